@@ -0,0 +1,29 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// Bearer token for admin endpoints; they are disabled when unset
+var adminToken = server_utils.GetEnv("ADMIN_TOKEN", "")
+
+// requireAdmin checks the Authorization header and writes the error response
+// itself, returning false when the request must stop.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if adminToken == "" {
+		writeError(w, http.StatusForbidden, "admin_disabled", "Admin endpoints are disabled")
+		return false
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "Missing or invalid admin token")
+		return false
+	}
+
+	return true
+}
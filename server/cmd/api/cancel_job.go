@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/devrayat000/video-process/models"
+	"github.com/devrayat000/video-process/pubsub"
+	"gorm.io/gorm"
+)
+
+// cancelJobHandler removes a job from the stream before any worker has read
+// it and marks its video failed. Jobs a worker already has answer 409.
+func cancelJobHandler(gormDB *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enableCors(&w)
+
+		if r.Method == "OPTIONS" {
+			return
+		}
+
+		if r.Method != "DELETE" {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+			return
+		}
+
+		streamID := r.PathValue("stream_id")
+		if !pubsub.ValidStreamID(streamID) {
+			writeError(w, http.StatusBadRequest, "invalid_stream_id", "Stream ID must look like <ms>-<seq>")
+			return
+		}
+		ctx := r.Context()
+
+		job, err := pubsub.CancelQueuedJob(ctx, streamID)
+		switch {
+		case errors.Is(err, pubsub.ErrJobNotFound):
+			writeError(w, http.StatusNotFound, "job_not_found", "Job not found in queue")
+			return
+		case errors.Is(err, pubsub.ErrJobDelivered):
+			writeError(w, http.StatusConflict, "job_in_progress", "Job has already been picked up by a worker and can no longer be removed from the queue")
+			return
+		case err != nil:
+			log.Printf("Failed to cancel job %s: %v", streamID, err)
+			writeError(w, http.StatusInternalServerError, "queue_error", "Failed to cancel job")
+			return
+		}
+
+		const reason = "cancelled before processing"
+		result := gormDB.WithContext(ctx).Model(&models.Video{}).
+			Where("id = ? AND status = ?", job.VideoID, models.StatusWaiting).
+			Updates(map[string]any{
+				"status":        models.StatusFailed,
+				"error_message": reason,
+			})
+		if result.Error != nil {
+			log.Printf("Failed to mark video %s cancelled: %v", job.VideoID, result.Error)
+			writeError(w, http.StatusInternalServerError, "database_error", "Job removed but video could not be updated")
+			return
+		}
+		// The video left waiting through another job for it, so this one
+		// cancelled nothing a client would see
+		if result.RowsAffected == 0 {
+			writeError(w, http.StatusConflict, "job_in_progress", "Video is no longer waiting and has already been picked up by a worker")
+			return
+		}
+
+		log.Printf(" [!] Cancelled queued job %s for video %s", streamID, job.VideoID)
+
+		if err := pubsub.PublishProgress(models.ProcessingProgress{
+			VideoID:   job.VideoID,
+			Status:    models.StatusFailed,
+			Error:     reason,
+			Timestamp: time.Now(),
+		}); err != nil {
+			log.Printf("Failed to publish cancel progress for %s: %v", job.VideoID, err)
+		}
+
+		event := models.VideoEvent{VideoID: job.VideoID, Status: models.StatusFailed, Timestamp: time.Now()}
+		if video, err := gorm.G[models.Video](gormDB).Where("id = ?", job.VideoID).First(ctx); err == nil {
+			event.Video = &video
+		}
+		if err := pubsub.PublishVideoEvent(ctx, event); err != nil {
+			log.Printf("Failed to publish cancel event for %s: %v", job.VideoID, err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":    "cancelled",
+			"id":        job.VideoID.String(),
+			"stream_id": streamID,
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+// queueRedis answers the stream commands CancelQueuedJob sends. lastDelivered
+// is the worker group's cursor; an empty entry means the ID isn't queued.
+func queueRedis(t *testing.T, id string, job models.VideoJob, queued bool, lastDelivered string) func([]string) any {
+	return func(cmd []string) any {
+		switch cmd[0] {
+		case "xrange":
+			if !queued {
+				return []any{}
+			}
+			data, err := json.Marshal(job)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return []any{[]any{id, []any{"video_id", job.VideoID.String(), "data", string(data)}}}
+		case "xinfo":
+			return []any{[]any{
+				"name", "video-workers", "consumers", int64(1), "pending", int64(0),
+				"last-delivered-id", lastDelivered, "entries-read", int64(1), "lag", int64(0),
+			}}
+		case "xdel":
+			return int64(1)
+		case "xpending":
+			return []any{}
+		}
+		return progressRedis(cmd)
+	}
+}
+
+func TestCancelJobHandler(t *testing.T) {
+	const streamID = "1700000000000-5"
+	job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/a.mp4"}
+
+	tests := []struct {
+		name          string
+		method        string
+		streamID      string
+		queued        bool
+		lastDelivered string
+		redisDown     bool
+		notWaiting    bool
+		wantCode      int
+		wantErr       string
+		wantCancelled bool
+	}{
+		{name: "pending job", method: http.MethodDelete, streamID: streamID, queued: true, lastDelivered: "1700000000000-4", wantCode: http.StatusOK, wantCancelled: true},
+		{name: "already processing", method: http.MethodDelete, streamID: streamID, queued: true, lastDelivered: streamID, wantCode: http.StatusConflict, wantErr: "job_in_progress"},
+		{name: "video no longer waiting", method: http.MethodDelete, streamID: streamID, queued: true, lastDelivered: "1700000000000-4", notWaiting: true, wantCode: http.StatusConflict, wantErr: "job_in_progress"},
+		{name: "not queued", method: http.MethodDelete, streamID: streamID, wantCode: http.StatusNotFound, wantErr: "job_not_found"},
+		{name: "malformed id", method: http.MethodDelete, streamID: "latest", wantCode: http.StatusBadRequest, wantErr: "invalid_stream_id"},
+		{name: "redis down", method: http.MethodDelete, streamID: streamID, redisDown: true, wantCode: http.StatusInternalServerError, wantErr: "queue_error"},
+		{name: "wrong method", method: http.MethodGet, streamID: streamID, wantCode: http.StatusMethodNotAllowed, wantErr: "method_not_allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := queueRedis(t, tt.streamID, job, tt.queued, tt.lastDelivered)
+			if tt.redisDown {
+				handler = func([]string) any { return errors.New("ERR connection refused") }
+			}
+			rdb := useRedis(t, handler)
+			gormDB, db := testdb.Open(t, func(q testdb.Query) testdb.Result {
+				if strings.HasPrefix(q.SQL, "SELECT") {
+					return testdb.Result{Columns: []string{"id", "status"}, Rows: [][]any{{job.VideoID.String(), "failed"}}}
+				}
+				if tt.notWaiting {
+					return testdb.Result{}
+				}
+				return testdb.Result{RowsAffected: 1}
+			})
+
+			req := httptest.NewRequest(tt.method, "/jobs/"+tt.streamID, nil)
+			req.SetPathValue("stream_id", tt.streamID)
+			rec := httptest.NewRecorder()
+			cancelJobHandler(gormDB).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantErr != "" {
+				if body := decodeError(t, rec); body.Code != tt.wantErr {
+					t.Errorf("error code = %q, want %q", body.Code, tt.wantErr)
+				}
+			}
+
+			updates := db.Matching(`UPDATE "videos"`)
+			if tt.notWaiting {
+				if len(rdb.Named("PUBLISH")) != 0 {
+					t.Error("cancel of a video that left waiting was published")
+				}
+				return
+			}
+			if !tt.wantCancelled {
+				if len(updates) != 0 || len(rdb.Named("XDEL")) != 0 {
+					t.Errorf("rejected cancel changed state: %d updates, XDEL %q", len(updates), rdb.Named("XDEL"))
+				}
+				return
+			}
+
+			if xdel := rdb.Named("XDEL"); len(xdel) != 1 || xdel[0][2] != streamID {
+				t.Errorf("XDEL calls = %q, want one for %s", xdel, streamID)
+			}
+			// Only a video still waiting is failed, never one a worker started
+			if len(updates) != 1 || !strings.Contains(updates[0].SQL, "status = $") ||
+				!slices.Contains(updates[0].Args, any(string(models.StatusFailed))) ||
+				!slices.Contains(updates[0].Args, any(string(models.StatusWaiting))) {
+				t.Errorf("video update = %v, want waiting -> failed", updates)
+			}
+			if len(rdb.Named("PUBLISH")) == 0 {
+				t.Error("cancellation was not published to progress subscribers")
+			}
+			var body map[string]string
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body["status"] != "cancelled" || body["id"] != job.VideoID.String() || body["stream_id"] != streamID {
+				t.Errorf("response = %v", body)
+			}
+		})
+	}
+}
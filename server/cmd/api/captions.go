@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/devrayat000/video-process/models"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"gorm.io/gorm"
+)
+
+// subtitleGroup is the GROUP-ID every caption track shares in the master
+const subtitleGroup = "subs"
+
+// languageTag loosely matches a BCP 47 tag such as "en", "pt-BR" or "zh-Hant"
+var languageTag = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// captionsHandler attaches a VTT or SRT caption file to a processed video.
+// The file is stored as subs/{lang}.vtt under the output prefix with a one-segment
+// playlist next to it, and the master playlist is rewritten to list every
+// caption track. Uploading the same language again replaces it.
+func captionsHandler(gormDB *gorm.DB, gcsClient *storage.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enableCors(&w)
+
+		if r.Method == "OPTIONS" {
+			return
+		}
+
+		if r.Method != "POST" {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+			return
+		}
+
+		videoID, ok := parseVideoID(w, r.PathValue("id"))
+		if !ok {
+			return
+		}
+		ctx := r.Context()
+
+		if !limitBody(w, r, maxCaptionBytes) {
+			return
+		}
+		if err := r.ParseMultipartForm(maxCaptionBytes); err != nil {
+			if isBodyTooLarge(err) {
+				writeBodyTooLarge(w, maxCaptionBytes)
+				return
+			}
+			writeError(w, http.StatusBadRequest, "invalid_form", "Expected a multipart form with file and language")
+			return
+		}
+
+		language := strings.ToLower(r.FormValue("language"))
+		if !languageTag.MatchString(language) {
+			writeError(w, http.StatusBadRequest, "invalid_language", "language must be a BCP 47 tag such as en or pt-br")
+			return
+		}
+
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "file_required", "Caption file is required")
+			return
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_form", "Failed to read caption file")
+			return
+		}
+
+		vtt, err := toWebVTT(data)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_caption", err.Error())
+			return
+		}
+
+		video, err := gorm.G[models.Video](gormDB).Where("id = ?", videoID).First(ctx)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "video_not_found", "Video not found")
+			return
+		}
+		if video.Status != models.StatusCompleted || video.MasterPlaylistKey == nil {
+			writeError(w, http.StatusConflict, "video_not_ready", "Captions can only be added to completed videos")
+			return
+		}
+
+		bucketName := outputBucketOf(video)
+		bucket := gcsClient.Bucket(bucketName)
+		subsDir := video.OutputPrefix() + "subs"
+		vttKey := fmt.Sprintf("%s/%s.vtt", subsDir, language)
+		playlistKey := fmt.Sprintf("%s/%s.m3u8", subsDir, language)
+
+		if err := writeObject(ctx, bucket, vttKey, "text/vtt", vtt); err != nil {
+			log.Printf("Failed to upload captions %s: %v", vttKey, err)
+			writeError(w, http.StatusInternalServerError, "storage_error", "Failed to store captions")
+			return
+		}
+		playlist := subtitlePlaylist(path.Base(vttKey), video.Duration)
+		if err := writeObject(ctx, bucket, playlistKey, "application/vnd.apple.mpegurl", []byte(playlist)); err != nil {
+			log.Printf("Failed to upload caption playlist %s: %v", playlistKey, err)
+			writeError(w, http.StatusInternalServerError, "storage_error", "Failed to store captions")
+			return
+		}
+
+		subtitle := models.VideoSubtitle{
+			VideoID:     video.ID,
+			Language:    language,
+			S3Key:       vttKey,
+			URL:         server_utils.PublicObjectURL(bucketName, vttKey),
+			PlaylistKey: playlistKey,
+		}
+		var subtitles []models.VideoSubtitle
+		err = gormDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if _, err := gorm.G[models.VideoSubtitle](tx).Where("video_id = ? AND language = ?", video.ID, language).Delete(ctx); err != nil {
+				return err
+			}
+			if err := gorm.G[models.VideoSubtitle](tx).Create(ctx, &subtitle); err != nil {
+				return err
+			}
+			subtitles, err = gorm.G[models.VideoSubtitle](tx).Where("video_id = ?", video.ID).Order("language").Find(ctx)
+			return err
+		})
+		if err != nil {
+			log.Printf("Failed to record captions for %s: %v", video.ID, err)
+			writeError(w, http.StatusInternalServerError, "database_error", "Failed to record captions")
+			return
+		}
+
+		if err := addSubtitlesToMaster(ctx, bucket, bucketName, *video.MasterPlaylistKey, subtitles); err != nil {
+			log.Printf("Failed to update master playlist for %s: %v", video.ID, err)
+			writeError(w, http.StatusInternalServerError, "storage_error", "Captions stored but the master playlist could not be updated")
+			return
+		}
+
+		log.Printf(" [√] Added %s captions to video %s", language, video.ID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&subtitle)
+	}
+}
+
+func writeObject(ctx context.Context, bucket *storage.BucketHandle, key, contentType string, data []byte) error {
+	writer := bucket.Object(key).NewWriter(ctx)
+	writer.ContentType = contentType
+	if _, err := writer.Write(data); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+var (
+	vttTiming = regexp.MustCompile(`^(\d{2,}:)?\d{2}:\d{2}\.\d{3}[ \t]+-->[ \t]+(\d{2,}:)?\d{2}:\d{2}\.\d{3}`)
+	srtTiming = regexp.MustCompile(`^(\d{1,2}):(\d{2}):(\d{2})[,.](\d{1,3})[ \t]*-->[ \t]*(\d{1,2}):(\d{2}):(\d{2})[,.](\d{1,3})`)
+)
+
+// toWebVTT validates a caption file and returns it as WebVTT. Files starting
+// with the WEBVTT signature are checked and kept as they are; anything else
+// is parsed as SRT and converted.
+func toWebVTT(data []byte) ([]byte, error) {
+	text := string(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	if isWebVTT(text) {
+		for line := range strings.SplitSeq(text, "\n") {
+			if vttTiming.MatchString(strings.TrimSpace(line)) {
+				return []byte(text), nil
+			}
+		}
+		return nil, errors.New("WebVTT file has no cues")
+	}
+
+	return srtToVTT(text)
+}
+
+func isWebVTT(text string) bool {
+	rest, ok := strings.CutPrefix(text, "WEBVTT")
+	return ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\n')
+}
+
+// srtToVTT converts SRT cues to WebVTT: comma decimal separators become
+// dots, hours are zero-padded and the numeric counters are dropped.
+func srtToVTT(text string) ([]byte, error) {
+	var out strings.Builder
+	out.WriteString("WEBVTT\n")
+
+	cues := 0
+	for block := range strings.SplitSeq(text, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		if len(lines) == 1 && strings.TrimSpace(lines[0]) == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(strings.TrimSpace(lines[0])); err == nil && len(lines) > 1 {
+			lines = lines[1:]
+		}
+
+		m := srtTiming.FindStringSubmatch(strings.TrimSpace(lines[0]))
+		if m == nil {
+			return nil, fmt.Errorf("not a WebVTT or SRT file: bad cue timing %q", strings.TrimSpace(lines[0]))
+		}
+
+		fmt.Fprintf(&out, "\n%s --> %s\n", vttTimestamp(m[1:5]), vttTimestamp(m[5:9]))
+		for _, line := range lines[1:] {
+			// "-->" would end the cue text early in WebVTT
+			out.WriteString(strings.ReplaceAll(line, "-->", "->"))
+			out.WriteString("\n")
+		}
+		cues++
+	}
+
+	if cues == 0 {
+		return nil, errors.New("caption file has no cues")
+	}
+	return []byte(out.String()), nil
+}
+
+// vttTimestamp formats SRT hour, minute, second and fraction fields as
+// HH:MM:SS.mmm. The fraction is read as a decimal, so ",5" means 500 ms.
+func vttTimestamp(parts []string) string {
+	h, _ := strconv.Atoi(parts[0])
+	m, _ := strconv.Atoi(parts[1])
+	s, _ := strconv.Atoi(parts[2])
+	ms, _ := strconv.Atoi(parts[3])
+	for i := len(parts[3]); i < 3; i++ {
+		ms *= 10
+	}
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", h, m, s, ms)
+}
+
+// subtitlePlaylist is a VOD media playlist with the whole caption file as
+// its only segment
+func subtitlePlaylist(vttName string, duration float64) string {
+	duration = math.Max(duration, 1)
+	return fmt.Sprintf("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXTINF:%.3f,\n%s\n#EXT-X-ENDLIST\n",
+		int(math.Ceil(duration)), duration, vttName)
+}
+
+var subtitlesAttribute = regexp.MustCompile(`,SUBTITLES="[^"]*"`)
+
+// withSubtitles rewrites a master playlist to list exactly the given caption
+// tracks. Earlier subtitle entries are dropped, one EXT-X-MEDIA line per
+// track goes ahead of the variants and each variant references the group.
+// uri maps a subtitle to the URI its media playlist is reachable at.
+func withSubtitles(master string, subtitles []models.VideoSubtitle, uri func(models.VideoSubtitle) string) string {
+	var media []string
+	for _, s := range subtitles {
+		media = append(media, fmt.Sprintf(`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="%s",NAME="%s",LANGUAGE="%s",DEFAULT=NO,AUTOSELECT=YES,URI="%s"`,
+			subtitleGroup, s.Language, s.Language, uri(s)))
+	}
+
+	var out []string
+	inserted := false
+	for line := range strings.SplitSeq(strings.TrimRight(master, "\n"), "\n") {
+		if strings.HasPrefix(line, "#EXT-X-MEDIA:") && strings.Contains(line, "TYPE=SUBTITLES") {
+			continue
+		}
+		if strings.HasPrefix(line, "#EXT-X-STREAM-INF:") {
+			if !inserted {
+				out = append(out, media...)
+				inserted = true
+			}
+			line = subtitlesAttribute.ReplaceAllString(line, "")
+			if len(subtitles) > 0 {
+				line += fmt.Sprintf(`,SUBTITLES="%s"`, subtitleGroup)
+			}
+		}
+		out = append(out, line)
+	}
+
+	return strings.Join(out, "\n") + "\n"
+}
+
+// addSubtitlesToMaster rewrites the stored master playlist with the video's
+// caption tracks. Subtitle URIs follow the variants: absolute when the worker
+// wrote absolute URIs (PLAYLIST_URIS=absolute), relative otherwise.
+func addSubtitlesToMaster(ctx context.Context, bucket *storage.BucketHandle, bucketName, masterKey string, subtitles []models.VideoSubtitle) error {
+	reader, err := bucket.Object(masterKey).NewReader(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open master playlist: %w", err)
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read master playlist: %w", err)
+	}
+	master := string(data)
+
+	absolute := false
+	for line := range strings.SplitSeq(master, "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			absolute = strings.Contains(line, "://")
+			break
+		}
+	}
+	masterDir := path.Dir(masterKey)
+	uri := func(s models.VideoSubtitle) string {
+		if absolute {
+			return server_utils.PublicObjectURL(bucketName, s.PlaylistKey)
+		}
+		return strings.TrimPrefix(s.PlaylistKey, masterDir+"/")
+	}
+
+	updated := withSubtitles(master, subtitles, uri)
+	return writeObject(ctx, bucket, masterKey, "application/vnd.apple.mpegurl", []byte(updated))
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestToWebVTT(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "webvtt kept",
+			in:   "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHello\n",
+			want: "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHello\n",
+		},
+		{
+			name: "webvtt with bom and crlf",
+			in:   "\xef\xbb\xbfWEBVTT\r\n\r\n00:01.000 --> 00:02.000\r\nHi\r\n",
+			want: "WEBVTT\n\n00:01.000 --> 00:02.000\nHi\n",
+		},
+		{
+			name:    "webvtt without cues",
+			in:      "WEBVTT\n\nNOTE nothing here\n",
+			wantErr: true,
+		},
+		{
+			name: "srt converted",
+			in:   "1\n00:00:01,000 --> 00:00:02,500\nHello\nworld\n\n2\n0:00:03,5 --> 0:00:04,25\nA --> B\n",
+			want: "WEBVTT\n\n00:00:01.000 --> 00:00:02.500\nHello\nworld\n\n00:00:03.500 --> 00:00:04.250\nA -> B\n",
+		},
+		{
+			name: "srt without counters",
+			in:   "00:00:01,000 --> 00:00:02,000\nHi\n",
+			want: "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHi\n",
+		},
+		{
+			name:    "not captions",
+			in:      "hello world\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			in:      "\n\n",
+			wantErr: true,
+		},
+		{
+			name:    "WEBVTT prefix without separator",
+			in:      "WEBVTTX\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := toWebVTT([]byte(tt.in))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("toWebVTT error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("toWebVTT = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithSubtitles(t *testing.T) {
+	master := "#EXTM3U\n#EXT-X-VERSION:6\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2800000\nstream_0/playlist.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=1400000\nstream_1/playlist.m3u8\n"
+	uri := func(s models.VideoSubtitle) string { return "subs/" + s.Language + ".m3u8" }
+	en := models.VideoSubtitle{Language: "en"}
+	fr := models.VideoSubtitle{Language: "fr"}
+
+	tests := []struct {
+		name      string
+		subtitles []models.VideoSubtitle
+		want      string
+	}{
+		{
+			name:      "adds a track",
+			subtitles: []models.VideoSubtitle{en},
+			want: "#EXTM3U\n#EXT-X-VERSION:6\n" +
+				`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="en",LANGUAGE="en",DEFAULT=NO,AUTOSELECT=YES,URI="subs/en.m3u8"` + "\n" +
+				`#EXT-X-STREAM-INF:BANDWIDTH=2800000,SUBTITLES="subs"` + "\nstream_0/playlist.m3u8\n" +
+				`#EXT-X-STREAM-INF:BANDWIDTH=1400000,SUBTITLES="subs"` + "\nstream_1/playlist.m3u8\n",
+		},
+		{
+			name:      "lists every track once",
+			subtitles: []models.VideoSubtitle{en, fr},
+			want: "#EXTM3U\n#EXT-X-VERSION:6\n" +
+				`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="en",LANGUAGE="en",DEFAULT=NO,AUTOSELECT=YES,URI="subs/en.m3u8"` + "\n" +
+				`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="fr",LANGUAGE="fr",DEFAULT=NO,AUTOSELECT=YES,URI="subs/fr.m3u8"` + "\n" +
+				`#EXT-X-STREAM-INF:BANDWIDTH=2800000,SUBTITLES="subs"` + "\nstream_0/playlist.m3u8\n" +
+				`#EXT-X-STREAM-INF:BANDWIDTH=1400000,SUBTITLES="subs"` + "\nstream_1/playlist.m3u8\n",
+		},
+		{
+			name: "no tracks leaves the master alone",
+			want: master,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := withSubtitles(master, tt.subtitles, uri)
+			if got != tt.want {
+				t.Errorf("withSubtitles =\n%s\nwant\n%s", got, tt.want)
+			}
+			// Rewriting an already captioned master must not duplicate anything
+			if again := withSubtitles(got, tt.subtitles, uri); again != got {
+				t.Errorf("second rewrite changed the master:\n%s", again)
+			}
+		})
+	}
+}
+
+func TestSubtitlePlaylist(t *testing.T) {
+	tests := []struct {
+		duration float64
+		want     string
+	}{
+		{12.5, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:13\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXTINF:12.500,\nen.vtt\n#EXT-X-ENDLIST\n"},
+		{0, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:1\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXTINF:1.000,\nen.vtt\n#EXT-X-ENDLIST\n"},
+	}
+	for _, tt := range tests {
+		if got := subtitlePlaylist("en.vtt", tt.duration); got != tt.want {
+			t.Errorf("subtitlePlaylist(%v) =\n%s\nwant\n%s", tt.duration, got, tt.want)
+		}
+	}
+}
+
+// captionForm builds a multipart caption upload
+func captionForm(t *testing.T, language, content string) (*bytes.Buffer, string) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if language != "" {
+		form.WriteField("language", language)
+	}
+	if content != "" {
+		part, err := form.CreateFormFile("file", "captions.srt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(content))
+	}
+	form.Close()
+	return &body, form.FormDataContentType()
+}
+
+func TestCaptionsHandler(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	id := uuid.New()
+	masterKey := id.String() + "/processed/master.m3u8"
+	const srt = "1\n00:00:01,000 --> 00:00:02,000\nHello\n"
+
+	tests := []struct {
+		name     string
+		status   models.VideoStatus
+		language string
+		content  string
+		wantCode int
+		wantErr  string
+	}{
+		{name: "srt attached", status: models.StatusCompleted, language: "pt-BR", content: srt, wantCode: http.StatusOK},
+		{name: "not completed", status: models.StatusProcessing, language: "en", content: srt, wantCode: http.StatusConflict, wantErr: "video_not_ready"},
+		{name: "bad language", status: models.StatusCompleted, language: "english!", content: srt, wantCode: http.StatusBadRequest, wantErr: "invalid_language"},
+		{name: "no file", status: models.StatusCompleted, language: "en", wantCode: http.StatusBadRequest, wantErr: "file_required"},
+		{name: "not captions", status: models.StatusCompleted, language: "en", content: "just some text", wantCode: http.StatusBadRequest, wantErr: "invalid_caption"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gcsClient, store := testgcs.Start(t)
+			store.Put("videos", masterKey, []byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=2800000\nstream_0/playlist.m3u8\n"))
+
+			gormDB, db := testdb.Open(t, func(q testdb.Query) testdb.Result {
+				switch {
+				case strings.HasPrefix(q.SQL, `SELECT * FROM "videos"`):
+					return testdb.Result{
+						Columns: []string{"id", "status", "master_playlist_key", "duration"},
+						Rows:    [][]any{{id.String(), string(tt.status), masterKey, 12.5}},
+					}
+				case strings.HasPrefix(q.SQL, `SELECT * FROM "video_subtitles"`):
+					return testdb.Result{
+						Columns: []string{"video_id", "language", "playlist_key"},
+						Rows:    [][]any{{id.String(), "pt-br", id.String() + "/processed/subs/pt-br.m3u8"}},
+					}
+				}
+				return testdb.Result{RowsAffected: 1}
+			})
+
+			body, contentType := captionForm(t, tt.language, tt.content)
+			req := httptest.NewRequest(http.MethodPost, "/videos/"+id.String()+"/captions", body)
+			req.Header.Set("Content-Type", contentType)
+			req.SetPathValue("id", id.String())
+			rec := httptest.NewRecorder()
+			captionsHandler(gormDB, gcsClient).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantErr != "" {
+				if body := decodeError(t, rec); body.Code != tt.wantErr {
+					t.Errorf("error code = %q, want %q", body.Code, tt.wantErr)
+				}
+				if len(db.Matching(`INSERT INTO "video_subtitles"`)) != 0 {
+					t.Error("rejected upload recorded a subtitle")
+				}
+				return
+			}
+
+			vtt, ok := store.Get("videos", id.String()+"/processed/subs/pt-br.vtt")
+			if !ok || vtt.ContentType != "text/vtt" || !strings.HasPrefix(string(vtt.Data), "WEBVTT\n\n00:00:01.000 --> 00:00:02.000") {
+				t.Errorf("stored captions = %+v, want converted WebVTT", vtt)
+			}
+			if _, ok := store.Get("videos", id.String()+"/processed/subs/pt-br.m3u8"); !ok {
+				t.Error("caption playlist was not stored")
+			}
+			if len(db.Matching(`DELETE FROM "video_subtitles"`)) != 1 || len(db.Matching(`INSERT INTO "video_subtitles"`)) != 1 {
+				t.Errorf("subtitle row not replaced: %v", db.Queries())
+			}
+			master, _ := store.Get("videos", masterKey)
+			if !strings.Contains(string(master.Data), `LANGUAGE="pt-br",DEFAULT=NO,AUTOSELECT=YES,URI="subs/pt-br.m3u8"`) ||
+				!strings.Contains(string(master.Data), `SUBTITLES="subs"`) {
+				t.Errorf("master playlist does not reference the captions:\n%s", master.Data)
+			}
+		})
+	}
+}
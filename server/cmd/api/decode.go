@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// decodeJSON reads a JSON request body into v, capped at MAX_JSON_BODY_BYTES.
+// Unknown fields, mistyped values and trailing data are rejected with a 400
+// naming the offending field, so client typos don't pass silently. It writes
+// the error response itself and returns false when the request must stop.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if !limitBody(w, r, maxJSONBodyBytes) {
+		return false
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		writeError(w, http.StatusBadRequest, "invalid_json", "Request body must contain a single JSON object")
+		return false
+	}
+	if err == nil {
+		return true
+	}
+
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
+	switch {
+	case isBodyTooLarge(err):
+		writeBodyTooLarge(w, maxJSONBodyBytes)
+	case errors.Is(err, io.EOF):
+		writeError(w, http.StatusBadRequest, "invalid_json", "Request body is empty")
+	case errors.As(err, &syntaxErr):
+		writeError(w, http.StatusBadRequest, "invalid_json", fmt.Sprintf("Invalid JSON at offset %d", syntaxErr.Offset))
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		writeFieldError(w, "invalid_field", typeErr.Field, fmt.Sprintf("Field %q must be %s", typeErr.Field, jsonTypeName(typeErr.Type.Kind().String())))
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no typed error for this one
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		writeFieldError(w, "unknown_field", field, fmt.Sprintf("Unknown field %q", field))
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON")
+	}
+	return false
+}
+
+// jsonTypeName describes a Go kind the way a JSON client would
+func jsonTypeName(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "a number"
+	case kind == "bool":
+		return "a boolean"
+	case kind == "string":
+		return "a string"
+	case kind == "slice", kind == "array":
+		return "an array"
+	default:
+		return "an object"
+	}
+}
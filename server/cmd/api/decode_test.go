@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/models"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantCode    int
+		wantErr     string
+		wantField   string
+		wantMessage string
+	}{
+		{name: "valid", body: `{"video_id": "00000000-0000-0000-0000-000000000001", "s3_path": "uploads/a.mp4"}`, wantCode: http.StatusOK},
+		{name: "unknown field", body: `{"s3_pth": "uploads/a.mp4"}`, wantCode: http.StatusBadRequest, wantErr: "unknown_field", wantField: "s3_pth", wantMessage: `Unknown field "s3_pth"`},
+		{name: "mistyped field", body: `{"s3_path": 42}`, wantCode: http.StatusBadRequest, wantErr: "invalid_field", wantField: "s3_path", wantMessage: `Field "s3_path" must be a string`},
+		{name: "syntax error", body: `{"s3_path": }`, wantCode: http.StatusBadRequest, wantErr: "invalid_json", wantMessage: "Invalid JSON at offset 13"},
+		{name: "empty body", body: ``, wantCode: http.StatusBadRequest, wantErr: "invalid_json", wantMessage: "Request body is empty"},
+		{name: "trailing data", body: `{"s3_path": "a"} {"s3_path": "b"}`, wantCode: http.StatusBadRequest, wantErr: "invalid_json", wantMessage: "Request body must contain a single JSON object"},
+		{name: "too large", body: `{"s3_path": "` + strings.Repeat("a", 128) + `"}`, wantCode: http.StatusRequestEntityTooLarge, wantErr: "body_too_large"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &maxJSONBodyBytes, 100)
+			req := httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+
+			var job models.VideoJob
+			ok := decodeJSON(rec, req, &job)
+			if ok != (tt.wantCode == http.StatusOK) {
+				t.Fatalf("decodeJSON = %v, response %d %s", ok, rec.Code, rec.Body)
+			}
+			if ok {
+				if job.S3Path != "uploads/a.mp4" {
+					t.Errorf("decoded job = %+v", job)
+				}
+				return
+			}
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			e := decodeError(t, rec)
+			if e.Code != tt.wantErr || e.Field != tt.wantField {
+				t.Errorf("error = %+v, want code %q naming field %q", e, tt.wantErr, tt.wantField)
+			}
+			if tt.wantMessage != "" && e.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", e.Message, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestJSONTypeName(t *testing.T) {
+	tests := map[string]string{
+		"int64":   "a number",
+		"uint8":   "a number",
+		"float64": "a number",
+		"bool":    "a boolean",
+		"string":  "a string",
+		"slice":   "an array",
+		"struct":  "an object",
+		"map":     "an object",
+	}
+	for kind, want := range tests {
+		if got := jsonTypeName(kind); got != want {
+			t.Errorf("jsonTypeName(%q) = %q, want %q", kind, got, want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/devrayat000/video-process/models"
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// Identical submissions within this window return the first video instead of
+// creating a new job; zero disables the check
+var dedupWindow = server_utils.GetEnvDuration("JOB_DEDUP_WINDOW", 60*time.Second)
+
+// jobFingerprint identifies what a job would produce: the same source,
+// ladder and destination always give the same fingerprint. The webhook is
+// part of it, so a resubmission with a different one isn't deduplicated
+// into a video that would never call it.
+func jobFingerprint(job models.VideoJob) string {
+	data, _ := json.Marshal(struct {
+		Bucket       string             `json:"b"`
+		Path         string             `json:"p"`
+		Renditions   []models.Rendition `json:"r"`
+		Profile      string             `json:"pr,omitempty"`
+		OutputBucket string             `json:"o"`
+		Start        float64            `json:"s"`
+		End          float64            `json:"e"`
+		FrameRate    float64            `json:"f,omitempty"`
+		Webhook      *models.Webhook    `json:"w,omitempty"`
+	}{job.Bucket, job.S3Path, job.Renditions, job.Profile, job.OutputBucket, job.StartSeconds, job.EndSeconds, sequenceFrameRate(job), job.Webhook})
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestJobFingerprint(t *testing.T) {
+	base := models.VideoJob{
+		VideoID:      uuid.New(),
+		OriginalName: "clip.mp4",
+		Bucket:       "uploads",
+		S3Path:       "raw/clip.mp4",
+		Renditions:   []models.Rendition{{Height: 720, Bitrate: 2800}},
+	}
+	want := jobFingerprint(base)
+
+	tests := []struct {
+		name   string
+		modify func(*models.VideoJob)
+		same   bool
+	}{
+		{"new video id", func(j *models.VideoJob) { j.VideoID = uuid.New() }, true},
+		{"new name", func(j *models.VideoJob) { j.OriginalName = "other.mp4" }, true},
+		{"other source bucket", func(j *models.VideoJob) { j.Bucket = "archive" }, false},
+		{"other source path", func(j *models.VideoJob) { j.S3Path = "raw/other.mp4" }, false},
+		{"other renditions", func(j *models.VideoJob) { j.Renditions = []models.Rendition{{Height: 480, Bitrate: 1400}} }, false},
+		{"other output bucket", func(j *models.VideoJob) { j.OutputBucket = "tenant-a" }, false},
+		{"trimmed", func(j *models.VideoJob) { j.StartSeconds, j.EndSeconds = 30, 90 }, false},
+		{"webhook", func(j *models.VideoJob) { j.Webhook = &models.Webhook{URL: "https://hooks.example.com/v"} }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := base
+			tt.modify(&job)
+			if got := jobFingerprint(job); (got == want) != tt.same {
+				t.Errorf("fingerprint changed = %v, want %v", got != want, !tt.same)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/devrayat000/video-process/models"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"google.golang.org/api/iterator"
+	"gorm.io/gorm"
+)
+
+// downloadHandler streams every object under the output prefix as a ZIP archive.
+// Objects are copied straight from GCS into the response, so large outputs
+// never sit in memory.
+func downloadHandler(gormDB *gorm.DB, gcsClient *storage.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enableCors(&w)
+
+		if r.Method == "OPTIONS" {
+			return
+		}
+
+		if r.Method != "GET" {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+			return
+		}
+
+		videoID, ok := parseVideoID(w, r.PathValue("id"))
+		if !ok {
+			return
+		}
+		ctx := r.Context()
+
+		video, err := gorm.G[models.Video](gormDB).Where("id = ?", videoID).First(ctx)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "video_not_found", "Video not found")
+			return
+		}
+
+		if video.Status != models.StatusCompleted {
+			writeError(w, http.StatusConflict, "video_not_ready", "Video has not finished processing")
+			return
+		}
+
+		bucket := gcsClient.Bucket(outputBucketOf(video))
+		prefix := video.OutputPrefix()
+		it := bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+
+		// Look at the first object before committing to a 200 response
+		first, err := it.Next()
+		if err == iterator.Done {
+			writeError(w, http.StatusNotFound, "output_not_found", "No processed output found")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to list output for %s: %v", video.ID, err)
+			writeError(w, http.StatusInternalServerError, "storage_error", "Failed to list output")
+			return
+		}
+
+		clearWriteDeadline(w)
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", server_utils.AttachmentDisposition(
+			server_utils.DownloadName(video.OriginalName, ".zip"), video.ID.String()+".zip"))
+
+		archive := zip.NewWriter(w)
+		for attrs := first; ; {
+			// Keep the layout players expect: {id}/master.m3u8, {id}/stream_0/...
+			name := fmt.Sprintf("%s/%s", video.ID, strings.TrimPrefix(attrs.Name, prefix))
+			if err := addObjectToZip(ctx, archive, bucket, attrs, name); err != nil {
+				// Headers are already sent; all we can do is cut the archive short
+				log.Printf("Failed to archive %s: %v", attrs.Name, err)
+				return
+			}
+
+			attrs, err = it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				log.Printf("Failed to list output for %s: %v", video.ID, err)
+				return
+			}
+		}
+
+		if err := archive.Close(); err != nil {
+			log.Printf("Failed to finish archive for %s: %v", video.ID, err)
+		}
+	}
+}
+
+// addObjectToZip copies one storage object into the archive. Media segments
+// are already compressed, so only text files are deflated.
+func addObjectToZip(ctx context.Context, archive *zip.Writer, bucket *storage.BucketHandle, attrs *storage.ObjectAttrs, name string) error {
+	method := zip.Store
+	if strings.HasSuffix(name, ".m3u8") || strings.HasSuffix(name, ".vtt") || strings.HasSuffix(name, ".json") {
+		method = zip.Deflate
+	}
+
+	entry, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   method,
+		Modified: attrs.Updated,
+	})
+	if err != nil {
+		return err
+	}
+
+	reader, err := bucket.Object(attrs.Name).NewReader(ctx)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	_, err = io.Copy(entry, reader)
+	return err
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestDownloadHandler(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	id := uuid.New()
+	prefix := id.String() + "/processed/"
+	output := map[string]string{
+		"master.m3u8":               "#EXTM3U\n",
+		"stream_0/playlist.m3u8":    "#EXTM3U\n#EXTINF:6,\nsegment_000.ts\n",
+		"stream_0/segment_000.ts":   "segment data",
+		"subtitles/en/captions.vtt": "WEBVTT\n",
+	}
+
+	tests := []struct {
+		name     string
+		status   models.VideoStatus
+		noOutput bool
+		wantCode int
+	}{
+		{name: "completed video", status: models.StatusCompleted, wantCode: http.StatusOK},
+		{name: "processing video", status: models.StatusProcessing, wantCode: http.StatusConflict},
+		{name: "completed without output", status: models.StatusCompleted, noOutput: true, wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gcsClient, store := testgcs.Start(t)
+			if !tt.noOutput {
+				for name, data := range output {
+					store.Put("videos", prefix+name, []byte(data))
+				}
+			}
+			// Outside the processed prefix, so never archived
+			store.Put("videos", "uploads/"+id.String()+".mp4", []byte("source"))
+
+			gormDB, _ := testdb.Open(t, func(q testdb.Query) testdb.Result {
+				return testdb.Result{
+					Columns: []string{"id", "status", "original_name"},
+					Rows:    [][]any{{id.String(), string(tt.status), "Café trip.mov"}},
+				}
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/videos/"+id.String()+"/download", nil)
+			req.SetPathValue("id", id.String())
+			rec := httptest.NewRecorder()
+			downloadHandler(gormDB, gcsClient).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			if ct := rec.Header().Get("Content-Type"); ct != "application/zip" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			want := `attachment; filename="Caf_ trip.zip"; filename*=UTF-8''Caf%C3%A9%20trip.zip`
+			if cd := rec.Header().Get("Content-Disposition"); cd != want {
+				t.Errorf("Content-Disposition = %q, want %q", cd, want)
+			}
+
+			archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(archive.File) != len(output) {
+				t.Errorf("archive has %d entries, want %d", len(archive.File), len(output))
+			}
+			for _, file := range archive.File {
+				rel, ok := strings.CutPrefix(file.Name, id.String()+"/")
+				want, known := output[rel]
+				if !ok || !known {
+					t.Errorf("unexpected entry %q", file.Name)
+					continue
+				}
+
+				wantMethod := zip.Deflate
+				if strings.HasSuffix(rel, ".ts") {
+					wantMethod = zip.Store
+				}
+				if file.Method != wantMethod {
+					t.Errorf("%s method = %d, want %d", rel, file.Method, wantMethod)
+				}
+
+				rc, err := file.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := io.ReadAll(rc)
+				rc.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != want {
+					t.Errorf("%s = %q, want %q", rel, data, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadHandlerUnknownVideo(t *testing.T) {
+	gormDB, _ := testdb.Open(t, nil)
+	id := uuid.NewString()
+	req := httptest.NewRequest(http.MethodGet, "/videos/"+id+"/download", nil)
+	req.SetPathValue("id", id)
+	rec := httptest.NewRecorder()
+	downloadHandler(gormDB, nil).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+// decodeError checks the response is a JSON error envelope and returns it
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) errorBody {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error body %q is not JSON: %v", rec.Body, err)
+	}
+	if len(body) != 1 || body["error"] == nil {
+		t.Fatalf("error body = %s, want only an \"error\" object", rec.Body)
+	}
+	var e errorBody
+	if err := json.Unmarshal(body["error"], &e); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestWriteError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeError(rec, http.StatusNotFound, "video_not_found", "Video not found")
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != `{"error":{"code":"video_not_found","message":"Video not found"}}` {
+		t.Errorf("body = %s", got)
+	}
+	if rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Error("missing X-Content-Type-Options: nosniff")
+	}
+}
+
+func TestHandlerErrorCodes(t *testing.T) {
+	setVar(t, &maxJSONBodyBytes, 16)
+	id := uuid.NewString()
+	processing := func(q testdb.Query) testdb.Result {
+		return testdb.Result{
+			Columns: []string{"id", "status", "s3_path"},
+			Rows:    [][]any{{id, string(models.StatusProcessing), "gs://videos/a.mp4"}},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		handler  func(*testing.T) http.Handler
+		method   string
+		path     string
+		body     string
+		wantCode int
+		wantErr  string
+	}{
+		{
+			name:     "unknown video download",
+			handler:  func(t *testing.T) http.Handler { db, _ := testdb.Open(t, nil); return downloadHandler(db, nil) },
+			method:   http.MethodGet,
+			path:     "/videos/" + id + "/download",
+			wantCode: http.StatusNotFound,
+			wantErr:  "video_not_found",
+		},
+		{
+			name:     "download while processing",
+			handler:  func(t *testing.T) http.Handler { db, _ := testdb.Open(t, processing); return downloadHandler(db, nil) },
+			method:   http.MethodGet,
+			path:     "/videos/" + id + "/download",
+			wantCode: http.StatusConflict,
+			wantErr:  "video_not_ready",
+		},
+		{
+			name:     "reprocess while processing",
+			handler:  func(t *testing.T) http.Handler { db, _ := testdb.Open(t, processing); return reprocessHandler(db, nil) },
+			method:   http.MethodPost,
+			path:     "/videos/" + id + "/reprocess",
+			wantCode: http.StatusConflict,
+			wantErr:  "video_processing",
+		},
+		{
+			name:     "wrong method",
+			handler:  func(t *testing.T) http.Handler { db, _ := testdb.Open(t, nil); return manifestHandler(db, nil) },
+			method:   http.MethodDelete,
+			path:     "/videos/" + id + "/manifest",
+			wantCode: http.StatusMethodNotAllowed,
+			wantErr:  "method_not_allowed",
+		},
+		{
+			name:     "malformed status ids",
+			handler:  func(t *testing.T) http.Handler { db, _ := testdb.Open(t, nil); return bulkStatusHandler(db) },
+			method:   http.MethodGet,
+			path:     "/videos/status?ids=not-a-uuid",
+			wantCode: http.StatusBadRequest,
+			wantErr:  "invalid_ids",
+		},
+		{
+			name:     "oversized body",
+			handler:  func(t *testing.T) http.Handler { return limitedEcho(&maxJSONBodyBytes) },
+			method:   http.MethodPost,
+			path:     "/",
+			body:     strings.Repeat("x", 17),
+			wantCode: http.StatusRequestEntityTooLarge,
+			wantErr:  "body_too_large",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.SetPathValue("id", id)
+			rec := httptest.NewRecorder()
+			tt.handler(t).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			e := decodeError(t, rec)
+			if e.Code != tt.wantErr || e.Message == "" {
+				t.Errorf("error = %+v, want code %q with a message", e, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/devrayat000/video-process/models"
+	"github.com/devrayat000/video-process/pubsub"
+	"gorm.io/gorm"
+)
+
+// forceStatusHandler lets an operator move a video to a terminal state, e.g.
+// when the output is fine in storage but the worker's update was lost. It
+// publishes a matching progress event so open SSE streams close.
+func forceStatusHandler(gormDB *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enableCors(&w)
+
+		if r.Method == "OPTIONS" {
+			return
+		}
+
+		if r.Method != "POST" {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+			return
+		}
+
+		if !requireAdmin(w, r) {
+			return
+		}
+
+		if !limitBody(w, r, maxJSONBodyBytes) {
+			return
+		}
+
+		var req struct {
+			Status models.VideoStatus `json:"status"`
+			Reason string             `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			if isBodyTooLarge(err) {
+				writeBodyTooLarge(w, maxJSONBodyBytes)
+				return
+			}
+			writeError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON")
+			return
+		}
+
+		if req.Status != models.StatusCompleted && req.Status != models.StatusFailed {
+			writeError(w, http.StatusBadRequest, "invalid_status", "Status must be completed or failed")
+			return
+		}
+		if req.Reason == "" {
+			writeError(w, http.StatusBadRequest, "reason_required", "Reason is required")
+			return
+		}
+
+		ctx := r.Context()
+		videoID, ok := parseVideoID(w, r.PathValue("id"))
+		if !ok {
+			return
+		}
+
+		video, err := gorm.G[models.Video](gormDB).Where("id = ?", videoID).First(ctx)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "video_not_found", "Video not found")
+			return
+		}
+
+		updates := map[string]any{"status": req.Status}
+		progress := models.ProcessingProgress{
+			VideoID:   video.ID,
+			Status:    req.Status,
+			Timestamp: time.Now(),
+		}
+		if req.Status == models.StatusCompleted {
+			updates["completed_at"] = time.Now()
+			updates["error_message"] = nil
+			updates["failure_category"] = nil
+		} else {
+			updates["error_message"] = req.Reason
+			progress.Error = req.Reason
+		}
+
+		if err := gormDB.WithContext(ctx).Model(&models.Video{}).Where("id = ?", video.ID).Updates(updates).Error; err != nil {
+			log.Printf("Failed to force status of %s: %v", video.ID, err)
+			writeError(w, http.StatusInternalServerError, "database_error", "Failed to update video")
+			return
+		}
+
+		log.Printf(" [!] Forced video %s from %s to %s: %s", video.ID, video.Status, req.Status, req.Reason)
+
+		if err := pubsub.PublishProgress(progress); err != nil {
+			log.Printf("Failed to publish forced progress for %s: %v", video.ID, err)
+		}
+
+		event := models.VideoEvent{VideoID: video.ID, Status: req.Status, Timestamp: time.Now()}
+		if updated, err := gorm.G[models.Video](gormDB).Where("id = ?", video.ID).First(ctx); err == nil {
+			event.Video = &updated
+		}
+		if err := pubsub.PublishVideoEvent(ctx, event); err != nil {
+			log.Printf("Failed to publish forced event for %s: %v", video.ID, err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"id":              video.ID.String(),
+			"previous_status": string(video.Status),
+			"status":          string(req.Status),
+		})
+	}
+}
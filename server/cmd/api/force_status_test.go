@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+// progressRedis answers the commands PublishProgress and PublishVideoEvent
+// send
+func progressRedis(cmd []string) any {
+	switch cmd[0] {
+	case "publish":
+		return int64(1)
+	case "xadd":
+		return "1-0"
+	}
+	return nil
+}
+
+func TestForceStatusHandler(t *testing.T) {
+	setVar(t, &adminToken, "s3cret")
+	id := uuid.New()
+
+	tests := []struct {
+		name       string
+		token      string
+		body       string
+		wantCode   int
+		wantErr    string
+		wantStatus models.VideoStatus
+	}{
+		{name: "force completed", token: "s3cret", body: `{"status":"completed","reason":"output verified in bucket"}`, wantCode: http.StatusOK, wantStatus: models.StatusCompleted},
+		{name: "force failed", token: "s3cret", body: `{"status":"failed","reason":"worker lost the ack"}`, wantCode: http.StatusOK, wantStatus: models.StatusFailed},
+		{name: "missing token", body: `{"status":"completed","reason":"x"}`, wantCode: http.StatusUnauthorized, wantErr: "unauthorized"},
+		{name: "wrong token", token: "guess", body: `{"status":"completed","reason":"x"}`, wantCode: http.StatusUnauthorized, wantErr: "unauthorized"},
+		{name: "non-terminal target", token: "s3cret", body: `{"status":"processing","reason":"x"}`, wantCode: http.StatusBadRequest, wantErr: "invalid_status"},
+		{name: "waiting target", token: "s3cret", body: `{"status":"waiting","reason":"x"}`, wantCode: http.StatusBadRequest, wantErr: "invalid_status"},
+		{name: "missing reason", token: "s3cret", body: `{"status":"failed"}`, wantCode: http.StatusBadRequest, wantErr: "reason_required"},
+		{name: "malformed body", token: "s3cret", body: `{`, wantCode: http.StatusBadRequest, wantErr: "invalid_json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rdb := useRedis(t, progressRedis)
+			gormDB, db := testdb.Open(t, func(q testdb.Query) testdb.Result {
+				if strings.HasPrefix(q.SQL, "SELECT") {
+					return testdb.Result{
+						Columns: []string{"id", "status"},
+						Rows:    [][]any{{id.String(), string(models.StatusProcessing)}},
+					}
+				}
+				return testdb.Result{RowsAffected: 1}
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/videos/"+id.String()+"/force-status", strings.NewReader(tt.body))
+			req.SetPathValue("id", id.String())
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			forceStatusHandler(gormDB).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			updates := db.Matching(`UPDATE "videos"`)
+			publishes := rdb.Named("PUBLISH")
+			events := rdb.Named("XADD")
+
+			if tt.wantErr != "" {
+				if e := decodeError(t, rec); e.Code != tt.wantErr {
+					t.Errorf("error code = %q, want %q", e.Code, tt.wantErr)
+				}
+				if len(updates) != 0 || len(publishes) != 0 || len(events) != 0 {
+					t.Errorf("rejected request changed state: %d updates, %d publishes, %d events", len(updates), len(publishes), len(events))
+				}
+				return
+			}
+
+			var resp map[string]string
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp["previous_status"] != string(models.StatusProcessing) || resp["status"] != string(tt.wantStatus) {
+				t.Errorf("response = %v", resp)
+			}
+
+			if len(updates) != 1 || !strings.Contains(updates[0].SQL, `"status"=`) {
+				t.Fatalf("updates = %v", updates)
+			}
+			if args := updates[0].Args; !containsArg(args, string(tt.wantStatus)) {
+				t.Errorf("update args %v do not set status %s", args, tt.wantStatus)
+			}
+			if tt.wantStatus == models.StatusCompleted && (!strings.Contains(updates[0].SQL, `"error_message"=$2`) || updates[0].Args[1] != nil) {
+				t.Errorf("completing did not clear the error: %s %v", updates[0].SQL, updates[0].Args)
+			}
+
+			// Both the per-video and the global channel see a terminal event
+			if len(publishes) != 2 {
+				t.Fatalf("PUBLISH calls = %v, want 2", publishes)
+			}
+			var event models.ProcessingProgress
+			if err := json.Unmarshal([]byte(publishes[0][2]), &event); err != nil {
+				t.Fatal(err)
+			}
+			if event.VideoID != id || event.Status != tt.wantStatus {
+				t.Errorf("event = %+v, want %s for %s", event, tt.wantStatus, id)
+			}
+			if tt.wantStatus == models.StatusFailed && event.Error != "worker lost the ack" {
+				t.Errorf("event error = %q, want the reason", event.Error)
+			}
+
+			// Services reading the events stream see the override too
+			if len(events) != 1 || events[0][1] != "video:events" || !slices.Contains(events[0], string(tt.wantStatus)) {
+				t.Errorf("XADD calls = %q, want one %s event", events, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestForceStatusHandlerDisabledWithoutToken(t *testing.T) {
+	setVar(t, &adminToken, "")
+	gormDB, db := testdb.Open(t, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/videos/x/force-status", strings.NewReader(`{"status":"completed","reason":"x"}`))
+	req.Header.Set("Authorization", "Bearer ")
+	rec := httptest.NewRecorder()
+	forceStatusHandler(gormDB).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden || decodeError(t, rec).Code != "admin_disabled" {
+		t.Errorf("status = %d %s, want 403 admin_disabled", rec.Code, rec.Body)
+	}
+	if len(db.Queries()) != 0 {
+		t.Error("disabled endpoint touched the database")
+	}
+}
+
+func containsArg(args []any, want string) bool {
+	for _, arg := range args {
+		if s, ok := arg.(string); ok && s == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// compressibleTypes are the text responses worth gzipping. Media segments
+// are already compressed and are never gzipped.
+var compressibleTypes = map[string]bool{
+	"application/vnd.apple.mpegurl": true,
+	"application/x-mpegurl":         true,
+	"audio/mpegurl":                 true,
+	"text/vtt":                      true,
+	"application/json":              true,
+}
+
+func isCompressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return compressibleTypes[mediaType] || strings.HasPrefix(mediaType, "text/")
+}
+
+// acceptsGzip reports whether the client listed gzip in Accept-Encoding
+// without refusing it via q=0.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// writeBody copies body to the response, gzipping text types for clients that
+// accept it. size is the uncompressed length, or -1 when unknown; it is only
+// sent as Content-Length for uncompressed responses.
+func writeBody(w http.ResponseWriter, r *http.Request, contentType string, size int64, body io.Reader) {
+	w.Header().Set("Content-Type", contentType)
+
+	if !isCompressibleType(contentType) {
+		if size >= 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+		io.Copy(w, body)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r) {
+		if size >= 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+		io.Copy(w, body)
+		return
+	}
+
+	// Length is unknown up front, so the response is chunked
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+
+	gz := gzip.NewWriter(w)
+	if _, err := io.Copy(gz, body); err != nil {
+		log.Printf("Failed to write gzip response: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		log.Printf("Failed to finish gzip response: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=0.8, br", true},
+		{"GZIP", true},
+		{"br, deflate", false},
+		{"gzip;q=0", false},
+		{"gzip; q=0.0", false},
+		{"x-gzip", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", tt.header)
+			if got := acceptsGzip(r); got != tt.want {
+				t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsCompressibleType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"application/vnd.apple.mpegurl", true},
+		{"application/x-mpegURL", true},
+		{"text/vtt; charset=utf-8", true},
+		{"application/json", true},
+		{"text/plain", true},
+		{"video/mp2t", false},
+		{"video/iso.segment", false},
+		{"video/mp4", false},
+		{"image/jpeg", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isCompressibleType(tt.contentType); got != tt.want {
+			t.Errorf("isCompressibleType(%q) = %v, want %v", tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestWriteBody(t *testing.T) {
+	playlist := "#EXTM3U\n" + strings.Repeat("#EXTINF:6.0,\nsegment_000.ts\n", 50)
+
+	tests := []struct {
+		name        string
+		contentType string
+		accept      string
+		wantGzip    bool
+	}{
+		{"playlist for gzip client", "application/vnd.apple.mpegurl", "gzip, deflate", true},
+		{"playlist for plain client", "application/vnd.apple.mpegurl", "", false},
+		{"subtitles for gzip client", "text/vtt", "gzip", true},
+		{"segment never gzipped", "video/mp2t", "gzip", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept-Encoding", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			writeBody(rec, req, tt.contentType, int64(len(playlist)), strings.NewReader(playlist))
+
+			if ct := rec.Header().Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", ct, tt.contentType)
+			}
+			body := rec.Body.String()
+			if tt.wantGzip {
+				if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Content-Length") != "" {
+					t.Errorf("headers = %v, want gzip without Content-Length", rec.Header())
+				}
+				if len(body) >= len(playlist) {
+					t.Errorf("gzipped body is %d bytes, not smaller than %d", len(body), len(playlist))
+				}
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, _ := io.ReadAll(zr)
+				body = string(data)
+			} else {
+				if rec.Header().Get("Content-Encoding") != "" {
+					t.Errorf("Content-Encoding = %q, want none", rec.Header().Get("Content-Encoding"))
+				}
+				if cl := rec.Header().Get("Content-Length"); cl != strconv.Itoa(len(playlist)) {
+					t.Errorf("Content-Length = %q, want %d", cl, len(playlist))
+				}
+			}
+			if body != playlist {
+				t.Errorf("body does not round-trip: %q", body)
+			}
+			if vary := rec.Header().Get("Vary"); (vary == "Accept-Encoding") != isCompressibleType(tt.contentType) {
+				t.Errorf("Vary = %q", vary)
+			}
+		})
+	}
+}
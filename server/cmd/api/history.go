@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/devrayat000/video-process/pubsub"
+)
+
+// progressHistoryHandler returns the retained progress events for a video,
+// newest first. The list is empty unless PROGRESS_HISTORY_SIZE is set.
+func progressHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	enableCors(&w)
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	videoID, ok := parseVideoID(w, r.PathValue("id"))
+	if !ok {
+		return
+	}
+
+	history, err := pubsub.GetProgressHistory(videoID.String())
+	if err != nil {
+		log.Printf("Failed to read progress history: %v", err)
+		writeError(w, http.StatusInternalServerError, "progress_unavailable", "Failed to fetch progress history")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
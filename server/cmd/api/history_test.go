@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestProgressHistoryHandler(t *testing.T) {
+	id := uuid.New()
+	event, _ := json.Marshal(models.ProcessingProgress{VideoID: id, Status: models.StatusProcessing, ProcessedFrames: 12})
+
+	tests := []struct {
+		name     string
+		reply    any
+		wantCode int
+		want     int
+	}{
+		{"retained events", []string{string(event), string(event)}, http.StatusOK, 2},
+		{"no history", []string{}, http.StatusOK, 0},
+		{"redis error", errors.New("ERR connection lost"), http.StatusInternalServerError, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useRedis(t, func(cmd []string) any { return tt.reply })
+
+			req := httptest.NewRequest(http.MethodGet, "/progress/"+id.String()+"/history", nil)
+			req.SetPathValue("id", id.String())
+			rec := httptest.NewRecorder()
+			progressHistoryHandler(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var history []models.ProcessingProgress
+			if err := json.NewDecoder(rec.Body).Decode(&history); err != nil {
+				t.Fatal(err)
+			}
+			if len(history) != tt.want {
+				t.Errorf("got %d events, want %d", len(history), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// parseVideoID validates a video id taken from the URL. Malformed ids get a
+// 400 here instead of reaching Postgres as an invalid uuid literal.
+func parseVideoID(w http.ResponseWriter, raw string) (uuid.UUID, bool) {
+	if raw == "" {
+		writeError(w, http.StatusBadRequest, "video_id_required", "Video ID required")
+		return uuid.Nil, false
+	}
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_video_id", "Video ID must be a UUID")
+		return uuid.Nil, false
+	}
+	return id, true
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/google/uuid"
+)
+
+func TestParseVideoID(t *testing.T) {
+	id := uuid.New()
+	tests := []struct {
+		name    string
+		raw     string
+		want    uuid.UUID
+		wantErr string
+	}{
+		{"canonical", id.String(), id, ""},
+		{"upper case", strings.ToUpper(id.String()), id, ""},
+		{"empty", "", uuid.Nil, "video_id_required"},
+		{"not a uuid", "abc", uuid.Nil, "invalid_video_id"},
+		{"sql fragment", "1' OR '1'='1", uuid.Nil, "invalid_video_id"},
+		{"truncated", id.String()[:35], uuid.Nil, "invalid_video_id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			got, ok := parseVideoID(rec, tt.raw)
+			if ok != (tt.wantErr == "") || got != tt.want {
+				t.Fatalf("parseVideoID(%q) = %v, %v, want %v", tt.raw, got, ok, tt.want)
+			}
+			if tt.wantErr == "" {
+				return
+			}
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", rec.Code)
+			}
+			if e := decodeError(t, rec); e.Code != tt.wantErr {
+				t.Errorf("error code = %q, want %q", e.Code, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandlersRejectMalformedIDs(t *testing.T) {
+	setVar(t, &adminToken, "s3cret")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"download", http.MethodGet, "/videos/abc/download", ""},
+		{"manifest", http.MethodGet, "/videos/abc/manifest", ""},
+		{"objects", http.MethodGet, "/videos/abc/objects", ""},
+		{"playlist", http.MethodGet, "/videos/abc/playlist/master.m3u8", ""},
+		{"reprocess", http.MethodPost, "/videos/abc/reprocess", ""},
+		{"verify", http.MethodGet, "/videos/abc/verify", ""},
+		{"force status", http.MethodPost, "/videos/abc/force-status", `{"status":"failed","reason":"stuck"}`},
+		{"progress history", http.MethodGet, "/progress/abc/history", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDB, db := testdb.Open(t, nil)
+			handlers := map[string]http.Handler{
+				"download":         downloadHandler(gormDB, nil),
+				"manifest":         manifestHandler(gormDB, nil),
+				"objects":          objectsHandler(gormDB, nil),
+				"playlist":         playlistHandler(gormDB, nil),
+				"reprocess":        reprocessHandler(gormDB, nil),
+				"verify":           verifyHandler(gormDB, nil),
+				"force status":     forceStatusHandler(gormDB),
+				"progress history": http.HandlerFunc(progressHistoryHandler),
+			}
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.SetPathValue("id", "abc")
+			req.SetPathValue("path", "master.m3u8")
+			req.Header.Set("Authorization", "Bearer s3cret")
+			rec := httptest.NewRecorder()
+			handlers[tt.name].ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400: %s", rec.Code, rec.Body)
+			}
+			if e := decodeError(t, rec); e.Code != "invalid_video_id" {
+				t.Errorf("error code = %q, want invalid_video_id", e.Code)
+			}
+			// The id never reaches Postgres as an invalid uuid literal
+			if queries := db.Queries(); len(queries) != 0 {
+				t.Errorf("malformed id was queried: %v", queries)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/devrayat000/video-process/models"
+	"github.com/devrayat000/video-process/outbox"
+	"github.com/devrayat000/video-process/pubsub"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"gorm.io/gorm"
+)
+
+// submitJob validates a decoded job, creates its video row and queues it.
+// Shared by POST /jobs and POST /jobs/remote.
+func submitJob(w http.ResponseWriter, r *http.Request, gormDB *gorm.DB, job models.VideoJob) {
+	// A new video starts at the unversioned prefix; only reprocessing moves
+	// it on, so a client can't point a job at another output version
+	job.OutputVersion = 0
+
+	if job.StartSeconds < 0 || job.EndSeconds < 0 || (job.EndSeconds > 0 && job.EndSeconds <= job.StartSeconds) {
+		writeError(w, http.StatusBadRequest, "invalid_trim", "end_seconds must be after start_seconds")
+		return
+	}
+
+	if job.ImageSequence != nil {
+		if err := normalizeImageSequence(job.S3Path, job.ImageSequence); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_image_sequence", err.Error())
+			return
+		}
+	}
+
+	for i := range job.Renditions {
+		if err := normalizeRendition(&job.Renditions[i]); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_rendition", err.Error())
+			return
+		}
+	}
+
+	if job.Webhook != nil {
+		if err := job.Webhook.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_webhook", err.Error())
+			return
+		}
+	}
+
+	// The worker signs reads of, and may delete, sources in our storage, so
+	// their bucket must be allowed. Sources outside it are fetched by the
+	// worker and get the same SSRF guard as remote jobs.
+	sourceBucket := job.Bucket
+	if sourceBucket == "" {
+		if bucketName, _, ok := server_utils.ParseObjectURL(job.S3Path); ok {
+			sourceBucket = bucketName
+		} else if err := server_utils.CheckRemoteURL(r.Context(), job.S3Path); err != nil {
+			writeError(w, http.StatusBadRequest, "unsafe_source_url", err.Error())
+			return
+		}
+	}
+	if sourceBucket != "" {
+		if err := server_utils.CheckSourceBucket(sourceBucket); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_bucket", err.Error())
+			return
+		}
+	}
+
+	outputBucket, err := server_utils.OutputBucket(job.OutputBucket)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_bucket", err.Error())
+		return
+	}
+
+	if job.Profile != "" {
+		if _, err := server_utils.RenditionProfile(job.Profile); err != nil {
+			writeError(w, http.StatusBadRequest, "unknown_profile", err.Error())
+			return
+		}
+	}
+
+	// A repeated submission inside the dedup window gets the first video.
+	// Redis trouble only disables the check.
+	fingerprint := ""
+	if dedupWindow > 0 {
+		fp := jobFingerprint(job)
+		existing, claimed, err := pubsub.ClaimJobFingerprint(r.Context(), fp, job.VideoID.String(), dedupWindow)
+		switch {
+		case err != nil:
+			log.Printf("Job dedup check failed: %v", err)
+		case !claimed:
+			log.Printf(" [i] Duplicate job for %s, returning %s", job.S3Path, existing)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{"status": "duplicate", "id": existing})
+			return
+		default:
+			fingerprint = fp
+		}
+	}
+
+	// Create video record in database
+	video := &models.Video{
+		ID:                job.VideoID,
+		OriginalName:      job.OriginalName,
+		S3Path:            job.S3Path,
+		SourceBucket:      job.Bucket,
+		OutputBucket:      outputBucket,
+		Profile:           job.Profile,
+		StartSeconds:      job.StartSeconds,
+		EndSeconds:        job.EndSeconds,
+		SequenceFrameRate: sequenceFrameRate(job),
+		OutputVersion:     job.OutputVersion,
+		Webhook:           job.Webhook,
+		Status:            models.StatusWaiting,
+		CreatedAt:         time.Now(),
+		UpdatedAt:         time.Now(),
+	}
+
+	// The video row and its outbox entry commit together, so the job
+	// survives a Redis outage
+	var entry *models.OutboxEntry
+	err = gormDB.WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := gorm.G[models.Video](tx).Create(r.Context(), video); err != nil {
+			return err
+		}
+		var err error
+		entry, err = outbox.Add(r.Context(), tx, job)
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to create video record: %s", err)
+		if fingerprint != "" {
+			pubsub.ReleaseJobFingerprint(r.Context(), fingerprint)
+		}
+		writeError(w, http.StatusInternalServerError, "database_error", "Failed to create video record")
+		return
+	}
+
+	// Enqueue job to Redis Stream; the outbox relay retries on failure
+	resp := map[string]string{"status": "queued", "id": job.VideoID.String()}
+	if streamID, err := outbox.Dispatch(r.Context(), gormDB, entry); err != nil {
+		log.Printf("Failed to enqueue job, left in outbox: %s", err)
+	} else {
+		log.Printf(" [x] Sent Job: %s", job.VideoID)
+		if streamID != "" {
+			resp["stream_id"] = streamID
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
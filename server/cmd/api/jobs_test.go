@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testredis"
+	"github.com/devrayat000/video-process/models"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"github.com/google/uuid"
+)
+
+func TestSubmitJobIgnoresOutputVersion(t *testing.T) {
+	setVar(t, &dedupWindow, 0)
+	setVar(t, &server_utils.SourceBuckets, "uploads")
+	rdb := useRedis(t, func(cmd []string) any {
+		if cmd[0] == "xadd" {
+			return "1-0"
+		}
+		return testredis.Status("OK")
+	})
+	gormDB, db := testdb.Open(t, outboxHandler(func(q testdb.Query) testdb.Result {
+		return testdb.Result{RowsAffected: 1}
+	}))
+
+	job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/clip.mp4", OutputVersion: 3}
+	rec := httptest.NewRecorder()
+	submitJob(rec, httptest.NewRequest(http.MethodPost, "/jobs", nil), gormDB, job)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	inserts := db.Matching(`INSERT INTO "videos"`)
+	if len(inserts) != 1 {
+		t.Fatalf("created %d video rows, want 1", len(inserts))
+	}
+	if slices.Contains(inserts[0].Args, any(int64(3))) {
+		t.Errorf("video row args %v carry the client's output_version", inserts[0].Args)
+	}
+	for _, cmd := range rdb.Named("XADD") {
+		for _, arg := range cmd {
+			if strings.Contains(arg, "output_version") {
+				t.Errorf("queued job %q carries an output_version", arg)
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+var (
+	// Longest upload key accepted, in bytes, prefix included; GCS allows 1024
+	uploadKeyMaxLength = server_utils.GetEnvInt("UPLOAD_KEY_MAX_LENGTH", 512)
+	// Most path segments an upload key may have, prefix included
+	uploadKeyMaxDepth = server_utils.GetEnvInt("UPLOAD_KEY_MAX_DEPTH", 8)
+	// UPLOAD_KEY_PREFIX is put in front of every client key that doesn't
+	// already start with it, e.g. "uploads/" to keep sources apart from
+	// the {id}/processed/ output
+	uploadKeyPrefix = server_utils.GetEnv("UPLOAD_KEY_PREFIX", "")
+)
+
+// normalizeUploadKey checks a client-chosen object key for /upload/init and
+// /upload/direct and returns it with UPLOAD_KEY_PREFIX applied. Keys must be
+// relative paths of plain segments: no "." or "..", no empty segments, no
+// backslashes or control characters, and none under a video's processed/
+// output.
+func normalizeUploadKey(key string) (string, error) {
+	if !utf8.ValidString(key) {
+		return "", fmt.Errorf("key must be valid UTF-8")
+	}
+	if strings.HasPrefix(key, "/") {
+		return "", fmt.Errorf("key must not start with a slash")
+	}
+	if strings.ContainsRune(key, '\\') {
+		return "", fmt.Errorf("key must not contain backslashes")
+	}
+	if strings.ContainsFunc(key, unicode.IsControl) {
+		return "", fmt.Errorf("key must not contain control characters")
+	}
+
+	if uploadKeyPrefix != "" && !strings.HasPrefix(key, uploadKeyPrefix) {
+		key = uploadKeyPrefix + key
+	}
+	if uploadKeyMaxLength > 0 && len(key) > uploadKeyMaxLength {
+		return "", fmt.Errorf("key is longer than %d bytes", uploadKeyMaxLength)
+	}
+
+	segments := strings.Split(key, "/")
+	if uploadKeyMaxDepth > 0 && len(segments) > uploadKeyMaxDepth {
+		return "", fmt.Errorf("key has more than %d path segments", uploadKeyMaxDepth)
+	}
+	for _, segment := range segments {
+		switch segment {
+		case "":
+			return "", fmt.Errorf("key must not contain empty path segments")
+		case ".", "..":
+			return "", fmt.Errorf("key must not contain %q segments", segment)
+		}
+	}
+	if len(segments) > 1 && segments[1] == "processed" {
+		return "", fmt.Errorf("key must not be under a {id}/processed/ output prefix")
+	}
+
+	return key, nil
+}
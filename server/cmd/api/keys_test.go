@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeUploadKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		prefix  string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain file", key: "holiday.mp4", want: "holiday.mp4"},
+		{name: "nested", key: "users/42/holiday.mp4", want: "users/42/holiday.mp4"},
+		{name: "unicode", key: "café/trip.mp4", want: "café/trip.mp4"},
+		{name: "dots in a name", key: "a..b/clip.v2.mp4", want: "a..b/clip.v2.mp4"},
+		{name: "prefix applied", key: "holiday.mp4", prefix: "uploads/", want: "uploads/holiday.mp4"},
+		{name: "prefix kept once", key: "uploads/holiday.mp4", prefix: "uploads/", want: "uploads/holiday.mp4"},
+		{name: "parent segment", key: "../secrets.mp4", wantErr: true},
+		{name: "inner parent segment", key: "a/../../b.mp4", wantErr: true},
+		{name: "dot segment", key: "./a.mp4", wantErr: true},
+		{name: "absolute", key: "/etc/passwd", wantErr: true},
+		{name: "empty segment", key: "a//b.mp4", wantErr: true},
+		{name: "trailing slash", key: "a/", wantErr: true},
+		{name: "backslash", key: `a\..\b.mp4`, wantErr: true},
+		{name: "newline", key: "a\nb.mp4", wantErr: true},
+		{name: "NUL", key: "a\x00.mp4", wantErr: true},
+		{name: "invalid UTF-8", key: "a\xff.mp4", wantErr: true},
+		{name: "processed output", key: "0f8fad5b-d9cb-469f-a165-70867728950e/processed/master.m3u8", wantErr: true},
+		{name: "too deep", key: "1/2/3/4/5/6/7/8/9.mp4", wantErr: true},
+		{name: "too deep with prefix", key: "2/3/4/5/6/7/8/9.mp4", prefix: "uploads/", wantErr: true},
+		{name: "too long", key: strings.Repeat("a", 509) + ".mp4", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &uploadKeyPrefix, tt.prefix)
+			setVar(t, &uploadKeyMaxLength, 512)
+			setVar(t, &uploadKeyMaxDepth, 8)
+
+			got, err := normalizeUploadKey(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeUploadKey(%q) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("normalizeUploadKey(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeUploadKeyUnlimited(t *testing.T) {
+	setVar(t, &uploadKeyPrefix, "")
+	setVar(t, &uploadKeyMaxLength, 0)
+	setVar(t, &uploadKeyMaxDepth, 0)
+
+	key := strings.Repeat("a/", 20) + strings.Repeat("b", 600) + ".mp4"
+	if got, err := normalizeUploadKey(key); err != nil || got != key {
+		t.Errorf("normalizeUploadKey with no limits = %q, %v; want the key", got, err)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+var (
+	// Largest JSON request body accepted by the API
+	maxJSONBodyBytes = int64(server_utils.GetEnvInt("MAX_JSON_BODY_BYTES", 1<<20))
+	// Largest file accepted by /upload/direct
+	maxUploadBytes = int64(server_utils.GetEnvInt("MAX_UPLOAD_BYTES", 5<<30))
+	// Largest caption file accepted by POST /videos/{id}/captions
+	maxCaptionBytes = int64(server_utils.GetEnvInt("MAX_CAPTION_BYTES", 5<<20))
+)
+
+// limitBody caps the request body at limit bytes. It answers 413 straight
+// away and returns false when the declared Content-Length is already too big.
+func limitBody(w http.ResponseWriter, r *http.Request, limit int64) bool {
+	if r.ContentLength > limit {
+		writeBodyTooLarge(w, limit)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	return true
+}
+
+// isBodyTooLarge reports whether err came from reading past a limitBody cap
+func isBodyTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+func writeBodyTooLarge(w http.ResponseWriter, limit int64) {
+	writeError(w, http.StatusRequestEntityTooLarge, "body_too_large", fmt.Sprintf("Request body too large (limit %d bytes)", limit))
+}
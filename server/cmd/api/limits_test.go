@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+// limitedEcho reads the body under limitBody the way the API handlers do
+func limitedEcho(limit *int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !limitBody(w, r, *limit) {
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			if isBodyTooLarge(err) {
+				writeBodyTooLarge(w, *limit)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write(data)
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	setVar(t, &maxJSONBodyBytes, 16)
+	setVar(t, &maxUploadBytes, 64)
+
+	tests := []struct {
+		name     string
+		limit    *int64
+		size     int
+		chunked  bool
+		wantCode int
+	}{
+		{"JSON within limit", &maxJSONBodyBytes, 16, false, http.StatusOK},
+		{"JSON over declared limit", &maxJSONBodyBytes, 17, false, http.StatusRequestEntityTooLarge},
+		{"JSON over limit without length", &maxJSONBodyBytes, 17, true, http.StatusRequestEntityTooLarge},
+		{"upload above the JSON limit", &maxUploadBytes, 64, false, http.StatusOK},
+		{"upload over declared limit", &maxUploadBytes, 65, false, http.StatusRequestEntityTooLarge},
+		{"upload over limit without length", &maxUploadBytes, 100, true, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.Repeat("x", tt.size)
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			limitedEcho(tt.limit).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode == http.StatusOK && rec.Body.String() != body {
+				t.Errorf("body = %q, want %q", rec.Body, body)
+			}
+			if tt.wantCode == http.StatusRequestEntityTooLarge && !strings.Contains(rec.Body.String(), "Request body too large") {
+				t.Errorf("413 body = %q, want a clear message", rec.Body)
+			}
+		})
+	}
+}
+
+func TestReprocessHandlerRejectsOversizedBody(t *testing.T) {
+	setVar(t, &maxJSONBodyBytes, 64)
+	id := uuid.New()
+	gormDB, db := testdb.Open(t, func(q testdb.Query) testdb.Result {
+		return testdb.Result{
+			Columns: []string{"id", "status", "s3_path"},
+			Rows:    [][]any{{id.String(), string(models.StatusCompleted), "gs://videos/a.mp4"}},
+		}
+	})
+
+	renditions := make([]models.Rendition, 20)
+	for i := range renditions {
+		renditions[i] = models.Rendition{Height: 720, Bitrate: 3000}
+	}
+	body, _ := json.Marshal(map[string]any{"renditions": renditions})
+
+	for _, chunked := range []bool{false, true} {
+		req := httptest.NewRequest(http.MethodPost, "/videos/"+id.String()+"/reprocess", strings.NewReader(string(body)))
+		req.SetPathValue("id", id.String())
+		if chunked {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		reprocessHandler(gormDB, nil).ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("chunked=%v: status = %d, want %d", chunked, rec.Code, http.StatusRequestEntityTooLarge)
+		}
+	}
+	if len(db.Matching("UPDATE")) != 0 {
+		t.Error("oversized request changed the video")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/devrayat000/video-process/pubsub"
+)
+
+// jobLogsHandler streams a video's worker log lines as server-sent events:
+// first the buffered recent lines, then new ones as the worker publishes
+// them. Secrets are redacted by the worker before publishing. Admin only,
+// since logs name sources and internals.
+func jobLogsHandler(w http.ResponseWriter, r *http.Request) {
+	enableCors(&w)
+
+	if r.Method == "OPTIONS" {
+		return
+	}
+
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	id, ok := parseVideoID(w, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	videoID := id.String()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming_unsupported", "Streaming unsupported")
+		return
+	}
+
+	ctx := r.Context()
+
+	// Subscribe before reading the buffer so nothing falls in between; a
+	// line published meanwhile may be sent twice
+	sub, err := pubsub.SubscribeJobLogs(ctx, videoID)
+	if err != nil {
+		log.Printf("Failed to subscribe to logs of %s: %v", videoID, err)
+		writeError(w, http.StatusInternalServerError, "subscribe_failed", "Failed to subscribe")
+		return
+	}
+	defer sub.Close()
+
+	buffered, err := pubsub.GetJobLogBuffer(ctx, videoID)
+	if err != nil {
+		log.Printf("Failed to read buffered logs of %s: %v", videoID, err)
+	}
+
+	clearWriteDeadline(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	for _, line := range buffered {
+		writeLogEvent(w, line)
+	}
+	flusher.Flush()
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			writeLogEvent(w, msg.Payload)
+			flusher.Flush()
+		}
+	}
+}
+
+// writeLogEvent sends one log entry as an SSE event. Entries such as FFmpeg
+// errors can span lines, and each needs its own data field.
+func writeLogEvent(w http.ResponseWriter, entry string) {
+	for _, line := range strings.Split(entry, "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
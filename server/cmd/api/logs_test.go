@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devrayat000/video-process/pubsub"
+	"github.com/google/uuid"
+)
+
+func TestJobLogsHandler(t *testing.T) {
+	setVar(t, &adminToken, "s3cret")
+	videoID := uuid.New()
+	channel := pubsub.JobLogChannel + videoID.String()
+	rdb := useRedis(t, func(cmd []string) any {
+		if cmd[0] == "lrange" {
+			return []string{" [i] Probing source", " [i] Generating 2 renditions"}
+		}
+		return nil
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/videos/{id}/logs", jobLogsHandler)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/videos/"+videoID.String()+"/logs", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer s3cret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("response = %d %q, want an event stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	expect := func(want ...string) {
+		t.Helper()
+		for _, w := range want {
+			select {
+			case got := <-lines:
+				if got != w {
+					t.Fatalf("stream line = %q, want %q", got, w)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("timed out waiting for %q", w)
+			}
+		}
+	}
+
+	// Buffered lines first, for a client that connects mid-job
+	expect("data:  [i] Probing source", "", "data:  [i] Generating 2 renditions", "")
+
+	// Then live lines; a multi-line entry stays one event
+	if n := rdb.Publish(channel, "[ffmpeg] Error opening input\nConversion failed!"); n != 1 {
+		t.Fatalf("published to %d subscribers, want 1", n)
+	}
+	expect("data: [ffmpeg] Error opening input", "data: Conversion failed!", "")
+
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for rdb.Subscribers(channel) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("subscription outlived the client")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestJobLogsHandlerRejects(t *testing.T) {
+	setVar(t, &adminToken, "s3cret")
+	useRedis(t, nil)
+
+	tests := []struct {
+		name     string
+		id       string
+		token    string
+		wantCode int
+		wantErr  string
+	}{
+		{"no token", uuid.NewString(), "", http.StatusUnauthorized, "unauthorized"},
+		{"invalid id", "not-a-uuid", "s3cret", http.StatusBadRequest, "invalid_video_id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/videos/"+tt.id+"/logs", nil)
+			req.SetPathValue("id", tt.id)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			jobLogsHandler(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if got := decodeError(t, rec).Code; !strings.HasPrefix(got, tt.wantErr) {
+				t.Errorf("error code = %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
@@ -7,14 +7,13 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"net/url"
 	"strconv"
-	"strings"
 	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/devrayat000/video-process/db"
 	"github.com/devrayat000/video-process/models"
+	"github.com/devrayat000/video-process/outbox"
 	"github.com/devrayat000/video-process/pubsub"
 	server_utils "github.com/devrayat000/video-process/utils"
 	"gorm.io/gorm"
@@ -22,8 +21,7 @@ import (
 
 // GCS configuration is resolved at runtime to keep API and worker consistent.
 var (
-	gcsPublicEndpoint = server_utils.GetEnv("GCS_PUBLIC_ENDPOINT", "https://storage.googleapis.com")
-	gcsBucket         = server_utils.GetEnv("GCS_BUCKET_NAME", "")
+	gcsBucket = server_utils.GetEnv("GCS_BUCKET_NAME", "")
 )
 
 func main() {
@@ -33,6 +31,12 @@ func main() {
 		log.Fatal("Failed to initialize database:", err)
 	}
 
+	// List and stats queries go to the replica when one is configured
+	readDB, err := db.InitReadDB(gormDB)
+	if err != nil {
+		log.Fatal("Failed to initialize read database:", err)
+	}
+
 	redis, err := pubsub.InitRedis()
 	if err != nil {
 		log.Fatal("Failed to initialize Redis:", err)
@@ -47,6 +51,17 @@ func main() {
 	}
 	defer gcsClient.Close()
 
+	if err := server_utils.LoadRenditionProfiles(); err != nil {
+		log.Fatal(err)
+	}
+
+	// SSE clients share one Redis subscription connection
+	progressHub := pubsub.NewProgressHub(ctx, sseMaxSubscribers, sseMaxPerVideo)
+	defer progressHub.Close()
+
+	// Push jobs that couldn't be enqueued right away
+	go outbox.Relay(ctx, gormDB)
+
 	// HTTP Handlers
 	http.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
 		enableCors(&w)
@@ -56,44 +71,23 @@ func main() {
 		}
 
 		if r.Method != "POST" {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 			return
 		}
 
 		var job models.VideoJob
-		if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		if !decodeJSON(w, r, &job) {
 			return
 		}
 
-		// Create video record in database
-		video := &models.Video{
-			ID:           job.VideoID,
-			OriginalName: job.OriginalName,
-			S3Path:       job.S3Path,
-			Status:       models.StatusWaiting,
-			CreatedAt:    time.Now(),
-			UpdatedAt:    time.Now(),
-		}
-
-		if err := gorm.G[models.Video](gormDB).Create(r.Context(), video); err != nil {
-			log.Printf("Failed to create video record: %s", err)
-			http.Error(w, "Failed to create video record", http.StatusInternalServerError)
-			return
-		}
-
-		// Enqueue job to Redis Stream
-		if err := pubsub.EnqueueJob(job); err != nil {
-			log.Printf("Failed to enqueue job: %s", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
+		submitJob(w, r, gormDB, job)
+	})
 
-		log.Printf(" [x] Sent Job: %s", job.VideoID)
+	// Jobs whose source is hosted elsewhere
+	http.HandleFunc("/jobs/remote", remoteJobHandler(gormDB))
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"status": "queued", "id": job.VideoID.String()})
-	})
+	// Remove a job that no worker has picked up yet
+	http.HandleFunc("/jobs/{stream_id}", cancelJobHandler(gormDB))
 
 	// Get video details
 	http.HandleFunc("/videos/", func(w http.ResponseWriter, r *http.Request) {
@@ -104,19 +98,18 @@ func main() {
 		}
 
 		if r.Method != "GET" {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 			return
 		}
 
-		videoID := r.URL.Path[len("/videos/"):]
-		if videoID == "" {
-			http.Error(w, "Video ID required", http.StatusBadRequest)
+		videoID, ok := parseVideoID(w, r.URL.Path[len("/videos/"):])
+		if !ok {
 			return
 		}
 
-		video, err := gorm.G[models.Video](gormDB).Where("id = ?", videoID).First(r.Context())
+		video, err := gorm.G[models.Video](readDB).Where("id = ?", videoID).First(r.Context())
 		if err != nil {
-			http.Error(w, "Video not found", http.StatusNotFound)
+			writeError(w, http.StatusNotFound, "video_not_found", "Video not found")
 			return
 		}
 
@@ -124,6 +117,45 @@ func main() {
 		json.NewEncoder(w).Encode(&video)
 	})
 
+	// Status of several videos in one request
+	http.HandleFunc("/videos/status", bulkStatusHandler(gormDB))
+
+	// Re-transcode an existing video from its original source
+	http.HandleFunc("/videos/{id}/reprocess", reprocessHandler(gormDB, gcsClient))
+
+	// Download the processed HLS output as a ZIP archive
+	http.HandleFunc("/videos/{id}/download", downloadHandler(gormDB, gcsClient))
+
+	// Completion manifest summarising the outputs
+	http.HandleFunc("/videos/{id}/manifest", manifestHandler(gormDB, gcsClient))
+
+	// Re-check stored objects against their recorded checksums
+	http.HandleFunc("/videos/{id}/verify", verifyHandler(gormDB, gcsClient))
+
+	// Playlists and subtitles proxied from storage, gzipped on request
+	http.HandleFunc("/videos/{id}/hls/{path...}", playlistHandler(gormDB, gcsClient))
+
+	// Storage objects that exist for a video
+	http.HandleFunc("/videos/{id}/objects", objectsHandler(gormDB, gcsClient))
+
+	// Admin override for videos stuck in a non-terminal state
+	http.HandleFunc("/videos/{id}/force-status", forceStatusHandler(gormDB))
+
+	// Raw ffprobe output for the source
+	http.HandleFunc("/videos/{id}/probe", probeHandler(readDB))
+
+	// Live worker log lines for a video (admin only)
+	http.HandleFunc("/videos/{id}/logs", jobLogsHandler)
+
+	// Caption tracks added after processing
+	http.HandleFunc("/videos/{id}/captions", captionsHandler(gormDB, gcsClient))
+
+	// Aggregate processing statistics for dashboards
+	http.HandleFunc("/stats", statsHandler(readDB))
+
+	// Admin dashboard: stats, queue and recent failures in one response
+	http.HandleFunc("/admin/overview", adminOverviewHandler(readDB))
+
 	// List all videos
 	http.HandleFunc("/videos", func(w http.ResponseWriter, r *http.Request) {
 		enableCors(&w)
@@ -133,7 +165,7 @@ func main() {
 		}
 
 		if r.Method != "GET" {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 			return
 		}
 
@@ -146,15 +178,46 @@ func main() {
 			}
 		}
 
+		// Cursor pagination: ?cursor= (empty for the first page) returns
+		// {videos, next_cursor}. Plain offset paging is kept for old clients.
+		if r.URL.Query().Has("cursor") {
+			if limit <= 0 || limit > 100 {
+				limit = 20
+			}
+
+			var cursor *listCursor
+			if token := r.URL.Query().Get("cursor"); token != "" {
+				c, err := decodeCursor(token)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, "invalid_cursor", err.Error())
+					return
+				}
+				cursor = c
+			}
+
+			videos, next, err := listVideosAfter(readDB.WithContext(r.Context()), cursor, limit)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "database_error", "Failed to fetch videos")
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{
+				"videos":      videos,
+				"next_cursor": next,
+			})
+			return
+		}
+
 		if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
 			if o, err := strconv.Atoi(offsetStr); err == nil {
 				offset = o
 			}
 		}
 
-		videos, err := gorm.G[models.Video](gormDB).Limit(limit).Offset(offset).Find(r.Context())
+		videos, err := gorm.G[models.Video](readDB).Limit(limit).Offset(offset).Find(r.Context())
 		if err != nil {
-			http.Error(w, "Failed to fetch videos", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, "database_error", "Failed to fetch videos")
 			return
 		}
 
@@ -162,6 +225,9 @@ func main() {
 		json.NewEncoder(w).Encode(videos)
 	})
 
+	// Recent progress events for a video
+	http.HandleFunc("/progress/{id}/history", progressHistoryHandler)
+
 	// SSE endpoint for real-time progress updates
 	http.HandleFunc("/progress/", func(w http.ResponseWriter, r *http.Request) {
 		enableCors(&w)
@@ -170,11 +236,26 @@ func main() {
 			return
 		}
 
-		videoID := r.URL.Path[len("/progress/"):]
-		if videoID == "" {
-			http.Error(w, "Video ID required", http.StatusBadRequest)
+		id, ok := parseVideoID(w, r.URL.Path[len("/progress/"):])
+		if !ok {
+			return
+		}
+		videoID := id.String()
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, http.StatusInternalServerError, "streaming_unsupported", "Streaming unsupported")
+			return
+		}
+
+		// Subscribe to progress updates
+		progressChan, unsubscribe, ok := subscribeProgress(progressHub, w, r, videoID)
+		if !ok {
 			return
 		}
+		defer unsubscribe()
+
+		clearWriteDeadline(w)
 
 		// Set headers for SSE
 		w.Header().Set("Content-Type", "text/event-stream")
@@ -182,31 +263,44 @@ func main() {
 		w.Header().Set("Connection", "keep-alive")
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 
-		flusher, ok := w.(http.Flusher)
-		if !ok {
-			http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
-			return
-		}
+		ctx := r.Context()
 
-		// Send current progress if available
-		if progress, err := pubsub.GetProgress(videoID); err == nil {
+		// Send current progress if available. The subscription is already
+		// open, so a job finishing from here on is caught either way; one
+		// that already finished ends the stream right away.
+		progress, err := pubsub.GetProgress(videoID)
+		if err != nil || !isTerminal(progress.Status) {
+			if final := terminalProgress(ctx, gormDB, id); final != nil {
+				progress, err = final, nil
+			}
+		}
+		if err == nil {
 			data, _ := json.Marshal(progress)
 			fmt.Fprintf(w, "data: %s\n\n", data)
 			flusher.Flush()
+			if isTerminal(progress.Status) {
+				return
+			}
 		}
 
-		// Subscribe to progress updates
-		ctx := r.Context()
-		progressChan, err := pubsub.SubscribeToProgress(ctx, videoID)
-		if err != nil {
-			http.Error(w, "Failed to subscribe", http.StatusInternalServerError)
-			return
+		var recheck <-chan time.Time
+		if sseStatusRecheck > 0 {
+			ticker := time.NewTicker(sseStatusRecheck)
+			defer ticker.Stop()
+			recheck = ticker.C
 		}
 
 		for {
 			select {
 			case <-ctx.Done():
 				return
+			case <-recheck:
+				if final := terminalProgress(ctx, gormDB, id); final != nil {
+					data, _ := json.Marshal(final)
+					fmt.Fprintf(w, "data: %s\n\n", data)
+					flusher.Flush()
+					return
+				}
 			case progress := <-progressChan:
 				if progress == nil {
 					return
@@ -216,7 +310,7 @@ func main() {
 				flusher.Flush()
 
 				// Close connection when completed or failed
-				if progress.Status == models.StatusCompleted || progress.Status == models.StatusFailed {
+				if isTerminal(progress.Status) {
 					return
 				}
 			}
@@ -231,25 +325,28 @@ func main() {
 			return
 		}
 
-		// Set headers for SSE
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-
 		flusher, ok := w.(http.Flusher)
 		if !ok {
-			http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, "streaming_unsupported", "Streaming unsupported")
 			return
 		}
 
 		// Subscribe to all progress updates
-		ctx := r.Context()
-		progressChan, err := pubsub.SubscribeToAllProgress(ctx)
-		if err != nil {
-			http.Error(w, "Failed to subscribe", http.StatusInternalServerError)
+		progressChan, unsubscribe, ok := subscribeProgress(progressHub, w, r, "")
+		if !ok {
 			return
 		}
+		defer unsubscribe()
+
+		clearWriteDeadline(w)
+
+		// Set headers for SSE
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+
+		ctx := r.Context()
 
 		for {
 			select {
@@ -275,7 +372,7 @@ func main() {
 		}
 
 		if r.Method != "POST" {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 			return
 		}
 
@@ -285,13 +382,17 @@ func main() {
 			ContentType string `json:"content_type"`
 		}
 
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		if !decodeJSON(w, r, &req) {
 			return
 		}
 
 		if req.Key == "" {
-			http.Error(w, "Key is required", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, "key_required", "Key is required")
+			return
+		}
+		key, err := normalizeUploadKey(req.Key)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_key", err.Error())
 			return
 		}
 
@@ -317,10 +418,10 @@ func main() {
 			},
 		}
 
-		signedURL, err := gcsClient.Bucket(bucket).SignedURL(req.Key, opts)
+		signedURL, err := gcsClient.Bucket(bucket).SignedURL(key, opts)
 		if err != nil {
 			log.Printf("Failed to create upload signed URL: %v", err)
-			http.Error(w, "Failed to create upload URL", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, "signed_url_failed", "Failed to create upload URL")
 			return
 		}
 
@@ -328,7 +429,7 @@ func main() {
 		json.NewEncoder(w).Encode(map[string]string{
 			"upload_url": signedURL,
 			"bucket":     bucket,
-			"key":        req.Key,
+			"key":        key,
 			"method":     "signed",
 		})
 	})
@@ -342,13 +443,18 @@ func main() {
 		}
 
 		if r.Method != "POST" && r.Method != "PUT" {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 			return
 		}
 
 		key := r.URL.Query().Get("key")
 		if key == "" {
-			http.Error(w, "Key query parameter is required", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, "key_required", "Key query parameter is required")
+			return
+		}
+		key, err := normalizeUploadKey(key)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_key", err.Error())
 			return
 		}
 
@@ -357,33 +463,56 @@ func main() {
 			bucket = gcsBucket
 		}
 
+		// Large uploads take longer than the default timeouts
+		clearReadDeadline(w)
+		clearWriteDeadline(w)
+
 		contentType := r.Header.Get("Content-Type")
 		if contentType == "" {
 			contentType = "application/octet-stream"
 		}
 
-		// Upload to GCS
-		ctx := r.Context()
+		if !limitBody(w, r, maxUploadBytes) {
+			return
+		}
+
+		release, ok := tryAcquireUpload()
+		if !ok {
+			writeUploadsBusy(w)
+			return
+		}
+		defer release()
+
+		// Upload to GCS. Cancelling the writer's context on error aborts the
+		// upload instead of committing a truncated object.
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
 		obj := gcsClient.Bucket(bucket).Object(key)
 		writer := obj.NewWriter(ctx)
 		writer.ContentType = contentType
+		writer.ChunkSize = uploadBufferSize(r.ContentLength)
 
-		_, err := io.Copy(writer, r.Body)
+		_, err = io.Copy(writer, r.Body)
 		if err != nil {
+			cancel()
 			writer.Close()
+			if isBodyTooLarge(err) {
+				writeBodyTooLarge(w, maxUploadBytes)
+				return
+			}
 			log.Printf("Failed to upload to GCS: %v", err)
-			http.Error(w, "Upload failed", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, "upload_failed", "Upload failed")
 			return
 		}
 
 		if err := writer.Close(); err != nil {
 			log.Printf("Failed to close GCS writer: %v", err)
-			http.Error(w, "Upload failed", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, "upload_failed", "Upload failed")
 			return
 		}
 
 		// Return the public URL
-		publicURL := fmt.Sprintf("%s/%s/%s", gcsPublicEndpoint, bucket, encodeKeyForURL(key))
+		publicURL := server_utils.PublicObjectURL(bucket, key)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{
@@ -402,13 +531,13 @@ func main() {
 		}
 
 		if r.Method != "GET" {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 			return
 		}
 
 		key := r.URL.Query().Get("key")
 		if key == "" {
-			http.Error(w, "Key query parameter is required", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, "key_required", "Key query parameter is required")
 			return
 		}
 
@@ -433,7 +562,7 @@ func main() {
 		signedURL, err := gcsClient.Bucket(bucket).SignedURL(key, opts)
 		if err != nil {
 			log.Printf("Failed to create download signed URL: %v", err)
-			http.Error(w, "Failed to create download URL", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, "signed_url_failed", "Failed to create download URL")
 			return
 		}
 
@@ -452,7 +581,7 @@ func main() {
 		}
 
 		if r.Method != "GET" {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 			return
 		}
 
@@ -460,8 +589,16 @@ func main() {
 		json.NewEncoder(w).Encode(map[string]string{"status": "ok", "service": "api_server"})
 	})
 
-	log.Println("API Server starting on port 8080...")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	log.Fatal(serve(newServer(http.DefaultServeMux)))
+}
+
+// outputBucketOf returns the bucket holding a video's processed output.
+// Videos created before per-tenant buckets have none recorded.
+func outputBucketOf(video models.Video) string {
+	if video.OutputBucket != "" {
+		return video.OutputBucket
+	}
+	return gcsBucket
 }
 
 func enableCors(w *http.ResponseWriter) {
@@ -469,11 +606,3 @@ func enableCors(w *http.ResponseWriter) {
 	(*w).Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
 	(*w).Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
 }
-
-func encodeKeyForURL(key string) string {
-	parts := strings.Split(key, "/")
-	for i, part := range parts {
-		parts[i] = url.PathEscape(part)
-	}
-	return strings.Join(parts, "/")
-}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testredis"
+	"github.com/devrayat000/video-process/pubsub"
+)
+
+// useRedis points pubsub at a test server for the test
+func useRedis(t *testing.T, handler testredis.Handler) *testredis.Server {
+	t.Helper()
+	client, server := testredis.Start(t, handler)
+	previous := pubsub.RedisClient
+	pubsub.RedisClient = client
+	t.Cleanup(func() { pubsub.RedisClient = previous })
+	return server
+}
+
+// setVar overrides a package setting for the test
+func setVar[T any](t *testing.T, v *T, value T) {
+	t.Helper()
+	previous := *v
+	*v = value
+	t.Cleanup(func() { *v = previous })
+}
+
+// outboxHandler answers job_outbox queries like a real table holding one
+// entry, the last one inserted, and passes everything else to next
+func outboxHandler(next testdb.Handler) testdb.Handler {
+	var payload string
+	return func(q testdb.Query) testdb.Result {
+		switch {
+		case strings.HasPrefix(q.SQL, `INSERT INTO "job_outbox"`):
+			for _, arg := range q.Args {
+				if s, ok := arg.(string); ok && strings.HasPrefix(s, "{") {
+					payload = s
+				}
+			}
+			return testdb.Result{Columns: []string{"id"}, Rows: [][]any{{int64(1)}}, RowsAffected: 1}
+		case strings.HasPrefix(q.SQL, `SELECT * FROM "job_outbox"`):
+			return testdb.Result{
+				Columns: []string{"id", "payload", "attempts"},
+				Rows:    [][]any{{int64(1), payload, int64(0)}},
+			}
+		case strings.Contains(q.SQL, `"job_outbox"`):
+			return testdb.Result{RowsAffected: 1}
+		}
+		return next(q)
+	}
+}
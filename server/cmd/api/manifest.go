@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"github.com/devrayat000/video-process/models"
+	"gorm.io/gorm"
+)
+
+// manifestHandler serves the completion manifest the worker stored as
+// manifest.json under the output prefix.
+func manifestHandler(gormDB *gorm.DB, gcsClient *storage.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enableCors(&w)
+
+		if r.Method == "OPTIONS" {
+			return
+		}
+
+		if r.Method != "GET" {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+			return
+		}
+
+		videoID, ok := parseVideoID(w, r.PathValue("id"))
+		if !ok {
+			return
+		}
+		ctx := r.Context()
+
+		video, err := gorm.G[models.Video](gormDB).Where("id = ?", videoID).First(ctx)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "video_not_found", "Video not found")
+			return
+		}
+
+		key := video.OutputPrefix() + "manifest.json"
+		reader, err := gcsClient.Bucket(outputBucketOf(video)).Object(key).NewReader(ctx)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			writeError(w, http.StatusNotFound, "manifest_not_found", "Manifest not found")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to read manifest %s: %v", key, err)
+			writeError(w, http.StatusInternalServerError, "storage_error", "Failed to read manifest")
+			return
+		}
+		defer reader.Close()
+
+		writeBody(w, r, "application/json", reader.Attrs.Size, reader)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/google/uuid"
+)
+
+func TestManifestHandler(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	id := uuid.New()
+	manifest := `{"video_id":"` + id.String() + `","renditions":[]}`
+
+	tests := []struct {
+		name     string
+		known    bool
+		stored   bool
+		wantCode int
+	}{
+		{name: "stored manifest", known: true, stored: true, wantCode: http.StatusOK},
+		{name: "no manifest yet", known: true, wantCode: http.StatusNotFound},
+		{name: "unknown video", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gcsClient, store := testgcs.Start(t)
+			if tt.stored {
+				store.Put("videos", id.String()+"/processed/manifest.json", []byte(manifest))
+			}
+			gormDB, _ := testdb.Open(t, func(q testdb.Query) testdb.Result {
+				if !tt.known {
+					return testdb.Result{}
+				}
+				return testdb.Result{Columns: []string{"id"}, Rows: [][]any{{id.String()}}}
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/videos/"+id.String()+"/manifest", nil)
+			req.SetPathValue("id", id.String())
+			rec := httptest.NewRecorder()
+			manifestHandler(gormDB, gcsClient).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			if rec.Body.String() != manifest {
+				t.Errorf("body = %q, want %q", rec.Body, manifest)
+			}
+		})
+	}
+}
+
+func TestManifestHandlerReadsTenantBucket(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	id := uuid.New()
+	gcsClient, store := testgcs.Start(t)
+	store.Put("videos", id.String()+"/processed/manifest.json", []byte(`{"bucket":"default"}`))
+	store.Put("tenant-a", id.String()+"/processed/manifest.json", []byte(`{"bucket":"tenant-a"}`))
+
+	tests := []struct {
+		outputBucket string
+		want         string
+	}{
+		{"", `{"bucket":"default"}`},
+		{"tenant-a", `{"bucket":"tenant-a"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.outputBucket, func(t *testing.T) {
+			gormDB, _ := testdb.Open(t, func(q testdb.Query) testdb.Result {
+				return testdb.Result{Columns: []string{"id", "output_bucket"}, Rows: [][]any{{id.String(), tt.outputBucket}}}
+			})
+			req := httptest.NewRequest(http.MethodGet, "/videos/"+id.String()+"/manifest", nil)
+			req.SetPathValue("id", id.String())
+			rec := httptest.NewRecorder()
+			manifestHandler(gormDB, gcsClient).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK || rec.Body.String() != tt.want {
+				t.Errorf("response = %d %s, want %s", rec.Code, rec.Body, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/devrayat000/video-process/models"
+	"google.golang.org/api/iterator"
+	"gorm.io/gorm"
+)
+
+type storageObject struct {
+	Key         string    `json:"key"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	MD5         string    `json:"md5,omitempty"`
+	Updated     time.Time `json:"updated"`
+}
+
+// objectsHandler lists what actually exists under the output prefix in storage,
+// one page at a time, for debugging and CDN invalidation.
+func objectsHandler(gormDB *gorm.DB, gcsClient *storage.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enableCors(&w)
+
+		if r.Method == "OPTIONS" {
+			return
+		}
+
+		if r.Method != "GET" {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+			return
+		}
+
+		pageSize := 100
+		if sizeStr := r.URL.Query().Get("page_size"); sizeStr != "" {
+			if n, err := strconv.Atoi(sizeStr); err == nil && n > 0 && n <= 1000 {
+				pageSize = n
+			}
+		}
+
+		ctx := r.Context()
+		videoID, ok := parseVideoID(w, r.PathValue("id"))
+		if !ok {
+			return
+		}
+
+		video, err := gorm.G[models.Video](gormDB).Where("id = ?", videoID).First(ctx)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "video_not_found", "Video not found")
+			return
+		}
+
+		prefix := video.OutputPrefix()
+		it := gcsClient.Bucket(outputBucketOf(video)).Objects(ctx, &storage.Query{Prefix: prefix})
+
+		var page []*storage.ObjectAttrs
+		nextToken, err := iterator.NewPager(it, pageSize, r.URL.Query().Get("page_token")).NextPage(&page)
+		if err != nil {
+			log.Printf("Failed to list objects under %s: %v", prefix, err)
+			writeError(w, http.StatusInternalServerError, "storage_error", "Failed to list objects")
+			return
+		}
+
+		objects := make([]storageObject, 0, len(page))
+		for _, attrs := range page {
+			objects = append(objects, storageObject{
+				Key:         attrs.Name,
+				Size:        attrs.Size,
+				ContentType: attrs.ContentType,
+				MD5:         hex.EncodeToString(attrs.MD5),
+				Updated:     attrs.Updated,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"bucket":          outputBucketOf(video),
+			"prefix":          prefix,
+			"objects":         objects,
+			"next_page_token": nextToken,
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/google/uuid"
+)
+
+type objectsPage struct {
+	Bucket        string          `json:"bucket"`
+	Prefix        string          `json:"prefix"`
+	Objects       []storageObject `json:"objects"`
+	NextPageToken string          `json:"next_page_token"`
+}
+
+func TestObjectsHandler(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	id := uuid.New()
+	prefix := id.String() + "/processed/"
+
+	gcsClient, store := testgcs.Start(t)
+	putObject(t, gcsClient, prefix+"master.m3u8", "application/vnd.apple.mpegurl", "#EXTM3U\n")
+	putObject(t, gcsClient, prefix+"stream_0/playlist.m3u8", "application/vnd.apple.mpegurl", "#EXTM3U\nsegment_000.ts\n")
+	putObject(t, gcsClient, prefix+"stream_0/segment_000.ts", "video/mp2t", "segment data")
+	putObject(t, gcsClient, prefix+"stream_0/segment_001.ts", "video/mp2t", "more segment data")
+	// Neither the source nor another video's output is listed
+	store.Put("videos", id.String()+"/source.mp4", []byte("source"))
+	store.Put("videos", uuid.NewString()+"/processed/master.m3u8", []byte("#EXTM3U\n"))
+
+	gormDB, _ := testdb.Open(t, func(q testdb.Query) testdb.Result {
+		return testdb.Result{Columns: []string{"id"}, Rows: [][]any{{id.String()}}}
+	})
+	list := func(query string) objectsPage {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/videos/"+id.String()+"/objects"+query, nil)
+		req.SetPathValue("id", id.String())
+		rec := httptest.NewRecorder()
+		objectsHandler(gormDB, gcsClient).ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+		var page objectsPage
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		return page
+	}
+
+	all := list("")
+	if all.Bucket != "videos" || all.Prefix != prefix || all.NextPageToken != "" {
+		t.Errorf("page = bucket %q, prefix %q, next %q", all.Bucket, all.Prefix, all.NextPageToken)
+	}
+	wantKeys := []string{
+		prefix + "master.m3u8",
+		prefix + "stream_0/playlist.m3u8",
+		prefix + "stream_0/segment_000.ts",
+		prefix + "stream_0/segment_001.ts",
+	}
+	var keys []string
+	for _, obj := range all.Objects {
+		keys = append(keys, obj.Key)
+	}
+	if !slices.Equal(keys, wantKeys) {
+		t.Fatalf("keys = %q, want %q", keys, wantKeys)
+	}
+	segment := all.Objects[2]
+	sum := md5.Sum([]byte("segment data"))
+	if segment.Size != int64(len("segment data")) || segment.ContentType != "video/mp2t" || segment.MD5 != hex.EncodeToString(sum[:]) {
+		t.Errorf("segment = %+v", segment)
+	}
+
+	// Paging through yields every key exactly once
+	var paged []string
+	token := ""
+	for range len(wantKeys) {
+		page := list("?page_size=3&page_token=" + token)
+		if len(page.Objects) > 3 {
+			t.Fatalf("page has %d objects, want at most 3", len(page.Objects))
+		}
+		for _, obj := range page.Objects {
+			paged = append(paged, obj.Key)
+		}
+		if token = page.NextPageToken; token == "" {
+			break
+		}
+	}
+	if !slices.Equal(paged, wantKeys) {
+		t.Errorf("paged keys = %q, want %q", paged, wantKeys)
+	}
+}
+
+func TestObjectsHandlerUnknownVideo(t *testing.T) {
+	gormDB, _ := testdb.Open(t, nil)
+	id := uuid.NewString()
+	req := httptest.NewRequest(http.MethodGet, "/videos/"+id+"/objects", nil)
+	req.SetPathValue("id", id)
+	rec := httptest.NewRecorder()
+	objectsHandler(gormDB, nil).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound || decodeError(t, rec).Code != "video_not_found" {
+		t.Errorf("status = %d %s, want 404 video_not_found", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/devrayat000/video-process/models"
+	"github.com/devrayat000/video-process/pubsub"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// How long the admin overview is served from Redis
+	overviewCacheTTL = server_utils.GetEnvDuration("ADMIN_OVERVIEW_CACHE_TTL", 5*time.Second)
+	// Videos and failures listed in the admin overview
+	overviewRecentLimit = server_utils.GetEnvInt("ADMIN_OVERVIEW_RECENT", 20)
+)
+
+// Window the failure counts by category cover
+const overviewFailureWindow = 24 * time.Hour
+
+type overviewVideo struct {
+	ID              uuid.UUID               `json:"id"`
+	OriginalName    string                  `json:"original_name"`
+	Status          models.VideoStatus      `json:"status"`
+	ErrorMessage    *string                 `json:"error_message,omitempty"`
+	FailureCategory *models.FailureCategory `json:"failure_category,omitempty"`
+	CreatedAt       time.Time               `json:"created_at"`
+	UpdatedAt       time.Time               `json:"updated_at"`
+}
+
+type overviewResponse struct {
+	Stats          *statsResponse                   `json:"stats"`
+	Queue          *pubsub.QueueInfo                `json:"queue"`
+	RecentVideos   []overviewVideo                  `json:"recent_videos"`
+	RecentFailures []overviewVideo                  `json:"recent_failures"`
+	FailuresByType map[models.FailureCategory]int64 `json:"failures_by_category"`
+	GeneratedAt    time.Time                        `json:"generated_at"`
+}
+
+// computeOverview gathers GET /admin/overview. A Redis failure only leaves
+// the queue out, so the dashboard still shows the database side.
+func computeOverview(ctx context.Context, gormDB *gorm.DB) (*overviewResponse, error) {
+	stats, err := computeStats(ctx, gormDB)
+	if err != nil {
+		return nil, err
+	}
+
+	overview := &overviewResponse{
+		Stats:          stats,
+		FailuresByType: make(map[models.FailureCategory]int64),
+		GeneratedAt:    time.Now().UTC(),
+	}
+
+	if overview.Queue, err = pubsub.QueueStats(ctx); err != nil {
+		log.Printf("Failed to read queue state: %v", err)
+	}
+
+	db := gormDB.WithContext(ctx)
+	columns := []string{"id", "original_name", "status", "error_message", "failure_category", "created_at", "updated_at"}
+
+	overview.RecentVideos = []overviewVideo{}
+	err = db.Model(&models.Video{}).Select(columns).
+		Order("created_at DESC").
+		Limit(overviewRecentLimit).
+		Scan(&overview.RecentVideos).Error
+	if err != nil {
+		return nil, err
+	}
+
+	overview.RecentFailures = []overviewVideo{}
+	err = db.Model(&models.Video{}).Select(columns).
+		Where("status = ?", models.StatusFailed).
+		Order("updated_at DESC").
+		Limit(overviewRecentLimit).
+		Scan(&overview.RecentFailures).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var byCategory []struct {
+		Category models.FailureCategory
+		Count    int64
+	}
+	err = db.Model(&models.Video{}).
+		Select("coalesce(failure_category, ?) AS category, count(*) AS count", models.FailureEncodeError).
+		Where("status = ? AND updated_at >= ?", models.StatusFailed, time.Now().Add(-overviewFailureWindow)).
+		Group("category").
+		Scan(&byCategory).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range byCategory {
+		overview.FailuresByType[row.Category] = row.Count
+	}
+
+	return overview, nil
+}
+
+// adminOverviewHandler serves everything a status dashboard needs in one
+// response: aggregate stats, queue depth and consumers, and recent videos
+// and failures. It is cached for ADMIN_OVERVIEW_CACHE_TTL.
+func adminOverviewHandler(gormDB *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enableCors(&w)
+
+		if r.Method == "OPTIONS" {
+			return
+		}
+
+		if r.Method != "GET" {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+			return
+		}
+
+		if !requireAdmin(w, r) {
+			return
+		}
+
+		ctx := r.Context()
+
+		if overviewCacheTTL > 0 {
+			if cached, err := pubsub.GetCachedOverview(ctx); err == nil {
+				writeBody(w, r, "application/json", int64(len(cached)), bytes.NewReader(cached))
+				return
+			}
+		}
+
+		overview, err := computeOverview(ctx, gormDB)
+		if err != nil {
+			log.Printf("Failed to compute admin overview: %v", err)
+			writeError(w, http.StatusInternalServerError, "database_error", "Failed to compute overview")
+			return
+		}
+
+		data, err := json.Marshal(overview)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "encode_error", "Failed to encode overview")
+			return
+		}
+
+		if overviewCacheTTL > 0 {
+			if err := pubsub.CacheOverview(ctx, data, overviewCacheTTL); err != nil {
+				log.Printf("Failed to cache admin overview: %v", err)
+			}
+		}
+
+		writeBody(w, r, "application/json", int64(len(data)), bytes.NewReader(data))
+	}
+}
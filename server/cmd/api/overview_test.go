@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+// overviewTables answers the overview's own queries and hands everything
+// else to the /stats fixture
+func overviewTables(recent, failed [][]any, byCategory [][]any) testdb.Handler {
+	stats := statsTables(nil, nil)
+	columns := []string{"id", "original_name", "status", "error_message", "failure_category", "created_at", "updated_at"}
+	return func(q testdb.Query) testdb.Result {
+		switch {
+		case strings.Contains(q.SQL, "AS category"):
+			return testdb.Result{Columns: []string{"category", "count"}, Rows: byCategory}
+		case strings.Contains(q.SQL, "ORDER BY created_at DESC"):
+			return testdb.Result{Columns: columns, Rows: recent}
+		case strings.Contains(q.SQL, "ORDER BY updated_at DESC"):
+			return testdb.Result{Columns: columns, Rows: failed}
+		}
+		return stats(q)
+	}
+}
+
+// overviewRequest is an authorised GET /admin/overview
+func overviewRequest() *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/admin/overview", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	return req
+}
+
+func TestAdminOverviewHandler(t *testing.T) {
+	setVar(t, &adminToken, "s3cret")
+	setVar(t, &overviewCacheTTL, 5*time.Second)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	processing, failed := uuid.New(), uuid.New()
+	recent := [][]any{
+		{processing.String(), "b.mp4", string(models.StatusProcessing), nil, nil, now, now},
+		{failed.String(), "a.mp4", string(models.StatusFailed), "ffmpeg exited", string(models.FailureEncodeError), now.Add(-time.Minute), now},
+	}
+	gormDB, _ := testdb.Open(t, overviewTables(recent, recent[1:], [][]any{
+		{string(models.FailureEncodeError), int64(3)},
+		{string(models.FailureUnsupportedInput), int64(1)},
+	}))
+	rdb := useRedis(t, func(cmd []string) any {
+		switch cmd[0] {
+		case "get":
+			return nil
+		case "xlen":
+			return int64(4)
+		case "xinfo":
+			if cmd[1] == "groups" {
+				return []any{[]any{"name", "video-workers", "consumers", int64(1), "pending", int64(1), "last-delivered-id", "1-0", "entries-read", int64(1), "lag", int64(2)}}
+			}
+			return []any{[]any{"name", "worker-1", "pending", int64(1), "idle", int64(250), "inactive", int64(250)}}
+		}
+		return "OK"
+	})
+
+	rec := httptest.NewRecorder()
+	adminOverviewHandler(gormDB)(rec, overviewRequest())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	var overview overviewResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &overview); err != nil {
+		t.Fatal(err)
+	}
+	if overview.Stats == nil {
+		t.Error("stats missing from the overview")
+	}
+	if q := overview.Queue; q == nil || q.Length != 4 || q.Waiting != 2 || q.Pending != 1 || q.ActiveConsumers != 1 {
+		t.Errorf("queue = %+v, want length 4, 2 waiting, 1 pending, 1 active consumer", q)
+	}
+	if len(overview.RecentVideos) != 2 || overview.RecentVideos[0].ID != processing || overview.RecentVideos[1].ID != failed {
+		t.Errorf("recent_videos = %+v, want the processing then the failed video", overview.RecentVideos)
+	}
+	if len(overview.RecentFailures) != 1 {
+		t.Fatalf("recent_failures = %+v, want the failed video", overview.RecentFailures)
+	}
+	if got := overview.RecentFailures[0]; got.ErrorMessage == nil || *got.ErrorMessage != "ffmpeg exited" ||
+		got.FailureCategory == nil || *got.FailureCategory != models.FailureEncodeError {
+		t.Errorf("recent failure = %+v, want its error message and category", got)
+	}
+	want := map[models.FailureCategory]int64{models.FailureEncodeError: 3, models.FailureUnsupportedInput: 1}
+	if len(overview.FailuresByType) != len(want) {
+		t.Errorf("failures_by_category = %v, want %v", overview.FailuresByType, want)
+	}
+	for category, n := range want {
+		if overview.FailuresByType[category] != n {
+			t.Errorf("failures_by_category[%s] = %d, want %d", category, overview.FailuresByType[category], n)
+		}
+	}
+
+	sets := rdb.Named("SET")
+	if len(sets) != 1 || sets[0][1] != "stats:admin-overview" || sets[0][2] != rec.Body.String() || !slices.Equal(sets[0][3:], []string{"ex", "5"}) {
+		t.Errorf("SET = %q, want the response cached for 5s", sets)
+	}
+}
+
+func TestAdminOverviewWithoutQueue(t *testing.T) {
+	setVar(t, &adminToken, "s3cret")
+	setVar(t, &overviewCacheTTL, 0)
+	gormDB, _ := testdb.Open(t, overviewTables(nil, nil, nil))
+	useRedis(t, func(cmd []string) any { return errors.New("LOADING Redis is loading the dataset") })
+
+	rec := httptest.NewRecorder()
+	adminOverviewHandler(gormDB)(rec, overviewRequest())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if string(body["queue"]) != "null" {
+		t.Errorf("queue = %s, want null when Redis is unavailable", body["queue"])
+	}
+	for _, key := range []string{"recent_videos", "recent_failures"} {
+		if string(body[key]) != "[]" {
+			t.Errorf("%s = %s, want an empty list", key, body[key])
+		}
+	}
+}
+
+func TestAdminOverviewServesCache(t *testing.T) {
+	setVar(t, &adminToken, "s3cret")
+	const cached = `{"queue":null}`
+	gormDB, db := testdb.Open(t, nil)
+	useRedis(t, func(cmd []string) any { return cached })
+
+	rec := httptest.NewRecorder()
+	adminOverviewHandler(gormDB)(rec, overviewRequest())
+	if rec.Code != http.StatusOK || rec.Body.String() != cached {
+		t.Errorf("response = %d %s, want the cached body", rec.Code, rec.Body)
+	}
+	if queries := db.Queries(); len(queries) != 0 {
+		t.Errorf("cache hit still queried the database: %v", queries)
+	}
+}
+
+func TestAdminOverviewRequiresAdmin(t *testing.T) {
+	setVar(t, &adminToken, "s3cret")
+	gormDB, db := testdb.Open(t, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/overview", nil)
+	rec := httptest.NewRecorder()
+	adminOverviewHandler(gormDB)(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", rec.Code)
+	}
+	if queries := db.Queries(); len(queries) != 0 {
+		t.Errorf("unauthorised request queried the database: %v", queries)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// listCursor marks the last row of a page. Rows are ordered newest first by
+// (created_at, id), so rows inserted while paging never shift later pages.
+type listCursor struct {
+	CreatedAt time.Time `json:"c"`
+	ID        uuid.UUID `json:"i"`
+}
+
+// encodeCursor returns an opaque token for the position after video
+func encodeCursor(video models.Video) string {
+	data, _ := json.Marshal(listCursor{CreatedAt: video.CreatedAt, ID: video.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(token string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var cursor listCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == uuid.Nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &cursor, nil
+}
+
+// listVideosAfter returns one page in keyset order plus the cursor for the
+// next page, which is empty on the last page.
+func listVideosAfter(db *gorm.DB, cursor *listCursor, limit int) ([]models.Video, string, error) {
+	query := db.Model(&models.Video{}).Order("created_at DESC, id DESC").Limit(limit + 1)
+	if cursor != nil {
+		query = query.Where("(created_at, id) < (?, ?)", cursor.CreatedAt, cursor.ID)
+	}
+
+	var videos []models.Video
+	if err := query.Find(&videos).Error; err != nil {
+		return nil, "", err
+	}
+
+	// The extra row only tells us whether another page exists
+	next := ""
+	if len(videos) > limit {
+		videos = videos[:limit]
+		next = encodeCursor(videos[len(videos)-1])
+	}
+
+	return videos, next, nil
+}
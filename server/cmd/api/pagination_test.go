@@ -0,0 +1,153 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	video := models.Video{ID: uuid.New(), CreatedAt: time.Date(2026, 3, 1, 12, 30, 0, 123456000, time.UTC)}
+	token := encodeCursor(video)
+
+	if strings.Contains(token, video.ID.String()) || strings.ContainsAny(token, "+/=") {
+		t.Errorf("cursor %q is not an opaque URL-safe token", token)
+	}
+	cursor, err := decodeCursor(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor.ID != video.ID || !cursor.CreatedAt.Equal(video.CreatedAt) {
+		t.Errorf("decoded cursor = %+v, want %s at %s", cursor, video.ID, video.CreatedAt)
+	}
+}
+
+func TestDecodeCursorRejectsGarbage(t *testing.T) {
+	for _, token := range []string{"not base64!", "bm90IGpzb24", "e30"} {
+		if _, err := decodeCursor(token); err == nil {
+			t.Errorf("decodeCursor(%q) accepted a bad token", token)
+		}
+	}
+}
+
+// videoTable keeps videos in memory and answers listVideosAfter's keyset
+// query the way Postgres would
+type videoTable struct {
+	mu     sync.Mutex
+	videos []models.Video
+}
+
+func (vt *videoTable) insert(createdAt time.Time) {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+	vt.videos = append(vt.videos, models.Video{ID: uuid.New(), CreatedAt: createdAt, Status: models.StatusCompleted})
+}
+
+// newer orders videos by (created_at, id) descending
+func newer(a, b models.Video) int {
+	if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+		return c
+	}
+	return cmp.Compare(b.ID.String(), a.ID.String())
+}
+
+func (vt *videoTable) handle(q testdb.Query) testdb.Result {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+
+	rows := slices.Clone(vt.videos)
+	slices.SortFunc(rows, newer)
+	args := q.Args
+	if strings.Contains(q.SQL, "(created_at, id) < ($1, $2)") {
+		after := models.Video{CreatedAt: args[0].(time.Time), ID: uuid.MustParse(fmt.Sprint(args[1]))}
+		rows = slices.DeleteFunc(rows, func(v models.Video) bool { return newer(v, after) <= 0 })
+		args = args[2:]
+	}
+	if limit := int(args[0].(int64)); len(rows) > limit {
+		rows = rows[:limit]
+	}
+
+	result := testdb.Result{Columns: []string{"id", "status", "created_at"}}
+	for _, v := range rows {
+		result.Rows = append(result.Rows, []any{v.ID.String(), string(v.Status), v.CreatedAt})
+	}
+	return result
+}
+
+func TestListVideosAfterVisitsEachRowOnce(t *testing.T) {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	table := &videoTable{}
+	for i := range 7 {
+		table.insert(base.Add(time.Duration(i) * time.Minute))
+	}
+	// Rows sharing a timestamp are split by id
+	table.insert(base.Add(3 * time.Minute))
+	table.insert(base.Add(3 * time.Minute))
+	want := len(table.videos)
+
+	gormDB, db := testdb.Open(t, table.handle)
+
+	seen := map[uuid.UUID]int{}
+	var cursor *listCursor
+	for page := 0; ; page++ {
+		if page > want {
+			t.Fatal("pagination did not terminate")
+		}
+		videos, next, err := listVideosAfter(gormDB, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(videos) > 2 {
+			t.Fatalf("page %d has %d rows, want at most 2", page, len(videos))
+		}
+		for _, v := range videos {
+			seen[v.ID]++
+		}
+		// New uploads land at the head of the list while the client pages
+		table.insert(time.Now())
+
+		if next == "" {
+			break
+		}
+		if cursor, err = decodeCursor(next); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(seen) != want {
+		t.Errorf("visited %d rows, want the %d that existed when paging started", len(seen), want)
+	}
+	for id, n := range seen {
+		if n != 1 {
+			t.Errorf("row %s returned %d times", id, n)
+		}
+	}
+	for _, q := range db.Queries() {
+		if !strings.Contains(q.SQL, "ORDER BY created_at DESC, id DESC") {
+			t.Errorf("query %q is not in keyset order", q.SQL)
+		}
+	}
+}
+
+func TestListVideosAfterLastPage(t *testing.T) {
+	table := &videoTable{}
+	table.insert(time.Now())
+	table.insert(time.Now())
+	gormDB, _ := testdb.Open(t, table.handle)
+
+	videos, next, err := listVideosAfter(gormDB, nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(videos) != 2 || next != "" {
+		t.Errorf("got %d videos, next %q; want 2 and no next cursor", len(videos), next)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/devrayat000/video-process/models"
+	"gorm.io/gorm"
+)
+
+// playlistHandler proxies the text parts of a video's HLS output (playlists
+// and subtitles) from the output prefix, gzipped when the client accepts it.
+// Segments are not proxied; players fetch them from storage directly.
+func playlistHandler(gormDB *gorm.DB, gcsClient *storage.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enableCors(&w)
+
+		if r.Method == "OPTIONS" {
+			return
+		}
+
+		if r.Method != "GET" {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+			return
+		}
+
+		name := path.Clean("/" + r.PathValue("path"))[1:]
+		if name == "" || strings.HasPrefix(name, "..") {
+			writeError(w, http.StatusBadRequest, "invalid_path", "Invalid playlist path")
+			return
+		}
+		if ext := path.Ext(name); ext != ".m3u8" && ext != ".vtt" {
+			writeError(w, http.StatusBadRequest, "invalid_path", "Only playlists and subtitles are served here")
+			return
+		}
+
+		ctx := r.Context()
+		videoID, ok := parseVideoID(w, r.PathValue("id"))
+		if !ok {
+			return
+		}
+
+		video, err := gorm.G[models.Video](gormDB).Where("id = ?", videoID).First(ctx)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "video_not_found", "Video not found")
+			return
+		}
+
+		key := video.OutputPrefix() + name
+		reader, err := gcsClient.Bucket(outputBucketOf(video)).Object(key).NewReader(ctx)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			writeError(w, http.StatusNotFound, "playlist_not_found", "Playlist not found")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to read playlist %s: %v", key, err)
+			writeError(w, http.StatusInternalServerError, "storage_error", "Failed to read playlist")
+			return
+		}
+		defer reader.Close()
+
+		contentType := reader.Attrs.ContentType
+		if contentType == "" {
+			contentType = "application/vnd.apple.mpegurl"
+			if path.Ext(name) == ".vtt" {
+				contentType = "text/vtt"
+			}
+		}
+
+		w.Header().Set("Cache-Control", "no-cache")
+		writeBody(w, r, contentType, reader.Attrs.Size, reader)
+	}
+}
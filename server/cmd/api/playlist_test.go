@@ -0,0 +1,96 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/google/uuid"
+)
+
+// putObject stores an object through the client so it keeps a content type
+func putObject(t *testing.T, client *storage.Client, key, contentType, data string) {
+	t.Helper()
+	w := client.Bucket(gcsBucket).Object(key).NewWriter(context.Background())
+	w.ContentType = contentType
+	io.WriteString(w, data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlaylistHandler(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	id := uuid.New()
+	playlist := "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=2800000\nstream_0/playlist.m3u8\n"
+	subtitles := "WEBVTT\n\n00:00.000 --> 00:01.000\nhello\n"
+
+	tests := []struct {
+		name     string
+		path     string
+		gzip     bool
+		wantCode int
+		wantType string
+		wantBody string
+	}{
+		{name: "gzip client", path: "master.m3u8", gzip: true, wantCode: http.StatusOK, wantType: "application/vnd.apple.mpegurl", wantBody: playlist},
+		{name: "plain client", path: "master.m3u8", wantCode: http.StatusOK, wantType: "application/vnd.apple.mpegurl", wantBody: playlist},
+		{name: "subtitles", path: "subs/en.vtt", gzip: true, wantCode: http.StatusOK, wantType: "text/vtt", wantBody: subtitles},
+		{name: "missing playlist", path: "stream_9/playlist.m3u8", wantCode: http.StatusNotFound},
+		{name: "segments not proxied", path: "stream_0/segment_000.ts", wantCode: http.StatusBadRequest},
+		{name: "path traversal", path: "../../other/processed/master.m3u8", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gcsClient, _ := testgcs.Start(t)
+			putObject(t, gcsClient, id.String()+"/processed/master.m3u8", "application/vnd.apple.mpegurl", playlist)
+			putObject(t, gcsClient, id.String()+"/processed/subs/en.vtt", "text/vtt", subtitles)
+			gormDB, _ := testdb.Open(t, func(q testdb.Query) testdb.Result {
+				return testdb.Result{Columns: []string{"id"}, Rows: [][]any{{id.String()}}}
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/videos/"+id.String()+"/hls/"+tt.path, nil)
+			req.SetPathValue("id", id.String())
+			req.SetPathValue("path", tt.path)
+			if tt.gzip {
+				req.Header.Set("Accept-Encoding", "gzip")
+			}
+			rec := httptest.NewRecorder()
+			playlistHandler(gormDB, gcsClient).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", ct, tt.wantType)
+			}
+
+			var body io.Reader = rec.Body
+			if tt.gzip {
+				if rec.Header().Get("Content-Encoding") != "gzip" {
+					t.Fatalf("Content-Encoding = %q, want gzip", rec.Header().Get("Content-Encoding"))
+				}
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = zr
+			} else if rec.Header().Get("Content-Encoding") != "" {
+				t.Errorf("plain client got Content-Encoding %q", rec.Header().Get("Content-Encoding"))
+			}
+			data, _ := io.ReadAll(body)
+			if string(data) != tt.wantBody {
+				t.Errorf("body = %q, want %q", data, tt.wantBody)
+			}
+		})
+	}
+}
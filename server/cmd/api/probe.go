@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/devrayat000/video-process/models"
+	"gorm.io/gorm"
+)
+
+// probeHandler returns the raw ffprobe JSON the worker recorded for a video's
+// source, for working out why it got the renditions it did.
+func probeHandler(readDB *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enableCors(&w)
+
+		if r.Method == "OPTIONS" {
+			return
+		}
+
+		if r.Method != "GET" {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+			return
+		}
+
+		videoID, ok := parseVideoID(w, r.PathValue("id"))
+		if !ok {
+			return
+		}
+
+		video, err := gorm.G[models.Video](readDB).Select("id", "probe_json").Where("id = ?", videoID).First(r.Context())
+		if err != nil {
+			writeError(w, http.StatusNotFound, "video_not_found", "Video not found")
+			return
+		}
+		if video.ProbeJSON == nil {
+			writeError(w, http.StatusNotFound, "probe_not_found", "Source has not been probed yet")
+			return
+		}
+
+		writeBody(w, r, "application/json", int64(len(*video.ProbeJSON)), strings.NewReader(*video.ProbeJSON))
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/google/uuid"
+)
+
+func TestProbeHandler(t *testing.T) {
+	id := uuid.New()
+	probe := `{"streams":[{"codec_type":"video","width":1280,"height":720}],"format":{"duration":"2.0"}}`
+
+	tests := []struct {
+		name     string
+		rows     [][]any
+		wantCode int
+		wantErr  string
+	}{
+		{"probed", [][]any{{id.String(), probe}}, http.StatusOK, ""},
+		{"not probed yet", [][]any{{id.String(), nil}}, http.StatusNotFound, "probe_not_found"},
+		{"unknown video", nil, http.StatusNotFound, "video_not_found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDB, _ := testdb.Open(t, func(q testdb.Query) testdb.Result {
+				return testdb.Result{Columns: []string{"id", "probe_json"}, Rows: tt.rows}
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/videos/"+id.String()+"/probe", nil)
+			req.SetPathValue("id", id.String())
+			rec := httptest.NewRecorder()
+			probeHandler(gormDB).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantErr != "" {
+				if got := decodeError(t, rec).Code; got != tt.wantErr {
+					t.Errorf("error code = %q, want %q", got, tt.wantErr)
+				}
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			if rec.Body.String() != probe {
+				t.Errorf("body = %s, want the stored probe", rec.Body)
+			}
+		})
+	}
+}
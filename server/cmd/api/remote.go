@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/devrayat000/video-process/models"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// remoteJobRequest is the body of POST /jobs/remote
+type remoteJobRequest struct {
+	// VideoID is generated when omitted
+	VideoID      uuid.UUID          `json:"video_id"`
+	SourceURL    string             `json:"source_url"`
+	OriginalName string             `json:"original_name"`
+	Renditions   []models.Rendition `json:"renditions,omitempty"`
+	Profile      string             `json:"profile,omitempty"`
+	OutputBucket string             `json:"output_bucket,omitempty"`
+	StartSeconds float64            `json:"start_seconds,omitempty"`
+	EndSeconds   float64            `json:"end_seconds,omitempty"`
+	// ImageSequence treats the source as a ZIP of frames
+	ImageSequence *models.ImageSequence `json:"image_sequence,omitempty"`
+	Webhook       *models.Webhook       `json:"webhook,omitempty"`
+}
+
+// checkRemoteReachable sends a HEAD request to confirm the source exists
+func checkRemoteReachable(ctx context.Context, sourceURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, sourceURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := server_utils.RemoteHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("source returned %s", resp.Status)
+	}
+	return nil
+}
+
+// remoteJobHandler queues a job whose source is already hosted elsewhere.
+// The URL must pass the SSRF guard and answer a HEAD request.
+func remoteJobHandler(gormDB *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enableCors(&w)
+
+		if r.Method == "OPTIONS" {
+			return
+		}
+
+		if r.Method != "POST" {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+			return
+		}
+
+		if !limitBody(w, r, maxJSONBodyBytes) {
+			return
+		}
+
+		var req remoteJobRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			if isBodyTooLarge(err) {
+				writeBodyTooLarge(w, maxJSONBodyBytes)
+				return
+			}
+			writeError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON")
+			return
+		}
+
+		if req.SourceURL == "" {
+			writeError(w, http.StatusBadRequest, "source_url_required", "source_url is required")
+			return
+		}
+
+		if err := server_utils.CheckRemoteURL(r.Context(), req.SourceURL); err != nil {
+			log.Printf("Rejected remote source %q: %v", req.SourceURL, err)
+			writeError(w, http.StatusBadRequest, "unsafe_source_url", err.Error())
+			return
+		}
+
+		if err := checkRemoteReachable(r.Context(), req.SourceURL); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, "source_unreachable", fmt.Sprintf("Source is not reachable: %v", err))
+			return
+		}
+
+		if req.VideoID == uuid.Nil {
+			req.VideoID = uuid.New()
+		}
+		if req.OriginalName == "" {
+			if u, err := url.Parse(req.SourceURL); err == nil {
+				req.OriginalName = path.Base(u.Path)
+			}
+		}
+
+		submitJob(w, r, gormDB, models.VideoJob{
+			VideoID:       req.VideoID,
+			S3Path:        req.SourceURL,
+			OriginalName:  req.OriginalName,
+			Renditions:    req.Renditions,
+			Profile:       req.Profile,
+			OutputBucket:  req.OutputBucket,
+			StartSeconds:  req.StartSeconds,
+			EndSeconds:    req.EndSeconds,
+			ImageSequence: req.ImageSequence,
+			Webhook:       req.Webhook,
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testredis"
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// useRemoteOrigin sends every remote source request to an httptest server
+// answering with status, whatever public address the URL names
+func useRemoteOrigin(t *testing.T, status int) *[]string {
+	t.Helper()
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	client := *server_utils.RemoteHTTPClient
+	client.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+		},
+	}
+	setVar(t, &server_utils.RemoteHTTPClient, &client)
+	return &requests
+}
+
+func TestCheckRemoteReachable(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"ok", http.StatusOK, false},
+		{"no content", http.StatusNoContent, false},
+		{"missing", http.StatusNotFound, true},
+		{"forbidden", http.StatusForbidden, true},
+		{"origin error", http.StatusBadGateway, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := useRemoteOrigin(t, tt.status)
+			err := checkRemoteReachable(context.Background(), "http://93.184.216.34/v.mp4")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkRemoteReachable error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(*requests) != 1 || (*requests)[0] != "HEAD /v.mp4" {
+				t.Errorf("origin saw %q, want one HEAD", *requests)
+			}
+		})
+	}
+}
+
+func TestRemoteJobHandler(t *testing.T) {
+	setVar(t, &dedupWindow, 0)
+
+	tests := []struct {
+		name       string
+		body       string
+		origin     int
+		wantCode   int
+		wantError  string
+		wantOrigin bool
+		wantQueued bool
+	}{
+		{
+			name:     "missing url",
+			body:     `{}`,
+			wantCode: http.StatusBadRequest, wantError: "source_url_required",
+		},
+		{
+			name:     "loopback",
+			body:     `{"source_url":"http://127.0.0.1:8080/v.mp4"}`,
+			wantCode: http.StatusBadRequest, wantError: "unsafe_source_url",
+		},
+		{
+			name:     "cloud metadata",
+			body:     `{"source_url":"http://169.254.169.254/latest/meta-data/"}`,
+			wantCode: http.StatusBadRequest, wantError: "unsafe_source_url",
+		},
+		{
+			name:     "file scheme",
+			body:     `{"source_url":"file:///etc/passwd"}`,
+			wantCode: http.StatusBadRequest, wantError: "unsafe_source_url",
+		},
+		{
+			name:     "unreachable",
+			body:     `{"source_url":"http://93.184.216.34/v.mp4"}`,
+			origin:   http.StatusNotFound,
+			wantCode: http.StatusUnprocessableEntity, wantError: "source_unreachable", wantOrigin: true,
+		},
+		{
+			name:     "unknown profile",
+			body:     `{"source_url":"http://93.184.216.34/media/clip.mp4","profile":"tv"}`,
+			origin:   http.StatusOK,
+			wantCode: http.StatusBadRequest, wantError: "unknown_profile", wantOrigin: true,
+		},
+		{
+			name:     "unknown webhook event",
+			body:     `{"source_url":"http://93.184.216.34/media/clip.mp4","webhook":{"url":"https://hooks.example.com/v","events":["deleted"]}}`,
+			origin:   http.StatusOK,
+			wantCode: http.StatusBadRequest, wantError: "invalid_webhook", wantOrigin: true,
+		},
+		{
+			name:     "queued",
+			body:     `{"source_url":"http://93.184.216.34/media/clip.mp4"}`,
+			origin:   http.StatusOK,
+			wantCode: http.StatusOK, wantOrigin: true, wantQueued: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := useRemoteOrigin(t, tt.origin)
+			useRedis(t, func(cmd []string) any {
+				if cmd[0] == "xadd" {
+					return "1-0"
+				}
+				return testredis.Status("OK")
+			})
+			gormDB, db := testdb.Open(t, outboxHandler(func(q testdb.Query) testdb.Result {
+				return testdb.Result{RowsAffected: 1}
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/jobs/remote", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			remoteJobHandler(gormDB).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantError != "" {
+				if body := decodeError(t, rec); body.Code != tt.wantError {
+					t.Errorf("error code = %q, want %q", body.Code, tt.wantError)
+				}
+			}
+			if got := len(*requests) > 0; got != tt.wantOrigin {
+				t.Errorf("origin contacted = %v, want %v", got, tt.wantOrigin)
+			}
+
+			inserts := db.Matching(`INSERT INTO "videos"`)
+			if !tt.wantQueued {
+				if len(inserts) != 0 {
+					t.Errorf("rejected source created %d video rows", len(inserts))
+				}
+				return
+			}
+			if len(inserts) != 1 {
+				t.Fatalf("created %d video rows, want 1", len(inserts))
+			}
+			var hasSource, hasName bool
+			for _, arg := range inserts[0].Args {
+				hasSource = hasSource || arg == "http://93.184.216.34/media/clip.mp4"
+				hasName = hasName || arg == "clip.mp4"
+			}
+			if !hasSource || !hasName {
+				t.Errorf("video row args %v should carry the source URL and its file name", inserts[0].Args)
+			}
+		})
+	}
+}
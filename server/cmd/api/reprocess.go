@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/devrayat000/video-process/models"
+	"github.com/devrayat000/video-process/outbox"
+	"github.com/devrayat000/video-process/pubsub"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"gorm.io/gorm"
+)
+
+// With VERSIONED_OUTPUT, each reprocess writes under a new
+// {id}/processed/v{n}/ prefix, so stored URLs change and CDNs can't serve
+// copies of the previous output
+var versionedOutput = server_utils.GetEnvBool("VERSIONED_OUTPUT", false)
+
+// errVideoQueued means another request queued the video first
+var errVideoQueued = errors.New("video already queued")
+
+// reprocessHandler re-transcodes an existing video from its original source,
+// optionally with a custom rendition ladder. Previous output and resolution
+// rows are removed before the new job is queued.
+func reprocessHandler(gormDB *gorm.DB, gcsClient *storage.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enableCors(&w)
+
+		if r.Method == "OPTIONS" {
+			return
+		}
+
+		if r.Method != "POST" {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+			return
+		}
+
+		videoID, ok := parseVideoID(w, r.PathValue("id"))
+		if !ok {
+			return
+		}
+		ctx := r.Context()
+
+		video, err := gorm.G[models.Video](gormDB).Where("id = ?", videoID).First(ctx)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "video_not_found", "Video not found")
+			return
+		}
+
+		if video.Status == models.StatusStarted || video.Status == models.StatusProcessing {
+			writeError(w, http.StatusConflict, "video_processing", "Video is currently being processed")
+			return
+		}
+		if video.Status == models.StatusWaiting {
+			writeError(w, http.StatusConflict, "video_queued", "Video is already queued")
+			return
+		}
+
+		// The body is optional; an empty one reprocesses with the default ladder
+		var req struct {
+			Renditions []models.Rendition `json:"renditions"`
+			// Force re-probes the source instead of reusing cached metadata
+			Force bool `json:"force"`
+		}
+		if !limitBody(w, r, maxJSONBodyBytes) {
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			if isBodyTooLarge(err) {
+				writeBodyTooLarge(w, maxJSONBodyBytes)
+				return
+			}
+			writeError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON")
+			return
+		}
+
+		for i := range req.Renditions {
+			if err := normalizeRendition(&req.Renditions[i]); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid_rendition", err.Error())
+				return
+			}
+		}
+
+		if video.SourceDeleted {
+			writeError(w, http.StatusConflict, "source_deleted", "Source was deleted after processing")
+			return
+		}
+
+		exists, err := sourceExists(ctx, gcsClient, video.SourceBucket, video.S3Path)
+		if err != nil {
+			log.Printf("Failed to check source for %s: %v", video.ID, err)
+			writeError(w, http.StatusInternalServerError, "storage_error", "Failed to check source")
+			return
+		}
+		if !exists {
+			writeError(w, http.StatusConflict, "source_missing", "Source object no longer exists")
+			return
+		}
+
+		job := video.Job()
+		job.Renditions = req.Renditions
+		job.ForceProbe = req.Force
+		// Without versioning the output goes back to the unversioned prefix
+		job.OutputVersion = 0
+		if versionedOutput {
+			job.OutputVersion = video.OutputVersion + 1
+		}
+
+		// Claim the row, clear the previous output and queue the job together
+		var entry *models.OutboxEntry
+		var storageErr error
+		err = gormDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			// Struct updates skip nil fields, so reset with a map. The status
+			// condition stops a concurrent reprocess from queueing it twice.
+			result := tx.Model(&models.Video{}).Where("id = ? AND status = ?", video.ID, video.Status).Updates(map[string]any{
+				"status":              models.StatusWaiting,
+				"error_message":       nil,
+				"failure_category":    nil,
+				"master_playlist_key": nil,
+				"master_playlist_url": nil,
+				"progressive_key":     nil,
+				"progressive_url":     nil,
+				"completed_at":        nil,
+				"encode_fallback":     false,
+				"job_stream_id":       nil,
+				"output_version":      job.OutputVersion,
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errVideoQueued
+			}
+
+			if _, err := gorm.G[models.VideoResolution](tx).Where("video_id = ?", video.ID).Delete(ctx); err != nil {
+				return fmt.Errorf("failed to delete resolutions: %w", err)
+			}
+			// Caption files go with the output prefix
+			if _, err := gorm.G[models.VideoSubtitle](tx).Where("video_id = ?", video.ID).Delete(ctx); err != nil {
+				return fmt.Errorf("failed to delete subtitles: %w", err)
+			}
+
+			// Only the request that claimed the row removes the previous
+			// output, and before the job can reach a worker. The unversioned
+			// prefix holds every version.
+			prefix := models.OutputPrefix(video.ID, 0)
+			deleted, err := server_utils.DeletePrefix(ctx, gcsClient.Bucket(outputBucketOf(video)), prefix)
+			if err != nil {
+				storageErr = err
+				return err
+			}
+			log.Printf("Deleted %d objects under %s", deleted, prefix)
+
+			entry, err = outbox.Add(ctx, tx, job)
+			return err
+		})
+		if errors.Is(err, errVideoQueued) {
+			writeError(w, http.StatusConflict, "video_queued", "Video is already queued")
+			return
+		}
+		if storageErr != nil {
+			log.Printf("Failed to delete previous output for %s: %v", video.ID, storageErr)
+			writeError(w, http.StatusInternalServerError, "storage_error", "Failed to delete previous output")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to reset video %s: %v", video.ID, err)
+			writeError(w, http.StatusInternalServerError, "database_error", "Failed to reset video")
+			return
+		}
+
+		// Replace the cached terminal progress so SSE clients don't see a stale state
+		pubsub.PublishProgress(models.ProcessingProgress{
+			VideoID:   video.ID,
+			Status:    models.StatusWaiting,
+			Timestamp: time.Now(),
+		})
+
+		resp := map[string]string{"status": "queued", "id": job.VideoID.String()}
+		if streamID, err := outbox.Dispatch(ctx, gormDB, entry); err != nil {
+			log.Printf("Failed to enqueue job, left in outbox: %s", err)
+		} else {
+			log.Printf(" [x] Sent Reprocess Job: %s", job.VideoID)
+			if streamID != "" {
+				resp["stream_id"] = streamID
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// normalizeRendition validates a client-supplied rendition and fills in
+// rate-control values derived from the target bitrate when omitted.
+func normalizeRendition(r *models.Rendition) error {
+	if r.Height <= 0 || r.Height > 4320 {
+		return fmt.Errorf("rendition height must be between 1 and 4320")
+	}
+	if r.Bitrate <= 0 {
+		return fmt.Errorf("rendition bitrate must be positive")
+	}
+	if r.MaxRate == 0 {
+		r.MaxRate = r.Bitrate * 107 / 100
+	}
+	if r.BufSize == 0 {
+		r.BufSize = r.Bitrate * 3 / 2
+	}
+	if r.AudioRate == 0 {
+		r.AudioRate = 128
+	}
+	if r.MaxRate < r.Bitrate || r.BufSize < 0 || r.AudioRate < 0 {
+		return fmt.Errorf("invalid rate control values for %dp rendition", r.Height)
+	}
+	return r.ValidateExtraArgs()
+}
+
+// sourceExists checks that a video's original source can still be read. GCS
+// objects are checked directly; other URLs fall back to a HEAD request. Only
+// a definite not-found is false; refused URLs and outages are errors.
+func sourceExists(ctx context.Context, gcsClient *storage.Client, sourceBucket, sourcePath string) (bool, error) {
+	bucketName, key, ok := sourceBucket, strings.TrimPrefix(sourcePath, "/"), sourceBucket != ""
+	if !ok {
+		bucketName, key, ok = server_utils.ParseObjectURL(sourcePath)
+	}
+	if ok {
+		_, err := gcsClient.Bucket(bucketName).Object(key).Attrs(ctx)
+		if errors.Is(err, storage.ErrObjectNotExist) || errors.Is(err, storage.ErrBucketNotExist) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	if err := server_utils.CheckRemoteURL(ctx, sourcePath); err != nil {
+		return false, fmt.Errorf("refusing to check source %q: %w", sourcePath, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, sourcePath, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := server_utils.RemoteHTTPClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	// Only a definite answer that the source is gone counts as missing
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return false, nil
+	}
+	if resp.StatusCode >= 400 {
+		return false, fmt.Errorf("source check returned %s", resp.Status)
+	}
+	return true, nil
+}
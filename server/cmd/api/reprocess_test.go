@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/internal/testredis"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestReprocessHandler(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	id := uuid.New()
+	source := "uploads/" + id.String() + ".mp4"
+	output := []string{
+		id.String() + "/processed/master.m3u8",
+		id.String() + "/processed/720p/seg0.ts",
+	}
+
+	tests := []struct {
+		name          string
+		status        models.VideoStatus
+		sourceMissing bool
+		sourceDeleted bool
+		bucketMode    bool
+		claimLost     bool
+		body          string
+		wantCode      int
+		wantRequeue   bool
+	}{
+		{name: "completed video is requeued", status: models.StatusCompleted, wantCode: http.StatusOK, wantRequeue: true},
+		{name: "bucket and key source", status: models.StatusCompleted, bucketMode: true, wantCode: http.StatusOK, wantRequeue: true},
+		{name: "missing bucket and key source", status: models.StatusCompleted, bucketMode: true, sourceMissing: true, wantCode: http.StatusConflict},
+		{name: "failed video is requeued", status: models.StatusFailed, wantCode: http.StatusOK, wantRequeue: true},
+		{
+			name:        "custom ladder",
+			status:      models.StatusCompleted,
+			body:        `{"renditions":[{"height":480,"bitrate":1000}]}`,
+			wantCode:    http.StatusOK,
+			wantRequeue: true,
+		},
+		{name: "forced probe", status: models.StatusCompleted, body: `{"force":true}`, wantCode: http.StatusOK, wantRequeue: true},
+		{name: "missing source", status: models.StatusCompleted, sourceMissing: true, wantCode: http.StatusConflict},
+		{name: "source deleted after processing", status: models.StatusCompleted, sourceDeleted: true, wantCode: http.StatusConflict},
+		{name: "queued video", status: models.StatusWaiting, wantCode: http.StatusConflict},
+		{name: "processing video", status: models.StatusProcessing, wantCode: http.StatusConflict},
+		{name: "queued by a concurrent request", status: models.StatusCompleted, claimLost: true, wantCode: http.StatusConflict},
+		{name: "invalid ladder", status: models.StatusCompleted, body: `{"renditions":[{"height":480}]}`, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gcsClient, store := testgcs.Start(t)
+			if !tt.sourceMissing {
+				store.Put("videos", source, []byte("source"))
+			}
+			for _, name := range output {
+				store.Put("videos", name, []byte("output"))
+			}
+
+			before := store.Names("videos")
+			s3Path, sourceBucket := "gs://videos/"+source, ""
+			if tt.bucketMode {
+				s3Path, sourceBucket = source, "videos"
+			}
+
+			rdb := useRedis(t, func(cmd []string) any {
+				if cmd[0] == "xadd" {
+					return "1-0"
+				}
+				return testredis.Status("OK")
+			})
+			gormDB, db := testdb.Open(t, outboxHandler(func(q testdb.Query) testdb.Result {
+				if strings.HasPrefix(q.SQL, "SELECT") {
+					return testdb.Result{
+						Columns: []string{"id", "status", "s3_path", "source_bucket", "original_name", "source_deleted"},
+						Rows:    [][]any{{id.String(), string(tt.status), s3Path, sourceBucket, "a.mp4", tt.sourceDeleted}},
+					}
+				}
+				if tt.claimLost && strings.HasPrefix(q.SQL, `UPDATE "videos"`) {
+					return testdb.Result{}
+				}
+				return testdb.Result{RowsAffected: 1}
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/videos/"+id.String()+"/reprocess", strings.NewReader(tt.body))
+			req.SetPathValue("id", id.String())
+			rec := httptest.NewRecorder()
+			reprocessHandler(gormDB, gcsClient).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+
+			remaining := store.Names("videos")
+			// Dispatching also stores the job's stream ID; only the status reset counts
+			var resets []testdb.Query
+			for _, q := range db.Matching(`UPDATE "videos"`) {
+				if strings.Contains(q.SQL, `"status"=`) {
+					resets = append(resets, q)
+				}
+			}
+			jobs := rdb.Named("XADD")
+			deletes := db.Matching(`DELETE FROM "video_`)
+			if !tt.wantRequeue {
+				if !slices.Equal(remaining, before) || len(deletes) != 0 || len(jobs) != 0 {
+					t.Errorf("rejected request changed state: objects %v, deletes %d, jobs %d", remaining, len(deletes), len(jobs))
+				}
+				// Only a lost claim gets as far as trying the reset
+				if tt.claimLost != (len(resets) == 1) {
+					t.Errorf("rejected request sent %d resets", len(resets))
+				}
+				if tt.claimLost && decodeError(t, rec).Code != "video_queued" {
+					t.Errorf("lost claim answered %s", rec.Body)
+				}
+				return
+			}
+
+			if want := []string{source}; !slices.Equal(remaining, want) {
+				t.Errorf("objects after reprocess = %v, want only the source %v", remaining, want)
+			}
+			if len(db.Matching(`DELETE FROM "video_resolutions"`)) != 1 {
+				t.Error("resolution rows were not deleted")
+			}
+			if len(db.Matching(`DELETE FROM "video_subtitles"`)) != 1 {
+				t.Error("subtitle rows were not deleted with their files")
+			}
+			if len(resets) != 1 || !slices.Contains(resets[0].Args, any(string(models.StatusWaiting))) {
+				t.Errorf("video was not reset to waiting: %v", resets)
+			}
+			// The row is claimed before anything is removed
+			queries := db.Queries()
+			claim := slices.IndexFunc(queries, func(q testdb.Query) bool { return q.SQL == resets[0].SQL })
+			firstDelete := slices.IndexFunc(queries, func(q testdb.Query) bool { return strings.HasPrefix(q.SQL, "DELETE") })
+			if claim > firstDelete {
+				t.Errorf("rows deleted before the video was claimed: %v", queries)
+			}
+			if len(jobs) != 1 {
+				t.Fatalf("enqueued %d jobs, want 1", len(jobs))
+			}
+			if !strings.Contains(rec.Body.String(), `"stream_id":"1-0"`) {
+				t.Errorf("response %s does not include the job's stream ID", rec.Body)
+			}
+			job := strings.Join(jobs[0], " ")
+			if !strings.Contains(job, `"s3_path":"`+s3Path+`"`) {
+				t.Errorf("job %q does not point at the original source", job)
+			}
+			if tt.bucketMode && !strings.Contains(job, `"bucket":"videos"`) {
+				t.Errorf("job %q does not name the source bucket", job)
+			}
+			if strings.Contains(tt.body, "renditions") && !strings.Contains(job, `"max_rate":1070`) {
+				t.Errorf("job %q does not carry the normalized custom ladder", job)
+			}
+			if forced := strings.Contains(tt.body, `"force":true`); strings.Contains(job, `"force_probe":true`) != forced {
+				t.Errorf("job %q: force_probe should be %v", job, forced)
+			}
+		})
+	}
+}
+
+func TestReprocessHandlerUnknownVideo(t *testing.T) {
+	gormDB, _ := testdb.Open(t, nil)
+	id := uuid.NewString()
+	req := httptest.NewRequest(http.MethodPost, "/videos/"+id+"/reprocess", nil)
+	req.SetPathValue("id", id)
+	rec := httptest.NewRecorder()
+	reprocessHandler(gormDB, nil).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestNormalizeRendition(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      models.Rendition
+		want    models.Rendition
+		wantErr bool
+	}{
+		{
+			name: "fills rate control from the bitrate",
+			in:   models.Rendition{Height: 720, Bitrate: 3000},
+			want: models.Rendition{Height: 720, Bitrate: 3000, MaxRate: 3210, BufSize: 4500, AudioRate: 128},
+		},
+		{
+			name: "keeps explicit values",
+			in:   models.Rendition{Height: 720, Bitrate: 3000, MaxRate: 4000, BufSize: 6000, AudioRate: 192},
+			want: models.Rendition{Height: 720, Bitrate: 3000, MaxRate: 4000, BufSize: 6000, AudioRate: 192},
+		},
+		{name: "zero height", in: models.Rendition{Bitrate: 3000}, wantErr: true},
+		{name: "height too large", in: models.Rendition{Height: 8640, Bitrate: 3000}, wantErr: true},
+		{name: "missing bitrate", in: models.Rendition{Height: 720}, wantErr: true},
+		{name: "max rate below bitrate", in: models.Rendition{Height: 720, Bitrate: 3000, MaxRate: 2000}, wantErr: true},
+		{
+			name: "keeps allowed extra args",
+			in:   models.Rendition{Height: 720, Bitrate: 3000, ExtraArgs: []string{"-tune", "film"}},
+			want: models.Rendition{Height: 720, Bitrate: 3000, MaxRate: 3210, BufSize: 4500, AudioRate: 128, ExtraArgs: []string{"-tune", "film"}},
+		},
+		{name: "disallowed extra args", in: models.Rendition{Height: 720, Bitrate: 3000, ExtraArgs: []string{"-map", "0"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.in
+			err := normalizeRendition(&got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeRendition error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalizeRendition = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourceExists(t *testing.T) {
+	gcsClient, store := testgcs.Start(t)
+	store.Put("uploads", "a.mp4", []byte("source"))
+
+	tests := []struct {
+		name       string
+		bucket     string
+		path       string
+		status     int
+		wantExists bool
+		wantErr    bool
+	}{
+		{name: "gs URL", path: "gs://uploads/a.mp4", wantExists: true},
+		{name: "missing gs object", path: "gs://uploads/b.mp4"},
+		{name: "bucket and key", bucket: "uploads", path: "a.mp4", wantExists: true},
+		{name: "missing bucket", bucket: "gone", path: "a.mp4"},
+		{name: "remote", path: "http://93.184.216.34/a.mp4", status: http.StatusOK, wantExists: true},
+		{name: "remote not found", path: "http://93.184.216.34/a.mp4", status: http.StatusNotFound},
+		{name: "remote gone", path: "http://93.184.216.34/a.mp4", status: http.StatusGone},
+		{name: "remote forbidden", path: "http://93.184.216.34/a.mp4", status: http.StatusForbidden, wantErr: true},
+		{name: "remote outage", path: "http://93.184.216.34/a.mp4", status: http.StatusBadGateway, wantErr: true},
+		{name: "refused URL", path: "http://127.0.0.1/a.mp4", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.status != 0 {
+				useRemoteOrigin(t, tt.status)
+			}
+			exists, err := sourceExists(context.Background(), gcsClient, tt.bucket, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sourceExists error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if exists != tt.wantExists {
+				t.Errorf("sourceExists = %v, want %v", exists, tt.wantExists)
+			}
+		})
+	}
+}
+
+func TestReprocessHandlerKeepsJobWhenRedisIsDown(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	id := uuid.New()
+	source := "uploads/" + id.String() + ".mp4"
+	gcsClient, store := testgcs.Start(t)
+	store.Put("videos", source, []byte("source"))
+
+	useRedis(t, func(cmd []string) any { return errors.New("ERR connection refused") })
+	gormDB, db := testdb.Open(t, outboxHandler(func(q testdb.Query) testdb.Result {
+		if strings.HasPrefix(q.SQL, "SELECT") {
+			return testdb.Result{
+				Columns: []string{"id", "status", "s3_path"},
+				Rows:    [][]any{{id.String(), string(models.StatusCompleted), "gs://videos/" + source}},
+			}
+		}
+		return testdb.Result{RowsAffected: 1}
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/videos/"+id.String()+"/reprocess", nil)
+	req.SetPathValue("id", id.String())
+	rec := httptest.NewRecorder()
+	reprocessHandler(gormDB, gcsClient).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if len(db.Matching(`INSERT INTO "job_outbox"`)) != 1 {
+		t.Error("job was not written to the outbox")
+	}
+	if len(db.Matching(`DELETE FROM "job_outbox"`)) != 0 {
+		t.Error("outbox entry removed although the enqueue failed")
+	}
+	if len(db.Matching(`UPDATE "job_outbox" SET "attempts"=attempts + 1`)) != 1 {
+		t.Errorf("failed attempt not recorded: %v", db.Matching(`"job_outbox"`))
+	}
+}
+
+func TestReprocessHandlerVersionsOutput(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	id := uuid.New()
+	source := "uploads/" + id.String() + ".mp4"
+
+	tests := []struct {
+		name        string
+		versioned   bool
+		current     int
+		wantVersion int
+	}{
+		{"unversioned", false, 0, 0},
+		{"first versioned reprocess", true, 0, 1},
+		{"later versioned reprocess", true, 3, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &versionedOutput, tt.versioned)
+			gcsClient, store := testgcs.Start(t)
+			store.Put("videos", source, []byte("source"))
+			store.Put("videos", models.OutputPrefix(id, 0)+"master.m3u8", []byte("#EXTM3U\n"))
+			store.Put("videos", models.OutputPrefix(id, tt.current)+"stream_0/playlist.m3u8", []byte("#EXTM3U\n"))
+
+			rdb := useRedis(t, func(cmd []string) any {
+				if cmd[0] == "xadd" {
+					return "1-0"
+				}
+				return testredis.Status("OK")
+			})
+			gormDB, db := testdb.Open(t, outboxHandler(func(q testdb.Query) testdb.Result {
+				if strings.HasPrefix(q.SQL, "SELECT") {
+					return testdb.Result{
+						Columns: []string{"id", "status", "s3_path", "output_version"},
+						Rows:    [][]any{{id.String(), string(models.StatusCompleted), "gs://videos/" + source, int64(tt.current)}},
+					}
+				}
+				return testdb.Result{RowsAffected: 1}
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/videos/"+id.String()+"/reprocess", nil)
+			req.SetPathValue("id", id.String())
+			rec := httptest.NewRecorder()
+			reprocessHandler(gormDB, gcsClient).ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+
+			// Every earlier version is removed with the unversioned prefix
+			if want := []string{source}; !slices.Equal(store.Names("videos"), want) {
+				t.Errorf("objects after reprocess = %v, want only the source", store.Names("videos"))
+			}
+
+			jobs := rdb.Named("XADD")
+			if len(jobs) != 1 {
+				t.Fatalf("enqueued %d jobs, want 1", len(jobs))
+			}
+			job := strings.Join(jobs[0], " ")
+			hasVersion := strings.Contains(job, fmt.Sprintf(`"output_version":%d`, tt.wantVersion))
+			if (tt.wantVersion > 0) != hasVersion || (tt.wantVersion == 0 && strings.Contains(job, "output_version")) {
+				t.Errorf("job %q, want output version %d", job, tt.wantVersion)
+			}
+
+			var stored []any
+			for _, q := range db.Matching(`"output_version"=`) {
+				stored = append(stored, q.Args...)
+			}
+			if !slices.Contains(stored, any(int64(tt.wantVersion))) {
+				t.Errorf("stored output_version args %v, want %d", stored, tt.wantVersion)
+			}
+		})
+	}
+}
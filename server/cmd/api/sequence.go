@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/devrayat000/video-process/models"
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// Frame rate image sequences play at when the job doesn't set one
+var defaultSequenceFrameRate = float64(server_utils.GetEnvInt("IMAGE_SEQUENCE_FRAME_RATE", 24))
+
+// normalizeImageSequence checks an image sequence job: the source must be a
+// .zip archive and the frame rate, defaulted when zero, within 1–240 fps.
+// The frames themselves are validated by the worker once it has the archive.
+func normalizeImageSequence(source string, seq *models.ImageSequence) error {
+	if u, err := url.Parse(source); err == nil {
+		source = u.Path
+	}
+	if !strings.EqualFold(path.Ext(source), ".zip") {
+		return fmt.Errorf("image sequence source must be a .zip archive")
+	}
+
+	if seq.FrameRate == 0 {
+		seq.FrameRate = defaultSequenceFrameRate
+	}
+	if seq.FrameRate < 1 || seq.FrameRate > 240 {
+		return fmt.Errorf("frame_rate must be between 1 and 240")
+	}
+	return nil
+}
+
+// sequenceFrameRate is the recorded input rate, zero for ordinary videos
+func sequenceFrameRate(job models.VideoJob) float64 {
+	if job.ImageSequence == nil {
+		return 0
+	}
+	return job.ImageSequence.FrameRate
+}
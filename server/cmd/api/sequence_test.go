@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/devrayat000/video-process/models"
+)
+
+func TestNormalizeImageSequence(t *testing.T) {
+	setVar(t, &defaultSequenceFrameRate, 24)
+
+	tests := []struct {
+		name      string
+		source    string
+		frameRate float64
+		want      float64
+		wantErr   bool
+	}{
+		{"default rate", "uploads/frames.zip", 0, 24, false},
+		{"explicit rate", "uploads/frames.ZIP", 29.97, 29.97, false},
+		{"signed url", "https://storage.example.com/frames.zip?sig=abc", 60, 60, false},
+		{"not an archive", "uploads/frames.tar", 24, 0, true},
+		{"url not an archive", "https://example.com/frames.png?x=.zip", 24, 0, true},
+		{"too slow", "frames.zip", 0.5, 0, true},
+		{"too fast", "frames.zip", 241, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seq := &models.ImageSequence{FrameRate: tt.frameRate}
+			err := normalizeImageSequence(tt.source, seq)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeImageSequence error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && seq.FrameRate != tt.want {
+				t.Errorf("frame rate = %v, want %v", seq.FrameRate, tt.want)
+			}
+		})
+	}
+}
+
+func TestSequenceFrameRate(t *testing.T) {
+	if got := sequenceFrameRate(models.VideoJob{}); got != 0 {
+		t.Errorf("ordinary video rate = %v, want 0", got)
+	}
+	job := models.VideoJob{ImageSequence: &models.ImageSequence{FrameRate: 12}}
+	if got := sequenceFrameRate(job); got != 12 {
+		t.Errorf("sequence rate = %v, want 12", got)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+var (
+	listenAddr = server_utils.GetEnv("LISTEN_ADDR", ":8080")
+
+	// TLS is enabled only when both files are set
+	tlsCertFile = server_utils.GetEnv("TLS_CERT_FILE", "")
+	tlsKeyFile  = server_utils.GetEnv("TLS_KEY_FILE", "")
+
+	// Server timeouts. SSE, ZIP download and direct upload handlers lift the
+	// read/write deadlines for their own requests.
+	readTimeout  = server_utils.GetEnvDuration("HTTP_READ_TIMEOUT", 60*time.Second)
+	writeTimeout = server_utils.GetEnvDuration("HTTP_WRITE_TIMEOUT", 60*time.Second)
+	idleTimeout  = server_utils.GetEnvDuration("HTTP_IDLE_TIMEOUT", 120*time.Second)
+)
+
+func newServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              listenAddr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+}
+
+// serve listens over HTTPS (with HTTP/2) when a certificate is configured and
+// plain HTTP otherwise.
+func serve(srv *http.Server) error {
+	if tlsCertFile != "" && tlsKeyFile != "" {
+		log.Printf("API Server starting on %s (TLS)...", srv.Addr)
+		return srv.ListenAndServeTLS(tlsCertFile, tlsKeyFile)
+	}
+	if tlsCertFile != "" || tlsKeyFile != "" {
+		log.Println("Warning: TLS_CERT_FILE and TLS_KEY_FILE must both be set, serving plain HTTP")
+	}
+
+	log.Printf("API Server starting on %s...", srv.Addr)
+	return srv.ListenAndServe()
+}
+
+// clearWriteDeadline lets long-lived responses such as SSE streams outlive
+// the server's WriteTimeout.
+func clearWriteDeadline(w http.ResponseWriter) {
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to clear write deadline: %v", err)
+	}
+}
+
+// clearReadDeadline lets large request bodies outlive the server's ReadTimeout
+func clearReadDeadline(w http.ResponseWriter) {
+	if err := http.NewResponseController(w).SetReadDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to clear read deadline: %v", err)
+	}
+}
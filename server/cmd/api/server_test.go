@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// selfSignedCert writes a certificate and key for 127.0.0.1 and returns their
+// paths plus a pool that trusts the certificate
+func selfSignedCert(t *testing.T) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "tls.crt")
+	keyFile = filepath.Join(dir, "tls.key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	pool = x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+	return certFile, keyFile, pool
+}
+
+// freeAddr returns a loopback address nothing is listening on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startServer runs serve in the background and stops it when the test ends
+func startServer(t *testing.T, handler http.Handler) {
+	t.Helper()
+	srv := newServer(handler)
+	done := make(chan error, 1)
+	go func() { done <- serve(srv) }()
+	t.Cleanup(func() {
+		srv.Close()
+		if err := <-done; !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("serve: %v", err)
+		}
+	})
+}
+
+// get retries until the server accepts connections
+func get(t *testing.T, client *http.Client, url string) *http.Response {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := client.Get(url)
+		if err == nil {
+			return resp
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GET %s: %v", url, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServeTLSWithSelfSignedCert(t *testing.T) {
+	certFile, keyFile, pool := selfSignedCert(t)
+	addr := freeAddr(t)
+	setVar(t, &listenAddr, addr)
+	setVar(t, &tlsCertFile, certFile)
+	setVar(t, &tlsKeyFile, keyFile)
+
+	startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool},
+		ForceAttemptHTTP2: true,
+	}}
+	resp := get(t, client, "https://"+addr+"/health")
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Errorf("response = %d %q", resp.StatusCode, body)
+	}
+	if resp.TLS == nil {
+		t.Error("response was not served over TLS")
+	}
+	if resp.ProtoMajor != 2 {
+		t.Errorf("protocol = %s, want HTTP/2", resp.Proto)
+	}
+}
+
+func TestServePlainHTTP(t *testing.T) {
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+	}{
+		{"no TLS settings", "", ""},
+		{"certificate without key", "/certs/tls.crt", ""},
+		{"key without certificate", "", "/certs/tls.key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := freeAddr(t)
+			setVar(t, &listenAddr, addr)
+			setVar(t, &tlsCertFile, tt.certFile)
+			setVar(t, &tlsKeyFile, tt.keyFile)
+
+			startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "ok")
+			}))
+
+			resp := get(t, http.DefaultClient, "http://"+addr+"/health")
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || resp.TLS != nil {
+				t.Errorf("response = %d, TLS %v; want plain 200", resp.StatusCode, resp.TLS != nil)
+			}
+		})
+	}
+}
+
+func TestNewServerTimeouts(t *testing.T) {
+	setVar(t, &listenAddr, ":9090")
+	setVar(t, &readTimeout, 5*time.Second)
+	setVar(t, &writeTimeout, 6*time.Second)
+	setVar(t, &idleTimeout, 7*time.Second)
+
+	srv := newServer(http.NotFoundHandler())
+	if srv.Addr != ":9090" || srv.ReadTimeout != 5*time.Second || srv.WriteTimeout != 6*time.Second ||
+		srv.IdleTimeout != 7*time.Second || srv.ReadHeaderTimeout == 0 {
+		t.Errorf("server = addr %q, read %s, write %s, idle %s, header %s",
+			srv.Addr, srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout, srv.ReadHeaderTimeout)
+	}
+}
+
+func TestClearWriteDeadlineOutlivesWriteTimeout(t *testing.T) {
+	addr := freeAddr(t)
+	setVar(t, &listenAddr, addr)
+	setVar(t, &tlsCertFile, "")
+	setVar(t, &tlsKeyFile, "")
+	setVar(t, &writeTimeout, 50*time.Millisecond)
+
+	startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stream" {
+			clearWriteDeadline(w)
+		}
+		// Like an SSE stream, keep writing past the server's write timeout
+		w.(http.Flusher).Flush()
+		time.Sleep(150 * time.Millisecond)
+		io.WriteString(w, "data: done\n\n")
+	}))
+
+	resp := get(t, http.DefaultClient, "http://"+addr+"/stream")
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "data: done\n\n" {
+		t.Errorf("stream body = %q, %v; want the late event", body, err)
+	}
+
+	resp = get(t, http.DefaultClient, "http://"+addr+"/other")
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err == nil && string(body) == "data: done\n\n" {
+		t.Error("write timeout did not apply to a handler that kept its deadline")
+	}
+}
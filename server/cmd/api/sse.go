@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/devrayat000/video-process/models"
+	"github.com/devrayat000/video-process/pubsub"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// Concurrent SSE clients across the API instance; zero means no cap
+	sseMaxSubscribers = server_utils.GetEnvInt("SSE_MAX_SUBSCRIBERS", 1000)
+	// Concurrent SSE clients watching the same video; zero means no cap
+	sseMaxPerVideo = server_utils.GetEnvInt("SSE_MAX_PER_VIDEO", 100)
+	// How often /progress/{id} re-reads the video's status, so a stream
+	// whose final event was lost still closes; zero only checks on connect
+	sseStatusRecheck = server_utils.GetEnvDuration("SSE_STATUS_RECHECK", 30*time.Second)
+)
+
+// subscribeProgress joins the hub for an SSE handler, replying 503 when the
+// subscriber caps are reached. ok is false when a response was written.
+func subscribeProgress(hub *pubsub.ProgressHub, w http.ResponseWriter, r *http.Request, videoID string) (<-chan *models.ProcessingProgress, func(), bool) {
+	progressChan, unsubscribe, err := hub.Subscribe(r.Context(), videoID)
+	if errors.Is(err, pubsub.ErrTooManySubscribers) {
+		w.Header().Set("Retry-After", "5")
+		writeError(w, http.StatusServiceUnavailable, "too_many_subscribers", "Too many progress subscribers, try again later")
+		return nil, nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to subscribe to progress: %v", err)
+		writeError(w, http.StatusInternalServerError, "subscribe_failed", "Failed to subscribe")
+		return nil, nil, false
+	}
+	return progressChan, unsubscribe, true
+}
+
+func isTerminal(status models.VideoStatus) bool {
+	return status == models.StatusCompleted || status == models.StatusFailed
+}
+
+// terminalProgress returns the final event of a video that has already
+// completed or failed according to the database, or nil while it is still
+// in progress or can't be read. It covers jobs that finished before the
+// client subscribed after their cached progress expired, and final events
+// that never reached this instance.
+func terminalProgress(ctx context.Context, gormDB *gorm.DB, videoID uuid.UUID) *models.ProcessingProgress {
+	video, err := gorm.G[models.Video](gormDB).Where("id = ?", videoID).First(ctx)
+	if err != nil || !isTerminal(video.Status) {
+		return nil
+	}
+	progress := &models.ProcessingProgress{
+		VideoID:   video.ID,
+		Status:    video.Status,
+		Timestamp: video.UpdatedAt,
+	}
+	if video.ErrorMessage != nil {
+		progress.Error = *video.ErrorMessage
+	}
+	if video.FailureCategory != nil {
+		progress.ErrorCategory = string(*video.FailureCategory)
+	}
+	return progress
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/models"
+	"github.com/devrayat000/video-process/pubsub"
+	"github.com/google/uuid"
+)
+
+func TestSubscribeProgressOverCap(t *testing.T) {
+	useRedis(t, nil)
+	hub := pubsub.NewProgressHub(context.Background(), 1, 0)
+	defer hub.Close()
+
+	req := httptest.NewRequest("GET", "/progress", nil)
+	_, unsubscribe, ok := subscribeProgress(hub, httptest.NewRecorder(), req, "")
+	if !ok {
+		t.Fatal("first subscriber rejected")
+	}
+	defer unsubscribe()
+
+	rec := httptest.NewRecorder()
+	if _, _, ok := subscribeProgress(hub, rec, req, ""); ok {
+		t.Fatal("subscriber over the cap admitted")
+	}
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "5" {
+		t.Errorf("response = %d with Retry-After %q, want 503 and 5", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if body := decodeError(t, rec); body.Code != "too_many_subscribers" {
+		t.Errorf("error code = %q, want too_many_subscribers", body.Code)
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	tests := []struct {
+		status models.VideoStatus
+		want   bool
+	}{
+		{models.StatusCompleted, true},
+		{models.StatusFailed, true},
+		{models.StatusStarted, false},
+		{models.StatusProcessing, false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isTerminal(tt.status); got != tt.want {
+			t.Errorf("isTerminal(%q) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
+
+// A client that subscribes just after the final event was published only
+// learns the outcome from the database
+func TestTerminalProgress(t *testing.T) {
+	videoID := uuid.New()
+	updatedAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	columns := []string{"id", "status", "error_message", "failure_category", "updated_at"}
+
+	tests := []struct {
+		name   string
+		result testdb.Result
+		want   *models.ProcessingProgress
+	}{
+		{
+			name:   "completed before subscribing",
+			result: testdb.Result{Columns: columns, Rows: [][]any{{videoID.String(), "completed", nil, nil, updatedAt}}},
+			want:   &models.ProcessingProgress{VideoID: videoID, Status: models.StatusCompleted, Timestamp: updatedAt},
+		},
+		{
+			name:   "failed before subscribing",
+			result: testdb.Result{Columns: columns, Rows: [][]any{{videoID.String(), "failed", "failed to transcode video", "encode_error", updatedAt}}},
+			want: &models.ProcessingProgress{
+				VideoID:       videoID,
+				Status:        models.StatusFailed,
+				Error:         "failed to transcode video",
+				ErrorCategory: string(models.FailureEncodeError),
+				Timestamp:     updatedAt,
+			},
+		},
+		{
+			name:   "still processing",
+			result: testdb.Result{Columns: columns, Rows: [][]any{{videoID.String(), "processing", nil, nil, updatedAt}}},
+		},
+		{
+			name:   "unknown video",
+			result: testdb.Result{Columns: columns},
+		},
+		{
+			name:   "database down",
+			result: testdb.Result{Err: errors.New("connection refused")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDB, _ := testdb.Open(t, func(q testdb.Query) testdb.Result { return tt.result })
+
+			got := terminalProgress(context.Background(), gormDB, videoID)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("terminalProgress = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/devrayat000/video-process/models"
+	"github.com/devrayat000/video-process/pubsub"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	// How long computed statistics are served from Redis
+	statsCacheTTL = server_utils.GetEnvDuration("STATS_CACHE_TTL", 30*time.Second)
+	// Days covered by the per-day completion counts
+	statsDays = server_utils.GetEnvInt("STATS_DAYS", 30)
+)
+
+type processingTimeStats struct {
+	AvgMs float64 `json:"avg_ms"`
+	P50Ms float64 `json:"p50_ms"`
+	P95Ms float64 `json:"p95_ms"`
+	P99Ms float64 `json:"p99_ms"`
+}
+
+type dailyCount struct {
+	Day   time.Time `json:"day"`
+	Count int64     `json:"count"`
+}
+
+type statsResponse struct {
+	TotalVideos    int64                        `json:"total_videos"`
+	ByStatus       map[models.VideoStatus]int64 `json:"by_status"`
+	ProcessingTime processingTimeStats          `json:"processing_time"`
+	StorageBytes   int64                        `json:"storage_bytes"`
+	CompletedByDay []dailyCount                 `json:"completed_by_day"`
+	GeneratedAt    time.Time                    `json:"generated_at"`
+}
+
+// computeStats runs the aggregate queries behind GET /stats
+func computeStats(ctx context.Context, gormDB *gorm.DB) (*statsResponse, error) {
+	db := gormDB.WithContext(ctx)
+	stats := &statsResponse{
+		ByStatus:       make(map[models.VideoStatus]int64),
+		CompletedByDay: []dailyCount{},
+		GeneratedAt:    time.Now().UTC(),
+	}
+
+	var byStatus []struct {
+		Status models.VideoStatus
+		Count  int64
+	}
+	err := db.Model(&models.Video{}).
+		Select("status, count(*) AS count").
+		Group("status").
+		Scan(&byStatus).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range byStatus {
+		stats.ByStatus[row.Status] = row.Count
+		stats.TotalVideos += row.Count
+	}
+
+	// Only completed videos have a full processing time
+	err = db.Model(&models.Video{}).
+		Select(`coalesce(avg(processing_ms), 0) AS avg_ms,
+			coalesce(percentile_cont(0.5) WITHIN GROUP (ORDER BY processing_ms), 0) AS p50_ms,
+			coalesce(percentile_cont(0.95) WITHIN GROUP (ORDER BY processing_ms), 0) AS p95_ms,
+			coalesce(percentile_cont(0.99) WITHIN GROUP (ORDER BY processing_ms), 0) AS p99_ms`).
+		Where("status = ? AND processing_ms > 0", models.StatusCompleted).
+		Scan(&stats.ProcessingTime).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Model(&models.VideoResolution{}).
+		Select("coalesce(sum(total_size), 0)").
+		Scan(&stats.StorageBytes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	since := time.Now().UTC().AddDate(0, 0, -statsDays)
+	err = db.Model(&models.Video{}).
+		Select("date_trunc('day', completed_at) AS day, count(*) AS count").
+		Where("status = ? AND completed_at >= ?", models.StatusCompleted, since).
+		Group("day").
+		Order("day").
+		Scan(&stats.CompletedByDay).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// statsHandler serves dashboard aggregates, computed at most once per
+// STATS_CACHE_TTL across all API instances.
+func statsHandler(gormDB *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enableCors(&w)
+
+		if r.Method == "OPTIONS" {
+			return
+		}
+
+		if r.Method != "GET" {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+			return
+		}
+
+		ctx := r.Context()
+
+		if cached, err := pubsub.GetCachedStats(ctx); err == nil {
+			writeBody(w, r, "application/json", int64(len(cached)), bytes.NewReader(cached))
+			return
+		}
+
+		stats, err := computeStats(ctx, gormDB)
+		if err != nil {
+			log.Printf("Failed to compute stats: %v", err)
+			writeError(w, http.StatusInternalServerError, "database_error", "Failed to compute statistics")
+			return
+		}
+
+		data, err := json.Marshal(stats)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "encode_error", "Failed to encode statistics")
+			return
+		}
+
+		if statsCacheTTL > 0 {
+			if err := pubsub.CacheStats(ctx, data, statsCacheTTL); err != nil {
+				log.Printf("Failed to cache stats: %v", err)
+			}
+		}
+
+		writeBody(w, r, "application/json", int64(len(data)), bytes.NewReader(data))
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testredis"
+	"github.com/devrayat000/video-process/models"
+)
+
+// seededVideo is one row of the statistics fixture
+type seededVideo struct {
+	status       models.VideoStatus
+	processingMs int64
+	completedAt  time.Time
+}
+
+// statsTables answers the /stats aggregates over videos and resolution sizes
+// the way Postgres would, so the handler's queries are checked end to end
+func statsTables(videos []seededVideo, sizes []int64) testdb.Handler {
+	return func(q testdb.Query) testdb.Result {
+		switch {
+		case strings.Contains(q.SQL, "percentile_cont"):
+			var times []float64
+			for _, v := range videos {
+				if string(v.status) == q.Args[0] && v.processingMs > 0 {
+					times = append(times, float64(v.processingMs))
+				}
+			}
+			sort.Float64s(times)
+			var sum float64
+			for _, ms := range times {
+				sum += ms
+			}
+			avg := 0.0
+			if len(times) > 0 {
+				avg = sum / float64(len(times))
+			}
+			return testdb.Result{
+				Columns: []string{"avg_ms", "p50_ms", "p95_ms", "p99_ms"},
+				Rows:    [][]any{{avg, percentileCont(times, 0.5), percentileCont(times, 0.95), percentileCont(times, 0.99)}},
+			}
+		case strings.Contains(q.SQL, "sum(total_size)"):
+			var total int64
+			for _, size := range sizes {
+				total += size
+			}
+			return testdb.Result{Columns: []string{"coalesce"}, Rows: [][]any{{total}}}
+		case strings.Contains(q.SQL, "date_trunc"):
+			since := q.Args[1].(time.Time)
+			counts := map[time.Time]int64{}
+			for _, v := range videos {
+				if string(v.status) == q.Args[0] && !v.completedAt.Before(since) {
+					counts[v.completedAt.Truncate(24*time.Hour)]++
+				}
+			}
+			var rows [][]any
+			for day, n := range counts {
+				rows = append(rows, []any{day, n})
+			}
+			slices.SortFunc(rows, func(a, b []any) int { return a[0].(time.Time).Compare(b[0].(time.Time)) })
+			return testdb.Result{Columns: []string{"day", "count"}, Rows: rows}
+		case strings.Contains(q.SQL, "GROUP BY"):
+			counts := map[models.VideoStatus]int64{}
+			for _, v := range videos {
+				counts[v.status]++
+			}
+			var rows [][]any
+			for status, n := range counts {
+				rows = append(rows, []any{string(status), n})
+			}
+			return testdb.Result{Columns: []string{"status", "count"}, Rows: rows}
+		}
+		return testdb.Result{}
+	}
+}
+
+// percentileCont interpolates like Postgres' percentile_cont over sorted values
+func percentileCont(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := p * float64(len(sorted)-1)
+	lo := int(pos)
+	if lo+1 >= len(sorted) {
+		return sorted[lo]
+	}
+	return sorted[lo] + (pos-float64(lo))*(sorted[lo+1]-sorted[lo])
+}
+
+func TestStatsHandler(t *testing.T) {
+	setVar(t, &statsDays, 30)
+	setVar(t, &statsCacheTTL, 30*time.Second)
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	yesterday := today.AddDate(0, 0, -1)
+	videos := []seededVideo{
+		{models.StatusCompleted, 1000, yesterday.Add(2 * time.Hour)},
+		{models.StatusCompleted, 2000, yesterday.Add(5 * time.Hour)},
+		{models.StatusCompleted, 3000, today.Add(time.Hour)},
+		{models.StatusCompleted, 10000, today.AddDate(0, 0, -60)},
+		// Completed before timings were recorded; left out of the percentiles
+		{models.StatusCompleted, 0, today.Add(2 * time.Hour)},
+		{models.StatusFailed, 500, time.Time{}},
+		{models.StatusProcessing, 0, time.Time{}},
+		{models.StatusWaiting, 0, time.Time{}},
+	}
+	gormDB, _ := testdb.Open(t, statsTables(videos, []int64{1 << 20, 2 << 20, 512}))
+	rdb := useRedis(t, func(cmd []string) any {
+		if cmd[0] == "get" {
+			return nil
+		}
+		return testredis.Status("OK")
+	})
+
+	rec := httptest.NewRecorder()
+	statsHandler(gormDB)(rec, httptest.NewRequest("GET", "/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	var stats statsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.TotalVideos != 8 {
+		t.Errorf("total = %d, want 8", stats.TotalVideos)
+	}
+	wantStatus := map[models.VideoStatus]int64{
+		models.StatusCompleted:  5,
+		models.StatusFailed:     1,
+		models.StatusProcessing: 1,
+		models.StatusWaiting:    1,
+	}
+	for status, want := range wantStatus {
+		if got := stats.ByStatus[status]; got != want {
+			t.Errorf("by_status[%s] = %d, want %d", status, got, want)
+		}
+	}
+	wantTime := processingTimeStats{AvgMs: 4000, P50Ms: 2500, P95Ms: 8950, P99Ms: 9790}
+	got := stats.ProcessingTime
+	got.P95Ms, got.P99Ms = math.Round(got.P95Ms), math.Round(got.P99Ms)
+	if got != wantTime {
+		t.Errorf("processing_time = %+v, want %+v", got, wantTime)
+	}
+	if stats.StorageBytes != 3<<20+512 {
+		t.Errorf("storage_bytes = %d, want %d", stats.StorageBytes, 3<<20+512)
+	}
+	wantDays := []dailyCount{{yesterday, 2}, {today, 2}}
+	if len(stats.CompletedByDay) != len(wantDays) {
+		t.Fatalf("completed_by_day = %+v, want %+v", stats.CompletedByDay, wantDays)
+	}
+	for i, want := range wantDays {
+		if got := stats.CompletedByDay[i]; !got.Day.Equal(want.Day) || got.Count != want.Count {
+			t.Errorf("completed_by_day[%d] = %+v, want %+v", i, got, want)
+		}
+	}
+
+	// The body is cached for the configured TTL
+	sets := rdb.Named("SET")
+	if len(sets) != 1 || sets[0][1] != "stats:summary" || sets[0][2] != rec.Body.String() || !slices.Equal(sets[0][3:], []string{"ex", "30"}) {
+		t.Errorf("SET = %q, want the response cached for 30s", sets)
+	}
+}
+
+func TestStatsHandlerServesCache(t *testing.T) {
+	const cached = `{"total_videos":42}`
+	gormDB, db := testdb.Open(t, nil)
+	useRedis(t, func(cmd []string) any { return cached })
+
+	rec := httptest.NewRecorder()
+	statsHandler(gormDB)(rec, httptest.NewRequest("GET", "/stats", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != cached {
+		t.Errorf("response = %d %s, want the cached body", rec.Code, rec.Body)
+	}
+	if queries := db.Queries(); len(queries) != 0 {
+		t.Errorf("cache hit still queried the database: %v", queries)
+	}
+}
+
+func TestStatsHandlerWithoutCaching(t *testing.T) {
+	setVar(t, &statsCacheTTL, 0)
+	gormDB, _ := testdb.Open(t, statsTables(nil, nil))
+	rdb := useRedis(t, func(cmd []string) any { return nil })
+
+	rec := httptest.NewRecorder()
+	statsHandler(gormDB)(rec, httptest.NewRequest("GET", "/stats", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var stats statsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.TotalVideos != 0 || stats.CompletedByDay == nil {
+		t.Errorf("empty stats = %+v, want zero totals and an empty day list", stats)
+	}
+	if sets := rdb.Named("SET"); len(sets) != 0 {
+		t.Errorf("STATS_CACHE_TTL=0 still cached: %q", sets)
+	}
+}
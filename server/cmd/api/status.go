@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/devrayat000/video-process/models"
+	"github.com/devrayat000/video-process/pubsub"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Maximum number of ids accepted by a single bulk status request
+var statusMaxIDs = server_utils.GetEnvInt("STATUS_MAX_IDS", 100)
+
+// videoStatusEntry is the compact per-video answer of the bulk status endpoint
+type videoStatusEntry struct {
+	Status          models.VideoStatus `json:"status"`
+	TotalFrames     int64              `json:"total_frames,omitempty"`
+	ProcessedFrames int64              `json:"processed_frames,omitempty"`
+	Error           string             `json:"error,omitempty"`
+}
+
+// parseStatusIDs splits the comma-separated ids parameter, dropping
+// duplicates, and rejects malformed or too many ids.
+func parseStatusIDs(raw string, max int) ([]string, error) {
+	var ids []string
+	seen := make(map[string]bool)
+
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := uuid.Parse(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid video id %q", part)
+		}
+		if !seen[id.String()] {
+			seen[id.String()] = true
+			ids = append(ids, id.String())
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("at least one video id is required")
+	}
+	if len(ids) > max {
+		return nil, fmt.Errorf("too many ids: %d (max %d)", len(ids), max)
+	}
+
+	return ids, nil
+}
+
+// bulkStatusHandler answers GET /videos/status?ids=a,b,c with a map of
+// id → status, combining the DB row with the latest Redis progress. Unknown
+// ids are omitted from the response.
+func bulkStatusHandler(gormDB *gorm.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enableCors(&w)
+
+		if r.Method == "OPTIONS" {
+			return
+		}
+
+		if r.Method != "GET" {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+			return
+		}
+
+		ids, err := parseStatusIDs(r.URL.Query().Get("ids"), statusMaxIDs)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_ids", err.Error())
+			return
+		}
+
+		videos, err := gorm.G[models.Video](gormDB).Where("id IN ?", ids).Find(r.Context())
+		if err != nil {
+			log.Printf("Failed to fetch video statuses: %v", err)
+			writeError(w, http.StatusInternalServerError, "database_error", "Failed to fetch videos")
+			return
+		}
+
+		progress, err := pubsub.GetProgressMany(ids)
+		if err != nil {
+			// The DB status alone is still useful
+			log.Printf("Failed to fetch progress snapshots: %v", err)
+			progress = nil
+		}
+
+		statuses := make(map[string]videoStatusEntry, len(videos))
+		for _, video := range videos {
+			id := video.ID.String()
+			entry := videoStatusEntry{Status: video.Status}
+			if video.ErrorMessage != nil {
+				entry.Error = *video.ErrorMessage
+			}
+			if p, ok := progress[id]; ok && p.Status == video.Status {
+				entry.TotalFrames = p.TotalFrames
+				entry.ProcessedFrames = p.ProcessedFrames
+				if entry.Error == "" {
+					entry.Error = p.Error
+				}
+			}
+			statuses[id] = entry
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statuses)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestParseStatusIDs(t *testing.T) {
+	a, b, c := uuid.NewString(), uuid.NewString(), uuid.NewString()
+
+	tests := []struct {
+		name    string
+		raw     string
+		max     int
+		want    []string
+		wantErr string
+	}{
+		{name: "single id", raw: a, max: 3, want: []string{a}},
+		{name: "several ids", raw: a + "," + b + "," + c, max: 3, want: []string{a, b, c}},
+		{name: "spaces and empty parts", raw: " " + a + ", ," + b + ",", max: 3, want: []string{a, b}},
+		{name: "duplicates count once", raw: a + "," + a + "," + strings.ToUpper(a) + "," + b, max: 2, want: []string{a, b}},
+		{name: "at the limit", raw: a + "," + b, max: 2, want: []string{a, b}},
+		{name: "over the limit", raw: a + "," + b + "," + c, max: 2, wantErr: "too many ids: 3 (max 2)"},
+		{name: "malformed id", raw: a + ",not-a-uuid", max: 3, wantErr: `invalid video id "not-a-uuid"`},
+		{name: "no ids", raw: "", max: 3, wantErr: "at least one video id is required"},
+		{name: "only commas", raw: ",,", max: 3, wantErr: "at least one video id is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseStatusIDs(tt.raw, tt.max)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("parseStatusIDs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBulkStatusHandler(t *testing.T) {
+	processing, failed, waiting, unknown := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	progressJSON := func(p models.ProcessingProgress) string {
+		data, _ := json.Marshal(p)
+		return string(data)
+	}
+
+	gormDB, db := testdb.Open(t, func(q testdb.Query) testdb.Result {
+		return testdb.Result{
+			Columns: []string{"id", "status", "error_message"},
+			Rows: [][]any{
+				{processing.String(), string(models.StatusProcessing), nil},
+				{failed.String(), string(models.StatusFailed), "ffmpeg exited"},
+				{waiting.String(), string(models.StatusWaiting), nil},
+			},
+		}
+	})
+	rdb := useRedis(t, func(cmd []string) any {
+		return []any{
+			progressJSON(models.ProcessingProgress{Status: models.StatusProcessing, TotalFrames: 100, ProcessedFrames: 40}),
+			progressJSON(models.ProcessingProgress{Status: models.StatusFailed, Error: "stale", ProcessedFrames: 7}),
+			// A snapshot from an earlier run no longer matches the DB status
+			progressJSON(models.ProcessingProgress{Status: models.StatusCompleted, TotalFrames: 10, ProcessedFrames: 10}),
+			nil,
+		}
+	})
+
+	ids := []string{processing.String(), failed.String(), waiting.String(), unknown.String()}
+	req := httptest.NewRequest(http.MethodGet, "/videos/status?ids="+strings.Join(ids, ","), nil)
+	rec := httptest.NewRecorder()
+	bulkStatusHandler(gormDB).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var got map[string]videoStatusEntry
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]videoStatusEntry{
+		processing.String(): {Status: models.StatusProcessing, TotalFrames: 100, ProcessedFrames: 40},
+		failed.String():     {Status: models.StatusFailed, ProcessedFrames: 7, Error: "ffmpeg exited"},
+		waiting.String():    {Status: models.StatusWaiting},
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d entries, want %d: %+v", len(got), len(want), got)
+	}
+	for id, entry := range want {
+		if got[id] != entry {
+			t.Errorf("%s = %+v, want %+v", id, got[id], entry)
+		}
+	}
+
+	// One query and one MGET for the whole batch
+	if q := db.Matching(`FROM "videos"`); len(q) != 1 || len(q[0].Args) != len(ids) {
+		t.Errorf("video queries = %v, want one IN query over %d ids", q, len(ids))
+	}
+	if mget := rdb.Named("MGET"); len(mget) != 1 || len(mget[0]) != len(ids)+1 {
+		t.Errorf("MGET = %q, want one call for %d keys", mget, len(ids))
+	}
+}
+
+func TestBulkStatusHandlerWithoutRedis(t *testing.T) {
+	id := uuid.New()
+	gormDB, _ := testdb.Open(t, func(q testdb.Query) testdb.Result {
+		return testdb.Result{
+			Columns: []string{"id", "status"},
+			Rows:    [][]any{{id.String(), string(models.StatusProcessing)}},
+		}
+	})
+	useRedis(t, func(cmd []string) any { return errors.New("ERR connection lost") })
+
+	req := httptest.NewRequest(http.MethodGet, "/videos/status?ids="+id.String(), nil)
+	rec := httptest.NewRecorder()
+	bulkStatusHandler(gormDB).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var got map[string]videoStatusEntry
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got[id.String()] != (videoStatusEntry{Status: models.StatusProcessing}) {
+		t.Errorf("entry = %+v, want the DB status alone", got[id.String()])
+	}
+}
+
+func TestBulkStatusHandlerRejectsTooManyIDs(t *testing.T) {
+	setVar(t, &statusMaxIDs, 2)
+	gormDB, db := testdb.Open(t, nil)
+
+	ids := []string{uuid.NewString(), uuid.NewString(), uuid.NewString()}
+	req := httptest.NewRequest(http.MethodGet, "/videos/status?ids="+strings.Join(ids, ","), nil)
+	rec := httptest.NewRecorder()
+	bulkStatusHandler(gormDB).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if len(db.Queries()) != 0 {
+		t.Errorf("rejected request queried the database: %v", db.Queries())
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	// Buffer size of each /upload/direct GCS writer; the client rounds it up
+	// to a multiple of 256 KiB
+	uploadChunkSize = server_utils.GetEnvInt("UPLOAD_CHUNK_SIZE", googleapi.DefaultUploadChunkSize)
+	// Concurrent /upload/direct requests before new ones get 503 (0 = no cap)
+	maxConcurrentUploads = server_utils.GetEnvInt("MAX_CONCURRENT_UPLOADS", 16)
+	// Memory the upload buffers may use together; shrinks the chunk size so
+	// MAX_CONCURRENT_UPLOADS full buffers fit (0 = no budget)
+	uploadMemoryBudget = int64(server_utils.GetEnvInt("UPLOAD_MEMORY_BUDGET", 0))
+)
+
+var uploadSlots = newUploadSlots(maxConcurrentUploads)
+
+func newUploadSlots(n int) chan struct{} {
+	if n <= 0 {
+		return nil
+	}
+	return make(chan struct{}, n)
+}
+
+// tryAcquireUpload claims an upload slot without waiting. It returns false
+// when every slot is busy; otherwise the caller must call the release func.
+func tryAcquireUpload() (func(), bool) {
+	if uploadSlots == nil {
+		return func() {}, true
+	}
+	select {
+	case uploadSlots <- struct{}{}:
+		return func() { <-uploadSlots }, true
+	default:
+		return nil, false
+	}
+}
+
+func writeUploadsBusy(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "10")
+	writeError(w, http.StatusServiceUnavailable, "too_many_uploads", "Too many uploads in progress, try again later")
+}
+
+// uploadBufferSize picks the GCS writer chunk size for an upload of
+// contentLength bytes (-1 when unknown). Small uploads only buffer what they
+// need, and the memory budget caps the size so a full set of concurrent
+// uploads can't exceed it. The result never drops below the 256 KiB minimum.
+func uploadBufferSize(contentLength int64) int {
+	size := int64(uploadChunkSize)
+	if uploadMemoryBudget > 0 && maxConcurrentUploads > 0 {
+		size = min(size, uploadMemoryBudget/int64(maxConcurrentUploads))
+	}
+	if contentLength >= 0 && contentLength < size {
+		size = contentLength
+	}
+	return int(max(size, int64(googleapi.MinUploadChunkSize)))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestUploadBufferSize(t *testing.T) {
+	const mib = 1 << 20
+	tests := []struct {
+		name          string
+		chunkSize     int
+		budget        int64
+		maxUploads    int
+		contentLength int64
+		want          int
+	}{
+		{"configured size", 8 * mib, 0, 16, 100 * mib, 8 * mib},
+		{"unknown length", 8 * mib, 0, 16, -1, 8 * mib},
+		{"small upload", 8 * mib, 0, 16, mib, mib},
+		{"tiny upload keeps the minimum", 8 * mib, 0, 16, 10, googleapi.MinUploadChunkSize},
+		{"budget shrinks the chunk", 16 * mib, 64 * mib, 16, -1, 4 * mib},
+		{"budget above the chunk", 4 * mib, 1024 * mib, 16, -1, 4 * mib},
+		{"budget without a cap is ignored", 8 * mib, 64 * mib, 0, -1, 8 * mib},
+		{"budget never drops below the minimum", 8 * mib, mib, 64, -1, googleapi.MinUploadChunkSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &uploadChunkSize, tt.chunkSize)
+			setVar(t, &uploadMemoryBudget, tt.budget)
+			setVar(t, &maxConcurrentUploads, tt.maxUploads)
+			if got := uploadBufferSize(tt.contentLength); got != tt.want {
+				t.Errorf("uploadBufferSize(%d) = %d, want %d", tt.contentLength, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTryAcquireUpload(t *testing.T) {
+	tests := []struct {
+		name string
+		cap  int
+		want int // uploads admitted out of 5
+	}{
+		{"capped", 2, 2},
+		{"uncapped", 0, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &uploadSlots, newUploadSlots(tt.cap))
+
+			var releases []func()
+			for range 5 {
+				if release, ok := tryAcquireUpload(); ok {
+					releases = append(releases, release)
+				}
+			}
+			if len(releases) != tt.want {
+				t.Fatalf("admitted %d uploads, want %d", len(releases), tt.want)
+			}
+
+			// A finished upload frees its slot for the next one
+			releases[0]()
+			release, ok := tryAcquireUpload()
+			if !ok {
+				t.Fatal("slot was not freed by release")
+			}
+			release()
+			for _, release := range releases[1:] {
+				release()
+			}
+		})
+	}
+}
+
+func TestWriteUploadsBusy(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeUploadsBusy(rec)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("missing Retry-After header")
+	}
+	if body := decodeError(t, rec); body.Code != "too_many_uploads" {
+		t.Errorf("error code = %q, want too_many_uploads", body.Code)
+	}
+}
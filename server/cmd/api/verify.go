@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/devrayat000/video-process/models"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"google.golang.org/api/iterator"
+	"gorm.io/gorm"
+)
+
+type renditionVerification struct {
+	Resolution string `json:"resolution"`
+	PlaylistOK bool   `json:"playlist_ok"`
+	SegmentsOK bool   `json:"segments_ok"`
+	Error      string `json:"error,omitempty"`
+}
+
+// verifyHandler re-reads the stored objects of every rendition and compares
+// their MD5s with the checksums recorded at upload time.
+func verifyHandler(gormDB *gorm.DB, gcsClient *storage.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enableCors(&w)
+
+		if r.Method == "OPTIONS" {
+			return
+		}
+
+		if r.Method != "GET" {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+			return
+		}
+
+		videoID, ok := parseVideoID(w, r.PathValue("id"))
+		if !ok {
+			return
+		}
+		ctx := r.Context()
+
+		video, err := gorm.G[models.Video](gormDB).Where("id = ?", videoID).First(ctx)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "video_not_found", "Video not found")
+			return
+		}
+
+		resolutions, err := gorm.G[models.VideoResolution](gormDB).Where("video_id = ?", video.ID).Find(ctx)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "database_error", "Failed to fetch resolutions")
+			return
+		}
+
+		bucket := gcsClient.Bucket(outputBucketOf(video))
+		results := make([]renditionVerification, 0, len(resolutions))
+		allOK := true
+
+		for _, res := range resolutions {
+			result := renditionVerification{Resolution: res.Resolution}
+
+			attrs, err := server_utils.StatObject(ctx, bucket, res.PlaylistS3Key)
+			switch {
+			case err != nil:
+				result.Error = err.Error()
+			case attrs == nil:
+				result.Error = "playlist missing"
+			default:
+				result.PlaylistOK = res.Checksum != "" && hex.EncodeToString(attrs.MD5) == res.Checksum
+			}
+
+			// Segments live next to the playlist
+			segmentMD5s := make(map[string][]byte)
+			prefix := path.Dir(res.PlaylistS3Key) + "/"
+			it := bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+			for {
+				obj, err := it.Next()
+				if err == iterator.Done {
+					break
+				}
+				if err != nil {
+					log.Printf("Failed to list %s: %v", prefix, err)
+					result.Error = "failed to list segments"
+					break
+				}
+				name := strings.TrimPrefix(obj.Name, prefix)
+				if strings.HasSuffix(name, ".ts") || strings.HasSuffix(name, ".m4s") {
+					segmentMD5s[name] = obj.MD5
+				}
+			}
+			result.SegmentsOK = res.SegmentsChecksum != "" && server_utils.RollupChecksum(segmentMD5s) == res.SegmentsChecksum
+
+			allOK = allOK && result.PlaylistOK && result.SegmentsOK
+			results = append(results, result)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":         video.ID,
+			"ok":         allOK,
+			"renditions": results,
+		})
+	}
+}
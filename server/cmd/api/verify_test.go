@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"github.com/google/uuid"
+)
+
+func TestVerifyHandler(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	id := uuid.New()
+	prefix := id.String() + "/processed/stream_0/"
+	playlist := []byte("#EXTM3U\n")
+	segments := map[string][]byte{
+		"segment_000.ts": []byte("segment 0"),
+		"segment_001.ts": []byte("segment 1"),
+	}
+
+	md5Of := func(data []byte) []byte {
+		sum := md5.Sum(data)
+		return sum[:]
+	}
+	playlistChecksum := hex.EncodeToString(md5Of(playlist))
+	segmentMD5s := make(map[string][]byte)
+	for name, data := range segments {
+		segmentMD5s[name] = md5Of(data)
+	}
+	segmentsChecksum := server_utils.RollupChecksum(segmentMD5s)
+
+	tests := []struct {
+		name           string
+		corrupt        string
+		delete         string
+		checksum       string
+		wantPlaylistOK bool
+		wantSegmentsOK bool
+	}{
+		{name: "intact", checksum: playlistChecksum, wantPlaylistOK: true, wantSegmentsOK: true},
+		{name: "corrupted segment", corrupt: "segment_001.ts", checksum: playlistChecksum, wantPlaylistOK: true},
+		{name: "missing segment", delete: "segment_000.ts", checksum: playlistChecksum, wantPlaylistOK: true},
+		{name: "corrupted playlist", corrupt: "playlist.m3u8", checksum: playlistChecksum, wantSegmentsOK: true},
+		{name: "missing playlist", delete: "playlist.m3u8", checksum: playlistChecksum, wantSegmentsOK: true},
+		{name: "no recorded checksum", wantSegmentsOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gcsClient, store := testgcs.Start(t)
+			store.Put("videos", prefix+"playlist.m3u8", playlist)
+			for name, data := range segments {
+				store.Put("videos", prefix+name, data)
+			}
+			if tt.corrupt != "" {
+				store.Put("videos", prefix+tt.corrupt, []byte("bit rot"))
+			}
+			if tt.delete != "" {
+				gcsClient.Bucket("videos").Object(prefix + tt.delete).Delete(t.Context())
+			}
+
+			gormDB, _ := testdb.Open(t, func(q testdb.Query) testdb.Result {
+				if strings.Contains(q.SQL, `FROM "video_resolutions"`) {
+					return testdb.Result{
+						Columns: []string{"video_id", "resolution", "playlist_s3_key", "checksum", "segments_checksum"},
+						Rows:    [][]any{{id.String(), "720p", prefix + "playlist.m3u8", tt.checksum, segmentsChecksum}},
+					}
+				}
+				return testdb.Result{Columns: []string{"id"}, Rows: [][]any{{id.String()}}}
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/videos/"+id.String()+"/verify", nil)
+			req.SetPathValue("id", id.String())
+			rec := httptest.NewRecorder()
+			verifyHandler(gormDB, gcsClient).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var body struct {
+				OK         bool                    `json:"ok"`
+				Renditions []renditionVerification `json:"renditions"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.Renditions) != 1 {
+				t.Fatalf("renditions = %+v", body.Renditions)
+			}
+			got := body.Renditions[0]
+			if got.PlaylistOK != tt.wantPlaylistOK || got.SegmentsOK != tt.wantSegmentsOK {
+				t.Errorf("playlist ok %v, segments ok %v; want %v, %v (%+v)",
+					got.PlaylistOK, got.SegmentsOK, tt.wantPlaylistOK, tt.wantSegmentsOK, got)
+			}
+			if want := tt.wantPlaylistOK && tt.wantSegmentsOK; body.OK != want {
+				t.Errorf("ok = %v, want %v", body.OK, want)
+			}
+		})
+	}
+}
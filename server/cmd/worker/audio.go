@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// AUDIO_CODEC selects the audio encoder: "aac" (FFmpeg native, default),
+// "libfdk_aac" (better quality at low bitrates) or "libopus".
+var audioCodec = server_utils.GetEnv("AUDIO_CODEC", "aac")
+
+var supportedAudioCodecs = []string{"aac", "libfdk_aac", "libopus"}
+
+// validateAudioCodec checks the configured codec is one we know how to drive.
+// Whether the FFmpeg build ships it is part of the startup capability check.
+func validateAudioCodec(codec string) error {
+	known := false
+	for _, c := range supportedAudioCodecs {
+		if c == codec {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return fmt.Errorf("unsupported AUDIO_CODEC %q (expected one of %v)", codec, supportedAudioCodecs)
+	}
+	return nil
+}
+
+// Audio bitrate in kbps, decoupled from the video tier. AUDIO_BITRATE gives
+// every variant the same audio (0 = each rendition's own rate); the floor and
+// ceiling then clamp whatever rate applies (0 = no bound).
+var (
+	sharedAudioBitrate = server_utils.GetEnvInt("AUDIO_BITRATE", 0)
+	minAudioBitrate    = server_utils.GetEnvInt("AUDIO_BITRATE_MIN", 0)
+	maxAudioBitrate    = server_utils.GetEnvInt("AUDIO_BITRATE_MAX", 0)
+)
+
+// validateAudioBitrate rejects negative rates and an inverted range
+func validateAudioBitrate(shared, floor, ceiling int) error {
+	if shared < 0 || floor < 0 || ceiling < 0 {
+		return fmt.Errorf("AUDIO_BITRATE, AUDIO_BITRATE_MIN and AUDIO_BITRATE_MAX must not be negative")
+	}
+	if ceiling > 0 && floor > ceiling {
+		return fmt.Errorf("AUDIO_BITRATE_MIN (%d) is above AUDIO_BITRATE_MAX (%d)", floor, ceiling)
+	}
+	return nil
+}
+
+// audioBitrate returns the audio rate in kbps to encode alongside r
+func audioBitrate(r Rendition) int {
+	rate := r.AudioRate
+	if sharedAudioBitrate > 0 {
+		rate = sharedAudioBitrate
+	}
+	if minAudioBitrate > 0 {
+		rate = max(rate, minAudioBitrate)
+	}
+	if maxAudioBitrate > 0 {
+		rate = min(rate, maxAudioBitrate)
+	}
+	return rate
+}
+
+// AUDIO_CHANNELS picks the output layout: "source" keeps the original,
+// "mono", "stereo" or "5.1" force one, and "auto" (default) keeps mono
+// sources mono and downmixes everything else to stereo.
+var audioChannelsMode = server_utils.GetEnv("AUDIO_CHANNELS", "auto")
+
+var audioChannelModes = map[string]int{
+	"auto":   0,
+	"source": 0,
+	"mono":   1,
+	"stereo": 2,
+	"5.1":    6,
+}
+
+func validateAudioChannels(mode string) error {
+	if _, ok := audioChannelModes[mode]; !ok {
+		return fmt.Errorf("unsupported AUDIO_CHANNELS %q (expected auto, source, mono, stereo or 5.1)", mode)
+	}
+	return nil
+}
+
+// outputChannels resolves the channel count for a source with the given
+// number of channels (zero when unknown). Layouts are never upmixed.
+func outputChannels(mode string, source int) int {
+	switch mode {
+	case "source":
+		if source > 0 {
+			return source
+		}
+		return 2
+	case "auto":
+		if source == 1 {
+			return 1
+		}
+		return 2
+	}
+
+	channels := audioChannelModes[mode]
+	if source > 0 && source < channels {
+		return source
+	}
+	return channels
+}
+
+// audioChannelArgs sets the channel count for the audio output stream at index
+func audioChannelArgs(codec string, index, channels int) []string {
+	args := []string{fmt.Sprintf("-ac:a:%d", index), strconv.Itoa(channels)}
+	if codec == "libopus" && channels > 2 {
+		// Surround Opus needs the Vorbis channel mapping family
+		args = append(args, fmt.Sprintf("-mapping_family:a:%d", index), "1")
+	}
+	return args
+}
+
+// audioCodecArgs returns the encoder flags for the audio output stream at index.
+func audioCodecArgs(codec string, index, bitrateKbps int) []string {
+	args := []string{
+		fmt.Sprintf("-c:a:%d", index), codec,
+		fmt.Sprintf("-b:a:%d", index), fmt.Sprintf("%dk", bitrateKbps),
+	}
+
+	switch codec {
+	case "libfdk_aac":
+		// HE-AAC holds up much better than LC at low bitrates
+		profile := "aac_low"
+		if bitrateKbps <= 64 {
+			profile = "aac_he"
+		}
+		args = append(args, fmt.Sprintf("-profile:a:%d", index), profile)
+	case "aac":
+		args = append(args, fmt.Sprintf("-profile:a:%d", index), "aac_low")
+	}
+
+	return args
+}
+
+// segmentTypeFor picks the HLS segment container. Opus isn't carried in
+// MPEG-TS by HLS players, and LL-HLS parts are fMP4 fragments, so both need
+// fragmented MP4.
+func segmentTypeFor(codec string, lowLatency bool) string {
+	if codec == "libopus" || lowLatency {
+		return "fmp4"
+	}
+	return "mpegts"
+}
+
+// segmentExtension is the file extension FFmpeg segments get for a segment type.
+func segmentExtension(segmentType string) string {
+	if segmentType == "fmp4" {
+		return ".m4s"
+	}
+	return ".ts"
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestValidateAudioCodec(t *testing.T) {
+	tests := []struct {
+		codec   string
+		wantErr bool
+	}{
+		{"aac", false},
+		{"libfdk_aac", false},
+		{"libopus", false},
+		{"libx264", true},
+		{"mp3", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.codec, func(t *testing.T) {
+			err := validateAudioCodec(tt.codec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateAudioCodec(%q) error = %v, wantErr %v", tt.codec, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAudioCodecArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		codec   string
+		index   int
+		bitrate int
+		want    []string
+	}{
+		{"native aac", "aac", 0, 128, []string{"-c:a:0", "aac", "-b:a:0", "128k", "-profile:a:0", "aac_low"}},
+		{"fdk at normal bitrate", "libfdk_aac", 1, 96, []string{"-c:a:1", "libfdk_aac", "-b:a:1", "96k", "-profile:a:1", "aac_low"}},
+		{"fdk at low bitrate", "libfdk_aac", 2, 64, []string{"-c:a:2", "libfdk_aac", "-b:a:2", "64k", "-profile:a:2", "aac_he"}},
+		{"opus has no profile", "libopus", 0, 96, []string{"-c:a:0", "libopus", "-b:a:0", "96k"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := audioCodecArgs(tt.codec, tt.index, tt.bitrate); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("audioCodecArgs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateAudioBitrate(t *testing.T) {
+	tests := []struct {
+		name                   string
+		shared, floor, ceiling int
+		wantErr                bool
+	}{
+		{"unset", 0, 0, 0, false},
+		{"shared", 128, 0, 0, false},
+		{"range", 0, 96, 192, false},
+		{"floor only", 0, 128, 0, false},
+		{"negative", -1, 0, 0, true},
+		{"inverted range", 0, 192, 96, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAudioBitrate(tt.shared, tt.floor, tt.ceiling)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateAudioBitrate error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAudioBitrate(t *testing.T) {
+	tests := []struct {
+		name                   string
+		shared, floor, ceiling int
+		tierRate               int
+		want                   int
+	}{
+		{"tier rate", 0, 0, 0, 96, 96},
+		{"shared overrides the tier", 128, 0, 0, 96, 128},
+		{"shared overrides a higher tier", 128, 0, 0, 256, 128},
+		{"floor raises low tiers", 0, 128, 0, 96, 128},
+		{"ceiling lowers high tiers", 0, 0, 160, 256, 160},
+		{"inside the range", 0, 96, 192, 128, 128},
+		{"range clamps shared", 320, 96, 192, 96, 192},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &sharedAudioBitrate, tt.shared)
+			setVar(t, &minAudioBitrate, tt.floor)
+			setVar(t, &maxAudioBitrate, tt.ceiling)
+			if got := audioBitrate(Rendition{Height: 240, AudioRate: tt.tierRate}); got != tt.want {
+				t.Errorf("audioBitrate = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranscodeSharedAudioBitrate(t *testing.T) {
+	ffmpeg, ffprobe, logFile := customTools(t, testProbe)
+	setVar(t, &ffmpegPath, ffmpeg)
+	setVar(t, &ffprobePath, ffprobe)
+	setVar(t, &gcsBucket, "videos")
+	setVar(t, &sharedAudioBitrate, 128)
+
+	useRedis(t, nil)
+	gcsClient, _ := testgcs.Start(t)
+	gormDB, _ := testdb.Open(t, nil)
+	job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"}
+	if err := processVideoStreaming(context.Background(), gcsClient, gormDB, job); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var transcode []string
+	for _, call := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(call, "ffmpeg ") && strings.Contains(call, "-filter_complex ") {
+			transcode = strings.Fields(call)
+		}
+	}
+
+	// The 720p source yields five variants, 720p down to 144p, each of
+	// which would otherwise carry its tier's 160k to 96k audio
+	for i := range 5 {
+		flag := fmt.Sprintf("-b:a:%d", i)
+		at := slices.Index(transcode, flag)
+		if at < 0 || at+1 >= len(transcode) || transcode[at+1] != "128k" {
+			t.Errorf("variant %d audio bitrate: args %q, want %s 128k", i, transcode, flag)
+		}
+	}
+}
+
+func TestSegmentTypeFor(t *testing.T) {
+	tests := []struct {
+		codec      string
+		lowLatency bool
+		wantType   string
+		wantExt    string
+	}{
+		{"aac", false, "mpegts", ".ts"},
+		{"libfdk_aac", false, "mpegts", ".ts"},
+		{"libopus", false, "fmp4", ".m4s"},
+		{"aac", true, "fmp4", ".m4s"},
+		{"libopus", true, "fmp4", ".m4s"},
+	}
+	for _, tt := range tests {
+		segmentType := segmentTypeFor(tt.codec, tt.lowLatency)
+		if segmentType != tt.wantType || segmentExtension(segmentType) != tt.wantExt {
+			t.Errorf("%s (LL-HLS %v): segment type %q (%s), want %q (%s)", tt.codec, tt.lowLatency, segmentType, segmentExtension(segmentType), tt.wantType, tt.wantExt)
+		}
+	}
+}
+
+func TestValidateAudioChannels(t *testing.T) {
+	for _, mode := range []string{"auto", "source", "mono", "stereo", "5.1"} {
+		if err := validateAudioChannels(mode); err != nil {
+			t.Errorf("validateAudioChannels(%q) = %v", mode, err)
+		}
+	}
+	for _, mode := range []string{"", "7.1", "quad", "2"} {
+		if err := validateAudioChannels(mode); err == nil {
+			t.Errorf("validateAudioChannels(%q) accepted an unknown mode", mode)
+		}
+	}
+}
+
+func TestOutputChannels(t *testing.T) {
+	tests := []struct {
+		mode   string
+		source int
+		want   int
+	}{
+		{"auto", 1, 1},
+		{"auto", 2, 2},
+		{"auto", 6, 2},
+		{"auto", 0, 2},
+		{"source", 1, 1},
+		{"source", 6, 6},
+		{"source", 0, 2},
+		{"mono", 6, 1},
+		{"stereo", 6, 2},
+		{"stereo", 1, 1},
+		{"5.1", 6, 6},
+		{"5.1", 8, 6},
+		{"5.1", 2, 2},
+		{"5.1", 0, 6},
+	}
+	for _, tt := range tests {
+		if got := outputChannels(tt.mode, tt.source); got != tt.want {
+			t.Errorf("outputChannels(%q, %d) = %d, want %d", tt.mode, tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestAudioChannelArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		codec    string
+		index    int
+		channels int
+		want     []string
+	}{
+		{"mono aac", "aac", 0, 1, []string{"-ac:a:0", "1"}},
+		{"stereo aac", "aac", 1, 2, []string{"-ac:a:1", "2"}},
+		{"surround aac", "libfdk_aac", 2, 6, []string{"-ac:a:2", "6"}},
+		{"stereo opus", "libopus", 0, 2, []string{"-ac:a:0", "2"}},
+		{"surround opus", "libopus", 1, 6, []string{"-ac:a:1", "6", "-mapping_family:a:1", "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := audioChannelArgs(tt.codec, tt.index, tt.channels); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("audioChannelArgs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+var (
+	// AV_ALIGN decides what happens when the audio and video streams differ
+	// in length: "pad" (default) extends the shorter one, with silence or by
+	// holding the last frame, so nothing is lost; "shortest" cuts both at the
+	// end of the shorter one; "off" encodes them as they are.
+	avAlignMode = server_utils.GetEnv("AV_ALIGN", "pad")
+	// Length difference below which the streams are treated as aligned
+	avAlignTolerance = server_utils.GetEnvDuration("AV_ALIGN_TOLERANCE", 500*time.Millisecond)
+)
+
+func validateAVAlign(mode string) error {
+	switch mode {
+	case "pad", "shortest", "off":
+		return nil
+	}
+	return fmt.Errorf("unsupported AV_ALIGN %q (expected pad, shortest or off)", mode)
+}
+
+// avAlignment is how a transcode evens out its audio and video lengths.
+// The zero value changes nothing.
+type avAlignment struct {
+	// PadVideo is how many seconds the last frame is held for
+	PadVideo float64
+	// PadAudioTo is the length in seconds silence pads the audio out to
+	PadAudioTo float64
+	// EndAt replaces the input end position (the -to of trimArgs)
+	EndAt float64
+}
+
+// planAVAlignment compares the probed stream durations, limited to the trim
+// range, and picks the alignment for mode. Unknown durations (zero) and
+// differences within tolerance need none.
+func planAVAlignment(mode string, videoDuration, audioDuration, start, end float64, tolerance time.Duration) avAlignment {
+	if mode == "off" || videoDuration <= 0 || audioDuration <= 0 {
+		return avAlignment{}
+	}
+
+	videoEnd, audioEnd := videoDuration, audioDuration
+	if end > 0 {
+		videoEnd, audioEnd = min(videoEnd, end), min(audioEnd, end)
+	}
+	if math.Abs(videoEnd-audioEnd) <= tolerance.Seconds() {
+		return avAlignment{}
+	}
+
+	switch {
+	case mode == "shortest":
+		return avAlignment{EndAt: min(videoEnd, audioEnd)}
+	case audioEnd < videoEnd:
+		// Output timestamps start at zero after a trim
+		return avAlignment{PadAudioTo: videoEnd - max(start, 0)}
+	default:
+		return avAlignment{PadVideo: audioEnd - videoEnd}
+	}
+}
+
+// videoFilter returns the filter that holds the last frame, or "" when the
+// video isn't padded
+func (a avAlignment) videoFilter() string {
+	if a.PadVideo <= 0 {
+		return ""
+	}
+	return "tpad=stop_mode=clone:stop_duration=" + strconv.FormatFloat(a.PadVideo, 'f', 3, 64)
+}
+
+// audioArgs pads the output audio stream(s) matching spec, e.g. "a:0" for
+// the first HLS variant or "a" for every audio stream of an output
+func (a avAlignment) audioArgs(spec string) []string {
+	if a.PadAudioTo <= 0 {
+		return nil
+	}
+	return []string{"-filter:" + spec, "apad=whole_dur=" + strconv.FormatFloat(a.PadAudioTo, 'f', 3, 64)}
+}
+
+// apply updates the metadata to the aligned output so progress is measured
+// against what is actually encoded
+func (a avAlignment) apply(metadata *VideoMetadata, start float64) {
+	switch {
+	case a.EndAt > 0:
+		full := metadata.Duration
+		metadata.Duration = max(a.EndAt-max(start, 0), 0)
+		if full > 0 && metadata.Frames > 0 {
+			metadata.Frames = int64(math.Round(float64(metadata.Frames) * metadata.Duration / full))
+		}
+	case a.PadVideo > 0 && metadata.FrameRate > 0:
+		metadata.Frames += int64(math.Round(a.PadVideo * metadata.FrameRate))
+	}
+}
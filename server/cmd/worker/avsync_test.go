@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestPlanAVAlignment(t *testing.T) {
+	tolerance := 500 * time.Millisecond
+	tests := []struct {
+		name         string
+		mode         string
+		video, audio float64
+		start, end   float64
+		want         avAlignment
+	}{
+		{"off", "off", 10, 5, 0, 0, avAlignment{}},
+		{"unknown video", "pad", 0, 5, 0, 0, avAlignment{}},
+		{"unknown audio", "pad", 10, 0, 0, 0, avAlignment{}},
+		{"within tolerance", "pad", 10, 10.4, 0, 0, avAlignment{}},
+		{"pad short audio", "pad", 10, 8, 0, 0, avAlignment{PadAudioTo: 10}},
+		{"pad short video", "pad", 8, 10, 0, 0, avAlignment{PadVideo: 2}},
+		{"pad audio after trim start", "pad", 10, 8, 2, 0, avAlignment{PadAudioTo: 8}},
+		{"trim end hides the gap", "pad", 10, 8, 0, 7, avAlignment{}},
+		{"trim end inside the gap", "pad", 10, 8, 0, 9, avAlignment{PadAudioTo: 9}},
+		{"shortest", "shortest", 10, 8, 0, 0, avAlignment{EndAt: 8}},
+		{"shortest with trim end", "shortest", 8, 10, 1, 9, avAlignment{EndAt: 8}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := planAVAlignment(tt.mode, tt.video, tt.audio, tt.start, tt.end, tolerance)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planAVAlignment = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAVAlignmentArgs(t *testing.T) {
+	a := avAlignment{PadVideo: 1.5, PadAudioTo: 12.25}
+	if got, want := a.videoFilter(), "tpad=stop_mode=clone:stop_duration=1.500"; got != want {
+		t.Errorf("videoFilter = %q, want %q", got, want)
+	}
+	if got, want := a.audioArgs("a:1"), []string{"-filter:a:1", "apad=whole_dur=12.250"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("audioArgs = %q, want %q", got, want)
+	}
+
+	var none avAlignment
+	if none.videoFilter() != "" || none.audioArgs("a") != nil {
+		t.Error("zero alignment should add no filters")
+	}
+}
+
+func TestAVAlignmentApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		align avAlignment
+		start float64
+		in    VideoMetadata
+		want  VideoMetadata
+	}{
+		{
+			name:  "cut",
+			align: avAlignment{EndAt: 8},
+			in:    VideoMetadata{Duration: 10, Frames: 250},
+			want:  VideoMetadata{Duration: 8, Frames: 200},
+		},
+		{
+			name:  "cut after trim start",
+			align: avAlignment{EndAt: 8},
+			start: 2,
+			in:    VideoMetadata{Duration: 10, Frames: 250},
+			want:  VideoMetadata{Duration: 6, Frames: 150},
+		},
+		{
+			name:  "held frames",
+			align: avAlignment{PadVideo: 2},
+			in:    VideoMetadata{Duration: 10, Frames: 250, FrameRate: 25},
+			want:  VideoMetadata{Duration: 10, Frames: 300, FrameRate: 25},
+		},
+		{
+			name:  "padded audio leaves video alone",
+			align: avAlignment{PadAudioTo: 12},
+			in:    VideoMetadata{Duration: 10, Frames: 250},
+			want:  VideoMetadata{Duration: 10, Frames: 250},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.in
+			tt.align.apply(&got, tt.start)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("apply = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateAVAlign(t *testing.T) {
+	for _, mode := range []string{"pad", "shortest", "off"} {
+		if err := validateAVAlign(mode); err != nil {
+			t.Errorf("validateAVAlign(%q) = %v", mode, err)
+		}
+	}
+	for _, mode := range []string{"", "longest", "PAD"} {
+		if err := validateAVAlign(mode); err == nil {
+			t.Errorf("validateAVAlign(%q) accepted", mode)
+		}
+	}
+}
+
+func TestRequiredFiltersForAlignment(t *testing.T) {
+	tests := []struct {
+		mode string
+		want []string
+	}{
+		{"pad", []string{"split", "scale", "tpad", "apad"}},
+		{"shortest", []string{"split", "scale"}},
+		{"off", []string{"split", "scale"}},
+	}
+	for _, tt := range tests {
+		setVar(t, &avAlignMode, tt.mode)
+		if got := requiredFilters(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("AV_ALIGN=%s: requiredFilters = %q, want %q", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestTranscodeAlignsAV(t *testing.T) {
+	probe := func(video, audio string) string {
+		return `{"streams": [
+			{"codec_type": "video", "width": 1280, "height": 720, "nb_frames": "48", "avg_frame_rate": "24/1", "duration": "` + video + `"},
+			{"codec_type": "audio", "channels": 2, "duration": "` + audio + `"}
+		], "format": {"duration": "2.0"}}`
+	}
+
+	tests := []struct {
+		name     string
+		mode     string
+		probe    string
+		want     []string
+		wantNone []string
+	}{
+		{"short audio padded", "pad", probe("2.0", "1.0"), []string{"-filter:a:0 apad=whole_dur=2.000"}, []string{"tpad="}},
+		{"short video padded", "pad", probe("1.0", "2.0"), []string{"[0:v:0]tpad=stop_mode=clone:stop_duration=1.000,split="}, []string{"apad="}},
+		{"shortest cuts both", "shortest", probe("2.0", "1.0"), []string{"-to 1.000"}, []string{"apad=", "tpad="}},
+		{"aligned source", "pad", probe("2.0", "2.0"), nil, []string{"apad=", "tpad="}},
+		{"off", "off", probe("2.0", "1.0"), nil, []string{"apad=", "tpad="}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &avAlignMode, tt.mode)
+			ffmpeg, ffprobe, logFile := customTools(t, tt.probe)
+			setVar(t, &ffmpegPath, ffmpeg)
+			setVar(t, &ffprobePath, ffprobe)
+			setVar(t, &gcsBucket, "videos")
+			useRedis(t, nil)
+			gcsClient, _ := testgcs.Start(t)
+			gormDB, _ := testdb.Open(t, nil)
+
+			job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"}
+			if err := processVideoStreaming(context.Background(), gcsClient, gormDB, job); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(logFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(data), want) {
+					t.Errorf("transcode is missing %q:\n%s", want, data)
+				}
+			}
+			for _, unwanted := range tt.wantNone {
+				if strings.Contains(string(data), unwanted) {
+					t.Errorf("transcode has %q:\n%s", unwanted, data)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// ffmpegCapabilities describes what the local FFmpeg build can do
+type ffmpegCapabilities struct {
+	Version  string
+	Encoders map[string]bool
+	Filters  map[string]bool
+}
+
+// probeFFmpegCapabilities runs `ffmpeg -version`, `-encoders` and `-filters`
+// once at startup, and makes sure ffprobe is runnable too.
+func probeFFmpegCapabilities(ctx context.Context) (*ffmpegCapabilities, error) {
+	version, err := exec.CommandContext(ctx, ffmpegPath, "-hide_banner", "-version").Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg -version failed: %w", err)
+	}
+	if err := exec.CommandContext(ctx, ffprobePath, "-hide_banner", "-version").Run(); err != nil {
+		return nil, fmt.Errorf("ffprobe -version failed: %w", err)
+	}
+
+	encoders, err := exec.CommandContext(ctx, ffmpegPath, "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg -encoders failed: %w", err)
+	}
+
+	filters, err := exec.CommandContext(ctx, ffmpegPath, "-hide_banner", "-filters").Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg -filters failed: %w", err)
+	}
+
+	firstLine, _, _ := strings.Cut(string(version), "\n")
+	return &ffmpegCapabilities{
+		Version:  strings.TrimSpace(firstLine),
+		Encoders: parseEncoders(string(encoders)),
+		Filters:  parseFilters(string(filters)),
+	}, nil
+}
+
+// parseEncoders extracts encoder names from `ffmpeg -encoders` output, where
+// each entry after the "------" separator looks like
+// " A....D aac                  AAC (Advanced Audio Coding)".
+func parseEncoders(output string) map[string]bool {
+	encoders := make(map[string]bool)
+	inList := false
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if !inList {
+			inList = strings.HasPrefix(fields[0], "---")
+			continue
+		}
+		if len(fields) >= 2 && len(fields[0]) == 6 {
+			encoders[fields[1]] = true
+		}
+	}
+
+	return encoders
+}
+
+// parseFilters extracts filter names from `ffmpeg -filters` output. Entries
+// look like " TSC scale             V->V       Scale the input video size";
+// the legend above them has no "->" column and is skipped.
+func parseFilters(output string) map[string]bool {
+	filters := make(map[string]bool)
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && strings.Contains(fields[2], "->") {
+			filters[fields[1]] = true
+		}
+	}
+
+	return filters
+}
+
+// requiredEncoders lists the encoders the configured pipeline uses
+func requiredEncoders() []string {
+	encoders := []string{cpuVideoEncoder, audioCodec}
+	if gpuSlots != nil {
+		encoders = append(encoders, gpuVideoEncoder)
+	}
+	return encoders
+}
+
+// requiredFilters lists the filters the configured pipeline uses
+func requiredFilters() []string {
+	filters := []string{"split", "scale"}
+	if avAlignMode == "pad" {
+		filters = append(filters, "tpad", "apad")
+	}
+	if normalizeFPS {
+		filters = append(filters, "fps")
+	}
+	return filters
+}
+
+// checkFFmpegCapabilities returns an error naming every missing encoder and
+// filter, so a minimal FFmpeg build fails fast instead of mid-job.
+func checkFFmpegCapabilities(caps *ffmpegCapabilities, encoders, filters []string) error {
+	var missingEncoders, missingFilters []string
+	for _, e := range encoders {
+		if !caps.Encoders[e] {
+			missingEncoders = append(missingEncoders, e)
+		}
+	}
+	for _, f := range filters {
+		if !caps.Filters[f] {
+			missingFilters = append(missingFilters, f)
+		}
+	}
+
+	var problems []string
+	if len(missingEncoders) > 0 {
+		problems = append(problems, "missing encoders: "+strings.Join(missingEncoders, ", "))
+	}
+	if len(missingFilters) > 0 {
+		problems = append(problems, "missing filters: "+strings.Join(missingFilters, ", "))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("FFmpeg build is missing required features (%s)", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEncoders(t *testing.T) {
+	want := map[string]bool{"libx264": true, "aac": true, "libopus": true}
+	if got := parseEncoders(sampleEncoders); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseEncoders = %v, want %v", got, want)
+	}
+
+	if got := parseEncoders("ffmpeg: command not found"); len(got) != 0 {
+		t.Errorf("parseEncoders without a list = %v, want none", got)
+	}
+}
+
+func TestParseFilters(t *testing.T) {
+	want := map[string]bool{"scale": true, "split": true, "aresample": true, "apad": true, "tpad": true}
+	if got := parseFilters(sampleFilters); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseFilters = %v, want %v", got, want)
+	}
+}
+
+func TestCheckFFmpegCapabilities(t *testing.T) {
+	caps := &ffmpegCapabilities{
+		Encoders: parseEncoders(sampleEncoders),
+		Filters:  parseFilters(sampleFilters),
+	}
+
+	tests := []struct {
+		name     string
+		encoders []string
+		filters  []string
+		wantErr  string
+	}{
+		{"everything present", []string{"libx264", "aac"}, []string{"split", "scale"}, ""},
+		{"missing codec", []string{"libx264", "libfdk_aac"}, []string{"scale"}, "missing encoders: libfdk_aac"},
+		{"missing filter", []string{"aac"}, []string{"yadif", "scale"}, "missing filters: yadif"},
+		{
+			"lists everything missing",
+			[]string{"libx265", "libfdk_aac"},
+			[]string{"yadif"},
+			"missing encoders: libx265, libfdk_aac; missing filters: yadif",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkFFmpegCapabilities(caps, tt.encoders, tt.filters)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProbeFFmpegCapabilities(t *testing.T) {
+	useFakeTools(t, testProbe)
+
+	caps, err := probeFFmpegCapabilities(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps.Version != "ffmpeg version 6.1.1 Copyright (c) 2000-2023 the FFmpeg developers" {
+		t.Errorf("Version = %q", caps.Version)
+	}
+	if err := checkFFmpegCapabilities(caps, requiredEncoders(), requiredFilters()); err != nil {
+		t.Errorf("default pipeline rejected: %v", err)
+	}
+}
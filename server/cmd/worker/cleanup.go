@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"cloud.google.com/go/storage"
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// Delete whatever output a failed transcode already uploaded, so a failed
+// video never has half a playable stream. Turn off to keep it for debugging.
+var cleanupPartialOutput = server_utils.GetEnvBool("CLEANUP_PARTIAL_OUTPUT", true)
+
+// removePartialOutput deletes the output prefix after a failed transcode. It
+// runs after the failure is recorded and outlives the job's context, since a
+// timeout is a common reason to end up here. The FFMPEG_DEBUG_LOG upload is
+// kept, since a failed transcode is when it matters.
+func removePartialOutput(ctx context.Context, bucket *storage.BucketHandle, prefix string) {
+	if !cleanupPartialOutput {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 2*time.Minute)
+	defer cancel()
+
+	deleted, err := server_utils.DeletePrefixExcept(ctx, bucket, prefix, []string{prefix + ffmpegLogName})
+	if err != nil {
+		log.Printf(" [!] Failed to remove partial output under %s: %v", prefix, err)
+		return
+	}
+	if deleted > 0 {
+		log.Printf(" [i] Removed %d partial output objects under %s", deleted, prefix)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestRemovePartialOutput(t *testing.T) {
+	videoID, otherID := uuid.New(), uuid.New()
+	partial := []string{
+		videoID.String() + "/processed/stream_0/segment_00000.ts",
+		videoID.String() + "/processed/stream_0/segment_00001.ts",
+		videoID.String() + "/processed/stream_1/segment_00000.ts",
+	}
+	kept := []string{
+		otherID.String() + "/processed/stream_0/segment_00000.ts",
+		"uploads/" + videoID.String() + ".mp4",
+	}
+
+	tests := []struct {
+		name    string
+		enabled bool
+		want    []string
+	}{
+		{"enabled", true, kept},
+		{"disabled", false, append(slices.Clone(partial), kept...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &cleanupPartialOutput, tt.enabled)
+			gcsClient, store := testgcs.Start(t)
+			for _, name := range append(slices.Clone(partial), kept...) {
+				store.Put("videos", name, []byte("x"))
+			}
+
+			// A cancelled job context must not stop the cleanup
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			removePartialOutput(ctx, gcsClient.Bucket("videos"), models.OutputPrefix(videoID, 0))
+
+			want := slices.Sorted(slices.Values(tt.want))
+			if got := store.Names("videos"); !slices.Equal(got, want) {
+				t.Errorf("objects = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestFailedTranscodeRemovesPartialOutput(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	setVar(t, &cleanupPartialOutput, true)
+	useFakeTools(t, testProbe)
+	t.Setenv(fakeFFmpegFailEnv, "Conversion failed!")
+	useRedis(t, nil)
+	gcsClient, store := testgcs.Start(t)
+	gormDB, _ := testdb.Open(t, nil)
+
+	job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"}
+	// Left by an upload that ran before the failure
+	store.Put("videos", job.VideoID.String()+"/processed/stream_0/segment_00000.ts", []byte("x"))
+
+	if err := processVideoStreaming(context.Background(), gcsClient, gormDB, job); err == nil {
+		t.Fatal("processVideoStreaming succeeded with a failing ffmpeg")
+	}
+	if names := store.Names("videos"); len(names) != 0 {
+		t.Errorf("partial output left after failure: %v", names)
+	}
+}
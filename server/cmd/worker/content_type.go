@@ -0,0 +1,43 @@
+package main
+
+import (
+	"mime"
+	"path"
+	"strings"
+)
+
+// contentTypes covers every file type the pipeline writes. Browsers and
+// players reject HLS pieces served with the wrong type.
+var contentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".m4s":  "video/iso.segment",
+	".mp4":  "video/mp4", // fMP4 init segments and progressive MP4
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".vtt":  "text/vtt",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".webp": "image/webp",
+	".json": "application/json",
+}
+
+// contentTypeFor returns the content type for an output file, falling back
+// to the system MIME table and then to application/octet-stream.
+func contentTypeFor(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if t, ok := contentTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// isMediaSegment reports whether name is an HLS media segment
+func isMediaSegment(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".ts" || ext == ".m4s"
+}
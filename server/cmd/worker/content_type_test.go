@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestContentTypeFor(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"master.m3u8", "application/vnd.apple.mpegurl"},
+		{"stream_0/playlist.m3u8", "application/vnd.apple.mpegurl"},
+		{"segment_001.ts", "video/mp2t"},
+		{"segment_001.m4s", "video/iso.segment"},
+		{"init.mp4", "video/mp4"},
+		{"progressive_720p.mp4", "video/mp4"},
+		{"audio.m4a", "audio/mp4"},
+		{"audio.aac", "audio/aac"},
+		{"subs_en.vtt", "text/vtt"},
+		{"thumb_0001.jpg", "image/jpeg"},
+		{"poster.jpeg", "image/jpeg"},
+		{"sprite.png", "image/png"},
+		{"sprite.webp", "image/webp"},
+		{"manifest.json", "application/json"},
+		{"SEGMENT_002.TS", "video/mp2t"},
+		{"unknown.bin", "application/octet-stream"},
+		{"no-extension", "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := contentTypeFor(tt.name); got != tt.want {
+				t.Errorf("contentTypeFor(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsMediaSegment(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"segment_001.ts", true},
+		{"segment_001.m4s", true},
+		{"segment_001.M4S", true},
+		{"init.mp4", false},
+		{"playlist.m3u8", false},
+		{"subs_en.vtt", false},
+	}
+	for _, tt := range tests {
+		if got := isMediaSegment(tt.name); got != tt.want {
+			t.Errorf("isMediaSegment(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
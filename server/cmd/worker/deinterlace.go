@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// DEINTERLACE controls deinterlacing: "auto" (default) applies it when
+// ffprobe reports an interlaced field order, "force" always applies it and
+// "off" never does.
+var deinterlaceMode = server_utils.GetEnv("DEINTERLACE", "auto")
+
+// DEINTERLACE_FILTER picks the FFmpeg filter: "bwdif" (default) or "yadif"
+var deinterlaceFilterName = server_utils.GetEnv("DEINTERLACE_FILTER", "bwdif")
+
+func validateDeinterlace(mode, filter string) error {
+	switch mode {
+	case "auto", "force", "off":
+	default:
+		return fmt.Errorf("unsupported DEINTERLACE %q (expected auto, force or off)", mode)
+	}
+	switch filter {
+	case "bwdif", "yadif":
+	default:
+		return fmt.Errorf("unsupported DEINTERLACE_FILTER %q (expected bwdif or yadif)", filter)
+	}
+	return nil
+}
+
+// isInterlacedFieldOrder reports whether an ffprobe field_order value
+// describes interlaced content. "progressive", "unknown" and empty are not.
+func isInterlacedFieldOrder(fieldOrder string) bool {
+	switch fieldOrder {
+	case "tt", "bb", "tb", "bt":
+		return true
+	}
+	return false
+}
+
+// shouldDeinterlace applies DEINTERLACE to the probed source
+func shouldDeinterlace(mode string, interlaced bool) bool {
+	switch mode {
+	case "force":
+		return true
+	case "off":
+		return false
+	}
+	return interlaced
+}
+
+// deinterlaceFilter returns the filter to run on the input before the split.
+// One output frame per frame keeps the source frame rate and frame count.
+func deinterlaceFilter(filter string) string {
+	return filter + "=mode=send_frame:parity=auto:deint=all"
+}
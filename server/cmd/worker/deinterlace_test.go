@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestValidateDeinterlace(t *testing.T) {
+	tests := []struct {
+		mode, filter string
+		wantErr      bool
+	}{
+		{"auto", "bwdif", false},
+		{"force", "yadif", false},
+		{"off", "bwdif", false},
+		{"always", "bwdif", true},
+		{"auto", "w3fdif", true},
+	}
+	for _, tt := range tests {
+		if err := validateDeinterlace(tt.mode, tt.filter); (err != nil) != tt.wantErr {
+			t.Errorf("validateDeinterlace(%q, %q) = %v, want error %v", tt.mode, tt.filter, err, tt.wantErr)
+		}
+	}
+}
+
+func TestShouldDeinterlace(t *testing.T) {
+	tests := []struct {
+		mode       string
+		interlaced bool
+		want       bool
+	}{
+		{"auto", true, true},
+		{"auto", false, false},
+		{"force", false, true},
+		{"off", true, false},
+	}
+	for _, tt := range tests {
+		if got := shouldDeinterlace(tt.mode, tt.interlaced); got != tt.want {
+			t.Errorf("shouldDeinterlace(%q, %v) = %v, want %v", tt.mode, tt.interlaced, got, tt.want)
+		}
+	}
+}
+
+func TestProbeFieldOrder(t *testing.T) {
+	setVar(t, &ffprobePath, "ffprobe")
+
+	tests := []struct {
+		fieldOrder string
+		want       bool
+	}{
+		{"tt", true},
+		{"bb", true},
+		{"tb", true},
+		{"bt", true},
+		{"progressive", false},
+		{"unknown", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fieldOrder, func(t *testing.T) {
+			output := `{"streams": [{"codec_type": "video", "width": 1920, "height": 1080, "nb_frames": "250", "avg_frame_rate": "25/1", "field_order": "` + tt.fieldOrder + `"}], "format": {"duration": "10.0"}}`
+			fakeCommand(t, "ffprobe", "cat <<'PROBE'\n"+output+"\nPROBE")
+
+			metadata, err := getVideoMetadata(context.Background(), "source.mts")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if metadata.Interlaced != tt.want {
+				t.Errorf("field_order=%s: interlaced = %v, want %v", tt.fieldOrder, metadata.Interlaced, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranscodeDeinterlaces(t *testing.T) {
+	interlacedProbe := strings.Replace(testProbe, `"avg_frame_rate": "24/1"`, `"avg_frame_rate": "24/1", "field_order": "tt"`, 1)
+
+	tests := []struct {
+		name       string
+		probe      string
+		mode       string
+		filter     string
+		wantFilter string
+	}{
+		{"interlaced source", interlacedProbe, "auto", "bwdif", "bwdif=mode=send_frame:parity=auto:deint=all"},
+		{"yadif", interlacedProbe, "auto", "yadif", "yadif=mode=send_frame:parity=auto:deint=all"},
+		{"forced on progressive source", testProbe, "force", "bwdif", "bwdif=mode=send_frame:parity=auto:deint=all"},
+		{"progressive source", testProbe, "auto", "bwdif", ""},
+		{"disabled", interlacedProbe, "off", "bwdif", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ffmpeg, ffprobe, logFile := customTools(t, tt.probe)
+			setVar(t, &ffmpegPath, ffmpeg)
+			setVar(t, &ffprobePath, ffprobe)
+			setVar(t, &gcsBucket, "videos")
+			setVar(t, &deinterlaceMode, tt.mode)
+			setVar(t, &deinterlaceFilterName, tt.filter)
+
+			useRedis(t, nil)
+			gcsClient, _ := testgcs.Start(t)
+			gormDB, _ := testdb.Open(t, nil)
+			if err := processVideoStreaming(context.Background(), gcsClient, gormDB, models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"}); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(logFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var graph string
+			for _, call := range strings.Split(string(data), "\n") {
+				if _, after, ok := strings.Cut(call, " -filter_complex "); ok && strings.HasPrefix(call, "ffmpeg ") {
+					graph, _, _ = strings.Cut(after, " ")
+				}
+			}
+
+			// The filter runs once on the input, never per rendition
+			want := "[0:v:0]split="
+			if tt.wantFilter != "" {
+				want = "[0:v:0]" + tt.wantFilter + ",split="
+			}
+			if !strings.HasPrefix(graph, want) {
+				t.Errorf("filter graph %q does not start with %q", graph, want)
+			}
+			if n := strings.Count(graph, "dif="); tt.wantFilter != "" && n != 1 {
+				t.Errorf("deinterlace filter appears %d times in %q, want once", n, graph)
+			}
+		})
+	}
+}
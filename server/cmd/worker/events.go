@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/devrayat000/video-process/models"
+	"github.com/devrayat000/video-process/pubsub"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// publishVideoEvent records a terminal status on the video:events stream with
+// the final video row and its renditions. Failures are only logged; the
+// ephemeral progress channel has already been notified.
+func publishVideoEvent(ctx context.Context, gormDB *gorm.DB, videoID uuid.UUID, status models.VideoStatus) {
+	event := models.VideoEvent{
+		VideoID:   videoID,
+		Status:    status,
+		Timestamp: time.Now(),
+	}
+
+	if video, err := gorm.G[models.Video](gormDB).Where("id = ?", videoID).First(ctx); err != nil {
+		log.Printf(" [!] Failed to load video %s for event: %v", videoID, err)
+	} else {
+		video.Resolutions, err = gorm.G[models.VideoResolution](gormDB).Where("video_id = ?", videoID).Order("bandwidth DESC").Find(ctx)
+		if err != nil {
+			log.Printf(" [!] Failed to load resolutions of %s for event: %v", videoID, err)
+		}
+		event.Video = &video
+	}
+
+	if err := pubsub.PublishVideoEvent(ctx, event); err != nil {
+		log.Printf(" [!] %v", err)
+	}
+}
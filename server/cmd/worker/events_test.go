@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/internal/testredis"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+// streamEvents decodes the entries added to the video:events stream
+func streamEvents(t *testing.T, rdb *testredis.Server) []models.VideoEvent {
+	t.Helper()
+	var events []models.VideoEvent
+	for _, cmd := range rdb.Named("XADD") {
+		if cmd[1] != "video:events" {
+			continue
+		}
+		for i := 2; i+1 < len(cmd); i++ {
+			if cmd[i] == "data" {
+				var event models.VideoEvent
+				if err := json.Unmarshal([]byte(cmd[i+1]), &event); err != nil {
+					t.Fatal(err)
+				}
+				events = append(events, event)
+			}
+		}
+	}
+	return events
+}
+
+// eventsRedis accepts XADD like a real stream
+func eventsRedis(cmd []string) any {
+	if cmd[0] == "xadd" {
+		return "1-0"
+	}
+	return testredis.Status("OK")
+}
+
+func TestCompletionWritesEvent(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	useFakeTools(t, testProbe)
+	rdb := useRedis(t, eventsRedis)
+	gcsClient, _ := testgcs.Start(t)
+
+	job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"}
+	gormDB, _ := testdb.Open(t, func(q testdb.Query) testdb.Result {
+		switch {
+		case strings.HasPrefix(q.SQL, `SELECT * FROM "video_resolutions"`):
+			return testdb.Result{
+				Columns: []string{"video_id", "resolution", "bandwidth"},
+				Rows:    [][]any{{job.VideoID.String(), "720p", int64(2800000)}, {job.VideoID.String(), "480p", int64(1400000)}},
+			}
+		case strings.HasPrefix(q.SQL, `SELECT * FROM "videos"`):
+			return testdb.Result{
+				Columns: []string{"id", "original_name", "status", "duration"},
+				Rows:    [][]any{{job.VideoID.String(), "clip.mp4", "processing", 2.0}},
+			}
+		}
+		return testdb.Result{RowsAffected: 1}
+	})
+	if err := processVideoStreaming(context.Background(), gcsClient, gormDB, job); err != nil {
+		t.Fatal(err)
+	}
+
+	events := streamEvents(t, rdb)
+	if len(events) != 1 {
+		t.Fatalf("events = %+v, want one completion", events)
+	}
+	event := events[0]
+	if event.VideoID != job.VideoID || event.Status != models.StatusCompleted {
+		t.Errorf("event = %s for %s, want completed for %s", event.Status, event.VideoID, job.VideoID)
+	}
+	if event.Video == nil || event.Video.OriginalName != "clip.mp4" || len(event.Video.Resolutions) != 2 {
+		t.Errorf("event summary = %+v, want the video with its renditions", event.Video)
+	}
+}
+
+func TestFailureWritesEvent(t *testing.T) {
+	rdb := useRedis(t, eventsRedis)
+	// Even without the row, the event names the video and its status
+	gormDB, _ := testdb.Open(t, nil)
+
+	videoID := uuid.New()
+	failVideo(context.Background(), gormDB, videoID, "bad input", errors.New("moov atom not found"))
+
+	events := streamEvents(t, rdb)
+	if len(events) != 1 || events[0].VideoID != videoID || events[0].Status != models.StatusFailed {
+		t.Errorf("events = %+v, want one failure for %s", events, videoID)
+	}
+	if events[0].Video != nil {
+		t.Errorf("event summary = %+v, want none when the row can't be read", events[0].Video)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestRenditionExtraArgs(t *testing.T) {
+	tests := []struct {
+		name  string
+		extra []string
+		index int
+		want  []string
+	}{
+		{"none", nil, 0, []string{}},
+		{"scoped to the stream", []string{"-tune", "film"}, 2, []string{"-tune:v:2", "film"}},
+		{"several pairs", []string{"-bf", "3", "-refs", "4"}, 0, []string{"-bf:v:0", "3", "-refs:v:0", "4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := renditionExtraArgs(Rendition{Height: 720, ExtraArgs: tt.extra}, tt.index)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("renditionExtraArgs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranscodeSplicesExtraArgs(t *testing.T) {
+	ffmpeg, ffprobe, logFile := customTools(t, testProbe)
+	setVar(t, &ffmpegPath, ffmpeg)
+	setVar(t, &ffprobePath, ffprobe)
+	setVar(t, &gcsBucket, "videos")
+
+	useRedis(t, nil)
+	gcsClient, _ := testgcs.Start(t)
+	gormDB, _ := testdb.Open(t, nil)
+	job := models.VideoJob{
+		VideoID: uuid.New(),
+		S3Path:  "gs://uploads/source.mp4",
+		Renditions: []models.Rendition{
+			{Height: 720, Bitrate: 2800, MaxRate: 2996, BufSize: 4200, AudioRate: 160, ExtraArgs: []string{"-tune", "film"}},
+			{Height: 480, Bitrate: 1400, MaxRate: 1498, BufSize: 2100, AudioRate: 128},
+		},
+	}
+	if err := processVideoStreaming(context.Background(), gcsClient, gormDB, job); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var transcode string
+	for _, call := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(call, "ffmpeg ") && strings.Contains(call, "-filter_complex ") {
+			transcode = call
+		}
+	}
+	if !strings.Contains(transcode, " -tune:v:0 film ") {
+		t.Errorf("transcode args %q are missing the 720p tuning", transcode)
+	}
+	if strings.Contains(transcode, "-tune:v:1") {
+		t.Errorf("transcode args %q leak the tuning into the 480p stream", transcode)
+	}
+}
+
+func TestTranscodeRejectsUnsafeExtraArgs(t *testing.T) {
+	ffmpeg, ffprobe, logFile := customTools(t, testProbe)
+	setVar(t, &ffmpegPath, ffmpeg)
+	setVar(t, &ffprobePath, ffprobe)
+	setVar(t, &gcsBucket, "videos")
+
+	useRedis(t, nil)
+	gcsClient, _ := testgcs.Start(t)
+	gormDB, db := testdb.Open(t, nil)
+	// A job queued before the API checked ExtraArgs
+	job := models.VideoJob{
+		VideoID: uuid.New(),
+		S3Path:  "gs://uploads/source.mp4",
+		Renditions: []models.Rendition{
+			{Height: 720, Bitrate: 2800, MaxRate: 2996, BufSize: 4200, AudioRate: 160, ExtraArgs: []string{"-f", "null"}},
+		},
+	}
+	if err := processVideoStreaming(context.Background(), gcsClient, gormDB, job); err == nil {
+		t.Fatal("expected the job to be rejected")
+	}
+
+	data, _ := os.ReadFile(logFile)
+	if strings.Contains(string(data), "-filter_complex") {
+		t.Error("ffmpeg ran with an unsafe rendition")
+	}
+	var failed bool
+	for _, q := range db.Matching(`UPDATE "videos"`) {
+		if updatedColumns(q)["status"] == string(models.StatusFailed) {
+			failed = true
+		}
+	}
+	if !failed {
+		t.Error("video was not marked failed")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/devrayat000/video-process/models"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// failurePatterns maps lower-cased fragments of error messages (including the
+// captured FFmpeg stderr) to a failure category. Earlier entries win, so the
+// more specific local causes are listed before the generic network ones.
+var failurePatterns = []struct {
+	category models.FailureCategory
+	patterns []string
+}{
+	{models.FailureDiskError, []string{
+		"no space left on device",
+		"disk quota exceeded",
+		"read-only file system",
+		"failed to create temp dir",
+	}},
+	{models.FailureUploadError, []string{
+		"gcs upload error",
+		"gcs writer close error",
+		"failed to upload master playlist",
+		"failed to close master playlist writer",
+	}},
+	// The source answered with a client error, which a retry won't change.
+	// Listed first so the generic "http error" below doesn't claim it.
+	{models.FailureSourceNotFound, []string{
+		"server returned 4",
+		"http error 4",
+	}},
+	{models.FailureSourceUnavailable, []string{
+		"ffprobe timed out",
+		"connection refused",
+		"connection reset",
+		"connection timed out",
+		"i/o timeout",
+		"network is unreachable",
+		"temporary failure in name resolution",
+		"name or service not known",
+		"server returned 5",
+		"http error",
+	}},
+	{models.FailureUnsupportedInput, []string{
+		"invalid data found when processing input",
+		"could not find codec parameters",
+		"unsupported codec",
+		"decoder not found",
+		"moov atom not found",
+		"does not contain any stream",
+		"matches no streams",
+		"failed to parse video dimensions",
+		"zero-length video",
+		"unsafe source url",
+		"invalid image sequence",
+		"remote source is larger than",
+		// A missing or truncated file: retrying reads the same one again
+		"no such file or directory",
+		"end of file",
+	}},
+}
+
+// classifyFailure inspects an error chain and decides which category it falls
+// into. Anything unrecognised is treated as an encode error.
+func classifyFailure(err error) models.FailureCategory {
+	if err == nil {
+		return models.FailureEncodeError
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, group := range failurePatterns {
+		for _, pattern := range group.patterns {
+			if strings.Contains(msg, pattern) {
+				return group.category
+			}
+		}
+	}
+
+	return models.FailureEncodeError
+}
+
+// failVideo marks the video as failed with a classified cause and publishes a
+// terminal progress event for connected clients.
+func failVideo(ctx context.Context, gormDB *gorm.DB, videoID uuid.UUID, errMsg string, cause error) {
+	// A job cut short by shutdown is redelivered; failing it would send a
+	// terminal event for a video that is about to be processed again
+	if interrupted(ctx) {
+		logf(ctx, " [i] Not failing video_id=%s, interrupted by shutdown: %s", videoID, errMsg)
+		return
+	}
+	// A job that hit its timeout still needs its failure recorded
+	ctx = context.WithoutCancel(ctx)
+	// The message is served by /status, SSE and webhooks
+	errMsg = server_utils.RedactSecrets(errMsg)
+
+	category := classifyFailure(cause)
+
+	_, err := gorm.G[models.Video](gormDB).Where("id = ?", videoID).Updates(ctx, models.Video{
+		Status:          models.StatusFailed,
+		ErrorMessage:    &errMsg,
+		FailureCategory: &category,
+	})
+	if err != nil {
+		logf(ctx, " [!] Failed to mark video as failed: %v", err)
+	}
+
+	progressSink.Publish(models.ProcessingProgress{
+		VideoID:       videoID,
+		Status:        models.StatusFailed,
+		Error:         errMsg,
+		ErrorCategory: string(category),
+		Timestamp:     time.Now(),
+	})
+
+	publishVideoEvent(ctx, gormDB, videoID, models.StatusFailed)
+}
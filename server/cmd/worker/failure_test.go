@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want models.FailureCategory
+	}{
+		{"nil", nil, models.FailureEncodeError},
+		{"unrecognised", errors.New("ffmpeg exited with status 1"), models.FailureEncodeError},
+		{"disk full", errors.New("write /tmp/x/seg0.ts: No space left on device"), models.FailureDiskError},
+		{"temp dir", errors.New("failed to create temp dir: permission denied"), models.FailureDiskError},
+		{"upload", errors.New("GCS upload error: 503"), models.FailureUploadError},
+		{"network", errors.New("dial tcp: i/o timeout"), models.FailureSourceUnavailable},
+		{"probe timeout", fmt.Errorf("ffprobe error: %w", &probeTimeoutError{timeout: time.Minute}), models.FailureSourceUnavailable},
+		{"http status", errors.New("Server returned 503 Service Unavailable"), models.FailureSourceUnavailable},
+		{"http error", errors.New("HTTP error 502 Bad Gateway"), models.FailureSourceUnavailable},
+		{"not found", errors.New("Server returned 404 Not Found"), models.FailureSourceNotFound},
+		{"forbidden", errors.New("HTTP error 403 Forbidden"), models.FailureSourceNotFound},
+		{"missing file", errors.New("/tmp/x/source: No such file or directory"), models.FailureUnsupportedInput},
+		{"truncated file", errors.New("/tmp/x/source: End of file"), models.FailureUnsupportedInput},
+		{"bad input", errors.New("Invalid data found when processing input"), models.FailureUnsupportedInput},
+		{"no streams", errors.New("Stream map '0:v:0' matches no streams."), models.FailureUnsupportedInput},
+		{"wrapped", fmt.Errorf("transcode: %w", errors.New("moov atom not found")), models.FailureUnsupportedInput},
+		{"zero length", errors.New("invalid or zero-length video: duration 0.000s"), models.FailureUnsupportedInput},
+		{"unsafe source", errors.New(`unsafe source URL: host "10.0.0.1" resolves to a non-public address`), models.FailureUnsupportedInput},
+		{"disk wins over network", errors.New("connection reset; no space left on device"), models.FailureDiskError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyFailure(tt.err); got != tt.want {
+				t.Errorf("classifyFailure(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailVideoStoresCategory(t *testing.T) {
+	useRedis(t, nil)
+	gormDB, db := testdb.Open(t, func(q testdb.Query) testdb.Result {
+		return testdb.Result{RowsAffected: 1}
+	})
+
+	failVideo(context.Background(), gormDB, uuid.New(), "bad input", errors.New("moov atom not found"))
+
+	updates := db.Matching(`"failure_category"`)
+	if len(updates) != 1 {
+		t.Fatalf("got %d failure updates, want 1", len(updates))
+	}
+	args := updates[0].Args
+	for _, want := range []any{"failed", "bad input", string(models.FailureUnsupportedInput)} {
+		if !slices.Contains(args, want) {
+			t.Errorf("update args %v do not include %q", args, want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/devrayat000/video-process/models"
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// Retry a failed transcode once with safer settings when FFmpeg's error looks
+// like something those settings can get past
+var ffmpegFallback = server_utils.GetEnvBool("FFMPEG_FALLBACK", true)
+
+// fallbackPatterns are lower-cased FFmpeg stderr fragments that a slower
+// preset or lenient decoding has a fair chance of getting past.
+var fallbackPatterns = []string{
+	"error while decoding",
+	"invalid nal unit",
+	"non-existing pps",
+	"corrupt decoded frame",
+	"error submitting packet to decoder",
+	"error while filtering",
+	"failed to inject frame into filter network",
+	"error initializing output stream",
+	"could not open encoder",
+	"x264 [error]",
+}
+
+// shouldFallback reports whether a failed attempt is worth one retry with
+// fallbackInputArgs and the safe preset. Only FFmpeg's own failures count;
+// disk, upload and network problems are left to the normal failure path.
+func shouldFallback(err error) bool {
+	var ffErr *ffmpegError
+	if !errors.As(err, &ffErr) {
+		return false
+	}
+	if classifyFailure(err) != models.FailureEncodeError {
+		return false
+	}
+
+	stderr := strings.ToLower(ffErr.stderr)
+	for _, pattern := range fallbackPatterns {
+		if strings.Contains(stderr, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// fallbackInputArgs replaces the normal input flags on a retry: corrupt
+// packets are decoded rather than dropped and decoder errors are ignored.
+func fallbackInputArgs(fallback bool) []string {
+	if fallback {
+		return []string{"-err_detect", "ignore_err"}
+	}
+	return []string{"-fflags", "+discardcorrupt"}
+}
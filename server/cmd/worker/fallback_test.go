@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestShouldFallback(t *testing.T) {
+	exit := errors.New("exit status 1")
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"decode error", &ffmpegError{exit, "[h264 @ 0x1] Error while decoding MB 12 40"}, true},
+		{"broken NAL units", &ffmpegError{exit, "[h264 @ 0x1] Invalid NAL unit size (1234 > 567)"}, true},
+		{"missing PPS", &ffmpegError{exit, "[h264 @ 0x1] non-existing PPS 0 referenced"}, true},
+		{"filter graph", &ffmpegError{exit, "Error while filtering: Cannot allocate memory"}, true},
+		{"x264 rejected settings", &ffmpegError{exit, "x264 [error]: malloc of size 1234 failed"}, true},
+		{"encoder init", &ffmpegError{exit, "Could not open encoder before EOF"}, true},
+		{"unrecognised encode error", &ffmpegError{exit, "Conversion failed!"}, false},
+		{"no stderr", &ffmpegError{exit, ""}, false},
+		{"disk full", &ffmpegError{exit, "Error while decoding\nNo space left on device"}, false},
+		{"source gone", &ffmpegError{exit, "Error while decoding\nConnection timed out"}, false},
+		{"not from ffmpeg", errors.New("error while decoding upload response"), false},
+		{"wrapped", fmt.Errorf("transcode: %w", &ffmpegError{exit, "corrupt decoded frame in stream 0"}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shouldFallback(tt.err); got != tt.want {
+				t.Errorf("shouldFallback(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFallbackInputArgs(t *testing.T) {
+	if got, want := fallbackInputArgs(false), []string{"-fflags", "+discardcorrupt"}; !slices.Equal(got, want) {
+		t.Errorf("first attempt = %q, want %q", got, want)
+	}
+	if got, want := fallbackInputArgs(true), []string{"-err_detect", "ignore_err"}; !slices.Equal(got, want) {
+		t.Errorf("fallback attempt = %q, want %q", got, want)
+	}
+}
+
+func TestTranscodeFallback(t *testing.T) {
+	tests := []struct {
+		name         string
+		stderr       string
+		enabled      bool
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"recoverable error", "Error while decoding stream #0:0: Invalid data found", true, 2, false},
+		{"fallback disabled", "Error while decoding stream #0:0: Invalid data found", false, 1, true},
+		{"unrecoverable error", "Conversion failed!", true, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &gcsBucket, "videos")
+			setVar(t, &ffmpegFallback, tt.enabled)
+			useFakeTools(t, testProbe)
+
+			// The first attempt fails; the fallback's lenient flags succeed
+			exe, err := os.Executable()
+			if err != nil {
+				t.Fatal(err)
+			}
+			logFile := filepath.Join(t.TempDir(), "ffmpeg.calls")
+			fakeCommand(t, "ffmpeg", fmt.Sprintf(`echo "$*" >> '%s'
+case "$*" in *discardcorrupt*) echo '%s' >&2; exit 1;; esac
+%s=ffmpeg exec '%s' "$@"`, logFile, tt.stderr, fakeToolEnv, exe))
+
+			useRedis(t, nil)
+			gcsClient, _ := testgcs.Start(t)
+			gormDB, db := testdb.Open(t, nil)
+			err = processVideoStreaming(context.Background(), gcsClient, gormDB, models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("processVideoStreaming error = %v, want error %v", err, tt.wantErr)
+			}
+
+			data, err := os.ReadFile(logFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var attempts []string
+			for _, call := range strings.Split(string(data), "\n") {
+				if strings.Contains(call, "-filter_complex ") {
+					attempts = append(attempts, call)
+				}
+			}
+			if len(attempts) != tt.wantAttempts {
+				t.Fatalf("transcode attempts = %d, want %d", len(attempts), tt.wantAttempts)
+			}
+
+			recorded := len(db.Matching(`"encode_fallback"`)) > 0
+			if recorded != (tt.wantAttempts == 2) {
+				t.Errorf("fallback recorded = %v, want %v", recorded, tt.wantAttempts == 2)
+			}
+			if tt.wantAttempts == 2 {
+				retry := attempts[1]
+				for _, want := range []string{" -err_detect ignore_err ", " -preset:v:0 fast "} {
+					if !strings.Contains(retry, want) {
+						t.Errorf("fallback args %q are missing %q", retry, want)
+					}
+				}
+				if strings.Contains(retry, "+discardcorrupt") {
+					t.Errorf("fallback args %q still drop corrupt packets", retry)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/devrayat000/video-process/models"
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+var (
+	// Binaries to run; point these at a custom static build if needed
+	ffmpegPath  = server_utils.GetEnv("FFMPEG_PATH", "ffmpeg")
+	ffprobePath = server_utils.GetEnv("FFPROBE_PATH", "ffprobe")
+
+	// Extra global FFmpeg flags, e.g. "-filter_threads 4". Validated at
+	// startup against allowedGlobalArgs.
+	ffmpegGlobalArgsRaw = server_utils.GetEnv("FFMPEG_GLOBAL_ARGS", "")
+	ffmpegGlobalArgs    []string
+
+	// Threads per FFmpeg job; zero lets FFmpeg decide
+	ffmpegThreads = server_utils.GetEnvInt("FFMPEG_THREADS", 0)
+	// Transcode log level; progress comes from -progress, so any level works
+	ffmpegLogLevel = server_utils.GetEnv("FFMPEG_LOGLEVEL", "error")
+	// Keep the full FFmpeg stderr and upload it as ffmpeg.log next to the output
+	ffmpegDebugLog = server_utils.GetEnvBool("FFMPEG_DEBUG_LOG", false)
+
+	// Niceness for transcodes (1-19) so co-located jobs keep some CPU; zero
+	// runs FFmpeg at normal priority
+	ffmpegNice = server_utils.GetEnvInt("FFMPEG_NICE", 0)
+
+	// Ceiling on FFmpeg processes running at once in this worker, across all
+	// jobs; zero leaves it to the encoder slots
+	maxFFmpegProcesses = server_utils.GetEnvInt("MAX_FFMPEG_PROCESSES", 0)
+)
+
+var ffmpegSlots = newEncoderSlots(maxFFmpegProcesses)
+
+// allowedGlobalArgs lists the operator-settable flags and whether each takes a
+// value. Anything that could change the output is deliberately left out.
+var allowedGlobalArgs = map[string]bool{
+	"-threads":                true,
+	"-filter_threads":         true,
+	"-filter_complex_threads": true,
+	"-stats_period":           true,
+	"-nostdin":                false,
+	"-hide_banner":            false,
+}
+
+var globalArgValue = regexp.MustCompile(`^[A-Za-z0-9_.:]+$`)
+
+var ffmpegLogLevels = []string{"quiet", "panic", "fatal", "error", "warning", "info", "verbose", "debug", "trace"}
+
+func validateLogLevel(level string) error {
+	for _, l := range ffmpegLogLevels {
+		if l == level {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported FFMPEG_LOGLEVEL %q (expected one of %v)", level, ffmpegLogLevels)
+}
+
+// parseGlobalArgs splits FFMPEG_GLOBAL_ARGS and rejects flags outside the
+// allowlist and values that look like anything but a plain number or word.
+func parseGlobalArgs(raw string) ([]string, error) {
+	fields := strings.Fields(raw)
+	var args []string
+
+	for i := 0; i < len(fields); i++ {
+		flag := fields[i]
+		takesValue, ok := allowedGlobalArgs[flag]
+		if !ok {
+			return nil, fmt.Errorf("FFMPEG_GLOBAL_ARGS: %q is not an allowed flag", flag)
+		}
+		args = append(args, flag)
+
+		if takesValue {
+			if i+1 >= len(fields) {
+				return nil, fmt.Errorf("FFMPEG_GLOBAL_ARGS: %s needs a value", flag)
+			}
+			i++
+			if !globalArgValue.MatchString(fields[i]) {
+				return nil, fmt.Errorf("FFMPEG_GLOBAL_ARGS: invalid value %q for %s", fields[i], flag)
+			}
+			args = append(args, fields[i])
+		}
+	}
+
+	return args, nil
+}
+
+// threadArgs bounds the filter graph and encoder threads when FFMPEG_THREADS
+// is set. The output-side -threads must be repeated for every output.
+func threadArgs(output bool) []string {
+	if ffmpegThreads <= 0 {
+		return nil
+	}
+	n := strconv.Itoa(ffmpegThreads)
+	if output {
+		return []string{"-threads", n}
+	}
+	return []string{"-filter_complex_threads", n}
+}
+
+// renditionExtraArgs scopes a rendition's validated ExtraArgs to its own
+// video output stream, so tuning one rendition can't leak into another.
+func renditionExtraArgs(r Rendition, index int) []string {
+	args := make([]string, 0, len(r.ExtraArgs))
+	for i := 0; i+1 < len(r.ExtraArgs); i += 2 {
+		args = append(args, fmt.Sprintf("%s:v:%d", r.ExtraArgs[i], index), r.ExtraArgs[i+1])
+	}
+	return args
+}
+
+// ffmpegCommand builds the transcode command, under nice when FFMPEG_NICE is set
+func ffmpegCommand(ctx context.Context, args []string) *exec.Cmd {
+	if ffmpegNice > 0 {
+		niceArgs := append([]string{"-n", strconv.Itoa(ffmpegNice), ffmpegPath}, args...)
+		return exec.CommandContext(ctx, "nice", niceArgs...)
+	}
+	return exec.CommandContext(ctx, ffmpegPath, args...)
+}
+
+// acquireFFmpegSlot blocks until another FFmpeg process may start and returns
+// the func that frees the slot once it has exited.
+func acquireFFmpegSlot(ctx context.Context) (func(), error) {
+	if ffmpegSlots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case ffmpegSlots <- struct{}{}:
+		return func() { <-ffmpegSlots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for an FFmpeg slot: %w", ctx.Err())
+	}
+}
+
+const ffmpegLogName = "ffmpeg.log"
+
+// uploadFFmpegLog stores a job's full FFmpeg stderr next to its output. The
+// log is served with the output and FFmpeg prints the signed source URL, so
+// every line is redacted.
+func uploadFFmpegLog(ctx context.Context, bucket *storage.BucketHandle, video models.Video, localPath string) (string, error) {
+	key := video.OutputPrefix() + ffmpegLogName
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open FFmpeg log: %w", err)
+	}
+	defer file.Close()
+
+	writer := bucket.Object(key).NewWriter(ctx)
+	writer.ChunkSize = fileChunkSize(file)
+	writer.ContentType = "text/plain; charset=utf-8"
+
+	scanner := newLineScanner(file)
+	for scanner.Scan() {
+		if _, err := io.WriteString(writer, server_utils.RedactSecrets(scanner.Text())+"\n"); err != nil {
+			writer.Close()
+			return "", fmt.Errorf("GCS upload error for %s: %w", key, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		writer.Close()
+		return "", fmt.Errorf("failed to read FFmpeg log: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("GCS writer close error for %s: %w", key, err)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestParseGlobalArgs(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    []string
+		wantErr bool
+	}{
+		{raw: "", want: nil},
+		{raw: "-threads 4", want: []string{"-threads", "4"}},
+		{raw: "  -filter_threads 2   -nostdin ", want: []string{"-filter_threads", "2", "-nostdin"}},
+		{raw: "-stats_period 0.5 -hide_banner -filter_complex_threads 3", want: []string{"-stats_period", "0.5", "-hide_banner", "-filter_complex_threads", "3"}},
+		{raw: "-threads", wantErr: true},
+		{raw: "-threads 4;rm", wantErr: true},
+		{raw: "-threads $(id)", wantErr: true},
+		{raw: "-y", wantErr: true},
+		{raw: "-i /etc/passwd", wantErr: true},
+		{raw: "-c:v libx265", wantErr: true},
+		{raw: "-threads 4 -f null", wantErr: true},
+		{raw: "output.mp4", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := parseGlobalArgs(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseGlobalArgs(%q) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("parseGlobalArgs(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+// customTools writes ffmpeg and ffprobe wrappers outside PATH that log each
+// invocation to the returned file before running the fake tools
+func customTools(t *testing.T, probe string) (ffmpeg, ffprobe, logFile string) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	logFile = filepath.Join(dir, "calls.log")
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		script := fmt.Sprintf("#!/bin/sh\necho \"%s $*\" >> '%s'\n%s=%s exec '%s' \"$@\"\n", tool, logFile, fakeToolEnv, tool, exe)
+		if err := os.WriteFile(filepath.Join(dir, "custom-"+tool), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv(fakeProbeEnv, probe)
+	// Nothing named ffmpeg or ffprobe is reachable through PATH
+	t.Setenv("PATH", t.TempDir())
+	return filepath.Join(dir, "custom-ffmpeg"), filepath.Join(dir, "custom-ffprobe"), logFile
+}
+
+func TestConfiguredBinaryPaths(t *testing.T) {
+	ffmpeg, ffprobe, logFile := customTools(t, testProbe)
+	setVar(t, &ffmpegPath, ffmpeg)
+	setVar(t, &ffprobePath, ffprobe)
+	setVar(t, &ffmpegGlobalArgs, []string{"-threads", "2", "-nostdin"})
+	setVar(t, &gcsBucket, "videos")
+
+	if _, err := probeFFmpegCapabilities(context.Background()); err != nil {
+		t.Fatalf("capability probe: %v", err)
+	}
+
+	useRedis(t, nil)
+	gcsClient, _ := testgcs.Start(t)
+	gormDB, _ := testdb.Open(t, nil)
+	job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"}
+	if err := processVideoStreaming(context.Background(), gcsClient, gormDB, job); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	var probes, transcodes []string
+	for _, call := range calls {
+		tool, args, _ := strings.Cut(call, " ")
+		switch {
+		case tool == "ffprobe" && strings.Contains(args, "source.mp4"):
+			probes = append(probes, args)
+		case tool == "ffmpeg" && strings.Contains(args, "-filter_complex"):
+			transcodes = append(transcodes, args)
+		}
+	}
+	// One JSON probe covers the video, audio and tags
+	if len(probes) != 1 {
+		t.Errorf("source probed %d times through FFPROBE_PATH, want 1: %q", len(probes), calls)
+	}
+	if len(transcodes) != 1 {
+		t.Fatalf("transcoded %d times through FFMPEG_PATH, want 1: %q", len(transcodes), calls)
+	}
+	if !strings.HasPrefix(transcodes[0], "-y -threads 2 -nostdin -v error ") {
+		t.Errorf("global args not placed before the input: %q", transcodes[0])
+	}
+}
+
+func TestThreadArgs(t *testing.T) {
+	tests := []struct {
+		threads    int
+		wantInput  []string
+		wantOutput []string
+	}{
+		{0, nil, nil},
+		{-1, nil, nil},
+		{4, []string{"-filter_complex_threads", "4"}, []string{"-threads", "4"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.threads), func(t *testing.T) {
+			setVar(t, &ffmpegThreads, tt.threads)
+			if got := threadArgs(false); !slices.Equal(got, tt.wantInput) {
+				t.Errorf("threadArgs(false) = %q, want %q", got, tt.wantInput)
+			}
+			if got := threadArgs(true); !slices.Equal(got, tt.wantOutput) {
+				t.Errorf("threadArgs(true) = %q, want %q", got, tt.wantOutput)
+			}
+		})
+	}
+}
+
+func TestFFmpegCommand(t *testing.T) {
+	setVar(t, &ffmpegPath, "/opt/ffmpeg/bin/ffmpeg")
+
+	tests := []struct {
+		nice int
+		want []string
+	}{
+		{0, []string{"/opt/ffmpeg/bin/ffmpeg", "-y", "-i", "in.mp4"}},
+		{10, []string{"nice", "-n", "10", "/opt/ffmpeg/bin/ffmpeg", "-y", "-i", "in.mp4"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.nice), func(t *testing.T) {
+			setVar(t, &ffmpegNice, tt.nice)
+			cmd := ffmpegCommand(context.Background(), []string{"-y", "-i", "in.mp4"})
+			if !slices.Equal(cmd.Args, tt.want) {
+				t.Errorf("Args = %q, want %q", cmd.Args, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranscodeEmitsThreads(t *testing.T) {
+	ffmpeg, ffprobe, logFile := customTools(t, testProbe)
+	setVar(t, &ffmpegPath, ffmpeg)
+	setVar(t, &ffprobePath, ffprobe)
+	setVar(t, &ffmpegThreads, 3)
+	setVar(t, &gcsBucket, "videos")
+
+	useRedis(t, nil)
+	gcsClient, _ := testgcs.Start(t)
+	gormDB, _ := testdb.Open(t, nil)
+	if err := processVideoStreaming(context.Background(), gcsClient, gormDB, models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var transcode string
+	for _, call := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(call, "ffmpeg ") && strings.Contains(call, "-filter_complex ") {
+			transcode = call
+		}
+	}
+	for _, want := range []string{"-y -filter_complex_threads 3 -v error ", "-threads 3 -f hls "} {
+		if !strings.Contains(transcode, want) {
+			t.Errorf("transcode args %q are missing %q", transcode, want)
+		}
+	}
+}
+
+func TestValidateLogLevel(t *testing.T) {
+	for _, level := range []string{"quiet", "error", "warning", "info", "verbose", "debug", "trace"} {
+		if err := validateLogLevel(level); err != nil {
+			t.Errorf("validateLogLevel(%q) = %v", level, err)
+		}
+	}
+	for _, level := range []string{"", "ERROR", "loud", "48"} {
+		if err := validateLogLevel(level); err == nil {
+			t.Errorf("validateLogLevel(%q) accepted an unknown level", level)
+		}
+	}
+}
+
+func TestTranscodeAppliesLogLevel(t *testing.T) {
+	for _, level := range []string{"error", "debug"} {
+		t.Run(level, func(t *testing.T) {
+			ffmpeg, ffprobe, logFile := customTools(t, testProbe)
+			setVar(t, &ffmpegPath, ffmpeg)
+			setVar(t, &ffprobePath, ffprobe)
+			setVar(t, &ffmpegLogLevel, level)
+			setVar(t, &gcsBucket, "videos")
+
+			useRedis(t, nil)
+			gcsClient, _ := testgcs.Start(t)
+			gormDB, _ := testdb.Open(t, nil)
+			if err := processVideoStreaming(context.Background(), gcsClient, gormDB, models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"}); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(logFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var transcode string
+			for _, call := range strings.Split(string(data), "\n") {
+				if strings.HasPrefix(call, "ffmpeg ") && strings.Contains(call, "-filter_complex ") {
+					transcode = call
+				}
+			}
+			// -nostats keeps the periodic stats line out of stderr at any level
+			if want := " -v " + level + " -nostats "; !strings.Contains(transcode, want) {
+				t.Errorf("transcode args %q are missing %q", transcode, want)
+			}
+		})
+	}
+}
+
+func TestFFmpegDebugLog(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	// The failed run's log survives removing its partial output
+	setVar(t, &cleanupPartialOutput, true)
+
+	tests := []struct {
+		name    string
+		debug   bool
+		fail    string
+		wantLog bool
+	}{
+		{name: "disabled", debug: false},
+		{name: "enabled", debug: true, wantLog: true},
+		{name: "enabled and failing", debug: true, fail: "Invalid data found when processing input", wantLog: true},
+		{name: "disabled and failing", debug: false, fail: "Invalid data found when processing input"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &ffmpegDebugLog, tt.debug)
+			useFakeTools(t, testProbe)
+			if tt.fail != "" {
+				t.Setenv(fakeFFmpegFailEnv, tt.fail)
+			}
+			useRedis(t, nil)
+			gcsClient, store := testgcs.Start(t)
+			gormDB, _ := testdb.Open(t, nil)
+
+			job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"}
+			if err := processVideoStreaming(context.Background(), gcsClient, gormDB, job); (err != nil) != (tt.fail != "") {
+				t.Fatalf("processVideoStreaming error = %v", err)
+			}
+
+			obj, ok := store.Get("videos", job.VideoID.String()+"/processed/ffmpeg.log")
+			if ok != tt.wantLog {
+				t.Fatalf("ffmpeg.log uploaded = %v, want %v", ok, tt.wantLog)
+			}
+			if !ok {
+				return
+			}
+			if !strings.HasPrefix(obj.ContentType, "text/plain") {
+				t.Errorf("ContentType = %q", obj.ContentType)
+			}
+			if tt.fail != "" && !strings.Contains(string(obj.Data), tt.fail) {
+				t.Errorf("log = %q, want FFmpeg's stderr", obj.Data)
+			}
+		})
+	}
+}
+
+func TestUploadFFmpegLogRedacts(t *testing.T) {
+	gcsClient, store := testgcs.Start(t)
+	lines := []string{
+		"ffmpeg version 6.1.1",
+		"Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'https://storage.googleapis.com/uploads/a.mp4?X-Goog-Credential=sa%40p.iam&X-Goog-Signature=0badc0ffee':",
+		"[h264 @ 0x5581] sps: " + strings.Repeat("0123456789", 20000),
+		"Conversion failed!",
+	}
+	localPath := filepath.Join(t.TempDir(), ffmpegLogName)
+	if err := os.WriteFile(localPath, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	video := models.Video{ID: uuid.New()}
+	key, err := uploadFFmpegLog(context.Background(), gcsClient.Bucket("videos"), video, localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, ok := store.Get("videos", key)
+	if !ok {
+		t.Fatalf("%s not uploaded", key)
+	}
+	uploaded := string(obj.Data)
+	if strings.Contains(uploaded, "0badc0ffee") || strings.Contains(uploaded, "sa%40p.iam") {
+		t.Errorf("uploaded log keeps the signed URL's credentials")
+	}
+	// Every line survives, however long
+	want := strings.Replace(strings.Join(lines, "\n")+"\n", "X-Goog-Credential=sa%40p.iam&X-Goog-Signature=0badc0ffee", "X-Goog-Credential=REDACTED&X-Goog-Signature=REDACTED", 1)
+	if uploaded != want {
+		t.Errorf("uploaded log differs from the redacted original (%d bytes, want %d)", len(uploaded), len(want))
+	}
+}
+
+func TestFFmpegSlotsCapLaunches(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int
+		launches int
+		wantPeak int32
+	}{
+		{"capped", 2, 6, 2},
+		{"single", 1, 4, 1},
+		{"unlimited", 0, 4, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &ffmpegSlots, newEncoderSlots(tt.limit))
+
+			var running, peak atomic.Int32
+			var wg sync.WaitGroup
+			start := make(chan struct{})
+			for range tt.launches {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					release, err := acquireFFmpegSlot(context.Background())
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					n := running.Add(1)
+					for {
+						p := peak.Load()
+						if n <= p || peak.CompareAndSwap(p, n) {
+							break
+						}
+					}
+					time.Sleep(20 * time.Millisecond)
+					running.Add(-1)
+					release()
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			if got := peak.Load(); got != tt.wantPeak {
+				t.Errorf("peak concurrent FFmpeg processes = %d, want %d", got, tt.wantPeak)
+			}
+		})
+	}
+}
+
+func TestAcquireFFmpegSlotCancelled(t *testing.T) {
+	setVar(t, &ffmpegSlots, newEncoderSlots(1))
+
+	release, err := acquireFFmpegSlot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := acquireFFmpegSlot(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want the wait to end with the context", err)
+	}
+}
+
+func TestTranscodeReleasesFFmpegSlot(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	setVar(t, &ffmpegSlots, newEncoderSlots(1))
+	useFakeTools(t, testProbe)
+	useRedis(t, nil)
+	gcsClient, _ := testgcs.Start(t)
+	gormDB, _ := testdb.Open(t, nil)
+
+	// With a single slot, the second job only runs if the first gave it back
+	for range 2 {
+		if err := processVideoStreaming(context.Background(), gcsClient, gormDB, models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(ffmpegSlots); n != 0 {
+		t.Errorf("%d FFmpeg slots still held after both jobs finished", n)
+	}
+}
+
+// openPipes counts the pipe file descriptors this process holds
+func openPipes(t *testing.T) int {
+	t.Helper()
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("no /proc/self/fd to count descriptors with")
+	}
+	n := 0
+	for _, e := range entries {
+		if target, err := os.Readlink(filepath.Join("/proc/self/fd", e.Name())); err == nil && strings.HasPrefix(target, "pipe:") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestTranscodeCancelledWaitingForSlot(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	setVar(t, &ffmpegSlots, newEncoderSlots(1))
+	useFakeTools(t, testProbe)
+	useRedis(t, nil)
+	gcsClient, _ := testgcs.Start(t)
+	gormDB, _ := testdb.Open(t, nil)
+
+	// Another job holds the only slot until this one gives up
+	release, err := acquireFFmpegSlot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	before := openPipes(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err = processVideoStreaming(ctx, gcsClient, gormDB, models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"})
+	if err == nil || !strings.Contains(err.Error(), "waiting for an FFmpeg slot") {
+		t.Fatalf("error = %v, want the slot wait to end with the context", err)
+	}
+	if after := openPipes(t); after > before {
+		t.Errorf("%d pipes left open by the cancelled job", after-before)
+	}
+}
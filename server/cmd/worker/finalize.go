@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"cloud.google.com/go/storage"
+	"github.com/devrayat000/video-process/models"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// uploadMasterPlaylist uploads the finished master playlist to key. When an
+// earlier delivery of the job already stored the same bytes, going by the
+// MD5 GCS keeps, the object is left alone and uploaded is false.
+func uploadMasterPlaylist(ctx context.Context, bucket *storage.BucketHandle, video models.Video, localPath, key string) (uploaded bool, err error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to open master playlist: %w", err)
+	}
+	defer file.Close()
+
+	sum := md5.New()
+	if _, err := io.Copy(sum, file); err != nil {
+		return false, fmt.Errorf("failed to read master playlist: %w", err)
+	}
+	attrs, err := server_utils.StatObject(ctx, bucket, key)
+	if err != nil {
+		log.Printf(" [!] %v", err)
+	} else if attrs != nil && bytes.Equal(attrs.MD5, sum.Sum(nil)) {
+		return false, nil
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return false, fmt.Errorf("failed to read master playlist: %w", err)
+	}
+	writer := bucket.Object(key).NewWriter(ctx)
+	writer.ContentType = contentTypeFor(masterPlaylistName)
+	writer.Metadata = objectMetadata(video)
+
+	if _, err := io.Copy(writer, file); err != nil {
+		writer.Close()
+		return false, fmt.Errorf("failed to upload master playlist: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return false, fmt.Errorf("failed to close master playlist writer: %w", err)
+	}
+	return true, nil
+}
+
+// outputRecorded reports whether the database already holds exactly this
+// output, as left by an earlier delivery that got as far as recording it:
+// the same master playlist and progressive MP4, and the same rendition
+// playlists with the same checksums.
+func outputRecorded(ctx context.Context, gormDB *gorm.DB, videoID uuid.UUID, resolutions []models.VideoResolution, updates models.Video) (bool, error) {
+	video, err := gorm.G[models.Video](gormDB).Where("id = ?", videoID).First(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !equalPtr(video.MasterPlaylistKey, updates.MasterPlaylistKey) ||
+		!equalPtr(video.MasterPlaylistURL, updates.MasterPlaylistURL) ||
+		!equalPtr(video.ProgressiveKey, updates.ProgressiveKey) ||
+		!equalPtr(video.ProgressiveURL, updates.ProgressiveURL) {
+		return false, nil
+	}
+
+	existing, err := gorm.G[models.VideoResolution](gormDB).Where("video_id = ?", videoID).Find(ctx)
+	if err != nil {
+		return false, err
+	}
+	return sameResolutions(existing, resolutions), nil
+}
+
+// sameResolutions compares rendition rows by playlist key, ignoring row ids
+// and timestamps, which differ on every delivery
+func sameResolutions(existing, resolutions []models.VideoResolution) bool {
+	if len(existing) != len(resolutions) {
+		return false
+	}
+	byKey := make(map[string]models.VideoResolution, len(existing))
+	for _, r := range existing {
+		byKey[r.PlaylistS3Key] = r
+	}
+	for _, r := range resolutions {
+		e, ok := byKey[r.PlaylistS3Key]
+		// Rows without checksums predate them and can't be compared
+		if !ok || r.Checksum == "" || e.Checksum != r.Checksum || e.SegmentsChecksum != r.SegmentsChecksum ||
+			e.PlaylistURL != r.PlaylistURL || e.Resolution != r.Resolution || e.Width != r.Width ||
+			e.SegmentCount != r.SegmentCount || e.TotalSize != r.TotalSize || e.Bandwidth != r.Bandwidth {
+			return false
+		}
+	}
+	return true
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
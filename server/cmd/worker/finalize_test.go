@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestUploadMasterPlaylist(t *testing.T) {
+	const master = "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=2800000\nhttps://storage.googleapis.com/videos/stream_0/playlist.m3u8\n"
+
+	tests := []struct {
+		name         string
+		stored       string // "" leaves the object missing
+		wantUploaded bool
+	}{
+		{"first delivery", "", true},
+		{"redelivery with the same playlist", master, false},
+		{"earlier playlist differs", "#EXTM3U\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gcsClient, store := testgcs.Start(t)
+			video := models.Video{ID: uuid.New(), OriginalName: "a.mp4"}
+			key := video.OutputPrefix() + masterPlaylistName
+			if tt.stored != "" {
+				store.Put("videos", key, []byte(tt.stored))
+			}
+
+			localPath := filepath.Join(t.TempDir(), masterPlaylistName)
+			if err := os.WriteFile(localPath, []byte(master), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			uploaded, err := uploadMasterPlaylist(context.Background(), gcsClient.Bucket("videos"), video, localPath, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if uploaded != tt.wantUploaded {
+				t.Errorf("uploaded = %v, want %v", uploaded, tt.wantUploaded)
+			}
+			if written := len(store.Written()) > 0; written != tt.wantUploaded {
+				t.Errorf("wrote the object: %v, want %v", written, tt.wantUploaded)
+			}
+			obj, ok := store.Get("videos", key)
+			if !ok || string(obj.Data) != master {
+				t.Errorf("stored master = %q, want the local playlist", obj.Data)
+			}
+		})
+	}
+}
+
+func TestSameResolutions(t *testing.T) {
+	row := models.VideoResolution{
+		ID:               uuid.New(),
+		Resolution:       "720p",
+		Width:            1280,
+		PlaylistS3Key:    "id/processed/stream_0/playlist.m3u8",
+		PlaylistURL:      "https://storage.googleapis.com/videos/id/processed/stream_0/playlist.m3u8",
+		SegmentCount:     3,
+		TotalSize:        4096,
+		Bandwidth:        2800000,
+		Checksum:         "abc",
+		SegmentsChecksum: "def",
+	}
+	other := row
+	other.Resolution, other.PlaylistS3Key, other.Checksum = "480p", "id/processed/stream_1/playlist.m3u8", "ghi"
+
+	// A new delivery makes new row ids
+	redelivered := row
+	redelivered.ID = uuid.New()
+	changed := row
+	changed.SegmentsChecksum = "xyz"
+	unchecked := row
+	unchecked.Checksum = ""
+
+	tests := []struct {
+		name        string
+		existing    []models.VideoResolution
+		resolutions []models.VideoResolution
+		want        bool
+	}{
+		{"same rows", []models.VideoResolution{row, other}, []models.VideoResolution{redelivered, other}, true},
+		{"any order", []models.VideoResolution{other, row}, []models.VideoResolution{row, other}, true},
+		{"partial earlier result", []models.VideoResolution{row}, []models.VideoResolution{row, other}, false},
+		{"different segments", []models.VideoResolution{row}, []models.VideoResolution{changed}, false},
+		{"no checksum to compare", []models.VideoResolution{unchecked}, []models.VideoResolution{unchecked}, false},
+		{"nothing recorded", nil, []models.VideoResolution{row}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameResolutions(tt.existing, tt.resolutions); got != tt.want {
+				t.Errorf("sameResolutions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordOutputIdempotent(t *testing.T) {
+	videoID := uuid.New()
+	masterKey := videoID.String() + "/processed/master.m3u8"
+	resolution := models.VideoResolution{
+		ID:            uuid.New(),
+		VideoID:       videoID,
+		Resolution:    "720p",
+		PlaylistS3Key: videoID.String() + "/processed/stream_0/playlist.m3u8",
+		Checksum:      "abc",
+	}
+
+	tests := []struct {
+		name          string
+		recordedKey   any
+		recordedSum   string
+		wantRewritten bool
+	}{
+		{"already recorded", masterKey, "abc", false},
+		{"different master", videoID.String() + "/processed/v1/master.m3u8", "abc", true},
+		{"different rendition", masterKey, "old", true},
+		{"nothing recorded", nil, "abc", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDB, db := testdb.Open(t, func(q testdb.Query) testdb.Result {
+				switch {
+				case strings.HasPrefix(q.SQL, `SELECT * FROM "videos"`):
+					return testdb.Result{
+						Columns: []string{"id", "master_playlist_key"},
+						Rows:    [][]any{{videoID.String(), tt.recordedKey}},
+					}
+				case strings.HasPrefix(q.SQL, `SELECT * FROM "video_resolutions"`):
+					return testdb.Result{
+						Columns: []string{"id", "video_id", "resolution", "playlist_s3_key", "checksum"},
+						Rows:    [][]any{{uuid.NewString(), videoID.String(), "720p", resolution.PlaylistS3Key, tt.recordedSum}},
+					}
+				}
+				return testdb.Result{RowsAffected: 1}
+			})
+
+			err := recordOutput(context.Background(), gormDB, videoID, []models.VideoResolution{resolution}, models.Video{MasterPlaylistKey: &masterKey})
+			if err != nil {
+				t.Fatal(err)
+			}
+			writes := len(db.Matching(`INSERT INTO "video_resolutions"`)) + len(db.Matching(`UPDATE "videos"`))
+			if rewritten := writes > 0; rewritten != tt.wantRewritten {
+				t.Errorf("rewritten = %v, want %v: %v", rewritten, tt.wantRewritten, db.Queries())
+			}
+		})
+	}
+}
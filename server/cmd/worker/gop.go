@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// Target HLS segment length in seconds
+const hlsSegmentSeconds = 6
+
+// Longest segment a size target may produce; players buffer whole segments
+const hlsMaxSegmentSeconds = 30
+
+// HLS_SEGMENT_TARGET_KB segments by approximate size instead of a fixed
+// length: the segment duration is chosen so the highest-bitrate rendition's
+// segments come out at about this many kilobytes. Zero keeps
+// hlsSegmentSeconds.
+var hlsSegmentTargetKB = server_utils.GetEnvInt("HLS_SEGMENT_TARGET_KB", 0)
+
+func validateSegmentTarget(targetKB int) error {
+	if targetKB < 0 {
+		return fmt.Errorf("HLS_SEGMENT_TARGET_KB must be positive, got %d", targetKB)
+	}
+	return nil
+}
+
+// segmentSeconds returns the HLS segment duration for a job. With a size
+// target it is derived from the largest combined video+audio bitrate and
+// rounded down, so no rendition's segments overshoot the target by design.
+// Every rendition shares one duration to keep segments aligned.
+func segmentSeconds(targetKB int, renditions []Rendition) int {
+	if targetKB <= 0 {
+		return hlsSegmentSeconds
+	}
+
+	peakKbps := 0
+	for _, r := range renditions {
+		peakKbps = max(peakKbps, r.Bitrate+audioBitrate(r))
+	}
+	if peakKbps <= 0 {
+		return hlsSegmentSeconds
+	}
+
+	seconds := targetKB * 8 / peakKbps
+	return min(max(seconds, 1), hlsMaxSegmentSeconds)
+}
+
+// GOP_SIZE is the key frame interval in frames, independent of the segment
+// length. Segment boundaries get a forced key frame either way, so a GOP
+// shorter than a segment only adds seek points.
+var gopSize = server_utils.GetEnvInt("GOP_SIZE", 48)
+
+func validateGOPSize(size int) error {
+	if size < 1 {
+		return fmt.Errorf("GOP_SIZE must be at least 1 frame, got %d", size)
+	}
+	return nil
+}
+
+// SCENE_CUT lets the encoder add key frames at scene changes. Segment
+// boundaries stay aligned because key frames are still forced at every
+// segment boundary.
+var sceneCut = server_utils.GetEnvBool("SCENE_CUT", false)
+
+// gopArgs returns the key frame flags for the video output stream at index.
+// Key frames are forced at every segment boundary so the segmenter can cut
+// there whatever the GOP size. Without scene cut GOPs are otherwise exactly
+// GOP_SIZE frames; with it, scene changes may start a new GOP early.
+func gopArgs(index, segmentSeconds int) []string {
+	args := []string{
+		"-g", strconv.Itoa(gopSize),
+		fmt.Sprintf("-force_key_frames:v:%d", index), fmt.Sprintf("expr:gte(t,n_forced*%d)", segmentSeconds),
+	}
+	if !sceneCut {
+		args = append(args,
+			"-keyint_min", strconv.Itoa(gopSize),
+			"-sc_threshold", "0",
+		)
+	}
+	return args
+}
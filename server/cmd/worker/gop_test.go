@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGOPArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		sceneCut bool
+		gopSize  int
+		index    int
+		segment  int
+		want     []string
+	}{
+		{
+			name: "fixed GOP", gopSize: 48, index: 0, segment: 6,
+			want: []string{"-g", "48", "-force_key_frames:v:0", "expr:gte(t,n_forced*6)", "-keyint_min", "48", "-sc_threshold", "0"},
+		},
+		{
+			name: "GOP shorter than a segment", gopSize: 24, index: 1, segment: 6,
+			want: []string{"-g", "24", "-force_key_frames:v:1", "expr:gte(t,n_forced*6)", "-keyint_min", "24", "-sc_threshold", "0"},
+		},
+		{
+			name: "scene cut", sceneCut: true, gopSize: 48, index: 2, segment: 6,
+			want: []string{"-g", "48", "-force_key_frames:v:2", "expr:gte(t,n_forced*6)"},
+		},
+		{
+			name: "scene cut with sized segments", sceneCut: true, gopSize: 120, index: 0, segment: 4,
+			want: []string{"-g", "120", "-force_key_frames:v:0", "expr:gte(t,n_forced*4)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &sceneCut, tt.sceneCut)
+			setVar(t, &gopSize, tt.gopSize)
+			if got := gopArgs(tt.index, tt.segment); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("gopArgs(%d, %d) = %q, want %q", tt.index, tt.segment, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateGOPSize(t *testing.T) {
+	tests := []struct {
+		size    int
+		wantErr bool
+	}{
+		{48, false},
+		{1, false},
+		{0, true},
+		{-24, true},
+	}
+	for _, tt := range tests {
+		if err := validateGOPSize(tt.size); (err != nil) != tt.wantErr {
+			t.Errorf("validateGOPSize(%d) error = %v, wantErr %v", tt.size, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSegmentSeconds(t *testing.T) {
+	ladder := []Rendition{
+		{Height: 720, Bitrate: 2800, AudioRate: 160},
+		{Height: 480, Bitrate: 1400, AudioRate: 128},
+	}
+	tests := []struct {
+		name       string
+		targetKB   int
+		renditions []Rendition
+		want       int
+	}{
+		{"no target", 0, ladder, hlsSegmentSeconds},
+		{"sized by the peak rendition", 2000, ladder, 5}, // 16000 kbit / 2960 kbps
+		{"rounds down", 1849, ladder, 4},
+		{"at least one second", 10, ladder, 1},
+		{"capped", 1 << 20, ladder, hlsMaxSegmentSeconds},
+		{"no bitrate", 2000, []Rendition{{Height: 720}}, hlsSegmentSeconds},
+		{"no renditions", 2000, nil, hlsSegmentSeconds},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := segmentSeconds(tt.targetKB, tt.renditions); got != tt.want {
+				t.Errorf("segmentSeconds(%d) = %d, want %d", tt.targetKB, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSegmentTarget(t *testing.T) {
+	tests := []struct {
+		targetKB int
+		wantErr  bool
+	}{
+		{0, false},
+		{1500, false},
+		{-1, true},
+	}
+	for _, tt := range tests {
+		if err := validateSegmentTarget(tt.targetKB); (err != nil) != tt.wantErr {
+			t.Errorf("validateSegmentTarget(%d) error = %v, wantErr %v", tt.targetKB, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// HLS output naming. The variant directory must contain %v (FFmpeg's variant
+// index) and the segment pattern a single %d/%0Nd sequence number, e.g.
+// "segment_%05d.ts" to keep names fixed-width past 1000 segments.
+var (
+	hlsSegmentType    = segmentTypeFor(audioCodec, llHLS)
+	hlsVariantDir     = server_utils.GetEnv("HLS_VARIANT_DIR", "stream_%v")
+	hlsSegmentPattern = server_utils.GetEnv("HLS_SEGMENT_PATTERN", "segment_%03d"+segmentExtension(hlsSegmentType))
+	// HLS_SINGLE_FILE writes each variant as one media file whose segments
+	// the playlist addresses with EXT-X-BYTERANGE, instead of a file per
+	// segment, so a video is a handful of objects
+	hlsSingleFile = server_utils.GetEnvBool("HLS_SINGLE_FILE", false)
+)
+
+// singleFileMediaName is the variant's media file in single-file mode. FFmpeg
+// writes the segment filename as-is then, with no sequence number.
+const singleFileMediaName = "media"
+
+var segmentNumberToken = regexp.MustCompile(`%(0[1-9][0-9]*)?d`)
+
+// validateHLSNaming rejects patterns FFmpeg would expand into colliding or
+// nested paths, which would break the upload loop's key mapping.
+func validateHLSNaming(variantDir, segmentPattern, segmentType string) error {
+	if strings.Count(variantDir, "%v") != 1 {
+		return fmt.Errorf("HLS_VARIANT_DIR %q must contain %%v exactly once", variantDir)
+	}
+	if strings.Contains(strings.Replace(variantDir, "%v", "", 1), "%") {
+		return fmt.Errorf("HLS_VARIANT_DIR %q may only use the %%v token", variantDir)
+	}
+	if strings.ContainsAny(variantDir, `/\`) || strings.Contains(variantDir, "..") {
+		return fmt.Errorf("HLS_VARIANT_DIR %q must be a single directory name", variantDir)
+	}
+
+	if len(segmentNumberToken.FindAllString(segmentPattern, -1)) != 1 {
+		return fmt.Errorf("HLS_SEGMENT_PATTERN %q must contain exactly one %%d or %%0Nd token", segmentPattern)
+	}
+	if strings.Contains(segmentNumberToken.ReplaceAllString(segmentPattern, ""), "%") {
+		return fmt.Errorf("HLS_SEGMENT_PATTERN %q may only use a single %%d token", segmentPattern)
+	}
+	if strings.ContainsAny(segmentPattern, `/\`) || strings.Contains(segmentPattern, "..") {
+		return fmt.Errorf("HLS_SEGMENT_PATTERN %q must be a file name", segmentPattern)
+	}
+	if ext := segmentExtension(segmentType); !strings.HasSuffix(segmentPattern, ext) {
+		return fmt.Errorf("HLS_SEGMENT_PATTERN %q must end in %s for %s segments", segmentPattern, ext, segmentType)
+	}
+
+	return nil
+}
+
+// variantDirName expands the variant directory pattern for a rendition index,
+// matching what FFmpeg produces for %v.
+func variantDirName(index int) string {
+	return strings.Replace(hlsVariantDir, "%v", strconv.Itoa(index), 1)
+}
+
+// variantIndex parses a directory name produced from HLS_VARIANT_DIR back to
+// its rendition index
+func variantIndex(name string) (int, bool) {
+	prefix, suffix, _ := strings.Cut(hlsVariantDir, "%v")
+	digits, ok := strings.CutPrefix(name, prefix)
+	if !ok {
+		return 0, false
+	}
+	digits, ok = strings.CutSuffix(digits, suffix)
+	if !ok {
+		return 0, false
+	}
+	index, err := strconv.Atoi(digits)
+	if err != nil || index < 0 || strconv.Itoa(index) != digits {
+		return 0, false
+	}
+	return index, true
+}
+
+// producedVariants returns the rendition indexes FFmpeg wrote a variant
+// directory for in dir. Directories past the last rendition are logged and
+// ignored.
+func producedVariants(ctx context.Context, dir string, count int) (map[int]bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read output dir %s: %w", dir, err)
+	}
+
+	produced := make(map[int]bool)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		index, ok := variantIndex(entry.Name())
+		if !ok {
+			continue
+		}
+		if index >= count {
+			logf(ctx, " [!] Ignoring variant dir %s, only %d renditions were requested", entry.Name(), count)
+			continue
+		}
+		produced[index] = true
+	}
+	return produced, nil
+}
+
+// validateSingleFileHLS rejects modes that need a file per segment. LL-HLS
+// parts are byte ranges within each segment file.
+func validateSingleFileHLS(singleFile, lowLatency bool) error {
+	if singleFile && lowLatency {
+		return fmt.Errorf("HLS_SINGLE_FILE cannot be combined with LL_HLS")
+	}
+	return nil
+}
+
+// hlsFlags is the -hls_flags value for the configured mode
+func hlsFlags(singleFile bool) string {
+	if singleFile {
+		return "independent_segments+single_file"
+	}
+	return "independent_segments"
+}
+
+// hlsSegmentFilename is the file name each variant's media goes to: the
+// numbered segment pattern, or one file in single-file mode
+func hlsSegmentFilename(singleFile bool) string {
+	if singleFile {
+		return singleFileMediaName + segmentExtension(hlsSegmentType)
+	}
+	return hlsSegmentPattern
+}
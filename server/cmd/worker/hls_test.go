@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestValidateHLSNaming(t *testing.T) {
+	tests := []struct {
+		name           string
+		variantDir     string
+		segmentPattern string
+		segmentType    string
+		wantErr        bool
+	}{
+		{"defaults", "stream_%v", "segment_%03d.ts", "mpegts", false},
+		{"fixed width", "v%v", "seg_%05d.ts", "mpegts", false},
+		{"plain number", "stream_%v", "%d.ts", "mpegts", false},
+		{"fmp4 segments", "stream_%v", "segment_%03d.m4s", "fmp4", false},
+		{"missing variant token", "stream", "segment_%03d.ts", "mpegts", true},
+		{"variant token twice", "%v_%v", "segment_%03d.ts", "mpegts", true},
+		{"other variant token", "stream_%v_%d", "segment_%03d.ts", "mpegts", true},
+		{"nested variant dir", "hls/stream_%v", "segment_%03d.ts", "mpegts", true},
+		{"variant dir escapes", "..%v", "segment_%03d.ts", "mpegts", true},
+		{"missing number", "stream_%v", "segment.ts", "mpegts", true},
+		{"number twice", "stream_%v", "%d_%03d.ts", "mpegts", true},
+		{"unpadded width", "stream_%v", "segment_%3d.ts", "mpegts", true},
+		{"other token", "stream_%v", "segment_%s_%d.ts", "mpegts", true},
+		{"nested segment", "stream_%v", "parts/segment_%d.ts", "mpegts", true},
+		{"wrong extension", "stream_%v", "segment_%d.mp4", "mpegts", true},
+		{"ts name for fmp4 segments", "stream_%v", "segment_%d.ts", "fmp4", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateHLSNaming(tt.variantDir, tt.segmentPattern, tt.segmentType)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateHLSNaming(%q, %q, %q) error = %v, wantErr %v", tt.variantDir, tt.segmentPattern, tt.segmentType, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVariantDirName(t *testing.T) {
+	defer func(dir string) { hlsVariantDir = dir }(hlsVariantDir)
+
+	tests := []struct {
+		pattern string
+		index   int
+		want    string
+	}{
+		{"stream_%v", 0, "stream_0"},
+		{"stream_%v", 12, "stream_12"},
+		{"v%v_hls", 3, "v3_hls"},
+	}
+	for _, tt := range tests {
+		hlsVariantDir = tt.pattern
+		if got := variantDirName(tt.index); got != tt.want {
+			t.Errorf("variantDirName(%d) with %q = %q, want %q", tt.index, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+// TestSegmentKeysPastOneThousand expands a fixed-width pattern the way FFmpeg
+// does and checks the uploaded keys keep their playback order by name.
+func TestSegmentKeysPastOneThousand(t *testing.T) {
+	defer func(dir string) { hlsVariantDir = dir }(hlsVariantDir)
+	hlsVariantDir = "stream_%v"
+	const pattern = "segment_%05d.ts"
+	if err := validateHLSNaming(hlsVariantDir, pattern, "mpegts"); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for n := range 1500 {
+		keys = append(keys, fmt.Sprintf("vid/processed/%s/%s", variantDirName(2), fmt.Sprintf(pattern, n)))
+	}
+	if !slices.IsSorted(keys) {
+		t.Error("segment keys do not sort in playback order")
+	}
+	if got, want := keys[1234], "vid/processed/stream_2/segment_01234.ts"; got != want {
+		t.Errorf("key for segment 1234 = %q, want %q", got, want)
+	}
+}
+
+func TestValidateSingleFileHLS(t *testing.T) {
+	tests := []struct {
+		singleFile, lowLatency bool
+		wantErr                bool
+	}{
+		{false, false, false},
+		{true, false, false},
+		{false, true, false},
+		{true, true, true},
+	}
+	for _, tt := range tests {
+		if err := validateSingleFileHLS(tt.singleFile, tt.lowLatency); (err != nil) != tt.wantErr {
+			t.Errorf("validateSingleFileHLS(%v, %v) error = %v, wantErr %v", tt.singleFile, tt.lowLatency, err, tt.wantErr)
+		}
+	}
+}
+
+func TestHLSSingleFileArgs(t *testing.T) {
+	setVar(t, &hlsSegmentPattern, "segment_%03d.ts")
+
+	tests := []struct {
+		segmentType  string
+		singleFile   bool
+		wantFlags    string
+		wantFilename string
+	}{
+		{"mpegts", false, "independent_segments", "segment_%03d.ts"},
+		{"mpegts", true, "independent_segments+single_file", "media.ts"},
+		{"fmp4", true, "independent_segments+single_file", "media.m4s"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s single_file=%v", tt.segmentType, tt.singleFile), func(t *testing.T) {
+			setVar(t, &hlsSegmentType, tt.segmentType)
+			if got := hlsFlags(tt.singleFile); got != tt.wantFlags {
+				t.Errorf("hlsFlags = %q, want %q", got, tt.wantFlags)
+			}
+			if got := hlsSegmentFilename(tt.singleFile); got != tt.wantFilename {
+				t.Errorf("hlsSegmentFilename = %q, want %q", got, tt.wantFilename)
+			}
+		})
+	}
+}
+
+// TestSingleFileHLSUploads checks a single-file run uploads one media file
+// per variant next to its byte-range playlist, and still counts segments.
+func TestSingleFileHLSUploads(t *testing.T) {
+	tests := []struct {
+		singleFile  bool
+		wantVariant []string // the files uploaded for each variant
+	}{
+		{false, []string{"playlist.m3u8", "segment_000.ts", "segment_001.ts"}},
+		{true, []string{"media.ts", "playlist.m3u8"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("single_file=%v", tt.singleFile), func(t *testing.T) {
+			setVar(t, &gcsBucket, "videos")
+			setVar(t, &hlsSingleFile, tt.singleFile)
+			setVar(t, &hlsSegmentType, "mpegts")
+			setVar(t, &hlsSegmentPattern, "segment_%03d.ts")
+			setVar(t, &hlsVariantDir, "stream_%v")
+			ffmpeg, ffprobe, logFile := customTools(t, testProbe)
+			setVar(t, &ffmpegPath, ffmpeg)
+			setVar(t, &ffprobePath, ffprobe)
+			useRedis(t, nil)
+			gcsClient, store := testgcs.Start(t)
+			gormDB, db := testdb.Open(t, nil)
+
+			job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"}
+			if err := processVideoStreaming(context.Background(), gcsClient, gormDB, job); err != nil {
+				t.Fatal(err)
+			}
+
+			calls, err := os.ReadFile(logFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Contains(string(calls), "+single_file"); got != tt.singleFile {
+				t.Errorf("ffmpeg passed single_file: %v, want %v", got, tt.singleFile)
+			}
+
+			prefix := job.VideoID.String() + "/processed/"
+			variants := make(map[string][]string)
+			for _, name := range store.Names("videos") {
+				rel, ok := strings.CutPrefix(name, prefix)
+				if dir, file, nested := strings.Cut(rel, "/"); ok && nested && !strings.HasSuffix(file, ".log") {
+					variants[dir] = append(variants[dir], file)
+				}
+			}
+			if len(variants) == 0 {
+				t.Fatal("no variants uploaded")
+			}
+			for dir, files := range variants {
+				if !slices.Equal(files, tt.wantVariant) {
+					t.Errorf("uploaded %q to %s, want %q", files, dir, tt.wantVariant)
+				}
+				if !tt.singleFile {
+					continue
+				}
+				playlist, _ := store.Get("videos", prefix+dir+"/playlist.m3u8")
+				if !strings.Contains(string(playlist.Data), "#EXT-X-BYTERANGE:") {
+					t.Errorf("%s playlist has no byte ranges:\n%s", dir, playlist.Data)
+				}
+			}
+
+			// Each variant still has two segments, however many files
+			counts := 0
+			for _, insert := range db.Matching(`INSERT INTO "video_resolutions"`) {
+				for _, arg := range insert.Args {
+					if arg == any(int64(2)) {
+						counts++
+					}
+				}
+			}
+			if counts != len(variants) {
+				t.Errorf("%d resolution rows record 2 segments, want all %d", counts, len(variants))
+			}
+		})
+	}
+}
+
+func TestVariantIndex(t *testing.T) {
+	defer func(dir string) { hlsVariantDir = dir }(hlsVariantDir)
+
+	tests := []struct {
+		pattern string
+		name    string
+		want    int
+		wantOK  bool
+	}{
+		{"stream_%v", "stream_0", 0, true},
+		{"stream_%v", "stream_12", 12, true},
+		{"stream_%v", "stream_01", 0, false},
+		{"stream_%v", "stream_-1", 0, false},
+		{"stream_%v", "stream_", 0, false},
+		{"stream_%v", "other_1", 0, false},
+		{"v%v_hls", "v3_hls", 3, true},
+		{"v%v_hls", "v3", 0, false},
+	}
+	for _, tt := range tests {
+		hlsVariantDir = tt.pattern
+		got, ok := variantIndex(tt.name)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("variantIndex(%q) with %q = %d, %v, want %d, %v", tt.name, tt.pattern, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestProducedVariants(t *testing.T) {
+	defer func(dir string) { hlsVariantDir = dir }(hlsVariantDir)
+	hlsVariantDir = "stream_%v"
+
+	tests := []struct {
+		name  string
+		dirs  []string
+		files []string
+		count int
+		want  map[int]bool
+	}{
+		{
+			name:  "all produced",
+			dirs:  []string{"stream_0", "stream_1", "stream_2"},
+			count: 3,
+			want:  map[int]bool{0: true, 1: true, 2: true},
+		},
+		{
+			name:  "one missing",
+			dirs:  []string{"stream_0", "stream_2"},
+			count: 3,
+			want:  map[int]bool{0: true, 2: true},
+		},
+		{
+			name:  "extra and unrelated entries ignored",
+			dirs:  []string{"stream_0", "stream_5", "thumbnails"},
+			files: []string{"stream_1", "master.m3u8"},
+			count: 2,
+			want:  map[int]bool{0: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tt.dirs {
+				if err := os.Mkdir(filepath.Join(dir, name), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, name := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := producedVariants(context.Background(), dir, tt.count)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("producedVariants = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := producedVariants(context.Background(), filepath.Join(t.TempDir(), "missing"), 1); err == nil {
+		t.Error("expected an error for a missing output dir")
+	}
+}
+
+// TestMissingVariantDir checks a job whose FFmpeg run left a rendition out
+// completes with the others instead of failing.
+func TestMissingVariantDir(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	setVar(t, &hlsVariantDir, "stream_%v")
+	useFakeTools(t, testProbe)
+	t.Setenv(fakeFFmpegSkipEnv, "1")
+	useRedis(t, nil)
+	gcsClient, store := testgcs.Start(t)
+	gormDB, db := testdb.Open(t, nil)
+
+	job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"}
+	if err := processVideoStreaming(context.Background(), gcsClient, gormDB, job); err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := job.VideoID.String() + "/processed/"
+	var dirs []string
+	for _, name := range store.Names("videos") {
+		rel, _ := strings.CutPrefix(name, prefix)
+		if dir, _, nested := strings.Cut(rel, "/"); nested && !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	if slices.Contains(dirs, "stream_1") || !slices.Contains(dirs, "stream_0") {
+		t.Errorf("uploaded variant dirs %q, want all but stream_1", dirs)
+	}
+
+	master, ok := store.Get("videos", prefix+masterPlaylistName)
+	if !ok {
+		t.Fatal("master playlist not uploaded")
+	}
+	if strings.Contains(string(master.Data), "stream_1/") {
+		t.Errorf("master still lists the missing variant:\n%s", master.Data)
+	}
+
+	for _, insert := range db.Matching(`INSERT INTO "video_resolutions"`) {
+		if slices.Contains(insert.Args, any("480p")) {
+			t.Errorf("recorded a resolution row for the missing 480p variant: %v", insert.Args)
+		}
+	}
+}
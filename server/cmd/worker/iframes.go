@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// Write an I-frame-only playlist per variant for trick play (MPEG-TS only)
+var iframePlaylists = server_utils.GetEnvBool("IFRAME_PLAYLISTS", false)
+
+const iframePlaylistName = "iframes.m3u8"
+
+// tsPacketSize is the MPEG-TS packet size; byte ranges are aligned to it
+const tsPacketSize = 188
+
+// keyframe is one I-frame located inside a segment file
+type keyframe struct {
+	Segment  string
+	Time     float64 // presentation time in seconds
+	Offset   int64
+	Length   int64
+	Duration float64
+}
+
+// playlistSegment is a media segment and its EXTINF duration
+type playlistSegment struct {
+	Name     string
+	Duration float64
+}
+
+// readMediaPlaylist lists the segments of a local media playlist in order
+func readMediaPlaylist(path string) ([]playlistSegment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var segments []playlistSegment
+	var duration float64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			duration, _ = strconv.ParseFloat(value, 64)
+		case line == "" || strings.HasPrefix(line, "#"):
+		default:
+			segments = append(segments, playlistSegment{Name: line, Duration: duration})
+			duration = 0
+		}
+	}
+
+	return segments, scanner.Err()
+}
+
+// probeKeyframes finds the I-frames of one TS segment. Each byte range runs
+// from the key frame's packet to the next video packet, rounded up to whole
+// TS packets.
+func probeKeyframes(ctx context.Context, segmentPath string) ([]keyframe, error) {
+	info, err := os.Stat(segmentPath)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := exec.CommandContext(ctx, ffprobePath,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "packet=pts_time,pos,flags",
+		"-of", "csv=p=0",
+		segmentPath,
+	).Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe packets failed for %s: %w", segmentPath, err)
+	}
+
+	var frames []keyframe
+	var open *keyframe
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ",")
+		if len(fields) < 3 {
+			continue
+		}
+		pts, err1 := strconv.ParseFloat(fields[0], 64)
+		pos, err2 := strconv.ParseInt(fields[1], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+
+		if open != nil {
+			open.Length = alignTS(pos - open.Offset)
+			frames = append(frames, *open)
+			open = nil
+		}
+		if strings.Contains(fields[2], "K") {
+			open = &keyframe{Segment: filepath.Base(segmentPath), Time: pts, Offset: pos}
+		}
+	}
+	if open != nil {
+		open.Length = info.Size() - open.Offset
+		frames = append(frames, *open)
+	}
+
+	return frames, nil
+}
+
+func alignTS(n int64) int64 {
+	return (n + tsPacketSize - 1) / tsPacketSize * tsPacketSize
+}
+
+// buildIFramePlaylist writes the key frames as an EXT-X-I-FRAMES-ONLY
+// playlist. Each frame lasts until the next one; the last until end.
+func buildIFramePlaylist(frames []keyframe, end float64) (string, int) {
+	var body strings.Builder
+	var maxDuration, totalDuration float64
+	var totalBytes int64
+
+	for i := range frames {
+		next := end
+		if i+1 < len(frames) {
+			next = frames[i+1].Time
+		}
+		frames[i].Duration = next - frames[i].Time
+		if frames[i].Duration > maxDuration {
+			maxDuration = frames[i].Duration
+		}
+		totalDuration += frames[i].Duration
+		totalBytes += frames[i].Length
+
+		fmt.Fprintf(&body, "#EXTINF:%.6f,\n#EXT-X-BYTERANGE:%d@%d\n%s\n",
+			frames[i].Duration, frames[i].Length, frames[i].Offset, frames[i].Segment)
+	}
+
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n#EXT-X-VERSION:4\n")
+	fmt.Fprintf(&playlist, "#EXT-X-TARGETDURATION:%d\n", int(maxDuration+0.999))
+	playlist.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-I-FRAMES-ONLY\n")
+	playlist.WriteString(body.String())
+	playlist.WriteString("#EXT-X-ENDLIST\n")
+
+	bandwidth := 0
+	if totalDuration > 0 {
+		bandwidth = int(float64(totalBytes*8) / totalDuration)
+	}
+	return playlist.String(), bandwidth
+}
+
+// writeIFramePlaylists creates iframes.m3u8 next to every variant playlist
+// and references them from the master playlist. Failures only cost trick
+// play, so they are logged rather than failing the job.
+func writeIFramePlaylists(ctx context.Context, tempDir string, renditions []Rendition, sourceWidth, sourceHeight int) {
+	if !iframePlaylists {
+		return
+	}
+	if hlsSegmentType != "mpegts" {
+		logf(ctx, " [!] I-frame playlists need MPEG-TS segments, skipping (segment type: %s)", hlsSegmentType)
+		return
+	}
+	if hlsSingleFile {
+		logf(ctx, " [!] I-frame playlists need a file per segment, skipping in single-file mode")
+		return
+	}
+
+	var entries []string
+	for i, r := range renditions {
+		streamName := variantDirName(i)
+		streamDir := filepath.Join(tempDir, streamName)
+
+		segments, err := readMediaPlaylist(filepath.Join(streamDir, "playlist.m3u8"))
+		if err != nil || len(segments) == 0 {
+			logf(ctx, " [!] Skipping I-frame playlist for %dp: %v", r.Height, err)
+			continue
+		}
+
+		var frames []keyframe
+		var total float64
+		for _, segment := range segments {
+			segmentFrames, err := probeKeyframes(ctx, filepath.Join(streamDir, segment.Name))
+			if err != nil {
+				logf(ctx, " [!] Skipping I-frame playlist for %dp: %v", r.Height, err)
+				frames = nil
+				break
+			}
+			frames = append(frames, segmentFrames...)
+			total += segment.Duration
+		}
+		if len(frames) == 0 {
+			continue
+		}
+
+		playlist, bandwidth := buildIFramePlaylist(frames, frames[0].Time+total)
+		if err := os.WriteFile(filepath.Join(streamDir, iframePlaylistName), []byte(playlist), 0o644); err != nil {
+			logf(ctx, " [!] Failed to write I-frame playlist for %dp: %v", r.Height, err)
+			continue
+		}
+
+		width := renditionWidth(sourceWidth, sourceHeight, r.Height)
+		entries = append(entries, fmt.Sprintf(`#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,URI="%s/%s"`,
+			bandwidth, width, r.Height, streamName, iframePlaylistName))
+	}
+
+	if len(entries) == 0 {
+		return
+	}
+
+	masterPath := filepath.Join(tempDir, masterPlaylistName)
+	master, err := os.ReadFile(masterPath)
+	if err != nil {
+		logf(ctx, " [!] Failed to read master playlist: %v", err)
+		return
+	}
+	content := strings.TrimRight(string(master), "\n") + "\n" + strings.Join(entries, "\n") + "\n"
+	if err := os.WriteFile(masterPath, []byte(content), 0o644); err != nil {
+		logf(ctx, " [!] Failed to add I-frame streams to master playlist: %v", err)
+	}
+}
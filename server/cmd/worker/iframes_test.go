@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadMediaPlaylist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "playlist.m3u8")
+	playlist := "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6.000000,\nsegment_000.ts\n\n#EXTINF:2.5,\nsegment_001.ts\n#EXT-X-ENDLIST\n"
+	if err := os.WriteFile(path, []byte(playlist), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readMediaPlaylist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []playlistSegment{{"segment_000.ts", 6}, {"segment_001.ts", 2.5}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readMediaPlaylist = %+v, want %+v", got, want)
+	}
+}
+
+func TestAlignTS(t *testing.T) {
+	for _, tt := range []struct{ n, want int64 }{{0, 0}, {1, 188}, {188, 188}, {189, 376}, {1000, 1128}} {
+		if got := alignTS(tt.n); got != tt.want {
+			t.Errorf("alignTS(%d) = %d, want %d", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestProbeKeyframes(t *testing.T) {
+	setVar(t, &ffprobePath, "ffprobe")
+	// Key frames at 0s and 2s; the last one runs to the end of the file
+	fakeCommand(t, "ffprobe", `printf '0.000000,376,K__\n0.040000,5000,___\n2.000000,9024,K__\n2.040000,12000,___\n'`)
+
+	segment := filepath.Join(t.TempDir(), "segment_000.ts")
+	if err := os.WriteFile(segment, make([]byte, 20000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := probeKeyframes(context.Background(), segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []keyframe{
+		{Segment: "segment_000.ts", Time: 0, Offset: 376, Length: alignTS(5000 - 376)},
+		{Segment: "segment_000.ts", Time: 2, Offset: 9024, Length: alignTS(12000 - 9024)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("probeKeyframes = %+v, want %+v", got, want)
+	}
+}
+
+func TestBuildIFramePlaylist(t *testing.T) {
+	frames := []keyframe{
+		{Segment: "segment_000.ts", Time: 0, Offset: 0, Length: 4700},
+		{Segment: "segment_000.ts", Time: 2, Offset: 9024, Length: 3008},
+		{Segment: "segment_001.ts", Time: 6, Offset: 0, Length: 4512},
+	}
+
+	playlist, bandwidth := buildIFramePlaylist(frames, 8)
+
+	want := "#EXTM3U\n#EXT-X-VERSION:4\n#EXT-X-TARGETDURATION:4\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-I-FRAMES-ONLY\n" +
+		"#EXTINF:2.000000,\n#EXT-X-BYTERANGE:4700@0\nsegment_000.ts\n" +
+		"#EXTINF:4.000000,\n#EXT-X-BYTERANGE:3008@9024\nsegment_000.ts\n" +
+		"#EXTINF:2.000000,\n#EXT-X-BYTERANGE:4512@0\nsegment_001.ts\n" +
+		"#EXT-X-ENDLIST\n"
+	if playlist != want {
+		t.Errorf("playlist =\n%s\nwant\n%s", playlist, want)
+	}
+	// 12220 bytes over 8 seconds
+	if want := 12220; bandwidth != want {
+		t.Errorf("bandwidth = %d, want %d", bandwidth, want)
+	}
+}
+
+// iframeOutput lays out FFmpeg's HLS output for two variants
+func iframeOutput(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	master := "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=2800000\nstream_0/playlist.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=800000\nstream_1/playlist.m3u8\n"
+	if err := os.WriteFile(filepath.Join(dir, "master.m3u8"), []byte(master), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 2 {
+		streamDir := filepath.Join(dir, variantDirName(i))
+		os.MkdirAll(streamDir, 0o755)
+		os.WriteFile(filepath.Join(streamDir, "playlist.m3u8"), []byte("#EXTM3U\n#EXTINF:4.0,\nsegment_000.ts\n#EXT-X-ENDLIST\n"), 0o644)
+		os.WriteFile(filepath.Join(streamDir, "segment_000.ts"), make([]byte, 18800), 0o644)
+	}
+	return dir
+}
+
+func TestWriteIFramePlaylists(t *testing.T) {
+	setVar(t, &ffprobePath, "ffprobe")
+	setVar(t, &hlsSegmentType, "mpegts")
+	fakeCommand(t, "ffprobe", `printf '0.000000,0,K__\n0.040000,3000,___\n2.000000,9400,K__\n'`)
+	renditions := []Rendition{{Height: 720}, {Height: 360}}
+
+	tests := []struct {
+		name        string
+		enabled     bool
+		wantStreams int
+		want        []string
+	}{
+		{"disabled", false, 0, nil},
+		{"enabled", true, 2, []string{
+			`#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=`,
+			`,RESOLUTION=1280x720,URI="stream_0/iframes.m3u8"`,
+			`,RESOLUTION=640x360,URI="stream_1/iframes.m3u8"`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &iframePlaylists, tt.enabled)
+			dir := iframeOutput(t)
+
+			writeIFramePlaylists(context.Background(), dir, renditions, 1920, 1080)
+
+			master, err := os.ReadFile(filepath.Join(dir, "master.m3u8"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Count(string(master), "#EXT-X-I-FRAME-STREAM-INF"); got != tt.wantStreams {
+				t.Errorf("master has %d I-frame streams:\n%s", got, master)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(master), want) {
+					t.Errorf("master is missing %q:\n%s", want, master)
+				}
+			}
+			for i := range renditions {
+				_, err := os.Stat(filepath.Join(dir, variantDirName(i), iframePlaylistName))
+				if (err == nil) != tt.enabled {
+					t.Errorf("variant %d I-frame playlist exists = %v, want %v", i, err == nil, tt.enabled)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteIFramePlaylistsSkipsFMP4(t *testing.T) {
+	setVar(t, &iframePlaylists, true)
+	setVar(t, &hlsSegmentType, "fmp4")
+	dir := iframeOutput(t)
+
+	writeIFramePlaylists(context.Background(), dir, []Rendition{{Height: 720}}, 1920, 1080)
+
+	master, _ := os.ReadFile(filepath.Join(dir, "master.m3u8"))
+	if strings.Contains(string(master), "I-FRAME") {
+		t.Errorf("fMP4 output got I-frame streams:\n%s", master)
+	}
+}
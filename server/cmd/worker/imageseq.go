@@ -0,0 +1,245 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/devrayat000/video-process/models"
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+var (
+	// Most frames an image sequence archive may hold
+	imageSequenceMaxFrames = server_utils.GetEnvInt("IMAGE_SEQUENCE_MAX_FRAMES", 10000)
+	// Largest archive, and largest total of unpacked frames, in bytes
+	imageSequenceMaxBytes = int64(server_utils.GetEnvInt("IMAGE_SEQUENCE_MAX_BYTES", 2<<30))
+)
+
+// Frame extensions accepted in an archive, mapped to the one image2 sees so
+// .jpg and .jpeg can be mixed
+var sequenceExtensions = map[string]string{
+	".png":  ".png",
+	".jpg":  ".jpg",
+	".jpeg": ".jpg",
+}
+
+// imageSequenceDir is where an archive is unpacked; kept apart from the
+// transcode directory, which is removed and recreated per attempt
+func imageSequenceDir(job models.VideoJob) string {
+	return fmt.Sprintf("/tmp/%s-sequence", job.VideoID)
+}
+
+// prepareImageSequence downloads a job's frame archive, validates it and
+// assembles the frames into an MP4 that the normal pipeline reads as its
+// source. The returned path lives under imageSequenceDir.
+func prepareImageSequence(ctx context.Context, gcsClient *storage.Client, job models.VideoJob, sourceURL string) (string, error) {
+	dir := imageSequenceDir(job)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create sequence directory: %w", err)
+	}
+
+	archivePath := path.Join(dir, "source.zip")
+	if err := downloadSequenceArchive(ctx, gcsClient, job, sourceURL, archivePath); err != nil {
+		return "", err
+	}
+
+	ext, count, err := extractFrames(archivePath, dir)
+	if err != nil {
+		return "", err
+	}
+	os.Remove(archivePath)
+	logf(ctx, " [i] Image sequence: %d frames at %g fps", count, job.ImageSequence.FrameRate)
+
+	outputPath := path.Join(dir, "sequence.mp4")
+	if err := assembleSequence(ctx, dir, ext, job.ImageSequence.FrameRate, outputPath); err != nil {
+		return "", err
+	}
+	return outputPath, nil
+}
+
+// downloadSequenceArchive copies the archive to localPath, reading GCS
+// objects directly and anything else through the SSRF-guarded client
+func downloadSequenceArchive(ctx context.Context, gcsClient *storage.Client, job models.VideoJob, sourceURL, localPath string) error {
+	bucketName, key, ok := sourceObject(job)
+	if !ok {
+		if err := fetchRemote(ctx, sourceURL, localPath, imageSequenceMaxBytes); err != nil {
+			return fmt.Errorf("failed to download image sequence archive: %w", err)
+		}
+		return nil
+	}
+
+	reader, err := gcsClient.Bucket(bucketName).Object(key).NewReader(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open image sequence archive: %w", err)
+	}
+	defer reader.Close()
+
+	file, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	n, err := io.Copy(file, io.LimitReader(reader, imageSequenceMaxBytes+1))
+	if err != nil {
+		return fmt.Errorf("failed to download image sequence archive: %w", err)
+	}
+	if n > imageSequenceMaxBytes {
+		return fmt.Errorf("invalid image sequence: archive is larger than %d bytes", imageSequenceMaxBytes)
+	}
+	return file.Close()
+}
+
+// extractFrames checks the archive and writes its frames to dir as
+// frame_000000.ext, frame_000001.ext, ... in natural name order, so
+// frame2.png sorts before frame10.png. Entry names are never used as paths.
+// Every frame must share one image type.
+func extractFrames(archivePath, dir string) (string, int, error) {
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid image sequence: %w", err)
+	}
+	defer archive.Close()
+
+	var frames []*zip.File
+	var ext string
+	var total uint64
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() || isArchiveJunk(f.Name) {
+			continue
+		}
+		frameExt, ok := sequenceExtensions[strings.ToLower(path.Ext(f.Name))]
+		if !ok {
+			return "", 0, fmt.Errorf("invalid image sequence: %q is not a PNG or JPEG frame", f.Name)
+		}
+		if ext == "" {
+			ext = frameExt
+		} else if frameExt != ext {
+			return "", 0, fmt.Errorf("invalid image sequence: frames mix %s and %s images", ext, frameExt)
+		}
+		total += f.UncompressedSize64
+		if total > uint64(imageSequenceMaxBytes) {
+			return "", 0, fmt.Errorf("invalid image sequence: frames unpack to more than %d bytes", imageSequenceMaxBytes)
+		}
+		frames = append(frames, f)
+	}
+
+	if len(frames) == 0 {
+		return "", 0, fmt.Errorf("invalid image sequence: archive contains no frames")
+	}
+	if len(frames) > imageSequenceMaxFrames {
+		return "", 0, fmt.Errorf("invalid image sequence: %d frames exceeds the limit of %d", len(frames), imageSequenceMaxFrames)
+	}
+
+	slices.SortFunc(frames, func(a, b *zip.File) int { return naturalCompare(a.Name, b.Name) })
+
+	for i, f := range frames {
+		if err := extractFrame(f, path.Join(dir, fmt.Sprintf("frame_%06d%s", i, ext))); err != nil {
+			return "", 0, fmt.Errorf("invalid image sequence: %s: %w", f.Name, err)
+		}
+	}
+	return ext, len(frames), nil
+}
+
+func extractFrame(f *zip.File, dest string) error {
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	// archive/zip rejects entries that don't match their declared size
+	if _, err := io.Copy(out, src); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// isArchiveJunk skips the metadata macOS and others add to archives
+func isArchiveJunk(name string) bool {
+	return strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), ".")
+}
+
+// naturalCompare orders names with runs of digits compared by value
+func naturalCompare(a, b string) int {
+	for a != "" && b != "" {
+		da, db := leadingDigits(a), leadingDigits(b)
+		if da != "" && db != "" {
+			na, _ := strconv.ParseUint(da, 10, 64)
+			nb, _ := strconv.ParseUint(db, 10, 64)
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+		if a[0] != b[0] {
+			return strings.Compare(a[:1], b[:1])
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) - len(b)
+}
+
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+// imageSequenceInputArgs reads the extracted frames with the image2 demuxer
+func imageSequenceInputArgs(dir, ext string, frameRate float64) []string {
+	return []string{
+		"-f", "image2",
+		"-framerate", strconv.FormatFloat(frameRate, 'f', -1, 64),
+		"-start_number", "0",
+		"-i", path.Join(dir, "frame_%06d"+ext),
+	}
+}
+
+// assembleSequence encodes the frames into a near-lossless MP4. Frames are
+// padded to even dimensions for yuv420p, and a silent track is added since
+// the HLS pipeline always maps an audio stream.
+func assembleSequence(ctx context.Context, dir, ext string, frameRate float64, outputPath string) error {
+	args := []string{"-y", "-v", "error", "-nostats"}
+	args = append(args, imageSequenceInputArgs(dir, ext, frameRate)...)
+	args = append(args,
+		"-f", "lavfi", "-i", "anullsrc=channel_layout=stereo:sample_rate=48000",
+		"-map", "0:v", "-map", "1:a",
+		"-vf", "pad=ceil(iw/2)*2:ceil(ih/2)*2,format=yuv420p",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "12",
+		"-c:a", "aac",
+		"-shortest",
+		outputPath,
+	)
+
+	release, err := acquireFFmpegSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if output, err := ffmpegCommand(ctx, args).CombinedOutput(); err != nil {
+		return &ffmpegError{err: fmt.Errorf("failed to assemble image sequence: %w", err), stderr: strings.TrimSpace(string(output))}
+	}
+	return nil
+}
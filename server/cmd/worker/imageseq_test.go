@@ -0,0 +1,178 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/devrayat000/video-process/models"
+)
+
+func TestNaturalCompare(t *testing.T) {
+	sign := func(n int) int {
+		switch {
+		case n < 0:
+			return -1
+		case n > 0:
+			return 1
+		}
+		return 0
+	}
+
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"frame2.png", "frame10.png", -1},
+		{"frame10.png", "frame2.png", 1},
+		{"frame2.png", "frame2.png", 0},
+		{"frame002.png", "frame2.png", 0},
+		{"a.png", "b.png", -1},
+		{"shot1_10.png", "shot1_9.png", 1},
+		{"shot2_1.png", "shot10_1.png", -1},
+		{"frame", "frame1", -1},
+		{"", "a", -1},
+	}
+	for _, tt := range tests {
+		if got := sign(naturalCompare(tt.a, tt.b)); got != tt.want {
+			t.Errorf("naturalCompare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestNaturalCompareSort(t *testing.T) {
+	names := []string{"img10.jpg", "img1.jpg", "img2.jpg", "img100.jpg", "img20.jpg"}
+	slices.SortFunc(names, naturalCompare)
+	want := []string{"img1.jpg", "img2.jpg", "img10.jpg", "img20.jpg", "img100.jpg"}
+	if !slices.Equal(names, want) {
+		t.Errorf("sorted = %q, want %q", names, want)
+	}
+}
+
+func TestIsArchiveJunk(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"frames/0001.png", false},
+		{"__MACOSX/frames/._0001.png", true},
+		{"frames/.DS_Store", true},
+		{".hidden.png", true},
+	}
+	for _, tt := range tests {
+		if got := isArchiveJunk(tt.name); got != tt.want {
+			t.Errorf("isArchiveJunk(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestImageSequenceInputArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		ext       string
+		frameRate float64
+		want      []string
+	}{
+		{"png at 24 fps", ".png", 24, []string{"-f", "image2", "-framerate", "24", "-start_number", "0", "-i", "/tmp/seq/frame_%06d.png"}},
+		{"jpeg at a fractional rate", ".jpg", 29.97, []string{"-f", "image2", "-framerate", "29.97", "-start_number", "0", "-i", "/tmp/seq/frame_%06d.jpg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := imageSequenceInputArgs("/tmp/seq", tt.ext, tt.frameRate); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("imageSequenceInputArgs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// writeArchive builds a ZIP holding an entry per name and returns its path
+func writeArchive(t *testing.T, names ...string) string {
+	t.Helper()
+	archivePath := filepath.Join(t.TempDir(), "source.zip")
+	file, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	w := zip.NewWriter(file)
+	for _, name := range names {
+		entry, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Write([]byte(name))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return archivePath
+}
+
+func TestExtractFrames(t *testing.T) {
+	tests := []struct {
+		name      string
+		entries   []string
+		maxFrames int
+		wantExt   string
+		want      []string
+		wantErr   bool
+	}{
+		{
+			name:    "natural order",
+			entries: []string{"shot/f10.png", "shot/f2.png", "shot/f1.png"},
+			wantExt: ".png",
+			want:    []string{"shot/f1.png", "shot/f2.png", "shot/f10.png"},
+		},
+		{
+			name:    "jpg and jpeg mix",
+			entries: []string{"b.jpeg", "a.JPG"},
+			wantExt: ".jpg",
+			want:    []string{"a.JPG", "b.jpeg"},
+		},
+		{
+			name:    "junk and directories skipped",
+			entries: []string{"__MACOSX/._a.png", ".DS_Store", "frames/", "a.png"},
+			wantExt: ".png",
+			want:    []string{"a.png"},
+		},
+		{name: "not a frame", entries: []string{"a.png", "notes.txt"}, wantErr: true},
+		{name: "mixed image types", entries: []string{"a.png", "b.jpg"}, wantErr: true},
+		{name: "no frames", entries: []string{".DS_Store"}, wantErr: true},
+		{name: "too many frames", entries: []string{"a.png", "b.png", "c.png"}, maxFrames: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.maxFrames > 0 {
+				setVar(t, &imageSequenceMaxFrames, tt.maxFrames)
+			}
+			dir := t.TempDir()
+			ext, count, err := extractFrames(writeArchive(t, tt.entries...), dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("extractFrames error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if classifyFailure(err) != models.FailureUnsupportedInput {
+					t.Errorf("%v classified as %s, want %s", err, classifyFailure(err), models.FailureUnsupportedInput)
+				}
+				return
+			}
+			if ext != tt.wantExt || count != len(tt.want) {
+				t.Fatalf("extractFrames = %q, %d, want %q, %d", ext, count, tt.wantExt, len(tt.want))
+			}
+			// Each frame holds its entry name, so the order can be read back
+			for i, name := range tt.want {
+				data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("frame_%06d%s", i, ext)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != name {
+					t.Errorf("frame %d = %s, want %s", i, data, name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/devrayat000/video-process/pubsub"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"github.com/google/uuid"
+)
+
+// Publish each job's log lines, and FFmpeg's stderr, to logs:{id} for
+// GET /videos/{id}/logs
+var jobLogs = server_utils.GetEnvBool("JOB_LOGS", true)
+
+type jobLogKey struct{}
+
+// withJobLog marks ctx as belonging to a video's job, so logf publishes
+func withJobLog(ctx context.Context, videoID uuid.UUID) context.Context {
+	return context.WithValue(ctx, jobLogKey{}, videoID)
+}
+
+// logf logs like log.Printf and, within a job, also publishes the line to
+// the job's log channel
+func logf(ctx context.Context, format string, args ...any) {
+	line := fmt.Sprintf(format, args...)
+	log.Print(line)
+	publishJobLog(ctx, line)
+}
+
+// publishJobLog sends a line to the job's channel with secrets redacted. It
+// is a no-op outside a job or with JOB_LOGS off.
+func publishJobLog(ctx context.Context, line string) {
+	videoID, ok := ctx.Value(jobLogKey{}).(uuid.UUID)
+	if !ok || !jobLogs {
+		return
+	}
+	// Lines written while a failed job winds down still go out
+	ctx = context.WithoutCancel(ctx)
+	if err := pubsub.PublishJobLog(ctx, videoID.String(), server_utils.RedactSecrets(line)); err != nil {
+		log.Printf(" [!] %v", err)
+	}
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+var (
+	// LL_HLS adds low-latency partial segments (EXT-X-PART) to the variant
+	// playlists. It implies fMP4 segments.
+	llHLS = server_utils.GetEnvBool("LL_HLS", false)
+	// Target length of each partial segment
+	llHLSPartDuration = server_utils.GetEnvDuration("LL_HLS_PART_DURATION", 333*time.Millisecond)
+)
+
+func validateLLHLS(enabled bool, part time.Duration) error {
+	if !enabled {
+		return nil
+	}
+	if part < 100*time.Millisecond || part > 2*time.Second {
+		return fmt.Errorf("LL_HLS_PART_DURATION must be between 100ms and 2s, got %s", part)
+	}
+	return nil
+}
+
+// llHLSArgs makes the fMP4 segment muxer cut a fragment (moof+mdat) every
+// part duration. FFmpeg's HLS muxer doesn't write EXT-X-PART itself; the
+// fragments are listed as byte-range parts by addPartialSegments.
+func llHLSArgs() []string {
+	if !llHLS {
+		return nil
+	}
+	return []string{"-hls_segment_options", fmt.Sprintf("frag_duration=%d", llHLSPartDuration.Microseconds())}
+}
+
+// mp4Box is a top-level box found by walkBoxes
+type mp4Box struct {
+	Type      string
+	Offset    int64
+	Size      int64
+	HeaderLen int64
+}
+
+// walkBoxes calls fn for each top-level box of r in order. fn may read from
+// r; walkBoxes seeks past the box afterwards.
+func walkBoxes(r io.ReadSeeker, fn func(box mp4Box) error) error {
+	var offset int64
+	header := make([]byte, 16)
+
+	for {
+		if _, err := io.ReadFull(r, header[:8]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		box := mp4Box{
+			Type:      string(header[4:8]),
+			Offset:    offset,
+			Size:      int64(binary.BigEndian.Uint32(header[:4])),
+			HeaderLen: 8,
+		}
+		switch box.Size {
+		case 1:
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return err
+			}
+			box.Size = int64(binary.BigEndian.Uint64(header[8:16]))
+			box.HeaderLen = 16
+		case 0:
+			end, err := r.Seek(0, io.SeekEnd)
+			if err != nil {
+				return err
+			}
+			box.Size = end - offset
+		}
+		if box.Size < box.HeaderLen {
+			return fmt.Errorf("invalid %q box size %d at offset %d", box.Type, box.Size, offset)
+		}
+
+		if err := fn(box); err != nil {
+			return err
+		}
+
+		offset += box.Size
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+}
+
+// readPayload reads the body of a box found by walkBoxes
+func readPayload(r io.ReadSeeker, box mp4Box) ([]byte, error) {
+	if _, err := r.Seek(box.Offset+box.HeaderLen, io.SeekStart); err != nil {
+		return nil, err
+	}
+	payload := make([]byte, box.Size-box.HeaderLen)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("truncated %q box at offset %d: %w", box.Type, box.Offset, err)
+	}
+	return payload, nil
+}
+
+// childBoxes calls fn with the type and body of each box nested in data
+func childBoxes(data []byte, fn func(boxType string, payload []byte) error) error {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		boxType := string(data[4:8])
+		headerLen := uint64(8)
+		switch size {
+		case 1:
+			if len(data) < 16 {
+				return fmt.Errorf("truncated %q box", boxType)
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			headerLen = 16
+		case 0:
+			size = uint64(len(data))
+		}
+		if size < headerLen || size > uint64(len(data)) {
+			return fmt.Errorf("invalid %q box size %d", boxType, size)
+		}
+		if err := fn(boxType, data[headerLen:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// fullBoxField reads the 32-bit field at pos of a full box's body
+func fullBoxField(boxType string, payload []byte, pos int) (uint32, error) {
+	if pos < 0 || len(payload) < pos+4 {
+		return 0, fmt.Errorf("truncated %q box", boxType)
+	}
+	return binary.BigEndian.Uint32(payload[pos : pos+4]), nil
+}
+
+// fmp4Track is what a track's fragment durations are read against: the
+// media timescale from mdhd and the default sample duration from trex
+type fmp4Track struct {
+	Timescale       uint32
+	DefaultDuration uint32
+}
+
+// initTracks reads the tracks of an fMP4 init segment's moov, keyed by
+// track ID
+func initTracks(r io.ReadSeeker) (map[uint32]fmp4Track, error) {
+	tracks := make(map[uint32]fmp4Track)
+
+	err := walkBoxes(r, func(box mp4Box) error {
+		if box.Type != "moov" {
+			return nil
+		}
+		moov, err := readPayload(r, box)
+		if err != nil {
+			return err
+		}
+		return childBoxes(moov, func(boxType string, payload []byte) error {
+			switch boxType {
+			case "trak":
+				return readTrak(payload, tracks)
+			case "mvex":
+				return childBoxes(payload, func(boxType string, payload []byte) error {
+					if boxType != "trex" {
+						return nil
+					}
+					id, err := fullBoxField(boxType, payload, 4)
+					if err != nil {
+						return err
+					}
+					duration, err := fullBoxField(boxType, payload, 12)
+					if err != nil {
+						return err
+					}
+					track := tracks[id]
+					track.DefaultDuration = duration
+					tracks[id] = track
+					return nil
+				})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("no tracks in init segment")
+	}
+	return tracks, nil
+}
+
+// readTrak records a trak's ID (tkhd) and timescale (mdia/mdhd)
+func readTrak(trak []byte, tracks map[uint32]fmp4Track) error {
+	var id, timescale uint32
+	err := childBoxes(trak, func(boxType string, payload []byte) error {
+		var err error
+		switch boxType {
+		case "tkhd":
+			// version 1 has 64-bit creation and modification times
+			pos := 12
+			if len(payload) > 0 && payload[0] == 1 {
+				pos = 20
+			}
+			id, err = fullBoxField(boxType, payload, pos)
+		case "mdia":
+			err = childBoxes(payload, func(boxType string, payload []byte) error {
+				if boxType != "mdhd" {
+					return nil
+				}
+				pos := 12
+				if len(payload) > 0 && payload[0] == 1 {
+					pos = 20
+				}
+				var err error
+				timescale, err = fullBoxField(boxType, payload, pos)
+				return err
+			})
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	track := tracks[id]
+	track.Timescale = timescale
+	tracks[id] = track
+	return nil
+}
+
+// fragment is one moof+mdat pair of an fMP4 segment: its byte range and how
+// long its samples play for
+type fragment struct {
+	Offset   int64
+	Size     int64
+	Duration float64
+}
+
+// fragmentRanges returns each moof+mdat pair in an fMP4 segment, in order.
+// Boxes before the first moof (styp, sidx) belong to no part.
+func fragmentRanges(r io.ReadSeeker, tracks map[uint32]fmp4Track) ([]fragment, error) {
+	var fragments []fragment
+	var current *fragment
+
+	err := walkBoxes(r, func(box mp4Box) error {
+		switch box.Type {
+		case "moof":
+			moof, err := readPayload(r, box)
+			if err != nil {
+				return err
+			}
+			duration, err := fragmentDuration(moof, tracks)
+			if err != nil {
+				return fmt.Errorf("moof at offset %d: %w", box.Offset, err)
+			}
+			current = &fragment{Offset: box.Offset, Duration: duration}
+		case "mdat":
+			if current != nil {
+				current.Size = box.Offset + box.Size - current.Offset
+				fragments = append(fragments, *current)
+				current = nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fragments, nil
+}
+
+// fragmentDuration sums the sample durations of each of a moof's track runs
+// (trun, falling back to the tfhd then trex default) and returns the longest
+// in seconds
+func fragmentDuration(moof []byte, tracks map[uint32]fmp4Track) (float64, error) {
+	var longest float64
+	err := childBoxes(moof, func(boxType string, traf []byte) error {
+		if boxType != "traf" {
+			return nil
+		}
+		var track fmp4Track
+		var ticks uint64
+		err := childBoxes(traf, func(boxType string, payload []byte) error {
+			switch boxType {
+			case "tfhd":
+				return readTfhd(payload, tracks, &track)
+			case "trun":
+				duration, err := trunDuration(payload, track.DefaultDuration)
+				ticks += duration
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if track.Timescale == 0 {
+			return fmt.Errorf("traf has no known track")
+		}
+		longest = max(longest, float64(ticks)/float64(track.Timescale))
+		return nil
+	})
+	return longest, err
+}
+
+// readTfhd looks up the fragment's track and applies its default sample
+// duration, if the tfhd carries one
+func readTfhd(tfhd []byte, tracks map[uint32]fmp4Track, track *fmp4Track) error {
+	flags, err := fullBoxField("tfhd", tfhd, 0)
+	if err != nil {
+		return err
+	}
+	id, err := fullBoxField("tfhd", tfhd, 4)
+	if err != nil {
+		return err
+	}
+	known, ok := tracks[id]
+	if !ok {
+		return fmt.Errorf("unknown track %d", id)
+	}
+	*track = known
+
+	pos := 8
+	if flags&0x01 != 0 { // base-data-offset
+		pos += 8
+	}
+	if flags&0x02 != 0 { // sample-description-index
+		pos += 4
+	}
+	if flags&0x08 != 0 { // default-sample-duration
+		track.DefaultDuration, err = fullBoxField("tfhd", tfhd, pos)
+	}
+	return err
+}
+
+// trunDuration sums a track run's sample durations in its track's timescale
+func trunDuration(trun []byte, defaultDuration uint32) (uint64, error) {
+	flags, err := fullBoxField("trun", trun, 0)
+	if err != nil {
+		return 0, err
+	}
+	count, err := fullBoxField("trun", trun, 4)
+	if err != nil {
+		return 0, err
+	}
+	if flags&0x100 == 0 { // no per-sample durations
+		return uint64(count) * uint64(defaultDuration), nil
+	}
+
+	pos := 8
+	if flags&0x01 != 0 { // data-offset
+		pos += 4
+	}
+	if flags&0x04 != 0 { // first-sample-flags
+		pos += 4
+	}
+	// Each sample has a duration, then optional size, flags and composition
+	// time offset
+	stride := 4
+	for _, field := range []uint32{0x200, 0x400, 0x800} {
+		if flags&field != 0 {
+			stride += 4
+		}
+	}
+
+	var total uint64
+	for i := 0; i < int(count); i++ {
+		duration, err := fullBoxField("trun", trun, pos+i*stride)
+		if err != nil {
+			return 0, err
+		}
+		total += uint64(duration)
+	}
+	return total, nil
+}
+
+// partialSegmentTags lists a segment's fragments as EXT-X-PART tags, each
+// with the duration its samples play for. Only the first starts on a key
+// frame.
+func partialSegmentTags(uri string, fragments []fragment) []string {
+	tags := make([]string, 0, len(fragments))
+	for i, f := range fragments {
+		tag := fmt.Sprintf(`#EXT-X-PART:DURATION=%.5f,URI="%s",BYTERANGE="%d@%d"`, f.Duration, uri, f.Size, f.Offset)
+		if i == 0 {
+			tag += ",INDEPENDENT=YES"
+		}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// addPartialSegments rewrites a local variant playlist in place with the
+// LL-HLS directives: EXT-X-PART-INF and EXT-X-SERVER-CONTROL in the header
+// and each segment's parts ahead of its EXTINF. Part durations are read from
+// the fragments against the EXT-X-MAP init segment, and PART-TARGET is raised
+// to the longest part when a fragment ran past LL_HLS_PART_DURATION. The
+// output is VOD, ending in EXT-X-ENDLIST, so there is never a part still to
+// come and no EXT-X-PRELOAD-HINT is written. It must run before URIs are made
+// absolute.
+func addPartialSegments(localPath string) error {
+	if !llHLS {
+		return nil
+	}
+
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to read playlist %s: %w", localPath, err)
+	}
+	dir := path.Dir(localPath)
+	partTarget := llHLSPartDuration.Seconds()
+
+	var tracks map[uint32]fmp4Track
+	partInf := -1
+	lines := strings.Split(string(data), "\n")
+	out := make([]string, 0, len(lines)*2)
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			out = append(out, line)
+			partInf = len(out)
+			out = append(out, "", "")
+			continue
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			match := uriAttribute.FindStringSubmatch(line)
+			if match == nil {
+				return fmt.Errorf("EXT-X-MAP without a URI in %s", localPath)
+			}
+			tracks, err = readInitTracks(path.Join(dir, match[1]))
+			if err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "#EXTINF:") && i+1 < len(lines):
+			if tracks == nil {
+				return fmt.Errorf("segment without an EXT-X-MAP in %s", localPath)
+			}
+			uri := strings.TrimSpace(lines[i+1])
+
+			segment, err := os.Open(path.Join(dir, uri))
+			if err != nil {
+				return fmt.Errorf("failed to open segment %s: %w", uri, err)
+			}
+			fragments, err := fragmentRanges(segment, tracks)
+			segment.Close()
+			if err != nil {
+				return fmt.Errorf("failed to read fragments of %s: %w", uri, err)
+			}
+			for _, f := range fragments {
+				partTarget = max(partTarget, f.Duration)
+			}
+			out = append(out, partialSegmentTags(uri, fragments)...)
+		}
+		out = append(out, line)
+	}
+
+	if partInf >= 0 {
+		out[partInf] = fmt.Sprintf("#EXT-X-PART-INF:PART-TARGET=%.5f", partTarget)
+		out[partInf+1] = fmt.Sprintf("#EXT-X-SERVER-CONTROL:PART-HOLD-BACK=%.5f", 3*partTarget)
+	}
+
+	if err := os.WriteFile(localPath, []byte(strings.Join(out, "\n")), 0o644); err != nil {
+		return fmt.Errorf("failed to rewrite playlist %s: %w", localPath, err)
+	}
+	return nil
+}
+
+// readInitTracks opens an init segment and reads its tracks
+func readInitTracks(localPath string) (map[uint32]fmp4Track, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open init segment %s: %w", localPath, err)
+	}
+	defer file.Close()
+
+	tracks, err := initTracks(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read init segment %s: %w", localPath, err)
+	}
+	return tracks, nil
+}
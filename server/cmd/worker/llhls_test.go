@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// box builds an MP4 box from its type and body parts
+func box(boxType string, parts ...[]byte) []byte {
+	body := bytes.Join(parts, nil)
+	out := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(out, boxType...), body...)
+}
+
+// u32s encodes each value as a big-endian 32-bit field
+func u32s(values ...uint32) []byte {
+	var out []byte
+	for _, v := range values {
+		out = binary.BigEndian.AppendUint32(out, v)
+	}
+	return out
+}
+
+// testInit is an init segment with a 90 kHz video track 1 and a 48 kHz
+// audio track 2 whose trex default sample duration is 1024
+func testInit() []byte {
+	trak := func(id, timescale uint32) []byte {
+		return box("trak",
+			box("tkhd", u32s(0, 0, 0, id)),
+			box("mdia", box("mdhd", u32s(0, 0, 0, timescale))))
+	}
+	moov := box("moov",
+		trak(1, 90000),
+		trak(2, 48000),
+		box("mvex",
+			box("trex", u32s(0, 1, 1, 0, 0, 0)),
+			box("trex", u32s(0, 2, 1, 1024, 0, 0))))
+	return append(box("ftyp", []byte("iso6")), moov...)
+}
+
+func TestValidateLLHLS(t *testing.T) {
+	tests := []struct {
+		enabled bool
+		part    time.Duration
+		wantErr bool
+	}{
+		{false, 0, false},
+		{true, 333 * time.Millisecond, false},
+		{true, 100 * time.Millisecond, false},
+		{true, 2 * time.Second, false},
+		{true, 50 * time.Millisecond, true},
+		{true, 3 * time.Second, true},
+	}
+	for _, tt := range tests {
+		if err := validateLLHLS(tt.enabled, tt.part); (err != nil) != tt.wantErr {
+			t.Errorf("validateLLHLS(%v, %s) error = %v, wantErr %v", tt.enabled, tt.part, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLLHLSArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		part    time.Duration
+		want    []string
+	}{
+		{"disabled", false, 333 * time.Millisecond, nil},
+		{"default part", true, 333 * time.Millisecond, []string{"-hls_segment_options", "frag_duration=333000"}},
+		{"one second parts", true, time.Second, []string{"-hls_segment_options", "frag_duration=1000000"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &llHLS, tt.enabled)
+			setVar(t, &llHLSPartDuration, tt.part)
+			if got := llHLSArgs(); !slices.Equal(got, tt.want) {
+				t.Errorf("llHLSArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitTracks(t *testing.T) {
+	tracks, err := initTracks(bytes.NewReader(testInit()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[uint32]fmp4Track{
+		1: {Timescale: 90000},
+		2: {Timescale: 48000, DefaultDuration: 1024},
+	}
+	if !reflect.DeepEqual(tracks, want) {
+		t.Errorf("initTracks = %+v, want %+v", tracks, want)
+	}
+}
+
+func TestFragmentRanges(t *testing.T) {
+	tracks := map[uint32]fmp4Track{
+		1: {Timescale: 90000},
+		2: {Timescale: 48000, DefaultDuration: 1024},
+	}
+
+	// Video: per-sample durations and sizes after a data offset
+	videoTraf := box("traf",
+		box("tfhd", u32s(0, 1)),
+		box("trun", u32s(0x000301, 3, 0, 3000, 100, 3000, 100, 3000, 100)))
+	// Audio: tfhd default duration, no per-sample fields
+	audioTraf := box("traf",
+		box("tfhd", u32s(0x08, 2, 960)),
+		box("trun", u32s(0, 10)))
+	// Audio: trex default only
+	trexTraf := box("traf",
+		box("tfhd", u32s(0, 2)),
+		box("trun", u32s(0, 9)))
+
+	first := box("moof", videoTraf, audioTraf)
+	second := box("moof", trexTraf)
+	mdat := box("mdat", make([]byte, 20))
+	styp := box("styp", []byte("msdh"))
+
+	segment := bytes.Join([][]byte{styp, first, mdat, second, mdat}, nil)
+	fragments, err := fragmentRanges(bytes.NewReader(segment), tracks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	firstOffset := int64(len(styp))
+	secondOffset := firstOffset + int64(len(first)+len(mdat))
+	want := []fragment{
+		{Offset: firstOffset, Size: int64(len(first) + len(mdat)), Duration: 0.2},
+		{Offset: secondOffset, Size: int64(len(second) + len(mdat)), Duration: 0.192},
+	}
+	if !reflect.DeepEqual(fragments, want) {
+		t.Errorf("fragmentRanges = %+v, want %+v", fragments, want)
+	}
+}
+
+func TestFragmentRangesErrors(t *testing.T) {
+	tracks := map[uint32]fmp4Track{1: {Timescale: 90000}}
+
+	tests := []struct {
+		name    string
+		segment []byte
+	}{
+		{"unknown track", box("moof", box("traf", box("tfhd", u32s(0, 7)), box("trun", u32s(0, 1))))},
+		{"truncated trun", box("moof", box("traf", box("tfhd", u32s(0, 1)), box("trun", u32s(0x100, 2, 3000))))},
+		{"box smaller than its header", u32s(4, 0x6d6f6f66)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := fragmentRanges(bytes.NewReader(tt.segment), tracks); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestPartialSegmentTags(t *testing.T) {
+	tags := partialSegmentTags("seg0.m4s", []fragment{
+		{Offset: 24, Size: 1000, Duration: 0.33367},
+		{Offset: 1024, Size: 900, Duration: 0.2},
+	})
+	want := []string{
+		`#EXT-X-PART:DURATION=0.33367,URI="seg0.m4s",BYTERANGE="1000@24",INDEPENDENT=YES`,
+		`#EXT-X-PART:DURATION=0.20000,URI="seg0.m4s",BYTERANGE="900@1024"`,
+	}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("partialSegmentTags = %q, want %q", tags, want)
+	}
+}
+
+func TestAddPartialSegments(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "init.mp4"), testInit(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Two fragments of 90 kHz video: six then three samples of 3000 ticks
+	fragment := func(samples int) []byte {
+		trun := []uint32{0x000100, uint32(samples)}
+		for range samples {
+			trun = append(trun, 3000)
+		}
+		moof := box("moof", box("traf", box("tfhd", u32s(0, 1)), box("trun", u32s(trun...))))
+		return append(moof, box("mdat", make([]byte, 20))...)
+	}
+	first, second := fragment(6), fragment(3)
+	if err := os.WriteFile(filepath.Join(dir, "segment_000.m4s"), append(slices.Clone(first), second...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	playlist := filepath.Join(dir, "playlist.m3u8")
+	original := "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:1\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:0.300000,\nsegment_000.m4s\n#EXT-X-ENDLIST\n"
+	if err := os.WriteFile(playlist, []byte(original), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	setVar(t, &llHLS, false)
+	if err := addPartialSegments(playlist); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(playlist); string(data) != original {
+		t.Errorf("playlist rewritten with LL_HLS off:\n%s", data)
+	}
+
+	// The first part runs past the configured target, which is raised to it
+	setVar(t, &llHLS, true)
+	setVar(t, &llHLSPartDuration, 150*time.Millisecond)
+	if err := addPartialSegments(playlist); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(playlist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"#EXTM3U",
+		"#EXT-X-VERSION:7",
+		"#EXT-X-TARGETDURATION:1",
+		"#EXT-X-PART-INF:PART-TARGET=0.20000",
+		"#EXT-X-SERVER-CONTROL:PART-HOLD-BACK=0.60000",
+		`#EXT-X-MAP:URI="init.mp4"`,
+		fmt.Sprintf(`#EXT-X-PART:DURATION=0.20000,URI="segment_000.m4s",BYTERANGE="%d@0",INDEPENDENT=YES`, len(first)),
+		fmt.Sprintf(`#EXT-X-PART:DURATION=0.10000,URI="segment_000.m4s",BYTERANGE="%d@%d"`, len(second), len(first)),
+		"#EXTINF:0.300000,",
+		"segment_000.m4s",
+		"#EXT-X-ENDLIST",
+		"",
+	}, "\n")
+	if string(data) != want {
+		t.Errorf("playlist =\n%s\nwant\n%s", data, want)
+	}
+	if strings.Contains(string(data), "#EXT-X-PRELOAD-HINT") {
+		t.Error("a VOD playlist has no upcoming part to hint")
+	}
+}
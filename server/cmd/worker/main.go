@@ -1,18 +1,18 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
-	"net/url"
+	"math"
 	"os"
-	"os/exec"
 	"os/signal"
-	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -28,20 +28,100 @@ import (
 // GCS configuration is resolved during startup via utils.InitStorage.
 // GCS configuration is resolved at runtime to keep API and worker consistent.
 var (
-	gcsPublicEndpoint = server_utils.GetEnv("GCS_PUBLIC_ENDPOINT", "https://storage.googleapis.com")
-	gcsBucket         = server_utils.GetEnv("GCS_BUCKET_NAME", "")
+	gcsBucket = server_utils.GetEnv("GCS_BUCKET_NAME", "")
 )
 
-// Rendition defines a single video quality preset
-type Rendition struct {
-	Height    int
-	Bitrate   int // in kbps
-	MaxRate   int // in kbps
-	BufSize   int // in kbps
-	AudioRate int // in kbps
-}
+// Rendition defines a single video quality preset. It lives in models so a
+// job can carry its own ladder.
+type Rendition = models.Rendition
 
 func main() {
+	if err := validateHLSNaming(hlsVariantDir, hlsSegmentPattern, hlsSegmentType); err != nil {
+		log.Fatal("Invalid HLS naming configuration: ", err)
+	}
+
+	if err := validateAudioCodec(audioCodec); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateAudioChannels(audioChannelsMode); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateAudioBitrate(sharedAudioBitrate, minAudioBitrate, maxAudioBitrate); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateDeinterlace(deinterlaceMode, deinterlaceFilterName); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateSegmentTarget(hlsSegmentTargetKB); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateGOPSize(gopSize); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateAVAlign(avAlignMode); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateUploadPartSize(uploadPartSize); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateTinySource(tinySourceBitrate, tinySourceMinBitrate); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateSmartLadder(smartLadderPercent, smartLadderMinBitrate); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateLLHLS(llHLS, llHLSPartDuration); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateSingleFileHLS(hlsSingleFile, llHLS); err != nil {
+		log.Fatal(err)
+	}
+
+	sink, err := newProgressSink(progressSinkNames)
+	if err != nil {
+		log.Fatal(err)
+	}
+	progressSink = sink
+
+	if err := server_utils.LoadRenditionProfiles(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validatePlaylistConfig(masterPlaylistName, playlistURIs, masterVariantOrder); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateLogLevel(ffmpegLogLevel); err != nil {
+		log.Fatal(err)
+	}
+
+	globalArgs, err := parseGlobalArgs(ffmpegGlobalArgsRaw)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ffmpegGlobalArgs = globalArgs
+
+	caps, err := probeFFmpegCapabilities(context.Background())
+	if err != nil {
+		log.Fatal("Failed to probe FFmpeg: ", err)
+	}
+	if err := checkFFmpegCapabilities(caps, requiredEncoders(), requiredFilters()); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf(" [i] %s", caps.Version)
+	log.Printf(" [i] Audio codec: %s, segment type: %s", audioCodec, hlsSegmentType)
+
 	// 0. Initialize Database and Redis
 	gormDB, err := db.InitDB()
 	if err != nil {
@@ -54,9 +134,12 @@ func main() {
 	defer redis.Close()
 
 	// 1. Connect to Google Cloud Storage
-	// 2. Setup graceful shutdown
+	// 2. Setup graceful shutdown. ctx stops taking new jobs; jobCtx aborts
+	// the ones already running.
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
 
 	gcsClient, err := server_utils.InitStorage(ctx)
 	if err != nil {
@@ -71,8 +154,12 @@ func main() {
 		<-sigChan
 		log.Println("Shutdown signal received, stopping worker...")
 		cancel()
+		drainJobs(sigChan, cancelJobs, shutdownGracePeriod)
 	}()
 
+	// Re-enqueue waiting videos whose job never reached the stream
+	go runReconciler(ctx, gormDB)
+
 	log.Println(" [*] Worker started. Ready to process videos from Redis Streams.")
 
 	// 3. Start consuming jobs from Redis
@@ -80,9 +167,13 @@ func main() {
 		log.Printf(" [x] Received Job: id=%s source=%s", job.VideoID, job.S3Path)
 
 		// Process the video
-		err := processVideoStreaming(gcsClient, gormDB, job)
+		err := processVideoStreaming(jobCtx, gcsClient, gormDB, job)
 		if err != nil {
 			log.Printf(" [!] Error processing %s: %v", job.VideoID, err)
+			// Only transient failures are worth another delivery
+			if jobCtx.Err() == nil && !classifyFailure(err).Retryable() {
+				return fmt.Errorf("%w: %w", pubsub.ErrJobFailed, err)
+			}
 			return err
 		}
 
@@ -97,172 +188,404 @@ func main() {
 	log.Println("Worker stopped gracefully")
 }
 
-func processVideoStreaming(gcsClient *storage.Client, gormDB *gorm.DB, job models.VideoJob) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Hour)
+func processVideoStreaming(ctx context.Context, gcsClient *storage.Client, gormDB *gorm.DB, job models.VideoJob) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Hour)
 	defer cancel()
+	ctx = withJobLog(ctx, job.VideoID)
+	defer webhooks.track(job.VideoID, job.Webhook)()
+
+	started := time.Now()
+	var timings phaseTimings
 
-	log.Printf(" [>] Processing video_id=%s source=%s", job.VideoID, job.S3Path)
+	logf(ctx, " [>] Processing video_id=%s source=%s", job.VideoID, job.S3Path)
+
+	// Jobs are delivered at least once; a finished video needs no second run
+	if existing, err := gorm.G[models.Video](gormDB).Where("id = ?", job.VideoID).First(ctx); err == nil && existing.Status == models.StatusCompleted {
+		logf(ctx, " [i] Skipping video_id=%s, already completed", job.VideoID)
+		return nil
+	}
 
 	video := &models.Video{
-		ID:     job.VideoID,
-		S3Path: job.S3Path, // GCS source path
+		ID:            job.VideoID,
+		S3Path:        job.S3Path, // GCS source path
+		OutputVersion: job.OutputVersion,
+	}
+
+	// Output goes to the tenant's bucket when the job names an allowed one
+	var err error
+	outputBucket := gcsBucket
+	if job.OutputBucket != "" {
+		if outputBucket, err = server_utils.OutputBucket(job.OutputBucket); err != nil {
+			failVideo(ctx, gormDB, job.VideoID, err.Error(), err)
+			return err
+		}
 	}
 
 	// Update status to processing
-	_, err := gorm.G[models.Video](gormDB).Where("id = ?", job.VideoID).Updates(ctx, models.Video{
+	_, err = gorm.G[models.Video](gormDB).Where("id = ?", job.VideoID).Updates(ctx, models.Video{
 		Status:       models.StatusProcessing,
+		OutputBucket: outputBucket,
 		ErrorMessage: nil,
 	})
 	if err != nil {
-		log.Printf(" [!] Failed to update status: %v", err)
+		logf(ctx, " [!] Failed to update status: %v", err)
 	}
 
 	// Publish initial progress snapshot
-	pubsub.PublishProgress(models.ProcessingProgress{
+	progressSink.Publish(models.ProcessingProgress{
 		VideoID:   job.VideoID,
 		Status:    models.StatusStarted,
 		Timestamp: time.Now(),
 	})
 
-	// Get video metadata using ffprobe
-	metadata, err := getVideoMetadata(ctx, job.S3Path)
+	// Resolve a readable URL for the source
+	sourceURL, err := resolveSourceURL(gcsClient, job)
 	if err != nil {
-		errorMessage := err.Error()
-		gorm.G[models.Video](gormDB).Where("id = ?", job.VideoID).Updates(ctx, models.Video{
-			Status:       models.StatusFailed,
-			ErrorMessage: &errorMessage,
-		})
+		errorMsg := fmt.Sprintf("Failed to resolve video source: %v", err)
+		failVideo(ctx, gormDB, job.VideoID, errorMsg, err)
+		return fmt.Errorf("failed to resolve video source: %w", err)
+	}
+	if err := checkSourceURL(ctx, job, sourceURL); err != nil {
+		failVideo(ctx, gormDB, job.VideoID, err.Error(), err)
+		return err
+	}
+
+	// Frame archives are assembled into a local video first, and other
+	// remote sources are downloaded through the SSRF-guarded client
+	if _, _, ok := sourceObject(job); !ok && job.ImageSequence == nil {
+		defer os.RemoveAll(remoteSourceDir(job))
+		sourceURL, err = downloadRemoteSource(ctx, job, sourceURL)
+		if err != nil {
+			failVideo(ctx, gormDB, job.VideoID, err.Error(), err)
+			return err
+		}
+	}
+	if job.ImageSequence != nil {
+		defer os.RemoveAll(imageSequenceDir(job))
+		sourceURL, err = prepareImageSequence(ctx, gcsClient, job, sourceURL)
+		if err != nil {
+			failVideo(ctx, gormDB, job.VideoID, err.Error(), err)
+			return err
+		}
+	}
 
+	// Get video metadata using ffprobe
+	probeStarted := time.Now()
+	metadata, err := probeSource(ctx, gcsClient, job, sourceURL)
+	timings.Probe = time.Since(probeStarted)
+	if err != nil {
 		errorMsg := fmt.Sprintf("Failed to read video metadata: %v", err)
-		pubsub.PublishProgress(models.ProcessingProgress{
-			VideoID:   job.VideoID,
-			Status:    models.StatusFailed,
-			Error:     errorMsg,
-			Timestamp: time.Now(),
-		})
+		failVideo(ctx, gormDB, job.VideoID, errorMsg, err)
 		return fmt.Errorf("failed to get video metadata: %w", err)
 	}
-	log.Printf(" [i] Source video: %dx%d, duration: %.2fs", metadata.Width, metadata.Height, metadata.Duration)
+	logf(ctx, " [i] Source video: %dx%d, duration: %.2fs", metadata.Width, metadata.Height, metadata.Duration)
+	cfrRate := planCFR(normalizeFPS, metadata)
+	if cfrRate > 0 {
+		logf(ctx, " [i] Variable frame rate source, normalizing to %.3f fps (%d frames)", cfrRate, metadata.Frames)
+	} else if metadata.VariableFrameRate {
+		logf(ctx, " [i] Variable frame rate source, averaging %.3f fps", metadata.FrameRate)
+	}
+	if job.StartSeconds > 0 || job.EndSeconds > 0 {
+		applyTrim(metadata, job.StartSeconds, job.EndSeconds)
+		logf(ctx, " [i] Trimming to %.2fs-%.2fs (%.2fs)", job.StartSeconds, job.EndSeconds, metadata.Duration)
+		if err := checkPlayable(metadata); err != nil {
+			err = fmt.Errorf("trim range is outside the video: %w", err)
+			failVideo(ctx, gormDB, job.VideoID, err.Error(), err)
+			return err
+		}
+	}
+
+	// Even out audio and video that end at different times
+	align := planAVAlignment(avAlignMode, metadata.VideoDuration, metadata.AudioDuration, job.StartSeconds, job.EndSeconds, avAlignTolerance)
+	if align != (avAlignment{}) {
+		logf(ctx, " [i] Audio %.2fs and video %.2fs differ in length, aligning with AV_ALIGN=%s", metadata.AudioDuration, metadata.VideoDuration, avAlignMode)
+		align.apply(metadata, job.StartSeconds)
+	}
 
 	video = &models.Video{
-		ID:           video.ID,
-		S3Path:       video.S3Path,
-		Frames:       metadata.Frames,
-		SourceWidth:  metadata.Width,
-		SourceHeight: metadata.Height,
-		Duration:     metadata.Duration,
+		ID:              video.ID,
+		OriginalName:    job.OriginalName,
+		S3Path:          video.S3Path,
+		Frames:          metadata.Frames,
+		FramesEstimated: metadata.FramesEstimated,
+		AudioChannels:   metadata.AudioChannels,
+		VideoStream:     metadata.StreamIndex,
+		Metadata:        metadata.Tags,
+		ProbeJSON:       probeJSON(metadata.Probe),
+		OutputBucket:    outputBucket,
+		StartSeconds:    job.StartSeconds,
+		EndSeconds:      job.EndSeconds,
+		SourceWidth:     metadata.Width,
+		SourceHeight:    metadata.Height,
+		Duration:        metadata.Duration,
+		OutputVersion:   job.OutputVersion,
 	}
 	// Update video metadata in database
 	_, err = gorm.G[models.Video](gormDB).Where("id = ?", job.VideoID).Updates(ctx, *video)
 	if err != nil {
-		log.Printf(" [!] Failed to update metadata: %v", err)
+		logf(ctx, " [!] Failed to update metadata: %v", err)
 	}
 
 	// Determine which renditions to generate
-	renditions := filterRenditions(metadata.Height)
-	log.Printf(" [i] Generating %d renditions: %v", len(renditions), getRenditionHeights(renditions))
+	ladder := renditions
+	if job.Profile != "" && len(job.Renditions) == 0 {
+		profile, err := server_utils.RenditionProfile(job.Profile)
+		if err != nil {
+			failVideo(ctx, gormDB, job.VideoID, err.Error(), err)
+			return err
+		}
+		ladder = profile
+		logf(ctx, " [i] Using rendition profile %q (%d entries)", job.Profile, len(ladder))
+	}
+	if len(job.Renditions) > 0 {
+		ladder = job.Renditions
+		logf(ctx, " [i] Using job-provided rendition ladder (%d entries)", len(ladder))
+	}
+	// Jobs are validated by the API, but may predate the allowlist
+	for _, r := range ladder {
+		if err := r.ValidateExtraArgs(); err != nil {
+			failVideo(ctx, gormDB, job.VideoID, err.Error(), err)
+			return err
+		}
+	}
+	renditions := filterRenditions(ladder, metadata.Height)
+	if smartLadder {
+		if metadata.Bitrate > 0 {
+			renditions = capRenditionBitrates(renditions, metadata.Bitrate, metadata.Height, smartLadderPercent, smartLadderMinBitrate)
+			logf(ctx, " [i] Smart ladder: source video at %d kbps, bitrates %v", metadata.Bitrate/1000, getRenditionBitrates(renditions))
+		} else {
+			logf(ctx, " [i] Smart ladder: source bitrate unknown, keeping the ladder's bitrates")
+		}
+	}
+	logf(ctx, " [i] Generating %d renditions: %v", len(renditions), getRenditionHeights(renditions))
 
-	pubsub.PublishProgress(models.ProcessingProgress{
+	progressSink.Publish(models.ProcessingProgress{
 		VideoID:   job.VideoID,
 		Status:    models.StatusProcessing,
 		Timestamp: time.Now(),
 	})
 
+	// Heavy jobs get a GPU encoder slot when available
+	class := classifyJob(metadata)
+	encoder, releaseEncoder, err := acquireEncoder(ctx, class)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to transcode video: %v", err)
+		failVideo(ctx, gormDB, job.VideoID, errMsg, err)
+		return fmt.Errorf("%s", errMsg)
+	}
+	logf(ctx, " [i] Job class: %s, video encoder: %s", class, encoder)
+
+	gorm.G[models.Video](gormDB).Where("id = ?", job.VideoID).Updates(ctx, models.Video{JobClass: string(class)})
+
 	// Transcode all renditions in a single FFmpeg command
-	err = transcodeToHLSBatch(ctx, gcsClient, gormDB, *video, renditions)
+	deinterlace := shouldDeinterlace(deinterlaceMode, metadata.Interlaced)
+	if deinterlace {
+		logf(ctx, " [i] Deinterlacing with %s (interlaced source: %v)", deinterlaceFilterName, metadata.Interlaced)
+	}
+
+	err = transcodeToHLSBatch(ctx, gcsClient, gormDB, *video, sourceURL, renditions, encoder, deinterlace, cfrRate, align, false, &timings)
+	if err != nil && ffmpegFallback && shouldFallback(err) {
+		logf(ctx, " [!] FFmpeg failed with a recoverable error, retrying with fallback settings: %v", err)
+		gorm.G[models.Video](gormDB).Where("id = ?", job.VideoID).Updates(ctx, models.Video{EncodeFallback: true})
+		err = transcodeToHLSBatch(ctx, gcsClient, gormDB, *video, sourceURL, renditions, encoder, deinterlace, cfrRate, align, true, &timings)
+	}
+	releaseEncoder()
+	if err != nil && interrupted(ctx) {
+		logf(ctx, " [i] Transcode of video_id=%s interrupted by shutdown, leaving it for redelivery", job.VideoID)
+		return err
+	}
 	if err != nil {
 		errMsg := fmt.Sprintf("failed to transcode video: %v", err)
-		gorm.G[models.Video](gormDB).Where("id = ?", job.VideoID).Updates(ctx, models.Video{
-			Status:       models.StatusFailed,
-			ErrorMessage: &errMsg,
-		})
-		pubsub.PublishProgress(models.ProcessingProgress{
-			VideoID:   job.VideoID,
-			Status:    models.StatusFailed,
-			Error:     errMsg,
-			Timestamp: time.Now(),
-		})
+		failVideo(ctx, gormDB, job.VideoID, errMsg, err)
+		removePartialOutput(ctx, gcsClient.Bucket(outputBucket), video.OutputPrefix())
 		return fmt.Errorf("%s", errMsg)
 	}
 
-	log.Printf(" [√] Completed HLS transcoding for video_id=%s", job.VideoID)
+	logf(ctx, " [√] Completed HLS transcoding for video_id=%s", job.VideoID)
+
+	// Record where the time went before the manifest is built from the row
+	timings.Total = time.Since(started)
+	logf(ctx, " [i] Timings for %s: %s", job.VideoID, timings)
+	gorm.G[models.Video](gormDB).Where("id = ?", job.VideoID).Updates(ctx, models.Video{
+		ProbeMs:      timings.Probe.Milliseconds(),
+		TranscodeMs:  timings.Transcode.Milliseconds(),
+		UploadMs:     timings.Upload.Milliseconds(),
+		ProcessingMs: timings.Total.Milliseconds(),
+	})
+
+	// Publish a machine-readable summary next to the output
+	if manifest, err := buildManifest(ctx, gormDB, gcsClient.Bucket(outputBucket), job.VideoID); err != nil {
+		logf(ctx, " [!] Failed to build manifest: %v", err)
+	} else if key, err := uploadManifest(ctx, gcsClient.Bucket(outputBucket), video.OutputPrefix(), manifest); err != nil {
+		logf(ctx, " [!] Failed to upload manifest: %v", err)
+	} else {
+		logf(ctx, " [√] Manifest uploaded: %s", key)
+	}
+
+	// Optionally drop the original once the output is known to be good
+	sourceDeleted := false
+	if deleteSourceOnComplete {
+		if err := verifyOutput(ctx, gcsClient.Bucket(outputBucket), video.OutputPrefix(), len(renditions)); err != nil {
+			logf(ctx, " [!] Keeping source for %s: %v", job.VideoID, err)
+		} else if refs, err := otherSourceReferences(ctx, gormDB, job); err != nil || refs > 0 {
+			logf(ctx, " [i] Keeping shared source for %s (other references: %d, err: %v)", job.VideoID, refs, err)
+		} else if sourceDeleted, err = deleteSource(ctx, gcsClient, job); err != nil {
+			logf(ctx, " [!] %v", err)
+		} else if sourceDeleted {
+			logf(ctx, " [i] Deleted source for video_id=%s", job.VideoID)
+			if err := markSourceDeleted(ctx, gormDB, job); err != nil {
+				logf(ctx, " [!] Failed to flag videos sharing the source: %v", err)
+			}
+		}
+	}
 
 	// Mark as completed
 	_, err = gorm.G[models.Video](gormDB).Where("id = ?", job.VideoID).Updates(ctx, models.Video{
-		Status:      models.StatusCompleted,
-		CompletedAt: ptr(time.Now()),
+		Status:        models.StatusCompleted,
+		CompletedAt:   ptr(time.Now()),
+		SourceDeleted: sourceDeleted,
 	})
 	if err != nil {
-		log.Printf(" [!] Failed to mark video as completed: %v", err)
+		logf(ctx, " [!] Failed to mark video as completed: %v", err)
 	}
 
-	pubsub.PublishProgress(models.ProcessingProgress{
+	progressSink.Publish(models.ProcessingProgress{
 		VideoID:   job.VideoID,
 		Status:    models.StatusCompleted,
 		Timestamp: time.Now(),
 	})
+	publishVideoEvent(ctx, gormDB, job.VideoID, models.StatusCompleted)
 
-	log.Printf(" [√] All renditions completed for video_id=%s", job.VideoID)
+	logf(ctx, " [√] All renditions completed for video_id=%s", job.VideoID)
 	return nil
 }
 
 type VideoMetadata struct {
-	Width    int
-	Height   int
-	Duration float64
-	Bitrate  int
-	Frames   int64
+	Width     int
+	Height    int
+	Duration  float64
+	Bitrate   int
+	Frames    int64
+	FrameRate float64
+	// FramesEstimated is set when Frames was derived from duration * fps
+	// because the container didn't report nb_frames.
+	FramesEstimated bool
+	// AudioChannels of the first audio stream; zero when unknown
+	AudioChannels int
+	// VideoDuration and AudioDuration are the main video stream's and the
+	// first audio stream's own lengths; zero when the container doesn't
+	// report them
+	VideoDuration float64
+	AudioDuration float64
+	// Interlaced is set from ffprobe's field_order
+	Interlaced bool
+	// VariableFrameRate is set when r_frame_rate and avg_frame_rate differ
+	VariableFrameRate bool
+	// Tags are the descriptive source tags; nil when there are none
+	Tags *models.SourceMetadata
+	// StreamIndex is the main stream's position among the video streams,
+	// as used in FFmpeg's 0:v:N specifier
+	StreamIndex int
+	// Probe is the raw ffprobe JSON, kept for GET /videos/{id}/probe
+	Probe json.RawMessage
 }
 
 // getVideoMetadata uses ffprobe to extract video metadata
 func getVideoMetadata(ctx context.Context, sourceURL string) (*VideoMetadata, error) {
-	args := []string{
+	args := append(sourceProtocolArgs(sourceURL),
 		"-v", "error",
-		"-select_streams", "v:0",
-		"-show_entries", "stream=width,height,bit_rate,nb_frames:format=duration",
-		"-of", "default=noprint_wrappers=1",
+		"-of", "json",
+		"-show_format",
+		"-show_streams",
 		sourceURL,
-	}
+	)
 
-	cmd := exec.CommandContext(ctx, "ffprobe", args...)
-	output, err := cmd.Output()
+	output, err := runFFprobe(ctx, args)
 	if err != nil {
 		return nil, fmt.Errorf("ffprobe error: %w", err)
 	}
 
-	metadata := &VideoMetadata{}
-	lines := strings.SplitSeq(string(output), "\n")
-
-	for line := range lines {
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
-
-		key := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
-
-		switch key {
-		case "width":
-			fmt.Sscanf(value, "%d", &metadata.Width)
-		case "height":
-			fmt.Sscanf(value, "%d", &metadata.Height)
-		case "duration":
-			fmt.Sscanf(value, "%f", &metadata.Duration)
-		case "bit_rate":
-			fmt.Sscanf(value, "%d", &metadata.Bitrate)
-		case "nb_frames":
-			fmt.Sscanf(value, "%d", &metadata.Frames)
-		}
+	streams, duration, audio, err := parseProbe(output)
+	if err != nil {
+		return nil, err
+	}
+	index := pickMainStream(streams)
+	if index < 0 {
+		return nil, fmt.Errorf("failed to parse video dimensions")
+	}
+	metadata := &streams[index].VideoMetadata
+	metadata.StreamIndex = index
+	metadata.Duration = duration
+	metadata.Probe = redactProbe(output)
+	if len(streams) > 1 {
+		logf(ctx, " [i] Source has %d video streams, using v:%d (%dx%d)", len(streams), index, metadata.Width, metadata.Height)
 	}
 
 	if metadata.Height == 0 || metadata.Width == 0 {
 		return nil, fmt.Errorf("failed to parse video dimensions")
 	}
 
+	metadata.AudioChannels = audio.Channels
+	metadata.AudioDuration = audio.Duration
+	metadata.Tags = parseSourceTags(output)
+
+	// Fragmented MP4 and WebM often report nb_frames=N/A
+	if metadata.Frames <= 0 && metadata.Duration > 0 && metadata.FrameRate > 0 {
+		metadata.Frames = int64(math.Round(metadata.Duration * metadata.FrameRate))
+		metadata.FramesEstimated = true
+		logf(ctx, " [i] nb_frames unavailable, estimated %d frames from %.3f fps", metadata.Frames, metadata.FrameRate)
+	}
+
+	if err := checkPlayable(metadata); err != nil {
+		return nil, err
+	}
+
 	return metadata, nil
 }
 
+// checkPlayable rejects sources with nothing to segment: no duration, or a
+// single frame (a still image or a truncated upload). An unknown frame count
+// is allowed as long as there is a duration.
+func checkPlayable(metadata *VideoMetadata) error {
+	if metadata.Duration <= 0 {
+		return fmt.Errorf("invalid or zero-length video: duration %.3fs", metadata.Duration)
+	}
+	if metadata.Frames == 1 {
+		return fmt.Errorf("invalid or zero-length video: single frame")
+	}
+	return nil
+}
+
+// parseFrameRate converts an ffprobe rational such as "30000/1001" to fps.
+// Unknown rates ("0/0", "N/A") yield zero.
+func parseFrameRate(value string) float64 {
+	num, den, found := strings.Cut(value, "/")
+	if !found {
+		fps, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0
+		}
+		return fps
+	}
+
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+var (
+	// Bounds on rendition heights after the source-height filter; zero means
+	// no bound
+	minRenditionHeight = server_utils.GetEnvInt("MIN_RENDITION_HEIGHT", 0)
+	maxRenditionHeight = server_utils.GetEnvInt("MAX_RENDITION_HEIGHT", 0)
+)
+
 var renditions = []Rendition{
 	{Height: 2160, Bitrate: 16000, MaxRate: 17600, BufSize: 24000, AudioRate: 256}, // 4K UHD
 	{Height: 1440, Bitrate: 9000, MaxRate: 9900, BufSize: 13500, AudioRate: 256},   // 2K QHD
@@ -274,11 +597,20 @@ var renditions = []Rendition{
 	{Height: 144, Bitrate: 300, MaxRate: 321, BufSize: 450, AudioRate: 96},         // Ultra Low
 }
 
-// filterRenditions selects renditions that don't exceed the source height
-func filterRenditions(sourceHeight int) []Rendition {
+// renditionWidth is the width FFmpeg's scale=-2:height gives a source of the
+// given size: the aspect-correct width rounded to the nearest even number.
+func renditionWidth(sourceWidth, sourceHeight, height int) int {
+	if sourceWidth <= 0 || sourceHeight <= 0 {
+		return 0
+	}
+	return int(math.Round(float64(height)*float64(sourceWidth)/float64(2*sourceHeight))) * 2
+}
+
+// filterRenditions selects renditions from the ladder that don't exceed the source height
+func filterRenditions(ladder []Rendition, sourceHeight int) []Rendition {
 	var selected []Rendition
 
-	for _, r := range renditions {
+	for _, r := range ladder {
 		if r.Height <= sourceHeight {
 			selected = append(selected, r)
 		}
@@ -286,18 +618,48 @@ func filterRenditions(sourceHeight int) []Rendition {
 
 	// If source is smaller than smallest preset, create a custom rendition
 	if len(selected) == 0 {
-		selected = []Rendition{
-			{
-				Height:    sourceHeight,
-				Bitrate:   sourceHeight * 2,
-				MaxRate:   sourceHeight*2 + 200,
-				BufSize:   sourceHeight * 3,
-				AudioRate: 96,
-			},
+		selected = []Rendition{tinyRendition(ladder, sourceHeight, tinySourceBitrate, tinySourceMinBitrate)}
+	}
+
+	return boundRenditions(selected, minRenditionHeight, maxRenditionHeight)
+}
+
+// boundRenditions drops renditions outside [min, max] (zero disables a bound).
+// If none are left, the one closest to the allowed range is kept so a job
+// always produces output; sources are never upscaled to reach min.
+func boundRenditions(selected []Rendition, min, max int) []Rendition {
+	var bounded []Rendition
+	for _, r := range selected {
+		if (min <= 0 || r.Height >= min) && (max <= 0 || r.Height <= max) {
+			bounded = append(bounded, r)
 		}
 	}
+	if len(bounded) > 0 || len(selected) == 0 {
+		return bounded
+	}
 
-	return selected
+	closest := selected[0]
+	distance := func(r Rendition) int {
+		if min > 0 && r.Height < min {
+			return min - r.Height
+		}
+		return r.Height - max
+	}
+	for _, r := range selected[1:] {
+		if distance(r) < distance(closest) {
+			closest = r
+		}
+	}
+	return []Rendition{closest}
+}
+
+// getRenditionBitrates returns the video bitrates in kbps for logging
+func getRenditionBitrates(renditions []Rendition) []int {
+	bitrates := make([]int, len(renditions))
+	for i, r := range renditions {
+		bitrates[i] = r.Bitrate
+	}
+	return bitrates
 }
 
 // getRenditionHeights returns a slice of heights for logging purposes
@@ -310,7 +672,7 @@ func getRenditionHeights(renditions []Rendition) []int {
 }
 
 // transcodeToHLSBatch transcodes all renditions in a single FFmpeg command
-func transcodeToHLSBatch(ctx context.Context, gcsClient *storage.Client, gormDB *gorm.DB, video models.Video, renditions []Rendition) error {
+func transcodeToHLSBatch(ctx context.Context, gcsClient *storage.Client, gormDB *gorm.DB, video models.Video, sourceURL string, renditions []Rendition, videoEncoder string, deinterlace bool, cfrRate float64, align avAlignment, fallback bool, timings *phaseTimings) error {
 	// Create temporary directory for HLS output
 	tempDir := fmt.Sprintf("/tmp/%s", video.ID)
 	if err := os.MkdirAll(tempDir, 0755); err != nil {
@@ -318,10 +680,17 @@ func transcodeToHLSBatch(ctx context.Context, gcsClient *storage.Client, gormDB
 	}
 	defer os.RemoveAll(tempDir) // Clean up after upload
 
-	bucket := gcsClient.Bucket(gcsBucket)
+	bucket := gcsClient.Bucket(video.OutputBucket)
 
 	splitCount := len(renditions)
 
+	// The progressive MP4 takes one extra branch of the split
+	progressive, withProgressive := pickProgressiveRendition(renditions, progressiveMP4Height)
+	filterBranches := splitCount
+	if withProgressive {
+		filterBranches++
+	}
+
 	// -------- BUILD FILTER COMPLEX --------
 	var filterParts []string
 
@@ -330,48 +699,80 @@ func transcodeToHLSBatch(ctx context.Context, gcsClient *storage.Client, gormDB
 	for i := 0; i < splitCount; i++ {
 		splitOutputs[i] = fmt.Sprintf("[v%d]", i+1)
 	}
-	filterParts = append(filterParts, fmt.Sprintf("[0:v]split=%d%s", splitCount, strings.Join(splitOutputs, "")))
+	if withProgressive {
+		splitOutputs = append(splitOutputs, "[vp]")
+	}
+	// Deinterlacing and frame rate normalization run once on the input, ahead
+	// of the split
+	head := fmt.Sprintf("[0:v:%d]", video.VideoStream)
+	if deinterlace {
+		head += deinterlaceFilter(deinterlaceFilterName) + ","
+	}
+	if filter := cfrFilter(cfrRate); filter != "" {
+		head += filter + ","
+	}
+	if filter := align.videoFilter(); filter != "" {
+		head += filter + ","
+	}
+	filterParts = append(filterParts, fmt.Sprintf("%ssplit=%d%s", head, filterBranches, strings.Join(splitOutputs, "")))
 
 	// Scale each stream to target resolution
 	for i, r := range renditions {
 		filterParts = append(filterParts, fmt.Sprintf("[v%d]scale=-2:%d[v%dout]", i+1, r.Height, i+1))
 	}
+	if withProgressive {
+		filterParts = append(filterParts, fmt.Sprintf("[vp]scale=-2:%d[vpout]", progressive.Height))
+	}
 
 	filterComplex := strings.Join(filterParts, ";")
 
 	// -------- BUILD FFMPEG ARGS --------
-	args := []string{
-		"-y",
-		"-v", "error",
-		"-fflags", "+discardcorrupt",
-		"-i", video.S3Path,
+	args := []string{"-y"}
+	args = append(args, ffmpegGlobalArgs...)
+	args = append(args, threadArgs(false)...)
+	args = append(args,
+		"-v", ffmpegLogLevel,
+		"-nostats",
+	)
+	args = append(args, fallbackInputArgs(fallback)...)
+	args = append(args, sourceProtocolArgs(sourceURL)...)
+	end := video.EndSeconds
+	if align.EndAt > 0 {
+		end = align.EndAt
+	}
+	args = append(args, trimArgs(video.StartSeconds, end)...)
+	args = append(args,
+		"-i", sourceURL,
 		"-progress", "pipe:1",
 		"-filter_complex", filterComplex,
+	)
+
+	channels := outputChannels(audioChannelsMode, video.AudioChannels)
+
+	segSeconds := segmentSeconds(hlsSegmentTargetKB, renditions)
+	if hlsSegmentTargetKB > 0 {
+		logf(ctx, " [i] %ds segments for a %d KB target", segSeconds, hlsSegmentTargetKB)
 	}
 
 	// Add video maps for each rendition
 	for i, r := range renditions {
+		args = append(args, "-map", fmt.Sprintf("[v%dout]", i+1))
+		args = append(args, videoEncoderArgs(videoEncoder, i, fallback)...)
 		args = append(args,
-			"-map", fmt.Sprintf("[v%dout]", i+1),
-			fmt.Sprintf("-c:v:%d", i), "libx264",
 			fmt.Sprintf("-b:v:%d", i), fmt.Sprintf("%dk", r.Bitrate),
 			fmt.Sprintf("-maxrate:v:%d", i), fmt.Sprintf("%dk", r.MaxRate),
 			fmt.Sprintf("-bufsize:v:%d", i), fmt.Sprintf("%dk", r.BufSize),
-			"-preset", "ultrafast",
-			"-g", "48",
-			"-keyint_min", "48",
-			"-sc_threshold", "0",
 		)
+		args = append(args, gopArgs(i, segSeconds)...)
+		args = append(args, renditionExtraArgs(r, i)...)
 	}
 
 	// Add audio maps for each rendition
 	for i, r := range renditions {
-		args = append(args,
-			"-map", "a:0",
-			fmt.Sprintf("-c:a:%d", i), "aac",
-			fmt.Sprintf("-b:a:%d", i), fmt.Sprintf("%dk", r.AudioRate),
-			"-ac", "2",
-		)
+		args = append(args, "-map", "a:0")
+		args = append(args, audioCodecArgs(audioCodec, i, audioBitrate(r))...)
+		args = append(args, audioChannelArgs(audioCodec, i, channels)...)
+		args = append(args, align.audioArgs(fmt.Sprintf("a:%d", i))...)
 	}
 
 	// Build var_stream_map
@@ -382,85 +783,170 @@ func transcodeToHLSBatch(ctx context.Context, gcsClient *storage.Client, gormDB
 	varStreamMap := strings.Join(varStreamParts, " ")
 
 	// Add HLS output options
+	args = append(args, threadArgs(true)...)
 	args = append(args,
 		"-f", "hls",
-		"-hls_time", "6",
+		"-hls_time", strconv.Itoa(segSeconds),
 		"-hls_playlist_type", "vod",
-		"-hls_flags", "independent_segments",
-		"-hls_segment_type", "mpegts",
-		"-hls_segment_filename", fmt.Sprintf("%s/stream_%%v/segment_%%03d.ts", tempDir),
-		"-master_pl_name", "master.m3u8",
+		"-hls_flags", hlsFlags(hlsSingleFile),
+		"-hls_segment_type", hlsSegmentType,
+	)
+	args = append(args, llHLSArgs()...)
+	args = append(args,
+		"-hls_segment_filename", fmt.Sprintf("%s/%s/%s", tempDir, hlsVariantDir, hlsSegmentFilename(hlsSingleFile)),
+		"-master_pl_name", masterPlaylistName,
 		"-var_stream_map", varStreamMap,
-		fmt.Sprintf("%s/stream_%%v/playlist.m3u8", tempDir),
+		fmt.Sprintf("%s/%s/playlist.m3u8", tempDir, hlsVariantDir),
 	)
 
+	// Progressive MP4 is a separate output of the same run
+	progressivePath := fmt.Sprintf("%s/%s", tempDir, progressiveMP4Name(progressive.Height))
+	if withProgressive {
+		args = append(args, progressiveMP4Args(progressive, "[vpout]", progressivePath, videoEncoder, fallback, channels, align.audioArgs("a"))...)
+	}
+
+	// Wait for a slot before opening the pipes, which only Start or Wait
+	// would close again
+	releaseFFmpeg, err := acquireFFmpegSlot(ctx)
+	if err != nil {
+		return err
+	}
+
 	// Execute FFmpeg
-	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
-	log.Printf(" [>] Running FFmpeg batch transcoding for %d renditions", splitCount)
+	cmd := ffmpegCommand(ctx, args)
+	logf(ctx, " [>] Running FFmpeg batch transcoding for %d renditions", splitCount)
 
-	// Capture stderr for progress monitoring
+	// Capture stderr for progress monitoring and error reporting
 	ffmpegStderr, err := cmd.StderrPipe()
 	if err != nil {
+		releaseFFmpeg()
 		return fmt.Errorf("stderr pipe error: %w", err)
 	}
 
-	// Monitor FFmpeg progress in background
-	go monitorFFmpegProgressBatch(ffmpegStderr)
-
 	ffmpegStdout, err := cmd.StdoutPipe()
 	if err != nil {
+		releaseFFmpeg()
 		return fmt.Errorf("stdout pipe error: %w", err)
 	}
 
-	// Publish progress from stdout
+	ffmpegStarted := time.Now()
+	if err := cmd.Start(); err != nil {
+		releaseFFmpeg()
+		return fmt.Errorf("ffmpeg start error: %w", err)
+	}
+
+	tail := newStderrTail(ffmpegStderrTailLines)
+
+	// In debug mode the whole stderr is also kept for upload
+	var stderrSource io.Reader = ffmpegStderr
+	logPath := fmt.Sprintf("%s/%s", tempDir, ffmpegLogName)
+	if ffmpegDebugLog {
+		if logFile, err := os.Create(logPath); err != nil {
+			logf(ctx, " [!] Failed to create FFmpeg log: %v", err)
+		} else {
+			defer logFile.Close()
+			stderrSource = io.TeeReader(ffmpegStderr, logFile)
+		}
+	}
+
+	// Both pipes must be drained before Wait closes them
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		captureStderr(ctx, stderrSource, tail)
+	}()
 	go func() {
-		publishProgress(video, ffmpegStdout)
+		defer wg.Done()
+		publishProgress(video, len(renditions), ffmpegStdout)
 	}()
+	wg.Wait()
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("ffmpeg execution error: %w", err)
+	waitErr := cmd.Wait()
+	releaseFFmpeg()
+
+	// Upload the debug log even when FFmpeg failed; that's when it matters
+	if ffmpegDebugLog {
+		if key, err := uploadFFmpegLog(ctx, bucket, video, logPath); err != nil {
+			logf(ctx, " [!] %v", err)
+		} else {
+			logf(ctx, " [i] FFmpeg log uploaded: %s", key)
+		}
 	}
 
-	log.Printf(" [√] FFmpeg transcoding completed for video_id=%s", video.ID)
+	if err := waitErr; err != nil {
+		return fmt.Errorf("ffmpeg execution error: %w", &ffmpegError{err: err, stderr: tail.String()})
+	}
+
+	logf(ctx, " [√] FFmpeg transcoding completed for video_id=%s", video.ID)
+	timings.Transcode = time.Since(ffmpegStarted)
+
+	// Trick-play playlists are derived from the finished segments
+	writeIFramePlaylists(ctx, tempDir, renditions, video.SourceWidth, video.SourceHeight)
+
+	uploadStarted := time.Now()
+	defer func() { timings.Upload = time.Since(uploadStarted) }()
 
 	// -------- UPLOAD MASTER PLAYLIST FIRST --------
-	masterPlaylistPath := fmt.Sprintf("%s/master.m3u8", tempDir)
-	masterPlaylistKey := fmt.Sprintf("%s/processed/master.m3u8", video.ID)
-	masterFile, err := os.Open(masterPlaylistPath)
+	masterPlaylistPath := fmt.Sprintf("%s/%s", tempDir, masterPlaylistName)
+	outputPrefix := video.OutputPrefix()
+	masterPlaylistKey := outputPrefix + masterPlaylistName
+
+	// FFmpeg can leave a variant out, e.g. when its audio map fails. Those
+	// are dropped from the master and skipped below rather than failing the
+	// whole job.
+	produced, err := producedVariants(ctx, tempDir, len(renditions))
 	if err != nil {
-		return fmt.Errorf("failed to open master playlist: %w", err)
+		return err
 	}
-
-	masterObj := bucket.Object(masterPlaylistKey)
-	masterWriter := masterObj.NewWriter(ctx)
-	masterWriter.ContentType = "application/vnd.apple.mpegurl"
-
-	if _, err := io.Copy(masterWriter, masterFile); err != nil {
-		masterFile.Close()
-		masterWriter.Close()
-		return fmt.Errorf("failed to upload master playlist: %w", err)
+	if len(produced) == 0 {
+		return fmt.Errorf("ffmpeg produced no variant directories in %s", tempDir)
+	}
+	var missing []string
+	for i, r := range renditions {
+		if !produced[i] {
+			logf(ctx, " [!] FFmpeg produced no %s for %dp, skipping it", variantDirName(i), r.Height)
+			missing = append(missing, variantDirName(i))
+		}
+	}
+	if err := dropVariants(masterPlaylistPath, missing); err != nil {
+		return err
 	}
-	masterFile.Close()
 
-	if err := masterWriter.Close(); err != nil {
-		return fmt.Errorf("failed to close master playlist writer: %w", err)
+	if err := reorderVariants(masterPlaylistPath, renditions); err != nil {
+		return err
+	}
+	if err := absolutizePlaylist(masterPlaylistPath, video.OutputBucket, strings.TrimSuffix(outputPrefix, "/")); err != nil {
+		return err
+	}
+	masterUploaded, err := uploadMasterPlaylist(ctx, bucket, video, masterPlaylistPath, masterPlaylistKey)
+	if err != nil {
+		return err
 	}
 
 	// Construct permanent GCS URL for master playlist
-	masterURL := buildPublicURL(masterPlaylistKey)
+	masterURL := buildPublicURL(video.OutputBucket, masterPlaylistKey)
+
+	if masterUploaded {
+		logf(ctx, " [√] Master playlist uploaded: %s", masterPlaylistKey)
+	} else {
+		logf(ctx, " [i] Master playlist already uploaded: %s", masterPlaylistKey)
+	}
 
-	// Update video record with master playlist info
-	gorm.G[models.Video](gormDB).Where("id = ?", video.ID).Updates(ctx, models.Video{
+	// Recorded together with the renditions once everything is uploaded
+	outputFields := models.Video{
 		MasterPlaylistKey: ptr(masterPlaylistKey),
 		MasterPlaylistURL: ptr(masterURL),
-	})
-
-	log.Printf(" [√] Master playlist uploaded: %s", masterPlaylistKey)
+	}
+	var resolutions []models.VideoResolution
 
 	// -------- UPLOAD RENDITIONS TO GCS --------
 	for i, r := range renditions {
-		streamDir := fmt.Sprintf("%s/stream_%d", tempDir, i)
-		streamName := fmt.Sprintf("stream_%d", i) // Keep same structure as temp dir
+		if !produced[i] {
+			continue
+		}
+		streamName := variantDirName(i) // Keep same structure as temp dir
+		streamDir := fmt.Sprintf("%s/%s", tempDir, streamName)
 		resolutionName := fmt.Sprintf("%dp", r.Height)
 
 		// Count segments and calculate total size
@@ -470,7 +956,10 @@ func transcodeToHLSBatch(ctx context.Context, gcsClient *storage.Client, gormDB
 		}
 
 		var totalSize int64
-		segmentCount := 0
+		mediaFiles := 0
+		skipped := 0
+		var playlistChecksum string
+		segmentMD5s := make(map[string][]byte)
 
 		// Upload segment files
 		for _, file := range segmentFiles {
@@ -479,22 +968,51 @@ func transcodeToHLSBatch(ctx context.Context, gcsClient *storage.Client, gormDB
 			}
 
 			filePath := fmt.Sprintf("%s/%s", streamDir, file.Name())
-			gcsKey := fmt.Sprintf("%s/processed/%s/%s", video.ID, streamName, file.Name())
+			gcsKey := fmt.Sprintf("%s%s/%s", outputPrefix, streamName, file.Name())
+
+			contentType := contentTypeFor(file.Name())
+			isSegment := isMediaSegment(file.Name())
+			if isSegment {
+				mediaFiles++
+			}
+
+			// Segments are immutable, so a same-size object left by an earlier
+			// delivery of this job can be kept. Playlists are always rewritten.
+			if isSegment {
+				info, err := file.Info()
+				if err != nil {
+					return fmt.Errorf("failed to stat file %s: %w", file.Name(), err)
+				}
+				attrs, err := server_utils.StatObject(ctx, bucket, gcsKey)
+				if err != nil {
+					logf(ctx, " [!] %v", err)
+				} else if attrs != nil && attrs.Size == info.Size() {
+					totalSize += attrs.Size
+					segmentMD5s[file.Name()] = attrs.MD5
+					skipped++
+					continue
+				}
+			}
+
+			if file.Name() == "playlist.m3u8" {
+				if err := addPartialSegments(filePath); err != nil {
+					return err
+				}
+				if err := absolutizePlaylist(filePath, video.OutputBucket, outputPrefix+streamName); err != nil {
+					return err
+				}
+			}
 
 			fileHandle, err := os.Open(filePath)
 			if err != nil {
 				return fmt.Errorf("failed to open file %s: %w", file.Name(), err)
 			}
 
-			contentType := "application/vnd.apple.mpegurl"
-			if strings.HasSuffix(file.Name(), ".ts") {
-				contentType = "video/mp2t"
-				segmentCount++
-			}
-
 			obj := bucket.Object(gcsKey)
 			writer := obj.NewWriter(ctx)
+			writer.ChunkSize = fileChunkSize(fileHandle)
 			writer.ContentType = contentType
+			writer.Metadata = objectMetadata(video)
 
 			written, err := io.Copy(writer, fileHandle)
 			fileHandle.Close()
@@ -508,110 +1026,103 @@ func transcodeToHLSBatch(ctx context.Context, gcsClient *storage.Client, gormDB
 				return fmt.Errorf("GCS writer close error for %s: %w", file.Name(), err)
 			}
 
+			// Keep the MD5 GCS computed so corruption can be detected later
+			if isSegment {
+				segmentMD5s[file.Name()] = writer.Attrs().MD5
+			} else if file.Name() == "playlist.m3u8" {
+				playlistChecksum = hex.EncodeToString(writer.Attrs().MD5)
+			}
+
 			totalSize += written
 		}
 
-		log.Printf(" [>] Uploaded %d segments for %s", segmentCount, resolutionName)
+		// In single-file mode the playlist, not the directory, has the segments
+		segmentCount := mediaFiles
+		if hlsSingleFile {
+			segments, err := readMediaPlaylist(fmt.Sprintf("%s/playlist.m3u8", streamDir))
+			if err != nil {
+				return fmt.Errorf("failed to read playlist for %s: %w", resolutionName, err)
+			}
+			segmentCount = len(segments)
+		}
+
+		logf(ctx, " [>] Uploaded %d media files for %s (%d already present, %d segments)", mediaFiles-skipped, resolutionName, skipped, segmentCount)
 
 		// Construct permanent GCS URL for playlist
-		playlistGCSKey := fmt.Sprintf("%s/processed/%s/playlist.m3u8", video.ID, streamName)
-		playlistURL := buildPublicURL(playlistGCSKey)
+		playlistGCSKey := fmt.Sprintf("%s%s/playlist.m3u8", outputPrefix, streamName)
+		playlistURL := buildPublicURL(video.OutputBucket, playlistGCSKey)
 
 		// Calculate bandwidth (convert kbps to bps)
 		bandwidth := r.Bitrate * 1000
 
-		// Save resolution to database
-		resolution := &models.VideoResolution{
-			ID:            uuid.New(),
-			VideoID:       video.ID,
-			Resolution:    resolutionName,
-			PlaylistS3Key: playlistGCSKey, // GCS object key (field name kept for DB compatibility)
-			PlaylistURL:   playlistURL,
-			SegmentCount:  segmentCount,
-			TotalSize:     totalSize,
-			Bandwidth:     bandwidth,
-			ProcessedAt:   time.Now(),
-		}
+		resolutions = append(resolutions, models.VideoResolution{
+			ID:               uuid.New(),
+			VideoID:          video.ID,
+			Resolution:       resolutionName,
+			Width:            renditionWidth(video.SourceWidth, video.SourceHeight, r.Height),
+			Height:           r.Height,
+			PlaylistS3Key:    playlistGCSKey, // GCS object key (field name kept for DB compatibility)
+			PlaylistURL:      playlistURL,
+			SegmentCount:     segmentCount,
+			TotalSize:        totalSize,
+			Bandwidth:        bandwidth,
+			Checksum:         playlistChecksum,
+			SegmentsChecksum: server_utils.RollupChecksum(segmentMD5s),
+			ProcessedAt:      time.Now(),
+		})
 
-		err = gorm.G[models.VideoResolution](gormDB).Create(ctx, resolution)
-		if err != nil {
-			log.Printf(" [!] Failed to save resolution to database: %v", err)
-		}
+		// The batch encodes every rendition together, so each one is reported
+		// as it becomes available in storage
+		progressSink.Publish(models.ProcessingProgress{
+			VideoID:           video.ID,
+			Status:            models.StatusProcessing,
+			ProcessedFrames:   video.Frames,
+			TotalFrames:       video.Frames,
+			CurrentResolution: i + 1,
+			TotalResolutions:  len(renditions),
+			Resolution:        resolutionName,
+			Timestamp:         time.Now(),
+		})
 	}
 
-	return nil
-}
-
-func publishProgress(video models.Video, stdout io.ReadCloser) {
-	defer stdout.Close()
-
-	scanner := bufio.NewScanner(stdout)
-
-	for scanner.Scan() {
-		// frames=1234
-		line := scanner.Text()
-
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
-
-		key := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
-
-		if key != "frame" {
-			continue
-		}
-
-		frames, err := strconv.ParseInt(value, 10, 64)
-
+	// -------- UPLOAD PROGRESSIVE MP4 --------
+	if withProgressive {
+		progressiveKey, err := uploadProgressiveMP4(ctx, bucket, video, progressivePath, progressive.Height)
 		if err != nil {
-			log.Printf("Error parsing frames: %v", err)
-			continue
+			return err
 		}
 
-		pubsub.PublishProgress(models.ProcessingProgress{
-			VideoID:         video.ID,
-			Status:          models.StatusProcessing,
-			ProcessedFrames: frames,
-			TotalFrames:     video.Frames,
-			Timestamp:       time.Now(),
-		})
-	}
-}
+		outputFields.ProgressiveKey = ptr(progressiveKey)
+		outputFields.ProgressiveURL = ptr(buildPublicURL(video.OutputBucket, progressiveKey))
 
-func monitorFFmpegProgressBatch(stderr io.ReadCloser) {
-	defer stderr.Close()
+		logf(ctx, " [√] Progressive MP4 uploaded: %s", progressiveKey)
+	}
 
-	scanner := bufio.NewScanner(stderr)
-	progressRegex := regexp.MustCompile(`time=(\d+:\d+:\d+\.\d+)`)
-	fpsRegex := regexp.MustCompile(`fps=\s*(\d+\.?\d*)`)
+	if err := recordOutput(ctx, gormDB, video.ID, resolutions, outputFields); err != nil {
+		return fmt.Errorf("failed to record output: %w", err)
+	}
 
-	for scanner.Scan() {
-		line := scanner.Text()
+	return nil
+}
 
-		timeMatches := progressRegex.FindStringSubmatch(line)
-		fpsMatches := fpsRegex.FindStringSubmatch(line)
+// phaseTimings records how long each stage of a job took
+type phaseTimings struct {
+	Probe     time.Duration
+	Transcode time.Duration
+	Upload    time.Duration
+	Total     time.Duration
+}
 
-		if len(timeMatches) > 1 {
-			logMsg := fmt.Sprintf("FFmpeg progress: time=%s", timeMatches[1])
-			if len(fpsMatches) > 1 {
-				logMsg += fmt.Sprintf(", fps=%s", fpsMatches[1])
-			}
-			log.Printf(" [>] %s", logMsg)
-		}
-	}
+func (t phaseTimings) String() string {
+	return fmt.Sprintf("probe=%s transcode=%s upload=%s total=%s",
+		t.Probe.Round(time.Millisecond), t.Transcode.Round(time.Millisecond),
+		t.Upload.Round(time.Millisecond), t.Total.Round(time.Millisecond))
 }
 
 func ptr[T any](v T) *T {
 	return &v
 }
 
-func buildPublicURL(key string) string {
-	parts := strings.Split(key, "/")
-	for i, part := range parts {
-		parts[i] = url.PathEscape(part)
-	}
-	escapedKey := strings.Join(parts, "/")
-	return fmt.Sprintf("%s/%s/%s", gcsPublicEndpoint, gcsBucket, escapedKey)
+func buildPublicURL(bucketName, key string) string {
+	return server_utils.PublicObjectURL(bucketName, key)
 }
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"github.com/google/uuid"
+)
+
+// setVar overrides a package setting for the test
+func setVar[T any](t *testing.T, v *T, value T) {
+	t.Helper()
+	previous := *v
+	*v = value
+	t.Cleanup(func() { *v = previous })
+}
+
+func TestParseFrameRate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+	}{
+		{"30/1", 30},
+		{"30000/1001", 30000.0 / 1001},
+		{"25", 25},
+		{"0/0", 0},
+		{"N/A", 0},
+		{"", 0},
+		{"x/1", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := parseFrameRate(tt.value); got != tt.want {
+				t.Errorf("parseFrameRate(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterRenditions(t *testing.T) {
+	tests := []struct {
+		name         string
+		sourceHeight int
+		min, max     int
+		want         []int
+	}{
+		{"unbounded", 720, 0, 0, []int{720, 480, 360, 240, 144}},
+		{"min bound", 1080, 480, 0, []int{1080, 720, 480}},
+		{"max bound", 2160, 0, 1080, []int{1080, 720, 480, 360, 240, 144}},
+		{"both bounds", 2160, 480, 1080, []int{1080, 720, 480}},
+		{"source below min keeps the tallest", 360, 480, 0, []int{360}},
+		{"small source below min", 100, 480, 1080, []int{100}},
+		{"odd tiny source", 51, 0, 0, []int{50}},
+		{"ladder above max keeps the shortest", 720, 0, 100, []int{144}},
+		{"empty range keeps the closest", 1080, 600, 700, []int{720}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &minRenditionHeight, tt.min)
+			setVar(t, &maxRenditionHeight, tt.max)
+			var got []int
+			for _, r := range filterRenditions(renditions, tt.sourceHeight) {
+				got = append(got, r.Height)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("filterRenditions(%d) with [%d, %d] = %v, want %v", tt.sourceHeight, tt.min, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetVideoMetadataFrames(t *testing.T) {
+	tests := []struct {
+		name          string
+		output        string
+		wantFrames    int64
+		wantEstimated bool
+	}{
+		{
+			name:       "exact count",
+			output:     `{"streams": [{"codec_type": "video", "width": 1920, "height": 1080, "nb_frames": "300", "avg_frame_rate": "30/1"}], "format": {"duration": "10.0"}}`,
+			wantFrames: 300,
+		},
+		{
+			name:          "missing count uses the average rate",
+			output:        `{"streams": [{"codec_type": "video", "width": 1920, "height": 1080, "nb_frames": "N/A", "avg_frame_rate": "30000/1001", "r_frame_rate": "60/1"}], "format": {"duration": "10.01"}}`,
+			wantFrames:    300,
+			wantEstimated: true,
+		},
+		{
+			name:          "unknown average rate falls back to r_frame_rate",
+			output:        `{"streams": [{"codec_type": "video", "width": 1280, "height": 720, "avg_frame_rate": "0/0", "r_frame_rate": "25/1"}], "format": {"duration": "4.0"}}`,
+			wantFrames:    100,
+			wantEstimated: true,
+		},
+		{
+			name:   "no rate leaves the count unknown",
+			output: `{"streams": [{"codec_type": "video", "width": 1280, "height": 720, "nb_frames": "N/A", "avg_frame_rate": "0/0", "r_frame_rate": "0/0"}], "format": {"duration": "4.0"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeCommand(t, "ffprobe", "cat <<'PROBE'\n"+tt.output+"\nPROBE")
+
+			metadata, err := getVideoMetadata(context.Background(), "source.webm")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if metadata.Frames != tt.wantFrames || metadata.FramesEstimated != tt.wantEstimated {
+				t.Errorf("frames = %d (estimated %v), want %d (estimated %v)",
+					metadata.Frames, metadata.FramesEstimated, tt.wantFrames, tt.wantEstimated)
+			}
+		})
+	}
+}
+
+func TestCheckPlayable(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration float64
+		frames   int64
+		wantErr  bool
+	}{
+		{"normal clip", 10, 300, false},
+		{"unknown frame count", 10, 0, false},
+		{"zero duration", 0, 300, true},
+		{"negative duration", -1, 0, true},
+		{"single frame", 0.04, 1, true},
+		{"two frames", 0.08, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPlayable(&VideoMetadata{Duration: tt.duration, Frames: tt.frames})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkPlayable(%vs, %d frames) = %v, want error %v", tt.duration, tt.frames, err, tt.wantErr)
+			}
+			if err != nil && classifyFailure(err) != models.FailureUnsupportedInput {
+				t.Errorf("%v classified as %s, want %s", err, classifyFailure(err), models.FailureUnsupportedInput)
+			}
+		})
+	}
+}
+
+func TestZeroLengthSourceFailsCleanly(t *testing.T) {
+	tests := []struct {
+		name  string
+		probe string
+		job   models.VideoJob
+	}{
+		{"zero duration", `{"streams": [{"codec_type": "video", "width": 1280, "height": 720, "nb_frames": "N/A", "avg_frame_rate": "0/0"}], "format": {"duration": "0.0"}}`, models.VideoJob{}},
+		{"still image", `{"streams": [{"codec_type": "video", "width": 1280, "height": 720, "nb_frames": "1", "avg_frame_rate": "25/1"}], "format": {"duration": "0.04"}}`, models.VideoJob{}},
+		{"trim past the end", testProbe, models.VideoJob{StartSeconds: 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ffmpeg, ffprobe, logFile := customTools(t, tt.probe)
+			setVar(t, &ffmpegPath, ffmpeg)
+			setVar(t, &ffprobePath, ffprobe)
+			setVar(t, &gcsBucket, "videos")
+			useRedis(t, nil)
+			gcsClient, _ := testgcs.Start(t)
+			gormDB, db := testdb.Open(t, nil)
+
+			job := tt.job
+			job.VideoID, job.S3Path = uuid.New(), "gs://uploads/source.mp4"
+			err := processVideoStreaming(context.Background(), gcsClient, gormDB, job)
+			if err == nil || !strings.Contains(err.Error(), "zero-length video") {
+				t.Fatalf("processVideoStreaming error = %v, want a zero-length failure", err)
+			}
+
+			updates := db.Matching(`"failure_category"`)
+			if len(updates) != 1 || !slices.Contains(updates[0].Args, any(string(models.FailureUnsupportedInput))) {
+				t.Errorf("failure updates = %v, want one unsupported_input", updates)
+			}
+
+			// Nothing is encoded for a source that can't be segmented
+			calls, _ := os.ReadFile(logFile)
+			if strings.Contains(string(calls), "-filter_complex") {
+				t.Errorf("transcode ran for an unplayable source: %s", calls)
+			}
+		})
+	}
+}
+
+func TestUploadSkipsExistingSegments(t *testing.T) {
+	defer func(b string) { gcsBucket = b }(gcsBucket)
+	gcsBucket = "videos"
+
+	useFakeTools(t, testProbe)
+	useRedis(t, nil)
+	gcsClient, store := testgcs.Start(t)
+	gormDB, _ := testdb.Open(t, nil)
+
+	// Left by an earlier delivery: one segment intact, one truncated, and a
+	// stale playlist
+	job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"}
+	prefix := job.VideoID.String() + "/processed/stream_0/"
+	store.Put("videos", prefix+"segment_000.ts", []byte("variant 0 segment 0\n"))
+	store.Put("videos", prefix+"segment_001.ts", []byte("variant"))
+	store.Put("videos", prefix+"playlist.m3u8", []byte("#EXTM3U\n"))
+
+	if err := processVideoStreaming(context.Background(), gcsClient, gormDB, job); err != nil {
+		t.Fatal(err)
+	}
+
+	written := store.Written()
+	for _, tt := range []struct {
+		name        string
+		wantWritten bool
+	}{
+		{"segment_000.ts", false},
+		{"segment_001.ts", true},
+		{"playlist.m3u8", true},
+	} {
+		if got := slices.Contains(written, "videos/"+prefix+tt.name); got != tt.wantWritten {
+			t.Errorf("%s uploaded = %v, want %v", tt.name, got, tt.wantWritten)
+		}
+	}
+	if obj, _ := store.Get("videos", prefix+"segment_001.ts"); string(obj.Data) != "variant 0 segment 1\n" {
+		t.Errorf("truncated segment not replaced: %q", obj.Data)
+	}
+}
+
+func TestUploadRecordsChecksums(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	useFakeTools(t, testProbe)
+	useRedis(t, nil)
+	gcsClient, store := testgcs.Start(t)
+	gormDB, db := testdb.Open(t, nil)
+
+	job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"}
+	if err := processVideoStreaming(context.Background(), gcsClient, gormDB, job); err != nil {
+		t.Fatal(err)
+	}
+
+	inserts := db.Matching(`INSERT INTO "video_resolutions"`)
+	if len(inserts) == 0 {
+		t.Fatal("no resolutions saved")
+	}
+	for _, insert := range inserts {
+		var playlistKey string
+		for _, arg := range insert.Args {
+			if s, ok := arg.(string); ok && strings.HasSuffix(s, "/playlist.m3u8") && !strings.HasPrefix(s, "http") {
+				playlistKey = s
+			}
+		}
+		if playlistKey == "" {
+			t.Fatalf("no playlist key in %v", insert.Args)
+		}
+
+		playlist, _ := store.Get("videos", playlistKey)
+		segments := make(map[string][]byte)
+		prefix := path.Dir(playlistKey) + "/"
+		for _, name := range store.Names("videos") {
+			if rel, ok := strings.CutPrefix(name, prefix); ok && strings.HasSuffix(rel, ".ts") {
+				obj, _ := store.Get("videos", name)
+				sum := md5.Sum(obj.Data)
+				segments[rel] = sum[:]
+			}
+		}
+		if len(segments) != 2 {
+			t.Fatalf("%s has %d segments, want 2", prefix, len(segments))
+		}
+
+		wantPlaylist := md5.Sum(playlist.Data)
+		if !slices.Contains(insert.Args, any(hex.EncodeToString(wantPlaylist[:]))) {
+			t.Errorf("%s: playlist checksum %x not recorded in %v", playlistKey, wantPlaylist, insert.Args)
+		}
+		if want := server_utils.RollupChecksum(segments); !slices.Contains(insert.Args, any(want)) {
+			t.Errorf("%s: segments checksum %s not recorded in %v", playlistKey, want, insert.Args)
+		}
+	}
+}
+
+func TestRenditionWidth(t *testing.T) {
+	tests := []struct {
+		name                      string
+		sourceWidth, sourceHeight int
+		height                    int
+		want                      int
+	}{
+		{"16:9", 1920, 1080, 720, 1280},
+		{"16:9 odd result rounds to even", 1920, 1080, 360, 640},
+		{"4:3", 640, 480, 360, 480},
+		{"portrait", 1080, 1920, 720, 406},
+		{"anamorphic rounding", 1440, 1080, 144, 192},
+		{"odd width rounds to nearest even", 854, 480, 240, 428},
+		{"unknown width", 0, 1080, 720, 0},
+		{"unknown height", 1920, 0, 720, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renditionWidth(tt.sourceWidth, tt.sourceHeight, tt.height); got != tt.want {
+				t.Errorf("renditionWidth(%d, %d, %d) = %d, want %d", tt.sourceWidth, tt.sourceHeight, tt.height, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordsRenditionSize(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	useFakeTools(t, testProbe)
+	useRedis(t, nil)
+	gcsClient, _ := testgcs.Start(t)
+	gormDB, db := testdb.Open(t, nil)
+
+	job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"}
+	if err := processVideoStreaming(context.Background(), gcsClient, gormDB, job); err != nil {
+		t.Fatal(err)
+	}
+
+	// The 1280x720 source scaled with scale=-2:height
+	want := map[string][2]int64{
+		"720p": {1280, 720},
+		"480p": {854, 480},
+		"360p": {640, 360},
+		"240p": {426, 240},
+		"144p": {256, 144},
+	}
+	inserts := db.Matching(`INSERT INTO "video_resolutions"`)
+	if len(inserts) == 0 {
+		t.Fatal("no resolutions saved")
+	}
+	var args []any
+	for _, insert := range inserts {
+		args = append(args, insert.Args...)
+	}
+	for name, size := range want {
+		// Width and height follow the resolution name in each row
+		i := slices.Index(args, any(name))
+		if i < 0 || i+2 >= len(args) {
+			t.Errorf("%s not saved", name)
+			continue
+		}
+		if args[i+1] != size[0] || args[i+2] != size[1] {
+			t.Errorf("%s saved as %vx%v, want %dx%d", name, args[i+1], args[i+2], size[0], size[1])
+		}
+	}
+}
+
+func TestVersionedOutputKeys(t *testing.T) {
+	for _, version := range []int{0, 2} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			setVar(t, &gcsBucket, "videos")
+			useFakeTools(t, testProbe)
+			useRedis(t, nil)
+			gcsClient, store := testgcs.Start(t)
+			gormDB, db := testdb.Open(t, nil)
+
+			job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4", OutputVersion: version}
+			if err := processVideoStreaming(context.Background(), gcsClient, gormDB, job); err != nil {
+				t.Fatal(err)
+			}
+
+			prefix := models.OutputPrefix(job.VideoID, version)
+			names := store.Names("videos")
+			if !slices.Contains(names, prefix+"master.m3u8") {
+				t.Errorf("master playlist not under %s: %v", prefix, names)
+			}
+			for _, name := range names {
+				if !strings.HasPrefix(name, prefix) {
+					t.Errorf("object %s written outside %s", name, prefix)
+				}
+			}
+
+			// Every stored key and URL points into the versioned prefix
+			stored := 0
+			for _, q := range db.Queries() {
+				for _, arg := range q.Args {
+					if s, ok := arg.(string); ok && strings.Contains(s, "/processed/") {
+						stored++
+						if !strings.Contains(s, "/"+prefix) && !strings.HasPrefix(s, prefix) {
+							t.Errorf("stored %q outside %s", s, prefix)
+						}
+					}
+				}
+			}
+			if stored == 0 {
+				t.Error("no output keys or URLs were stored")
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// buildManifest summarises the persisted output of a video. Checksums are the
+// hex MD5 GCS recorded for each playlist object.
+func buildManifest(ctx context.Context, gormDB *gorm.DB, bucket *storage.BucketHandle, videoID uuid.UUID) (*models.Manifest, error) {
+	video, err := gorm.G[models.Video](gormDB).Where("id = ?", videoID).First(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load video: %w", err)
+	}
+	if video.MasterPlaylistKey == nil {
+		return nil, fmt.Errorf("video has no master playlist")
+	}
+
+	resolutions, err := gorm.G[models.VideoResolution](gormDB).Where("video_id = ?", videoID).Order("bandwidth DESC").Find(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load resolutions: %w", err)
+	}
+
+	manifest := &models.Manifest{
+		VideoID:           video.ID,
+		OriginalName:      video.OriginalName,
+		Duration:          video.Duration,
+		SourceWidth:       video.SourceWidth,
+		SourceHeight:      video.SourceHeight,
+		MasterPlaylistKey: *video.MasterPlaylistKey,
+		MasterPlaylistURL: buildPublicURL(bucket.BucketName(), *video.MasterPlaylistKey),
+		MasterChecksum:    objectMD5(ctx, bucket, *video.MasterPlaylistKey),
+		ProgressiveURL:    video.ProgressiveURL,
+		Timings: &models.ManifestTimings{
+			ProbeMs:     video.ProbeMs,
+			TranscodeMs: video.TranscodeMs,
+			UploadMs:    video.UploadMs,
+			TotalMs:     video.ProcessingMs,
+		},
+		Renditions:  make([]models.ManifestRendition, 0, len(resolutions)),
+		GeneratedAt: time.Now(),
+	}
+
+	for _, r := range resolutions {
+		checksum := r.Checksum
+		if checksum == "" {
+			checksum = objectMD5(ctx, bucket, r.PlaylistS3Key)
+		}
+		manifest.Renditions = append(manifest.Renditions, models.ManifestRendition{
+			Resolution:   r.Resolution,
+			Bandwidth:    r.Bandwidth,
+			SegmentCount: r.SegmentCount,
+			TotalSize:    r.TotalSize,
+			PlaylistKey:  r.PlaylistS3Key,
+			PlaylistURL:  r.PlaylistURL,
+			Checksum:     checksum,
+		})
+	}
+
+	return manifest, nil
+}
+
+// uploadManifest writes the manifest next to the HLS output under prefix
+func uploadManifest(ctx context.Context, bucket *storage.BucketHandle, prefix string, manifest *models.Manifest) (string, error) {
+	key := prefix + "manifest.json"
+
+	writer := bucket.Object(key).NewWriter(ctx)
+	writer.ContentType = contentTypeFor(key)
+	writer.Metadata = objectMetadata(models.Video{ID: manifest.VideoID, OriginalName: manifest.OriginalName})
+
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		writer.Close()
+		return "", fmt.Errorf("GCS upload error for %s: %w", key, err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("GCS writer close error for %s: %w", key, err)
+	}
+
+	return key, nil
+}
+
+// objectMD5 returns the hex MD5 of a stored object, or "" when unavailable
+func objectMD5(ctx context.Context, bucket *storage.BucketHandle, key string) string {
+	attrs, err := bucket.Object(key).Attrs(ctx)
+	if err != nil || len(attrs.MD5) == 0 {
+		return ""
+	}
+	return hex.EncodeToString(attrs.MD5)
+}
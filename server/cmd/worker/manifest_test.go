@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestBuildManifest(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+
+	id := uuid.New()
+	master := id.String() + "/processed/master.m3u8"
+	playlists := map[string]string{
+		"720p": id.String() + "/processed/stream_0/playlist.m3u8",
+		"360p": id.String() + "/processed/stream_1/playlist.m3u8",
+	}
+
+	gcsClient, store := testgcs.Start(t)
+	store.Put("videos", master, []byte("#EXTM3U\nmaster\n"))
+	store.Put("videos", playlists["720p"], []byte("#EXTM3U\n720p\n"))
+	// 360p playlist is missing from storage, so it has no checksum
+
+	resolutions := [][]any{
+		{uuid.NewString(), id.String(), "720p", playlists["720p"], "https://cdn/720", 12, int64(9000), 2800000},
+		{uuid.NewString(), id.String(), "360p", playlists["360p"], "https://cdn/360", 12, int64(3000), 800000},
+	}
+	gormDB, db := testdb.Open(t, func(q testdb.Query) testdb.Result {
+		switch {
+		case strings.Contains(q.SQL, `FROM "videos"`):
+			return testdb.Result{
+				Columns: []string{"id", "original_name", "duration", "source_width", "source_height", "master_playlist_key", "probe_ms", "transcode_ms", "upload_ms", "processing_ms"},
+				Rows:    [][]any{{id.String(), "talk.mp4", 72.5, 1280, 720, master, int64(120), int64(41000), int64(3500), int64(45200)}},
+			}
+		case strings.Contains(q.SQL, `FROM "video_resolutions"`):
+			return testdb.Result{
+				Columns: []string{"id", "video_id", "resolution", "playlist_s3_key", "playlist_url", "segment_count", "total_size", "bandwidth"},
+				Rows:    resolutions,
+			}
+		}
+		return testdb.Result{}
+	})
+
+	manifest, err := buildManifest(context.Background(), gormDB, gcsClient.Bucket("videos"), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if q := db.Matching(`FROM "video_resolutions"`); len(q) != 1 || !strings.Contains(q[0].SQL, "ORDER BY bandwidth DESC") {
+		t.Errorf("resolutions not loaded by bandwidth: %v", q)
+	}
+
+	if manifest.VideoID != id || manifest.OriginalName != "talk.mp4" || manifest.Duration != 72.5 ||
+		manifest.SourceWidth != 1280 || manifest.SourceHeight != 720 {
+		t.Errorf("video fields = %+v", manifest)
+	}
+	if manifest.MasterPlaylistKey != master || manifest.MasterPlaylistURL != buildPublicURL("videos", master) {
+		t.Errorf("master = %q, %q", manifest.MasterPlaylistKey, manifest.MasterPlaylistURL)
+	}
+	if want := md5Hex("#EXTM3U\nmaster\n"); manifest.MasterChecksum != want {
+		t.Errorf("MasterChecksum = %q, want %q", manifest.MasterChecksum, want)
+	}
+	if want := (models.ManifestTimings{ProbeMs: 120, TranscodeMs: 41000, UploadMs: 3500, TotalMs: 45200}); manifest.Timings == nil || *manifest.Timings != want {
+		t.Errorf("Timings = %+v, want %+v", manifest.Timings, want)
+	}
+
+	want := []models.ManifestRendition{
+		{Resolution: "720p", Bandwidth: 2800000, SegmentCount: 12, TotalSize: 9000, PlaylistKey: playlists["720p"], PlaylistURL: "https://cdn/720", Checksum: md5Hex("#EXTM3U\n720p\n")},
+		{Resolution: "360p", Bandwidth: 800000, SegmentCount: 12, TotalSize: 3000, PlaylistKey: playlists["360p"], PlaylistURL: "https://cdn/360"},
+	}
+	if len(manifest.Renditions) != len(want) {
+		t.Fatalf("Renditions = %+v, want %+v", manifest.Renditions, want)
+	}
+	for i := range want {
+		if manifest.Renditions[i] != want[i] {
+			t.Errorf("Renditions[%d] = %+v, want %+v", i, manifest.Renditions[i], want[i])
+		}
+	}
+}
+
+func TestBuildManifestWithoutMaster(t *testing.T) {
+	id := uuid.New()
+	gormDB, _ := testdb.Open(t, func(q testdb.Query) testdb.Result {
+		return testdb.Result{Columns: []string{"id"}, Rows: [][]any{{id.String()}}}
+	})
+	if _, err := buildManifest(context.Background(), gormDB, nil, id); err == nil {
+		t.Error("expected an error for a video without a master playlist")
+	}
+}
+
+func TestUploadManifest(t *testing.T) {
+	gcsClient, store := testgcs.Start(t)
+	manifest := &models.Manifest{
+		VideoID:    uuid.New(),
+		Renditions: []models.ManifestRendition{{Resolution: "720p", Bandwidth: 2800000}},
+	}
+
+	key, err := uploadManifest(context.Background(), gcsClient.Bucket("videos"), models.OutputPrefix(manifest.VideoID, 0), manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := manifest.VideoID.String() + "/processed/manifest.json"; key != want {
+		t.Errorf("key = %q, want %q", key, want)
+	}
+
+	obj, ok := store.Get("videos", key)
+	if !ok {
+		t.Fatal("manifest not uploaded")
+	}
+	if obj.ContentType != "application/json" {
+		t.Errorf("ContentType = %q", obj.ContentType)
+	}
+	var got models.Manifest
+	if err := json.Unmarshal(obj.Data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.VideoID != manifest.VideoID || len(got.Renditions) != 1 || got.Renditions[0] != manifest.Renditions[0] {
+		t.Errorf("stored manifest = %+v", got)
+	}
+}
+
+func md5Hex(data string) string {
+	sum := md5.Sum([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"github.com/devrayat000/video-process/models"
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// Tag every uploaded output object with its video id and original file name
+// so storage stays self-describing without the database
+var outputObjectMetadata = server_utils.GetEnvBool("OUTPUT_OBJECT_METADATA", true)
+
+// objectMetadata returns the custom metadata set on a video's output objects,
+// or nil when OUTPUT_OBJECT_METADATA is off.
+func objectMetadata(video models.Video) map[string]string {
+	if !outputObjectMetadata {
+		return nil
+	}
+	metadata := map[string]string{"video-id": video.ID.String()}
+	if video.OriginalName != "" {
+		metadata["original-name"] = video.OriginalName
+	}
+	return metadata
+}
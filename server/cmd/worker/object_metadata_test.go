@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestObjectMetadata(t *testing.T) {
+	id := uuid.New()
+	tests := []struct {
+		name    string
+		enabled bool
+		video   models.Video
+		want    map[string]string
+	}{
+		{"named upload", true, models.Video{ID: id, OriginalName: "My Clip (final).mp4"}, map[string]string{"video-id": id.String(), "original-name": "My Clip (final).mp4"}},
+		{"no original name", true, models.Video{ID: id}, map[string]string{"video-id": id.String()}},
+		{"disabled", false, models.Video{ID: id, OriginalName: "clip.mp4"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &outputObjectMetadata, tt.enabled)
+			if got := objectMetadata(tt.video); !maps.Equal(got, tt.want) {
+				t.Errorf("objectMetadata = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutputObjectsCarryMetadata(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	setVar(t, &outputObjectMetadata, true)
+	useFakeTools(t, testProbe)
+	useRedis(t, nil)
+	gcsClient, store := testgcs.Start(t)
+
+	job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4", OriginalName: "holiday.mov"}
+	gormDB, _ := testdb.Open(t, nil)
+	if err := processVideoStreaming(context.Background(), gcsClient, gormDB, job); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"video-id": job.VideoID.String(), "original-name": "holiday.mov"}
+	names := store.Names("videos")
+	if len(names) == 0 {
+		t.Fatal("nothing uploaded")
+	}
+	for _, name := range names {
+		obj, _ := store.Get("videos", name)
+		if !maps.Equal(obj.Metadata, want) {
+			t.Errorf("%s metadata = %v, want %v", name, obj.Metadata, want)
+		}
+	}
+}
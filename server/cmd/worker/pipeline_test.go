@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// The test binary doubles as ffmpeg and ffprobe: fake scripts on PATH re-run
+// it with fakeToolEnv naming the tool to imitate.
+const (
+	fakeToolEnv = "WORKER_TEST_FAKE_TOOL"
+	// ffprobe's stdout
+	fakeProbeEnv = "WORKER_TEST_PROBE"
+	// When set, ffmpeg writes this to stderr and exits 1
+	fakeFFmpegFailEnv = "WORKER_TEST_FFMPEG_FAIL"
+	// Index of a variant ffmpeg lists in the master but writes no files for
+	fakeFFmpegSkipEnv = "WORKER_TEST_FFMPEG_SKIP"
+)
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(fakeToolEnv) {
+	case "ffprobe":
+		fmt.Print(os.Getenv(fakeProbeEnv))
+		os.Exit(0)
+	case "ffmpeg":
+		os.Exit(fakeFFmpeg(os.Args[1:]))
+	}
+	// Test jobs read their sources from gs://uploads
+	server_utils.SourceBuckets = "uploads"
+	os.Exit(m.Run())
+}
+
+// useFakeTools puts fake ffmpeg and ffprobe binaries on PATH. ffprobe prints
+// probe; ffmpeg writes a small HLS tree wherever its arguments point.
+func useFakeTools(t *testing.T, probe string) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		fakeCommand(t, tool, fmt.Sprintf("%s=%s exec '%s' \"$@\"", fakeToolEnv, tool, exe))
+	}
+	t.Setenv(fakeProbeEnv, probe)
+}
+
+// fakeFFmpeg answers the startup capability queries and otherwise imitates
+// an HLS run: two segments and a playlist per variant in
+// -var_stream_map, plus the master playlist. With -hls_flags single_file
+// the segments share one file.
+func fakeFFmpeg(args []string) int {
+	switch args[len(args)-1] {
+	case "-version":
+		fmt.Println("ffmpeg version 6.1.1 Copyright (c) 2000-2023 the FFmpeg developers")
+		return 0
+	case "-encoders":
+		fmt.Print(sampleEncoders)
+		return 0
+	case "-filters":
+		fmt.Print(sampleFilters)
+		return 0
+	}
+
+	if msg := os.Getenv(fakeFFmpegFailEnv); msg != "" {
+		fmt.Fprintln(os.Stderr, msg)
+		return 1
+	}
+
+	value := func(flag string) string {
+		for i, arg := range args[:len(args)-1] {
+			if arg == flag {
+				return args[i+1]
+			}
+		}
+		return ""
+	}
+	playlistPattern := args[len(args)-1]
+	segmentPattern := value("-hls_segment_filename")
+	variants := len(strings.Fields(value("-var_stream_map")))
+	singleFile := strings.Contains(value("-hls_flags"), "single_file")
+
+	var master strings.Builder
+	master.WriteString("#EXTM3U\n")
+	for i := range variants {
+		index := fmt.Sprint(i)
+		playlistPath := strings.Replace(playlistPattern, "%v", index, 1)
+		if index == os.Getenv(fakeFFmpegSkipEnv) {
+			rel, _ := filepath.Rel(filepath.Dir(filepath.Dir(playlistPattern)), playlistPath)
+			fmt.Fprintf(&master, "#EXT-X-STREAM-INF:BANDWIDTH=%d\n%s\n", 1000000*(i+1), rel)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(playlistPath), 0o755); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		var playlist strings.Builder
+		playlist.WriteString("#EXTM3U\n#EXT-X-TARGETDURATION:6\n")
+		if singleFile {
+			// One media file, its segments addressed by byte range
+			mediaPath := strings.Replace(segmentPattern, "%v", index, 1)
+			var media []byte
+			for n := range 2 {
+				data := fmt.Sprintf("variant %d segment %d\n", i, n)
+				fmt.Fprintf(&playlist, "#EXTINF:6.000000,\n#EXT-X-BYTERANGE:%d@%d\n%s\n", len(data), len(media), filepath.Base(mediaPath))
+				media = append(media, data...)
+			}
+			if err := os.WriteFile(mediaPath, media, 0o644); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+		} else {
+			for n := range 2 {
+				segmentPath := fmt.Sprintf(strings.Replace(segmentPattern, "%v", index, 1), n)
+				data := fmt.Sprintf("variant %d segment %d\n", i, n)
+				if err := os.WriteFile(segmentPath, []byte(data), 0o644); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					return 1
+				}
+				fmt.Fprintf(&playlist, "#EXTINF:6.000000,\n%s\n", filepath.Base(segmentPath))
+			}
+		}
+		playlist.WriteString("#EXT-X-ENDLIST\n")
+		if err := os.WriteFile(playlistPath, []byte(playlist.String()), 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		rel, _ := filepath.Rel(filepath.Dir(filepath.Dir(playlistPattern)), playlistPath)
+		fmt.Fprintf(&master, "#EXT-X-STREAM-INF:BANDWIDTH=%d\n%s\n", 1000000*(i+1), rel)
+	}
+
+	masterPath := filepath.Join(filepath.Dir(filepath.Dir(playlistPattern)), value("-master_pl_name"))
+	if err := os.WriteFile(masterPath, []byte(master.String()), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Println("progress=end")
+	return 0
+}
+
+// testProbe is ffprobe output for a two-second 720p source
+const testProbe = `{"streams": [{"codec_type": "video", "width": 1280, "height": 720, "nb_frames": "48", "avg_frame_rate": "24/1"}], "format": {"duration": "2.0"}}`
+
+// sampleEncoders is trimmed `ffmpeg -encoders` output
+const sampleEncoders = `Encoders:
+ V..... = Video
+ A..... = Audio
+ S..... = Subtitle
+ .F.... = Frame-level multithreading
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)
+ A....D aac                  AAC (Advanced Audio Coding)
+ A....D libopus              libopus Opus (codec opus)
+`
+
+// sampleFilters is trimmed `ffmpeg -filters` output
+const sampleFilters = `Filters:
+  T.. = Timeline support
+  .S. = Slice threading
+  ..C = Command support
+  A = Audio input/output
+  V = Video input/output
+  N = Dynamic number and/or type of input/output
+  | = Source or sink filter
+ ... apad              A->A       Pad audio with silence.
+ ... aresample         A->A       Resample audio data.
+ ..C scale             V->V       Scale the input video size and/or convert the image format.
+ ... split             V->N       Pass on the input to N video outputs.
+ T.C tpad              V->V       Temporarily pad video frames.
+`
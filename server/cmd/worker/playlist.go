@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+var (
+	// File name of the master playlist, locally and in storage
+	masterPlaylistName = server_utils.GetEnv("MASTER_PLAYLIST_NAME", "master.m3u8")
+
+	// "relative" keeps FFmpeg's URIs; "absolute" rewrites them to public URLs
+	// so the master can be served from a different host than the segments
+	playlistURIs = server_utils.GetEnv("PLAYLIST_URIS", "relative")
+
+	// Height of the variant players should start on; it is listed first in
+	// the master since most clients begin with the first entry (0 = ladder order)
+	defaultRenditionHeight = server_utils.GetEnvInt("DEFAULT_RENDITION_HEIGHT", 0)
+
+	// "bandwidth_asc" or "bandwidth_desc" sorts the master's variants; empty
+	// keeps the ladder order. DEFAULT_RENDITION_HEIGHT still goes first.
+	masterVariantOrder = server_utils.GetEnv("MASTER_VARIANT_ORDER", "")
+)
+
+// validatePlaylistConfig rejects settings FFmpeg or players would choke on
+func validatePlaylistConfig(name, uris, order string) error {
+	if path.Ext(name) != ".m3u8" || strings.Contains(name, "/") {
+		return fmt.Errorf("MASTER_PLAYLIST_NAME must be a plain .m3u8 file name, got %q", name)
+	}
+	if uris != "relative" && uris != "absolute" {
+		return fmt.Errorf("PLAYLIST_URIS must be relative or absolute, got %q", uris)
+	}
+	if order != "" && order != "bandwidth_asc" && order != "bandwidth_desc" {
+		return fmt.Errorf("MASTER_VARIANT_ORDER must be bandwidth_asc or bandwidth_desc, got %q", order)
+	}
+	return nil
+}
+
+// uriAttribute matches URI="..." inside tags such as EXT-X-MAP and EXT-X-MEDIA
+var uriAttribute = regexp.MustCompile(`URI="([^"]*)"`)
+
+// rewritePlaylistURIs resolves every relative URI in a playlist against
+// baseURL. URI lines and URI attributes are rewritten; absolute ones are kept.
+func rewritePlaylistURIs(content, baseURL string) string {
+	resolve := func(uri string) string {
+		if uri == "" || strings.Contains(uri, "://") {
+			return uri
+		}
+		return strings.TrimSuffix(baseURL, "/") + "/" + uri
+	}
+
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+		case strings.HasPrefix(trimmed, "#"):
+			lines[i] = uriAttribute.ReplaceAllStringFunc(line, func(attr string) string {
+				uri := uriAttribute.FindStringSubmatch(attr)[1]
+				return fmt.Sprintf(`URI="%s"`, resolve(uri))
+			})
+		default:
+			lines[i] = resolve(trimmed)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// absolutizePlaylist rewrites a local playlist in place when PLAYLIST_URIS is
+// absolute. keyPrefix is the directory in bucketName the playlist goes to.
+func absolutizePlaylist(localPath, bucketName, keyPrefix string) error {
+	if playlistURIs != "absolute" {
+		return nil
+	}
+
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to read playlist %s: %w", localPath, err)
+	}
+
+	rewritten := rewritePlaylistURIs(string(data), buildPublicURL(bucketName, keyPrefix))
+	if err := os.WriteFile(localPath, []byte(rewritten), 0o644); err != nil {
+		return fmt.Errorf("failed to rewrite playlist %s: %w", localPath, err)
+	}
+
+	return nil
+}
+
+// defaultVariantIndex returns the rendition closest to height, preferring
+// the lower one on a tie, or -1 when height is unset or the ladder is empty.
+func defaultVariantIndex(renditions []Rendition, height int) int {
+	if height <= 0 {
+		return -1
+	}
+	best := -1
+	for i, r := range renditions {
+		if best < 0 {
+			best = i
+			continue
+		}
+		d, bd := abs(r.Height-height), abs(renditions[best].Height-height)
+		if d < bd || (d == bd && r.Height < renditions[best].Height) {
+			best = i
+		}
+	}
+	return best
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// promoteVariant moves the EXT-X-STREAM-INF entry whose URI lives in dir to
+// the front of the variant list. EXT-X-STREAM-INF has no DEFAULT attribute,
+// so the order is the only signal players use for the starting variant.
+func promoteVariant(content, dir string) string {
+	lines := strings.Split(content, "\n")
+
+	first, found := -1, -1
+	for i := 0; i+1 < len(lines); i++ {
+		if !strings.HasPrefix(lines[i], "#EXT-X-STREAM-INF:") {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		if strings.HasPrefix(strings.TrimSpace(lines[i+1]), dir+"/") {
+			found = i
+			break
+		}
+	}
+	if found <= first {
+		return content
+	}
+
+	entry := []string{lines[found], lines[found+1]}
+	rest := append(lines[:found:found], lines[found+2:]...)
+	out := append(rest[:first:first], entry...)
+	out = append(out, rest[first:]...)
+	return strings.Join(out, "\n")
+}
+
+// dropVariants removes the master playlist entries of the given variant
+// directories, for renditions FFmpeg didn't produce
+func dropVariants(localPath string, dirs []string) error {
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to read playlist %s: %w", localPath, err)
+	}
+
+	lines := strings.Split(string(data), "\n")
+	out := make([]string, 0, len(lines))
+	for i := 0; i < len(lines); i++ {
+		if strings.HasPrefix(lines[i], "#EXT-X-STREAM-INF:") && i+1 < len(lines) {
+			uri := strings.TrimSpace(lines[i+1])
+			if slices.ContainsFunc(dirs, func(dir string) bool { return strings.HasPrefix(uri, dir+"/") }) {
+				i++
+				continue
+			}
+		}
+		out = append(out, lines[i])
+	}
+
+	if err := os.WriteFile(localPath, []byte(strings.Join(out, "\n")), 0o644); err != nil {
+		return fmt.Errorf("failed to rewrite playlist %s: %w", localPath, err)
+	}
+	return nil
+}
+
+// variantBandwidth matches the BANDWIDTH attribute, not AVERAGE-BANDWIDTH
+var variantBandwidth = regexp.MustCompile(`[:,]BANDWIDTH=(\d+)`)
+
+// sortVariants orders the EXT-X-STREAM-INF entries by BANDWIDTH, ascending
+// or descending. Entries keep their slots in the file, so tags and blank
+// lines around them stay put, and equal bandwidths keep their order.
+func sortVariants(content, order string) string {
+	if order == "" {
+		return content
+	}
+	lines := strings.Split(content, "\n")
+
+	type variant struct {
+		tag, uri  string
+		bandwidth int
+	}
+	var slots []int
+	var variants []variant
+	for i := 0; i+1 < len(lines); i++ {
+		if !strings.HasPrefix(lines[i], "#EXT-X-STREAM-INF:") {
+			continue
+		}
+		v := variant{tag: lines[i], uri: lines[i+1]}
+		if m := variantBandwidth.FindStringSubmatch(lines[i]); m != nil {
+			v.bandwidth, _ = strconv.Atoi(m[1])
+		}
+		slots = append(slots, i)
+		variants = append(variants, v)
+		i++
+	}
+
+	slices.SortStableFunc(variants, func(a, b variant) int {
+		if order == "bandwidth_desc" {
+			return b.bandwidth - a.bandwidth
+		}
+		return a.bandwidth - b.bandwidth
+	})
+	for n, i := range slots {
+		lines[i], lines[i+1] = variants[n].tag, variants[n].uri
+	}
+	return strings.Join(lines, "\n")
+}
+
+// reorderVariants applies MASTER_VARIANT_ORDER to the local master playlist
+// and then moves the variant picked by DEFAULT_RENDITION_HEIGHT to the front.
+// It must run before URIs are made absolute.
+func reorderVariants(localPath string, renditions []Rendition) error {
+	index := defaultVariantIndex(renditions, defaultRenditionHeight)
+	if index < 0 && masterVariantOrder == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to read playlist %s: %w", localPath, err)
+	}
+
+	reordered := sortVariants(string(data), masterVariantOrder)
+	if index >= 0 {
+		reordered = promoteVariant(reordered, variantDirName(index))
+	}
+	if err := os.WriteFile(localPath, []byte(reordered), 0o644); err != nil {
+		return fmt.Errorf("failed to rewrite playlist %s: %w", localPath, err)
+	}
+
+	return nil
+}
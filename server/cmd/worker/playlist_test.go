@@ -0,0 +1,336 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidatePlaylistConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		master  string
+		uris    string
+		order   string
+		wantErr bool
+	}{
+		{"defaults", "master.m3u8", "relative", "", false},
+		{"custom name absolute", "index.m3u8", "absolute", "", false},
+		{"ascending", "master.m3u8", "relative", "bandwidth_asc", false},
+		{"descending", "master.m3u8", "relative", "bandwidth_desc", false},
+		{"not a playlist", "master.txt", "relative", "", true},
+		{"nested path", "hls/master.m3u8", "relative", "", true},
+		{"unknown mode", "master.m3u8", "signed", "", true},
+		{"empty mode", "master.m3u8", "", "", true},
+		{"unknown order", "master.m3u8", "relative", "height_asc", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validatePlaylistConfig(tt.master, tt.uris, tt.order); (err != nil) != tt.wantErr {
+				t.Errorf("validatePlaylistConfig(%q, %q, %q) error = %v, wantErr %v", tt.master, tt.uris, tt.order, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRewritePlaylistURIs(t *testing.T) {
+	base := "https://cdn.example.com/videos/abc/processed/"
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name: "master variants",
+			content: "#EXTM3U\n#EXT-X-VERSION:6\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=2800000,RESOLUTION=1280x720\nstream_0/playlist.m3u8\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=1400000,RESOLUTION=854x480\nstream_1/playlist.m3u8\n",
+			want: "#EXTM3U\n#EXT-X-VERSION:6\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=2800000,RESOLUTION=1280x720\nhttps://cdn.example.com/videos/abc/processed/stream_0/playlist.m3u8\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=1400000,RESOLUTION=854x480\nhttps://cdn.example.com/videos/abc/processed/stream_1/playlist.m3u8\n",
+		},
+		{
+			name:    "URI attributes",
+			content: "#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXT-X-MEDIA:TYPE=SUBTITLES,NAME=\"en\",URI=\"subs/en.m3u8\"\n",
+			want:    "#EXTM3U\n#EXT-X-MAP:URI=\"https://cdn.example.com/videos/abc/processed/init.mp4\"\n#EXT-X-MEDIA:TYPE=SUBTITLES,NAME=\"en\",URI=\"https://cdn.example.com/videos/abc/processed/subs/en.m3u8\"\n",
+		},
+		{
+			name:    "media segments",
+			content: "#EXTINF:6.000000,\nsegment_000.ts\n#EXTINF:4.000000,\n  segment_001.ts  \n#EXT-X-ENDLIST",
+			want:    "#EXTINF:6.000000,\nhttps://cdn.example.com/videos/abc/processed/segment_000.ts\n#EXTINF:4.000000,\nhttps://cdn.example.com/videos/abc/processed/segment_001.ts\n#EXT-X-ENDLIST",
+		},
+		{
+			name:    "absolute URIs kept",
+			content: "#EXT-X-MAP:URI=\"https://other.example.com/init.mp4\"\nhttps://other.example.com/segment_000.ts\n",
+			want:    "#EXT-X-MAP:URI=\"https://other.example.com/init.mp4\"\nhttps://other.example.com/segment_000.ts\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewritePlaylistURIs(tt.content, base); got != tt.want {
+				t.Errorf("rewritePlaylistURIs =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAbsolutizePlaylist(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	master := "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=2800000\nstream_0/playlist.m3u8\n"
+
+	for _, mode := range []string{"relative", "absolute"} {
+		t.Run(mode, func(t *testing.T) {
+			setVar(t, &playlistURIs, mode)
+			localPath := filepath.Join(t.TempDir(), "master.m3u8")
+			if err := os.WriteFile(localPath, []byte(master), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := absolutizePlaylist(localPath, "tenant-a", "abc/processed"); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(localPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := master
+			if mode == "absolute" {
+				want = "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=2800000\n" + buildPublicURL("tenant-a", "abc/processed/stream_0/playlist.m3u8") + "\n"
+			}
+			if string(data) != want {
+				t.Errorf("playlist = %q, want %q", data, want)
+			}
+		})
+	}
+}
+
+func TestDefaultVariantIndex(t *testing.T) {
+	ladder := []Rendition{{Height: 1080}, {Height: 720}, {Height: 480}, {Height: 360}}
+	tests := []struct {
+		name   string
+		height int
+		want   int
+	}{
+		{"unset", 0, -1},
+		{"exact", 480, 2},
+		{"first", 1080, 0},
+		{"closest", 700, 1},
+		{"tie prefers lower", 600, 2},
+		{"above the ladder", 2160, 0},
+		{"below the ladder", 144, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := defaultVariantIndex(ladder, tt.height); got != tt.want {
+				t.Errorf("defaultVariantIndex(%d) = %d, want %d", tt.height, got, tt.want)
+			}
+		})
+	}
+	if got := defaultVariantIndex(nil, 480); got != -1 {
+		t.Errorf("defaultVariantIndex on an empty ladder = %d, want -1", got)
+	}
+}
+
+func TestPromoteVariant(t *testing.T) {
+	master := "#EXTM3U\n#EXT-X-VERSION:6\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2800000,RESOLUTION=1280x720\nstream_0/playlist.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=1400000,RESOLUTION=854x480\nstream_1/playlist.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360\nstream_2/playlist.m3u8\n"
+
+	tests := []struct {
+		name string
+		dir  string
+		want []string // variant URIs in order
+	}{
+		{"middle moves first", "stream_1", []string{"stream_1", "stream_0", "stream_2"}},
+		{"last moves first", "stream_2", []string{"stream_2", "stream_0", "stream_1"}},
+		{"already first", "stream_0", []string{"stream_0", "stream_1", "stream_2"}},
+		{"unknown dir", "stream_9", []string{"stream_0", "stream_1", "stream_2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := promoteVariant(master, tt.dir)
+			if !strings.HasPrefix(got, "#EXTM3U\n#EXT-X-VERSION:6\n") {
+				t.Errorf("header lost:\n%s", got)
+			}
+
+			var order []string
+			lines := strings.Split(got, "\n")
+			for i, line := range lines {
+				if strings.HasPrefix(line, "#EXT-X-STREAM-INF:") {
+					// Each tag must still be followed by its own URI
+					dir, _, _ := strings.Cut(lines[i+1], "/")
+					order = append(order, dir)
+					if strings.Contains(line, "854x480") != (dir == "stream_1") {
+						t.Errorf("%s lost its EXT-X-STREAM-INF line: %q", dir, line)
+					}
+				}
+			}
+			if !slices.Equal(order, tt.want) {
+				t.Errorf("variant order = %v, want %v", order, tt.want)
+			}
+		})
+	}
+}
+
+func TestReorderVariants(t *testing.T) {
+	master := "#EXTM3U\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2800000\nstream_0/playlist.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=1400000\nstream_1/playlist.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=800000\nstream_2/playlist.m3u8\n"
+	ladder := []Rendition{{Height: 720}, {Height: 480}, {Height: 360}}
+
+	tests := []struct {
+		name   string
+		height int
+		order  string
+		want   []string
+	}{
+		{"unset keeps ladder order", 0, "", []string{"stream_0", "stream_1", "stream_2"}},
+		{"480p first", 480, "", []string{"stream_1", "stream_0", "stream_2"}},
+		{"ascending", 0, "bandwidth_asc", []string{"stream_2", "stream_1", "stream_0"}},
+		{"descending", 0, "bandwidth_desc", []string{"stream_0", "stream_1", "stream_2"}},
+		{"default variant ahead of the order", 480, "bandwidth_asc", []string{"stream_1", "stream_2", "stream_0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &defaultRenditionHeight, tt.height)
+			setVar(t, &masterVariantOrder, tt.order)
+			setVar(t, &hlsVariantDir, "stream_%v")
+			playlist := filepath.Join(t.TempDir(), "master.m3u8")
+			if err := os.WriteFile(playlist, []byte(master), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := reorderVariants(playlist, ladder); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(playlist)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, line := range strings.Split(string(data), "\n") {
+				if strings.HasSuffix(line, "/playlist.m3u8") {
+					got = append(got, strings.TrimSuffix(line, "/playlist.m3u8"))
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("variant order = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDropVariants(t *testing.T) {
+	master := "#EXTM3U\n#EXT-X-VERSION:3\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2800000\nstream_0/playlist.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=1400000\nstream_1/playlist.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=800000\nstream_10/playlist.m3u8\n"
+
+	tests := []struct {
+		name string
+		dirs []string
+		want string
+	}{
+		{"nothing missing", nil, master},
+		{
+			name: "middle variant",
+			dirs: []string{"stream_1"},
+			want: "#EXTM3U\n#EXT-X-VERSION:3\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=2800000\nstream_0/playlist.m3u8\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=800000\nstream_10/playlist.m3u8\n",
+		},
+		{
+			// stream_1 must not match stream_10
+			name: "last variant",
+			dirs: []string{"stream_10"},
+			want: "#EXTM3U\n#EXT-X-VERSION:3\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=2800000\nstream_0/playlist.m3u8\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=1400000\nstream_1/playlist.m3u8\n",
+		},
+		{"unknown dir", []string{"stream_7"}, master},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			playlist := filepath.Join(t.TempDir(), "master.m3u8")
+			if err := os.WriteFile(playlist, []byte(master), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := dropVariants(playlist, tt.dirs); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(playlist)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("master =\n%s\nwant\n%s", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortVariants(t *testing.T) {
+	master := "#EXTM3U\n" +
+		"#EXT-X-VERSION:3\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2000000,AVERAGE-BANDWIDTH=1500000,RESOLUTION=1280x720\n" +
+		"720p/playlist.m3u8\n" +
+		"\n" +
+		"#EXT-X-STREAM-INF:AVERAGE-BANDWIDTH=9000000,BANDWIDTH=500000,RESOLUTION=640x360\n" +
+		"360p/playlist.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=5000000,RESOLUTION=1920x1080\n" +
+		"1080p/playlist.m3u8\n"
+
+	tests := []struct {
+		name  string
+		order string
+		want  string
+	}{
+		{"unset keeps the file", "", master},
+		{
+			name:  "ascending",
+			order: "bandwidth_asc",
+			want: "#EXTM3U\n" +
+				"#EXT-X-VERSION:3\n" +
+				"#EXT-X-STREAM-INF:AVERAGE-BANDWIDTH=9000000,BANDWIDTH=500000,RESOLUTION=640x360\n" +
+				"360p/playlist.m3u8\n" +
+				"\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=2000000,AVERAGE-BANDWIDTH=1500000,RESOLUTION=1280x720\n" +
+				"720p/playlist.m3u8\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=5000000,RESOLUTION=1920x1080\n" +
+				"1080p/playlist.m3u8\n",
+		},
+		{
+			name:  "descending",
+			order: "bandwidth_desc",
+			want: "#EXTM3U\n" +
+				"#EXT-X-VERSION:3\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=5000000,RESOLUTION=1920x1080\n" +
+				"1080p/playlist.m3u8\n" +
+				"\n" +
+				"#EXT-X-STREAM-INF:BANDWIDTH=2000000,AVERAGE-BANDWIDTH=1500000,RESOLUTION=1280x720\n" +
+				"720p/playlist.m3u8\n" +
+				"#EXT-X-STREAM-INF:AVERAGE-BANDWIDTH=9000000,BANDWIDTH=500000,RESOLUTION=640x360\n" +
+				"360p/playlist.m3u8\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortVariants(master, tt.order); got != tt.want {
+				t.Errorf("sortVariants = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortVariantsStable(t *testing.T) {
+	master := "#EXT-X-STREAM-INF:BANDWIDTH=100\na.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=100\nb.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=50\nc.m3u8"
+	want := "#EXT-X-STREAM-INF:BANDWIDTH=50\nc.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=100\na.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=100\nb.m3u8"
+	if got := sortVariants(master, "bandwidth_asc"); got != want {
+		t.Errorf("sortVariants = %q, want %q", got, want)
+	}
+}
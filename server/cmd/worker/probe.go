@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+var (
+	// Longest a single ffprobe run may take, so an unreachable source fails
+	// fast instead of holding a job slot until the job timeout
+	ffprobeTimeout = server_utils.GetEnvDuration("FFPROBE_TIMEOUT", 60*time.Second)
+	// Further attempts after a probe times out or hits a network error
+	ffprobeRetries = server_utils.GetEnvInt("FFPROBE_RETRIES", 2)
+	// Pause before the first retry; each later one waits a step longer
+	ffprobeRetryDelay = 2 * time.Second
+)
+
+// Fragments of ffprobe errors worth another attempt. Missing files and HTTP
+// 4xx answers won't change on a retry, so only network trouble is listed.
+var transientProbeErrors = []string{
+	"connection refused",
+	"connection reset",
+	"connection timed out",
+	"i/o timeout",
+	"network is unreachable",
+	"temporary failure in name resolution",
+	"server returned 5",
+}
+
+// runFFprobe runs ffprobe with args and returns its stdout. Each attempt gets
+// FFPROBE_TIMEOUT; timeouts and network errors are retried up to
+// FFPROBE_RETRIES times with a growing pause.
+func runFFprobe(ctx context.Context, args []string) ([]byte, error) {
+	var err error
+	for attempt := 0; ; attempt++ {
+		var output []byte
+		output, err = probeOnce(ctx, args)
+		if err == nil {
+			return output, nil
+		}
+		if attempt >= ffprobeRetries || !isTransientProbeError(err) || ctx.Err() != nil {
+			return nil, err
+		}
+
+		wait := time.Duration(attempt+1) * ffprobeRetryDelay
+		logf(ctx, " [!] ffprobe attempt %d failed, retrying in %s: %v", attempt+1, wait, err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, err
+		}
+	}
+}
+
+// probeOnce is a single ffprobe run bounded by FFPROBE_TIMEOUT
+func probeOnce(ctx context.Context, args []string) ([]byte, error) {
+	if ffprobeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ffprobeTimeout)
+		defer cancel()
+	}
+
+	output, err := exec.CommandContext(ctx, ffprobePath, args...).Output()
+	if err == nil {
+		return output, nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, &probeTimeoutError{timeout: ffprobeTimeout}
+	}
+	// Output captures stderr on exit errors; surface it as the cause
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		err = &ffmpegError{err: err, stderr: strings.TrimSpace(string(exitErr.Stderr))}
+	}
+	return nil, err
+}
+
+// probeTimeoutError is a probe killed by FFPROBE_TIMEOUT
+type probeTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *probeTimeoutError) Error() string {
+	return fmt.Sprintf("ffprobe timed out after %s", e.timeout)
+}
+
+func isTransientProbeError(err error) bool {
+	var timeout *probeTimeoutError
+	if errors.As(err, &timeout) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, pattern := range transientProbeErrors {
+		if strings.Contains(msg, pattern) {
+			return true
+		}
+	}
+	return false
+}
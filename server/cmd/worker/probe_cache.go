@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/devrayat000/video-process/models"
+	"github.com/devrayat000/video-process/pubsub"
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// How long probed source metadata is reused; zero always runs ffprobe
+var probeCacheTTL = server_utils.GetEnvDuration("PROBE_CACHE_TTL", 24*time.Hour)
+
+// probeCacheKey identifies a source. GCS sources include the object
+// generation, so an overwritten source never hits an old entry; remote URLs
+// can only be refreshed with a forced reprocess.
+func probeCacheKey(ctx context.Context, gcsClient *storage.Client, job models.VideoJob) string {
+	identity := job.S3Path
+	if bucketName, key, ok := sourceObject(job); ok {
+		identity = fmt.Sprintf("gs://%s/%s", bucketName, key)
+		if attrs, err := gcsClient.Bucket(bucketName).Object(key).Attrs(ctx); err == nil {
+			identity = fmt.Sprintf("%s#%d", identity, attrs.Generation)
+		}
+	}
+	sum := sha256.Sum256([]byte(identity))
+	return hex.EncodeToString(sum[:])
+}
+
+// probeSource returns the source metadata, from the cache when an earlier
+// run already probed the same source and ForceProbe isn't set.
+func probeSource(ctx context.Context, gcsClient *storage.Client, job models.VideoJob, sourceURL string) (*VideoMetadata, error) {
+	// An assembled image sequence depends on the job's frame rate, not just
+	// the archive, so it is always probed
+	if probeCacheTTL <= 0 || job.ImageSequence != nil {
+		return getVideoMetadata(ctx, sourceURL)
+	}
+
+	key := probeCacheKey(ctx, gcsClient, job)
+
+	if job.ForceProbe {
+		if err := pubsub.DeleteCachedProbe(ctx, key); err != nil {
+			log.Printf(" [!] Failed to invalidate probe cache: %v", err)
+		}
+	} else if data, err := pubsub.GetCachedProbe(ctx, key); err == nil {
+		var metadata VideoMetadata
+		if err := json.Unmarshal(data, &metadata); err == nil {
+			log.Printf(" [i] Using cached probe for video_id=%s", job.VideoID)
+			return &metadata, nil
+		}
+	}
+
+	metadata, err := getVideoMetadata(ctx, sourceURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err := json.Marshal(metadata); err == nil {
+		if err := pubsub.CacheProbe(ctx, key, data, probeCacheTTL); err != nil {
+			log.Printf(" [!] Failed to cache probe: %v", err)
+		}
+	}
+
+	return metadata, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/devrayat000/video-process/pubsub"
+	"github.com/google/uuid"
+)
+
+// probeCacheStore is a Redis fake that keeps GET/SET/DEL values
+type probeCacheStore struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (s *probeCacheStore) handle(cmd []string) any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch cmd[0] {
+	case "get":
+		if v, ok := s.values[cmd[1]]; ok {
+			return v
+		}
+		return nil
+	case "set":
+		s.values[cmd[1]] = cmd[2]
+		return "OK"
+	case "del":
+		delete(s.values, cmd[1])
+		return int64(1)
+	}
+	return nil
+}
+
+// probeCalls counts the metadata probes recorded by customTools; each
+// getVideoMetadata call makes exactly one JSON probe of all streams
+func probeCalls(t *testing.T, logFile string) int {
+	t.Helper()
+	data, err := os.ReadFile(logFile)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "-show_streams")
+}
+
+func TestProbeSourceCache(t *testing.T) {
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		runs      []bool // ForceProbe per run
+		wantCalls int
+	}{
+		{"miss then hit", time.Hour, []bool{false, false}, 1},
+		{"force re-probes", time.Hour, []bool{false, true}, 2},
+		{"force refreshes the entry", time.Hour, []bool{false, true, false}, 2},
+		{"disabled", 0, []bool{false, false}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ffprobe, logFile := customTools(t, testProbe)
+			setVar(t, &ffprobePath, ffprobe)
+			setVar(t, &probeCacheTTL, tt.ttl)
+			store := &probeCacheStore{values: map[string]string{}}
+			rdb := useRedis(t, store.handle)
+			gcsClient, _ := testgcs.Start(t)
+
+			job := models.VideoJob{VideoID: uuid.New(), S3Path: "https://cdn.example.com/source.mp4"}
+			for i, force := range tt.runs {
+				job.ForceProbe = force
+				metadata, err := probeSource(context.Background(), gcsClient, job, job.S3Path)
+				if err != nil {
+					t.Fatalf("run %d: %v", i, err)
+				}
+				if metadata.Width != 1280 || metadata.Duration != 2.0 {
+					t.Errorf("run %d: metadata = %+v, want the probed source", i, metadata)
+				}
+			}
+
+			if got := probeCalls(t, logFile); got != tt.wantCalls {
+				t.Errorf("ffprobe runs = %d, want %d", got, tt.wantCalls)
+			}
+			if tt.ttl <= 0 && len(rdb.Commands()) != 0 {
+				t.Errorf("redis commands = %q, want none with the cache disabled", rdb.Commands())
+			}
+			for _, set := range rdb.Named("SET") {
+				if !strings.HasPrefix(set[1], pubsub.ProbeCachePrefix) {
+					t.Errorf("cached under %q, want the %q prefix", set[1], pubsub.ProbeCachePrefix)
+				}
+			}
+		})
+	}
+}
+
+func TestProbeCacheKey(t *testing.T) {
+	gcsClient, gcs := testgcs.Start(t)
+	gcs.Put("uploads", "a.mp4", []byte("a"))
+	ctx := context.Background()
+
+	remote := probeCacheKey(ctx, gcsClient, models.VideoJob{S3Path: "https://cdn.example.com/a.mp4"})
+	bucketA := probeCacheKey(ctx, gcsClient, models.VideoJob{Bucket: "uploads", S3Path: "a.mp4"})
+	bucketB := probeCacheKey(ctx, gcsClient, models.VideoJob{Bucket: "uploads", S3Path: "b.mp4"})
+
+	if bucketA != probeCacheKey(ctx, gcsClient, models.VideoJob{Bucket: "uploads", S3Path: "/a.mp4"}) {
+		t.Error("a leading slash changed the key for the same object")
+	}
+	if remote == bucketA || bucketA == bucketB {
+		t.Errorf("keys collide: remote=%s a=%s b=%s", remote, bucketA, bucketB)
+	}
+}
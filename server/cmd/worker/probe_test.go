@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakyProbe installs an ffprobe that fails with stderr on its first
+// failures runs and prints testProbe after that. It returns the file that
+// counts the runs.
+func flakyProbe(t *testing.T, failures int, stderr string) string {
+	t.Helper()
+	counter := filepath.Join(t.TempDir(), "attempts")
+	fakeCommand(t, "ffprobe", fmt.Sprintf(`echo x >> '%s'
+if [ "$(wc -l < '%s')" -le %d ]; then
+	echo '%s' >&2
+	exit 1
+fi
+cat <<'PROBE'
+%s
+PROBE`, counter, counter, failures, stderr, testProbe))
+	return counter
+}
+
+func attempts(t *testing.T, counter string) int {
+	t.Helper()
+	data, err := os.ReadFile(counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "\n")
+}
+
+func TestRunFFprobeRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		stderr       string
+		retries      int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"succeeds at once", 0, "", 2, 1, false},
+		{"transient network error", 1, "Connection refused", 2, 2, false},
+		{"server error on every try", 5, "Server returned 503 Service Unavailable", 2, 3, true},
+		{"retries disabled", 1, "Connection refused", 0, 1, true},
+		{"missing file", 1, "source.mp4: No such file or directory", 2, 1, true},
+		{"client error", 1, "Server returned 404 Not Found", 2, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &ffprobePath, "ffprobe")
+			setVar(t, &ffprobeRetries, tt.retries)
+			setVar(t, &ffprobeRetryDelay, time.Millisecond)
+			counter := flakyProbe(t, tt.failures, tt.stderr)
+
+			output, err := runFFprobe(context.Background(), []string{"source.mp4"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("runFFprobe error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := attempts(t, counter); got != tt.wantAttempts {
+				t.Errorf("ran ffprobe %d times, want %d", got, tt.wantAttempts)
+			}
+			if tt.wantErr {
+				if !strings.Contains(err.Error(), tt.stderr) {
+					t.Errorf("error %q does not carry ffprobe's stderr %q", err, tt.stderr)
+				}
+				return
+			}
+			if strings.TrimSpace(string(output)) != testProbe {
+				t.Errorf("output = %q, want the probe", output)
+			}
+		})
+	}
+}
+
+func TestRunFFprobeTimeout(t *testing.T) {
+	setVar(t, &ffprobePath, "ffprobe")
+	setVar(t, &ffprobeTimeout, 100*time.Millisecond)
+	setVar(t, &ffprobeRetries, 1)
+	setVar(t, &ffprobeRetryDelay, time.Millisecond)
+	counter := filepath.Join(t.TempDir(), "attempts")
+	// exec, so the timeout kills the process holding stdout
+	fakeCommand(t, "ffprobe", fmt.Sprintf("echo x >> '%s'\nexec sleep 10", counter))
+
+	start := time.Now()
+	_, err := runFFprobe(context.Background(), []string{"source.mp4"})
+	var timeout *probeTimeoutError
+	if !errors.As(err, &timeout) {
+		t.Fatalf("runFFprobe error = %v, want a probe timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("runFFprobe took %s with a 100ms timeout", elapsed)
+	}
+	if got := attempts(t, counter); got != 2 {
+		t.Errorf("ran ffprobe %d times, want 2 (one retry after the timeout)", got)
+	}
+}
+
+func TestRunFFprobeStopsOnCancel(t *testing.T) {
+	setVar(t, &ffprobePath, "ffprobe")
+	setVar(t, &ffprobeRetries, 5)
+	setVar(t, &ffprobeRetryDelay, time.Hour)
+	flakyProbe(t, 10, "Connection refused")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := runFFprobe(ctx, []string{"source.mp4"}); err == nil {
+		t.Fatal("runFFprobe succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("runFFprobe waited %s for a retry after the job was cancelled", elapsed)
+	}
+}
+
+func TestIsTransientProbeError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&probeTimeoutError{timeout: time.Minute}, true},
+		{fmt.Errorf("ffprobe error: %w", &probeTimeoutError{timeout: time.Minute}), true},
+		{errors.New("tcp: Connection reset by peer"), true},
+		{errors.New("Temporary failure in name resolution"), true},
+		{errors.New("Server returned 502 Bad Gateway"), true},
+		{errors.New("Server returned 403 Forbidden"), false},
+		{errors.New("Invalid data found when processing input"), false},
+	}
+	for _, tt := range tests {
+		if got := isTransientProbeError(tt.err); got != tt.want {
+			t.Errorf("isTransientProbeError(%q) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
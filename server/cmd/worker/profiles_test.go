@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+// RENDITION_PROFILES_FILE is read when the package loads, so the test runs
+// itself again in a child process with the setting in place.
+func TestJobRenditionProfile(t *testing.T) {
+	if os.Getenv("RENDITION_PROFILES_FILE") == "" {
+		path := filepath.Join(t.TempDir(), "profiles.json")
+		profiles := `{"mobile": [{"height": 360, "bitrate": 800}, {"height": 240, "bitrate": 500}]}`
+		if err := os.WriteFile(path, []byte(profiles), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command(os.Args[0], "-test.run=^TestJobRenditionProfile$", "-test.v")
+		cmd.Env = append(os.Environ(), "RENDITION_PROFILES_FILE="+path)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("child test failed: %v\n%s", err, output)
+		}
+		return
+	}
+
+	tests := []struct {
+		name       string
+		job        models.VideoJob
+		wantFilter string
+		wantErr    bool
+	}{
+		{
+			name:       "named profile",
+			job:        models.VideoJob{Profile: "mobile"},
+			wantFilter: "split=2[v1][v2];[v1]scale=-2:360[v1out];[v2]scale=-2:240[v2out]",
+		},
+		{
+			name:       "job ladder wins over the profile",
+			job:        models.VideoJob{Profile: "mobile", Renditions: []models.Rendition{{Height: 480, Bitrate: 1400}}},
+			wantFilter: "split=1[v1];[v1]scale=-2:480[v1out]",
+		},
+		{
+			name:    "unknown profile",
+			job:     models.VideoJob{Profile: "tv"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ffmpeg, ffprobe, logFile := customTools(t, testProbe)
+			setVar(t, &ffmpegPath, ffmpeg)
+			setVar(t, &ffprobePath, ffprobe)
+			setVar(t, &gcsBucket, "videos")
+			useRedis(t, nil)
+			gcsClient, _ := testgcs.Start(t)
+			gormDB, _ := testdb.Open(t, nil)
+
+			job := tt.job
+			job.VideoID, job.S3Path = uuid.New(), "gs://uploads/source.mp4"
+			err := processVideoStreaming(context.Background(), gcsClient, gormDB, job)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("processVideoStreaming error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			data, err := os.ReadFile(logFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), tt.wantFilter) {
+				t.Errorf("transcode does not use the expected ladder %q:\n%s", tt.wantFilter, data)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devrayat000/video-process/models"
+)
+
+// ffmpegProgress is one block of `-progress` output. FFmpeg writes a block
+// of key=value lines every stats period, ending with progress=continue, and
+// a last one ending with progress=end.
+type ffmpegProgress struct {
+	Frame   int64
+	OutTime time.Duration
+	FPS     float64
+	Speed   string
+	End     bool
+}
+
+// readProgress calls emit for every complete -progress block in r. Unknown
+// keys and unparseable values are ignored; "N/A" leaves a field unchanged.
+func readProgress(r io.Reader, emit func(ffmpegProgress)) {
+	scanner := newLineScanner(r)
+
+	var block ffmpegProgress
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "frame":
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				block.Frame = n
+			}
+		case "out_time_us", "out_time_ms":
+			// Despite its name out_time_ms is also in microseconds
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
+				block.OutTime = time.Duration(n) * time.Microsecond
+			}
+		case "fps":
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				block.FPS = f
+			}
+		case "speed":
+			block.Speed = value
+		case "progress":
+			block.End = value == "end"
+			emit(block)
+			block = ffmpegProgress{}
+		}
+	}
+	drainAfterScan(scanner, r, "progress")
+}
+
+// processedFrames returns the frames encoded so far, estimated from the
+// output time when FFmpeg reports no frame count
+func (p ffmpegProgress) processedFrames(video models.Video) int64 {
+	if p.Frame > 0 || video.Duration <= 0 || video.Frames <= 0 {
+		return p.Frame
+	}
+	return min(int64(p.OutTime.Seconds()/video.Duration*float64(video.Frames)), video.Frames)
+}
+
+// publishProgress turns FFmpeg's -progress output on stdout into progress
+// events for the video
+func publishProgress(video models.Video, totalResolutions int, stdout io.ReadCloser) {
+	defer stdout.Close()
+
+	readProgress(stdout, func(p ffmpegProgress) {
+		if p.End {
+			log.Printf(" [>] FFmpeg finished encoding: frame=%d time=%s speed=%s", p.Frame, p.OutTime, p.Speed)
+		}
+
+		progressSink.Publish(models.ProcessingProgress{
+			VideoID:          video.ID,
+			Status:           models.StatusProcessing,
+			ProcessedFrames:  p.processedFrames(video),
+			TotalFrames:      video.Frames,
+			TotalResolutions: totalResolutions,
+			Timestamp:        time.Now(),
+		})
+	})
+}
+
+// captureStderr keeps FFmpeg's diagnostics for error reports. Progress comes
+// from -progress on stdout, so stderr is only read for the tail and the
+// job log.
+func captureStderr(ctx context.Context, stderr io.Reader, tail *stderrTail) {
+	scanner := newLineScanner(stderr)
+	for scanner.Scan() {
+		tail.Add(scanner.Text())
+		publishJobLog(ctx, "[ffmpeg] "+scanner.Text())
+	}
+	drainAfterScan(scanner, stderr, "stderr")
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+// publishedProgress decodes every progress event sent on the video's channel
+func publishedProgress(t *testing.T, publishes [][]string, videoID uuid.UUID) []models.ProcessingProgress {
+	t.Helper()
+	var events []models.ProcessingProgress
+	for _, cmd := range publishes {
+		if cmd[1] != "video:progress:"+videoID.String() {
+			continue
+		}
+		var event models.ProcessingProgress
+		if err := json.Unmarshal([]byte(cmd[2]), &event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestPublishProgressCarriesRenditionCount(t *testing.T) {
+	rdb := useRedis(t, nil)
+	video := models.Video{ID: uuid.New(), Frames: 48}
+
+	stdout := io.NopCloser(strings.NewReader("frame=12\nfps=24.0\nprogress=continue\nframe=48\nprogress=end\n"))
+	publishProgress(video, 3, stdout)
+
+	events := publishedProgress(t, rdb.Named("PUBLISH"), video.ID)
+	if len(events) != 2 {
+		t.Fatalf("events = %+v, want one per -progress block", events)
+	}
+	for i, want := range []int64{12, 48} {
+		e := events[i]
+		if e.ProcessedFrames != want || e.TotalFrames != 48 || e.TotalResolutions != 3 || e.CurrentResolution != 0 {
+			t.Errorf("event %d = %+v, want %d/48 frames across 3 renditions", i, e, want)
+		}
+	}
+}
+
+func TestReadProgress(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []ffmpegProgress
+	}{
+		{
+			name:  "one block",
+			input: "frame=120\nfps=29.97\nout_time_us=4000000\nspeed=1.5x\nprogress=continue\n",
+			want:  []ffmpegProgress{{Frame: 120, FPS: 29.97, OutTime: 4 * time.Second, Speed: "1.5x"}},
+		},
+		{
+			name:  "out_time_ms is microseconds",
+			input: "out_time_ms=2500000\nprogress=end\n",
+			want:  []ffmpegProgress{{OutTime: 2500 * time.Millisecond, End: true}},
+		},
+		{
+			name:  "blocks don't carry over",
+			input: "frame=10\nprogress=continue\nfps=5\nprogress=end\n",
+			want:  []ffmpegProgress{{Frame: 10}, {FPS: 5, End: true}},
+		},
+		{
+			name:  "N/A, negative and unknown values ignored",
+			input: "frame=N/A\nout_time_us=-9223372036854775807\nbitrate=N/A\nstream_0_0_q=28.0\nnoise\nprogress=continue\n",
+			want:  []ffmpegProgress{{}},
+		},
+		{
+			name:  "crlf and padding",
+			input: "frame= 7 \r\nprogress=continue\r\n",
+			want:  []ffmpegProgress{{Frame: 7}},
+		},
+		{
+			name:  "incomplete block not emitted",
+			input: "frame=3\nfps=1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []ffmpegProgress
+			readProgress(strings.NewReader(tt.input), func(p ffmpegProgress) {
+				got = append(got, p)
+			})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readProgress = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessedFrames(t *testing.T) {
+	video := models.Video{Duration: 10, Frames: 250}
+	tests := []struct {
+		name     string
+		progress ffmpegProgress
+		video    models.Video
+		want     int64
+	}{
+		{"reported frames", ffmpegProgress{Frame: 42, OutTime: 9 * time.Second}, video, 42},
+		{"estimated from time", ffmpegProgress{OutTime: 4 * time.Second}, video, 100},
+		{"capped at total", ffmpegProgress{OutTime: 20 * time.Second}, video, 250},
+		{"unknown duration", ffmpegProgress{OutTime: 4 * time.Second}, models.Video{Frames: 250}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.progress.processedFrames(tt.video); got != tt.want {
+				t.Errorf("processedFrames = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPublishProgressKeepsFlowing(t *testing.T) {
+	setVar(t, &ffmpegMaxLineBytes, 4096)
+
+	tests := []struct {
+		name   string
+		output string
+		want   []int64
+	}{
+		{"carriage returns", "frame=10\rprogress=continue\rframe=20\rprogress=continue\rframe=30\rprogress=end\r", []int64{10, 20, 30}},
+		{"crlf", "frame=10\r\nprogress=continue\r\nframe=20\r\nprogress=end\r\n", []int64{10, 20}},
+		{"line longer than the buffer", "frame=10\nprogress=continue\n" + strings.Repeat("x", 10000) + "\nframe=20\nprogress=end\n", []int64{10, 20}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rdb := useRedis(t, nil)
+			video := models.Video{ID: uuid.New(), Frames: 30}
+
+			publishProgress(video, 1, io.NopCloser(strings.NewReader(tt.output)))
+
+			var got []int64
+			for _, e := range publishedProgress(t, rdb.Named("PUBLISH"), video.ID) {
+				got = append(got, e.ProcessedFrames)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("published frames = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPerRenditionProgress(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	useFakeTools(t, testProbe)
+	rdb := useRedis(t, nil)
+	gcsClient, _ := testgcs.Start(t)
+	gormDB, _ := testdb.Open(t, nil)
+
+	job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"}
+	if err := processVideoStreaming(context.Background(), gcsClient, gormDB, job); err != nil {
+		t.Fatal(err)
+	}
+
+	var done []models.ProcessingProgress
+	for _, e := range publishedProgress(t, rdb.Named("PUBLISH"), job.VideoID) {
+		if e.CurrentResolution > 0 {
+			done = append(done, e)
+		}
+	}
+
+	// The 720p source gets the 720p ladder and below, reported in order
+	want := []string{"720p", "480p", "360p", "240p", "144p"}
+	if len(done) != len(want) {
+		t.Fatalf("rendition events = %+v, want %d", done, len(want))
+	}
+	for i, e := range done {
+		if e.CurrentResolution != i+1 || e.TotalResolutions != len(want) || e.Resolution != want[i] {
+			t.Errorf("event %d = %d/%d %q, want %d/%d %q",
+				i, e.CurrentResolution, e.TotalResolutions, e.Resolution, i+1, len(want), want[i])
+		}
+		if e.Status != models.StatusProcessing {
+			t.Errorf("event %d status = %s, want processing", i, e.Status)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"cloud.google.com/go/storage"
+	"github.com/devrayat000/video-process/models"
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// Height of the optional progressive MP4 for clients that can't play HLS.
+// Zero disables it.
+var progressiveMP4Height = server_utils.GetEnvInt("PROGRESSIVE_MP4_HEIGHT", 0)
+
+// pickProgressiveRendition chooses the rate settings for the progressive MP4:
+// the tallest selected rendition not above the requested height, or the
+// smallest one when the source is shorter than that.
+func pickProgressiveRendition(renditions []Rendition, height int) (Rendition, bool) {
+	if height <= 0 || len(renditions) == 0 {
+		return Rendition{}, false
+	}
+
+	var best *Rendition
+	for i := range renditions {
+		r := &renditions[i]
+		if r.Height <= height && (best == nil || r.Height > best.Height) {
+			best = r
+		}
+	}
+	if best == nil {
+		best = &renditions[len(renditions)-1]
+		for i := range renditions {
+			if renditions[i].Height < best.Height {
+				best = &renditions[i]
+			}
+		}
+	}
+
+	return *best, true
+}
+
+// progressiveMP4Args builds a second FFmpeg output that writes a single
+// faststart MP4 from the given filter label, separate from the HLS variants.
+// It uses the same video encoder as the variants. audioArgs are extra
+// options for its audio stream, such as padding.
+func progressiveMP4Args(r Rendition, videoLabel, outputPath, videoEncoder string, fallback bool, channels int, audioArgs []string) []string {
+	args := []string{"-map", videoLabel}
+	args = append(args, videoEncoderArgs(videoEncoder, 0, fallback)...)
+	args = append(args,
+		"-b:v:0", fmt.Sprintf("%dk", r.Bitrate),
+		"-maxrate:v:0", fmt.Sprintf("%dk", r.MaxRate),
+		"-bufsize:v:0", fmt.Sprintf("%dk", r.BufSize),
+	)
+
+	// Always AAC, whatever AUDIO_CODEC the variants use: every MP4 player
+	// decodes it, which isn't true of Opus in MP4
+	args = append(args, "-map", "a:0")
+	args = append(args, audioCodecArgs("aac", 0, audioBitrate(r))...)
+	args = append(args, audioChannelArgs("aac", 0, channels)...)
+	args = append(args, audioArgs...)
+
+	args = append(args, "-movflags", "+faststart")
+	args = append(args, threadArgs(true)...)
+	args = append(args, "-f", "mp4", outputPath)
+	return args
+}
+
+func progressiveMP4Name(height int) string {
+	return fmt.Sprintf("progressive_%dp.mp4", height)
+}
+
+// uploadProgressiveMP4 uploads the progressive MP4 and returns its object key
+func uploadProgressiveMP4(ctx context.Context, bucket *storage.BucketHandle, video models.Video, localPath string, height int) (string, error) {
+	key := video.OutputPrefix() + progressiveMP4Name(height)
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open progressive MP4: %w", err)
+	}
+	defer file.Close()
+
+	writer := bucket.Object(key).NewWriter(ctx)
+	writer.ChunkSize = fileChunkSize(file)
+	writer.ContentType = contentTypeFor(key)
+	// Served straight from storage, so the download name is set on the object
+	writer.ContentDisposition = server_utils.AttachmentDisposition(
+		server_utils.DownloadName(video.OriginalName, fmt.Sprintf("-%dp.mp4", height)), progressiveMP4Name(height))
+	writer.Metadata = objectMetadata(video)
+
+	if _, err := io.Copy(writer, file); err != nil {
+		writer.Close()
+		return "", fmt.Errorf("GCS upload error for %s: %w", key, err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("GCS writer close error for %s: %w", key, err)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestPickProgressiveRendition(t *testing.T) {
+	renditions := []Rendition{
+		{Height: 1080, Bitrate: 5000},
+		{Height: 720, Bitrate: 2800},
+		{Height: 480, Bitrate: 1400},
+	}
+
+	tests := []struct {
+		name       string
+		renditions []Rendition
+		height     int
+		wantHeight int
+		wantOK     bool
+	}{
+		{"disabled", renditions, 0, 0, false},
+		{"no renditions", nil, 720, 0, false},
+		{"exact match", renditions, 720, 720, true},
+		{"tallest not above", renditions, 900, 720, true},
+		{"above every rendition", renditions, 2160, 1080, true},
+		{"below every rendition", renditions, 360, 480, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, ok := pickProgressiveRendition(tt.renditions, tt.height)
+			if ok != tt.wantOK || r.Height != tt.wantHeight {
+				t.Errorf("pickProgressiveRendition = %d, %v, want %d, %v", r.Height, ok, tt.wantHeight, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestProgressiveMP4Args(t *testing.T) {
+	r := Rendition{Height: 720, Bitrate: 2800, MaxRate: 2996, BufSize: 4200, AudioRate: 128}
+	video := func(encoderArgs ...string) []string {
+		return slices.Concat([]string{"-map", "[vpout]"}, encoderArgs, []string{
+			"-b:v:0", "2800k",
+			"-maxrate:v:0", "2996k",
+			"-bufsize:v:0", "4200k",
+		})
+	}
+	audio := []string{
+		"-map", "a:0",
+		"-c:a:0", "aac",
+		"-b:a:0", "128k",
+		"-profile:a:0", "aac_low",
+		"-ac:a:0", "2",
+	}
+	tail := []string{"-movflags", "+faststart", "-f", "mp4", "/tmp/job/progressive_720p.mp4"}
+	cpu := video("-c:v:0", "libx264", "-preset:v:0", "ultrafast")
+
+	tests := []struct {
+		name      string
+		encoder   string
+		fallback  bool
+		audioArgs []string
+		want      []string
+	}{
+		{"plain", cpuVideoEncoder, false, nil, slices.Concat(cpu, audio, tail)},
+		{"padded audio", cpuVideoEncoder, false, []string{"-filter:a", "apad=whole_dur=10.000"}, slices.Concat(cpu, audio, []string{"-filter:a", "apad=whole_dur=10.000"}, tail)},
+		{"fallback", cpuVideoEncoder, true, nil, slices.Concat(video("-c:v:0", "libx264", "-preset:v:0", "fast"), audio, tail)},
+		{"gpu", gpuVideoEncoder, false, nil, slices.Concat(video("-c:v:0", "h264_nvenc", "-preset:v:0", "p1", "-no-scenecut:v:0", "1"), audio, tail)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &sceneCut, false)
+			got := progressiveMP4Args(r, "[vpout]", "/tmp/job/progressive_720p.mp4", tt.encoder, tt.fallback, 2, tt.audioArgs)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("progressiveMP4Args = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadProgressiveMP4(t *testing.T) {
+	gcsClient, store := testgcs.Start(t)
+
+	localPath := filepath.Join(t.TempDir(), progressiveMP4Name(720))
+	if err := os.WriteFile(localPath, []byte("mp4 data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	video := models.Video{ID: uuid.New(), OriginalName: "holiday.mov"}
+	key, err := uploadProgressiveMP4(context.Background(), gcsClient.Bucket("videos"), video, localPath, 720)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := video.ID.String() + "/processed/progressive_720p.mp4"; key != want {
+		t.Errorf("key = %q, want %q", key, want)
+	}
+
+	obj, ok := store.Get("videos", key)
+	if !ok {
+		t.Fatal("progressive MP4 not uploaded")
+	}
+	if obj.ContentType != "video/mp4" {
+		t.Errorf("ContentType = %q, want video/mp4", obj.ContentType)
+	}
+	if string(obj.Data) != "mp4 data" {
+		t.Errorf("Data = %q", obj.Data)
+	}
+	if want := `attachment; filename="holiday-720p.mp4"`; obj.ContentDisposition != want {
+		t.Errorf("ContentDisposition = %q, want %q", obj.ContentDisposition, want)
+	}
+	if obj.Metadata["video-id"] != video.ID.String() || obj.Metadata["original-name"] != "holiday.mov" {
+		t.Errorf("Metadata = %v, want the video id and original name", obj.Metadata)
+	}
+}
+
+func TestProgressiveMP4ArgsWithThreads(t *testing.T) {
+	setVar(t, &ffmpegThreads, 2)
+	args := progressiveMP4Args(Rendition{Height: 720, Bitrate: 2800}, "[vpout]", "out.mp4", cpuVideoEncoder, false, 1, nil)
+	want := []string{"-ac:a:0", "1", "-movflags", "+faststart", "-threads", "2", "-f", "mp4", "out.mp4"}
+	if !slices.Equal(args[len(args)-len(want):], want) {
+		t.Errorf("progressiveMP4Args ends with %q, want %q", args[len(args)-len(want):], want)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/devrayat000/video-process/models"
+	"github.com/devrayat000/video-process/outbox"
+	"github.com/devrayat000/video-process/pubsub"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// How often to look for waiting videos whose job was lost; zero disables
+	reconcileInterval = server_utils.GetEnvDuration("RECONCILE_INTERVAL", 5*time.Minute)
+	// A waiting video must be untouched this long before it is re-enqueued
+	reconcileMinAge = server_utils.GetEnvDuration("RECONCILE_MIN_AGE", 15*time.Minute)
+	// Upper bound on re-enqueued jobs per pass
+	reconcileBatchSize = server_utils.GetEnvInt("RECONCILE_BATCH_SIZE", 20)
+)
+
+// runReconciler periodically re-enqueues videos stuck in waiting, for
+// example after a crash between the DB insert and the stream write.
+func runReconciler(ctx context.Context, gormDB *gorm.DB) {
+	if reconcileInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := reconcileWaitingVideos(ctx, gormDB)
+		if err != nil {
+			log.Printf(" [!] Reconciler: %v", err)
+		} else if n > 0 {
+			log.Printf(" [i] Reconciler re-enqueued %d job(s)", n)
+		}
+	}
+}
+
+// reconcileWaitingVideos re-enqueues up to reconcileBatchSize stale waiting
+// videos that have neither an outbox entry nor a live stream entry.
+func reconcileWaitingVideos(ctx context.Context, gormDB *gorm.DB) (int, error) {
+	queued, err := pubsub.QueuedVideoIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var entries []*models.OutboxEntry
+	err = gormDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// SKIP LOCKED keeps other workers' reconcilers off the same rows
+		var stale []models.Video
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND updated_at < ?", models.StatusWaiting, time.Now().Add(-reconcileMinAge)).
+			Where("NOT EXISTS (SELECT 1 FROM job_outbox WHERE job_outbox.video_id = videos.id)").
+			Order("updated_at").Limit(reconcileBatchSize * 2).Find(&stale).Error
+		if err != nil {
+			return err
+		}
+
+		for _, video := range findUnqueued(stale, queued, reconcileBatchSize) {
+			entry, err := outbox.Add(ctx, tx, video.Job())
+			if err != nil {
+				return err
+			}
+			// Touch the row so it isn't picked again before reconcileMinAge
+			if err := tx.Model(&models.Video{}).Where("id = ?", video.ID).Update("updated_at", time.Now()).Error; err != nil {
+				return err
+			}
+			log.Printf(" [i] Re-enqueueing stuck video %s", video.ID)
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		// Failures stay in the outbox for the API relay
+		if _, err := outbox.Dispatch(ctx, gormDB, entry); err != nil {
+			log.Printf(" [!] Reconciler enqueue failed for %s: %v", entry.VideoID, err)
+		}
+	}
+
+	return len(entries), nil
+}
+
+// findUnqueued returns up to limit videos that have no live stream entry
+func findUnqueued(videos []models.Video, queued map[string]bool, limit int) []models.Video {
+	var result []models.Video
+	for _, video := range videos {
+		if len(result) >= limit {
+			break
+		}
+		if !queued[video.ID.String()] {
+			result = append(result, video)
+		}
+	}
+	return result
+}
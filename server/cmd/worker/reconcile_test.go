@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testredis"
+	"github.com/devrayat000/video-process/models"
+	"github.com/devrayat000/video-process/pubsub"
+	"github.com/google/uuid"
+)
+
+func TestFindUnqueued(t *testing.T) {
+	a, b, c := uuid.New(), uuid.New(), uuid.New()
+	videos := []models.Video{{ID: a}, {ID: b}, {ID: c}}
+
+	tests := []struct {
+		name   string
+		queued map[string]bool
+		limit  int
+		want   []uuid.UUID
+	}{
+		{"nothing queued", nil, 10, []uuid.UUID{a, b, c}},
+		{"skips queued videos", map[string]bool{b.String(): true}, 10, []uuid.UUID{a, c}},
+		{"everything queued", map[string]bool{a.String(): true, b.String(): true, c.String(): true}, 10, nil},
+		{"bounded by the limit", nil, 2, []uuid.UUID{a, b}},
+		{"limit counts only unqueued videos", map[string]bool{a.String(): true}, 1, []uuid.UUID{b}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []uuid.UUID
+			for _, v := range findUnqueued(videos, tt.queued, tt.limit) {
+				got = append(got, v.ID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("findUnqueued = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// jobsStream answers the reconciler's stream inspection with the given jobs
+// waiting undelivered, and accepts every XADD
+func jobsStream(queued ...uuid.UUID) testredis.Handler {
+	entries := []any{}
+	for i, id := range queued {
+		data, _ := json.Marshal(models.VideoJob{VideoID: id})
+		entries = append(entries, []any{
+			strings.Repeat("1", i+1) + "-0",
+			[]any{"video_id", id.String(), "data", string(data)},
+		})
+	}
+	return func(cmd []string) any {
+		switch cmd[0] {
+		case "xinfo":
+			return []any{[]any{"name", pubsub.ConsumerGroup, "last-delivered-id", "0-0"}}
+		case "xrange":
+			return entries
+		case "xpending":
+			return []any{}
+		case "xadd":
+			return "9-0"
+		}
+		return testredis.Status("OK")
+	}
+}
+
+// reconcileDB serves stale waiting videos and keeps outbox entries the way
+// the table would
+func reconcileDB(stale []models.Video) testdb.Handler {
+	var mu sync.Mutex
+	var payloads []string
+	return func(q testdb.Query) testdb.Result {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.HasPrefix(q.SQL, `SELECT * FROM "videos"`):
+			rows := make([][]any, len(stale))
+			for i, v := range stale {
+				rows[i] = []any{v.ID.String(), string(models.StatusWaiting), v.S3Path, v.OriginalName}
+			}
+			return testdb.Result{Columns: []string{"id", "status", "s3_path", "original_name"}, Rows: rows}
+		case strings.HasPrefix(q.SQL, `INSERT INTO "job_outbox"`):
+			for _, arg := range q.Args {
+				if s, ok := arg.(string); ok && strings.HasPrefix(s, "{") {
+					payloads = append(payloads, s)
+				}
+			}
+			return testdb.Result{Columns: []string{"id"}, Rows: [][]any{{int64(len(payloads))}}, RowsAffected: 1}
+		case strings.HasPrefix(q.SQL, `SELECT * FROM "job_outbox"`):
+			id := q.Args[0].(int64)
+			return testdb.Result{
+				Columns: []string{"id", "payload"},
+				Rows:    [][]any{{id, payloads[id-1]}},
+			}
+		}
+		return testdb.Result{RowsAffected: 1}
+	}
+}
+
+func TestReconcileWaitingVideos(t *testing.T) {
+	setVar(t, &reconcileBatchSize, 5)
+	setVar(t, &reconcileMinAge, 15*time.Minute)
+
+	lost := models.Video{ID: uuid.New(), S3Path: "gs://videos/lost.mp4", OriginalName: "lost.mp4"}
+	alsoLost := models.Video{ID: uuid.New(), S3Path: "gs://videos/also.mp4"}
+	stillQueued := models.Video{ID: uuid.New(), S3Path: "gs://videos/queued.mp4"}
+
+	rdb := useRedis(t, jobsStream(stillQueued.ID))
+	gormDB, db := testdb.Open(t, reconcileDB([]models.Video{lost, stillQueued, alsoLost}))
+
+	n, err := reconcileWaitingVideos(context.Background(), gormDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("re-enqueued %d videos, want 2", n)
+	}
+
+	// Only stale waiting videos without an outbox entry, oldest first
+	selects := db.Matching(`SELECT * FROM "videos"`)
+	if len(selects) != 1 {
+		t.Fatalf("video queries = %v", selects)
+	}
+	for _, fragment := range []string{
+		"status = $1 AND updated_at < $2",
+		"NOT EXISTS (SELECT 1 FROM job_outbox WHERE job_outbox.video_id = videos.id)",
+		"ORDER BY updated_at LIMIT $3 FOR UPDATE SKIP LOCKED",
+	} {
+		if !strings.Contains(selects[0].SQL, fragment) {
+			t.Errorf("query %q is missing %q", selects[0].SQL, fragment)
+		}
+	}
+	if cutoff, ok := selects[0].Args[1].(time.Time); !ok || time.Since(cutoff) < 15*time.Minute {
+		t.Errorf("cutoff = %v, want at least RECONCILE_MIN_AGE ago", selects[0].Args[1])
+	}
+
+	var enqueued []string
+	for _, cmd := range rdb.Named("XADD") {
+		enqueued = append(enqueued, strings.Join(cmd, " "))
+	}
+	if len(enqueued) != 2 {
+		t.Fatalf("XADD calls = %d, want 2", len(enqueued))
+	}
+	for i, video := range []models.Video{lost, alsoLost} {
+		if !strings.Contains(enqueued[i], video.ID.String()) || !strings.Contains(enqueued[i], video.S3Path) {
+			t.Errorf("job %d = %q, want video %s from %s", i, enqueued[i], video.ID, video.S3Path)
+		}
+	}
+	for _, cmd := range enqueued {
+		if strings.Contains(cmd, stillQueued.ID.String()) {
+			t.Error("video with a live stream entry was enqueued again")
+		}
+	}
+
+	// Re-enqueued rows are touched so the next pass leaves them alone
+	if touched := db.Matching(`UPDATE "videos" SET "updated_at"`); len(touched) != 2 {
+		t.Errorf("touched %d videos, want 2", len(touched))
+	}
+}
+
+func TestReconcileWaitingVideosBatchLimit(t *testing.T) {
+	setVar(t, &reconcileBatchSize, 2)
+
+	var stale []models.Video
+	for range 4 {
+		stale = append(stale, models.Video{ID: uuid.New(), S3Path: "gs://videos/a.mp4"})
+	}
+	rdb := useRedis(t, jobsStream())
+	gormDB, db := testdb.Open(t, reconcileDB(stale))
+
+	n, err := reconcileWaitingVideos(context.Background(), gormDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || len(rdb.Named("XADD")) != 2 || len(db.Matching(`INSERT INTO "job_outbox"`)) != 2 {
+		t.Errorf("re-enqueued %d (%d XADDs), want the batch size 2", n, len(rdb.Named("XADD")))
+	}
+}
+
+func TestReconcileSkipsWhenStreamUnreadable(t *testing.T) {
+	useRedis(t, func(cmd []string) any { return testredis.Status("OK") })
+	gormDB, db := testdb.Open(t, nil)
+
+	if _, err := reconcileWaitingVideos(context.Background(), gormDB); err == nil {
+		t.Error("expected an error when the stream can't be inspected")
+	}
+	if len(db.Queries()) != 0 {
+		t.Errorf("reconciler touched the database without knowing what is queued: %v", db.Queries())
+	}
+}
+
+func TestProcessSkipsCompletedVideo(t *testing.T) {
+	useRedis(t, nil)
+	id := uuid.New()
+	gormDB, db := testdb.Open(t, func(q testdb.Query) testdb.Result {
+		return testdb.Result{
+			Columns: []string{"id", "status"},
+			Rows:    [][]any{{id.String(), string(models.StatusCompleted)}},
+		}
+	})
+
+	if err := processVideoStreaming(context.Background(), nil, gormDB, models.VideoJob{VideoID: id, S3Path: "gs://videos/a.mp4"}); err != nil {
+		t.Fatal(err)
+	}
+	if q := db.Queries(); len(q) != 1 {
+		t.Errorf("completed video was processed again: %v", q)
+	}
+}
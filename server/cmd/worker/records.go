@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// recordOutput stores the rendition rows and the video's playlist fields in
+// one transaction, so a video never points at a master playlist whose
+// renditions are missing from the database. Rows left by an earlier delivery
+// of the same job are replaced, unless they already match, in which case
+// nothing is written.
+func recordOutput(ctx context.Context, gormDB *gorm.DB, videoID uuid.UUID, resolutions []models.VideoResolution, updates models.Video) error {
+	if recorded, err := outputRecorded(ctx, gormDB, videoID, resolutions, updates); err != nil {
+		logf(ctx, " [!] Failed to compare recorded output of %s: %v", videoID, err)
+	} else if recorded {
+		logf(ctx, " [i] Output of %s is already recorded", videoID)
+		return nil
+	}
+
+	return gormDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("video_id = ?", videoID).Delete(&models.VideoResolution{}).Error; err != nil {
+			return err
+		}
+		if len(resolutions) > 0 {
+			if err := tx.Create(&resolutions).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.Video{}).Where("id = ?", videoID).Updates(updates).Error
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestRecordOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		failOn   string // statement prefix that returns an error
+		wantStmt []string
+	}{
+		// Each case first finds nothing recorded yet, so the output is written
+		{
+			name:     "commits",
+			wantStmt: []string{`SELECT * FROM "videos"`, "BEGIN", `DELETE FROM "video_resolutions"`, `INSERT INTO "video_resolutions"`, `UPDATE "videos"`, "COMMIT"},
+		},
+		{
+			name:     "stale rows can't be cleared",
+			failOn:   `DELETE FROM "video_resolutions"`,
+			wantStmt: []string{`SELECT * FROM "videos"`, "BEGIN", `DELETE FROM "video_resolutions"`, "ROLLBACK"},
+		},
+		{
+			name:     "renditions can't be inserted",
+			failOn:   `INSERT INTO "video_resolutions"`,
+			wantStmt: []string{`SELECT * FROM "videos"`, "BEGIN", `DELETE FROM "video_resolutions"`, `INSERT INTO "video_resolutions"`, "ROLLBACK"},
+		},
+		{
+			name:     "master playlist can't be recorded",
+			failOn:   `UPDATE "videos"`,
+			wantStmt: []string{`SELECT * FROM "videos"`, "BEGIN", `DELETE FROM "video_resolutions"`, `INSERT INTO "video_resolutions"`, `UPDATE "videos"`, "ROLLBACK"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDB, db := testdb.Open(t, func(q testdb.Query) testdb.Result {
+				if tt.failOn != "" && strings.HasPrefix(q.SQL, tt.failOn) {
+					return testdb.Result{Err: errors.New("connection reset")}
+				}
+				return testdb.Result{RowsAffected: 1}
+			})
+
+			videoID := uuid.New()
+			resolutions := []models.VideoResolution{
+				{ID: uuid.New(), VideoID: videoID, Resolution: "720p"},
+				{ID: uuid.New(), VideoID: videoID, Resolution: "480p"},
+			}
+			err := recordOutput(context.Background(), gormDB, videoID, resolutions, models.Video{MasterPlaylistKey: ptr("master.m3u8")})
+			if (err != nil) != (tt.failOn != "") {
+				t.Fatalf("recordOutput error = %v, want failure %v", err, tt.failOn != "")
+			}
+
+			queries := db.Queries()
+			if len(queries) != len(tt.wantStmt) {
+				t.Fatalf("ran %d statements, want %d: %v", len(queries), len(tt.wantStmt), queries)
+			}
+			for i, q := range queries {
+				if !strings.HasPrefix(q.SQL, tt.wantStmt[i]) {
+					t.Errorf("statement %d = %q, want %q", i, q.SQL, tt.wantStmt[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// Longest FFmpeg output line kept whole; longer ones are split into pieces
+// of this size instead of stopping the reader
+var ffmpegMaxLineBytes = max(server_utils.GetEnvInt("FFMPEG_MAX_LINE_BYTES", 1024*1024), 4096)
+
+// newLineScanner reads FFmpeg output line by line. FFmpeg redraws status
+// lines with a bare \r, so both \r and \n end a line; the \r\n pair yields an
+// extra empty line that callers skip.
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, min(64*1024, ffmpegMaxLineBytes)), ffmpegMaxLineBytes)
+	scanner.Split(scanOutputLines)
+	return scanner
+}
+
+// scanOutputLines is a bufio.SplitFunc for \r- or \n-terminated lines that
+// hands over a full buffer as a line of its own rather than failing with
+// bufio.ErrTooLong
+func scanOutputLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF || len(data) >= ffmpegMaxLineBytes {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// drainAfterScan discards the rest of r when the scanner stopped on a read
+// error, so FFmpeg never blocks writing to a pipe nobody reads
+func drainAfterScan(scanner *bufio.Scanner, r io.Reader, name string) {
+	if err := scanner.Err(); err != nil {
+		log.Printf(" [!] Stopped reading FFmpeg %s: %v", name, err)
+		io.Copy(io.Discard, r)
+	}
+}
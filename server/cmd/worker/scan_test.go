@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScanOutputLines(t *testing.T) {
+	defer func(n int) { ffmpegMaxLineBytes = n }(ffmpegMaxLineBytes)
+	ffmpegMaxLineBytes = 8
+
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"empty", "", nil},
+		{"newlines", "a\nb\n", []string{"a", "b"}},
+		{"carriage returns", "frame=1\rframe=2\r", []string{"frame=1", "frame=2"}},
+		{"crlf adds an empty line", "a\r\nb", []string{"a", "", "b"}},
+		{"unterminated last line", "a\nbc", []string{"a", "bc"}},
+		{"long line split", "0123456789abcdef01\n", []string{"01234567", "89abcdef", "01"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scanner := newLineScanner(strings.NewReader(tt.input))
+			var got []string
+			for scanner.Scan() {
+				got = append(got, scanner.Text())
+			}
+			if err := scanner.Err(); err != nil {
+				t.Fatalf("scanner error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lines = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/devrayat000/video-process/pubsub"
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// jobClass separates big jobs that benefit from a GPU encoder from small ones
+// that are fine on the CPU.
+type jobClass string
+
+const (
+	jobLight jobClass = "light"
+	jobHeavy jobClass = "heavy"
+)
+
+var (
+	// A job is heavy when the source reaches either threshold
+	heavyJobMinHeight   = server_utils.GetEnvInt("HEAVY_JOB_MIN_HEIGHT", 1440)
+	heavyJobMinDuration = server_utils.GetEnvInt("HEAVY_JOB_MIN_DURATION", 1800) // in seconds
+
+	// NVENC slots reserved for heavy jobs; zero keeps everything on the CPU
+	gpuEncoderSlots = server_utils.GetEnvInt("GPU_ENCODER_SLOTS", 0)
+	cpuEncoderSlots = server_utils.GetEnvInt("CPU_ENCODER_SLOTS", pubsub.WorkerConcurrency)
+)
+
+const (
+	cpuVideoEncoder = "libx264"
+	gpuVideoEncoder = "h264_nvenc"
+)
+
+var (
+	gpuSlots = newEncoderSlots(gpuEncoderSlots)
+	cpuSlots = newEncoderSlots(cpuEncoderSlots)
+)
+
+// newEncoderSlots returns a counting semaphore, or nil for "unbounded"
+func newEncoderSlots(n int) chan struct{} {
+	if n <= 0 {
+		return nil
+	}
+	return make(chan struct{}, n)
+}
+
+// classifyJob marks a job heavy or light from its probed size
+func classifyJob(metadata *VideoMetadata) jobClass {
+	if metadata.Height >= heavyJobMinHeight || metadata.Duration >= float64(heavyJobMinDuration) {
+		return jobHeavy
+	}
+	return jobLight
+}
+
+// acquireEncoder waits for an encoder slot suited to the job class and returns
+// the video encoder to use plus a release func. Heavy jobs take a GPU slot when
+// GPUs are configured; everything else competes for CPU slots, so a flood of
+// small jobs can never hold the GPU slots.
+func acquireEncoder(ctx context.Context, class jobClass) (string, func(), error) {
+	encoder, slots := cpuVideoEncoder, cpuSlots
+	if class == jobHeavy && gpuSlots != nil {
+		encoder, slots = gpuVideoEncoder, gpuSlots
+	}
+
+	if slots == nil {
+		return encoder, func() {}, nil
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return encoder, func() { <-slots }, nil
+	case <-ctx.Done():
+		return "", nil, fmt.Errorf("waiting for %s encoder slot: %w", encoder, ctx.Err())
+	}
+}
+
+// videoEncoderArgs returns the encoder and speed preset flags for the video
+// output stream at index. NVENC uses its own preset names. A fallback attempt
+// trades speed for a more conservative preset.
+func videoEncoderArgs(encoder string, index int, fallback bool) []string {
+	args := []string{fmt.Sprintf("-c:v:%d", index), encoder}
+
+	switch encoder {
+	case gpuVideoEncoder:
+		preset := "p1"
+		if fallback {
+			preset = "p4"
+		}
+		args = append(args, fmt.Sprintf("-preset:v:%d", index), preset)
+		if !sceneCut {
+			args = append(args, fmt.Sprintf("-no-scenecut:v:%d", index), "1")
+		}
+	default:
+		preset := "ultrafast"
+		if fallback {
+			preset = "fast"
+		}
+		args = append(args, fmt.Sprintf("-preset:v:%d", index), preset)
+	}
+
+	return args
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClassifyJob(t *testing.T) {
+	setVar(t, &heavyJobMinHeight, 1440)
+	setVar(t, &heavyJobMinDuration, 1800)
+
+	tests := []struct {
+		name     string
+		height   int
+		duration float64
+		want     jobClass
+	}{
+		{"short 360p clip", 360, 30, jobLight},
+		{"just under both thresholds", 1439, 1799.9, jobLight},
+		{"height at threshold", 1440, 30, jobHeavy},
+		{"4K clip", 2160, 10, jobHeavy},
+		{"duration at threshold", 720, 1800, jobHeavy},
+		{"long 480p recording", 480, 7200, jobHeavy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyJob(&VideoMetadata{Height: tt.height, Duration: tt.duration})
+			if got != tt.want {
+				t.Errorf("classifyJob(%dp, %.1fs) = %s, want %s", tt.height, tt.duration, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAcquireEncoder(t *testing.T) {
+	tests := []struct {
+		name  string
+		gpu   int
+		class jobClass
+		want  string
+	}{
+		{"light job without GPUs", 0, jobLight, cpuVideoEncoder},
+		{"heavy job without GPUs", 0, jobHeavy, cpuVideoEncoder},
+		{"light job with GPUs", 1, jobLight, cpuVideoEncoder},
+		{"heavy job with GPUs", 1, jobHeavy, gpuVideoEncoder},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &gpuSlots, newEncoderSlots(tt.gpu))
+			setVar(t, &cpuSlots, newEncoderSlots(1))
+
+			encoder, release, err := acquireEncoder(context.Background(), tt.class)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer release()
+			if encoder != tt.want {
+				t.Errorf("encoder = %s, want %s", encoder, tt.want)
+			}
+		})
+	}
+}
+
+func TestLightJobsCannotTakeGPUSlots(t *testing.T) {
+	setVar(t, &gpuSlots, newEncoderSlots(1))
+	setVar(t, &cpuSlots, newEncoderSlots(1))
+
+	_, releaseLight, err := acquireEncoder(context.Background(), jobLight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseLight()
+
+	// The only CPU slot is busy, but a heavy job still gets the GPU
+	encoder, releaseHeavy, err := acquireEncoder(context.Background(), jobHeavy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseHeavy()
+	if encoder != gpuVideoEncoder {
+		t.Errorf("heavy encoder = %s, want %s", encoder, gpuVideoEncoder)
+	}
+
+	// A second light job waits for the CPU slot rather than using the idle GPU
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := acquireEncoder(ctx, jobLight); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("second light job error = %v, want it to wait for a CPU slot", err)
+	}
+}
+
+func TestAcquireEncoderReleasesSlot(t *testing.T) {
+	setVar(t, &gpuSlots, nil)
+	setVar(t, &cpuSlots, newEncoderSlots(1))
+
+	_, release, err := acquireEncoder(context.Background(), jobLight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, release, err = acquireEncoder(ctx, jobLight)
+	if err != nil {
+		t.Fatalf("slot was not released: %v", err)
+	}
+	release()
+}
+
+func TestVideoEncoderArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoder  string
+		fallback bool
+		want     []string
+	}{
+		{"cpu", cpuVideoEncoder, false, []string{"-c:v:1", "libx264", "-preset:v:1", "ultrafast"}},
+		{"gpu", gpuVideoEncoder, false, []string{"-c:v:1", "h264_nvenc", "-preset:v:1", "p1", "-no-scenecut:v:1", "1"}},
+		{"cpu fallback", cpuVideoEncoder, true, []string{"-c:v:1", "libx264", "-preset:v:1", "fast"}},
+		{"gpu fallback", gpuVideoEncoder, true, []string{"-c:v:1", "h264_nvenc", "-preset:v:1", "p4", "-no-scenecut:v:1", "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := videoEncoderArgs(tt.encoder, 1, tt.fallback); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("videoEncoderArgs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequiredEncodersWithGPU(t *testing.T) {
+	setVar(t, &audioCodec, "aac")
+
+	setVar(t, &gpuSlots, nil)
+	if got, want := requiredEncoders(), []string{"libx264", "aac"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("requiredEncoders without GPUs = %q, want %q", got, want)
+	}
+
+	setVar(t, &gpuSlots, newEncoderSlots(1))
+	if got, want := requiredEncoders(), []string{"libx264", "aac", "h264_nvenc"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("requiredEncoders with GPUs = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// How long running jobs may keep going after SIGTERM before FFmpeg is
+// killed; zero cancels them straight away. The job is redelivered after a
+// restart either way, since it was never acknowledged.
+var shutdownGracePeriod = server_utils.GetEnvDuration("SHUTDOWN_GRACE_PERIOD", 0)
+
+// drainJobs cancels running jobs once the grace period is over, or right
+// away on a second signal.
+func drainJobs(signals <-chan os.Signal, cancelJobs context.CancelFunc, grace time.Duration) {
+	if grace <= 0 {
+		cancelJobs()
+		return
+	}
+
+	log.Printf("Letting running jobs finish for up to %s (signal again to stop now)", grace)
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		log.Println("Grace period over, cancelling running jobs")
+	case <-signals:
+		log.Println("Second signal received, cancelling running jobs")
+	}
+	cancelJobs()
+}
+
+// interrupted reports whether a job's context was cancelled by shutdown, as
+// opposed to hitting its timeout. Such a job stays pending and is
+// redelivered, so it must not be failed or cleaned up.
+func interrupted(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.Canceled)
+}
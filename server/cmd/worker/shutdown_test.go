@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestDrainJobs(t *testing.T) {
+	tests := []struct {
+		name       string
+		grace      time.Duration
+		signal     bool
+		wantBefore time.Duration
+	}{
+		{"no grace period", 0, false, time.Second},
+		{"grace period runs out", 50 * time.Millisecond, false, 5 * time.Second},
+		{"second signal", time.Hour, true, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signals := make(chan os.Signal, 1)
+			jobCtx, cancelJobs := context.WithCancel(context.Background())
+			defer cancelJobs()
+
+			go drainJobs(signals, cancelJobs, tt.grace)
+			if tt.signal {
+				select {
+				case <-jobCtx.Done():
+					t.Fatal("jobs cancelled before the grace period or a second signal")
+				case <-time.After(50 * time.Millisecond):
+				}
+				signals <- syscall.SIGTERM
+			}
+
+			select {
+			case <-jobCtx.Done():
+			case <-time.After(tt.wantBefore):
+				t.Fatal("running jobs were never cancelled")
+			}
+		})
+	}
+}
+
+func TestCancelStopsRunningJob(t *testing.T) {
+	useFakeTools(t, testProbe)
+	// exec so the kill reaches the long-running process itself
+	fakeCommand(t, "ffmpeg", "exec sleep 30")
+	setVar(t, &ffmpegPath, "ffmpeg")
+	setVar(t, &ffprobePath, "ffprobe")
+	setVar(t, &gcsBucket, "videos")
+	useRedis(t, nil)
+	gcsClient, _ := testgcs.Start(t)
+	gormDB, db := testdb.Open(t, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"}
+		result <- processVideoStreaming(ctx, gcsClient, gormDB, job)
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("cancelled job reported success")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("cancelling the parent context did not stop FFmpeg")
+	}
+	// The job is redelivered, so it is neither failed nor cleaned up
+	if failed := db.Matching(`"failure_category"`); len(failed) != 0 {
+		t.Errorf("interrupted job marked failed: %v", failed)
+	}
+}
+
+func TestInterrupted(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	timedOut, cancelTimeout := context.WithTimeout(context.Background(), 0)
+	defer cancelTimeout()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want bool
+	}{
+		{"running", context.Background(), false},
+		{"cancelled by shutdown", cancelled, true},
+		{"timed out", timedOut, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := interrupted(tt.ctx); got != tt.want {
+				t.Errorf("interrupted = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailVideoAfterShutdownOrTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		ctx        func() (context.Context, context.CancelFunc)
+		wantFailed bool
+	}{
+		{"shutdown", func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			return ctx, cancel
+		}, false},
+		{"timeout", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 0)
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rdb := useRedis(t, nil)
+			gormDB, db := testdb.Open(t, func(q testdb.Query) testdb.Result {
+				return testdb.Result{RowsAffected: 1}
+			})
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			failVideo(ctx, gormDB, uuid.New(), "boom", errors.New("boom"))
+
+			if failed := len(db.Matching(`"failure_category"`)) == 1; failed != tt.wantFailed {
+				t.Errorf("video marked failed = %v, want %v", failed, tt.wantFailed)
+			}
+			if published := len(rdb.Named("PUBLISH")) > 0; published != tt.wantFailed {
+				t.Errorf("failure published = %v, want %v", published, tt.wantFailed)
+			}
+		})
+	}
+}
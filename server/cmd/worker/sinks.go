@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/devrayat000/video-process/models"
+	"github.com/devrayat000/video-process/pubsub"
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// PROGRESS_SINKS lists where progress events go, comma-separated: "redis"
+// feeds the API's progress endpoints and SSE, "log" writes each event to the
+// worker log. Job webhooks are always called on top.
+var progressSinkNames = server_utils.GetEnv("PROGRESS_SINKS", "redis")
+
+// progressSink is what the worker publishes progress to, set up at startup
+var progressSink pubsub.ProgressSink = pubsub.RedisProgressSink{}
+
+// newProgressSink builds the composite sink for a PROGRESS_SINKS value
+func newProgressSink(names string) (pubsub.ProgressSink, error) {
+	var sinks pubsub.ProgressSinks
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		switch name {
+		case "redis":
+			sinks = append(sinks, pubsub.RedisProgressSink{})
+		case "log":
+			sinks = append(sinks, logProgressSink{})
+		default:
+			return nil, fmt.Errorf("unsupported progress sink %q in PROGRESS_SINKS (expected redis or log)", name)
+		}
+	}
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("PROGRESS_SINKS must name at least one sink")
+	}
+	// Webhooks were asked for by the job's submitter, not the operator
+	return append(sinks, webhooks), nil
+}
+
+// logProgressSink writes progress events to the worker log
+type logProgressSink struct{}
+
+func (logProgressSink) Publish(p models.ProcessingProgress) error {
+	switch {
+	case p.Error != "":
+		log.Printf(" [progress] %s %s: %s (%s)", p.VideoID, p.Status, p.Error, p.ErrorCategory)
+	case p.Resolution != "":
+		log.Printf(" [progress] %s %s: %s ready (%d/%d)", p.VideoID, p.Status, p.Resolution, p.CurrentResolution, p.TotalResolutions)
+	case p.TotalFrames > 0:
+		log.Printf(" [progress] %s %s: %d/%d frames", p.VideoID, p.Status, p.ProcessedFrames, p.TotalFrames)
+	default:
+		log.Printf(" [progress] %s %s", p.VideoID, p.Status)
+	}
+	return nil
+}
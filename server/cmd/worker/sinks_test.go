@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/devrayat000/video-process/pubsub"
+	"github.com/google/uuid"
+)
+
+// captureSink records the progress events the worker publishes
+type captureSink struct {
+	mu     sync.Mutex
+	events []models.ProcessingProgress
+}
+
+func (s *captureSink) Publish(progress models.ProcessingProgress) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, progress)
+	return nil
+}
+
+func (s *captureSink) statuses() []models.VideoStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var statuses []models.VideoStatus
+	for _, e := range s.events {
+		if len(statuses) == 0 || statuses[len(statuses)-1] != e.Status {
+			statuses = append(statuses, e.Status)
+		}
+	}
+	return statuses
+}
+
+// useCaptureSink sends the worker's progress to a fresh captureSink
+func useCaptureSink(t *testing.T) *captureSink {
+	t.Helper()
+	sink := &captureSink{}
+	setVar[pubsub.ProgressSink](t, &progressSink, sink)
+	return sink
+}
+
+func TestNewProgressSink(t *testing.T) {
+	tests := []struct {
+		names   string
+		want    pubsub.ProgressSink
+		wantErr bool
+	}{
+		{"redis", pubsub.ProgressSinks{pubsub.RedisProgressSink{}, webhooks}, false},
+		{"redis,log", pubsub.ProgressSinks{pubsub.RedisProgressSink{}, logProgressSink{}, webhooks}, false},
+		{" log , redis ", pubsub.ProgressSinks{logProgressSink{}, pubsub.RedisProgressSink{}, webhooks}, false},
+		{"redis,redis", pubsub.ProgressSinks{pubsub.RedisProgressSink{}, webhooks}, false},
+		// Webhooks are always on, not an operator choice
+		{"redis,webhook", nil, true},
+		{"", nil, true},
+		{" , ", nil, true},
+		{"redis,statsd", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.names, func(t *testing.T) {
+			got, err := newProgressSink(tt.names)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newProgressSink(%q) error = %v, wantErr %v", tt.names, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newProgressSink(%q) = %#v, want %#v", tt.names, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogProgressSink(t *testing.T) {
+	events := []models.ProcessingProgress{
+		{VideoID: uuid.New(), Status: models.StatusStarted},
+		{VideoID: uuid.New(), Status: models.StatusProcessing, ProcessedFrames: 10, TotalFrames: 48},
+		{VideoID: uuid.New(), Status: models.StatusProcessing, Resolution: "720p", CurrentResolution: 1, TotalResolutions: 2},
+		{VideoID: uuid.New(), Status: models.StatusFailed, Error: "boom", ErrorCategory: string(models.FailureEncodeError)},
+	}
+	for _, e := range events {
+		if err := (logProgressSink{}).Publish(e); err != nil {
+			t.Errorf("Publish(%+v) = %v", e, err)
+		}
+	}
+}
+
+func TestWorkerPublishesToProgressSink(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	useFakeTools(t, testProbe)
+	useRedis(t, nil)
+	sink := useCaptureSink(t)
+	gcsClient, _ := testgcs.Start(t)
+	gormDB, _ := testdb.Open(t, nil)
+
+	job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"}
+	if err := processVideoStreaming(context.Background(), gcsClient, gormDB, job); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []models.VideoStatus{models.StatusStarted, models.StatusProcessing, models.StatusCompleted}
+	if got := sink.statuses(); !reflect.DeepEqual(got, want) {
+		t.Errorf("published statuses = %v, want %v", got, want)
+	}
+	for _, e := range sink.events {
+		if e.VideoID != job.VideoID {
+			t.Errorf("event %+v is for another video", e)
+		}
+	}
+}
+
+func TestFailVideoPublishesToProgressSink(t *testing.T) {
+	useRedis(t, nil)
+	sink := useCaptureSink(t)
+	gormDB, _ := testdb.Open(t, nil)
+
+	videoID := uuid.New()
+	failVideo(context.Background(), gormDB, videoID, "failed to transcode video", errors.New("exit status 1"))
+
+	if len(sink.events) != 1 {
+		t.Fatalf("published %d events, want 1", len(sink.events))
+	}
+	if e := sink.events[0]; e.VideoID != videoID || e.Status != models.StatusFailed || e.Error != "failed to transcode video" {
+		t.Errorf("event = %+v, want the failure", e)
+	}
+}
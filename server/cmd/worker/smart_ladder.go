@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+var (
+	// SMART_LADDER caps rendition bitrates by the source's own video
+	// bitrate, so a low-bitrate source isn't encoded with more bits than it
+	// ever had. The fixed ladder is used as-is by default.
+	smartLadder = server_utils.GetEnvBool("SMART_LADDER", false)
+	// Share of the source bitrate, in percent, a rendition at the source's
+	// height may use; smaller renditions get it scaled by picture area
+	smartLadderPercent = server_utils.GetEnvInt("SMART_LADDER_PERCENT", 80)
+	// Lowest capped bitrate, in kbps; entries already below keep theirs
+	smartLadderMinBitrate = server_utils.GetEnvInt("SMART_LADDER_MIN_BITRATE", 200)
+)
+
+func validateSmartLadder(percent, minBitrate int) error {
+	if percent <= 0 || percent > 100 {
+		return fmt.Errorf("SMART_LADDER_PERCENT must be between 1 and 100, got %d", percent)
+	}
+	if minBitrate <= 0 {
+		return fmt.Errorf("SMART_LADDER_MIN_BITRATE must be positive, got %d", minBitrate)
+	}
+	return nil
+}
+
+// smartBitrate returns the highest video bitrate in kbps worth spending on
+// a rendition of height h: percent of the source bitrate (bits per second,
+// as ffprobe reports it) times the area ratio to the source, not below
+// minBitrate. Zero means no cap, e.g. when the source bitrate is unknown.
+func smartBitrate(sourceBitrate, sourceHeight, height, percent, minBitrate int) int {
+	if sourceBitrate <= 0 || sourceHeight <= 0 || height <= 0 {
+		return 0
+	}
+	ratio := min(float64(height)/float64(sourceHeight), 1)
+	capKbps := float64(sourceBitrate) / 1000 * float64(percent) / 100 * ratio * ratio
+	return max(int(math.Round(capKbps)), minBitrate)
+}
+
+// capRenditionBitrates lowers each rendition's bitrate to its smart cap.
+// Max rate and buffer size shrink in proportion, as with tinyRendition;
+// audio is left alone.
+func capRenditionBitrates(selected []Rendition, sourceBitrate, sourceHeight, percent, minBitrate int) []Rendition {
+	capped := make([]Rendition, len(selected))
+	for i, r := range selected {
+		capped[i] = r
+		limit := smartBitrate(sourceBitrate, sourceHeight, r.Height, percent, minBitrate)
+		if limit == 0 || r.Bitrate <= limit {
+			continue
+		}
+		scale := func(rate int) int {
+			return int(math.Round(float64(rate) * float64(limit) / float64(r.Bitrate)))
+		}
+		capped[i].Bitrate = limit
+		capped[i].MaxRate = scale(r.MaxRate)
+		capped[i].BufSize = scale(r.BufSize)
+	}
+	return capped
+}
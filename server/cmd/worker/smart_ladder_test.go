@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSmartBitrate(t *testing.T) {
+	tests := []struct {
+		name                        string
+		sourceBitrate, sourceHeight int
+		height, percent, minBitrate int
+		want                        int
+	}{
+		{"same height", 4000000, 1080, 1080, 100, 200, 4000},
+		{"scaled by area", 4000000, 1080, 720, 100, 200, 1778},
+		{"percent", 4000000, 1080, 1080, 50, 200, 2000},
+		{"upscale not rewarded", 4000000, 720, 1080, 100, 200, 4000},
+		{"minimum", 4000000, 1080, 144, 100, 200, 200},
+		{"unknown bitrate", 0, 1080, 720, 100, 200, 0},
+		{"unknown height", 4000000, 0, 720, 100, 200, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := smartBitrate(tt.sourceBitrate, tt.sourceHeight, tt.height, tt.percent, tt.minBitrate)
+			if got != tt.want {
+				t.Errorf("smartBitrate = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapRenditionBitrates(t *testing.T) {
+	ladder := []Rendition{
+		{Height: 1080, Bitrate: 5000, MaxRate: 5350, BufSize: 7500, AudioRate: 192},
+		{Height: 720, Bitrate: 2800, MaxRate: 2996, BufSize: 4200, AudioRate: 128},
+		{Height: 360, Bitrate: 400, MaxRate: 428, BufSize: 600, AudioRate: 96},
+		{Height: 144, Bitrate: 300, MaxRate: 321, BufSize: 450, AudioRate: 96},
+	}
+
+	tests := []struct {
+		name          string
+		sourceBitrate int
+		want          []Rendition
+	}{
+		{
+			name:          "capped",
+			sourceBitrate: 4000000,
+			want: []Rendition{
+				{Height: 1080, Bitrate: 4000, MaxRate: 4280, BufSize: 6000, AudioRate: 192},
+				{Height: 720, Bitrate: 1778, MaxRate: 1902, BufSize: 2667, AudioRate: 128},
+				{Height: 360, Bitrate: 400, MaxRate: 428, BufSize: 600, AudioRate: 96},
+				{Height: 144, Bitrate: 200, MaxRate: 214, BufSize: 300, AudioRate: 96},
+			},
+		},
+		{
+			name:          "unknown source bitrate",
+			sourceBitrate: 0,
+			want:          ladder,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := capRenditionBitrates(ladder, tt.sourceBitrate, 1080, 100, 200)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("capRenditionBitrates = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if ladder[0].Bitrate != 5000 {
+		t.Error("capRenditionBitrates modified its input")
+	}
+}
+
+func TestValidateSmartLadder(t *testing.T) {
+	tests := []struct {
+		name       string
+		percent    int
+		minBitrate int
+		wantErr    bool
+	}{
+		{"defaults", 80, 200, false},
+		{"whole source", 100, 1, false},
+		{"zero percent", 0, 300, true},
+		{"over 100 percent", 101, 300, true},
+		{"zero minimum", 80, 0, true},
+		{"negative minimum", 80, -1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateSmartLadder(tt.percent, tt.minBitrate); (err != nil) != tt.wantErr {
+				t.Errorf("validateSmartLadder(%d, %d) error = %v, wantErr %v", tt.percent, tt.minBitrate, err, tt.wantErr)
+			}
+		})
+	}
+}
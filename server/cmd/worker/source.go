@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/devrayat000/video-process/models"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"gorm.io/gorm"
+)
+
+var (
+	// Remove the original upload once its HLS output has been verified
+	deleteSourceOnComplete = server_utils.GetEnvBool("DELETE_SOURCE_ON_COMPLETE", false)
+	// Largest remote source the worker downloads, in bytes
+	remoteSourceMaxBytes = int64(server_utils.GetEnvInt("REMOTE_SOURCE_MAX_BYTES", 20<<30))
+)
+
+// remoteDownloadClient fetches remote sources with the SSRF-guarded transport
+// and redirect checks of RemoteHTTPClient. Downloads can take far longer than
+// its timeout, so they are bounded by the job's context instead.
+var remoteDownloadClient = &http.Client{
+	Transport:     server_utils.RemoteHTTPClient.Transport,
+	CheckRedirect: server_utils.RemoteHTTPClient.CheckRedirect,
+}
+
+// verifyOutput confirms the master playlist and every variant playlist made it
+// to storage before anything irreversible happens to the source.
+func verifyOutput(ctx context.Context, bucket *storage.BucketHandle, prefix string, variantCount int) error {
+	keys := []string{prefix + masterPlaylistName}
+	for i := 0; i < variantCount; i++ {
+		keys = append(keys, fmt.Sprintf("%s%s/playlist.m3u8", prefix, variantDirName(i)))
+	}
+
+	for _, key := range keys {
+		attrs, err := bucket.Object(key).Attrs(ctx)
+		if err != nil {
+			return fmt.Errorf("output verification failed for %s: %w", key, err)
+		}
+		if attrs.Size == 0 {
+			return fmt.Errorf("output verification failed for %s: empty object", key)
+		}
+	}
+
+	return nil
+}
+
+// Lifetime of signed URLs handed to FFmpeg; must outlive the job timeout
+const sourceURLExpiry = 3 * time.Hour
+
+// sourceObject returns the bucket and key of a job's source when it lives in
+// GCS. An explicit Bucket means S3Path is an object key in that bucket.
+func sourceObject(job models.VideoJob) (bucketName, key string, ok bool) {
+	if job.Bucket != "" {
+		return job.Bucket, strings.TrimPrefix(job.S3Path, "/"), true
+	}
+	return server_utils.ParseObjectURL(job.S3Path)
+}
+
+// resolveSourceURL returns a URL FFmpeg can read. Jobs naming a bucket get a
+// signed URL so private sources work; otherwise S3Path is used as-is.
+func resolveSourceURL(gcsClient *storage.Client, job models.VideoJob) (string, error) {
+	if job.Bucket == "" {
+		return job.S3Path, nil
+	}
+
+	bucketName, key, _ := sourceObject(job)
+	if err := server_utils.CheckSourceBucket(bucketName); err != nil {
+		return "", err
+	}
+	signedURL, err := gcsClient.Bucket(bucketName).SignedURL(key, &storage.SignedURLOptions{
+		Scheme:  storage.SigningSchemeV4,
+		Method:  "GET",
+		Expires: time.Now().Add(sourceURLExpiry),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to sign source URL for %s/%s: %w", bucketName, key, err)
+	}
+
+	return signedURL, nil
+}
+
+// checkSourceURL applies the SSRF guard to sources outside our storage;
+// signed and recognised GCS URLs are trusted when their bucket is allowed.
+// The API checks at submission, but DNS can change while a job waits in
+// the queue.
+func checkSourceURL(ctx context.Context, job models.VideoJob, sourceURL string) error {
+	if bucketName, _, ok := sourceObject(job); ok {
+		return server_utils.CheckSourceBucket(bucketName)
+	}
+	if err := server_utils.CheckRemoteURL(ctx, sourceURL); err != nil {
+		return fmt.Errorf("unsafe source URL: %w", err)
+	}
+	return nil
+}
+
+// remoteSourceDir holds the local copy of a remote source; kept apart from
+// the transcode directory, which is removed and recreated per attempt
+func remoteSourceDir(job models.VideoJob) string {
+	return fmt.Sprintf("/tmp/%s-source", job.VideoID)
+}
+
+// downloadRemoteSource copies a source from outside our storage to a local
+// file and returns its path. FFmpeg and ffprobe would otherwise resolve the
+// host again, follow redirects and open whatever a remote playlist lists,
+// all past the SSRF guard, so they are never given the remote URL.
+func downloadRemoteSource(ctx context.Context, job models.VideoJob, sourceURL string) (string, error) {
+	dir := remoteSourceDir(job)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create temp dir for source: %w", err)
+	}
+
+	localPath := path.Join(dir, "source")
+	if err := fetchRemote(ctx, sourceURL, localPath, remoteSourceMaxBytes); err != nil {
+		return "", fmt.Errorf("failed to download source: %w", err)
+	}
+	return localPath, nil
+}
+
+// fetchRemote downloads rawURL to localPath through remoteDownloadClient,
+// failing once more than maxBytes arrive
+func fetchRemote(ctx context.Context, rawURL, localPath string, maxBytes int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := remoteDownloadClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("server returned %s", resp.Status)
+	}
+
+	file, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	n, err := io.Copy(file, io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return err
+	}
+	if n > maxBytes {
+		return fmt.Errorf("remote source is larger than %d bytes", maxBytes)
+	}
+	return file.Close()
+}
+
+// sourceProtocolArgs limits what FFmpeg and ffprobe may open for the source
+// to network protocols, so a crafted playlist can't read local files or
+// reach other schemes. Local paths, which only come from the worker itself
+// (downloaded remote sources and assembled image sequences), may use the
+// file protocol.
+func sourceProtocolArgs(sourceURL string) []string {
+	if !strings.Contains(sourceURL, "://") {
+		return []string{"-protocol_whitelist", "file"}
+	}
+	return []string{"-protocol_whitelist", "http,https,tcp,tls,crypto"}
+}
+
+// deleteSource removes the original upload. Only sources that live in GCS can
+// be deleted; remote URLs are left alone.
+func deleteSource(ctx context.Context, gcsClient *storage.Client, job models.VideoJob) (bool, error) {
+	bucketName, key, ok := sourceObject(job)
+	if !ok {
+		return false, nil
+	}
+	if err := server_utils.CheckSourceBucket(bucketName); err != nil {
+		return false, fmt.Errorf("refusing to delete source: %w", err)
+	}
+
+	if err := gcsClient.Bucket(bucketName).Object(key).Delete(ctx); err != nil {
+		return false, fmt.Errorf("failed to delete source %s/%s: %w", bucketName, key, err)
+	}
+
+	return true, nil
+}
+
+// otherSourceReferences counts videos besides this job's that point at the
+// same source and still need it. Several uploads can share one object, so the
+// source is only deleted once the last of them has completed.
+func otherSourceReferences(ctx context.Context, gormDB *gorm.DB, job models.VideoJob) (int64, error) {
+	var count int64
+	err := gormDB.WithContext(ctx).Model(&models.Video{}).
+		Where("id <> ? AND s3_path = ? AND source_bucket = ?", job.VideoID, job.S3Path, job.Bucket).
+		Where("status <> ? AND source_deleted = ?", models.StatusCompleted, false).
+		Count(&count).Error
+	return count, err
+}
+
+// markSourceDeleted flags every video sharing the job's source, so none of
+// them offers a reprocess that can no longer work.
+func markSourceDeleted(ctx context.Context, gormDB *gorm.DB, job models.VideoJob) error {
+	return gormDB.WithContext(ctx).Model(&models.Video{}).
+		Where("s3_path = ? AND source_bucket = ?", job.S3Path, job.Bucket).
+		Update("source_deleted", true).Error
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"github.com/google/uuid"
+)
+
+func TestVerifyOutput(t *testing.T) {
+	defer func(dir string) { hlsVariantDir = dir }(hlsVariantDir)
+	hlsVariantDir = "stream_%v"
+	id := uuid.New()
+	prefix := id.String() + "/processed/"
+	playlist := []byte("#EXTM3U\n")
+
+	tests := []struct {
+		name    string
+		objects map[string][]byte
+		wantErr bool
+	}{
+		{
+			name: "complete output",
+			objects: map[string][]byte{
+				prefix + "master.m3u8":            playlist,
+				prefix + "stream_0/playlist.m3u8": playlist,
+				prefix + "stream_1/playlist.m3u8": playlist,
+			},
+		},
+		{
+			name: "missing master",
+			objects: map[string][]byte{
+				prefix + "stream_0/playlist.m3u8": playlist,
+				prefix + "stream_1/playlist.m3u8": playlist,
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing variant",
+			objects: map[string][]byte{
+				prefix + "master.m3u8":            playlist,
+				prefix + "stream_0/playlist.m3u8": playlist,
+			},
+			wantErr: true,
+		},
+		{
+			name: "empty variant playlist",
+			objects: map[string][]byte{
+				prefix + "master.m3u8":            playlist,
+				prefix + "stream_0/playlist.m3u8": playlist,
+				prefix + "stream_1/playlist.m3u8": {},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, store := testgcs.Start(t)
+			for name, data := range tt.objects {
+				store.Put("videos", name, data)
+			}
+
+			err := verifyOutput(context.Background(), client.Bucket("videos"), models.OutputPrefix(id, 0), 2)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyOutput error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeleteSource(t *testing.T) {
+	tests := []struct {
+		name        string
+		job         models.VideoJob
+		wantDeleted bool
+		wantErr     bool
+	}{
+		{"gcs object", models.VideoJob{S3Path: "gs://uploads/a.mp4"}, true, false},
+		{"public url", models.VideoJob{S3Path: "https://storage.googleapis.com/uploads/a.mp4"}, true, false},
+		{"bucket and key", models.VideoJob{Bucket: "uploads", S3Path: "a.mp4"}, true, false},
+		{"remote url is kept", models.VideoJob{S3Path: "https://example.com/a.mp4"}, false, false},
+		{"missing object", models.VideoJob{S3Path: "gs://uploads/gone.mp4"}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, store := testgcs.Start(t)
+			store.Put("uploads", "a.mp4", []byte("source"))
+
+			deleted, err := deleteSource(context.Background(), client, tt.job)
+			if deleted != tt.wantDeleted || (err != nil) != tt.wantErr {
+				t.Fatalf("deleteSource = %v, %v; want %v, error %v", deleted, err, tt.wantDeleted, tt.wantErr)
+			}
+			if remaining := store.Names("uploads"); tt.wantDeleted == slices.Contains(remaining, "a.mp4") {
+				t.Errorf("objects after deleteSource = %v", remaining)
+			}
+		})
+	}
+}
+
+func TestSourceObject(t *testing.T) {
+	tests := []struct {
+		name       string
+		job        models.VideoJob
+		wantBucket string
+		wantKey    string
+		wantOK     bool
+	}{
+		{"bucket and key", models.VideoJob{Bucket: "private", S3Path: "uploads/a.mp4"}, "private", "uploads/a.mp4", true},
+		{"bucket and rooted key", models.VideoJob{Bucket: "private", S3Path: "/uploads/a.mp4"}, "private", "uploads/a.mp4", true},
+		{"gs url", models.VideoJob{S3Path: "gs://public/a.mp4"}, "public", "a.mp4", true},
+		{"remote url", models.VideoJob{S3Path: "https://example.com/a.mp4"}, "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucketName, key, ok := sourceObject(tt.job)
+			if bucketName != tt.wantBucket || key != tt.wantKey || ok != tt.wantOK {
+				t.Errorf("sourceObject = %q, %q, %v; want %q, %q, %v", bucketName, key, ok, tt.wantBucket, tt.wantKey, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestResolveSourceURL(t *testing.T) {
+	client := signingClient(t)
+
+	t.Run("full url is used as-is", func(t *testing.T) {
+		job := models.VideoJob{S3Path: "https://example.com/a.mp4?token=1"}
+		got, err := resolveSourceURL(client, job)
+		if err != nil || got != job.S3Path {
+			t.Errorf("resolveSourceURL = %q, %v; want %q", got, err, job.S3Path)
+		}
+	})
+
+	t.Run("bucket and key are signed", func(t *testing.T) {
+		setVar(t, &server_utils.SourceBuckets, "uploads, private")
+		job := models.VideoJob{Bucket: "private", S3Path: "uploads/a b.mp4"}
+		got, err := resolveSourceURL(client, job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := url.Parse(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Path != "/private/uploads/a b.mp4" || u.Query().Get("X-Goog-Signature") == "" {
+			t.Errorf("resolveSourceURL = %q, want a signed URL for private/uploads/a b.mp4", got)
+		}
+	})
+
+	t.Run("bucket outside SOURCE_BUCKETS is refused", func(t *testing.T) {
+		job := models.VideoJob{Bucket: "private", S3Path: "uploads/a.mp4"}
+		if got, err := resolveSourceURL(client, job); err == nil {
+			t.Errorf("resolveSourceURL = %q, want an error", got)
+		}
+	})
+}
+
+// signingClient returns a storage client with service account credentials,
+// enough to sign URLs locally
+func signingClient(t *testing.T) *storage.Client {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	creds, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "worker@test.iam.gserviceaccount.com",
+		"private_key":  string(pemKey),
+		"token_uri":    "https://oauth2.googleapis.com/token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := storage.NewClient(context.Background(), option.WithCredentialsJSON(creds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestCheckSourceURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		job     models.VideoJob
+		url     string
+		wantErr bool
+	}{
+		{"gs URL", models.VideoJob{S3Path: "gs://uploads/a.mp4"}, "gs://uploads/a.mp4", false},
+		{"signed bucket source", models.VideoJob{Bucket: "uploads", S3Path: "a.mp4"}, "https://storage.googleapis.com/uploads/a.mp4?X-Goog-Signature=x", false},
+		{"gs URL outside SOURCE_BUCKETS", models.VideoJob{S3Path: "gs://private/a.mp4"}, "gs://private/a.mp4", true},
+		{"bucket outside SOURCE_BUCKETS", models.VideoJob{Bucket: "private", S3Path: "a.mp4"}, "https://storage.googleapis.com/private/a.mp4?X-Goog-Signature=x", true},
+		{"public remote", models.VideoJob{S3Path: "https://93.184.216.34/a.mp4"}, "https://93.184.216.34/a.mp4", false},
+		{"private remote", models.VideoJob{S3Path: "http://10.0.0.8/a.mp4"}, "http://10.0.0.8/a.mp4", true},
+		{"metadata server", models.VideoJob{S3Path: "http://169.254.169.254/"}, "http://169.254.169.254/", true},
+		{"local file", models.VideoJob{S3Path: "/etc/passwd"}, "/etc/passwd", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSourceURL(context.Background(), tt.job, tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkSourceURL(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFetchRemote(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a.mp4":
+			w.Write([]byte("0123456789"))
+		case "/moved.mp4":
+			http.Redirect(w, r, "/a.mp4", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		name     string
+		path     string
+		maxBytes int64
+		want     string
+		wantErr  string
+	}{
+		{name: "downloaded", path: "/a.mp4", maxBytes: 10, want: "0123456789"},
+		{name: "redirect followed", path: "/moved.mp4", maxBytes: 10, want: "0123456789"},
+		{name: "not found", path: "/gone.mp4", maxBytes: 10, wantErr: "server returned 404"},
+		{name: "over the cap", path: "/a.mp4", maxBytes: 9, wantErr: "remote source is larger than 9 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The test server is on loopback, which the SSRF guard refuses
+			setVar(t, &remoteDownloadClient, srv.Client())
+			localPath := filepath.Join(t.TempDir(), "source")
+
+			err := fetchRemote(context.Background(), srv.URL+tt.path, localPath, tt.maxBytes)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("fetchRemote error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := os.ReadFile(localPath); string(got) != tt.want {
+				t.Errorf("downloaded %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchRemoteRefusesPrivateAddresses(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	t.Cleanup(srv.Close)
+
+	if err := fetchRemote(context.Background(), srv.URL+"/a.mp4", filepath.Join(t.TempDir(), "source"), 10); err == nil {
+		t.Error("fetchRemote downloaded from a loopback address")
+	}
+	if requests != 0 {
+		t.Errorf("loopback server got %d requests", requests)
+	}
+}
+
+func TestDownloadRemoteSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("video"))
+	}))
+	t.Cleanup(srv.Close)
+	setVar(t, &remoteDownloadClient, srv.Client())
+
+	job := models.VideoJob{VideoID: uuid.New(), S3Path: srv.URL + "/a.mp4"}
+	t.Cleanup(func() { os.RemoveAll(remoteSourceDir(job)) })
+
+	localPath, err := downloadRemoteSource(context.Background(), job, job.S3Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(remoteSourceDir(job), "source"); localPath != want {
+		t.Errorf("local path = %q, want %q", localPath, want)
+	}
+	// FFmpeg may only read the local copy as a file
+	if args := sourceProtocolArgs(localPath); !slices.Equal(args, []string{"-protocol_whitelist", "file"}) {
+		t.Errorf("protocol args for the local copy = %q", args)
+	}
+}
+
+func TestSourceProtocolArgs(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"https://storage.googleapis.com/uploads/a.mp4?X-Goog-Signature=abc", "http,https,tcp,tls,crypto"},
+		{"http://example.com/a.mp4", "http,https,tcp,tls,crypto"},
+		{"/tmp/1234-sequence/sequence.mp4", "file"},
+	}
+	for _, tt := range tests {
+		got := sourceProtocolArgs(tt.source)
+		if !slices.Equal(got, []string{"-protocol_whitelist", tt.want}) {
+			t.Errorf("sourceProtocolArgs(%q) = %q, want %s", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestUnsafeSourceFailsBeforeProbing(t *testing.T) {
+	ffmpeg, ffprobe, logFile := customTools(t, testProbe)
+	setVar(t, &ffmpegPath, ffmpeg)
+	setVar(t, &ffprobePath, ffprobe)
+
+	useRedis(t, nil)
+	gcsClient, _ := testgcs.Start(t)
+	gormDB, db := testdb.Open(t, nil)
+	job := models.VideoJob{VideoID: uuid.New(), S3Path: "http://127.0.0.1:8080/internal.mp4"}
+	if err := processVideoStreaming(context.Background(), gcsClient, gormDB, job); err == nil {
+		t.Fatal("processVideoStreaming accepted a loopback source")
+	}
+
+	if _, err := os.Stat(logFile); err == nil {
+		t.Error("ffprobe or ffmpeg ran against the unsafe source")
+	}
+	var category any
+	for _, q := range db.Matching(`UPDATE "videos"`) {
+		if c, ok := updatedColumns(q)["failure_category"]; ok {
+			category = c
+		}
+	}
+	if category != string(models.FailureUnsupportedInput) {
+		t.Errorf("failure_category = %v, want %s", category, models.FailureUnsupportedInput)
+	}
+}
+
+func TestDeleteSourceOnComplete(t *testing.T) {
+	defer func(v bool, b string) { deleteSourceOnComplete, gcsBucket = v, b }(deleteSourceOnComplete, gcsBucket)
+	deleteSourceOnComplete, gcsBucket = true, "videos"
+
+	tests := []struct {
+		name        string
+		ffmpegFails bool
+		wantDeleted bool
+	}{
+		{name: "deleted after verified output", wantDeleted: true},
+		{name: "kept when the transcode fails", ffmpegFails: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeTools(t, testProbe)
+			if tt.ffmpegFails {
+				t.Setenv(fakeFFmpegFailEnv, "Conversion failed!")
+			}
+			useRedis(t, nil)
+			gcsClient, store := testgcs.Start(t)
+			store.Put("uploads", "a.mp4", []byte("source"))
+			gormDB, db := testdb.Open(t, nil)
+
+			job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/a.mp4"}
+			err := processVideoStreaming(context.Background(), gcsClient, gormDB, job)
+			if (err != nil) != tt.ffmpegFails {
+				t.Fatalf("processVideoStreaming error = %v, want failure %v", err, tt.ffmpegFails)
+			}
+
+			if deleted := !slices.Contains(store.Names("uploads"), "a.mp4"); deleted != tt.wantDeleted {
+				t.Errorf("source deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+			recorded := len(db.Matching(`"source_deleted"`)) > 0
+			if recorded != tt.wantDeleted {
+				t.Errorf("source_deleted recorded = %v, want %v", recorded, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestSharedSourceDeletedByLastReference(t *testing.T) {
+	setVar(t, &deleteSourceOnComplete, true)
+	setVar(t, &gcsBucket, "videos")
+	useFakeTools(t, testProbe)
+	useRedis(t, nil)
+	gcsClient, store := testgcs.Start(t)
+	store.Put("uploads", "shared.mp4", []byte("source"))
+
+	first, second := uuid.New(), uuid.New()
+	status := map[uuid.UUID]models.VideoStatus{first: models.StatusWaiting, second: models.StatusWaiting}
+
+	// Count videos other than the job's that still need the shared source
+	handler := func(job uuid.UUID) testdb.Handler {
+		return func(q testdb.Query) testdb.Result {
+			if strings.HasPrefix(q.SQL, "SELECT count(*)") {
+				var n int64
+				for id, s := range status {
+					if id != job && s != models.StatusCompleted {
+						n++
+					}
+				}
+				return testdb.Result{Columns: []string{"count"}, Rows: [][]any{{n}}}
+			}
+			return testdb.Result{RowsAffected: 1}
+		}
+	}
+
+	steps := []struct {
+		video       uuid.UUID
+		wantDeleted bool
+	}{
+		{first, false},
+		{second, true},
+	}
+	for _, step := range steps {
+		gormDB, db := testdb.Open(t, handler(step.video))
+		job := models.VideoJob{VideoID: step.video, S3Path: "gs://uploads/shared.mp4"}
+		if err := processVideoStreaming(context.Background(), gcsClient, gormDB, job); err != nil {
+			t.Fatal(err)
+		}
+		status[step.video] = models.StatusCompleted
+
+		if deleted := !slices.Contains(store.Names("uploads"), "shared.mp4"); deleted != step.wantDeleted {
+			t.Fatalf("after %s: source deleted = %v, want %v", step.video, deleted, step.wantDeleted)
+		}
+
+		counts := db.Matching("SELECT count(*)")
+		if len(counts) != 1 || !strings.Contains(counts[0].SQL, "id <> $1 AND s3_path = $2") {
+			t.Errorf("reference count queries = %v", counts)
+		}
+		// Every video sharing the source learns it is gone
+		flagged := db.Matching(`SET "source_deleted"=$1`)
+		if got := len(flagged) > 0 && strings.Contains(flagged[0].SQL, "WHERE s3_path = $3"); got != step.wantDeleted {
+			t.Errorf("after %s: shared rows flagged = %v, want %v (%v)", step.video, got, step.wantDeleted, flagged)
+		}
+	}
+}
+
+func TestSharedSourceKeptWhenReferencesUnknown(t *testing.T) {
+	setVar(t, &deleteSourceOnComplete, true)
+	setVar(t, &gcsBucket, "videos")
+	useFakeTools(t, testProbe)
+	useRedis(t, nil)
+	gcsClient, store := testgcs.Start(t)
+	store.Put("uploads", "a.mp4", []byte("source"))
+	gormDB, _ := testdb.Open(t, func(q testdb.Query) testdb.Result {
+		if strings.HasPrefix(q.SQL, "SELECT count(*)") {
+			return testdb.Result{Err: errors.New("connection reset")}
+		}
+		return testdb.Result{RowsAffected: 1}
+	})
+
+	if err := processVideoStreaming(context.Background(), gcsClient, gormDB, models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/a.mp4"}); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(store.Names("uploads"), "a.mp4") {
+		t.Error("source deleted although its references could not be counted")
+	}
+}
+
+func TestOutputBucketRouting(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+
+	tests := []struct {
+		name       string
+		requested  string
+		wantErr    bool
+		wantBucket string
+	}{
+		{name: "default bucket", wantBucket: "videos"},
+		{name: "bucket outside the allowlist", requested: "tenant-x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeTools(t, testProbe)
+			useRedis(t, nil)
+			gcsClient, store := testgcs.Start(t)
+			gormDB, db := testdb.Open(t, nil)
+
+			job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4", OutputBucket: tt.requested}
+			err := processVideoStreaming(context.Background(), gcsClient, gormDB, job)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("processVideoStreaming error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				if !strings.Contains(err.Error(), `"tenant-x" is not allowed`) {
+					t.Errorf("error = %v", err)
+				}
+				if len(store.Written()) != 0 {
+					t.Errorf("rejected job wrote output: %v", store.Written())
+				}
+				if failed := db.Matching(`"status"=`); len(failed) == 0 || !containsArg(failed[len(failed)-1].Args, string(models.StatusFailed)) {
+					t.Errorf("video not marked failed: %v", failed)
+				}
+				return
+			}
+
+			master := job.VideoID.String() + "/processed/master.m3u8"
+			if !slices.Contains(store.Names(tt.wantBucket), master) {
+				t.Errorf("master playlist not in %s: %v", tt.wantBucket, store.Written())
+			}
+			// The chosen bucket is recorded so the API reads output from it
+			if recorded := db.Matching(`"output_bucket"=`); len(recorded) == 0 || !containsArg(recorded[0].Args, tt.wantBucket) {
+				t.Errorf("output bucket not recorded: %v", recorded)
+			}
+		})
+	}
+}
+
+func containsArg(args []any, want string) bool {
+	for _, arg := range args {
+		if s, ok := arg.(string); ok && s == want {
+			return true
+		}
+	}
+	return false
+}
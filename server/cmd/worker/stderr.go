@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// Number of trailing FFmpeg stderr lines kept for failure messages.
+var ffmpegStderrTailLines = server_utils.GetEnvInt("FFMPEG_STDERR_TAIL_LINES", 20)
+
+// stderrTail keeps the last few lines FFmpeg wrote to stderr so a failed run
+// can report the real cause instead of a bare "exit status 1".
+type stderrTail struct {
+	mu    sync.Mutex
+	max   int
+	lines []string
+}
+
+func newStderrTail(max int) *stderrTail {
+	if max <= 0 {
+		max = 1
+	}
+	return &stderrTail{max: max}
+}
+
+func (t *stderrTail) Add(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lines = append(t.lines, line)
+	if len(t.lines) > t.max {
+		t.lines = t.lines[len(t.lines)-t.max:]
+	}
+}
+
+func (t *stderrTail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strings.Join(t.lines, "\n")
+}
+
+// ffmpegError wraps a failed FFmpeg/ffprobe run together with the tail of its
+// stderr output. FFmpeg prints the signed source URL, so the tail is
+// redacted in the message.
+type ffmpegError struct {
+	err    error
+	stderr string
+}
+
+func (e *ffmpegError) Error() string {
+	if e.stderr == "" {
+		return e.err.Error()
+	}
+	return e.err.Error() + ":\n" + server_utils.RedactSecrets(e.stderr)
+}
+
+func (e *ffmpegError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ffprobeOutput is the part of `ffprobe -of json -show_format -show_streams`
+// the worker reads
+type ffprobeOutput struct {
+	Streams []ffprobeStream `json:"streams"`
+	Format  struct {
+		Duration probeNumber `json:"duration"`
+	} `json:"format"`
+}
+
+type ffprobeStream struct {
+	Index        int         `json:"index"`
+	CodecType    string      `json:"codec_type"`
+	Width        probeNumber `json:"width"`
+	Height       probeNumber `json:"height"`
+	Channels     probeNumber `json:"channels"`
+	BitRate      probeNumber `json:"bit_rate"`
+	NbFrames     probeNumber `json:"nb_frames"`
+	Duration     probeNumber `json:"duration"`
+	AvgFrameRate string      `json:"avg_frame_rate"`
+	RFrameRate   string      `json:"r_frame_rate"`
+	FieldOrder   string      `json:"field_order"`
+	Disposition  struct {
+		AttachedPic int `json:"attached_pic"`
+	} `json:"disposition"`
+}
+
+// probeNumber is a numeric ffprobe field. ffprobe writes some as JSON
+// numbers and others as strings, and uses "N/A" for values it doesn't know;
+// unknown and unparseable values read as zero.
+type probeNumber float64
+
+func (n *probeNumber) UnmarshalJSON(data []byte) error {
+	text := strings.Trim(string(data), `"`)
+	v, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		*n = 0
+		return nil
+	}
+	*n = probeNumber(v)
+	return nil
+}
+
+func (n probeNumber) Int() int { return int(n) }
+
+// probedStream is one video stream from the probe
+type probedStream struct {
+	VideoMetadata
+	// AttachedPic marks cover art, which containers store as a video stream
+	AttachedPic bool
+}
+
+// probedAudio is the first audio stream from the probe
+type probedAudio struct {
+	Channels int
+	// Duration is zero when the container doesn't report one per stream
+	Duration float64
+}
+
+// parseProbe reads ffprobe JSON into the video streams, in stream order, and
+// fills in what applies to the whole source: the duration and the first
+// audio stream.
+func parseProbe(output []byte) ([]probedStream, float64, probedAudio, error) {
+	var probed ffprobeOutput
+	if err := json.Unmarshal(output, &probed); err != nil {
+		return nil, 0, probedAudio{}, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	var (
+		streams   []probedStream
+		audio     probedAudio
+		seenAudio bool
+		// Some containers only report a duration per stream
+		streamDuration float64
+	)
+	for _, s := range probed.Streams {
+		switch s.CodecType {
+		case "audio":
+			if !seenAudio {
+				audio = probedAudio{Channels: s.Channels.Int(), Duration: float64(s.Duration)}
+				seenAudio = true
+			}
+		case "video":
+			stream := probedStream{AttachedPic: s.Disposition.AttachedPic == 1}
+			stream.Width = s.Width.Int()
+			stream.Height = s.Height.Int()
+			stream.Bitrate = s.BitRate.Int()
+			stream.Frames = int64(s.NbFrames)
+			stream.Interlaced = isInterlacedFieldOrder(s.FieldOrder)
+			stream.VariableFrameRate = isVariableFrameRate(s.RFrameRate, s.AvgFrameRate)
+			stream.VideoDuration = float64(s.Duration)
+			// The real base rate is only used when the average is unknown
+			stream.FrameRate = parseFrameRate(s.AvgFrameRate)
+			if stream.FrameRate <= 0 {
+				stream.FrameRate = max(parseFrameRate(s.RFrameRate), 0)
+			}
+			if !stream.AttachedPic {
+				streamDuration = max(streamDuration, float64(s.Duration))
+			}
+			streams = append(streams, stream)
+		}
+	}
+
+	duration := float64(probed.Format.Duration)
+	if duration <= 0 {
+		duration = streamDuration
+	}
+	return streams, duration, audio, nil
+}
+
+// pickMainStream returns the index of the stream to transcode: the largest
+// picture, then the highest bitrate, skipping cover art and streams without
+// dimensions. It returns -1 when nothing qualifies.
+func pickMainStream(streams []probedStream) int {
+	best := -1
+	for i, s := range streams {
+		if s.AttachedPic || s.Width <= 0 || s.Height <= 0 {
+			continue
+		}
+		if best < 0 {
+			best = i
+			continue
+		}
+		b := streams[best]
+		area, bestArea := s.Width*s.Height, b.Width*b.Height
+		if area > bestArea || (area == bestArea && s.Bitrate > b.Bitrate) {
+			best = i
+		}
+	}
+	return best
+}
+
+// redactProbe drops the query string from the probed file name, which for
+// signed URLs holds the signature, before the output is stored and served.
+func redactProbe(output []byte) json.RawMessage {
+	var probed map[string]any
+	if err := json.Unmarshal(output, &probed); err != nil {
+		return nil
+	}
+	if format, ok := probed["format"].(map[string]any); ok {
+		if name, ok := format["filename"].(string); ok {
+			if u, err := url.Parse(name); err == nil && u.RawQuery != "" {
+				u.RawQuery = ""
+				format["filename"] = u.String()
+			}
+		}
+	}
+	redacted, err := json.Marshal(probed)
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+// probeJSON returns the raw probe for storage, or nil when there is none
+// (e.g. metadata cached before it was kept)
+func probeJSON(raw json.RawMessage) *string {
+	if len(raw) == 0 {
+		return nil
+	}
+	s := string(raw)
+	return &s
+}
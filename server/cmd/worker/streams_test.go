@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+// A cover image, a small preview and the main picture, in that order, with
+// an audio stream in between that must not shift the video indexes
+const multiStreamProbe = `{"streams": [
+	{"index": 0, "codec_type": "video", "width": 600, "height": 600, "avg_frame_rate": "0/0", "disposition": {"attached_pic": 1}},
+	{"index": 1, "codec_type": "audio", "channels": 2},
+	{"index": 2, "codec_type": "video", "width": 640, "height": 360, "bit_rate": "400000", "avg_frame_rate": "24/1"},
+	{"index": 3, "codec_type": "video", "width": 1280, "height": 720, "bit_rate": "2500000", "nb_frames": "48", "avg_frame_rate": "24/1"}
+], "format": {"duration": "2.0"}}`
+
+func TestParseProbe(t *testing.T) {
+	tests := []struct {
+		name         string
+		output       string
+		wantStreams  []probedStream
+		wantDuration float64
+		wantAudio    probedAudio
+		wantErr      bool
+	}{
+		{
+			name:         "single stream",
+			output:       testProbe,
+			wantStreams:  []probedStream{{VideoMetadata: VideoMetadata{Width: 1280, Height: 720, Frames: 48, FrameRate: 24}}},
+			wantDuration: 2,
+		},
+		{
+			name:   "several streams",
+			output: multiStreamProbe,
+			wantStreams: []probedStream{
+				{VideoMetadata: VideoMetadata{Width: 600, Height: 600}, AttachedPic: true},
+				{VideoMetadata: VideoMetadata{Width: 640, Height: 360, Bitrate: 400000, FrameRate: 24}},
+				{VideoMetadata: VideoMetadata{Width: 1280, Height: 720, Bitrate: 2500000, Frames: 48, FrameRate: 24}},
+			},
+			wantDuration: 2,
+			wantAudio:    probedAudio{Channels: 2},
+		},
+		{
+			name: "numbers as JSON numbers",
+			output: `{"streams": [
+				{"codec_type": "video", "width": 1920, "height": 1080, "bit_rate": 5000000, "nb_frames": 1500, "avg_frame_rate": "25/1", "duration": 60},
+				{"codec_type": "audio", "channels": 2, "duration": 59.9},
+				{"codec_type": "audio", "channels": 6, "duration": 12}
+			], "format": {"duration": 60.04}}`,
+			wantStreams: []probedStream{{VideoMetadata: VideoMetadata{
+				Width: 1920, Height: 1080, Bitrate: 5000000, Frames: 1500, FrameRate: 25, VideoDuration: 60,
+			}}},
+			wantDuration: 60.04,
+			wantAudio:    probedAudio{Channels: 2, Duration: 59.9},
+		},
+		{
+			name:        "interlaced with fractional r_frame_rate fallback",
+			output:      `{"streams": [{"codec_type": "video", "width": 720, "height": 576, "avg_frame_rate": "0/0", "r_frame_rate": "30000/1001", "field_order": "tb"}]}`,
+			wantStreams: []probedStream{{VideoMetadata: VideoMetadata{Width: 720, Height: 576, FrameRate: 30000.0 / 1001, Interlaced: true}}},
+		},
+		{
+			name:        "N/A values read as unknown",
+			output:      `{"streams": [{"codec_type": "video", "width": 1280, "height": 720, "bit_rate": "N/A", "nb_frames": "N/A"}], "format": {"duration": "N/A"}}`,
+			wantStreams: []probedStream{{VideoMetadata: VideoMetadata{Width: 1280, Height: 720}}},
+		},
+		{
+			name: "duration from streams, cover art ignored",
+			output: `{"streams": [
+				{"codec_type": "video", "width": 500, "height": 500, "duration": "30.0", "disposition": {"attached_pic": 1}},
+				{"codec_type": "video", "width": 1280, "height": 720, "duration": "12.5"}
+			], "format": {}}`,
+			wantStreams: []probedStream{
+				{VideoMetadata: VideoMetadata{Width: 500, Height: 500, VideoDuration: 30}, AttachedPic: true},
+				{VideoMetadata: VideoMetadata{Width: 1280, Height: 720, VideoDuration: 12.5}},
+			},
+			wantDuration: 12.5,
+		},
+		{
+			name:   "no video streams",
+			output: `{"streams": [{"codec_type": "audio", "channels": 1}], "format": {"duration": "3.5"}}`,
+			// The duration is still reported so callers can log it
+			wantDuration: 3.5,
+			wantAudio:    probedAudio{Channels: 1},
+		},
+		{
+			name:    "not json",
+			output:  "width=1280\nheight=720\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			streams, duration, audio, err := parseProbe([]byte(tt.output))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseProbe error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(streams, tt.wantStreams) {
+				t.Errorf("streams = %+v, want %+v", streams, tt.wantStreams)
+			}
+			if duration != tt.wantDuration {
+				t.Errorf("duration = %v, want %v", duration, tt.wantDuration)
+			}
+			if audio != tt.wantAudio {
+				t.Errorf("audio = %+v, want %+v", audio, tt.wantAudio)
+			}
+		})
+	}
+}
+
+func TestProbeNumber(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want probeNumber
+	}{
+		{`1920`, 1920},
+		{`"5000000"`, 5000000},
+		{`"60.04"`, 60.04},
+		{`"N/A"`, 0},
+		{`""`, 0},
+		{`"nan"`, 0},
+		{`"inf"`, 0},
+	}
+	for _, tt := range tests {
+		var n probeNumber
+		if err := json.Unmarshal([]byte(tt.raw), &n); err != nil {
+			t.Errorf("unmarshal %s: %v", tt.raw, err)
+		}
+		if n != tt.want {
+			t.Errorf("probeNumber(%s) = %v, want %v", tt.raw, n, tt.want)
+		}
+	}
+}
+
+func TestRedactProbe(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			name:   "signed URL",
+			output: `{"format": {"filename": "https://storage.example.com/uploads/a.mp4?X-Goog-Signature=abc", "duration": "2.0"}}`,
+			want:   `{"format":{"duration":"2.0","filename":"https://storage.example.com/uploads/a.mp4"}}`,
+		},
+		{
+			name:   "plain path",
+			output: `{"format": {"filename": "gs://uploads/a.mp4"}, "streams": []}`,
+			want:   `{"format":{"filename":"gs://uploads/a.mp4"},"streams":[]}`,
+		},
+		{
+			name:   "not json",
+			output: "ffprobe: error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(redactProbe([]byte(tt.output))); got != tt.want {
+				t.Errorf("redactProbe = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeJSON(t *testing.T) {
+	if got := probeJSON(nil); got != nil {
+		t.Errorf("probeJSON(nil) = %q, want nil", *got)
+	}
+	if got := probeJSON(json.RawMessage(`{"streams":[]}`)); got == nil || *got != `{"streams":[]}` {
+		t.Errorf("probeJSON = %v, want the raw probe", got)
+	}
+}
+
+func TestPickMainStream(t *testing.T) {
+	stream := func(w, h, bitrate int, cover bool) probedStream {
+		return probedStream{VideoMetadata: VideoMetadata{Width: w, Height: h, Bitrate: bitrate}, AttachedPic: cover}
+	}
+	tests := []struct {
+		name    string
+		streams []probedStream
+		want    int
+	}{
+		{"none", nil, -1},
+		{"only cover art", []probedStream{stream(600, 600, 0, true)}, -1},
+		{"no dimensions", []probedStream{stream(0, 0, 1000, false)}, -1},
+		{"largest picture", []probedStream{stream(640, 360, 9000, false), stream(1920, 1080, 100, false)}, 1},
+		{"bitrate breaks ties", []probedStream{stream(1280, 720, 100, false), stream(1280, 720, 200, false)}, 1},
+		{"skips larger cover", []probedStream{stream(3000, 3000, 0, true), stream(640, 360, 0, false)}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pickMainStream(tt.streams); got != tt.want {
+				t.Errorf("pickMainStream = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranscodeUsesMainStream(t *testing.T) {
+	ffmpeg, ffprobe, logFile := customTools(t, multiStreamProbe)
+	setVar(t, &ffmpegPath, ffmpeg)
+	setVar(t, &ffprobePath, ffprobe)
+	setVar(t, &gcsBucket, "videos")
+
+	metadata, err := getVideoMetadata(context.Background(), "source.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.StreamIndex != 2 || metadata.Width != 1280 || metadata.Duration != 2 {
+		t.Errorf("metadata = %+v, want the 1280x720 stream at v:2", metadata)
+	}
+
+	useRedis(t, nil)
+	gcsClient, _ := testgcs.Start(t)
+	gormDB, db := testdb.Open(t, nil)
+	job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"}
+	if err := processVideoStreaming(context.Background(), gcsClient, gormDB, job); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), " -filter_complex [0:v:2]split=") {
+		t.Errorf("transcode does not read the main stream:\n%s", data)
+	}
+	var stored bool
+	for _, q := range db.Matching(`UPDATE "videos"`) {
+		if v, ok := updatedColumns(q)["video_stream"]; ok {
+			stored = v == int64(2)
+		}
+	}
+	if !stored {
+		t.Error("chosen stream index was not stored on the video")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devrayat000/video-process/models"
+)
+
+// ffprobeTags is the subset of `ffprobe -of json` output carrying tags
+type ffprobeTags struct {
+	Format struct {
+		Tags map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		Tags map[string]string `json:"tags"`
+	} `json:"streams"`
+}
+
+// Tag names vary by muxer and device; the first one present wins
+var (
+	creationTimeTags = []string{"creation_time", "com.apple.quicktime.creationdate", "date"}
+	encoderTags      = []string{"encoder", "com.apple.quicktime.software", "handler_name"}
+	makeTags         = []string{"com.apple.quicktime.make", "com.android.manufacturer", "make"}
+	modelTags        = []string{"com.apple.quicktime.model", "com.android.model", "model"}
+	locationTags     = []string{"com.apple.quicktime.location.ISO6709", "location", "location-eng"}
+)
+
+var iso6709Coordinates = regexp.MustCompile(`^([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)`)
+
+// parseSourceTags builds SourceMetadata from ffprobe JSON. Format tags take
+// precedence over stream tags; unparseable values are skipped.
+func parseSourceTags(output []byte) *models.SourceMetadata {
+	var probed ffprobeTags
+	if err := json.Unmarshal(output, &probed); err != nil {
+		return nil
+	}
+
+	// Tag keys are case-insensitive in practice (e.g. "ENCODER" in MKV)
+	tags := map[string]string{}
+	collect := func(src map[string]string) {
+		for k, v := range src {
+			k = strings.ToLower(k)
+			if _, seen := tags[k]; !seen && strings.TrimSpace(v) != "" {
+				tags[k] = strings.TrimSpace(v)
+			}
+		}
+	}
+	collect(probed.Format.Tags)
+	for _, s := range probed.Streams {
+		collect(s.Tags)
+	}
+
+	first := func(names []string) string {
+		for _, name := range names {
+			if v, ok := tags[strings.ToLower(name)]; ok {
+				return v
+			}
+		}
+		return ""
+	}
+
+	metadata := &models.SourceMetadata{
+		Encoder: first(encoderTags),
+		Make:    first(makeTags),
+		Model:   first(modelTags),
+	}
+
+	if raw := first(creationTimeTags); raw != "" {
+		if t, ok := parseCreationTime(raw); ok {
+			metadata.CreationTime = &t
+		}
+	}
+
+	if loc := first(locationTags); loc != "" {
+		metadata.Location = loc
+		if m := iso6709Coordinates.FindStringSubmatch(loc); m != nil {
+			lat, latErr := strconv.ParseFloat(m[1], 64)
+			lon, lonErr := strconv.ParseFloat(m[2], 64)
+			if latErr == nil && lonErr == nil && lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180 {
+				metadata.Latitude = &lat
+				metadata.Longitude = &lon
+			}
+		}
+	}
+
+	if *metadata == (models.SourceMetadata{}) {
+		return nil
+	}
+	return metadata
+}
+
+var creationTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+func parseCreationTime(raw string) (time.Time, bool) {
+	for _, layout := range creationTimeLayouts {
+		if t, err := time.Parse(layout, raw); err == nil {
+			// Cameras with an unset clock write the epoch
+			if t.Year() <= 1970 {
+				return time.Time{}, false
+			}
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sampleTags is the tag part of `ffprobe -of json -show_format -show_streams`
+// output for a phone recording
+const sampleTags = `{
+    "programs": [],
+    "streams": [
+        {
+            "tags": {
+                "creation_time": "2024-05-01T09:30:00.000000Z",
+                "language": "und",
+                "handler_name": "Core Media Video",
+                "encoder": "H.264"
+            }
+        },
+        {
+            "tags": {
+                "creation_time": "2024-05-01T09:30:00.000000Z",
+                "handler_name": "Core Media Audio"
+            }
+        }
+    ],
+    "format": {
+        "tags": {
+            "major_brand": "qt  ",
+            "creation_time": "2024-05-01T09:30:02.000000Z",
+            "com.apple.quicktime.location.ISO6709": "+37.7749-122.4194+012.000/",
+            "com.apple.quicktime.make": "Apple",
+            "com.apple.quicktime.model": "iPhone 15",
+            "com.apple.quicktime.software": "17.4.1"
+        }
+    }
+}`
+
+func TestParseSourceTags(t *testing.T) {
+	metadata := parseSourceTags([]byte(sampleTags))
+	if metadata == nil {
+		t.Fatal("no metadata parsed")
+	}
+
+	// The format's creation time wins over the stream's
+	wantTime := time.Date(2024, 5, 1, 9, 30, 2, 0, time.UTC)
+	if metadata.CreationTime == nil || !metadata.CreationTime.Equal(wantTime) {
+		t.Errorf("creation time = %v, want %v", metadata.CreationTime, wantTime)
+	}
+	// A plain encoder tag ranks above QuickTime's software version
+	if metadata.Encoder != "H.264" {
+		t.Errorf("encoder = %q, want the stream's encoder tag", metadata.Encoder)
+	}
+	if metadata.Make != "Apple" || metadata.Model != "iPhone 15" {
+		t.Errorf("device = %q %q, want Apple iPhone 15", metadata.Make, metadata.Model)
+	}
+	if metadata.Location != "+37.7749-122.4194+012.000/" {
+		t.Errorf("location = %q", metadata.Location)
+	}
+	if metadata.Latitude == nil || *metadata.Latitude != 37.7749 || metadata.Longitude == nil || *metadata.Longitude != -122.4194 {
+		t.Errorf("coordinates = %v, %v, want 37.7749, -122.4194", metadata.Latitude, metadata.Longitude)
+	}
+}
+
+func TestParseSourceTagsFallbacks(t *testing.T) {
+	tests := []struct {
+		name        string
+		output      string
+		wantNil     bool
+		wantEncoder string
+		wantTime    bool
+		wantCoords  bool
+	}{
+		{name: "not json", output: "width=1280", wantNil: true},
+		{name: "no tags", output: `{"streams": [{}], "format": {}}`, wantNil: true},
+		{name: "only blank tags", output: `{"format": {"tags": {"encoder": "  "}}}`, wantNil: true},
+		{name: "upper-case stream tag", output: `{"streams": [{"tags": {"ENCODER": "Lavf60.3.100"}}]}`, wantEncoder: "Lavf60.3.100"},
+		{name: "epoch creation time", output: `{"format": {"tags": {"creation_time": "1970-01-01T00:00:00.000000Z", "encoder": "x"}}}`, wantEncoder: "x"},
+		{name: "malformed creation time", output: `{"format": {"tags": {"creation_time": "yesterday", "encoder": "x"}}}`, wantEncoder: "x"},
+		{name: "date only", output: `{"format": {"tags": {"date": "2023-12-24"}}}`, wantTime: true},
+		{name: "location out of range", output: `{"format": {"tags": {"location": "+97.0000+200.0000/"}}}`},
+		{name: "android location", output: `{"format": {"tags": {"location": "+51.5072-000.1276/"}}}`, wantCoords: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata := parseSourceTags([]byte(tt.output))
+			if tt.wantNil {
+				if metadata != nil {
+					t.Errorf("parseSourceTags = %+v, want nil", metadata)
+				}
+				return
+			}
+			if metadata == nil {
+				t.Fatal("parseSourceTags = nil")
+			}
+			if metadata.Encoder != tt.wantEncoder {
+				t.Errorf("encoder = %q, want %q", metadata.Encoder, tt.wantEncoder)
+			}
+			if (metadata.CreationTime != nil) != tt.wantTime {
+				t.Errorf("creation time = %v, want set %v", metadata.CreationTime, tt.wantTime)
+			}
+			if (metadata.Latitude != nil) != tt.wantCoords {
+				t.Errorf("latitude = %v, want set %v", metadata.Latitude, tt.wantCoords)
+			}
+		})
+	}
+}
+
+func TestParseCreationTime(t *testing.T) {
+	tests := []struct {
+		raw    string
+		want   time.Time
+		wantOK bool
+	}{
+		{"2024-05-01T09:30:00.000000Z", time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC), true},
+		{"2024-05-01T11:30:00+0200", time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC), true},
+		{"2024-05-01 09:30:00", time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC), true},
+		{"1970-01-01T00:00:00.000000Z", time.Time{}, false},
+		{"05/01/2024", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, ok := parseCreationTime(tt.raw)
+			if ok != tt.wantOK || !got.Equal(tt.want) {
+				t.Errorf("parseCreationTime(%q) = %v, %v, want %v, %v", tt.raw, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGetVideoMetadataSingleProbe(t *testing.T) {
+	probe := `{
+		"streams": [
+			{"codec_type": "video", "width": 1920, "height": 1080, "nb_frames": 300, "avg_frame_rate": "30/1",
+			 "tags": {"creation_time": "2024-05-01T09:30:00.000000Z"}},
+			{"codec_type": "audio", "channels": 6}
+		],
+		"format": {"duration": "10.0", "tags": {"com.apple.quicktime.make": "Apple"}}
+	}`
+	ffmpeg, ffprobe, logFile := customTools(t, probe)
+	setVar(t, &ffmpegPath, ffmpeg)
+	setVar(t, &ffprobePath, ffprobe)
+
+	metadata, err := getVideoMetadata(context.Background(), "source.mov")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.AudioChannels != 6 {
+		t.Errorf("audio channels = %d, want 6", metadata.AudioChannels)
+	}
+	if metadata.Tags == nil || metadata.Tags.Make != "Apple" {
+		t.Errorf("tags = %+v, want the format's make", metadata.Tags)
+	}
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs := strings.Count(string(data), "ffprobe "); runs != 1 {
+		t.Errorf("ffprobe ran %d times, want once:\n%s", runs, data)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+// setColumn matches one "column"=$N assignment in an UPDATE
+var setColumn = regexp.MustCompile(`"(\w+)"=\$(\d+)`)
+
+// updatedColumns maps the columns an UPDATE sets to their values
+func updatedColumns(q testdb.Query) map[string]any {
+	values := map[string]any{}
+	for _, m := range setColumn.FindAllStringSubmatch(q.SQL, -1) {
+		var n int
+		fmt.Sscan(m[2], &n)
+		values[m[1]] = q.Args[n-1]
+	}
+	return values
+}
+
+func TestPhaseTimingsRecorded(t *testing.T) {
+	setVar(t, &gcsBucket, "videos")
+	useFakeTools(t, testProbe)
+	// Slow the tools down so each phase takes measurable time
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		fakeCommand(t, tool, fmt.Sprintf("sleep 0.05\n%s=%s exec '%s' \"$@\"", fakeToolEnv, tool, exe))
+	}
+
+	useRedis(t, nil)
+	gcsClient, _ := testgcs.Start(t)
+	gormDB, db := testdb.Open(t, nil)
+
+	started := time.Now()
+	if err := processVideoStreaming(context.Background(), gcsClient, gormDB, models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"}); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(started).Milliseconds()
+
+	updates := db.Matching(`"processing_ms"`)
+	if len(updates) != 1 {
+		t.Fatalf("timing updates = %v, want 1", updates)
+	}
+	values := updatedColumns(updates[0])
+	ms := func(column string) int64 {
+		v, ok := values[column].(int64)
+		if !ok {
+			t.Fatalf("%s = %v, want a millisecond count", column, values[column])
+		}
+		return v
+	}
+	probe, transcode, upload, total := ms("probe_ms"), ms("transcode_ms"), ms("upload_ms"), ms("processing_ms")
+
+	if probe < 50 || transcode < 50 || upload < 0 {
+		t.Errorf("phases = probe %dms, transcode %dms, upload %dms; want the tool delays reflected", probe, transcode, upload)
+	}
+	if sum := probe + transcode + upload; sum > total || total > elapsed {
+		t.Errorf("phases sum to %dms, total %dms, job took %dms", sum, total, elapsed)
+	}
+	// Setup and bookkeeping between phases should be small next to the phases
+	if sum := probe + transcode + upload; total-sum > 500 {
+		t.Errorf("total %dms is far above the %dms spent in phases", total, sum)
+	}
+}
+
+func TestPhaseTimingsString(t *testing.T) {
+	timings := phaseTimings{
+		Probe:     1234567 * time.Microsecond,
+		Transcode: 42 * time.Second,
+		Upload:    900 * time.Millisecond,
+		Total:     45*time.Second + 100*time.Millisecond,
+	}
+	want := "probe=1.235s transcode=42s upload=900ms total=45.1s"
+	if got := timings.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if !strings.Contains(phaseTimings{}.String(), "total=0s") {
+		t.Errorf("zero timings = %q", phaseTimings{}.String())
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+var (
+	// TINY_SOURCE_BITRATE sets the rates of the rendition made for sources
+	// shorter than every ladder entry: "scale" (default) scales the smallest
+	// entry's rates by picture area, "preset" keeps them unchanged
+	tinySourceBitrate = server_utils.GetEnv("TINY_SOURCE_BITRATE", "scale")
+	// Floor for the scaled video bitrate, in kbps
+	tinySourceMinBitrate = server_utils.GetEnvInt("TINY_SOURCE_MIN_BITRATE", 64)
+)
+
+func validateTinySource(mode string, minBitrate int) error {
+	if mode != "scale" && mode != "preset" {
+		return fmt.Errorf("unsupported TINY_SOURCE_BITRATE %q (expected scale or preset)", mode)
+	}
+	if minBitrate <= 0 {
+		return fmt.Errorf("TINY_SOURCE_MIN_BITRATE must be positive, got %d", minBitrate)
+	}
+	return nil
+}
+
+// tinyRendition builds the single rendition for a source below the smallest
+// ladder entry. The height is rounded down to even, which 4:2:0 encoding
+// needs. With "scale" the bitrate is the smallest entry's times the area
+// ratio, never below the floor nor above the entry itself, and max rate and
+// buffer keep the entry's proportions; audio keeps the entry's rate.
+func tinyRendition(ladder []Rendition, sourceHeight int, mode string, minBitrate int) Rendition {
+	if len(ladder) == 0 {
+		ladder = renditions
+	}
+	smallest := ladder[0]
+	for _, r := range ladder[1:] {
+		if r.Height < smallest.Height {
+			smallest = r
+		}
+	}
+
+	height := max(sourceHeight-sourceHeight%2, 2)
+	if mode == "preset" {
+		return Rendition{Height: height, Bitrate: smallest.Bitrate, MaxRate: smallest.MaxRate, BufSize: smallest.BufSize, AudioRate: smallest.AudioRate}
+	}
+
+	ratio := float64(height) / float64(smallest.Height)
+	bitrate := int(math.Round(float64(smallest.Bitrate) * ratio * ratio))
+	bitrate = min(max(bitrate, minBitrate), smallest.Bitrate)
+
+	scale := func(rate int) int {
+		return int(math.Round(float64(rate) * float64(bitrate) / float64(smallest.Bitrate)))
+	}
+	return Rendition{
+		Height:    height,
+		Bitrate:   bitrate,
+		MaxRate:   scale(smallest.MaxRate),
+		BufSize:   scale(smallest.BufSize),
+		AudioRate: smallest.AudioRate,
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTinyRendition(t *testing.T) {
+	tests := []struct {
+		name         string
+		sourceHeight int
+		mode         string
+		minBitrate   int
+		want         Rendition
+	}{
+		{"100px scales by area", 100, "scale", 64, Rendition{Height: 100, Bitrate: 145, MaxRate: 155, BufSize: 218, AudioRate: 96}},
+		{"50px hits the floor", 50, "scale", 64, Rendition{Height: 50, Bitrate: 64, MaxRate: 68, BufSize: 96, AudioRate: 96}},
+		{"odd height rounds down", 143, "scale", 64, Rendition{Height: 142, Bitrate: 292, MaxRate: 312, BufSize: 438, AudioRate: 96}},
+		{"floor above the entry is capped", 100, "scale", 1000, Rendition{Height: 100, Bitrate: 300, MaxRate: 321, BufSize: 450, AudioRate: 96}},
+		{"preset keeps the entry's rates", 100, "preset", 64, Rendition{Height: 100, Bitrate: 300, MaxRate: 321, BufSize: 450, AudioRate: 96}},
+		{"1px still gets an encodable height", 1, "scale", 64, Rendition{Height: 2, Bitrate: 64, MaxRate: 68, BufSize: 96, AudioRate: 96}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tinyRendition(renditions, tt.sourceHeight, tt.mode, tt.minBitrate)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tinyRendition(%d, %q, %d) = %+v, want %+v", tt.sourceHeight, tt.mode, tt.minBitrate, got, tt.want)
+			}
+			if got.MaxRate < got.Bitrate || got.BufSize < got.Bitrate {
+				t.Errorf("tinyRendition(%d) = %+v: max rate and buffer must not be below the bitrate", tt.sourceHeight, got)
+			}
+		})
+	}
+}
+
+func TestTinyRenditionUsesSmallestEntry(t *testing.T) {
+	ladder := []Rendition{
+		{Height: 480, Bitrate: 1400, MaxRate: 1498, BufSize: 2100, AudioRate: 128},
+		{Height: 240, Bitrate: 500, MaxRate: 535, BufSize: 750, AudioRate: 96},
+		{Height: 360, Bitrate: 800, MaxRate: 856, BufSize: 1200, AudioRate: 128},
+	}
+	want := Rendition{Height: 120, Bitrate: 125, MaxRate: 134, BufSize: 188, AudioRate: 96}
+	if got := tinyRendition(ladder, 120, "scale", 64); !reflect.DeepEqual(got, want) {
+		t.Errorf("tinyRendition = %+v, want %+v", got, want)
+	}
+}
+
+func TestValidateTinySource(t *testing.T) {
+	tests := []struct {
+		mode       string
+		minBitrate int
+		wantErr    bool
+	}{
+		{"scale", 64, false},
+		{"preset", 64, false},
+		{"area", 64, true},
+		{"scale", 0, true},
+		{"scale", -10, true},
+	}
+	for _, tt := range tests {
+		if err := validateTinySource(tt.mode, tt.minBitrate); (err != nil) != tt.wantErr {
+			t.Errorf("validateTinySource(%q, %d) error = %v, wantErr %v", tt.mode, tt.minBitrate, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math"
+	"strconv"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// Frame-accurate trimming decodes from the previous key frame; turning it off
+// starts at the key frame itself, which is faster but less precise
+var trimAccurateSeek = server_utils.GetEnvBool("TRIM_ACCURATE_SEEK", true)
+
+// trimArgs returns the input options that limit decoding to [start, end).
+// They go before -i so FFmpeg seeks in the input instead of decoding and
+// discarding everything up to start.
+func trimArgs(start, end float64) []string {
+	var args []string
+	if start > 0 {
+		if !trimAccurateSeek {
+			args = append(args, "-noaccurate_seek")
+		}
+		args = append(args, "-ss", strconv.FormatFloat(start, 'f', 3, 64))
+	}
+	if end > 0 {
+		args = append(args, "-to", strconv.FormatFloat(end, 'f', 3, 64))
+	}
+	return args
+}
+
+// applyTrim shrinks the probed duration and frame count to the trimmed
+// range, so progress is measured against what is actually encoded.
+func applyTrim(metadata *VideoMetadata, start, end float64) {
+	if start <= 0 && end <= 0 {
+		return
+	}
+
+	full := metadata.Duration
+	if end <= 0 || end > full {
+		end = full
+	}
+	trimmed := math.Max(end-start, 0)
+
+	if full > 0 && metadata.Frames > 0 {
+		metadata.Frames = int64(math.Round(float64(metadata.Frames) * trimmed / full))
+	}
+	metadata.Duration = trimmed
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestTrimArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		start, end float64
+		accurate   bool
+		want       []string
+	}{
+		{"untrimmed", 0, 0, true, nil},
+		{"start only", 30, 0, true, []string{"-ss", "30.000"}},
+		{"end only", 0, 90, true, []string{"-to", "90.000"}},
+		{"range", 30, 90.5, true, []string{"-ss", "30.000", "-to", "90.500"}},
+		{"key frame seek", 30, 90, false, []string{"-noaccurate_seek", "-ss", "30.000", "-to", "90.000"}},
+		{"key frame seek without start", 0, 90, false, []string{"-to", "90.000"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &trimAccurateSeek, tt.accurate)
+			if got := trimArgs(tt.start, tt.end); !slices.Equal(got, tt.want) {
+				t.Errorf("trimArgs(%v, %v) = %q, want %q", tt.start, tt.end, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyTrim(t *testing.T) {
+	tests := []struct {
+		name         string
+		start, end   float64
+		wantDuration float64
+		wantFrames   int64
+	}{
+		{"untrimmed", 0, 0, 120, 2880},
+		{"range", 30, 90, 60, 1440},
+		{"start only", 30, 0, 90, 2160},
+		{"end only", 0, 45, 45, 1080},
+		{"end past the source", 100, 500, 20, 480},
+		{"start past the source", 200, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata := &VideoMetadata{Duration: 120, Frames: 2880, FrameRate: 24}
+			applyTrim(metadata, tt.start, tt.end)
+			if metadata.Duration != tt.wantDuration || metadata.Frames != tt.wantFrames {
+				t.Errorf("applyTrim(%v, %v) = %vs, %d frames, want %vs, %d frames",
+					tt.start, tt.end, metadata.Duration, metadata.Frames, tt.wantDuration, tt.wantFrames)
+			}
+		})
+	}
+}
+
+func TestTranscodeAppliesTrim(t *testing.T) {
+	ffmpeg, ffprobe, logFile := customTools(t, testProbe)
+	setVar(t, &ffmpegPath, ffmpeg)
+	setVar(t, &ffprobePath, ffprobe)
+	setVar(t, &gcsBucket, "videos")
+
+	useRedis(t, nil)
+	gcsClient, _ := testgcs.Start(t)
+	gormDB, db := testdb.Open(t, nil)
+	job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4", StartSeconds: 0.5, EndSeconds: 1.5}
+	if err := processVideoStreaming(context.Background(), gcsClient, gormDB, job); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var transcode string
+	for _, call := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(call, "ffmpeg ") && strings.Contains(call, "-filter_complex ") {
+			transcode = call
+		}
+	}
+	// Seeking happens on the input, so the options come before -i
+	if want := " -ss 0.500 -to 1.500 -i "; !strings.Contains(transcode, want) {
+		t.Errorf("transcode args %q are missing %q", transcode, want)
+	}
+
+	// Progress is measured against the one trimmed second, not the full two
+	var recorded bool
+	for _, q := range db.Matching(`UPDATE "videos"`) {
+		columns := updatedColumns(q)
+		if duration, ok := columns["duration"]; ok {
+			recorded = true
+			if duration != 1.0 || columns["frames"] != int64(24) {
+				t.Errorf("stored duration %v and frames %v, want 1 and 24", duration, columns["frames"])
+			}
+		}
+	}
+	if !recorded {
+		t.Error("trimmed duration was never stored")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	// Outputs at least this large are uploaded in resumable parts; smaller
+	// ones, like most segments, go up in a single request
+	uploadMultipartThreshold = int64(server_utils.GetEnvInt("UPLOAD_MULTIPART_THRESHOLD", 16<<20))
+	// Size of each part of a resumable upload, buffered in memory; the
+	// client rounds it up to a multiple of 256 KiB
+	uploadPartSize = server_utils.GetEnvInt("UPLOAD_PART_SIZE", googleapi.DefaultUploadChunkSize)
+)
+
+func validateUploadPartSize(size int) error {
+	if size < googleapi.MinUploadChunkSize {
+		return fmt.Errorf("UPLOAD_PART_SIZE must be at least %d bytes, got %d", googleapi.MinUploadChunkSize, size)
+	}
+	return nil
+}
+
+// uploadChunkSize is the writer ChunkSize for an object of size bytes (-1
+// when unknown). Small objects get a single chunk just larger than they are,
+// so they go up in one request that is still retried; ChunkSize 0 would turn
+// retries off. Large ones get resumable uploads that retry per part.
+func uploadChunkSize(size int64) int {
+	if size >= 0 && size < uploadMultipartThreshold {
+		return int(size/googleapi.MinUploadChunkSize+1) * googleapi.MinUploadChunkSize
+	}
+	return uploadPartSize
+}
+
+// fileChunkSize is uploadChunkSize for an open local file
+func fileChunkSize(file *os.File) int {
+	info, err := file.Stat()
+	if err != nil {
+		return uploadChunkSize(-1)
+	}
+	return uploadChunkSize(info.Size())
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestUploadChunkSize(t *testing.T) {
+	setVar(t, &uploadMultipartThreshold, 32<<20)
+	setVar(t, &uploadPartSize, 8<<20)
+
+	const chunk = googleapi.MinUploadChunkSize
+	tests := []struct {
+		name string
+		size int64
+		want int
+	}{
+		{"empty", 0, chunk},
+		{"tiny", 100, chunk},
+		{"one byte short of a chunk", chunk - 1, chunk},
+		{"exactly a chunk", chunk, 2 * chunk},
+		{"just under the threshold", 32<<20 - 1, 32 << 20},
+		{"at the threshold", 32 << 20, 8 << 20},
+		{"large", 1 << 30, 8 << 20},
+		{"unknown", -1, 8 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := uploadChunkSize(tt.size)
+			if got != tt.want {
+				t.Errorf("uploadChunkSize(%d) = %d, want %d", tt.size, got, tt.want)
+			}
+			// Below the threshold the object goes up in one retried request
+			if tt.size >= 0 && tt.size < uploadMultipartThreshold && int64(got) <= tt.size {
+				t.Errorf("uploadChunkSize(%d) = %d does not fit the object in one chunk", tt.size, got)
+			}
+		})
+	}
+}
+
+func TestFileChunkSize(t *testing.T) {
+	setVar(t, &uploadMultipartThreshold, 1024)
+	setVar(t, &uploadPartSize, 2*googleapi.MinUploadChunkSize)
+
+	tests := []struct {
+		name string
+		size int
+		want int
+	}{
+		{"small file", 100, googleapi.MinUploadChunkSize},
+		{"large file", 4096, 2 * googleapi.MinUploadChunkSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "segment.ts")
+			if err := os.WriteFile(path, make([]byte, tt.size), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			if got := fileChunkSize(file); got != tt.want {
+				t.Errorf("fileChunkSize(%d bytes) = %d, want %d", tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateUploadPartSize(t *testing.T) {
+	tests := []struct {
+		size    int
+		wantErr bool
+	}{
+		{googleapi.DefaultUploadChunkSize, false},
+		{googleapi.MinUploadChunkSize, false},
+		{googleapi.MinUploadChunkSize - 1, true},
+		{0, true},
+	}
+	for _, tt := range tests {
+		if err := validateUploadPartSize(tt.size); (err != nil) != tt.wantErr {
+			t.Errorf("validateUploadPartSize(%d) error = %v, wantErr %v", tt.size, err, tt.wantErr)
+		}
+	}
+}
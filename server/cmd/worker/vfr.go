@@ -0,0 +1,52 @@
+package main
+
+import (
+	"math"
+	"strconv"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+)
+
+// NORMALIZE_FPS re-times variable frame rate sources, such as screen
+// recordings, to a constant rate at their average, so segments come out
+// even and progress counts the frames actually encoded
+var normalizeFPS = server_utils.GetEnvBool("NORMALIZE_FPS", false)
+
+// Relative difference between the base and average rates above which a
+// stream counts as variable frame rate. Some muxers round one of the two, so
+// an exact comparison would flag ordinary constant-rate files.
+const vfrTolerance = 0.01
+
+// isVariableFrameRate compares ffprobe's r_frame_rate, the lowest rate all
+// timestamps fit, with avg_frame_rate. They only differ when frame durations
+// vary. Unknown rates are never treated as variable.
+func isVariableFrameRate(rFrameRate, avgFrameRate string) bool {
+	base, avg := parseFrameRate(rFrameRate), parseFrameRate(avgFrameRate)
+	if base <= 0 || avg <= 0 {
+		return false
+	}
+	return math.Abs(base-avg)/avg > vfrTolerance
+}
+
+// cfrFilter returns the filter that re-times the video to a constant rate,
+// or "" when the source is left as it is
+func cfrFilter(rate float64) string {
+	if rate <= 0 {
+		return ""
+	}
+	return "fps=fps=" + strconv.FormatFloat(rate, 'f', 3, 64)
+}
+
+// planCFR returns the constant rate to encode at, or zero when the source
+// isn't normalized. The frame estimate becomes duration * rate, which is
+// what the fps filter outputs; nb_frames of a VFR source doesn't match it.
+func planCFR(enabled bool, metadata *VideoMetadata) float64 {
+	if !enabled || !metadata.VariableFrameRate || metadata.FrameRate <= 0 {
+		return 0
+	}
+	if metadata.Duration > 0 {
+		metadata.Frames = int64(math.Round(metadata.Duration * metadata.FrameRate))
+		metadata.FramesEstimated = true
+	}
+	return metadata.FrameRate
+}
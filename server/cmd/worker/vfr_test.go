@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testgcs"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestIsVariableFrameRate(t *testing.T) {
+	tests := []struct {
+		rFrameRate, avgFrameRate string
+		want                     bool
+	}{
+		{"30/1", "30/1", false},
+		{"30000/1001", "30000/1001", false},
+		// Rounded by the muxer, still within the tolerance
+		{"30/1", "30000/1001", false},
+		// Screen recording: 60 fps timebase, ~23.4 fps on average
+		{"60/1", "1403/60", true},
+		{"30/1", "25/1", true},
+		{"0/0", "25/1", false},
+		{"30/1", "0/0", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if got := isVariableFrameRate(tt.rFrameRate, tt.avgFrameRate); got != tt.want {
+			t.Errorf("isVariableFrameRate(%q, %q) = %v, want %v", tt.rFrameRate, tt.avgFrameRate, got, tt.want)
+		}
+	}
+}
+
+func TestCFRFilter(t *testing.T) {
+	tests := []struct {
+		rate float64
+		want string
+	}{
+		{0, ""},
+		{-1, ""},
+		{24, "fps=fps=24.000"},
+		{30000.0 / 1001, "fps=fps=29.970"},
+	}
+	for _, tt := range tests {
+		if got := cfrFilter(tt.rate); got != tt.want {
+			t.Errorf("cfrFilter(%v) = %q, want %q", tt.rate, got, tt.want)
+		}
+	}
+}
+
+func TestPlanCFR(t *testing.T) {
+	tests := []struct {
+		name          string
+		enabled       bool
+		metadata      VideoMetadata
+		wantRate      float64
+		wantFrames    int64
+		wantEstimated bool
+	}{
+		{
+			name:       "disabled",
+			metadata:   VideoMetadata{VariableFrameRate: true, FrameRate: 24, Duration: 10, Frames: 300},
+			wantFrames: 300,
+		},
+		{
+			name:       "constant rate source",
+			enabled:    true,
+			metadata:   VideoMetadata{FrameRate: 24, Duration: 10, Frames: 240},
+			wantFrames: 240,
+		},
+		{
+			name:          "variable rate source",
+			enabled:       true,
+			metadata:      VideoMetadata{VariableFrameRate: true, FrameRate: 23.4, Duration: 10, Frames: 300},
+			wantRate:      23.4,
+			wantFrames:    234,
+			wantEstimated: true,
+		},
+		{
+			name:       "unknown duration keeps the count",
+			enabled:    true,
+			metadata:   VideoMetadata{VariableFrameRate: true, FrameRate: 23.4, Frames: 300},
+			wantRate:   23.4,
+			wantFrames: 300,
+		},
+		{
+			name:       "unknown rate",
+			enabled:    true,
+			metadata:   VideoMetadata{VariableFrameRate: true, Duration: 10, Frames: 300},
+			wantFrames: 300,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata := tt.metadata
+			rate := planCFR(tt.enabled, &metadata)
+			if rate != tt.wantRate {
+				t.Errorf("planCFR rate = %v, want %v", rate, tt.wantRate)
+			}
+			if metadata.Frames != tt.wantFrames || metadata.FramesEstimated != tt.wantEstimated {
+				t.Errorf("frames = %d (estimated %v), want %d (estimated %v)", metadata.Frames, metadata.FramesEstimated, tt.wantFrames, tt.wantEstimated)
+			}
+		})
+	}
+}
+
+func TestRequiredFiltersForNormalizeFPS(t *testing.T) {
+	setVar(t, &avAlignMode, "off")
+	setVar(t, &normalizeFPS, true)
+	if got := requiredFilters(); !slices.Contains(got, "fps") {
+		t.Errorf("requiredFilters = %q, want fps with NORMALIZE_FPS", got)
+	}
+}
+
+func TestTranscodeNormalizesVFR(t *testing.T) {
+	vfrProbe := strings.Replace(testProbe, `"avg_frame_rate": "24/1"`, `"avg_frame_rate": "24/1", "r_frame_rate": "60/1"`, 1)
+
+	tests := []struct {
+		name      string
+		probe     string
+		normalize bool
+		wantHead  string
+	}{
+		{"variable rate source", vfrProbe, true, "[0:v:0]fps=fps=24.000,split="},
+		{"normalization off", vfrProbe, false, "[0:v:0]split="},
+		{"constant rate source", testProbe, true, "[0:v:0]split="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ffmpeg, ffprobe, logFile := customTools(t, tt.probe)
+			setVar(t, &ffmpegPath, ffmpeg)
+			setVar(t, &ffprobePath, ffprobe)
+			setVar(t, &gcsBucket, "videos")
+			setVar(t, &normalizeFPS, tt.normalize)
+
+			useRedis(t, nil)
+			gcsClient, _ := testgcs.Start(t)
+			gormDB, _ := testdb.Open(t, nil)
+			if err := processVideoStreaming(context.Background(), gcsClient, gormDB, models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/source.mp4"}); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(logFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var graph string
+			for _, call := range strings.Split(string(data), "\n") {
+				if _, after, ok := strings.Cut(call, " -filter_complex "); ok && strings.HasPrefix(call, "ffmpeg ") {
+					graph, _, _ = strings.Cut(after, " ")
+				}
+			}
+			if !strings.HasPrefix(graph, tt.wantHead) {
+				t.Errorf("filter graph %q does not start with %q", graph, tt.wantHead)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/devrayat000/video-process/models"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"github.com/google/uuid"
+)
+
+var (
+	// WEBHOOK_SECRET signs webhook bodies: X-Webhook-Signature carries
+	// "sha256=" and the hex HMAC-SHA256 of "{X-Webhook-Timestamp}.{body}".
+	// Requests go unsigned when it is empty.
+	webhookSecret = server_utils.GetEnv("WEBHOOK_SECRET", "")
+	// Least time between two progress events sent to one webhook
+	webhookProgressInterval = server_utils.GetEnvDuration("WEBHOOK_PROGRESS_INTERVAL", 10*time.Second)
+	// Further attempts after a webhook request fails
+	webhookRetries = server_utils.GetEnvInt("WEBHOOK_RETRIES", 3)
+)
+
+// Webhook URLs come from API clients, so they get the SSRF-guarded transport.
+// Redirects are not followed; a 3xx answer counts as a failure.
+var webhookClient = &http.Client{
+	Timeout:   server_utils.GetEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+	Transport: server_utils.RemoteHTTPClient.Transport,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// webhookQueueSize bounds the events waiting to be delivered per job. Past it
+// progress-type events are dropped rather than holding up the encode;
+// completed and failed wait for room.
+const webhookQueueSize = 32
+
+// webhookSink delivers progress events to the webhooks of the jobs this
+// worker is running. Each job's events are sent in order by its own
+// goroutine, so a slow endpoint only delays its own callbacks. It runs
+// alongside PROGRESS_SINKS, whatever that is set to.
+type webhookSink struct {
+	mu      sync.Mutex
+	targets map[uuid.UUID]*webhookTarget
+}
+
+type webhookTarget struct {
+	hook  models.Webhook
+	queue chan models.WebhookPayload
+
+	// mu guards lastProgress and closed, and is held while sending so the
+	// queue isn't closed under a waiting send
+	mu           sync.Mutex
+	lastProgress time.Time
+	closed       bool
+}
+
+var webhooks = &webhookSink{targets: make(map[uuid.UUID]*webhookTarget)}
+
+// track starts delivering the video's events to hook, if it has one. The
+// returned func stops tracking once the job is done; events already queued
+// are still sent.
+func (s *webhookSink) track(videoID uuid.UUID, hook *models.Webhook) func() {
+	if hook == nil {
+		return func() {}
+	}
+
+	target := &webhookTarget{hook: *hook, queue: make(chan models.WebhookPayload, webhookQueueSize)}
+	s.mu.Lock()
+	s.targets[videoID] = target
+	s.mu.Unlock()
+
+	go deliverWebhooks(withJobLog(context.Background(), videoID), target)
+
+	return func() {
+		s.mu.Lock()
+		if s.targets[videoID] == target {
+			delete(s.targets, videoID)
+		}
+		s.mu.Unlock()
+
+		target.mu.Lock()
+		defer target.mu.Unlock()
+		target.closed = true
+		close(target.queue)
+	}
+}
+
+func (s *webhookSink) Publish(p models.ProcessingProgress) error {
+	s.mu.Lock()
+	target, ok := s.targets[p.VideoID]
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	event := webhookEventFor(p)
+	if event == "" || !target.hook.Wants(event) {
+		return nil
+	}
+
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	if target.closed {
+		return nil
+	}
+	if event == models.WebhookProgress {
+		if time.Since(target.lastProgress) < webhookProgressInterval {
+			return nil
+		}
+		target.lastProgress = time.Now()
+	}
+
+	payload := models.WebhookPayload{Event: event, VideoID: p.VideoID, Progress: p, Timestamp: time.Now()}
+	if event == models.WebhookCompleted || event == models.WebhookFailed {
+		target.queue <- payload
+		return nil
+	}
+	select {
+	case target.queue <- payload:
+		return nil
+	default:
+		return fmt.Errorf("webhook queue for %s is full, dropping %s event", p.VideoID, event)
+	}
+}
+
+// webhookEventFor maps a progress event to the webhook event it represents
+func webhookEventFor(p models.ProcessingProgress) models.WebhookEvent {
+	switch p.Status {
+	case models.StatusStarted:
+		return models.WebhookStarted
+	case models.StatusCompleted:
+		return models.WebhookCompleted
+	case models.StatusFailed:
+		return models.WebhookFailed
+	case models.StatusProcessing:
+		switch {
+		case p.Resolution != "":
+			return models.WebhookRenditionReady
+		case p.TotalFrames > 0 || p.ProcessedFrames > 0:
+			return models.WebhookProgress
+		default:
+			return models.WebhookProcessing
+		}
+	}
+	return ""
+}
+
+// deliverWebhooks sends a target's queued events until the job stops
+// tracking it, retrying each up to WEBHOOK_RETRIES times with a growing pause
+func deliverWebhooks(ctx context.Context, target *webhookTarget) {
+	for payload := range target.queue {
+		for attempt := 0; ; attempt++ {
+			err := sendWebhook(ctx, target.hook.URL, payload)
+			if err == nil {
+				break
+			}
+			if attempt >= webhookRetries {
+				logf(ctx, " [!] Giving up on %s webhook after %d attempts: %v", payload.Event, attempt+1, err)
+				break
+			}
+			time.Sleep(time.Duration(attempt+1) * 2 * time.Second)
+		}
+	}
+}
+
+// sendWebhook POSTs one event, signed when WEBHOOK_SECRET is set. Any 2xx
+// answer is a success.
+func sendWebhook(ctx context.Context, url string, payload models.WebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", string(payload.Event))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	if webhookSecret != "" {
+		req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(webhookSecret, timestamp, body))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// signWebhook returns the hex HMAC-SHA256 of "{timestamp}.{body}"
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+// webhookReceiver records the webhook requests it is sent
+type webhookReceiver struct {
+	mu       sync.Mutex
+	payloads []models.WebhookPayload
+	headers  []http.Header
+	bodies   [][]byte
+}
+
+func (rc *webhookReceiver) events() []models.WebhookEvent {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	var events []models.WebhookEvent
+	for _, p := range rc.payloads {
+		events = append(events, p.Event)
+	}
+	return events
+}
+
+// useWebhookReceiver answers webhooks with status and lets the worker's
+// client reach it on loopback
+func useWebhookReceiver(t *testing.T, status int) (*webhookReceiver, string) {
+	t.Helper()
+	rc := &webhookReceiver{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload models.WebhookPayload
+		json.Unmarshal(body, &payload)
+		rc.mu.Lock()
+		rc.payloads = append(rc.payloads, payload)
+		rc.headers = append(rc.headers, r.Header.Clone())
+		rc.bodies = append(rc.bodies, body)
+		rc.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	// Keep the timeout and redirect policy, but not the SSRF guard
+	client := *webhookClient
+	client.Transport = srv.Client().Transport
+	setVar(t, &webhookClient, &client)
+	return rc, srv.URL + "/video"
+}
+
+func TestWebhookEventFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		progress models.ProcessingProgress
+		want     models.WebhookEvent
+	}{
+		{"started", models.ProcessingProgress{Status: models.StatusStarted}, models.WebhookStarted},
+		{"encoding begins", models.ProcessingProgress{Status: models.StatusProcessing, TotalResolutions: 2}, models.WebhookProcessing},
+		{"frames", models.ProcessingProgress{Status: models.StatusProcessing, ProcessedFrames: 10, TotalFrames: 48}, models.WebhookProgress},
+		{"rendition", models.ProcessingProgress{Status: models.StatusProcessing, Resolution: "720p", CurrentResolution: 1}, models.WebhookRenditionReady},
+		{"completed", models.ProcessingProgress{Status: models.StatusCompleted}, models.WebhookCompleted},
+		{"failed", models.ProcessingProgress{Status: models.StatusFailed, Error: "boom"}, models.WebhookFailed},
+		{"waiting", models.ProcessingProgress{Status: models.StatusWaiting}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := webhookEventFor(tt.progress); got != tt.want {
+				t.Errorf("webhookEventFor(%+v) = %q, want %q", tt.progress, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookSinkDeliversSubscribedEvents(t *testing.T) {
+	videoID := uuid.New()
+	job := []models.ProcessingProgress{
+		{VideoID: videoID, Status: models.StatusStarted},
+		{VideoID: videoID, Status: models.StatusProcessing, TotalResolutions: 2},
+		{VideoID: videoID, Status: models.StatusProcessing, ProcessedFrames: 10, TotalFrames: 48},
+		{VideoID: videoID, Status: models.StatusProcessing, Resolution: "720p", CurrentResolution: 1, TotalResolutions: 2},
+		{VideoID: videoID, Status: models.StatusProcessing, Resolution: "480p", CurrentResolution: 2, TotalResolutions: 2},
+		// Another job's events never reach this webhook
+		{VideoID: uuid.New(), Status: models.StatusStarted},
+		{VideoID: videoID, Status: models.StatusCompleted},
+	}
+
+	tests := []struct {
+		name   string
+		events []models.WebhookEvent
+		want   []models.WebhookEvent
+	}{
+		{"terminal by default", nil, []models.WebhookEvent{models.WebhookCompleted}},
+		{
+			name:   "transitions",
+			events: []models.WebhookEvent{models.WebhookStarted, models.WebhookProcessing, models.WebhookCompleted},
+			want:   []models.WebhookEvent{models.WebhookStarted, models.WebhookProcessing, models.WebhookCompleted},
+		},
+		{
+			name:   "renditions only",
+			events: []models.WebhookEvent{models.WebhookRenditionReady},
+			want:   []models.WebhookEvent{models.WebhookRenditionReady, models.WebhookRenditionReady},
+		},
+		{
+			name:   "progress",
+			events: []models.WebhookEvent{models.WebhookProgress, models.WebhookFailed},
+			want:   []models.WebhookEvent{models.WebhookProgress},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &webhookSecret, "")
+			setVar(t, &webhookProgressInterval, 0)
+			rc, url := useWebhookReceiver(t, http.StatusNoContent)
+			sink := &webhookSink{targets: make(map[uuid.UUID]*webhookTarget)}
+
+			done := sink.track(videoID, &models.Webhook{URL: url, Events: tt.events})
+			for _, p := range job {
+				if err := sink.Publish(p); err != nil {
+					t.Fatal(err)
+				}
+			}
+			done()
+
+			waitForWebhooks(t, rc, len(tt.want))
+			if got := rc.events(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("delivered %v, want %v", got, tt.want)
+			}
+			for i, p := range rc.payloads {
+				if p.VideoID != videoID || p.Progress.VideoID != videoID || p.Event != webhookEventFor(p.Progress) {
+					t.Errorf("payload %d = %+v, want the video's %s event", i, p, p.Event)
+				}
+				if got := rc.headers[i].Get("X-Webhook-Event"); got != string(p.Event) {
+					t.Errorf("X-Webhook-Event = %q, want %q", got, p.Event)
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookSinkThrottlesProgress(t *testing.T) {
+	setVar(t, &webhookProgressInterval, time.Hour)
+	rc, url := useWebhookReceiver(t, http.StatusOK)
+	sink := &webhookSink{targets: make(map[uuid.UUID]*webhookTarget)}
+	videoID := uuid.New()
+
+	done := sink.track(videoID, &models.Webhook{URL: url, Events: []models.WebhookEvent{models.WebhookProgress, models.WebhookRenditionReady}})
+	for frames := int64(10); frames <= 40; frames += 10 {
+		sink.Publish(models.ProcessingProgress{VideoID: videoID, Status: models.StatusProcessing, ProcessedFrames: frames, TotalFrames: 48})
+	}
+	// Only progress is throttled
+	sink.Publish(models.ProcessingProgress{VideoID: videoID, Status: models.StatusProcessing, Resolution: "720p"})
+	done()
+
+	want := []models.WebhookEvent{models.WebhookProgress, models.WebhookRenditionReady}
+	waitForWebhooks(t, rc, len(want))
+	if got := rc.events(); !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+	if frames := rc.payloads[0].Progress.ProcessedFrames; frames != 10 {
+		t.Errorf("progress event at %d frames, want the first at 10", frames)
+	}
+}
+
+func TestWebhookSinkQueueFull(t *testing.T) {
+	setVar(t, &webhookProgressInterval, 0)
+	videoID := uuid.New()
+	sink := &webhookSink{targets: make(map[uuid.UUID]*webhookTarget)}
+	// Nobody drains the queue, as with an endpoint that hangs
+	target := &webhookTarget{
+		hook:  models.Webhook{URL: "https://hooks.example.com/video", Events: []models.WebhookEvent{models.WebhookProgress, models.WebhookCompleted}},
+		queue: make(chan models.WebhookPayload, 1),
+	}
+	sink.targets[videoID] = target
+
+	progress := models.ProcessingProgress{VideoID: videoID, Status: models.StatusProcessing, ProcessedFrames: 10, TotalFrames: 48}
+	if err := sink.Publish(progress); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Publish(progress); err == nil {
+		t.Error("progress event queued past a full queue, want it dropped")
+	}
+
+	// The terminal event waits for room instead of being dropped
+	published := make(chan error, 1)
+	go func() {
+		published <- sink.Publish(models.ProcessingProgress{VideoID: videoID, Status: models.StatusCompleted})
+	}()
+	select {
+	case err := <-published:
+		t.Fatalf("completed event returned %v with the queue full, want it to wait", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if got := (<-target.queue).Event; got != models.WebhookProgress {
+		t.Fatalf("first event = %s, want progress", got)
+	}
+	if err := <-published; err != nil {
+		t.Fatal(err)
+	}
+	if got := (<-target.queue).Event; got != models.WebhookCompleted {
+		t.Errorf("second event = %s, want completed", got)
+	}
+}
+
+func TestWebhookSinkUntracked(t *testing.T) {
+	sink := &webhookSink{targets: make(map[uuid.UUID]*webhookTarget)}
+	done := sink.track(uuid.New(), nil)
+	done()
+	if err := sink.Publish(models.ProcessingProgress{VideoID: uuid.New(), Status: models.StatusCompleted}); err != nil {
+		t.Errorf("Publish for a job without a webhook = %v", err)
+	}
+}
+
+func TestSendWebhook(t *testing.T) {
+	payload := models.WebhookPayload{
+		Event:     models.WebhookCompleted,
+		VideoID:   uuid.New(),
+		Progress:  models.ProcessingProgress{Status: models.StatusCompleted},
+		Timestamp: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name          string
+		secret        string
+		status        int
+		wantErr       bool
+		wantSignature bool
+	}{
+		{"signed", "change-me", http.StatusOK, false, true},
+		{"unsigned", "", http.StatusAccepted, false, false},
+		{"endpoint error", "change-me", http.StatusInternalServerError, true, true},
+		{"redirect", "change-me", http.StatusFound, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &webhookSecret, tt.secret)
+			rc, url := useWebhookReceiver(t, tt.status)
+
+			err := sendWebhook(context.Background(), url, payload)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sendWebhook error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(rc.headers) != 1 {
+				t.Fatalf("endpoint got %d requests, want 1", len(rc.headers))
+			}
+			header := rc.headers[0]
+			if header.Get("Content-Type") != "application/json" {
+				t.Errorf("Content-Type = %q", header.Get("Content-Type"))
+			}
+			signature := header.Get("X-Webhook-Signature")
+			if !tt.wantSignature {
+				if signature != "" {
+					t.Errorf("unsigned request carries X-Webhook-Signature %q", signature)
+				}
+				return
+			}
+			want := "sha256=" + signWebhook(tt.secret, header.Get("X-Webhook-Timestamp"), rc.bodies[0])
+			if signature != want {
+				t.Errorf("X-Webhook-Signature = %q, want %q", signature, want)
+			}
+		})
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	// printf '%s' '1700000000.{"event":"completed"}' | openssl dgst -sha256 -hmac change-me
+	const want = "871c9db8cb62f302c12c9b6c7193e70b8867503fecde28852be159609aee2579"
+	if got := signWebhook("change-me", "1700000000", []byte(`{"event":"completed"}`)); got != want {
+		t.Errorf("signWebhook = %q, want %q", got, want)
+	}
+}
+
+// waitForWebhooks waits for the sink's delivery goroutine to send n events
+func waitForWebhooks(t *testing.T, rc *webhookReceiver, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(rc.events()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("delivered %v, want %d events", rc.events(), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// Give a wrongly queued extra event the chance to show up
+	time.Sleep(20 * time.Millisecond)
+}
@@ -20,6 +20,9 @@ var (
 	dbPass                 = server_utils.GetEnv("DB_PASSWORD", "password")
 	dbName                 = server_utils.GetEnv("DB_NAME", "videodb")
 	instanceConnectionName = os.Getenv("INSTANCE_CONNECTION_NAME")
+	// Optional read replica for read-only API queries; same credentials
+	dbReadHost = server_utils.GetEnv("DB_READ_HOST", "")
+	dbReadPort = server_utils.GetEnv("DB_READ_PORT", dbPort)
 )
 
 func InitDB() (*gorm.DB, error) {
@@ -42,6 +45,59 @@ func InitDB() (*gorm.DB, error) {
 
 	log.Println("Connecting to database with connection string:", connStr)
 
+	gormDB, err := open(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("Database connection established")
+
+	if err = gormDB.AutoMigrate(&models.Video{}, &models.VideoResolution{}, &models.VideoSubtitle{}, &models.OutboxEntry{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate database schema: %w", err)
+	}
+
+	if err = backfillResolutionSize(gormDB); err != nil {
+		return nil, fmt.Errorf("failed to backfill resolution sizes: %w", err)
+	}
+
+	return gormDB, nil
+}
+
+// backfillResolutionSize fills width and height for renditions recorded
+// before those columns existed, from the "720p" name and the source's aspect
+// ratio, rounded to an even width the way FFmpeg's scale=-2 does. Rows that
+// already have a height are left alone, so this is a no-op once done.
+func backfillResolutionSize(gormDB *gorm.DB) error {
+	return gormDB.Exec(`
+		UPDATE video_resolutions r
+		SET height = CAST(rtrim(r.resolution, 'p') AS integer),
+			width = CASE WHEN v.source_height > 0
+				THEN CAST(round(CAST(rtrim(r.resolution, 'p') AS numeric) * v.source_width / (2 * v.source_height)) AS integer) * 2
+				ELSE 0 END
+		FROM videos v
+		WHERE r.video_id = v.id AND r.height = 0 AND r.resolution ~ '^[0-9]+p$'`).Error
+}
+
+// InitReadDB connects to the read replica at DB_READ_HOST for queries that
+// can tolerate replication lag. Without a replica it returns primary.
+func InitReadDB(primary *gorm.DB) (*gorm.DB, error) {
+	if dbReadHost == "" {
+		return primary, nil
+	}
+
+	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		dbReadHost, dbReadPort, dbUser, dbPass, dbName)
+
+	readDB, err := open(postgres.Open(connStr))
+	if err != nil {
+		return nil, fmt.Errorf("read replica: %w", err)
+	}
+
+	log.Println("Read replica connection established:", dbReadHost)
+	return readDB, nil
+}
+
+func open(conn gorm.Dialector) (*gorm.DB, error) {
 	gormDB, err := gorm.Open(conn, &gorm.Config{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
@@ -55,11 +111,5 @@ func InitDB() (*gorm.DB, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	log.Println("Database connection established")
-
-	if err = gormDB.AutoMigrate(&models.Video{}, &models.VideoResolution{}); err != nil {
-		return nil, fmt.Errorf("failed to migrate database schema: %w", err)
-	}
-
 	return gormDB, nil
 }
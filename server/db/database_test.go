@@ -0,0 +1,78 @@
+package db
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+)
+
+func TestInitReadDB(t *testing.T) {
+	primary, _ := testdb.Open(t, nil)
+
+	// A port nothing listens on, so connecting fails fast
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+
+	tests := []struct {
+		name        string
+		host        string
+		wantPrimary bool
+		wantErr     bool
+	}{
+		{name: "no replica uses the primary", wantPrimary: true},
+		{name: "unreachable replica", host: "127.0.0.1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previousHost, previousPort := dbReadHost, dbReadPort
+			dbReadHost, dbReadPort = tt.host, closedPort
+			t.Cleanup(func() { dbReadHost, dbReadPort = previousHost, previousPort })
+
+			readDB, err := InitReadDB(primary)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("InitReadDB error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantPrimary && readDB != primary {
+				t.Error("InitReadDB opened a new connection instead of returning the primary")
+			}
+		})
+	}
+}
+
+func TestBackfillResolutionSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		wantErr bool
+	}{
+		{name: "backfilled"},
+		{name: "update fails", err: errors.New("relation does not exist"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDB, db := testdb.Open(t, func(q testdb.Query) testdb.Result {
+				return testdb.Result{Err: tt.err}
+			})
+
+			if err := backfillResolutionSize(gormDB); (err != nil) != tt.wantErr {
+				t.Fatalf("backfillResolutionSize error = %v, wantErr %v", err, tt.wantErr)
+			}
+			updates := db.Matching("UPDATE video_resolutions")
+			if len(updates) != 1 {
+				t.Fatalf("got %d updates, want 1", len(updates))
+			}
+			// Rows recorded with a size are never rewritten
+			if !strings.Contains(updates[0].SQL, "r.height = 0") {
+				t.Errorf("update is not limited to rows without a size:\n%s", updates[0].SQL)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ require (
 	github.com/GoogleCloudPlatform/cloudsql-proxy v1.37.10
 	github.com/google/uuid v1.6.0
 	github.com/redis/go-redis/v9 v9.17.0
+	google.golang.org/api v0.253.0
 	gorm.io/driver/postgres v1.6.0
 	gorm.io/gorm v1.31.1
 )
@@ -62,7 +63,6 @@ require (
 	golang.org/x/sys v0.38.0 // indirect
 	golang.org/x/text v0.31.0 // indirect
 	golang.org/x/time v0.14.0 // indirect
-	google.golang.org/api v0.253.0 // indirect
 	google.golang.org/genproto v0.0.0-20250603155806-513f23925822 // indirect
 	google.golang.org/genproto/googleapis/api v0.0.0-20250818200422-3122310a409c // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20251014184007-4626949a642f // indirect
@@ -0,0 +1,191 @@
+// Package testdb backs a *gorm.DB with a scripted database/sql driver for
+// tests. Every statement is recorded and answered by a handler, so code that
+// takes a *gorm.DB can be exercised without Postgres.
+package testdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Query is one statement the code under test ran
+type Query struct {
+	SQL  string
+	Args []any
+}
+
+// Result answers a Query: rows for reads, RowsAffected for writes, or an
+// error
+type Result struct {
+	Columns      []string
+	Rows         [][]any
+	RowsAffected int64
+	Err          error
+}
+
+// Handler decides the result of each statement; nil answers every statement
+// with an empty result
+type Handler func(q Query) Result
+
+// DB records the statements run against a test database
+type DB struct {
+	mu      sync.Mutex
+	handler Handler
+	queries []Query
+}
+
+// Open returns a *gorm.DB whose statements go to handler
+func Open(t testing.TB, handler Handler) (*gorm.DB, *DB) {
+	t.Helper()
+	d := &DB{handler: handler}
+	sqlDB := sql.OpenDB(connector{d})
+	t.Cleanup(func() { sqlDB.Close() })
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		DisableAutomaticPing: true,
+		Logger:               logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gormDB, d
+}
+
+// Queries returns every statement run so far
+func (d *DB) Queries() []Query {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Query(nil), d.queries...)
+}
+
+// Matching returns the statements whose SQL contains fragment
+func (d *DB) Matching(fragment string) []Query {
+	var matched []Query
+	for _, q := range d.Queries() {
+		if strings.Contains(q.SQL, fragment) {
+			matched = append(matched, q)
+		}
+	}
+	return matched
+}
+
+func (d *DB) run(query string, args []driver.NamedValue) Result {
+	q := Query{SQL: query}
+	for _, arg := range args {
+		q.Args = append(q.Args, arg.Value)
+	}
+
+	d.mu.Lock()
+	d.queries = append(d.queries, q)
+	handler := d.handler
+	d.mu.Unlock()
+
+	if handler == nil {
+		return Result{}
+	}
+	return handler(q)
+}
+
+type connector struct{ db *DB }
+
+func (c connector) Connect(context.Context) (driver.Conn, error) { return &conn{db: c.db}, nil }
+func (c connector) Driver() driver.Driver                        { return c }
+func (c connector) Open(string) (driver.Conn, error)             { return &conn{db: c.db}, nil }
+
+type conn struct{ db *DB }
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) { return &stmt{conn: c, query: query}, nil }
+func (c *conn) Close() error                              { return nil }
+func (c *conn) Begin() (driver.Tx, error)                 { return c.BeginTx(context.Background(), driver.TxOptions{}) }
+
+// BeginTx records transactions as BEGIN, COMMIT and ROLLBACK statements
+func (c *conn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	if r := c.db.run("BEGIN", nil); r.Err != nil {
+		return nil, r.Err
+	}
+	return tx{c.db}, nil
+}
+
+// CheckNamedValue converts arguments the way database/sql would, including
+// nil pointers whose Value method has a value receiver
+func (c *conn) CheckNamedValue(v *driver.NamedValue) error {
+	value, err := driver.DefaultParameterConverter.ConvertValue(v.Value)
+	if err != nil {
+		return driver.ErrSkip
+	}
+	v.Value = value
+	return nil
+}
+
+func (c *conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	r := c.db.run(query, args)
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return driver.RowsAffected(r.RowsAffected), nil
+}
+
+func (c *conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	r := c.db.run(query, args)
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return &rows{columns: r.Columns, values: r.Rows}, nil
+}
+
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return -1 }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, named(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, named(args))
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	out := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		out[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return out
+}
+
+type tx struct{ db *DB }
+
+func (t tx) Commit() error   { return t.db.run("COMMIT", nil).Err }
+func (t tx) Rollback() error { return t.db.run("ROLLBACK", nil).Err }
+
+type rows struct {
+	columns []string
+	values  [][]any
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	for i, v := range r.values[0] {
+		dest[i] = v
+	}
+	r.values = r.values[1:]
+	return nil
+}
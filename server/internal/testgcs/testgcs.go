@@ -0,0 +1,396 @@
+// Package testgcs serves an in-memory Cloud Storage JSON and XML API for
+// tests, enough for the storage client to list, read, write, stat and delete
+// objects without a real bucket.
+package testgcs
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// Object is one stored object
+type Object struct {
+	Data               []byte
+	ContentType        string
+	ContentDisposition string
+	Metadata           map[string]string
+	Updated            time.Time
+}
+
+// Server holds the objects of every bucket by bucket and object name
+type Server struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]*Object
+	uploads map[string]*upload
+	written []string
+	deleted []string
+}
+
+type upload struct {
+	bucket string
+	attrs  objectResource
+	data   bytes.Buffer
+}
+
+// objectResource is the JSON API representation of an object
+type objectResource struct {
+	Bucket      string            `json:"bucket"`
+	Name        string            `json:"name"`
+	Size        string            `json:"size,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Disposition string            `json:"contentDisposition,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Updated     string            `json:"updated,omitempty"`
+	Generation  string            `json:"generation,omitempty"`
+	MD5Hash     string            `json:"md5Hash,omitempty"`
+}
+
+// Start runs a server and returns a client that talks to it. Both are closed
+// when the test ends.
+func Start(t testing.TB) (*storage.Client, *Server) {
+	t.Helper()
+	s := &Server{
+		buckets: make(map[string]map[string]*Object),
+		uploads: make(map[string]*upload),
+	}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	t.Setenv("STORAGE_EMULATOR_HOST", srv.URL)
+	client, err := storage.NewClient(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, s
+}
+
+// Put stores an object
+func (s *Server) Put(bucket, name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(bucket, name, &Object{Data: data, Updated: time.Now()})
+}
+
+// Get returns a stored object
+func (s *Server) Get(bucket, name string) (*Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.buckets[bucket][name]
+	return obj, ok
+}
+
+// Names returns the sorted names of the objects in a bucket
+func (s *Server) Names(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.buckets[bucket] {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Written returns "bucket/name" for every object uploaded so far, in order
+func (s *Server) Written() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.written...)
+}
+
+// Deleted returns "bucket/name" for every object deleted so far, in order
+func (s *Server) Deleted() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.deleted...)
+}
+
+func (s *Server) put(bucket, name string, obj *Object) {
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string]*Object)
+	}
+	s.buckets[bucket][name] = obj
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := r.URL.EscapedPath()
+	switch {
+	case strings.HasPrefix(path, "/upload/storage/v1/b/"):
+		s.serveUpload(w, r, strings.TrimPrefix(path, "/upload/storage/v1/b/"))
+	case strings.HasPrefix(path, "/storage/v1/b/"):
+		s.serveJSON(w, r, strings.TrimPrefix(path, "/storage/v1/b/"))
+	default:
+		// XML API reads: /{bucket}/{object}
+		bucket, name, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+		s.serveMedia(w, r, unescape(bucket), unescape(name))
+	}
+}
+
+// serveJSON handles /storage/v1/b/{bucket}/o[/{object}]
+func (s *Server) serveJSON(w http.ResponseWriter, r *http.Request, path string) {
+	bucket, rest, _ := strings.Cut(path, "/")
+	bucket = unescape(bucket)
+	if rest == "o" {
+		s.list(w, r, bucket)
+		return
+	}
+	name, ok := strings.CutPrefix(rest, "o/")
+	if !ok {
+		http.Error(w, "unsupported path", http.StatusNotImplemented)
+		return
+	}
+	name = unescape(name)
+
+	obj, exists := s.buckets[bucket][name]
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			notFound(w)
+			return
+		}
+		if r.URL.Query().Get("alt") == "media" {
+			s.serveMedia(w, r, bucket, name)
+			return
+		}
+		writeJSON(w, resource(bucket, name, obj))
+	case http.MethodDelete:
+		if !exists {
+			notFound(w)
+			return
+		}
+		delete(s.buckets[bucket], name)
+		s.deleted = append(s.deleted, bucket+"/"+name)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPatch:
+		if !exists {
+			notFound(w)
+			return
+		}
+		var patch objectResource
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if patch.ContentType != "" {
+			obj.ContentType = patch.ContentType
+		}
+		for k, v := range patch.Metadata {
+			if obj.Metadata == nil {
+				obj.Metadata = make(map[string]string)
+			}
+			obj.Metadata[k] = v
+		}
+		writeJSON(w, resource(bucket, name, obj))
+	default:
+		http.Error(w, "unsupported method", http.StatusNotImplemented)
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request, bucket string) {
+	prefix := r.URL.Query().Get("prefix")
+	delimiter := r.URL.Query().Get("delimiter")
+
+	var names []string
+	for name := range s.buckets[bucket] {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	var items []objectResource
+	var prefixes []string
+	for _, name := range names {
+		if delimiter != "" {
+			if i := strings.Index(name[len(prefix):], delimiter); i >= 0 {
+				p := name[:len(prefix)+i+len(delimiter)]
+				if !slices.Contains(prefixes, p) {
+					prefixes = append(prefixes, p)
+				}
+				continue
+			}
+		}
+		items = append(items, resource(bucket, name, s.buckets[bucket][name]))
+	}
+
+	// Pages continue after the last name of the previous page
+	if token := r.URL.Query().Get("pageToken"); token != "" {
+		items = slices.DeleteFunc(items, func(item objectResource) bool { return item.Name <= token })
+	}
+	reply := map[string]any{"kind": "storage#objects", "prefixes": prefixes}
+	if max, err := strconv.Atoi(r.URL.Query().Get("maxResults")); err == nil && max > 0 && len(items) > max {
+		items = items[:max]
+		reply["nextPageToken"] = items[max-1].Name
+	}
+	reply["items"] = items
+	writeJSON(w, reply)
+}
+
+func (s *Server) serveMedia(w http.ResponseWriter, r *http.Request, bucket, name string) {
+	obj, ok := s.buckets[bucket][name]
+	if !ok {
+		notFound(w)
+		return
+	}
+	if obj.ContentType != "" {
+		w.Header().Set("Content-Type", obj.ContentType)
+	}
+	w.Header().Set("X-Goog-Generation", "1")
+	w.Header().Set("Last-Modified", obj.Updated.UTC().Format(http.TimeFormat))
+	w.Header().Set("Content-Length", strconv.Itoa(len(obj.Data)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(obj.Data)
+}
+
+// serveUpload handles multipart uploads and the steps of resumable ones
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request, path string) {
+	bucket, _, _ := strings.Cut(path, "/")
+	bucket = unescape(bucket)
+	query := r.URL.Query()
+
+	if id := query.Get("upload_id"); id != "" {
+		up, ok := s.uploads[id]
+		if !ok {
+			notFound(w)
+			return
+		}
+		io.Copy(&up.data, r.Body)
+		// The last chunk's Content-Range carries the total size
+		if cr := r.Header.Get("Content-Range"); strings.HasSuffix(cr, "/*") {
+			// Clients send X-GUploader-No-308 and expect the override header
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", up.data.Len()-1))
+			w.Header().Set("X-Http-Status-Code-Override", "308")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		delete(s.uploads, id)
+		s.finish(w, up.bucket, up.attrs, up.data.Bytes())
+		return
+	}
+
+	switch query.Get("uploadType") {
+	case "multipart":
+		attrs, data, err := readMultipart(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.finish(w, bucket, attrs, data)
+	case "resumable":
+		var attrs objectResource
+		if err := json.NewDecoder(r.Body).Decode(&attrs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if attrs.Name == "" {
+			attrs.Name = query.Get("name")
+		}
+		id := strconv.Itoa(len(s.uploads)+1) + "-" + attrs.Name
+		s.uploads[id] = &upload{bucket: bucket, attrs: attrs}
+		location := *r.URL
+		location.Scheme, location.Host = "http", r.Host
+		q := location.Query()
+		q.Set("upload_id", id)
+		location.RawQuery = q.Encode()
+		w.Header().Set("Location", location.String())
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "unsupported upload type", http.StatusNotImplemented)
+	}
+}
+
+func (s *Server) finish(w http.ResponseWriter, bucket string, attrs objectResource, data []byte) {
+	obj := &Object{
+		Data:               append([]byte(nil), data...),
+		ContentType:        attrs.ContentType,
+		ContentDisposition: attrs.Disposition,
+		Metadata:           attrs.Metadata,
+		Updated:            time.Now(),
+	}
+	s.put(bucket, attrs.Name, obj)
+	s.written = append(s.written, bucket+"/"+attrs.Name)
+	writeJSON(w, resource(bucket, attrs.Name, obj))
+}
+
+func readMultipart(r *http.Request) (objectResource, []byte, error) {
+	var attrs objectResource
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return attrs, nil, err
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+
+	part, err := mr.NextPart()
+	if err != nil {
+		return attrs, nil, err
+	}
+	if err := json.NewDecoder(part).Decode(&attrs); err != nil {
+		return attrs, nil, err
+	}
+
+	part, err = mr.NextPart()
+	if err != nil {
+		return attrs, nil, err
+	}
+	if attrs.ContentType == "" {
+		attrs.ContentType = part.Header.Get("Content-Type")
+	}
+	data, err := io.ReadAll(part)
+	return attrs, data, err
+}
+
+func resource(bucket, name string, obj *Object) objectResource {
+	sum := md5.Sum(obj.Data)
+	return objectResource{
+		Bucket:      bucket,
+		Name:        name,
+		Size:        strconv.Itoa(len(obj.Data)),
+		ContentType: obj.ContentType,
+		Disposition: obj.ContentDisposition,
+		Metadata:    obj.Metadata,
+		Updated:     obj.Updated.UTC().Format(time.RFC3339Nano),
+		Generation:  "1",
+		MD5Hash:     base64.StdEncoding.EncodeToString(sum[:]),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func notFound(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	io.WriteString(w, `{"error":{"code":404,"message":"No such object"}}`)
+}
+
+func unescape(s string) string {
+	if u, err := url.PathUnescape(s); err == nil {
+		return u
+	}
+	return s
+}
@@ -0,0 +1,331 @@
+// Package testredis runs a scripted RESP2 server for tests. Every command is
+// recorded and answered by a handler, so code that talks to Redis can be
+// exercised without a real server.
+package testredis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Status is a simple string reply such as OK
+type Status string
+
+// Handler answers one command. Replies are encoded by type: nil is a null
+// bulk string, string and []byte are bulk strings, Status is a simple string,
+// error is an error reply, integers are integer replies and []any is an
+// array. A nil Handler answers every command with OK.
+type Handler func(cmd []string) any
+
+// Server records the commands sent to a test Redis server
+type Server struct {
+	mu       sync.Mutex
+	handler  Handler
+	commands [][]string
+	// Connections subscribed to each channel, for Publish
+	subscribers map[string]map[*conn]bool
+}
+
+// conn serializes replies and pushed messages on one client connection
+type conn struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+func (c *conn) write(replies ...any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, reply := range replies {
+		writeReply(c.w, reply)
+	}
+	return c.w.Flush()
+}
+
+// Start runs a server answered by handler and returns a client connected to
+// it. Both are closed when the test ends.
+func Start(t testing.TB, handler Handler) (*redis.Client, *Server) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{handler: handler, subscribers: make(map[string]map[*conn]bool)}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.serve(c)
+			}()
+		}
+	}()
+
+	client := redis.NewClient(&redis.Options{
+		Addr:            ln.Addr().String(),
+		Protocol:        2,
+		DisableIdentity: true,
+		MaxRetries:      -1,
+	})
+	t.Cleanup(func() {
+		client.Close()
+		ln.Close()
+		wg.Wait()
+	})
+	return client, s
+}
+
+// Commands returns every command received so far, excluding the connection
+// handshake
+func (s *Server) Commands() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]string(nil), s.commands...)
+}
+
+// Named returns the commands with the given name, e.g. "XADD"
+func (s *Server) Named(name string) [][]string {
+	var matched [][]string
+	for _, cmd := range s.Commands() {
+		if strings.EqualFold(cmd[0], name) {
+			matched = append(matched, cmd)
+		}
+	}
+	return matched
+}
+
+// Publish sends message to every connection subscribed to channel, the way
+// a PUBLISH from another client would, and returns how many received it
+func (s *Server) Publish(channel, message string) int {
+	s.mu.Lock()
+	var targets []*conn
+	for c := range s.subscribers[channel] {
+		targets = append(targets, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range targets {
+		c.write([]any{"message", channel, message})
+	}
+	return len(targets)
+}
+
+// Subscribers returns how many connections are subscribed to channel
+func (s *Server) Subscribers(channel string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subscribers[channel])
+}
+
+func (s *Server) serve(nc net.Conn) {
+	defer nc.Close()
+	r := bufio.NewReader(nc)
+	c := &conn{w: bufio.NewWriter(nc)}
+	defer s.unsubscribe(c, nil)
+	// Replies to the commands queued since MULTI, sent back by EXEC
+	var queued []any
+	inMulti := false
+	for {
+		cmd, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		var replies []any
+		switch name := strings.ToUpper(cmd[0]); {
+		case name == "MULTI":
+			inMulti, queued = true, []any{}
+			replies = []any{Status("OK")}
+		case name == "EXEC":
+			inMulti = false
+			replies = []any{queued}
+		case inMulti:
+			queued = append(queued, s.answer(cmd))
+			replies = []any{Status("QUEUED")}
+		case name == "SUBSCRIBE":
+			replies = s.subscribe(c, cmd)
+		case name == "UNSUBSCRIBE":
+			replies = s.unsubscribe(c, cmd[1:])
+		default:
+			replies = []any{s.answer(cmd)}
+		}
+		c.mu.Lock()
+		for _, reply := range replies {
+			writeReply(c.w, reply)
+		}
+		// Pipelines are answered in one write
+		if r.Buffered() == 0 {
+			err = c.w.Flush()
+		}
+		c.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// subscribe registers c for each channel in a SUBSCRIBE and confirms each one
+func (s *Server) subscribe(c *conn, cmd []string) []any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands = append(s.commands, cmd)
+
+	var replies []any
+	for _, channel := range cmd[1:] {
+		if s.subscribers[channel] == nil {
+			s.subscribers[channel] = make(map[*conn]bool)
+		}
+		s.subscribers[channel][c] = true
+		replies = append(replies, []any{"subscribe", channel, s.subscriptions(c)})
+	}
+	return replies
+}
+
+// unsubscribe drops c from channels, or from all of them when none are given
+func (s *Server) unsubscribe(c *conn, channels []string) []any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(channels) == 0 {
+		for channel, conns := range s.subscribers {
+			if conns[c] {
+				channels = append(channels, channel)
+			}
+		}
+	}
+	var replies []any
+	for _, channel := range channels {
+		delete(s.subscribers[channel], c)
+		replies = append(replies, []any{"unsubscribe", channel, s.subscriptions(c)})
+	}
+	return replies
+}
+
+func (s *Server) subscriptions(c *conn) int {
+	n := 0
+	for _, conns := range s.subscribers {
+		if conns[c] {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) answer(cmd []string) any {
+	switch strings.ToUpper(cmd[0]) {
+	case "HELLO":
+		// Keeps the client on RESP2
+		return errors.New("ERR unknown command 'HELLO'")
+	case "CLIENT":
+		return Status("OK")
+	}
+
+	s.mu.Lock()
+	s.commands = append(s.commands, cmd)
+	handler := s.handler
+	s.mu.Unlock()
+
+	if handler == nil {
+		return Status("OK")
+	}
+	return handler(cmd)
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return nil, fmt.Errorf("unexpected request %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("bad array length %q", line)
+	}
+
+	cmd := make([]string, n)
+	for i := range cmd {
+		header, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(header) == 0 || header[0] != '$' {
+			return nil, fmt.Errorf("unexpected argument %q", header)
+		}
+		size, err := strconv.Atoi(header[1:])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("bad bulk length %q", header)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		cmd[i] = string(buf[:size])
+	}
+	return cmd, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+func writeReply(w *bufio.Writer, reply any) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case Status:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case error:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []byte:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case bool:
+		if v {
+			w.WriteString(":1\r\n")
+		} else {
+			w.WriteString(":0\r\n")
+		}
+	case []string:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	case []any:
+		if v == nil {
+			w.WriteString("*-1\r\n")
+			return
+		}
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	default:
+		fmt.Fprintf(w, "-ERR testredis cannot encode %T\r\n", v)
+	}
+}
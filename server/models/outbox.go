@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OutboxEntry is a job waiting to be pushed to the Redis stream. It is written
+// in the same transaction as the video row, so a Redis outage delays the job
+// instead of losing it.
+type OutboxEntry struct {
+	ID        uint64    `json:"id" db:"id" gorm:"column:id;primaryKey;autoIncrement"`
+	VideoID   uuid.UUID `json:"video_id" db:"video_id" gorm:"column:video_id;type:uuid;not null;index"`
+	Payload   string    `json:"payload" db:"payload" gorm:"column:payload;type:text;not null"`
+	Attempts  int       `json:"attempts" db:"attempts" gorm:"column:attempts;not null;default:0"`
+	LastError *string   `json:"last_error,omitempty" db:"last_error" gorm:"column:last_error;type:text"`
+	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"column:created_at;autoCreateTime"`
+}
+
+func (OutboxEntry) TableName() string {
+	return "job_outbox"
+}
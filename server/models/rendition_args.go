@@ -0,0 +1,102 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// renditionArgFlags are the encoder tuning flags a rendition may carry in
+// ExtraArgs. Each takes exactly one value and only affects how that
+// rendition's video stream is encoded. Anything that can name a file, change
+// stream mapping, add filters or alter the muxer is deliberately absent:
+// ExtraArgs come from API clients and end up on the worker's command line.
+var renditionArgFlags = map[string]bool{
+	"-tune":         true,
+	"-profile":      true,
+	"-level":        true,
+	"-x264-params":  true,
+	"-x265-params":  true,
+	"-bf":           true,
+	"-refs":         true,
+	"-rc-lookahead": true,
+	"-aq-mode":      true,
+	"-aq-strength":  true,
+	"-coder":        true,
+}
+
+// Values are plain words, numbers and x264-style key=value:key=value lists.
+// No slashes (paths), no leading dash (another flag) and no whitespace.
+var renditionArgValue = regexp.MustCompile(`^[A-Za-z0-9_.:=+,][A-Za-z0-9_.:=+,-]*$`)
+
+// encoderParamKeys are the keys -x264-params and -x265-params may set. The
+// encoders also take keys that read or write files (stats, dump-yuv, csv,
+// qpfile, zones files…), so the lists are checked key by key rather than
+// passed through.
+var encoderParamKeys = map[string]bool{
+	"aq-mode":        true,
+	"aq-strength":    true,
+	"psy-rd":         true,
+	"psy-rdoq":       true,
+	"deblock":        true,
+	"ref":            true,
+	"bframes":        true,
+	"b-adapt":        true,
+	"b-pyramid":      true,
+	"rc-lookahead":   true,
+	"me":             true,
+	"subme":          true,
+	"merange":        true,
+	"trellis":        true,
+	"no-fast-pskip":  true,
+	"keyint":         true,
+	"min-keyint":     true,
+	"scenecut":       true,
+	"no-scenecut":    true,
+	"open-gop":       true,
+	"no-open-gop":    true,
+	"sao":            true,
+	"no-sao":         true,
+	"weightp":        true,
+	"weightb":        true,
+	"mbtree":         true,
+	"no-mbtree":      true,
+	"qcomp":          true,
+	"tu-intra-depth": true,
+	"tu-inter-depth": true,
+}
+
+// validateEncoderParams checks every key in an x264/x265 key=value:key=value
+// list is in encoderParamKeys
+func validateEncoderParams(value string) error {
+	for _, param := range strings.Split(value, ":") {
+		key, _, _ := strings.Cut(param, "=")
+		if !encoderParamKeys[key] {
+			return fmt.Errorf("%q is not an allowed encoder parameter", key)
+		}
+	}
+	return nil
+}
+
+// ValidateExtraArgs checks ExtraArgs is a sequence of allowlisted flag/value
+// pairs.
+func (r Rendition) ValidateExtraArgs() error {
+	if len(r.ExtraArgs)%2 != 0 {
+		return fmt.Errorf("extra_args for %dp must be flag/value pairs", r.Height)
+	}
+	for i := 0; i < len(r.ExtraArgs); i += 2 {
+		flag, value := r.ExtraArgs[i], r.ExtraArgs[i+1]
+		if !renditionArgFlags[flag] {
+			return fmt.Errorf("extra_args for %dp: %q is not an allowed flag", r.Height, flag)
+		}
+		if !renditionArgValue.MatchString(value) {
+			return fmt.Errorf("extra_args for %dp: invalid value %q for %s", r.Height, value, flag)
+		}
+		if flag == "-x264-params" || flag == "-x265-params" {
+			if err := validateEncoderParams(value); err != nil {
+				return fmt.Errorf("extra_args for %dp: %s: %w", r.Height, flag, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package models
+
+import "testing"
+
+func TestValidateExtraArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"tuning flags", []string{"-tune", "film", "-bf", "3", "-profile", "high"}, false},
+		{"x264 params", []string{"-x264-params", "aq-mode=3:psy-rd=1.0,0.15:deblock=-1,-1"}, false},
+		{"x265 params", []string{"-x265-params", "no-sao=1:rc-lookahead=40"}, false},
+		{"x264 stats file", []string{"-x264-params", "aq-mode=2:stats=out.log"}, true},
+		{"x265 csv", []string{"-x265-params", "csv=x.csv"}, true},
+		{"x264 dump", []string{"-x264-params", "dump-yuv=x.yuv"}, true},
+		{"odd count", []string{"-tune"}, true},
+		{"unknown flag", []string{"-vf", "scale=1:1"}, true},
+		{"output flag", []string{"-f", "mp4"}, true},
+		{"path value", []string{"-tune", "/etc/passwd"}, true},
+		{"flag as value", []string{"-tune", "-y"}, true},
+		{"whitespace", []string{"-tune", "film grain"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Rendition{Height: 720, ExtraArgs: tt.args}.ValidateExtraArgs()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateExtraArgs(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+		})
+	}
+}
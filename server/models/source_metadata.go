@@ -0,0 +1,42 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// SourceMetadata holds descriptive tags read from the uploaded file. Every
+// field is optional; most sources only carry a few of them.
+type SourceMetadata struct {
+	CreationTime *time.Time `json:"creation_time,omitempty"`
+	Encoder      string     `json:"encoder,omitempty"`
+	Make         string     `json:"make,omitempty"`
+	Model        string     `json:"model,omitempty"`
+	// Location is the raw ISO 6709 string, e.g. "+37.7749-122.4194/"
+	Location  string   `json:"location,omitempty"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+}
+
+// Value stores the metadata as JSONB
+func (m SourceMetadata) Value() (driver.Value, error) {
+	return json.Marshal(m)
+}
+
+// Scan reads the metadata back from a JSONB column
+func (m *SourceMetadata) Scan(value any) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported source metadata type %T", value)
+	}
+	return json.Unmarshal(data, m)
+}
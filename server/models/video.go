@@ -1,6 +1,7 @@
 package models
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -16,35 +17,136 @@ const (
 	StatusFailed     VideoStatus = "failed"
 )
 
+// FailureCategory classifies why a job failed so callers can decide whether
+// running it again is worthwhile.
+type FailureCategory string
+
+const (
+	FailureSourceUnavailable FailureCategory = "source_unavailable"
+	FailureSourceNotFound    FailureCategory = "source_not_found"
+	FailureUnsupportedInput  FailureCategory = "unsupported_input"
+	FailureEncodeError       FailureCategory = "encode_error"
+	FailureUploadError       FailureCategory = "upload_error"
+	FailureDiskError         FailureCategory = "disk_error"
+)
+
+// Retryable reports whether a failure of this category is likely transient.
+func (c FailureCategory) Retryable() bool {
+	switch c {
+	case FailureSourceUnavailable, FailureUploadError, FailureDiskError:
+		return true
+	}
+	return false
+}
+
 type Video struct {
 	ID                uuid.UUID         `json:"id" db:"id" gorm:"column:id;type:uuid;default:gen_random_uuid();primaryKey"`
 	OriginalName      string            `json:"original_name" db:"original_name" gorm:"column:original_name;type:varchar(255);not null"`
 	S3Path            string            `json:"s3_path" db:"s3_path" gorm:"column:s3_path;type:text;not null"`
+	SourceBucket      string            `json:"source_bucket,omitempty" db:"source_bucket" gorm:"column:source_bucket;type:varchar(255)"`
+	OutputBucket      string            `json:"output_bucket,omitempty" db:"output_bucket" gorm:"column:output_bucket;type:varchar(255)"`
+	Profile           string            `json:"profile,omitempty" db:"profile" gorm:"column:profile;type:varchar(64)"`
+	StartSeconds      float64           `json:"start_seconds,omitempty" db:"start_seconds" gorm:"column:start_seconds;type:double precision"`
+	EndSeconds        float64           `json:"end_seconds,omitempty" db:"end_seconds" gorm:"column:end_seconds;type:double precision"`
+	SequenceFrameRate float64           `json:"sequence_frame_rate,omitempty" db:"sequence_frame_rate" gorm:"column:sequence_frame_rate;type:double precision"`
+	OutputVersion     int               `json:"output_version,omitempty" db:"output_version" gorm:"column:output_version;not null;default:0"`
 	Status            VideoStatus       `json:"status" db:"status" gorm:"column:status;type:varchar(32);not null"`
 	SourceHeight      int               `json:"source_height" db:"source_height" gorm:"column:source_height;not null"`
 	SourceWidth       int               `json:"source_width" db:"source_width" gorm:"column:source_width;not null"`
 	Duration          float64           `json:"duration" db:"duration" gorm:"column:duration;type:double precision;not null"`
 	Frames            int64             `json:"frames" db:"frames" gorm:"column:frames"`
+	AudioChannels     int               `json:"audio_channels,omitempty" db:"audio_channels" gorm:"column:audio_channels"`
+	VideoStream       int               `json:"video_stream" db:"video_stream" gorm:"column:video_stream;not null;default:0"`
+	Metadata          *SourceMetadata   `json:"metadata,omitempty" db:"metadata" gorm:"column:metadata;type:jsonb"`
+	ProbeJSON         *string           `json:"-" db:"probe_json" gorm:"column:probe_json;type:jsonb"`
+	Webhook           *Webhook          `json:"-" db:"webhook" gorm:"column:webhook;type:jsonb"`
+	FramesEstimated   bool              `json:"frames_estimated" db:"frames_estimated" gorm:"column:frames_estimated;not null;default:false"`
 	FileSize          int64             `json:"file_size" db:"file_size" gorm:"column:file_size;type:bigint;not null"`
+	SourceDeleted     bool              `json:"source_deleted" db:"source_deleted" gorm:"column:source_deleted;not null;default:false"`
 	MasterPlaylistKey *string           `json:"master_playlist_key" db:"master_playlist_key" gorm:"column:master_playlist_key;type:text"`
 	MasterPlaylistURL *string           `json:"master_playlist_url" db:"master_playlist_url" gorm:"column:master_playlist_url;type:text"`
+	ProgressiveKey    *string           `json:"progressive_key,omitempty" db:"progressive_key" gorm:"column:progressive_key;type:text"`
+	ProgressiveURL    *string           `json:"progressive_url,omitempty" db:"progressive_url" gorm:"column:progressive_url;type:text"`
 	CreatedAt         time.Time         `json:"created_at" db:"created_at" gorm:"column:created_at;autoCreateTime"`
 	UpdatedAt         time.Time         `json:"updated_at" db:"updated_at" gorm:"column:updated_at;autoUpdateTime"`
 	CompletedAt       *time.Time        `json:"completed_at,omitempty" db:"completed_at" gorm:"column:completed_at"`
 	ErrorMessage      *string           `json:"error_message,omitempty" db:"error_message" gorm:"column:error_message;type:text"`
+	FailureCategory   *FailureCategory  `json:"failure_category,omitempty" db:"failure_category" gorm:"column:failure_category;type:varchar(32)"`
+	JobClass          string            `json:"job_class,omitempty" db:"job_class" gorm:"column:job_class;type:varchar(16)"`
+	JobStreamID       string            `json:"job_stream_id,omitempty" db:"job_stream_id" gorm:"column:job_stream_id;type:varchar(64)"`
+	EncodeFallback    bool              `json:"encode_fallback" db:"encode_fallback" gorm:"column:encode_fallback;not null;default:false"`
+	ProbeMs           int64             `json:"probe_ms,omitempty" db:"probe_ms" gorm:"column:probe_ms"`
+	TranscodeMs       int64             `json:"transcode_ms,omitempty" db:"transcode_ms" gorm:"column:transcode_ms"`
+	UploadMs          int64             `json:"upload_ms,omitempty" db:"upload_ms" gorm:"column:upload_ms"`
+	ProcessingMs      int64             `json:"processing_ms,omitempty" db:"processing_ms" gorm:"column:processing_ms"`
 	Resolutions       []VideoResolution `json:"resolutions,omitempty" db:"-" gorm:"foreignKey:VideoID;references:ID;constraint:OnDelete:CASCADE"`
+	Subtitles         []VideoSubtitle   `json:"subtitles,omitempty" db:"-" gorm:"foreignKey:VideoID;references:ID;constraint:OnDelete:CASCADE"`
+}
+
+// OutputPrefix is the key prefix, with a trailing slash, of a video's output:
+// {id}/processed/ until a versioned reprocess moves it to {id}/processed/v{n}/
+// so the new objects get URLs no CDN has cached. It is also the prefix of
+// every version.
+func OutputPrefix(videoID uuid.UUID, version int) string {
+	if version <= 0 {
+		return fmt.Sprintf("%s/processed/", videoID)
+	}
+	return fmt.Sprintf("%s/processed/v%d/", videoID, version)
+}
+
+// OutputPrefix returns the prefix of the video's current output
+func (v Video) OutputPrefix() string {
+	return OutputPrefix(v.ID, v.OutputVersion)
+}
+
+// Job rebuilds the job that produces the video's output from its row, for
+// re-enqueueing it. Per-submission options that aren't stored, such as a
+// custom ladder, are left for the caller.
+func (v Video) Job() VideoJob {
+	job := VideoJob{
+		VideoID:       v.ID,
+		S3Path:        v.S3Path,
+		Bucket:        v.SourceBucket,
+		OriginalName:  v.OriginalName,
+		Profile:       v.Profile,
+		OutputBucket:  v.OutputBucket,
+		StartSeconds:  v.StartSeconds,
+		EndSeconds:    v.EndSeconds,
+		OutputVersion: v.OutputVersion,
+		Webhook:       v.Webhook,
+	}
+	if v.SequenceFrameRate > 0 {
+		job.ImageSequence = &ImageSequence{FrameRate: v.SequenceFrameRate}
+	}
+	return job
 }
 
 type VideoResolution struct {
-	ID            uuid.UUID `json:"id" db:"id" gorm:"column:id;type:uuid;default:gen_random_uuid();primaryKey"`
-	VideoID       uuid.UUID `json:"video_id" db:"video_id" gorm:"column:video_id;type:uuid;not null;index"`
-	Resolution    string    `json:"resolution" db:"resolution" gorm:"column:resolution;type:varchar(32);not null"`
-	PlaylistS3Key string    `json:"playlist_s3_key" db:"playlist_s3_key" gorm:"column:playlist_s3_key;type:text;not null"`
-	PlaylistURL   string    `json:"playlist_url" db:"playlist_url" gorm:"column:playlist_url;type:text;not null"`
-	SegmentCount  int       `json:"segment_count" db:"segment_count" gorm:"column:segment_count;not null"`
-	TotalSize     int64     `json:"total_size" db:"total_size" gorm:"column:total_size;type:bigint;not null"`
-	Bandwidth     int       `json:"bandwidth" db:"bandwidth" gorm:"column:bandwidth;not null"`
-	ProcessedAt   time.Time `json:"processed_at" db:"processed_at" gorm:"column:processed_at;autoCreateTime"`
+	ID               uuid.UUID `json:"id" db:"id" gorm:"column:id;type:uuid;default:gen_random_uuid();primaryKey"`
+	VideoID          uuid.UUID `json:"video_id" db:"video_id" gorm:"column:video_id;type:uuid;not null;index"`
+	Resolution       string    `json:"resolution" db:"resolution" gorm:"column:resolution;type:varchar(32);not null"`
+	Width            int       `json:"width" db:"width" gorm:"column:width;not null;default:0"`
+	Height           int       `json:"height" db:"height" gorm:"column:height;not null;default:0"`
+	PlaylistS3Key    string    `json:"playlist_s3_key" db:"playlist_s3_key" gorm:"column:playlist_s3_key;type:text;not null"`
+	PlaylistURL      string    `json:"playlist_url" db:"playlist_url" gorm:"column:playlist_url;type:text;not null"`
+	SegmentCount     int       `json:"segment_count" db:"segment_count" gorm:"column:segment_count;not null"`
+	TotalSize        int64     `json:"total_size" db:"total_size" gorm:"column:total_size;type:bigint;not null"`
+	Bandwidth        int       `json:"bandwidth" db:"bandwidth" gorm:"column:bandwidth;not null"`
+	Checksum         string    `json:"checksum,omitempty" db:"checksum" gorm:"column:checksum;type:varchar(64)"`
+	SegmentsChecksum string    `json:"segments_checksum,omitempty" db:"segments_checksum" gorm:"column:segments_checksum;type:varchar(64)"`
+	ProcessedAt      time.Time `json:"processed_at" db:"processed_at" gorm:"column:processed_at;autoCreateTime"`
+}
+
+// VideoSubtitle is a WebVTT caption track uploaded after processing and
+// listed in the master playlist. There is one per video and language.
+type VideoSubtitle struct {
+	ID          uuid.UUID `json:"id" db:"id" gorm:"column:id;type:uuid;default:gen_random_uuid();primaryKey"`
+	VideoID     uuid.UUID `json:"video_id" db:"video_id" gorm:"column:video_id;type:uuid;not null;uniqueIndex:idx_video_subtitle_language"`
+	Language    string    `json:"language" db:"language" gorm:"column:language;type:varchar(35);not null;uniqueIndex:idx_video_subtitle_language"`
+	S3Key       string    `json:"s3_key" db:"s3_key" gorm:"column:s3_key;type:text;not null"`
+	URL         string    `json:"url" db:"url" gorm:"column:url;type:text;not null"`
+	PlaylistKey string    `json:"playlist_key" db:"playlist_key" gorm:"column:playlist_key;type:text;not null"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at" gorm:"column:created_at;autoCreateTime"`
 }
 
 type ProcessingProgress struct {
@@ -52,12 +154,103 @@ type ProcessingProgress struct {
 	Status          VideoStatus `json:"status"`
 	TotalFrames     int64       `json:"total_frames,omitempty"`
 	ProcessedFrames int64       `json:"processed_frames,omitempty"`
-	Error           string      `json:"error,omitempty"`
-	Timestamp       time.Time   `json:"timestamp"`
+	// CurrentResolution is the 1-based index of the rendition this event
+	// reports as finished (named by Resolution) out of TotalResolutions
+	CurrentResolution int       `json:"current_resolution,omitempty"`
+	TotalResolutions  int       `json:"total_resolutions,omitempty"`
+	Resolution        string    `json:"resolution,omitempty"`
+	Error             string    `json:"error,omitempty"`
+	ErrorCategory     string    `json:"error_category,omitempty"`
+	Timestamp         time.Time `json:"timestamp"`
+}
+
+// VideoEvent is the durable record of a video reaching a terminal status,
+// appended to the video:events stream for other services
+type VideoEvent struct {
+	VideoID   uuid.UUID   `json:"video_id"`
+	Status    VideoStatus `json:"status"`
+	Video     *Video      `json:"video,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// Rendition defines a single video quality preset
+type Rendition struct {
+	Height    int `json:"height"`
+	Bitrate   int `json:"bitrate"`    // in kbps
+	MaxRate   int `json:"max_rate"`   // in kbps
+	BufSize   int `json:"buf_size"`   // in kbps
+	AudioRate int `json:"audio_rate"` // in kbps
+	// ExtraArgs are encoder tuning flag/value pairs, e.g. ["-tune", "film"],
+	// limited to the allowlist in ValidateExtraArgs
+	ExtraArgs []string `json:"extra_args,omitempty"`
 }
 
 type VideoJob struct {
 	VideoID      uuid.UUID `json:"video_id"`
 	S3Path       string    `json:"s3_path"`
 	OriginalName string    `json:"original_name"`
+	// Bucket makes S3Path an object key in that bucket instead of a full URL
+	Bucket string `json:"bucket,omitempty"`
+	// Renditions overrides the worker's default ladder when set
+	Renditions []Rendition `json:"renditions,omitempty"`
+	// Profile names a ladder from RENDITION_PROFILES_FILE; Renditions wins
+	// when both are set
+	Profile string `json:"profile,omitempty"`
+	// OutputBucket routes the output to a tenant bucket from OUTPUT_BUCKETS
+	OutputBucket string `json:"output_bucket,omitempty"`
+	// StartSeconds/EndSeconds limit processing to a clip; zero means unset
+	StartSeconds float64 `json:"start_seconds,omitempty"`
+	EndSeconds   float64 `json:"end_seconds,omitempty"`
+	// ForceProbe re-runs ffprobe instead of reusing cached source metadata
+	ForceProbe bool `json:"force_probe,omitempty"`
+	// ImageSequence marks the source as a ZIP of still frames to assemble
+	// into a video before transcoding
+	ImageSequence *ImageSequence `json:"image_sequence,omitempty"`
+	// OutputVersion selects the output prefix; see OutputPrefix
+	OutputVersion int `json:"output_version,omitempty"`
+	// Webhook is called on the job's state changes
+	Webhook *Webhook `json:"webhook,omitempty"`
+}
+
+// ImageSequence describes a source archive of numbered frames
+type ImageSequence struct {
+	// FrameRate is the input rate the frames are played at; the API fills
+	// in IMAGE_SEQUENCE_FRAME_RATE when it is zero
+	FrameRate float64 `json:"frame_rate"`
+}
+
+// Manifest is the machine-readable summary written to manifest.json under
+// the video's output prefix once it completes.
+type Manifest struct {
+	VideoID           uuid.UUID           `json:"video_id"`
+	OriginalName      string              `json:"original_name"`
+	Duration          float64             `json:"duration"`
+	SourceWidth       int                 `json:"source_width"`
+	SourceHeight      int                 `json:"source_height"`
+	MasterPlaylistKey string              `json:"master_playlist_key"`
+	MasterPlaylistURL string              `json:"master_playlist_url"`
+	MasterChecksum    string              `json:"master_checksum,omitempty"`
+	ProgressiveURL    *string             `json:"progressive_url,omitempty"`
+	Timings           *ManifestTimings    `json:"timings,omitempty"`
+	Renditions        []ManifestRendition `json:"renditions"`
+	GeneratedAt       time.Time           `json:"generated_at"`
+}
+
+// ManifestTimings breaks the processing time down by phase, in milliseconds.
+// Total also covers setup and waiting for an encoder slot.
+type ManifestTimings struct {
+	ProbeMs     int64 `json:"probe_ms"`
+	TranscodeMs int64 `json:"transcode_ms"`
+	UploadMs    int64 `json:"upload_ms"`
+	TotalMs     int64 `json:"total_ms"`
+}
+
+type ManifestRendition struct {
+	Resolution   string `json:"resolution"`
+	Bandwidth    int    `json:"bandwidth"`
+	SegmentCount int    `json:"segment_count"`
+	TotalSize    int64  `json:"total_size"`
+	PlaylistKey  string `json:"playlist_key"`
+	PlaylistURL  string `json:"playlist_url"`
+	Checksum     string `json:"checksum,omitempty"`
 }
@@ -0,0 +1,191 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+func TestFailureCategoryRetryable(t *testing.T) {
+	tests := []struct {
+		category FailureCategory
+		want     bool
+	}{
+		{FailureSourceUnavailable, true},
+		{FailureUploadError, true},
+		{FailureDiskError, true},
+		{FailureSourceNotFound, false},
+		{FailureUnsupportedInput, false},
+		{FailureEncodeError, false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.category), func(t *testing.T) {
+			if got := tt.category.Retryable(); got != tt.want {
+				t.Errorf("%q.Retryable() = %v, want %v", tt.category, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutputPrefix(t *testing.T) {
+	id := uuid.MustParse("6f1c2a9e-0b7d-4c55-9d1e-3a8f4b2c1d00")
+	tests := []struct {
+		version int
+		want    string
+	}{
+		{0, "6f1c2a9e-0b7d-4c55-9d1e-3a8f4b2c1d00/processed/"},
+		{-1, "6f1c2a9e-0b7d-4c55-9d1e-3a8f4b2c1d00/processed/"},
+		{1, "6f1c2a9e-0b7d-4c55-9d1e-3a8f4b2c1d00/processed/v1/"},
+		{12, "6f1c2a9e-0b7d-4c55-9d1e-3a8f4b2c1d00/processed/v12/"},
+	}
+	for _, tt := range tests {
+		if got := OutputPrefix(id, tt.version); got != tt.want {
+			t.Errorf("OutputPrefix(%d) = %q, want %q", tt.version, got, tt.want)
+		}
+		if got := (Video{ID: id, OutputVersion: tt.version}).OutputPrefix(); got != tt.want {
+			t.Errorf("Video.OutputPrefix() with version %d = %q, want %q", tt.version, got, tt.want)
+		}
+		// Every version lives under the unversioned prefix
+		if !strings.HasPrefix(OutputPrefix(id, tt.version), OutputPrefix(id, 0)) {
+			t.Errorf("version %d is outside the unversioned prefix", tt.version)
+		}
+	}
+}
+
+func TestVideoJob(t *testing.T) {
+	id := uuid.New()
+	hook := &Webhook{URL: "https://hooks.example.com/video"}
+	tests := []struct {
+		name  string
+		video Video
+		want  VideoJob
+	}{
+		{
+			name:  "upload",
+			video: Video{ID: id, S3Path: "gs://uploads/a.mp4", OriginalName: "a.mp4", Status: StatusFailed},
+			want:  VideoJob{VideoID: id, S3Path: "gs://uploads/a.mp4", OriginalName: "a.mp4"},
+		},
+		{
+			name: "stored options",
+			video: Video{
+				ID: id, S3Path: "a.mp4", SourceBucket: "uploads", OriginalName: "a.mp4",
+				Profile: "mobile", OutputBucket: "tenant-out", StartSeconds: 5, EndSeconds: 20,
+				OutputVersion: 2, Webhook: hook,
+			},
+			want: VideoJob{
+				VideoID: id, S3Path: "a.mp4", Bucket: "uploads", OriginalName: "a.mp4",
+				Profile: "mobile", OutputBucket: "tenant-out", StartSeconds: 5, EndSeconds: 20,
+				OutputVersion: 2, Webhook: hook,
+			},
+		},
+		{
+			name:  "image sequence",
+			video: Video{ID: id, S3Path: "gs://uploads/frames.zip", OriginalName: "frames.zip", SequenceFrameRate: 24},
+			want:  VideoJob{VideoID: id, S3Path: "gs://uploads/frames.zip", OriginalName: "frames.zip", ImageSequence: &ImageSequence{FrameRate: 24}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.video.Job(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Job() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourceMetadataRoundTrip(t *testing.T) {
+	lat, lon := 37.7749, -122.4194
+	created := time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)
+	original := SourceMetadata{CreationTime: &created, Make: "Apple", Latitude: &lat, Longitude: &lon}
+
+	value, err := original.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"creation_time":"2024-05-01T09:30:00Z","make":"Apple","latitude":37.7749,"longitude":-122.4194}`; string(value.([]byte)) != want {
+		t.Errorf("Value = %s, want %s", value, want)
+	}
+
+	for _, stored := range []any{value, string(value.([]byte))} {
+		var scanned SourceMetadata
+		if err := scanned.Scan(stored); err != nil {
+			t.Fatal(err)
+		}
+		if !scanned.CreationTime.Equal(created) || scanned.Make != "Apple" || *scanned.Latitude != lat || *scanned.Longitude != lon {
+			t.Errorf("Scan(%T) = %+v, want %+v", stored, scanned, original)
+		}
+	}
+}
+
+func TestSourceMetadataScanErrors(t *testing.T) {
+	var m SourceMetadata
+	if err := m.Scan(nil); err != nil {
+		t.Errorf("Scan(nil) = %v, want nil", err)
+	}
+	if err := m.Scan(42); err == nil {
+		t.Error("Scan(int) succeeded, want an error")
+	}
+	if err := m.Scan([]byte("{")); err == nil {
+		t.Error("Scan of malformed JSON succeeded, want an error")
+	}
+}
+
+func TestVideoIDJSON(t *testing.T) {
+	id := uuid.MustParse("6f1c1b1e-3f0a-4b7e-9a51-2d4c8e9f0a12")
+
+	data, err := json.Marshal(VideoJob{VideoID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"video_id":"6f1c1b1e-3f0a-4b7e-9a51-2d4c8e9f0a12"`) {
+		t.Errorf("VideoJob JSON = %s, want the id as a string", data)
+	}
+
+	var job VideoJob
+	if err := json.Unmarshal(data, &job); err != nil || job.VideoID != id {
+		t.Errorf("round trip = %v, %v, want %v", job.VideoID, err, id)
+	}
+	if err := json.Unmarshal([]byte(`{"video_id":"not-a-uuid"}`), &job); err == nil {
+		t.Error("malformed video_id decoded without an error")
+	}
+}
+
+func TestVideoIDDatabaseRoundTrip(t *testing.T) {
+	id := uuid.New()
+	gormDB, db := testdb.Open(t, func(q testdb.Query) testdb.Result {
+		if strings.HasPrefix(q.SQL, "SELECT") {
+			// Postgres returns uuid columns as text
+			return testdb.Result{Columns: []string{"id", "status"}, Rows: [][]any{{id.String(), "waiting"}}}
+		}
+		return testdb.Result{RowsAffected: 1}
+	})
+	ctx := context.Background()
+
+	if err := gorm.G[Video](gormDB).Create(ctx, &Video{ID: id, OriginalName: "a.mp4", S3Path: "a.mp4", Status: StatusWaiting}); err != nil {
+		t.Fatal(err)
+	}
+	inserts := db.Matching(`INSERT INTO "videos"`)
+	if len(inserts) != 1 || !slices.Contains(inserts[0].Args, any(id.String())) {
+		t.Errorf("INSERT = %v, want the id bound as its string form", inserts)
+	}
+
+	video, err := gorm.G[Video](gormDB).Where("id = ?", id).First(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.ID != id {
+		t.Errorf("scanned id = %v, want %v", video.ID, id)
+	}
+	if selects := db.Matching("SELECT"); len(selects) != 1 || selects[0].Args[0] != id.String() {
+		t.Errorf("SELECT = %v, want the id bound as its string form", selects)
+	}
+}
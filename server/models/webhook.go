@@ -0,0 +1,111 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WebhookEvent names a kind of state change a webhook can subscribe to
+type WebhookEvent string
+
+const (
+	// WebhookStarted fires when a worker picks the job up
+	WebhookStarted WebhookEvent = "started"
+	// WebhookProcessing fires once the source is probed and encoding begins
+	WebhookProcessing WebhookEvent = "processing"
+	// WebhookProgress fires with encoding progress, at most once per
+	// WEBHOOK_PROGRESS_INTERVAL
+	WebhookProgress WebhookEvent = "progress"
+	// WebhookRenditionReady fires as each rendition lands in storage
+	WebhookRenditionReady WebhookEvent = "rendition_ready"
+	WebhookCompleted      WebhookEvent = "completed"
+	WebhookFailed         WebhookEvent = "failed"
+)
+
+var webhookEvents = map[WebhookEvent]bool{
+	WebhookStarted:        true,
+	WebhookProcessing:     true,
+	WebhookProgress:       true,
+	WebhookRenditionReady: true,
+	WebhookCompleted:      true,
+	WebhookFailed:         true,
+}
+
+// Webhook is a per-video callback. Events selects what is sent; with none
+// listed only the terminal completed and failed events are.
+type Webhook struct {
+	URL    string         `json:"url"`
+	Events []WebhookEvent `json:"events,omitempty"`
+}
+
+// Validate checks the URL is absolute http(s) without credentials and every
+// event is known. Where the URL may point is enforced when delivering.
+func (w Webhook) Validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("webhook url must be http or https")
+	}
+	if u.Host == "" {
+		return fmt.Errorf("webhook url has no host")
+	}
+	if u.User != nil {
+		return fmt.Errorf("webhook url must not contain credentials")
+	}
+	for _, event := range w.Events {
+		if !webhookEvents[event] {
+			return fmt.Errorf("unknown webhook event %q", event)
+		}
+	}
+	return nil
+}
+
+// Wants reports whether the webhook subscribes to event
+func (w Webhook) Wants(event WebhookEvent) bool {
+	if len(w.Events) == 0 {
+		return event == WebhookCompleted || event == WebhookFailed
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Value stores the webhook as JSONB
+func (w Webhook) Value() (driver.Value, error) {
+	return json.Marshal(w)
+}
+
+// Scan reads the webhook back from a JSONB column
+func (w *Webhook) Scan(value any) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("unsupported webhook type %T", value)
+	}
+	return json.Unmarshal(data, w)
+}
+
+// WebhookPayload is the body of every webhook request. Progress is the same
+// event the progress endpoints serve.
+type WebhookPayload struct {
+	Event     WebhookEvent       `json:"event"`
+	VideoID   uuid.UUID          `json:"video_id"`
+	Progress  ProcessingProgress `json:"progress"`
+	Timestamp time.Time          `json:"timestamp"`
+}
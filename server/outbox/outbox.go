@@ -0,0 +1,145 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/devrayat000/video-process/models"
+	"github.com/devrayat000/video-process/pubsub"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// How often the relay looks for jobs that couldn't be enqueued right away
+	pollInterval = server_utils.GetEnvDuration("OUTBOX_POLL_INTERVAL", 5*time.Second)
+	// Entries handled per relay pass
+	batchSize = server_utils.GetEnvInt("OUTBOX_BATCH_SIZE", 50)
+)
+
+// Add records a job in the outbox. Call it with the transaction that creates
+// or resets the video so both commit or roll back together.
+func Add(ctx context.Context, tx *gorm.DB, job models.VideoJob) (*models.OutboxEntry, error) {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal job: %w", err)
+	}
+
+	entry := &models.OutboxEntry{
+		VideoID: job.VideoID,
+		Payload: string(data),
+	}
+	if err := gorm.G[models.OutboxEntry](tx).Create(ctx, entry); err != nil {
+		return nil, fmt.Errorf("failed to write outbox entry: %w", err)
+	}
+
+	return entry, nil
+}
+
+// Dispatch pushes one entry to the stream and removes it from the outbox,
+// returning the stream ID. On failure the entry stays behind for the relay.
+// The ID is empty when the relay got to the entry first.
+func Dispatch(ctx context.Context, gormDB *gorm.DB, entry *models.OutboxEntry) (string, error) {
+	var streamID string
+	var dispatchErr error
+	err := gormDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Skip the entry if the relay is already sending it
+		var locked []models.OutboxEntry
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("id = ?", entry.ID).Find(&locked).Error
+		if err != nil || len(locked) == 0 {
+			return err
+		}
+
+		// Commit even when the enqueue fails so the attempt is recorded
+		streamID, dispatchErr = dispatch(ctx, tx, &locked[0])
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return streamID, dispatchErr
+}
+
+// dispatch enqueues an entry, records the stream ID on its video and deletes
+// the entry
+func dispatch(ctx context.Context, tx *gorm.DB, entry *models.OutboxEntry) (string, error) {
+	var job models.VideoJob
+	if err := json.Unmarshal([]byte(entry.Payload), &job); err != nil {
+		// A payload we can't read will never succeed; drop it
+		log.Printf(" [!] Dropping unreadable outbox entry %d: %v", entry.ID, err)
+		_, err := gorm.G[models.OutboxEntry](tx).Where("id = ?", entry.ID).Delete(ctx)
+		return "", err
+	}
+
+	streamID, err := pubsub.EnqueueJob(job)
+	if err != nil {
+		msg := err.Error()
+		tx.Model(&models.OutboxEntry{}).Where("id = ?", entry.ID).Updates(map[string]any{
+			"attempts":   gorm.Expr("attempts + 1"),
+			"last_error": msg,
+		})
+		return "", err
+	}
+
+	// Lets clients cancel the job by stream ID while it's still queued
+	if err := tx.Model(&models.Video{}).Where("id = ?", job.VideoID).Update("job_stream_id", streamID).Error; err != nil {
+		return "", err
+	}
+
+	// The job is already on the stream; if this delete is lost the relay
+	// sends it again, so delivery is at-least-once.
+	if _, err := gorm.G[models.OutboxEntry](tx).Where("id = ?", entry.ID).Delete(ctx); err != nil {
+		return "", err
+	}
+	return streamID, nil
+}
+
+// Relay drains the outbox until ctx is cancelled. Rows are locked with
+// SKIP LOCKED, so several API replicas can run it side by side.
+func Relay(ctx context.Context, gormDB *gorm.DB) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if n, err := drain(ctx, gormDB); err != nil {
+			log.Printf(" [!] Outbox relay: %v", err)
+		} else if n > 0 {
+			log.Printf(" [i] Outbox relay enqueued %d job(s)", n)
+		}
+	}
+}
+
+// drain dispatches one batch and returns how many entries were enqueued. It
+// stops at the first enqueue error, since Redis is most likely down.
+func drain(ctx context.Context, gormDB *gorm.DB) (int, error) {
+	sent := 0
+	err := gormDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var entries []models.OutboxEntry
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Order("id").Limit(batchSize).Find(&entries).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range entries {
+			if _, err := dispatch(ctx, tx, &entries[i]); err != nil {
+				// Keep the attempt counters already written
+				return nil
+			}
+			sent++
+		}
+		return nil
+	})
+	return sent, err
+}
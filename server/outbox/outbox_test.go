@@ -0,0 +1,224 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testdb"
+	"github.com/devrayat000/video-process/internal/testredis"
+	"github.com/devrayat000/video-process/models"
+	"github.com/devrayat000/video-process/pubsub"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// useRedis points pubsub at a test server for the test
+func useRedis(t *testing.T, handler testredis.Handler) *testredis.Server {
+	t.Helper()
+	client, server := testredis.Start(t, handler)
+	previous := pubsub.RedisClient
+	pubsub.RedisClient = client
+	t.Cleanup(func() { pubsub.RedisClient = previous })
+	return server
+}
+
+// redisUp accepts every XADD; redisDown refuses every command
+func redisUp(cmd []string) any { return "1-0" }
+
+func redisDown(cmd []string) any { return errors.New("ERR connection refused") }
+
+// outboxRows answers job_outbox SELECTs with entries and everything else
+// with one affected row
+func outboxRows(entries ...models.OutboxEntry) testdb.Handler {
+	return func(q testdb.Query) testdb.Result {
+		if !strings.HasPrefix(q.SQL, "SELECT") {
+			return testdb.Result{RowsAffected: 1}
+		}
+		rows := make([][]any, len(entries))
+		for i, e := range entries {
+			rows[i] = []any{int64(e.ID), e.VideoID.String(), e.Payload, int64(e.Attempts)}
+		}
+		return testdb.Result{Columns: []string{"id", "video_id", "payload", "attempts"}, Rows: rows}
+	}
+}
+
+func entryFor(t *testing.T, id uint64) models.OutboxEntry {
+	t.Helper()
+	job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://videos/a.mp4"}
+	data, err := json.Marshal(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return models.OutboxEntry{ID: id, VideoID: job.VideoID, Payload: string(data)}
+}
+
+func TestAdd(t *testing.T) {
+	gormDB, db := testdb.Open(t, func(q testdb.Query) testdb.Result {
+		return testdb.Result{Columns: []string{"id"}, Rows: [][]any{{int64(7)}}, RowsAffected: 1}
+	})
+	job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://videos/a.mp4", OriginalName: "a.mp4"}
+
+	entry, err := Add(context.Background(), gormDB, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.ID != 7 || entry.VideoID != job.VideoID {
+		t.Errorf("entry = %+v", entry)
+	}
+
+	var stored models.VideoJob
+	if err := json.Unmarshal([]byte(entry.Payload), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.VideoID != job.VideoID || stored.S3Path != job.S3Path || stored.OriginalName != job.OriginalName {
+		t.Errorf("payload = %+v, want %+v", stored, job)
+	}
+	if len(db.Matching(`INSERT INTO "job_outbox"`)) != 1 {
+		t.Errorf("queries = %v, want one outbox insert", db.Queries())
+	}
+}
+
+func TestAddRollsBackWithTransaction(t *testing.T) {
+	gormDB, db := testdb.Open(t, func(q testdb.Query) testdb.Result {
+		if strings.HasPrefix(q.SQL, `INSERT INTO "job_outbox"`) {
+			return testdb.Result{Err: errors.New("disk full")}
+		}
+		return testdb.Result{RowsAffected: 1}
+	})
+	video := &models.Video{ID: uuid.New(), Status: models.StatusWaiting}
+
+	err := gormDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(video).Error; err != nil {
+			return err
+		}
+		_, err := Add(context.Background(), tx, models.VideoJob{VideoID: video.ID})
+		return err
+	})
+	if err == nil {
+		t.Fatal("expected the outbox failure to fail the transaction")
+	}
+	if len(db.Matching("ROLLBACK")) != 1 || len(db.Matching("COMMIT")) != 0 {
+		t.Errorf("video row was not rolled back: %v", db.Queries())
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	tests := []struct {
+		name         string
+		redis        testredis.Handler
+		locked       bool
+		payload      string
+		wantErr      bool
+		wantEnqueued bool
+		wantDeleted  bool
+		wantAttempt  bool
+	}{
+		{name: "enqueued and removed", redis: redisUp, wantEnqueued: true, wantDeleted: true},
+		{name: "redis down keeps the entry", redis: redisDown, wantErr: true, wantAttempt: true},
+		{name: "entry held by the relay", redis: redisUp, locked: true},
+		{name: "unreadable payload is dropped", redis: redisUp, payload: "{", wantDeleted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := entryFor(t, 3)
+			if tt.payload != "" {
+				entry.Payload = tt.payload
+			}
+			rows := []models.OutboxEntry{entry}
+			if tt.locked {
+				rows = nil
+			}
+			rdb := useRedis(t, tt.redis)
+			gormDB, db := testdb.Open(t, outboxRows(rows...))
+
+			streamID, err := Dispatch(context.Background(), gormDB, &entry)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Dispatch error = %v, wantErr %v", err, tt.wantErr)
+			}
+			wantStreamID := ""
+			if tt.wantEnqueued {
+				wantStreamID = "1-0"
+			}
+			if streamID != wantStreamID {
+				t.Errorf("stream ID = %q, want %q", streamID, wantStreamID)
+			}
+			// The ID is stored on the video so the job can be cancelled
+			recorded := db.Matching(`UPDATE "videos" SET "job_stream_id"`)
+			if got := len(recorded) == 1 && slices.Contains(recorded[0].Args, any("1-0")); got != tt.wantEnqueued {
+				t.Errorf("stream ID recorded = %v, want %v: %v", got, tt.wantEnqueued, recorded)
+			}
+
+			if q := db.Matching("SELECT"); len(q) != 1 || !strings.Contains(q[0].SQL, "FOR UPDATE SKIP LOCKED") {
+				t.Errorf("entry not locked with SKIP LOCKED: %v", q)
+			}
+			if got := len(rdb.Named("XADD")) == 1; got != tt.wantEnqueued && !tt.wantErr {
+				t.Errorf("enqueued = %v, want %v", got, tt.wantEnqueued)
+			}
+			if got := len(db.Matching(`DELETE FROM "job_outbox"`)) == 1; got != tt.wantDeleted {
+				t.Errorf("deleted = %v, want %v", got, tt.wantDeleted)
+			}
+			attempts := db.Matching(`UPDATE "job_outbox" SET "attempts"=attempts + 1`)
+			if got := len(attempts) == 1; got != tt.wantAttempt {
+				t.Errorf("attempt recorded = %v, want %v", got, tt.wantAttempt)
+			}
+			// The attempt must survive the failure
+			if tt.wantAttempt && len(db.Matching("COMMIT")) != 1 {
+				t.Errorf("attempt counter not committed: %v", db.Queries())
+			}
+		})
+	}
+}
+
+func TestDrain(t *testing.T) {
+	setVar(t, &batchSize, 10)
+	entries := []models.OutboxEntry{entryFor(t, 1), entryFor(t, 2), entryFor(t, 3)}
+
+	t.Run("sends the batch in order", func(t *testing.T) {
+		rdb := useRedis(t, redisUp)
+		gormDB, db := testdb.Open(t, outboxRows(entries...))
+
+		sent, err := drain(context.Background(), gormDB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sent != 3 || len(rdb.Named("XADD")) != 3 || len(db.Matching(`DELETE FROM "job_outbox"`)) != 3 {
+			t.Errorf("sent %d, %d XADDs, %d deletes; want 3 of each", sent, len(rdb.Named("XADD")), len(db.Matching("DELETE")))
+		}
+		q := db.Matching("SELECT")
+		if len(q) != 1 || !strings.Contains(q[0].SQL, "ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED") ||
+			!slices.Equal(q[0].Args, []any{int64(10)}) {
+			t.Errorf("batch query = %v", q)
+		}
+	})
+
+	t.Run("stops at the first failure", func(t *testing.T) {
+		useRedis(t, redisDown)
+		gormDB, db := testdb.Open(t, outboxRows(entries...))
+
+		sent, err := drain(context.Background(), gormDB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sent != 0 {
+			t.Errorf("sent = %d, want 0", sent)
+		}
+		if n := len(db.Matching(`UPDATE "job_outbox"`)); n != 1 {
+			t.Errorf("recorded %d attempts, want 1 before giving up", n)
+		}
+		if len(db.Matching("COMMIT")) != 1 {
+			t.Error("attempt counter not committed")
+		}
+	})
+}
+
+// setVar overrides a package setting for the test
+func setVar[T any](t *testing.T, v *T, value T) {
+	t.Helper()
+	previous := *v
+	*v = value
+	t.Cleanup(func() { *v = previous })
+}
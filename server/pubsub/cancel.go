@@ -0,0 +1,110 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/devrayat000/video-process/models"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// ErrJobNotFound means the stream has no entry with the given ID
+	ErrJobNotFound = errors.New("job not found in stream")
+	// ErrJobDelivered means a worker has already picked the job up
+	ErrJobDelivered = errors.New("job already delivered to a worker")
+)
+
+// ValidStreamID reports whether id looks like a Redis stream entry ID
+func ValidStreamID(id string) bool {
+	_, _, ok := parseStreamID(id)
+	return ok
+}
+
+func parseStreamID(id string) (ms, seq uint64, ok bool) {
+	msPart, seqPart, found := strings.Cut(id, "-")
+	if !found {
+		return 0, 0, false
+	}
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	seq, err = strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return ms, seq, true
+}
+
+// streamIDAfter reports whether stream ID a comes strictly after b
+func streamIDAfter(a, b string) bool {
+	ams, aseq, _ := parseStreamID(a)
+	bms, bseq, _ := parseStreamID(b)
+	return ams > bms || (ams == bms && aseq > bseq)
+}
+
+// CancelQueuedJob removes a job from the stream if no worker has read it
+// yet and returns it. Jobs already handed to a consumer, whether running or
+// waiting for a retry, give ErrJobDelivered and are left alone.
+func CancelQueuedJob(ctx context.Context, streamID string) (models.VideoJob, error) {
+	messages, err := RedisClient.XRangeN(ctx, VideoJobsStream, streamID, streamID, 1).Result()
+	if err != nil {
+		return models.VideoJob{}, fmt.Errorf("failed to read job %s: %w", streamID, err)
+	}
+	if len(messages) == 0 {
+		return models.VideoJob{}, ErrJobNotFound
+	}
+	job, err := parseJob(messages[0].Values)
+	if err != nil {
+		return models.VideoJob{}, err
+	}
+
+	delivered, err := jobDelivered(ctx, streamID)
+	if err != nil {
+		return models.VideoJob{}, err
+	}
+	if delivered {
+		return models.VideoJob{}, ErrJobDelivered
+	}
+
+	if err := RedisClient.XDel(ctx, VideoJobsStream, streamID).Err(); err != nil {
+		return models.VideoJob{}, fmt.Errorf("failed to delete job %s: %w", streamID, err)
+	}
+
+	// A consumer may have read the entry between the check and the delete.
+	// It already holds the payload, so the job goes ahead.
+	pending, err := RedisClient.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: VideoJobsStream,
+		Group:  ConsumerGroup,
+		Start:  streamID,
+		End:    streamID,
+		Count:  1,
+	}).Result()
+	if err != nil {
+		return models.VideoJob{}, fmt.Errorf("failed to check job %s: %w", streamID, err)
+	}
+	if len(pending) > 0 {
+		return models.VideoJob{}, ErrJobDelivered
+	}
+
+	return job, nil
+}
+
+// jobDelivered reports whether the consumer group's cursor has passed the
+// entry, i.e. a worker has read it at some point
+func jobDelivered(ctx context.Context, streamID string) (bool, error) {
+	groups, err := RedisClient.XInfoGroups(ctx, VideoJobsStream).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect consumer groups: %w", err)
+	}
+	for _, g := range groups {
+		if g.Name == ConsumerGroup {
+			return !streamIDAfter(streamID, g.LastDeliveredID), nil
+		}
+	}
+	return false, nil
+}
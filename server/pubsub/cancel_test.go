@@ -0,0 +1,124 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestValidStreamID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"1700000000000-0", true},
+		{"0-0", true},
+		{"1700000000000-12", true},
+		{"1700000000000", false},
+		{"abc-0", false},
+		{"1-x", false},
+		{"-1-0", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := ValidStreamID(tt.id); got != tt.want {
+			t.Errorf("ValidStreamID(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestStreamIDAfter(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"2-0", "1-0", true},
+		{"1-1", "1-0", true},
+		{"1-0", "1-0", false},
+		{"1-0", "1-1", false},
+		{"10-0", "9-5", true}, // numeric, not lexical
+	}
+	for _, tt := range tests {
+		if got := streamIDAfter(tt.a, tt.b); got != tt.want {
+			t.Errorf("streamIDAfter(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// consumerGroup is an XINFO GROUPS reply for the worker group
+func consumerGroup(lastDelivered string) []any {
+	return []any{[]any{
+		"name", ConsumerGroup,
+		"consumers", int64(1),
+		"pending", int64(0),
+		"last-delivered-id", lastDelivered,
+		"entries-read", int64(1),
+		"lag", int64(0),
+	}}
+}
+
+func TestCancelQueuedJob(t *testing.T) {
+	job := models.VideoJob{VideoID: uuid.New(), S3Path: "gs://uploads/a.mp4"}
+	const id = "1700000000000-3"
+
+	tests := []struct {
+		name       string
+		entry      bool
+		groups     []any
+		pendingNow bool // a consumer read the entry between check and XDEL
+		wantErr    error
+		wantXDel   bool
+	}{
+		{name: "queued", entry: true, groups: consumerGroup("1700000000000-2"), wantXDel: true},
+		{name: "no consumer group yet", entry: true, groups: []any{}, wantXDel: true},
+		{name: "unknown entry", wantErr: ErrJobNotFound},
+		{name: "already read", entry: true, groups: consumerGroup(id), wantErr: ErrJobDelivered},
+		{name: "read long ago", entry: true, groups: consumerGroup("1700000000001-0"), wantErr: ErrJobDelivered},
+		{name: "read during the delete", entry: true, groups: consumerGroup("1700000000000-2"), pendingNow: true, wantErr: ErrJobDelivered, wantXDel: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rdb := useRedis(t, func(cmd []string) any {
+				switch cmd[0] {
+				case "xrange":
+					if !tt.entry {
+						return []any{}
+					}
+					return []any{streamEntry(t, id, job)}
+				case "xinfo":
+					return tt.groups
+				case "xdel":
+					return int64(1)
+				case "xpending":
+					if tt.pendingNow {
+						return []any{[]any{id, "worker-a", int64(5), int64(1)}}
+					}
+					return []any{}
+				}
+				return nil
+			})
+
+			got, err := CancelQueuedJob(context.Background(), id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CancelQueuedJob error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && got.VideoID != job.VideoID {
+				t.Errorf("cancelled job for %s, want %s", got.VideoID, job.VideoID)
+			}
+			if xdel := rdb.Named("XDEL"); (len(xdel) == 1) != tt.wantXDel {
+				t.Errorf("XDEL calls = %q, want deleted %v", xdel, tt.wantXDel)
+			}
+		})
+	}
+}
+
+func TestCancelQueuedJobRedisError(t *testing.T) {
+	useRedis(t, func(cmd []string) any { return errors.New("ERR connection reset") })
+	_, err := CancelQueuedJob(context.Background(), "1-0")
+	if err == nil || errors.Is(err, ErrJobNotFound) || errors.Is(err, ErrJobDelivered) {
+		t.Errorf("CancelQueuedJob error = %v, want a plain Redis error", err)
+	}
+}
@@ -0,0 +1,56 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+// DeadLetterStream keeps jobs that failed on every allowed delivery, with
+// the last error, for inspection or manual re-submission
+const DeadLetterStream = "video:jobs:dead"
+
+// Deliveries a failing job gets before it is moved to the dead-letter stream;
+// each redelivery happens after RECLAIM_MIN_IDLE
+var MaxDeliveries = server_utils.GetEnvInt("JOB_MAX_DELIVERIES", 3)
+
+// ErrJobFailed marks a handler error as final. The message is acknowledged
+// rather than left pending, since running the job again can't help.
+var ErrJobFailed = errors.New("job failed permanently")
+
+// deliveryCount returns how often a pending message has been delivered
+func deliveryCount(ctx context.Context, id string) (int64, error) {
+	pending, err := RedisClient.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: VideoJobsStream,
+		Group:  ConsumerGroup,
+		Start:  id,
+		End:    id,
+		Count:  1,
+	}).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read delivery count of %s: %w", id, err)
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+	return pending[0].RetryCount, nil
+}
+
+// deadLetter copies a message to DeadLetterStream with its error and then
+// acknowledges it. If the copy fails the message stays pending.
+func deadLetter(ctx context.Context, message redis.XMessage, cause error) error {
+	values := map[string]interface{}{
+		"message_id": message.ID,
+		"error":      server_utils.RedactSecrets(cause.Error()),
+	}
+	if data, ok := message.Values["data"]; ok {
+		values["data"] = data
+	}
+	if err := RedisClient.XAdd(ctx, &redis.XAddArgs{Stream: DeadLetterStream, Values: values}).Err(); err != nil {
+		return fmt.Errorf("failed to dead-letter job %s: %w", message.ID, err)
+	}
+	return RedisClient.XAck(ctx, VideoJobsStream, ConsumerGroup, message.ID).Err()
+}
@@ -0,0 +1,121 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testredis"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestProcessMessageFailures(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		deliveries     int64
+		pendingErr     bool
+		deadLetterErr  bool
+		wantAck        bool
+		wantDeadLetter bool
+	}{
+		{name: "permanent failure", err: fmt.Errorf("%w: moov atom not found", ErrJobFailed), deliveries: 1, wantAck: true},
+		{name: "retryable, first delivery", err: errors.New("connection reset"), deliveries: 1},
+		{name: "retryable, deliveries left", err: errors.New("connection reset"), deliveries: 2},
+		{name: "retryable, out of deliveries", err: errors.New("connection reset"), deliveries: 3, wantAck: true, wantDeadLetter: true},
+		{name: "delivery count unknown", err: errors.New("connection reset"), pendingErr: true},
+		{name: "dead-letter write fails", err: errors.New("connection reset"), deliveries: 3, deadLetterErr: true, wantDeadLetter: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &MaxDeliveries, 3)
+			server := useRedis(t, func(cmd []string) any {
+				switch cmd[0] {
+				case "xpending":
+					if tt.pendingErr {
+						return errors.New("ERR connection refused")
+					}
+					return []any{[]any{"1-0", ConsumerName, int64(60000), tt.deliveries}}
+				case "xadd":
+					if tt.deadLetterErr {
+						return errors.New("ERR connection refused")
+					}
+					return "1-1"
+				}
+				return int64(1)
+			})
+			job := models.VideoJob{VideoID: uuid.New()}
+			data, err := json.Marshal(job)
+			if err != nil {
+				t.Fatal(err)
+			}
+			message := redis.XMessage{ID: "1-0", Values: map[string]any{"video_id": job.VideoID.String(), "data": string(data)}}
+
+			processMessage(context.Background(), message, func(models.VideoJob) error { return tt.err })
+
+			if acked := len(server.Named("XACK")) == 1; acked != tt.wantAck {
+				t.Errorf("acknowledged = %v, want %v", acked, tt.wantAck)
+			}
+			adds := server.Named("XADD")
+			if deadLettered := len(adds) == 1 && adds[0][1] == DeadLetterStream; deadLettered != tt.wantDeadLetter {
+				t.Errorf("dead-letter XADD calls = %q, want one: %v", adds, tt.wantDeadLetter)
+			}
+		})
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	server := useRedis(t, func(cmd []string) any {
+		if cmd[0] == "xadd" {
+			return "1-1"
+		}
+		return int64(1)
+	})
+	message := redis.XMessage{ID: "7-0", Values: map[string]any{"video_id": uuid.NewString(), "data": `{"s3_path":"a.mp4"}`}}
+	cause := errors.New("Server returned 503 for https://cdn.example.com/a.mp4?token=s3cret")
+
+	if err := deadLetter(context.Background(), message, cause); err != nil {
+		t.Fatal(err)
+	}
+
+	adds := server.Named("XADD")
+	if len(adds) != 1 || adds[0][1] != DeadLetterStream {
+		t.Fatalf("XADD calls = %q, want one to %s", adds, DeadLetterStream)
+	}
+	fields := strings.Join(adds[0], " ")
+	for _, want := range []string{"message_id 7-0", `data {"s3_path":"a.mp4"}`, "token=REDACTED"} {
+		if !strings.Contains(fields, want) {
+			t.Errorf("dead-letter entry %q lacks %q", fields, want)
+		}
+	}
+	if strings.Contains(fields, "s3cret") {
+		t.Errorf("dead-letter entry %q keeps the token", fields)
+	}
+	want := []string{"xack", VideoJobsStream, ConsumerGroup, "7-0"}
+	if acks := server.Named("XACK"); len(acks) != 1 || !slices.Equal(acks[0], want) {
+		t.Errorf("XACK calls = %q, want %q", acks, want)
+	}
+}
+
+func TestDeadLetterKeepsPendingOnError(t *testing.T) {
+	server := useRedis(t, func(cmd []string) any {
+		if cmd[0] == "xadd" {
+			return errors.New("ERR connection refused")
+		}
+		return testredis.Status("OK")
+	})
+	message := redis.XMessage{ID: "7-0", Values: map[string]any{"data": "{}"}}
+
+	if err := deadLetter(context.Background(), message, errors.New("boom")); err == nil {
+		t.Error("expected an error when the dead-letter write fails")
+	}
+	if acks := server.Named("XACK"); len(acks) != 0 {
+		t.Errorf("message acknowledged without a dead-letter copy: %q", acks)
+	}
+}
@@ -0,0 +1,155 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/devrayat000/video-process/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// Events buffered per client; a client that falls further behind misses
+// intermediate updates, or is disconnected on a final one, rather than
+// stalling everyone else on the channel
+const hubClientBuffer = 32
+
+var ErrTooManySubscribers = errors.New("too many progress subscribers")
+
+// ProgressHub fans progress events out to any number of local subscribers
+// over a single Redis connection. Each Redis channel is subscribed once, when
+// its first client arrives, and unsubscribed when the last one leaves.
+type ProgressHub struct {
+	mu      sync.Mutex
+	ps      *redis.PubSub
+	clients map[string]map[chan *models.ProcessingProgress]struct{}
+	total   int
+
+	// Caps on clients overall and per video; zero means no cap
+	maxTotal      int
+	maxPerChannel int
+}
+
+// NewProgressHub opens the shared subscription connection and starts
+// dispatching. InitRedis must have been called.
+func NewProgressHub(ctx context.Context, maxTotal, maxPerVideo int) *ProgressHub {
+	h := &ProgressHub{
+		ps:            RedisClient.Subscribe(ctx),
+		clients:       make(map[string]map[chan *models.ProcessingProgress]struct{}),
+		maxTotal:      maxTotal,
+		maxPerChannel: maxPerVideo,
+	}
+	go h.dispatch()
+	return h
+}
+
+// Subscribe registers a client for videoID, or for every video when videoID
+// is empty. The returned func must be called once the client goes away.
+func (h *ProgressHub) Subscribe(ctx context.Context, videoID string) (<-chan *models.ProcessingProgress, func(), error) {
+	channel := ProgressAllChan
+	if videoID != "" {
+		channel = ProgressChannel + videoID
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.maxTotal > 0 && h.total >= h.maxTotal {
+		return nil, nil, ErrTooManySubscribers
+	}
+
+	clients := h.clients[channel]
+	if videoID != "" && h.maxPerChannel > 0 && len(clients) >= h.maxPerChannel {
+		return nil, nil, ErrTooManySubscribers
+	}
+
+	if clients == nil {
+		if err := h.ps.Subscribe(ctx, channel); err != nil {
+			return nil, nil, err
+		}
+		clients = make(map[chan *models.ProcessingProgress]struct{})
+		h.clients[channel] = clients
+	}
+
+	client := make(chan *models.ProcessingProgress, hubClientBuffer)
+	clients[client] = struct{}{}
+	h.total++
+
+	return client, func() { h.unsubscribe(channel, client) }, nil
+}
+
+func (h *ProgressHub) unsubscribe(channel string, client chan *models.ProcessingProgress) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(channel, client)
+}
+
+// remove disconnects a client by closing its channel. h.mu must be held.
+func (h *ProgressHub) remove(channel string, client chan *models.ProcessingProgress) {
+	clients := h.clients[channel]
+	if _, ok := clients[client]; !ok {
+		return
+	}
+	delete(clients, client)
+	close(client)
+	h.total--
+
+	// Last one out drops the Redis channel
+	if len(clients) == 0 {
+		delete(h.clients, channel)
+		if err := h.ps.Unsubscribe(context.Background(), channel); err != nil {
+			log.Printf("Failed to unsubscribe from %s: %v", channel, err)
+		}
+	}
+}
+
+// dispatch decodes each message once and copies it to the channel's clients.
+// A client too far behind misses intermediate events, but not a completed or
+// failed one: it is disconnected instead, so it reconnects and reads the
+// final state rather than waiting on an event that never comes.
+func (h *ProgressHub) dispatch() {
+	for msg := range h.ps.Channel() {
+		var progress models.ProcessingProgress
+		if err := json.Unmarshal([]byte(msg.Payload), &progress); err != nil {
+			log.Printf("Error unmarshaling progress: %v", err)
+			continue
+		}
+		terminal := progress.Status == models.StatusCompleted || progress.Status == models.StatusFailed
+
+		h.mu.Lock()
+		var slow []chan *models.ProcessingProgress
+		for client := range h.clients[msg.Channel] {
+			select {
+			case client <- &progress:
+			default:
+				if terminal {
+					slow = append(slow, client)
+					continue
+				}
+				log.Printf("Dropping progress event for slow subscriber on %s", msg.Channel)
+			}
+		}
+		for _, client := range slow {
+			log.Printf("Disconnecting slow subscriber on %s that would miss a %s event", msg.Channel, progress.Status)
+			h.remove(msg.Channel, client)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// Close ends the shared subscription and disconnects every client
+func (h *ProgressHub) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for channel, clients := range h.clients {
+		for client := range clients {
+			close(client)
+		}
+		delete(h.clients, channel)
+	}
+	h.total = 0
+	return h.ps.Close()
+}
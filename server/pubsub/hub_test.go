@@ -0,0 +1,276 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/devrayat000/video-process/internal/testredis"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+// newHub starts a hub on a test server for the test
+func newHub(t *testing.T, maxTotal, maxPerVideo int) (*ProgressHub, *testredis.Server) {
+	t.Helper()
+	rdb := useRedis(t, nil)
+	hub := NewProgressHub(context.Background(), maxTotal, maxPerVideo)
+	t.Cleanup(func() { hub.Close() })
+	return hub, rdb
+}
+
+// waitUntil polls cond until it holds or a second has passed
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// receive reads one event from a client or fails after a second
+func receive(t *testing.T, client <-chan *models.ProcessingProgress) *models.ProcessingProgress {
+	t.Helper()
+	select {
+	case progress, ok := <-client:
+		if !ok {
+			t.Fatal("client channel closed")
+		}
+		return progress
+	case <-time.After(time.Second):
+		t.Fatal("no progress event received")
+	}
+	return nil
+}
+
+func publish(t *testing.T, rdb *testredis.Server, channel string, progress models.ProcessingProgress) {
+	t.Helper()
+	data, err := json.Marshal(progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := rdb.Publish(channel, string(data)); n != 1 {
+		t.Fatalf("published to %d connections, want the hub's one", n)
+	}
+}
+
+func TestProgressHubFanOut(t *testing.T) {
+	hub, rdb := newHub(t, 0, 0)
+	videoID := uuid.New()
+	channel := ProgressChannel + videoID.String()
+
+	var clients []<-chan *models.ProcessingProgress
+	var unsubscribes []func()
+	for range 3 {
+		client, unsubscribe, err := hub.Subscribe(context.Background(), videoID.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, client)
+		unsubscribes = append(unsubscribes, unsubscribe)
+	}
+	waitUntil(t, "the subscription", func() bool { return rdb.Subscribers(channel) == 1 })
+
+	// Three clients share one Redis subscription
+	if got := len(rdb.Named("SUBSCRIBE")); got != 1 {
+		t.Errorf("SUBSCRIBE sent %d times, want 1", got)
+	}
+
+	publish(t, rdb, channel, models.ProcessingProgress{VideoID: videoID, ProcessedFrames: 42})
+	var first *models.ProcessingProgress
+	for i, client := range clients {
+		got := receive(t, client)
+		if got.ProcessedFrames != 42 {
+			t.Errorf("client %d got %+v, want 42 frames", i, got)
+		}
+		// The message is decoded once and shared
+		if first == nil {
+			first = got
+		} else if got != first {
+			t.Errorf("client %d got a separately decoded event", i)
+		}
+	}
+
+	// The subscription outlives all but the last client
+	unsubscribes[0]()
+	unsubscribes[1]()
+	if _, open := <-clients[0]; open {
+		t.Error("unsubscribed client channel still open")
+	}
+	if got := len(rdb.Named("UNSUBSCRIBE")); got != 0 {
+		t.Errorf("UNSUBSCRIBE sent with a client still watching")
+	}
+	unsubscribes[2]()
+	waitUntil(t, "the unsubscribe", func() bool { return rdb.Subscribers(channel) == 0 })
+}
+
+func TestProgressHubSeparatesChannels(t *testing.T) {
+	hub, rdb := newHub(t, 0, 0)
+	ctx := context.Background()
+	a, b := uuid.New(), uuid.New()
+
+	clientA, unsubscribeA, _ := hub.Subscribe(ctx, a.String())
+	defer unsubscribeA()
+	clientB, unsubscribeB, _ := hub.Subscribe(ctx, b.String())
+	defer unsubscribeB()
+	all, unsubscribeAll, _ := hub.Subscribe(ctx, "")
+	defer unsubscribeAll()
+	waitUntil(t, "three subscriptions", func() bool {
+		return rdb.Subscribers(ProgressChannel+a.String()) == 1 &&
+			rdb.Subscribers(ProgressChannel+b.String()) == 1 &&
+			rdb.Subscribers(ProgressAllChan) == 1
+	})
+
+	publish(t, rdb, ProgressChannel+b.String(), models.ProcessingProgress{VideoID: b})
+	publish(t, rdb, ProgressAllChan, models.ProcessingProgress{VideoID: b})
+
+	if got := receive(t, clientB); got.VideoID != b {
+		t.Errorf("video b client got %+v", got)
+	}
+	if got := receive(t, all); got.VideoID != b {
+		t.Errorf("global client got %+v", got)
+	}
+	select {
+	case got := <-clientA:
+		t.Errorf("video a client got another video's event: %+v", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestProgressHubSlowSubscriber(t *testing.T) {
+	tests := []struct {
+		name       string
+		final      models.VideoStatus
+		wantClosed bool
+	}{
+		{"intermediate events dropped", models.StatusProcessing, false},
+		{"disconnected before missing completion", models.StatusCompleted, true},
+		{"disconnected before missing failure", models.StatusFailed, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub, rdb := newHub(t, 0, 0)
+			videoID := uuid.New()
+			channel := ProgressChannel + videoID.String()
+
+			slow, unsubscribeSlow, err := hub.Subscribe(context.Background(), videoID.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer unsubscribeSlow()
+			fast, unsubscribeFast, err := hub.Subscribe(context.Background(), videoID.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer unsubscribeFast()
+			waitUntil(t, "the subscription", func() bool { return rdb.Subscribers(channel) == 1 })
+
+			// The slow client never reads, so its buffer fills up
+			for n := range hubClientBuffer + 5 {
+				publish(t, rdb, channel, models.ProcessingProgress{VideoID: videoID, Status: models.StatusProcessing, ProcessedFrames: int64(n)})
+				receive(t, fast)
+			}
+			publish(t, rdb, channel, models.ProcessingProgress{VideoID: videoID, Status: tt.final})
+			if got := receive(t, fast); got.Status != tt.final {
+				t.Errorf("fast client got %s, want %s", got.Status, tt.final)
+			}
+
+			// Whatever was buffered is still delivered
+			for n := range hubClientBuffer {
+				if got := receive(t, slow); got.ProcessedFrames != int64(n) {
+					t.Fatalf("slow client event %d has %d frames", n, got.ProcessedFrames)
+				}
+			}
+			select {
+			case got, open := <-slow:
+				if open || !tt.wantClosed {
+					t.Errorf("slow client got %+v (open %v), want closed %v", got, open, tt.wantClosed)
+				}
+			case <-time.After(50 * time.Millisecond):
+				if tt.wantClosed {
+					t.Error("slow client left open after missing a final event")
+				}
+			}
+		})
+	}
+}
+
+func TestProgressHubLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		global   int
+		perVideo int
+		videos   []string
+		wantErrs []bool
+	}{
+		{"under both caps", 3, 2, []string{"a", "a", "b"}, []bool{false, false, false}},
+		{"per-video cap", 10, 2, []string{"a", "a", "a", "b"}, []bool{false, false, true, false}},
+		{"global cap", 2, 10, []string{"a", "b", "c"}, []bool{false, false, true}},
+		{"global feed has no per-video cap", 10, 1, []string{"", "", ""}, []bool{false, false, false}},
+		{"global cap covers the global feed", 2, 1, []string{"", "", "a"}, []bool{false, false, true}},
+		{"zero disables the caps", 0, 0, []string{"a", "a", "a", "a"}, []bool{false, false, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub, _ := newHub(t, tt.global, tt.perVideo)
+			for i, videoID := range tt.videos {
+				_, unsubscribe, err := hub.Subscribe(context.Background(), videoID)
+				if (err != nil) != tt.wantErrs[i] {
+					t.Errorf("subscriber %d (%q) error = %v, want error %v", i, videoID, err, tt.wantErrs[i])
+				}
+				if err == nil {
+					defer unsubscribe()
+				} else if !errors.Is(err, ErrTooManySubscribers) {
+					t.Errorf("subscriber %d error = %v, want ErrTooManySubscribers", i, err)
+				}
+			}
+		})
+	}
+}
+
+func TestProgressHubFreesSlots(t *testing.T) {
+	hub, _ := newHub(t, 1, 0)
+	ctx := context.Background()
+
+	_, unsubscribe, err := hub.Subscribe(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := hub.Subscribe(ctx, "b"); err == nil {
+		t.Fatal("second subscriber admitted over the cap")
+	}
+	unsubscribe()
+	// Unsubscribing twice must not free a slot that isn't held
+	unsubscribe()
+
+	_, unsubscribe, err = hub.Subscribe(ctx, "b")
+	if err != nil {
+		t.Fatalf("slot not freed: %v", err)
+	}
+	defer unsubscribe()
+	if _, _, err := hub.Subscribe(ctx, "c"); err == nil {
+		t.Error("double unsubscribe freed an extra slot")
+	}
+}
+
+func TestProgressHubClose(t *testing.T) {
+	hub, rdb := newHub(t, 0, 0)
+	client, unsubscribe, err := hub.Subscribe(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitUntil(t, "the subscription", func() bool { return rdb.Subscribers(ProgressChannel+"a") == 1 })
+
+	hub.Close()
+	if _, open := <-client; open {
+		t.Error("client still connected after Close")
+	}
+	// A handler unsubscribing after shutdown is harmless
+	unsubscribe()
+	waitUntil(t, "the connection to close", func() bool { return rdb.Subscribers(ProgressChannel+"a") == 0 })
+}
@@ -0,0 +1,58 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	server_utils "github.com/devrayat000/video-process/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	JobLogChannel      = "logs:"
+	JobLogBufferPrefix = "logs:buffer:"
+)
+
+var (
+	// Recent log lines kept per video for clients that connect late; zero
+	// only publishes them live
+	JobLogBufferSize = server_utils.GetEnvInt("JOB_LOG_BUFFER_SIZE", 500)
+	// How long a video's buffered log lines live after the last one
+	JobLogTTL = server_utils.GetEnvDuration("JOB_LOG_TTL", time.Hour)
+)
+
+// PublishJobLog sends one worker log line to logs:{videoID} and appends it
+// to the video's buffer, oldest first
+func PublishJobLog(ctx context.Context, videoID, line string) error {
+	pipe := RedisClient.Pipeline()
+	pipe.Publish(ctx, JobLogChannel+videoID, line)
+	if JobLogBufferSize > 0 {
+		key := JobLogBufferPrefix + videoID
+		pipe.RPush(ctx, key, line)
+		pipe.LTrim(ctx, key, int64(-JobLogBufferSize), -1)
+		pipe.Expire(ctx, key, JobLogTTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to publish job log: %w", err)
+	}
+	return nil
+}
+
+// GetJobLogBuffer returns the buffered log lines of a video, oldest first
+func GetJobLogBuffer(ctx context.Context, videoID string) ([]string, error) {
+	return RedisClient.LRange(ctx, JobLogBufferPrefix+videoID, 0, -1).Result()
+}
+
+// SubscribeJobLogs subscribes to a video's live log lines. The caller closes
+// the subscription.
+func SubscribeJobLogs(ctx context.Context, videoID string) (*redis.PubSub, error) {
+	sub := RedisClient.Subscribe(ctx, JobLogChannel+videoID)
+	// Wait for the confirmation so no line published after this returns is
+	// missed
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, fmt.Errorf("failed to subscribe to job logs: %w", err)
+	}
+	return sub, nil
+}
@@ -0,0 +1,122 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/devrayat000/video-process/internal/testredis"
+	"github.com/google/uuid"
+)
+
+func TestPublishJobLog(t *testing.T) {
+	tests := []struct {
+		name       string
+		bufferSize int
+		wantBuffer bool
+	}{
+		{"buffered", 500, true},
+		{"live only", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &JobLogBufferSize, tt.bufferSize)
+			setVar(t, &JobLogTTL, 30*time.Minute)
+			rdb := useRedis(t, func(cmd []string) any {
+				switch cmd[0] {
+				case "publish", "rpush", "expire":
+					return int64(1)
+				}
+				return testredis.Status("OK")
+			})
+
+			videoID := uuid.NewString()
+			if err := PublishJobLog(context.Background(), videoID, " [i] Probing source"); err != nil {
+				t.Fatal(err)
+			}
+
+			want := []string{"publish", JobLogChannel + videoID, " [i] Probing source"}
+			if publishes := rdb.Named("PUBLISH"); len(publishes) != 1 || !slices.Equal(publishes[0], want) {
+				t.Errorf("PUBLISH = %q, want %q", publishes, want)
+			}
+
+			key := JobLogBufferPrefix + videoID
+			pushes, trims, expires := rdb.Named("RPUSH"), rdb.Named("LTRIM"), rdb.Named("EXPIRE")
+			if !tt.wantBuffer {
+				if len(pushes)+len(trims)+len(expires) != 0 {
+					t.Errorf("buffer written with JOB_LOG_BUFFER_SIZE=0: %q %q %q", pushes, trims, expires)
+				}
+				return
+			}
+			if want := []string{"rpush", key, " [i] Probing source"}; len(pushes) != 1 || !slices.Equal(pushes[0], want) {
+				t.Errorf("RPUSH = %q, want %q", pushes, want)
+			}
+			// The newest lines are kept
+			if want := []string{"ltrim", key, "-500", "-1"}; len(trims) != 1 || !slices.Equal(trims[0], want) {
+				t.Errorf("LTRIM = %q, want %q", trims, want)
+			}
+			if want := []string{"expire", key, "1800"}; len(expires) != 1 || !slices.Equal(expires[0], want) {
+				t.Errorf("EXPIRE = %q, want %q", expires, want)
+			}
+		})
+	}
+}
+
+func TestPublishJobLogError(t *testing.T) {
+	useRedis(t, func(cmd []string) any { return errors.New("READONLY You can't write against a read only replica") })
+	if err := PublishJobLog(context.Background(), uuid.NewString(), "line"); err == nil {
+		t.Error("PublishJobLog succeeded against a failing Redis")
+	}
+}
+
+func TestGetJobLogBuffer(t *testing.T) {
+	videoID := uuid.NewString()
+	rdb := useRedis(t, func(cmd []string) any {
+		if cmd[0] == "lrange" && cmd[1] == JobLogBufferPrefix+videoID {
+			return []string{"first", "second"}
+		}
+		return []string{}
+	})
+
+	got, err := GetJobLogBuffer(context.Background(), videoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"first", "second"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetJobLogBuffer = %q, want %q", got, want)
+	}
+	if want := []string{"lrange", JobLogBufferPrefix + videoID, "0", "-1"}; !slices.Equal(rdb.Named("LRANGE")[0], want) {
+		t.Errorf("LRANGE = %q, want %q", rdb.Named("LRANGE"), want)
+	}
+}
+
+func TestSubscribeJobLogs(t *testing.T) {
+	rdb := useRedis(t, nil)
+	videoID := uuid.NewString()
+	channel := JobLogChannel + videoID
+
+	sub, err := SubscribeJobLogs(context.Background(), videoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Subscribed by the time it returns, so nothing published now is lost
+	if n := rdb.Subscribers(channel); n != 1 {
+		t.Fatalf("%d subscribers on %s, want 1", n, channel)
+	}
+
+	rdb.Publish(channel, " [>] Uploaded 12 segments for 720p")
+	select {
+	case msg := <-sub.Channel():
+		if msg.Payload != " [>] Uploaded 12 segments for 720p" {
+			t.Errorf("payload = %q", msg.Payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no log line received")
+	}
+
+	sub.Close()
+	waitUntil(t, "the unsubscribe", func() bool { return rdb.Subscribers(channel) == 0 })
+}
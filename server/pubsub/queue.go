@@ -0,0 +1,74 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Consumers that have polled the stream this recently count as live; an
+// idle worker blocks for 5s per read, so anything longer means it's gone
+const activeConsumerIdle = time.Minute
+
+// QueueConsumer is one consumer of the jobs group
+type QueueConsumer struct {
+	Name string `json:"name"`
+	// Pending is the number of jobs it holds without having acknowledged them
+	Pending int64 `json:"pending"`
+	IdleMs  int64 `json:"idle_ms"`
+	Active  bool  `json:"active"`
+}
+
+// QueueInfo summarises the jobs stream for the admin overview
+type QueueInfo struct {
+	// Length is the number of entries in the stream, acknowledged or not
+	Length int64 `json:"length"`
+	// Waiting jobs haven't been delivered to a worker yet (-1 if unknown)
+	Waiting int64 `json:"waiting"`
+	// Pending jobs have been delivered but not acknowledged
+	Pending         int64           `json:"pending"`
+	ActiveConsumers int             `json:"active_consumers"`
+	Consumers       []QueueConsumer `json:"consumers"`
+}
+
+// QueueStats reads the length of the jobs stream and the state of its
+// consumer group
+func QueueStats(ctx context.Context) (*QueueInfo, error) {
+	info := &QueueInfo{Waiting: -1, Consumers: []QueueConsumer{}}
+
+	length, err := RedisClient.XLen(ctx, VideoJobsStream).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue length: %w", err)
+	}
+	info.Length = length
+
+	groups, err := RedisClient.XInfoGroups(ctx, VideoJobsStream).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect consumer groups: %w", err)
+	}
+	for _, g := range groups {
+		if g.Name == ConsumerGroup {
+			info.Waiting = g.Lag
+			info.Pending = g.Pending
+		}
+	}
+
+	consumers, err := RedisClient.XInfoConsumers(ctx, VideoJobsStream, ConsumerGroup).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect consumers: %w", err)
+	}
+	for _, c := range consumers {
+		active := c.Idle < activeConsumerIdle
+		if active {
+			info.ActiveConsumers++
+		}
+		info.Consumers = append(info.Consumers, QueueConsumer{
+			Name:    c.Name,
+			Pending: c.Pending,
+			IdleMs:  c.Idle.Milliseconds(),
+			Active:  active,
+		})
+	}
+
+	return info, nil
+}
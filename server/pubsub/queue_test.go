@@ -0,0 +1,101 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// queueReplies answers XLEN and XINFO the way Redis 7 does for a stream with
+// the given groups and consumers
+func queueReplies(length int64, groups, consumers []any) func(cmd []string) any {
+	return func(cmd []string) any {
+		switch {
+		case cmd[0] == "xlen":
+			return length
+		case cmd[0] == "xinfo" && cmd[1] == "groups":
+			return groups
+		case cmd[0] == "xinfo" && cmd[1] == "consumers":
+			return consumers
+		}
+		return nil
+	}
+}
+
+// xinfoGroup is one XINFO GROUPS entry; a nil lag means Redis can't tell
+func xinfoGroup(name string, pending int64, lag any) []any {
+	return []any{
+		"name", name, "consumers", int64(2), "pending", pending,
+		"last-delivered-id", "1-0", "entries-read", int64(5), "lag", lag,
+	}
+}
+
+func xinfoConsumer(name string, pending, idleMs int64) []any {
+	return []any{"name", name, "pending", pending, "idle", idleMs, "inactive", idleMs}
+}
+
+func TestQueueStats(t *testing.T) {
+	tests := []struct {
+		name      string
+		groups    []any
+		consumers []any
+		want      *QueueInfo
+	}{
+		{
+			name:   "waiting and pending jobs",
+			groups: []any{xinfoGroup("other", 9, int64(9)), xinfoGroup(ConsumerGroup, 2, int64(3))},
+			consumers: []any{
+				xinfoConsumer("worker-1", 2, 1500),
+				xinfoConsumer("worker-2", 0, 10*60*1000),
+			},
+			want: &QueueInfo{
+				Length:          7,
+				Waiting:         3,
+				Pending:         2,
+				ActiveConsumers: 1,
+				Consumers: []QueueConsumer{
+					{Name: "worker-1", Pending: 2, IdleMs: 1500, Active: true},
+					{Name: "worker-2", Pending: 0, IdleMs: 600000, Active: false},
+				},
+			},
+		},
+		{
+			name:      "lag unknown",
+			groups:    []any{xinfoGroup(ConsumerGroup, 0, nil)},
+			consumers: []any{},
+			want:      &QueueInfo{Length: 7, Waiting: -1, Consumers: []QueueConsumer{}},
+		},
+		{
+			name:      "group not created yet",
+			groups:    []any{},
+			consumers: []any{},
+			want:      &QueueInfo{Length: 7, Waiting: -1, Consumers: []QueueConsumer{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useRedis(t, queueReplies(7, tt.groups, tt.consumers))
+
+			got, err := QueueStats(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("QueueStats = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueueStatsRedisError(t *testing.T) {
+	useRedis(t, func(cmd []string) any {
+		if cmd[0] == "xlen" {
+			return int64(1)
+		}
+		return errors.New("NOGROUP No such key")
+	})
+	if info, err := QueueStats(context.Background()); err == nil {
+		t.Errorf("QueueStats = %+v, want an error", info)
+	}
+}
@@ -0,0 +1,172 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/devrayat000/video-process/models"
+	server_utils "github.com/devrayat000/video-process/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// How long another consumer's message must sit unacknowledged before
+	// this worker takes it over. Idle time isn't refreshed while a job runs,
+	// so this has to outlive the worker's job timeout.
+	ReclaimMinIdle = server_utils.GetEnvDuration("RECLAIM_MIN_IDLE", 150*time.Minute)
+	// How often a worker with a free slot looks for abandoned messages;
+	// zero only checks at startup
+	ReclaimInterval = server_utils.GetEnvDuration("RECLAIM_INTERVAL", time.Minute)
+	// Pending entries listed per XPENDING call when resuming at startup
+	PendingBatchSize = server_utils.GetEnvInt("PENDING_BATCH_SIZE", 100)
+)
+
+// processPendingMessages resumes messages left unacknowledged under this
+// worker's own consumer names by a previous run. Those are claimed at once,
+// since nothing else is working on them, but only one per free slot. The
+// pending list is read in PENDING_BATCH_SIZE pages after the last seen ID,
+// so a backlog of any size is resumed. Messages of other consumers are left
+// to reclaimLoop.
+func processPendingMessages(ctx context.Context, slots jobSlots, concurrency int, wg *sync.WaitGroup, handler func(models.VideoJob) error) error {
+	log.Println("Checking for pending messages...")
+
+	names := []string{ConsumerName}
+	if concurrency > 1 {
+		for i := 0; i < concurrency; i++ {
+			names = append(names, consumerName(i, concurrency))
+		}
+	}
+
+	batch := int64(max(PendingBatchSize, 1))
+	for _, name := range names {
+		resumed := 0
+		for start := "-"; ; {
+			pending, err := RedisClient.XPendingExt(ctx, &redis.XPendingExtArgs{
+				Stream:   VideoJobsStream,
+				Group:    ConsumerGroup,
+				Start:    start,
+				End:      "+",
+				Count:    batch,
+				Consumer: name,
+			}).Result()
+			if err != nil {
+				return fmt.Errorf("failed to get pending messages: %w", err)
+			}
+
+			for _, p := range pending {
+				if !claimOwnPending(ctx, slots, wg, p.ID, handler) {
+					return ctx.Err()
+				}
+			}
+			resumed += len(pending)
+
+			if int64(len(pending)) < batch {
+				break
+			}
+			start = "(" + pending[len(pending)-1].ID
+		}
+
+		if resumed > 0 {
+			log.Printf("Resumed %d pending messages for %s", resumed, name)
+		}
+	}
+
+	return nil
+}
+
+// claimOwnPending waits for a slot, claims one of this worker's pending
+// messages and starts it. It returns false once ctx is done.
+func claimOwnPending(ctx context.Context, slots jobSlots, wg *sync.WaitGroup, id string, handler func(models.VideoJob) error) bool {
+	if !slots.acquire(ctx) {
+		return false
+	}
+
+	// Claim the message for this consumer
+	messages, err := RedisClient.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   VideoJobsStream,
+		Group:    ConsumerGroup,
+		Consumer: ConsumerName,
+		MinIdle:  0,
+		Messages: []string{id},
+	}).Result()
+	if err != nil || len(messages) == 0 {
+		slots.release()
+		if err != nil {
+			log.Printf("Error claiming message %s: %v", id, err)
+		}
+		return true
+	}
+
+	startMessage(ctx, wg, slots, messages[0], handler)
+	return true
+}
+
+// reclaimLoop takes over messages other consumers have left idle for
+// ReclaimMinIdle, such as those of a crashed worker. It runs right away and
+// then every ReclaimInterval, claiming one message per free slot, so a busy
+// worker never reclaims work it can't start.
+func reclaimLoop(ctx context.Context, slots jobSlots, wg *sync.WaitGroup, handler func(models.VideoJob) error) {
+	var tick <-chan time.Time
+	if ReclaimInterval > 0 {
+		ticker := time.NewTicker(ReclaimInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	cursor := "0-0"
+	for {
+		for slots.tryAcquire() {
+			messages, next, err := RedisClient.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+				Stream:   VideoJobsStream,
+				Group:    ConsumerGroup,
+				Consumer: ConsumerName,
+				MinIdle:  ReclaimMinIdle,
+				Start:    cursor,
+				Count:    1,
+			}).Result()
+			if err != nil {
+				slots.release()
+				if ctx.Err() == nil {
+					log.Printf("Error reclaiming idle messages: %v", err)
+				}
+				break
+			}
+			cursor = next
+
+			if len(messages) == 0 {
+				slots.release()
+				// "0-0" means the scan reached the end of the pending list
+				if cursor == "0-0" {
+					break
+				}
+				continue
+			}
+
+			log.Printf("Reclaimed idle message %s", messages[0].ID)
+			startMessage(ctx, wg, slots, messages[0], handler)
+		}
+
+		if tick == nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		}
+	}
+}
+
+// startMessage handles a claimed message in the background and frees its
+// slot when done. The caller must already hold the slot.
+func startMessage(ctx context.Context, wg *sync.WaitGroup, slots jobSlots, message redis.XMessage, handler func(models.VideoJob) error) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer slots.release()
+		processMessage(ctx, message, handler)
+	}()
+}
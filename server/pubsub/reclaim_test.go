@@ -0,0 +1,109 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testredis"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+// pendingBacklog answers XPENDING pages over messages 1-0 to n-0, all held
+// by ConsumerName, and XCLAIM of any of them
+func pendingBacklog(t *testing.T, n int) testredis.Handler {
+	return func(cmd []string) any {
+		switch cmd[0] {
+		case "xpending":
+			// xpending stream group start end count consumer
+			if cmd[6] != ConsumerName {
+				return []any{}
+			}
+			first := 1
+			if after, ok := strings.CutPrefix(cmd[3], "("); ok {
+				id, _, _ := strings.Cut(after, "-")
+				last, _ := strconv.Atoi(id)
+				first = last + 1
+			}
+			count, _ := strconv.Atoi(cmd[5])
+			page := []any{}
+			for i := first; i <= n && len(page) < count; i++ {
+				page = append(page, []any{fmt.Sprintf("%d-0", i), ConsumerName, int64(1000), int64(1)})
+			}
+			return page
+		case "xclaim":
+			return []any{streamEntry(t, cmd[len(cmd)-1], models.VideoJob{VideoID: uuid.New()})}
+		case "xack":
+			return int64(1)
+		}
+		return testredis.Status("OK")
+	}
+}
+
+func TestProcessPendingMessagesPagesThroughBacklog(t *testing.T) {
+	tests := []struct {
+		name      string
+		pending   int
+		batch     int
+		wantPages int
+	}{
+		{"more than one default page", 250, 100, 3},
+		{"exact multiple of the batch", 200, 100, 3},
+		{"small batches", 23, 5, 5},
+		{"nothing pending", 0, 100, 1},
+		{"batch size floored at one", 3, 0, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &PendingBatchSize, tt.batch)
+			server := useRedis(t, pendingBacklog(t, tt.pending))
+
+			var mu sync.Mutex
+			processed := map[uuid.UUID]bool{}
+			handler := func(job models.VideoJob) error {
+				mu.Lock()
+				defer mu.Unlock()
+				processed[job.VideoID] = true
+				return nil
+			}
+
+			var wg sync.WaitGroup
+			if err := processPendingMessages(context.Background(), newJobSlots(4), 1, &wg, handler); err != nil {
+				t.Fatal(err)
+			}
+			if !waitFor(&wg) {
+				t.Fatal("resumed jobs did not finish")
+			}
+
+			if got := countNamed(server, "XPENDING"); got != tt.wantPages {
+				t.Errorf("read %d pages, want %d", got, tt.wantPages)
+			}
+			if got := countNamed(server, "XCLAIM"); got != tt.pending {
+				t.Errorf("claimed %d messages, want all %d", got, tt.pending)
+			}
+			if len(processed) != tt.pending {
+				t.Errorf("processed %d jobs, want %d", len(processed), tt.pending)
+			}
+			if got := countNamed(server, "XACK"); got != tt.pending {
+				t.Errorf("acked %d messages, want %d", got, tt.pending)
+			}
+		})
+	}
+}
+
+func TestProcessPendingMessagesStopsOnCancel(t *testing.T) {
+	useRedis(t, pendingBacklog(t, 10))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var wg sync.WaitGroup
+	err := processPendingMessages(ctx, newJobSlots(1), 1, &wg, func(models.VideoJob) error { return nil })
+	if err == nil {
+		t.Error("processPendingMessages kept claiming after cancel")
+	}
+	waitFor(&wg)
+}
@@ -3,8 +3,10 @@ package pubsub
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/devrayat000/video-process/models"
@@ -15,17 +17,31 @@ import (
 var RedisClient *redis.Client
 
 const (
-	VideoJobsStream   = "video:jobs"
-	ConsumerGroup     = "video-workers"
-	ProgressKeyPrefix = "progress:"
-	ProgressChannel   = "video:progress:"
-	ProgressAllChan   = "video:progress:all"
+	VideoJobsStream       = "video:jobs"
+	VideoEventsStream     = "video:events"
+	ConsumerGroup         = "video-workers"
+	ProgressKeyPrefix     = "progress:"
+	ProgressHistoryPrefix = "progress:history:"
+	JobFingerprintPrefix  = "job:fingerprint:"
+	StatsKey              = "stats:summary"
+	AdminOverviewKey      = "stats:admin-overview"
+	ProbeCachePrefix      = "probe:"
+	ProgressChannel       = "video:progress:"
+	ProgressAllChan       = "video:progress:all"
 )
 
 var (
 	redisAddr    = server_utils.GetEnv("REDIS_ADDR", "localhost:6379")
 	redisPass    = server_utils.GetEnv("REDIS_PASSWORD", "")
 	ConsumerName = server_utils.GetEnv("HOSTNAME", "worker-1")
+	// Number of jobs a single worker process handles at once
+	WorkerConcurrency = server_utils.GetEnvInt("WORKER_CONCURRENCY", 1)
+	// How long progress keys live after the last update
+	ProgressTTL = server_utils.GetEnvDuration("PROGRESS_TTL", 24*time.Hour)
+	// Number of recent progress events kept per video; zero keeps only the latest
+	ProgressHistorySize = server_utils.GetEnvInt("PROGRESS_HISTORY_SIZE", 0)
+	// Approximate number of entries kept in the video:events stream
+	EventsStreamMaxLen = server_utils.GetEnvInt("EVENTS_STREAM_MAXLEN", 100000)
 )
 
 func InitRedis() (*redis.Client, error) {
@@ -51,13 +67,103 @@ func InitRedis() (*redis.Client, error) {
 	return RedisClient, nil
 }
 
-// EnqueueJob adds a video processing job to the Redis stream
-func EnqueueJob(job models.VideoJob) error {
+// ClaimJobFingerprint records that videoID owns a job fingerprint for ttl. If
+// another video already holds it, that video's id is returned with claimed
+// set to false.
+func ClaimJobFingerprint(ctx context.Context, fingerprint, videoID string, ttl time.Duration) (existing string, claimed bool, err error) {
+	key := JobFingerprintPrefix + fingerprint
+
+	for {
+		ok, err := RedisClient.SetNX(ctx, key, videoID, ttl).Result()
+		if err != nil || ok {
+			return "", ok, err
+		}
+
+		existing, err = RedisClient.Get(ctx, key).Result()
+		if err != redis.Nil {
+			return existing, false, err
+		}
+		// Expired between the two calls; claim it again
+	}
+}
+
+// ReleaseJobFingerprint frees a fingerprint whose job was never created
+func ReleaseJobFingerprint(ctx context.Context, fingerprint string) error {
+	return RedisClient.Del(ctx, JobFingerprintPrefix+fingerprint).Err()
+}
+
+// GetCachedStats returns the cached GET /stats body, or redis.Nil when absent
+func GetCachedStats(ctx context.Context) ([]byte, error) {
+	return RedisClient.Get(ctx, StatsKey).Bytes()
+}
+
+// CacheStats stores a GET /stats body for ttl
+func CacheStats(ctx context.Context, data []byte, ttl time.Duration) error {
+	return RedisClient.Set(ctx, StatsKey, data, ttl).Err()
+}
+
+// GetCachedOverview returns the cached GET /admin/overview body, or
+// redis.Nil when absent
+func GetCachedOverview(ctx context.Context) ([]byte, error) {
+	return RedisClient.Get(ctx, AdminOverviewKey).Bytes()
+}
+
+// CacheOverview stores a GET /admin/overview body for ttl
+func CacheOverview(ctx context.Context, data []byte, ttl time.Duration) error {
+	return RedisClient.Set(ctx, AdminOverviewKey, data, ttl).Err()
+}
+
+// PublishVideoEvent appends a completion or failure event to the events
+// stream, where consumer groups can read it long after it happened.
+func PublishVideoEvent(ctx context.Context, event models.VideoEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	args := &redis.XAddArgs{
+		Stream: VideoEventsStream,
+		Values: map[string]interface{}{
+			"video_id": event.VideoID.String(),
+			"status":   string(event.Status),
+			"data":     string(data),
+		},
+	}
+	if EventsStreamMaxLen > 0 {
+		args.MaxLen = int64(EventsStreamMaxLen)
+		args.Approx = true
+	}
+
+	if err := RedisClient.XAdd(ctx, args).Err(); err != nil {
+		return fmt.Errorf("failed to add event to stream: %w", err)
+	}
+	return nil
+}
+
+// GetCachedProbe returns cached ffprobe results for a source key, or
+// redis.Nil when absent
+func GetCachedProbe(ctx context.Context, key string) ([]byte, error) {
+	return RedisClient.Get(ctx, ProbeCachePrefix+key).Bytes()
+}
+
+// CacheProbe stores ffprobe results for a source key for ttl
+func CacheProbe(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	return RedisClient.Set(ctx, ProbeCachePrefix+key, data, ttl).Err()
+}
+
+// DeleteCachedProbe drops cached ffprobe results for a source key
+func DeleteCachedProbe(ctx context.Context, key string) error {
+	return RedisClient.Del(ctx, ProbeCachePrefix+key).Err()
+}
+
+// EnqueueJob adds a video processing job to the Redis stream and returns the
+// entry's stream ID
+func EnqueueJob(job models.VideoJob) (string, error) {
 	ctx := context.Background()
 
 	data, err := json.Marshal(job)
 	if err != nil {
-		return fmt.Errorf("failed to marshal job: %w", err)
+		return "", fmt.Errorf("failed to marshal job: %w", err)
 	}
 
 	values := map[string]interface{}{
@@ -68,102 +174,162 @@ func EnqueueJob(job models.VideoJob) error {
 		"enqueued_at":   time.Now().Unix(),
 	}
 
-	_, err = RedisClient.XAdd(ctx, &redis.XAddArgs{
+	id, err := RedisClient.XAdd(ctx, &redis.XAddArgs{
 		Stream: VideoJobsStream,
 		Values: values,
 	}).Result()
 
 	if err != nil {
-		return fmt.Errorf("failed to add job to stream: %w", err)
+		return "", fmt.Errorf("failed to add job to stream: %w", err)
 	}
 
-	log.Printf("Job enqueued: video_id=%s", job.VideoID)
-	return nil
+	log.Printf("Job enqueued: video_id=%s, stream_id=%s", job.VideoID, id)
+	return id, nil
 }
 
-// ConsumeJobs reads jobs from the Redis stream and processes them
+// ConsumeJobs reads jobs from the Redis stream and processes them. With
+// WORKER_CONCURRENCY > 1 several consumers share the group, each under its own
+// consumer name, and at most that many handlers run at the same time. Every
+// read and claim waits for a free slot, so the worker never holds more
+// messages than it is working on.
 func ConsumeJobs(ctx context.Context, handler func(models.VideoJob) error) error {
-	// First, process any pending messages from previous runs
-	if err := processPendingMessages(ctx, handler); err != nil {
+	concurrency := max(WorkerConcurrency, 1)
+	slots := newJobSlots(concurrency)
+
+	var wg sync.WaitGroup
+
+	// First, resume this worker's own pending messages from previous runs
+	if err := processPendingMessages(ctx, slots, concurrency, &wg, handler); err != nil {
 		log.Printf("Warning: Error processing pending messages: %v", err)
 	}
 
 	// Then start consuming new messages
+	for i := 0; i < concurrency; i++ {
+		consumer := consumerName(i, concurrency)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			consumeLoop(ctx, consumer, slots, handler)
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		reclaimLoop(ctx, slots, &wg, handler)
+	}()
+
+	log.Printf("Consuming jobs with %d consumer(s)", concurrency)
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// consumerName keeps the plain hostname for a single consumer so pending
+// entries from older deployments still belong to it.
+func consumerName(index, concurrency int) string {
+	if concurrency == 1 {
+		return ConsumerName
+	}
+	return fmt.Sprintf("%s-%d", ConsumerName, index)
+}
+
+// consumeLoop reads new messages for one consumer until the context ends
+func consumeLoop(ctx context.Context, consumer string, slots jobSlots, handler func(models.VideoJob) error) {
 	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			// Read from stream with consumer group
-			streams, err := RedisClient.XReadGroup(ctx, &redis.XReadGroupArgs{
-				Group:    ConsumerGroup,
-				Consumer: ConsumerName,
-				Streams:  []string{VideoJobsStream, ">"},
-				Count:    1,
-				Block:    5 * time.Second,
-			}).Result()
+		if !slots.acquire(ctx) {
+			return
+		}
 
-			if err != nil {
-				if err == redis.Nil {
-					// No new messages, continue
-					continue
-				}
-				log.Printf("Error reading from stream: %v", err)
-				time.Sleep(2 * time.Second)
+		// Read from stream with consumer group
+		streams, err := RedisClient.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    ConsumerGroup,
+			Consumer: consumer,
+			Streams:  []string{VideoJobsStream, ">"},
+			Count:    1,
+			Block:    5 * time.Second,
+		}).Result()
+
+		if err != nil {
+			slots.release()
+			if err == redis.Nil || ctx.Err() != nil {
+				// No new messages, continue
 				continue
 			}
+			log.Printf("Error reading from stream (%s): %v", consumer, err)
+			time.Sleep(2 * time.Second)
+			continue
+		}
 
-			for _, stream := range streams {
-				for _, message := range stream.Messages {
-					processMessage(ctx, message, handler)
-				}
+		for _, stream := range streams {
+			for _, message := range stream.Messages {
+				processMessage(ctx, message, handler)
 			}
 		}
+		slots.release()
 	}
 }
 
-// processPendingMessages handles messages that were enqueued but not yet processed
-func processPendingMessages(ctx context.Context, handler func(models.VideoJob) error) error {
-	log.Println("Checking for pending messages...")
-
-	// Get pending messages for this consumer
-	pending, err := RedisClient.XPendingExt(ctx, &redis.XPendingExtArgs{
-		Stream: VideoJobsStream,
-		Group:  ConsumerGroup,
-		Start:  "-",
-		End:    "+",
-		Count:  100,
-	}).Result()
+// QueuedVideoIDs returns the video ids that still have a live entry in the
+// jobs stream: either not yet delivered to the group or delivered but not
+// acknowledged.
+func QueuedVideoIDs(ctx context.Context) (map[string]bool, error) {
+	ids := make(map[string]bool)
 
+	groups, err := RedisClient.XInfoGroups(ctx, VideoJobsStream).Result()
 	if err != nil {
-		return fmt.Errorf("failed to get pending messages: %w", err)
+		return nil, fmt.Errorf("failed to inspect consumer groups: %w", err)
+	}
+	lastDelivered := "0"
+	for _, g := range groups {
+		if g.Name == ConsumerGroup {
+			lastDelivered = g.LastDeliveredID
+		}
 	}
 
-	if len(pending) > 0 {
-		log.Printf("Found %d pending messages, processing...", len(pending))
+	// Entries after the group's cursor haven't been handed to any consumer yet
+	undelivered, err := RedisClient.XRange(ctx, VideoJobsStream, "("+lastDelivered, "+").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read undelivered jobs: %w", err)
+	}
+	for _, message := range undelivered {
+		if job, err := parseJob(message.Values); err == nil {
+			ids[job.VideoID.String()] = true
+		}
 	}
 
-	for _, p := range pending {
-		// Claim the message for this consumer
-		messages, err := RedisClient.XClaim(ctx, &redis.XClaimArgs{
-			Stream:   VideoJobsStream,
-			Group:    ConsumerGroup,
-			Consumer: ConsumerName,
-			MinIdle:  0,
-			Messages: []string{p.ID},
+	// Entries handed out but not yet acked, a page at a time
+	const batch = 1000
+	for start := "-"; ; {
+		pending, err := RedisClient.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: VideoJobsStream,
+			Group:  ConsumerGroup,
+			Start:  start,
+			End:    "+",
+			Count:  batch,
 		}).Result()
-
 		if err != nil {
-			log.Printf("Error claiming message %s: %v", p.ID, err)
-			continue
+			return nil, fmt.Errorf("failed to read pending jobs: %w", err)
+		}
+		for _, p := range pending {
+			messages, err := RedisClient.XRangeN(ctx, VideoJobsStream, p.ID, p.ID, 1).Result()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read pending job %s: %w", p.ID, err)
+			}
+			for _, message := range messages {
+				if job, err := parseJob(message.Values); err == nil {
+					ids[job.VideoID.String()] = true
+				}
+			}
 		}
 
-		for _, message := range messages {
-			processMessage(ctx, message, handler)
+		if len(pending) < batch {
+			break
 		}
+		start = "(" + pending[len(pending)-1].ID
 	}
 
-	return nil
+	return ids, nil
 }
 
 // processMessage processes a single message from the stream
@@ -178,14 +344,41 @@ func processMessage(ctx context.Context, message redis.XMessage, handler func(mo
 
 	log.Printf("Processing job: video_id=%s, message_id=%s", job.VideoID, message.ID)
 
+	// Shutdown may cancel ctx while a draining job finishes; it still needs
+	// its ack
+	ackCtx := context.WithoutCancel(ctx)
+
 	// Process the job
-	if err := handler(job); err != nil {
-		log.Printf("Error processing job %s: %v", job.VideoID, err)
-		// Job will be retried on next worker restart or manual intervention
-	} else {
+	err = handler(job)
+	switch {
+	case err == nil:
 		// Acknowledge successful processing
-		RedisClient.XAck(ctx, VideoJobsStream, ConsumerGroup, message.ID)
+		RedisClient.XAck(ackCtx, VideoJobsStream, ConsumerGroup, message.ID)
 		log.Printf("Job completed and acknowledged: video_id=%s", job.VideoID)
+	case ctx.Err() != nil:
+		// Interrupted by shutdown; another worker picks it up after
+		// RECLAIM_MIN_IDLE
+		log.Printf("Job %s interrupted, left pending: %v", job.VideoID, err)
+	case errors.Is(err, ErrJobFailed):
+		RedisClient.XAck(ackCtx, VideoJobsStream, ConsumerGroup, message.ID)
+		log.Printf("Job %s failed permanently, acknowledged: %v", job.VideoID, err)
+	default:
+		// Retryable failures stay pending and are reclaimed after
+		// RECLAIM_MIN_IDLE, up to JOB_MAX_DELIVERIES deliveries
+		deliveries, countErr := deliveryCount(ackCtx, message.ID)
+		if countErr != nil {
+			log.Printf("Error processing job %s, left pending: %v (%v)", job.VideoID, err, countErr)
+			return
+		}
+		if deliveries < int64(MaxDeliveries) {
+			log.Printf("Error processing job %s (delivery %d of %d), left pending: %v", job.VideoID, deliveries, MaxDeliveries, err)
+			return
+		}
+		if dlErr := deadLetter(ackCtx, message, err); dlErr != nil {
+			log.Printf("Error processing job %s, left pending: %v (%v)", job.VideoID, err, dlErr)
+			return
+		}
+		log.Printf("Job %s failed %d times, moved to %s: %v", job.VideoID, deliveries, DeadLetterStream, err)
 	}
 }
 
@@ -223,83 +416,99 @@ func PublishProgress(progress models.ProcessingProgress) error {
 		return err
 	}
 
-	// Store latest progress in Redis with TTL (24 hours)
+	// Store latest progress in Redis with TTL
 	key := fmt.Sprintf("%s%s", ProgressKeyPrefix, progress.VideoID)
-	if err := RedisClient.Set(ctx, key, data, 24*time.Hour).Err(); err != nil {
+	if err := RedisClient.Set(ctx, key, data, ProgressTTL).Err(); err != nil {
 		return err
 	}
 
+	if ProgressHistorySize > 0 {
+		// Keep the last N events, newest first, in a capped list
+		historyKey := fmt.Sprintf("%s%s", ProgressHistoryPrefix, progress.VideoID)
+		pipe := RedisClient.TxPipeline()
+		pipe.LPush(ctx, historyKey, data)
+		pipe.LTrim(ctx, historyKey, 0, int64(ProgressHistorySize-1))
+		pipe.Expire(ctx, historyKey, ProgressTTL)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func GetProgress(videoID string) (*models.ProcessingProgress, error) {
+// GetProgressMany fetches the latest progress for several videos in one round
+// trip. Videos without a stored snapshot are left out of the map.
+func GetProgressMany(videoIDs []string) (map[string]*models.ProcessingProgress, error) {
 	ctx := context.Background()
-	key := fmt.Sprintf("%s%s", ProgressKeyPrefix, videoID)
+	result := make(map[string]*models.ProcessingProgress, len(videoIDs))
+	if len(videoIDs) == 0 {
+		return result, nil
+	}
 
-	data, err := RedisClient.Get(ctx, key).Bytes()
+	keys := make([]string, len(videoIDs))
+	for i, id := range videoIDs {
+		keys[i] = fmt.Sprintf("%s%s", ProgressKeyPrefix, id)
+	}
+
+	values, err := RedisClient.MGet(ctx, keys...).Result()
 	if err != nil {
 		return nil, err
 	}
 
-	var progress models.ProcessingProgress
-	if err := json.Unmarshal(data, &progress); err != nil {
-		return nil, err
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var progress models.ProcessingProgress
+		if err := json.Unmarshal([]byte(data), &progress); err != nil {
+			log.Printf("Error unmarshaling progress: %v", err)
+			continue
+		}
+		result[videoIDs[i]] = &progress
 	}
 
-	return &progress, nil
+	return result, nil
 }
 
-func SubscribeToProgress(ctx context.Context, videoID string) (<-chan *models.ProcessingProgress, error) {
-	channel := fmt.Sprintf("%s%s", ProgressChannel, videoID)
-	pubsub := RedisClient.Subscribe(ctx, channel)
+// GetProgressHistory returns the retained progress events for a video,
+// newest first. It is empty unless PROGRESS_HISTORY_SIZE is set.
+func GetProgressHistory(videoID string) ([]models.ProcessingProgress, error) {
+	ctx := context.Background()
+	key := fmt.Sprintf("%s%s", ProgressHistoryPrefix, videoID)
 
-	progressChan := make(chan *models.ProcessingProgress)
+	entries, err := RedisClient.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
 
-	go func() {
-		defer close(progressChan)
-		defer pubsub.Close()
-
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case msg := <-pubsub.Channel():
-				var progress models.ProcessingProgress
-				if err := json.Unmarshal([]byte(msg.Payload), &progress); err != nil {
-					log.Printf("Error unmarshaling progress: %v", err)
-					continue
-				}
-				progressChan <- &progress
-			}
+	history := make([]models.ProcessingProgress, 0, len(entries))
+	for _, entry := range entries {
+		var progress models.ProcessingProgress
+		if err := json.Unmarshal([]byte(entry), &progress); err != nil {
+			log.Printf("Error unmarshaling progress history: %v", err)
+			continue
 		}
-	}()
+		history = append(history, progress)
+	}
 
-	return progressChan, nil
+	return history, nil
 }
 
-func SubscribeToAllProgress(ctx context.Context) (<-chan *models.ProcessingProgress, error) {
-	pubsub := RedisClient.Subscribe(ctx, ProgressAllChan)
+func GetProgress(videoID string) (*models.ProcessingProgress, error) {
+	ctx := context.Background()
+	key := fmt.Sprintf("%s%s", ProgressKeyPrefix, videoID)
 
-	progressChan := make(chan *models.ProcessingProgress)
+	data, err := RedisClient.Get(ctx, key).Bytes()
+	if err != nil {
+		return nil, err
+	}
 
-	go func() {
-		defer close(progressChan)
-		defer pubsub.Close()
-
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case msg := <-pubsub.Channel():
-				var progress models.ProcessingProgress
-				if err := json.Unmarshal([]byte(msg.Payload), &progress); err != nil {
-					log.Printf("Error unmarshaling progress: %v", err)
-					continue
-				}
-				progressChan <- &progress
-			}
-		}
-	}()
+	var progress models.ProcessingProgress
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return nil, err
+	}
 
-	return progressChan, nil
+	return &progress, nil
 }
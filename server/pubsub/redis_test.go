@@ -0,0 +1,637 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devrayat000/video-process/internal/testredis"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// useRedis points RedisClient at a test server for the test
+func useRedis(t *testing.T, handler testredis.Handler) *testredis.Server {
+	t.Helper()
+	client, server := testredis.Start(t, handler)
+	previous := RedisClient
+	RedisClient = client
+	t.Cleanup(func() { RedisClient = previous })
+	return server
+}
+
+// setVar overrides a package setting for the test
+func setVar[T any](t *testing.T, v *T, value T) {
+	t.Helper()
+	previous := *v
+	*v = value
+	t.Cleanup(func() { *v = previous })
+}
+
+// streamEntry encodes one jobs stream entry the way XREADGROUP and XCLAIM
+// return it
+func streamEntry(t *testing.T, id string, job models.VideoJob) []any {
+	t.Helper()
+	data, err := json.Marshal(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []any{id, []any{"video_id", job.VideoID.String(), "data", string(data)}}
+}
+
+func TestConsumerName(t *testing.T) {
+	previous := ConsumerName
+	ConsumerName = "worker-a"
+	t.Cleanup(func() { ConsumerName = previous })
+
+	tests := []struct {
+		index, concurrency int
+		want               string
+	}{
+		{0, 1, "worker-a"},
+		{0, 3, "worker-a-0"},
+		{2, 3, "worker-a-2"},
+	}
+	for _, tt := range tests {
+		if got := consumerName(tt.index, tt.concurrency); got != tt.want {
+			t.Errorf("consumerName(%d, %d) = %q, want %q", tt.index, tt.concurrency, got, tt.want)
+		}
+	}
+}
+
+func TestConsumeJobsRunsHandlersConcurrently(t *testing.T) {
+	const concurrency = 3
+	previous := WorkerConcurrency
+	WorkerConcurrency = concurrency
+	t.Cleanup(func() { WorkerConcurrency = previous })
+
+	// One job per consumer; the last one fails and must stay pending
+	var mu sync.Mutex
+	var entries [][]any
+	jobs := make([]models.VideoJob, concurrency)
+	for i := range jobs {
+		jobs[i] = models.VideoJob{VideoID: uuid.New()}
+		entries = append(entries, streamEntry(t, fmt.Sprintf("%d-0", i+1), jobs[i]))
+	}
+	failing := jobs[concurrency-1].VideoID
+
+	server := useRedis(t, func(cmd []string) any {
+		switch cmd[0] {
+		case "xpending":
+			return []any{}
+		case "xreadgroup":
+			mu.Lock()
+			defer mu.Unlock()
+			if len(entries) == 0 {
+				time.Sleep(10 * time.Millisecond)
+				return []any(nil)
+			}
+			entry := entries[0]
+			entries = entries[1:]
+			return []any{[]any{VideoJobsStream, []any{entry}}}
+		case "xack":
+			return 1
+		}
+		return testredis.Status("OK")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Every handler waits until all of them are running at once
+	var running sync.WaitGroup
+	running.Add(concurrency)
+	var done sync.WaitGroup
+	done.Add(concurrency)
+	handler := func(job models.VideoJob) error {
+		defer done.Done()
+		running.Done()
+		if !waitFor(&running) {
+			t.Error("handlers never ran concurrently")
+		}
+		if job.VideoID == failing {
+			return errors.New("transcode failed")
+		}
+		return nil
+	}
+
+	result := make(chan error, 1)
+	go func() { result <- ConsumeJobs(ctx, handler) }()
+
+	if !waitFor(&done) {
+		t.Fatal("handlers did not finish")
+	}
+	cancel()
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("ConsumeJobs returned %v, want context.Canceled", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("ConsumeJobs did not return after cancel")
+	}
+
+	consumers := map[string]bool{}
+	for _, cmd := range server.Named("XREADGROUP") {
+		consumers[cmd[3]] = true
+	}
+	if len(consumers) != concurrency {
+		t.Errorf("read as consumers %v, want %d distinct names", consumers, concurrency)
+	}
+
+	var acked []string
+	for _, cmd := range server.Named("XACK") {
+		acked = append(acked, cmd[3:]...)
+	}
+	slices.Sort(acked)
+	if want := []string{"1-0", "2-0"}; !slices.Equal(acked, want) {
+		t.Errorf("acked %v, want %v", acked, want)
+	}
+}
+
+// waitFor waits for wg and reports whether it finished in time
+func waitFor(wg *sync.WaitGroup) bool {
+	ch := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	select {
+	case <-ch:
+		return true
+	case <-time.After(10 * time.Second):
+		return false
+	}
+}
+
+func TestPublishProgressTTL(t *testing.T) {
+	tests := []struct {
+		name        string
+		ttl         time.Duration
+		historySize int
+		wantExpire  string
+	}{
+		{"default", 24 * time.Hour, 0, "86400"},
+		{"weekly reconcile", 168 * time.Hour, 0, "604800"},
+		{"with history", 168 * time.Hour, 5, "604800"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &ProgressTTL, tt.ttl)
+			setVar(t, &ProgressHistorySize, tt.historySize)
+			rdb := useRedis(t, func(cmd []string) any {
+				switch cmd[0] {
+				case "publish", "lpush", "expire":
+					return 1
+				}
+				return testredis.Status("OK")
+			})
+
+			videoID := uuid.New()
+			if err := PublishProgress(models.ProcessingProgress{VideoID: videoID, Status: models.StatusProcessing}); err != nil {
+				t.Fatal(err)
+			}
+
+			key := ProgressKeyPrefix + videoID.String()
+			sets := rdb.Named("SET")
+			if len(sets) != 1 || sets[0][1] != key || !slices.Equal(sets[0][3:], []string{"ex", tt.wantExpire}) {
+				t.Errorf("SET = %q, want %s with ex %s", sets, key, tt.wantExpire)
+			}
+
+			historyKey := ProgressHistoryPrefix + videoID.String()
+			pushes, trims, expires := rdb.Named("LPUSH"), rdb.Named("LTRIM"), rdb.Named("EXPIRE")
+			if tt.historySize == 0 {
+				if len(pushes)+len(trims)+len(expires) != 0 {
+					t.Errorf("history written with PROGRESS_HISTORY_SIZE=0: %q %q %q", pushes, trims, expires)
+				}
+				return
+			}
+			if len(pushes) != 1 || pushes[0][1] != historyKey {
+				t.Errorf("LPUSH = %q, want %s", pushes, historyKey)
+			}
+			wantTrim := []string{"ltrim", historyKey, "0", fmt.Sprint(tt.historySize - 1)}
+			if len(trims) != 1 || !slices.Equal(trims[0], wantTrim) {
+				t.Errorf("LTRIM = %q, want %q", trims, wantTrim)
+			}
+			if wantExpire := []string{"expire", historyKey, tt.wantExpire}; len(expires) != 1 || !slices.Equal(expires[0], wantExpire) {
+				t.Errorf("EXPIRE = %q, want %q", expires, wantExpire)
+			}
+		})
+	}
+}
+
+func TestGetProgressHistory(t *testing.T) {
+	videoID := uuid.New()
+	newest, _ := json.Marshal(models.ProcessingProgress{VideoID: videoID, ProcessedFrames: 80})
+	oldest, _ := json.Marshal(models.ProcessingProgress{VideoID: videoID, ProcessedFrames: 10})
+	rdb := useRedis(t, func(cmd []string) any {
+		return []string{string(newest), "not json", string(oldest)}
+	})
+
+	history, err := GetProgressHistory(videoID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].ProcessedFrames != 80 || history[1].ProcessedFrames != 10 {
+		t.Errorf("history = %+v, want the two valid events newest first", history)
+	}
+	want := []string{"lrange", ProgressHistoryPrefix + videoID.String(), "0", "-1"}
+	if got := rdb.Named("LRANGE"); len(got) != 1 || !slices.Equal(got[0], want) {
+		t.Errorf("LRANGE = %q, want %q", got, want)
+	}
+}
+
+func TestQueuedVideoIDs(t *testing.T) {
+	acked := models.VideoJob{VideoID: uuid.New()}
+	pending := models.VideoJob{VideoID: uuid.New()}
+	undelivered := models.VideoJob{VideoID: uuid.New()}
+	entries := map[string][]any{
+		"1-0": streamEntry(t, "1-0", acked),
+		"2-0": streamEntry(t, "2-0", pending),
+		"3-0": streamEntry(t, "3-0", undelivered),
+	}
+
+	rdb := useRedis(t, func(cmd []string) any {
+		switch cmd[0] {
+		case "xinfo":
+			return []any{
+				[]any{"name", "other-group", "consumers", 0, "pending", 0, "last-delivered-id", "0-0"},
+				[]any{"name", ConsumerGroup, "consumers", 1, "pending", 1, "last-delivered-id", "2-0"},
+			}
+		case "xrange":
+			if cmd[2] == "(2-0" {
+				return []any{entries["3-0"]}
+			}
+			return []any{entries[cmd[2]]}
+		case "xpending":
+			return []any{[]any{"2-0", "worker-1", 1000, 1}}
+		}
+		return testredis.Status("OK")
+	})
+
+	ids, err := QueuedVideoIDs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{pending.VideoID.String(): true, undelivered.VideoID.String(): true}
+	if len(ids) != len(want) {
+		t.Errorf("QueuedVideoIDs = %v, want %v", ids, want)
+	}
+	for id := range want {
+		if !ids[id] {
+			t.Errorf("video %s is queued but not reported", id)
+		}
+	}
+	if ids[acked.VideoID.String()] {
+		t.Error("acknowledged job reported as queued")
+	}
+
+	// Undelivered entries are read from just after the group's cursor
+	if got := rdb.Named("XRANGE"); len(got) != 2 || !slices.Equal(got[0][1:], []string{VideoJobsStream, "(2-0", "+"}) {
+		t.Errorf("XRANGE calls = %q", got)
+	}
+}
+
+func TestQueuedVideoIDsPagesThroughPending(t *testing.T) {
+	tests := []struct {
+		name      string
+		pending   int
+		wantPages int
+	}{
+		{"more than one page", 2500, 3},
+		{"exact page", 1000, 2},
+		{"nothing pending", 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			videoIDs := make([]uuid.UUID, tt.pending+1)
+			for i := range videoIDs {
+				videoIDs[i] = uuid.New()
+			}
+			rdb := useRedis(t, func(cmd []string) any {
+				switch cmd[0] {
+				case "xinfo":
+					return []any{[]any{"name", ConsumerGroup, "consumers", 1, "pending", tt.pending, "last-delivered-id", fmt.Sprintf("%d-0", tt.pending)}}
+				case "xrange":
+					n, _ := strconv.Atoi(strings.TrimSuffix(cmd[2], "-0"))
+					if n == 0 {
+						return []any{}
+					}
+					return []any{streamEntry(t, cmd[2], models.VideoJob{VideoID: videoIDs[n]})}
+				case "xpending":
+					// xpending stream group start end count
+					first := 1
+					if after, ok := strings.CutPrefix(cmd[3], "("); ok {
+						first, _ = strconv.Atoi(strings.TrimSuffix(after, "-0"))
+						first++
+					}
+					count, _ := strconv.Atoi(cmd[5])
+					page := []any{}
+					for i := first; i <= tt.pending && len(page) < count; i++ {
+						page = append(page, []any{fmt.Sprintf("%d-0", i), "worker-1", int64(1000), int64(1)})
+					}
+					return page
+				}
+				return testredis.Status("OK")
+			})
+
+			ids, err := QueuedVideoIDs(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ids) != tt.pending {
+				t.Errorf("QueuedVideoIDs found %d videos, want %d", len(ids), tt.pending)
+			}
+			if got := len(rdb.Named("XPENDING")); got != tt.wantPages {
+				t.Errorf("read %d XPENDING pages, want %d", got, tt.wantPages)
+			}
+		})
+	}
+}
+
+func TestQueuedVideoIDsErrors(t *testing.T) {
+	for _, failing := range []string{"xinfo", "xrange", "xpending"} {
+		t.Run(failing, func(t *testing.T) {
+			useRedis(t, func(cmd []string) any {
+				if cmd[0] == failing {
+					return errors.New("ERR no such key")
+				}
+				switch cmd[0] {
+				case "xinfo", "xrange", "xpending":
+					return []any{}
+				}
+				return testredis.Status("OK")
+			})
+			if _, err := QueuedVideoIDs(context.Background()); err == nil {
+				t.Errorf("expected an error when %s fails", failing)
+			}
+		})
+	}
+}
+
+// fingerprintStore answers SET NX, GET and DEL from a map whose keys expire
+// against a clock the test moves by hand
+type fingerprintStore struct {
+	mu      sync.Mutex
+	now     time.Time
+	values  map[string]string
+	expires map[string]time.Time
+}
+
+func newFingerprintStore() *fingerprintStore {
+	return &fingerprintStore{now: time.Now(), values: map[string]string{}, expires: map[string]time.Time{}}
+}
+
+func (s *fingerprintStore) advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = s.now.Add(d)
+}
+
+func (s *fingerprintStore) handle(cmd []string) any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := cmd[1]
+	if deadline, ok := s.expires[key]; ok && !s.now.Before(deadline) {
+		delete(s.values, key)
+		delete(s.expires, key)
+	}
+	switch cmd[0] {
+	case "set":
+		if _, ok := s.values[key]; ok {
+			return nil
+		}
+		s.values[key] = cmd[2]
+		for i := 3; i+1 < len(cmd); i++ {
+			n, _ := strconv.Atoi(cmd[i+1])
+			switch cmd[i] {
+			case "ex":
+				s.expires[key] = s.now.Add(time.Duration(n) * time.Second)
+			case "px":
+				s.expires[key] = s.now.Add(time.Duration(n) * time.Millisecond)
+			}
+		}
+		return testredis.Status("OK")
+	case "get":
+		if v, ok := s.values[key]; ok {
+			return v
+		}
+		return nil
+	case "del":
+		if _, ok := s.values[key]; ok {
+			delete(s.values, key)
+			return 1
+		}
+		return 0
+	}
+	return errors.New("ERR unknown command")
+}
+
+func TestClaimJobFingerprint(t *testing.T) {
+	const ttl = time.Minute
+	tests := []struct {
+		name         string
+		held         bool
+		elapsed      time.Duration
+		wantClaimed  bool
+		wantExisting string
+	}{
+		{"miss", false, 0, true, ""},
+		{"hit within the window", true, 30 * time.Second, false, "first"},
+		{"hit outside the window", true, ttl, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFingerprintStore()
+			rdb := useRedis(t, store.handle)
+			ctx := context.Background()
+
+			if tt.held {
+				if _, claimed, err := ClaimJobFingerprint(ctx, "abc", "first", ttl); err != nil || !claimed {
+					t.Fatalf("first claim = %v, %v", claimed, err)
+				}
+			}
+			store.advance(tt.elapsed)
+
+			existing, claimed, err := ClaimJobFingerprint(ctx, "abc", "second", ttl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claimed != tt.wantClaimed || existing != tt.wantExisting {
+				t.Errorf("ClaimJobFingerprint = %q, %v, want %q, %v", existing, claimed, tt.wantExisting, tt.wantClaimed)
+			}
+
+			sets := rdb.Named("SET")
+			want := []string{"set", JobFingerprintPrefix + "abc", "second", "ex", "60", "nx"}
+			if last := sets[len(sets)-1]; !slices.Equal(last, want) {
+				t.Errorf("SET = %q, want %q", last, want)
+			}
+		})
+	}
+}
+
+func TestClaimJobFingerprintExpiresBeforeGet(t *testing.T) {
+	// The key exists for a SET NX but is gone by the GET that follows, so
+	// the claim is retried rather than reported as a duplicate of nothing
+	tests := []struct {
+		name         string
+		sets         []bool
+		gets         []string
+		wantClaimed  bool
+		wantExisting string
+	}{
+		{"retry claims", []bool{false, true}, []string{""}, true, ""},
+		{"retry loses to another request", []bool{false, false}, []string{"", "third"}, false, "third"},
+		{"expires twice", []bool{false, false, true}, []string{"", ""}, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sets, gets int
+			rdb := useRedis(t, func(cmd []string) any {
+				switch cmd[0] {
+				case "set":
+					sets++
+					if sets > len(tt.sets) {
+						return errors.New("ERR unexpected SET")
+					}
+					if tt.sets[sets-1] {
+						return testredis.Status("OK")
+					}
+					return nil
+				case "get":
+					gets++
+					if gets > len(tt.gets) {
+						return errors.New("ERR unexpected GET")
+					}
+					if v := tt.gets[gets-1]; v != "" {
+						return v
+					}
+					return nil
+				}
+				return testredis.Status("OK")
+			})
+
+			existing, claimed, err := ClaimJobFingerprint(context.Background(), "abc", "second", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claimed != tt.wantClaimed || existing != tt.wantExisting {
+				t.Errorf("ClaimJobFingerprint = %q, %v, want %q, %v", existing, claimed, tt.wantExisting, tt.wantClaimed)
+			}
+			if got := len(rdb.Named("SET")); got != len(tt.sets) {
+				t.Errorf("SET NX sent %d times, want %d", got, len(tt.sets))
+			}
+		})
+	}
+}
+
+func TestReleaseJobFingerprint(t *testing.T) {
+	store := newFingerprintStore()
+	useRedis(t, store.handle)
+	ctx := context.Background()
+
+	if _, _, err := ClaimJobFingerprint(ctx, "abc", "first", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReleaseJobFingerprint(ctx, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	// A released fingerprint can be claimed straight away
+	if _, claimed, err := ClaimJobFingerprint(ctx, "abc", "second", time.Minute); err != nil || !claimed {
+		t.Errorf("claim after release = %v, %v, want claimed", claimed, err)
+	}
+}
+
+func TestPublishVideoEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxLen   int
+		wantTrim []string
+	}{
+		{"capped", 1000, []string{"maxlen", "~", "1000"}},
+		{"uncapped", 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &EventsStreamMaxLen, tt.maxLen)
+			rdb := useRedis(t, func(cmd []string) any { return "1-0" })
+
+			videoID := uuid.New()
+			event := models.VideoEvent{
+				VideoID: videoID,
+				Status:  models.StatusCompleted,
+				Video:   &models.Video{ID: videoID, OriginalName: "clip.mp4", Duration: 12.5},
+			}
+			if err := PublishVideoEvent(context.Background(), event); err != nil {
+				t.Fatal(err)
+			}
+
+			adds := rdb.Named("XADD")
+			if len(adds) != 1 {
+				t.Fatalf("XADD calls = %q, want 1", adds)
+			}
+			cmd := adds[0]
+			if cmd[1] != VideoEventsStream {
+				t.Errorf("stream = %q, want %q", cmd[1], VideoEventsStream)
+			}
+			// Trimming options sit between the key and the entry id
+			if got := cmd[2 : 2+len(tt.wantTrim)]; !slices.Equal(got, tt.wantTrim) {
+				t.Errorf("trim args = %q, want %q", got, tt.wantTrim)
+			}
+
+			fields := map[string]string{}
+			rest := cmd[2+len(tt.wantTrim)+1:]
+			for i := 0; i+1 < len(rest); i += 2 {
+				fields[rest[i]] = rest[i+1]
+			}
+			if fields["video_id"] != videoID.String() || fields["status"] != "completed" {
+				t.Errorf("fields = %v", fields)
+			}
+			var decoded models.VideoEvent
+			if err := json.Unmarshal([]byte(fields["data"]), &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if decoded.Video == nil || decoded.Video.OriginalName != "clip.mp4" || decoded.Video.Duration != 12.5 {
+				t.Errorf("event summary = %+v, want the final video row", decoded.Video)
+			}
+		})
+	}
+}
+
+func TestPublishVideoEventError(t *testing.T) {
+	useRedis(t, func(cmd []string) any { return errors.New("ERR OOM") })
+	if err := PublishVideoEvent(context.Background(), models.VideoEvent{VideoID: uuid.New()}); err == nil {
+		t.Error("expected the XADD error to be returned")
+	}
+}
+
+func TestProcessMessageAcksAfterShutdown(t *testing.T) {
+	server := useRedis(t, func(cmd []string) any { return int64(1) })
+	job := models.VideoJob{VideoID: uuid.New()}
+	data, err := json.Marshal(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := redis.XMessage{ID: "1-0", Values: map[string]any{"video_id": job.VideoID.String(), "data": string(data)}}
+
+	// Shutdown arrives while the job is still draining
+	ctx, cancel := context.WithCancel(context.Background())
+	processMessage(ctx, message, func(models.VideoJob) error {
+		cancel()
+		return nil
+	})
+
+	acks := server.Named("XACK")
+	if len(acks) != 1 || acks[0][len(acks[0])-1] != "1-0" {
+		t.Errorf("acks = %q, want one for 1-0", acks)
+	}
+}
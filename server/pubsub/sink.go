@@ -0,0 +1,34 @@
+package pubsub
+
+import (
+	"errors"
+
+	"github.com/devrayat000/video-process/models"
+)
+
+// ProgressSink receives the progress events of a job
+type ProgressSink interface {
+	Publish(progress models.ProcessingProgress) error
+}
+
+// RedisProgressSink publishes to the progress channels and keys the API
+// serves from; see PublishProgress
+type RedisProgressSink struct{}
+
+func (RedisProgressSink) Publish(progress models.ProcessingProgress) error {
+	return PublishProgress(progress)
+}
+
+// ProgressSinks sends every event to each sink in order. A failing sink
+// doesn't stop the rest; the errors are joined.
+type ProgressSinks []ProgressSink
+
+func (sinks ProgressSinks) Publish(progress models.ProcessingProgress) error {
+	var errs []error
+	for _, sink := range sinks {
+		if err := sink.Publish(progress); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
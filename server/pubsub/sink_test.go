@@ -0,0 +1,89 @@
+package pubsub
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testredis"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+// captureSink records every event it is given and fails with err
+type captureSink struct {
+	events []models.ProcessingProgress
+	err    error
+}
+
+func (s *captureSink) Publish(progress models.ProcessingProgress) error {
+	s.events = append(s.events, progress)
+	return s.err
+}
+
+func TestProgressSinks(t *testing.T) {
+	errMetrics := errors.New("metrics unavailable")
+	errLogs := errors.New("log shipper down")
+
+	tests := []struct {
+		name    string
+		errs    []error
+		wantErr []error
+	}{
+		{"no sinks", nil, nil},
+		{"all succeed", []error{nil, nil}, nil},
+		{"one fails", []error{errMetrics, nil}, []error{errMetrics}},
+		{"both fail", []error{errMetrics, errLogs}, []error{errMetrics, errLogs}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sinks ProgressSinks
+			var captured []*captureSink
+			for _, err := range tt.errs {
+				sink := &captureSink{err: err}
+				sinks = append(sinks, sink)
+				captured = append(captured, sink)
+			}
+
+			event := models.ProcessingProgress{VideoID: uuid.New(), Status: models.StatusProcessing, ProcessedFrames: 12}
+			err := sinks.Publish(event)
+			if (err != nil) != (len(tt.wantErr) > 0) {
+				t.Fatalf("Publish error = %v, want %v", err, tt.wantErr)
+			}
+			for _, want := range tt.wantErr {
+				if !errors.Is(err, want) {
+					t.Errorf("Publish error = %v, want it to include %v", err, want)
+				}
+			}
+
+			// A failing sink doesn't keep the event from the others
+			for i, sink := range captured {
+				if len(sink.events) != 1 || sink.events[0] != event {
+					t.Errorf("sink %d got %+v, want the event once", i, sink.events)
+				}
+			}
+		})
+	}
+}
+
+func TestRedisProgressSink(t *testing.T) {
+	rdb := useRedis(t, func(cmd []string) any {
+		if cmd[0] == "publish" {
+			return 1
+		}
+		return testredis.Status("OK")
+	})
+
+	videoID := uuid.New()
+	var sink ProgressSink = RedisProgressSink{}
+	if err := sink.Publish(models.ProcessingProgress{VideoID: videoID, Status: models.StatusStarted}); err != nil {
+		t.Fatal(err)
+	}
+
+	publishes := rdb.Named("PUBLISH")
+	if len(publishes) != 2 || publishes[0][1] != ProgressChannel+videoID.String() || publishes[1][1] != ProgressAllChan {
+		t.Errorf("PUBLISH = %q, want the video's channel and the global one", publishes)
+	}
+	if sets := rdb.Named("SET"); len(sets) != 1 || sets[0][1] != ProgressKeyPrefix+videoID.String() {
+		t.Errorf("SET = %q, want the latest progress stored", sets)
+	}
+}
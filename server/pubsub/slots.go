@@ -0,0 +1,36 @@
+package pubsub
+
+import "context"
+
+// jobSlots caps the jobs a worker holds at once. A slot is taken before a
+// message is read or claimed, not after, so a saturated worker leaves new
+// and abandoned messages in Redis for workers that can start them now.
+type jobSlots chan struct{}
+
+func newJobSlots(n int) jobSlots {
+	return make(jobSlots, max(n, 1))
+}
+
+// acquire waits for a free slot and reports false if ctx ends first
+func (s jobSlots) acquire(ctx context.Context) bool {
+	select {
+	case s <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// tryAcquire takes a slot only if one is free right now
+func (s jobSlots) tryAcquire() bool {
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s jobSlots) release() {
+	<-s
+}
@@ -0,0 +1,168 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devrayat000/video-process/internal/testredis"
+	"github.com/devrayat000/video-process/models"
+	"github.com/google/uuid"
+)
+
+func TestJobSlots(t *testing.T) {
+	slots := newJobSlots(2)
+	if !slots.acquire(context.Background()) || !slots.tryAcquire() {
+		t.Fatal("free slots could not be taken")
+	}
+	if slots.tryAcquire() {
+		t.Fatal("took a third slot out of two")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if slots.acquire(ctx) {
+		t.Fatal("acquire returned a slot while saturated")
+	}
+
+	slots.release()
+	if !slots.tryAcquire() {
+		t.Error("released slot could not be taken again")
+	}
+
+	if got := cap(newJobSlots(0)); got != 1 {
+		t.Errorf("newJobSlots(0) has %d slots, want 1", got)
+	}
+}
+
+// countNamed returns how many commands the server saw with the given name
+func countNamed(server *testredis.Server, name string) int {
+	return len(server.Named(name))
+}
+
+func TestSaturatedWorkerStopsReading(t *testing.T) {
+	previous := WorkerConcurrency
+	WorkerConcurrency = 1
+	t.Cleanup(func() { WorkerConcurrency = previous })
+
+	var mu sync.Mutex
+	entries := [][]any{streamEntry(t, "1-0", models.VideoJob{VideoID: uuid.New()})}
+	server := useRedis(t, func(cmd []string) any {
+		switch cmd[0] {
+		case "xpending":
+			return []any{}
+		case "xautoclaim":
+			return []any{"0-0", []any{}, []any{}}
+		case "xreadgroup":
+			mu.Lock()
+			defer mu.Unlock()
+			if len(entries) == 0 {
+				time.Sleep(10 * time.Millisecond)
+				return []any(nil)
+			}
+			entry := entries[0]
+			entries = entries[1:]
+			return []any{[]any{VideoJobsStream, []any{entry}}}
+		case "xack":
+			return int64(1)
+		}
+		return testredis.Status("OK")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	handler := func(models.VideoJob) error {
+		close(started)
+		<-finish
+		return nil
+	}
+	result := make(chan error, 1)
+	go func() { result <- ConsumeJobs(ctx, handler) }()
+
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("job never started")
+	}
+
+	// With the only slot taken, nothing more is read or reclaimed
+	reads, claims := countNamed(server, "XREADGROUP"), countNamed(server, "XAUTOCLAIM")
+	time.Sleep(200 * time.Millisecond)
+	if got := countNamed(server, "XREADGROUP"); got != reads {
+		t.Errorf("saturated worker read %d more times", got-reads)
+	}
+	if got := countNamed(server, "XAUTOCLAIM"); got != claims {
+		t.Errorf("saturated worker reclaimed %d more times", got-claims)
+	}
+
+	// Freeing the slot resumes reading
+	close(finish)
+	deadline := time.Now().Add(10 * time.Second)
+	for countNamed(server, "XREADGROUP") == reads {
+		if time.Now().After(deadline) {
+			t.Fatal("worker did not read again after the slot was freed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-result:
+	case <-time.After(10 * time.Second):
+		t.Fatal("ConsumeJobs did not return after cancel")
+	}
+}
+
+func TestReclaimLoopClaimsPerFreeSlot(t *testing.T) {
+	previous := ReclaimInterval
+	ReclaimInterval = 0
+	t.Cleanup(func() { ReclaimInterval = previous })
+
+	var mu sync.Mutex
+	claimed := 0
+	server := useRedis(t, func(cmd []string) any {
+		switch cmd[0] {
+		case "xautoclaim":
+			mu.Lock()
+			defer mu.Unlock()
+			claimed++
+			id := fmt.Sprintf("%d-0", claimed)
+			return []any{id, []any{streamEntry(t, id, models.VideoJob{VideoID: uuid.New()})}, []any{}}
+		case "xack":
+			return int64(1)
+		}
+		return testredis.Status("OK")
+	})
+
+	var running sync.WaitGroup
+	running.Add(2)
+	finish := make(chan struct{})
+	handler := func(models.VideoJob) error {
+		running.Done()
+		<-finish
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	slots := newJobSlots(2)
+	reclaimLoop(context.Background(), slots, &wg, handler)
+
+	if !waitFor(&running) {
+		t.Fatal("reclaimed jobs did not start")
+	}
+	if got := countNamed(server, "XAUTOCLAIM"); got != 2 {
+		t.Errorf("claimed %d times with 2 free slots, want 2", got)
+	}
+	close(finish)
+	if !waitFor(&wg) {
+		t.Fatal("reclaimed jobs did not finish")
+	}
+	if got := countNamed(server, "XACK"); got != 2 {
+		t.Errorf("acked %d reclaimed jobs, want 2", got)
+	}
+}
@@ -0,0 +1,78 @@
+package server_utils
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+)
+
+// AttachmentDisposition builds a Content-Disposition header that makes
+// browsers download the response as name. The name is reduced to a bare
+// file name; an ASCII fallback goes in filename and the full UTF-8 name in
+// filename* (RFC 5987) when they differ.
+func AttachmentDisposition(name, fallback string) string {
+	name = SanitizeFileName(name)
+	if name == "" {
+		name = fallback
+	}
+
+	ascii := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII {
+			return '_'
+		}
+		return r
+	}, name)
+
+	header := fmt.Sprintf(`attachment; filename="%s"`, ascii)
+	if ascii != name {
+		header += "; filename*=UTF-8''" + encodeRFC5987(name)
+	}
+	return header
+}
+
+// SanitizeFileName drops directories and any character that could break out
+// of a quoted header value, leaving "" when nothing usable remains.
+func SanitizeFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsControl(r):
+			return -1
+		case r == '"' || r == ';':
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == "/" || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// encodeRFC5987 percent-encodes every byte outside RFC 5987's attr-char set
+func encodeRFC5987(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x80 && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// DownloadName replaces the extension of a video's original file name with
+// suffix, e.g. "holiday.mov" and "-720p.mp4" give "holiday-720p.mp4". It
+// returns "" when there is no usable original name.
+func DownloadName(originalName, suffix string) string {
+	stem := SanitizeFileName(originalName)
+	stem = strings.TrimSuffix(stem, path.Ext(stem))
+	if stem == "" {
+		return ""
+	}
+	return stem + suffix
+}
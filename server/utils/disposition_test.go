@@ -0,0 +1,47 @@
+package server_utils
+
+import "testing"
+
+func TestAttachmentDisposition(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		want     string
+	}{
+		{"ascii", "holiday-720p.mp4", `attachment; filename="holiday-720p.mp4"`},
+		{"unicode", "видео.zip", `attachment; filename="_____.zip"; filename*=UTF-8''%D0%B2%D0%B8%D0%B4%D0%B5%D0%BE.zip`},
+		{"accent and space", "Café trip.zip", `attachment; filename="Caf_ trip.zip"; filename*=UTF-8''Caf%C3%A9%20trip.zip`},
+		{"quotes and separators", `a"b;c.zip`, `attachment; filename="a_b_c.zip"`},
+		{"directories dropped", `../../etc\passwd`, `attachment; filename="passwd"`},
+		{"control characters dropped", "bad\r\nname.zip", `attachment; filename="badname.zip"`},
+		{"empty falls back", "", `attachment; filename="fallback.zip"`},
+		{"dots fall back", "..", `attachment; filename="fallback.zip"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AttachmentDisposition(tt.fileName, "fallback.zip"); got != tt.want {
+				t.Errorf("AttachmentDisposition(%q) = %s, want %s", tt.fileName, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadName(t *testing.T) {
+	tests := []struct {
+		originalName string
+		suffix       string
+		want         string
+	}{
+		{"holiday.mov", "-720p.mp4", "holiday-720p.mp4"},
+		{"clip", ".zip", "clip.zip"},
+		{"archive.tar.gz", ".zip", "archive.tar.zip"},
+		{"uploads/nested/holiday.mov", ".zip", "holiday.zip"},
+		{"", ".zip", ""},
+		{".mov", ".zip", ""},
+	}
+	for _, tt := range tests {
+		if got := DownloadName(tt.originalName, tt.suffix); got != tt.want {
+			t.Errorf("DownloadName(%q, %q) = %q, want %q", tt.originalName, tt.suffix, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package server_utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetEnvBool(t *testing.T) {
+	tests := []struct {
+		value        string
+		defaultValue bool
+		want         bool
+	}{
+		{"", false, false},
+		{"", true, true},
+		{"true", false, true},
+		{"1", false, true},
+		{"false", true, false},
+		{"0", true, false},
+		{"yes", true, true},
+		{"yes", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("TEST_BOOL", tt.value)
+			if got := GetEnvBool("TEST_BOOL", tt.defaultValue); got != tt.want {
+				t.Errorf("GetEnvBool(%q, %v) = %v, want %v", tt.value, tt.defaultValue, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetEnvDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 24 * time.Hour},
+		{"168h", 168 * time.Hour},
+		{"90m", 90 * time.Minute},
+		{"1h30m", 90 * time.Minute},
+		{"7d", 24 * time.Hour},
+		{"3600", 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("TEST_DURATION", tt.value)
+			if got := GetEnvDuration("TEST_DURATION", 24*time.Hour); got != tt.want {
+				t.Errorf("GetEnvDuration(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+// setVar overrides a package setting for the test
+func setVar[T any](t *testing.T, v *T, value T) {
+	t.Helper()
+	previous := *v
+	*v = value
+	t.Cleanup(func() { *v = previous })
+}
@@ -0,0 +1,70 @@
+package server_utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/devrayat000/video-process/models"
+)
+
+// Path to a JSON object of named rendition ladders, e.g. a mounted config map:
+// {"mobile": [{"height": 480, "bitrate": 1400, ...}], ...}
+var renditionProfilesFile = GetEnv("RENDITION_PROFILES_FILE", "")
+
+var (
+	profilesOnce sync.Once
+	profiles     map[string][]models.Rendition
+	profilesErr  error
+)
+
+// LoadRenditionProfiles reads and validates RENDITION_PROFILES_FILE once.
+// Without the setting there are no profiles and nothing fails.
+func LoadRenditionProfiles() error {
+	profilesOnce.Do(func() {
+		if renditionProfilesFile == "" {
+			return
+		}
+		data, err := os.ReadFile(renditionProfilesFile)
+		if err != nil {
+			profilesErr = fmt.Errorf("failed to read RENDITION_PROFILES_FILE: %w", err)
+			return
+		}
+		profiles, profilesErr = parseRenditionProfiles(data)
+	})
+	return profilesErr
+}
+
+func parseRenditionProfiles(data []byte) (map[string][]models.Rendition, error) {
+	var parsed map[string][]models.Rendition
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid rendition profiles: %w", err)
+	}
+	for name, ladder := range parsed {
+		if len(ladder) == 0 {
+			return nil, fmt.Errorf("rendition profile %q is empty", name)
+		}
+		for _, r := range ladder {
+			if r.Height <= 0 || r.Bitrate <= 0 {
+				return nil, fmt.Errorf("rendition profile %q needs a positive height and bitrate for every rendition", name)
+			}
+			if err := r.ValidateExtraArgs(); err != nil {
+				return nil, fmt.Errorf("rendition profile %q: %w", name, err)
+			}
+		}
+	}
+	return parsed, nil
+}
+
+// RenditionProfile returns the ladder configured under name
+func RenditionProfile(name string) ([]models.Rendition, error) {
+	if err := LoadRenditionProfiles(); err != nil {
+		return nil, err
+	}
+	ladder, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown rendition profile %q", name)
+	}
+	return ladder, nil
+}
@@ -0,0 +1,104 @@
+package server_utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/devrayat000/video-process/models"
+)
+
+// useProfilesFile points RENDITION_PROFILES_FILE at contents and forgets any
+// profiles loaded before. An empty contents leaves the setting unset.
+func useProfilesFile(t *testing.T, contents string) {
+	t.Helper()
+	path := ""
+	if contents != "" {
+		path = filepath.Join(t.TempDir(), "profiles.json")
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reset := func() {
+		profilesOnce = sync.Once{}
+		profiles, profilesErr = nil, nil
+	}
+	old := renditionProfilesFile
+	renditionProfilesFile = path
+	reset()
+	t.Cleanup(func() {
+		renditionProfilesFile = old
+		reset()
+	})
+}
+
+func TestParseRenditionProfiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string][]models.Rendition
+		wantErr bool
+	}{
+		{
+			name: "two profiles",
+			data: `{"mobile": [{"height": 480, "bitrate": 1400}], "tv": [{"height": 1080, "bitrate": 5000, "extra_args": ["-tune", "film"]}]}`,
+			want: map[string][]models.Rendition{
+				"mobile": {{Height: 480, Bitrate: 1400}},
+				"tv":     {{Height: 1080, Bitrate: 5000, ExtraArgs: []string{"-tune", "film"}}},
+			},
+		},
+		{name: "not json", data: `mobile: 480`, wantErr: true},
+		{name: "empty ladder", data: `{"mobile": []}`, wantErr: true},
+		{name: "missing bitrate", data: `{"mobile": [{"height": 480}]}`, wantErr: true},
+		{name: "negative height", data: `{"mobile": [{"height": -480, "bitrate": 1400}]}`, wantErr: true},
+		{name: "unsafe extra args", data: `{"mobile": [{"height": 480, "bitrate": 1400, "extra_args": ["-i", "/etc/passwd"]}]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRenditionProfiles([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRenditionProfiles error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRenditionProfiles = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenditionProfile(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		profile  string
+		want     []models.Rendition
+		wantErr  bool
+	}{
+		{"named profile", `{"mobile": [{"height": 480, "bitrate": 1400}]}`, "mobile", []models.Rendition{{Height: 480, Bitrate: 1400}}, false},
+		{"unknown profile", `{"mobile": [{"height": 480, "bitrate": 1400}]}`, "tv", nil, true},
+		{"no profiles configured", "", "mobile", nil, true},
+		{"invalid file", `{"mobile": []}`, "mobile", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useProfilesFile(t, tt.contents)
+			got, err := RenditionProfile(tt.profile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RenditionProfile(%q) error = %v, wantErr %v", tt.profile, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RenditionProfile(%q) = %+v, want %+v", tt.profile, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadRenditionProfilesMissingFile(t *testing.T) {
+	useProfilesFile(t, "")
+	renditionProfilesFile = filepath.Join(t.TempDir(), "missing.json")
+	if err := LoadRenditionProfiles(); err == nil {
+		t.Error("LoadRenditionProfiles succeeded with a missing file")
+	}
+}
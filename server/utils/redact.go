@@ -0,0 +1,24 @@
+package server_utils
+
+import "regexp"
+
+// Patterns for secrets that end up in log lines: signed URL parameters, URL
+// credentials, bearer tokens and key=value settings with telling names
+var secretPatterns = []struct {
+	re          *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)([?&](?:x-goog-signature|x-goog-credential|x-amz-signature|x-amz-credential|x-amz-security-token|signature|sig|token|access_token|key)=)[^&\s"']+`), "${1}REDACTED"},
+	{regexp.MustCompile(`(://)[^/\s:@]+:[^/\s@]+@`), "${1}REDACTED@"},
+	{regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`), "${1}REDACTED"},
+	{regexp.MustCompile(`(?i)\b((?:password|passwd|secret|api_key|apikey|token)\s*[=:]\s*)[^\s&"']+`), "${1}REDACTED"},
+}
+
+// RedactSecrets masks credentials in a line of text before it leaves the
+// process, e.g. a signed source URL in an FFmpeg error
+func RedactSecrets(line string) string {
+	for _, p := range secretPatterns {
+		line = p.re.ReplaceAllString(line, p.replacement)
+	}
+	return line
+}
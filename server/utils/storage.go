@@ -2,16 +2,40 @@ package server_utils
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
 
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
 )
 
 var (
 	endpoint = GetEnv("GCS_PUBLIC_ENDPOINT", "https://storage.googleapis.com")
 	bucket   = GetEnv("GCS_BUCKET_NAME", "")
+	// Optional API endpoint, e.g. a regional endpoint or a local emulator
+	apiEndpoint = GetEnv("GCS_ENDPOINT", "")
+	// Path-style public URLs ({endpoint}/{bucket}/{key}); false switches to
+	// virtual-hosted style ({bucket}.{endpoint host}/{key})
+	pathStyle = GetEnvBool("GCS_PATH_STYLE", true)
+	// Comma-separated buckets jobs may pick for their output (per tenant)
+	outputBuckets = GetEnv("OUTPUT_BUCKETS", "")
+	// Base for stored playback URLs when output is served through a CDN, e.g.
+	// "https://cdn.example.com"; "{bucket}" is replaced by the bucket name
+	publicBaseURL = GetEnv("PUBLIC_BASE_URL", "")
 )
 
+// SourceBuckets is the comma-separated SOURCE_BUCKETS: buckets besides
+// GCS_BUCKET_NAME jobs may read sources from. The worker signs reads and may
+// delete objects in them.
+var SourceBuckets = GetEnv("SOURCE_BUCKETS", "")
+
 // InitStorage initializes the Google Cloud Storage client and resolves the required
 // configuration (bucket name and public endpoint). It ensures the bucket is set
 // and returns the constructed client alongside the configuration struct.
@@ -20,10 +44,167 @@ func InitStorage(ctx context.Context) (*storage.Client, error) {
 		return nil, fmt.Errorf("GCS_BUCKET_NAME must be set")
 	}
 
-	client, err := storage.NewClient(ctx)
+	var opts []option.ClientOption
+	if apiEndpoint != "" {
+		opts = append(opts, option.WithEndpoint(apiEndpoint))
+	}
+
+	client, err := storage.NewClient(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize GCS client: %w", err)
 	}
 
 	return client, nil
 }
+
+// OutputBucket validates a job's requested output bucket against
+// OUTPUT_BUCKETS. An empty request means the default GCS_BUCKET_NAME.
+func OutputBucket(requested string) (string, error) {
+	if requested == "" || requested == bucket {
+		return bucket, nil
+	}
+	for _, allowed := range strings.Split(outputBuckets, ",") {
+		if strings.TrimSpace(allowed) == requested {
+			return requested, nil
+		}
+	}
+	return "", fmt.Errorf("output bucket %q is not allowed", requested)
+}
+
+// CheckSourceBucket rejects a source bucket other than GCS_BUCKET_NAME and
+// SOURCE_BUCKETS
+func CheckSourceBucket(name string) error {
+	if name == bucket {
+		return nil
+	}
+	for _, allowed := range strings.Split(SourceBuckets, ",") {
+		if strings.TrimSpace(allowed) == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("source bucket %q is not allowed", name)
+}
+
+// ParseObjectURL maps a source location back to a bucket and object key. It
+// understands gs:// URIs, public URLs built from GCS_PUBLIC_ENDPOINT and
+// Firebase download URLs; ok is false for anything else.
+func ParseObjectURL(raw string) (bucketName, key string, ok bool) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", false
+	}
+
+	if u.Scheme == "gs" {
+		key = strings.TrimPrefix(u.Path, "/")
+		return u.Host, key, u.Host != "" && key != ""
+	}
+
+	// Firebase: /v0/b/{bucket}/o/{escaped key}
+	if rest, found := strings.CutPrefix(u.Path, "/v0/b/"); found {
+		bucketName, key, found = strings.Cut(rest, "/o/")
+		return bucketName, key, found && bucketName != "" && key != ""
+	}
+
+	if base, err := url.Parse(endpoint); err == nil {
+		path := strings.TrimPrefix(strings.TrimPrefix(u.Path, base.Path), "/")
+		if u.Host == base.Host {
+			bucketName, key, found := strings.Cut(path, "/")
+			return bucketName, key, found && bucketName != "" && key != ""
+		}
+		// Virtual-hosted: {bucket}.{endpoint host}/{key}
+		if bucketName, found := strings.CutSuffix(u.Host, "."+base.Host); found {
+			return bucketName, path, bucketName != "" && path != ""
+		}
+	}
+
+	return "", "", false
+}
+
+// PublicObjectURL builds the public URL of an object. PUBLIC_BASE_URL wins
+// when set; otherwise the URL is built from GCS_PUBLIC_ENDPOINT, in path style
+// or virtual-hosted style depending on GCS_PATH_STYLE. Uploads always go
+// through the storage client and are unaffected.
+func PublicObjectURL(bucketName, key string) string {
+	parts := strings.Split(key, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	escapedKey := strings.Join(parts, "/")
+
+	if publicBaseURL != "" {
+		base := strings.ReplaceAll(publicBaseURL, "{bucket}", bucketName)
+		return fmt.Sprintf("%s/%s", strings.TrimSuffix(base, "/"), escapedKey)
+	}
+
+	if !pathStyle {
+		if base, err := url.Parse(endpoint); err == nil && base.Host != "" {
+			base.Host = bucketName + "." + base.Host
+			return fmt.Sprintf("%s/%s", strings.TrimSuffix(base.String(), "/"), escapedKey)
+		}
+	}
+
+	return fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(endpoint, "/"), bucketName, escapedKey)
+}
+
+// DeletePrefix removes every object whose name starts with prefix and returns
+// how many were deleted.
+func DeletePrefix(ctx context.Context, bucket *storage.BucketHandle, prefix string) (int, error) {
+	return DeletePrefixExcept(ctx, bucket, prefix, nil)
+}
+
+// DeletePrefixExcept is DeletePrefix, but keeps the objects named in keep
+func DeletePrefixExcept(ctx context.Context, bucket *storage.BucketHandle, prefix string, keep []string) (int, error) {
+	it := bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	deleted := 0
+
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return deleted, fmt.Errorf("failed to list objects under %s: %w", prefix, err)
+		}
+		if slices.Contains(keep, attrs.Name) {
+			continue
+		}
+
+		err = bucket.Object(attrs.Name).Delete(ctx)
+		if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			return deleted, fmt.Errorf("failed to delete %s: %w", attrs.Name, err)
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// StatObject returns an object's attributes, or nil when it doesn't exist.
+// A missing object is not an error.
+func StatObject(ctx context.Context, bucket *storage.BucketHandle, key string) (*storage.ObjectAttrs, error) {
+	attrs, err := bucket.Object(key).Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", key, err)
+	}
+	return attrs, nil
+}
+
+// RollupChecksum folds the MD5s of a rendition's segments, keyed by file
+// name, into a single SHA-256 so the whole set can be verified against one
+// stored value. The result doesn't depend on map iteration order.
+func RollupChecksum(segmentMD5s map[string][]byte) string {
+	names := make([]string, 0, len(segmentMD5s))
+	for name := range segmentMD5s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s:%x\n", name, segmentMD5s[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,257 @@
+package server_utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"slices"
+	"testing"
+
+	"github.com/devrayat000/video-process/internal/testgcs"
+)
+
+func TestParseObjectURL(t *testing.T) {
+	tests := []struct {
+		raw        string
+		wantBucket string
+		wantKey    string
+		wantOK     bool
+	}{
+		{"gs://videos/uploads/a.mp4", "videos", "uploads/a.mp4", true},
+		{"https://storage.googleapis.com/videos/uploads/a.mp4", "videos", "uploads/a.mp4", true},
+		{"https://firebasestorage.googleapis.com/v0/b/app.appspot.com/o/uploads%2Fa.mp4?alt=media", "app.appspot.com", "uploads/a.mp4", true},
+		{"gs://videos", "", "", false},
+		{"https://storage.googleapis.com/videos", "", "", false},
+		{"https://videos.storage.googleapis.com/uploads/a.mp4", "videos", "uploads/a.mp4", true},
+		{"https://videos.storage.googleapis.com/", "", "", false},
+		{"https://example.com/videos/a.mp4", "", "", false},
+		{"not a url\x7f", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			bucketName, key, ok := ParseObjectURL(tt.raw)
+			if ok != tt.wantOK || (ok && (bucketName != tt.wantBucket || key != tt.wantKey)) {
+				t.Errorf("ParseObjectURL(%q) = %q, %q, %v; want %q, %q, %v",
+					tt.raw, bucketName, key, ok, tt.wantBucket, tt.wantKey, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestPublicObjectURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		endpoint  string
+		pathStyle bool
+		key       string
+		want      string
+	}{
+		{"path style", "https://storage.googleapis.com", true, "a/master.m3u8", "https://storage.googleapis.com/videos/a/master.m3u8"},
+		{"path style with trailing slash", "https://cdn.example.com/", true, "a/master.m3u8", "https://cdn.example.com/videos/a/master.m3u8"},
+		{"path style escapes each segment", "https://storage.googleapis.com", true, "a/my clip#1.mp4", "https://storage.googleapis.com/videos/a/my%20clip%231.mp4"},
+		{"virtual-hosted", "https://storage.googleapis.com", false, "a/master.m3u8", "https://videos.storage.googleapis.com/a/master.m3u8"},
+		{"virtual-hosted regional endpoint", "https://s3.eu-central-1.wasabisys.com", false, "a/seg 1.ts", "https://videos.s3.eu-central-1.wasabisys.com/a/seg%201.ts"},
+		{"virtual-hosted needs a host", "cdn", false, "a/master.m3u8", "cdn/videos/a/master.m3u8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &endpoint, tt.endpoint)
+			setVar(t, &pathStyle, tt.pathStyle)
+			got := PublicObjectURL("videos", tt.key)
+			if got != tt.want {
+				t.Errorf("PublicObjectURL = %q, want %q", got, tt.want)
+			}
+
+			// Every URL we build must map back to its object
+			if b, key, ok := ParseObjectURL(got); tt.endpoint != "cdn" && (!ok || b != "videos" || key != tt.key) {
+				t.Errorf("ParseObjectURL(%q) = %q, %q, %v", got, b, key, ok)
+			}
+		})
+	}
+}
+
+func TestPublicObjectURLWithPublicBase(t *testing.T) {
+	// The storage endpoint stays the upload target; only playback URLs move
+	setVar(t, &endpoint, "https://storage.googleapis.com")
+
+	tests := []struct {
+		name      string
+		base      string
+		pathStyle bool
+		key       string
+		want      string
+	}{
+		{"cdn origin is the bucket", "https://cdn.example.com", true, "a/master.m3u8", "https://cdn.example.com/a/master.m3u8"},
+		{"trailing slash", "https://cdn.example.com/", true, "a/master.m3u8", "https://cdn.example.com/a/master.m3u8"},
+		{"base with a path", "https://example.com/media", true, "a/stream_0/playlist.m3u8", "https://example.com/media/a/stream_0/playlist.m3u8"},
+		{"bucket placeholder", "https://{bucket}.cdn.example.com", true, "a/master.m3u8", "https://videos.cdn.example.com/a/master.m3u8"},
+		{"escapes each segment", "https://cdn.example.com", true, "a/my clip#1.mp4", "https://cdn.example.com/a/my%20clip%231.mp4"},
+		{"ignores GCS_PATH_STYLE", "https://cdn.example.com", false, "a/master.m3u8", "https://cdn.example.com/a/master.m3u8"},
+		{"unset falls back to the endpoint", "", true, "a/master.m3u8", "https://storage.googleapis.com/videos/a/master.m3u8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &publicBaseURL, tt.base)
+			setVar(t, &pathStyle, tt.pathStyle)
+			if got := PublicObjectURL("videos", tt.key); got != tt.want {
+				t.Errorf("PublicObjectURL = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitStorageRequiresBucket(t *testing.T) {
+	setVar(t, &bucket, "")
+	if _, err := InitStorage(context.Background()); err == nil {
+		t.Error("expected an error without GCS_BUCKET_NAME")
+	}
+}
+
+func TestOutputBucket(t *testing.T) {
+	setVar(t, &bucket, "videos")
+	setVar(t, &outputBuckets, "tenant-a, tenant-b")
+
+	tests := []struct {
+		requested string
+		want      string
+		wantErr   bool
+	}{
+		{requested: "", want: "videos"},
+		{requested: "videos", want: "videos"},
+		{requested: "tenant-a", want: "tenant-a"},
+		{requested: "tenant-b", want: "tenant-b"},
+		{requested: "tenant-c", wantErr: true},
+		{requested: "tenant", wantErr: true},
+		{requested: " tenant-a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.requested, func(t *testing.T) {
+			got, err := OutputBucket(tt.requested)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("OutputBucket(%q) error = %v, wantErr %v", tt.requested, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("OutputBucket(%q) = %q, want %q", tt.requested, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutputBucketWithoutAllowlist(t *testing.T) {
+	setVar(t, &bucket, "videos")
+	setVar(t, &outputBuckets, "")
+
+	if _, err := OutputBucket("tenant-a"); err == nil {
+		t.Error("tenant bucket accepted with an empty OUTPUT_BUCKETS")
+	}
+	if _, err := OutputBucket(""); err != nil {
+		t.Errorf("default bucket rejected: %v", err)
+	}
+}
+
+func TestCheckSourceBucket(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed string
+		source  string
+		wantErr bool
+	}{
+		{"default bucket", "", "videos", false},
+		{"listed", "uploads, tenant-a", "tenant-a", false},
+		{"first listed", "uploads,tenant-a", "uploads", false},
+		{"not listed", "uploads, tenant-a", "tenant-b", true},
+		{"prefix of a listed bucket", "uploads", "upload", true},
+		{"empty list", "", "uploads", true},
+		{"empty name", "uploads", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setVar(t, &bucket, "videos")
+			setVar(t, &SourceBuckets, tt.allowed)
+			if err := CheckSourceBucket(tt.source); (err != nil) != tt.wantErr {
+				t.Errorf("CheckSourceBucket(%q) with SOURCE_BUCKETS=%q error = %v, wantErr %v", tt.source, tt.allowed, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeletePrefixExcept(t *testing.T) {
+	tests := []struct {
+		name        string
+		keep        []string
+		wantDeleted int
+		wantLeft    []string
+	}{
+		{"everything", nil, 3, []string{"b/seg0.ts"}},
+		{"keep the log", []string{"a/processed/ffmpeg.log"}, 2, []string{"a/processed/ffmpeg.log", "b/seg0.ts"}},
+		{"keep outside the prefix", []string{"b/seg0.ts"}, 3, []string{"b/seg0.ts"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, store := testgcs.Start(t)
+			for _, name := range []string{"a/processed/ffmpeg.log", "a/processed/stream_0/seg0.ts", "a/processed/master.m3u8", "b/seg0.ts"} {
+				store.Put("videos", name, []byte("x"))
+			}
+
+			deleted, err := DeletePrefixExcept(context.Background(), client.Bucket("videos"), "a/processed/", tt.keep)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted != tt.wantDeleted {
+				t.Errorf("deleted %d objects, want %d", deleted, tt.wantDeleted)
+			}
+			if left := store.Names("videos"); !slices.Equal(left, tt.wantLeft) {
+				t.Errorf("left %q, want %q", left, tt.wantLeft)
+			}
+		})
+	}
+}
+
+func TestStatObject(t *testing.T) {
+	client, store := testgcs.Start(t)
+	store.Put("videos", "a/seg0.ts", []byte("segment"))
+	bucket := client.Bucket("videos")
+
+	attrs, err := StatObject(context.Background(), bucket, "a/seg0.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := md5.Sum([]byte("segment"))
+	if attrs == nil || attrs.Size != 7 || !bytes.Equal(attrs.MD5, sum[:]) {
+		t.Errorf("StatObject = %+v, want size 7 and MD5 %x", attrs, sum)
+	}
+
+	attrs, err = StatObject(context.Background(), bucket, "a/seg1.ts")
+	if err != nil || attrs != nil {
+		t.Errorf("StatObject of a missing object = %+v, %v; want nil, nil", attrs, err)
+	}
+}
+
+func TestRollupChecksum(t *testing.T) {
+	base := map[string][]byte{
+		"segment_000.ts": {0x01, 0x02},
+		"segment_001.ts": {0x03, 0x04},
+	}
+
+	tests := []struct {
+		name     string
+		segments map[string][]byte
+		wantSame bool
+	}{
+		{"same set", map[string][]byte{"segment_001.ts": {0x03, 0x04}, "segment_000.ts": {0x01, 0x02}}, true},
+		{"corrupted segment", map[string][]byte{"segment_000.ts": {0x01, 0x02}, "segment_001.ts": {0x03, 0xff}}, false},
+		{"missing segment", map[string][]byte{"segment_000.ts": {0x01, 0x02}}, false},
+		{"renamed segment", map[string][]byte{"segment_000.ts": {0x01, 0x02}, "segment_002.ts": {0x03, 0x04}}, false},
+	}
+	want := RollupChecksum(base)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Map iteration order varies between runs, so repeat a few times
+			for range 5 {
+				if got := RollupChecksum(tt.segments); (got == want) != tt.wantSame {
+					t.Fatalf("RollupChecksum = %s, base %s, want same %v", got, want, tt.wantSame)
+				}
+			}
+		})
+	}
+}